@@ -2,13 +2,22 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"os/user"
 	"simplewallet/config"
+	"simplewallet/controller"
+	"simplewallet/job"
 	"simplewallet/router"
+	"simplewallet/service"
+	"simplewallet/util"
 	"simplewallet/util/db"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -22,16 +31,72 @@ func Init() {
 	if err != nil {
 		panic(err)
 	}
+	if config.Config.Db.AutoMigrate {
+		for shard, shardCli := range db.GetShardClients() {
+			migrator, err := db.NewMigrator(shardCli, db.GetDbDriver())
+			if err != nil {
+				panic(err)
+			}
+			applied, err := migrator.Up(context.Background())
+			if err != nil {
+				panic(err)
+			}
+			for _, m := range applied {
+				log.Printf("migration %d_%s applied on shard %d\n", m.Version, m.Name, shard)
+			}
+		}
+	}
 	err = db.InitRedis(&config.Config.Redis)
 	if err != nil {
 		panic(err)
 	}
+	// a user who wrote through any instance reads their writes from the primary on every instance
+	db.GetReplicaPool().WithRedis(db.GetRedisClient())
+	// writes on any instance reach the streams of every instance
+	service.SetEventBus(service.NewRedisBus(context.Background(), db.GetRedisClient()))
+	controller.SetAdminConf(&config.Config.Admin)
 }
 func main() {
+	switch flag.Arg(0) {
+	case "migrate":
+		os.Exit(Migrate(flag.Args()[1:]))
+	case "reconcile":
+		os.Exit(Reconcile(flag.Args()[1:]))
+	case "chain":
+		os.Exit(Chain(flag.Args()[1:]))
+	}
 
 	Init()
 
-	engine := router.InitRouter()
+	if config.Config.Interest.Enable {
+		job.NewInterestJob(&config.Config.Interest).Start(context.Background())
+	}
+	if config.Config.Approval.Enable {
+		job.NewApprovalJob(&config.Config.Approval).Start(context.Background())
+	}
+	if config.Config.ShardTransfer.Enable {
+		job.NewShardTransferJob(&config.Config.ShardTransfer).Start(context.Background())
+	}
+	if config.Config.Archive.Enable {
+		job.NewArchiveJob(&config.Config.Archive).Start(context.Background())
+	}
+	if config.Config.Outbox.Enable {
+		job.NewOutboxJob(&config.Config.Outbox, config.Config.Webhook.Enable).Start(context.Background())
+	}
+	if config.Config.Webhook.Enable {
+		if !config.Config.Outbox.Enable {
+			log.Println("webhook.enable without outbox.enable: no event reaches the webhook subscriptions")
+		}
+		job.NewWebhookJob(&config.Config.Webhook).Start(context.Background())
+	}
+	if config.Config.Chain.Enable {
+		job.NewChainJob(&config.Config.Chain).Start(context.Background())
+	}
+
+	engine, err := router.InitRouter(config.Config.TrustedProxies, controller.NewWalletController(config.Config.Approval.Enable))
+	if err != nil {
+		log.Fatal(err)
+	}
 	addr := config.Config.GinHost
 	server := &http.Server{
 		Addr:         addr,
@@ -40,6 +105,7 @@ func main() {
 		WriteTimeout: 10 * time.Second,
 	}
 
+	server.RegisterOnShutdown(controller.StopStreams)
 	SignalHandler(server)
 
 	if err := server.ListenAndServe(); err != nil {
@@ -48,6 +114,207 @@ func main() {
 	}
 }
 
+// Migrate runs `migrate up|down [n]|status` on the configured db, returns the exit code.
+func Migrate(args []string) int {
+	if len(args) == 0 {
+		fmt.Println("usage: simplewallet [-conf=./conf.yaml] migrate up|down [n]|status")
+		return 2
+	}
+	err := db.InitDb(&config.Config.Db)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	shardClis := db.GetShardClients()
+	for _, shardCli := range shardClis {
+		defer shardCli.Close()
+	}
+	for shard, shardCli := range shardClis {
+		if len(shardClis) > 1 {
+			fmt.Printf("shard %d:\n", shard)
+		}
+		if code := migrateShard(shardCli, args); code != 0 {
+			return code
+		}
+	}
+	return 0
+}
+
+// migrateShard runs the migrate command on the db of one shard
+func migrateShard(shardCli *sql.DB, args []string) int {
+	migrator, err := db.NewMigrator(shardCli, db.GetDbDriver())
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			fmt.Printf("applied  %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+	case "down":
+		n := 1
+		if len(args) > 1 {
+			n, err = strconv.Atoi(args[1])
+			if err != nil || n <= 0 {
+				fmt.Println("migrate down takes a positive number of migrations")
+				return 2
+			}
+		}
+		reverted, err := migrator.Down(ctx, n)
+		for _, m := range reverted {
+			fmt.Printf("reverted %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+	case "status":
+		list, err := migrator.Status(ctx)
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+		for _, m := range list {
+			applied := "pending"
+			if m.AppliedAt > 0 {
+				applied = "applied at " + time.Unix(m.AppliedAt, 0).Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", m.Version, m.Name, applied)
+		}
+	default:
+		fmt.Println("unknown migrate command " + args[0])
+		return 2
+	}
+	return 0
+}
+
+// Reconcile runs `reconcile [-fix] [-batch n]` on the configured db, returns the exit code:
+// 1 when a wallet drifted and was not fixed or a shard could not be read.
+func Reconcile(args []string) int {
+	flags := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	fix := flags.Bool("fix", false, "set each drifted balance to the one rebuilt from the transactions")
+	batch := flags.Int("batch", int(service.ReconcileBatch), "wallets read at a time")
+	if err := flags.Parse(args); err != nil || flags.NArg() > 0 || *batch <= 0 {
+		fmt.Println("usage: simplewallet [-conf=./conf.yaml] reconcile [-fix] [-batch=500]")
+		return 2
+	}
+	err := db.InitDb(&config.Config.Db)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	for _, shardCli := range db.GetShardClients() {
+		defer shardCli.Close()
+	}
+	ctx := context.Background()
+	if *fix {
+		// the fixed balances leave the cache and reach the streams of the running instances
+		if err = db.InitRedis(&config.Config.Redis); err != nil {
+			log.Println(err)
+			return 1
+		}
+		bus := service.NewRedisBus(ctx, db.GetRedisClient())
+		defer bus.Close()
+		service.SetEventBus(bus)
+	}
+
+	// the fixes are recorded in the audit trail as done by the operator running the command
+	operator := "cli"
+	if u, err := user.Current(); err == nil {
+		operator = "cli:" + u.Username
+	}
+	actor := &service.AuditActor{Name: operator, LogID: "reconcile"}
+	result, err := service.NewReconcileService(ctx, "reconcile", db.GetDbClient(), int32(*batch), *fix).WithAuditActor(actor).Run(func(drift *service.BalanceDrift) {
+		status := "drifted"
+		if drift.Fixed {
+			status = "fixed"
+		} else if drift.Err != nil {
+			status = "not fixed: " + drift.Err.Error()
+		}
+		fmt.Printf("shard %d wallet %d user %d org %d %s\tbalance %.8f\ttransactions %.8f\tdrift %.8f\t%s\n", drift.Shard, drift.Wallet.ID,
+			drift.Wallet.UserID, drift.Wallet.OrgID, drift.Wallet.Name, drift.Wallet.Balance, drift.Ledger, drift.Drift(), status)
+	})
+	fmt.Printf("%d wallets checked, %d drifted, %d fixed\n", result.Checked, result.Drifted, result.Fixed)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	if result.Drifted > result.Fixed {
+		return 1
+	}
+	return 0
+}
+
+// Chain runs `chain verify [-anchors=file] [-batch n]` or `chain anchor` on the configured db, returns the exit code:
+// 1 when a chain is broken, the anchor file was altered or a shard could not be read.
+func Chain(args []string) int {
+	usage := "usage: simplewallet [-conf=./conf.yaml] chain verify [-anchors=./chain_anchors.jsonl] [-batch=500] | anchor"
+	if len(args) == 0 || (args[0] != "verify" && args[0] != "anchor") {
+		fmt.Println(usage)
+		return 2
+	}
+	flags := flag.NewFlagSet("chain "+args[0], flag.ContinueOnError)
+	anchorFile := flags.String("anchors", "", "anchor file whose heads must still be on the chains")
+	batch := flags.Int("batch", int(service.ChainBatch), "wallets, or transactions of a wallet, read at a time")
+	if err := flags.Parse(args[1:]); err != nil || flags.NArg() > 0 || *batch <= 0 || (args[0] == "anchor" && *anchorFile != "") {
+		fmt.Println(usage)
+		return 2
+	}
+	err := db.InitDb(&config.Config.Db)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	for _, shardCli := range db.GetShardClients() {
+		defer shardCli.Close()
+	}
+	ctx := context.Background()
+
+	if args[0] == "anchor" {
+		// the same lock as the chain job of the running instances
+		if err = db.InitRedis(&config.Config.Redis); err != nil {
+			log.Println(err)
+			return 1
+		}
+		locker := util.NewDistributedLock(ctx, db.GetRedisClient(), "chain", "chain:anchor", 300)
+		n, err := service.NewChainService(ctx, "chain", db.GetDbClient(), int32(*batch), locker).Anchor(service.NewAnchorIndex(config.Config.Chain.AnchorFile), time.Now())
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+		fmt.Printf("%d chain heads anchored to %s\n", n, config.Config.Chain.AnchorFile)
+		return 0
+	}
+
+	var anchors []*service.ChainAnchor
+	if *anchorFile != "" {
+		if anchors, err = service.LoadAnchors(*anchorFile); err != nil {
+			log.Println(err)
+			return 1
+		}
+	}
+	result, err := service.NewChainService(ctx, "chain", db.GetDbClient(), int32(*batch), nil).Verify(anchors, func(brk *service.ChainBreak) {
+		fmt.Printf("shard %d wallet %d transaction %d\t%s\n", brk.Shard, brk.WalletID, brk.TxID, brk.Reason)
+	})
+	fmt.Printf("%d wallets verified, %d transactions chained, %d recorded before the chains, %d broken\n",
+		result.Wallets, result.Transactions, result.Unchained, result.Broken)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	if result.Broken > 0 {
+		return 1
+	}
+	return 0
+}
+
 func SignalHandler(server *http.Server) {
 	logID := ""
 	c := make(chan os.Signal, 2)
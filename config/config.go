@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"simplewallet/controller"
 	"simplewallet/util/db"
 
 	"gopkg.in/yaml.v2"
@@ -12,10 +13,19 @@ import (
 var Config Conf
 
 type Conf struct {
-	Env     string       `yaml:"env"`
-	GinHost string       `yaml:"gin_host"`
-	Db      db.DbConf    `yaml:"db"`
-	Redis   db.RedisConf `yaml:"redis"`
+	Env            string               `yaml:"env"`
+	GinHost        string               `yaml:"gin_host"`
+	TrustedProxies []string             `yaml:"trusted_proxies"` // the proxies whose X-Forwarded-For is trusted, none when empty
+	Db             db.DbConf            `yaml:"db"`
+	Redis          db.RedisConf         `yaml:"redis"`
+	Interest       InterestConf         `yaml:"interest"`
+	Approval       ApprovalConf         `yaml:"approval"`
+	ShardTransfer  ShardTransferConf    `yaml:"shard_transfer"`
+	Archive        ArchiveConf          `yaml:"archive"`
+	Outbox         OutboxConf           `yaml:"outbox"`
+	Webhook        WebhookConf          `yaml:"webhook"`
+	Chain          ChainConf            `yaml:"chain"`
+	Admin          controller.AdminConf `yaml:"admin"`
 }
 
 var gConfigName string
@@ -0,0 +1,55 @@
+package config
+
+// the confs of the background jobs, read by package job
+
+type ApprovalConf struct {
+	Enable         bool `yaml:"enable" json:"enable"`
+	IntervalSecond int  `yaml:"interval_second" json:"interval_second"`
+}
+
+type ArchiveConf struct {
+	Enable         bool `yaml:"enable" json:"enable"`
+	IntervalSecond int  `yaml:"interval_second" json:"interval_second"`
+	TimeoutSecond  int  `yaml:"timeout_second" json:"timeout_second"` // each db transaction on a shard, instead of the db timeouts
+}
+
+type ChainConf struct {
+	Enable         bool   `yaml:"enable" json:"enable"`
+	IntervalSecond int    `yaml:"interval_second" json:"interval_second"`
+	AnchorFile     string `yaml:"anchor_file" json:"anchor_file"`
+	BatchSize      int32  `yaml:"batch_size" json:"batch_size"`
+}
+
+type InterestConf struct {
+	Enable         bool               `yaml:"enable" json:"enable"`
+	IntervalSecond int                `yaml:"interval_second" json:"interval_second"`
+	Products       map[string]float64 `yaml:"products" json:"products"` // wallet product -> annual rate, 0.02 means 2%
+}
+
+type OutboxConf struct {
+	Enable         bool   `yaml:"enable" json:"enable"`
+	IntervalSecond int    `yaml:"interval_second" json:"interval_second"`
+	BatchSize      int32  `yaml:"batch_size" json:"batch_size"`
+	Publisher      string `yaml:"publisher" json:"publisher"` // stdout, file or http
+	FilePath       string `yaml:"file_path" json:"file_path"`
+	URL            string `yaml:"url" json:"url"`
+	TimeoutMs      int    `yaml:"timeout_ms" json:"timeout_ms"`
+}
+
+type ShardTransferConf struct {
+	Enable         bool  `yaml:"enable" json:"enable"`
+	IntervalSecond int   `yaml:"interval_second" json:"interval_second"`
+	MaxAttempts    int32 `yaml:"max_attempts" json:"max_attempts"` // failed credits before a transfer is refunded
+}
+
+type WebhookConf struct {
+	Enable          bool  `yaml:"enable" json:"enable"`
+	IntervalSecond  int   `yaml:"interval_second" json:"interval_second"`
+	BatchSize       int32 `yaml:"batch_size" json:"batch_size"`
+	TimeoutMs       int   `yaml:"timeout_ms" json:"timeout_ms"`
+	MaxAttempts     int32 `yaml:"max_attempts" json:"max_attempts"`           // posts before a delivery is dead
+	BaseDelaySecond int   `yaml:"base_delay_second" json:"base_delay_second"` // wait after the first failure, doubled on each next one
+	MaxDelaySecond  int   `yaml:"max_delay_second" json:"max_delay_second"`
+	// posts to loopback, private and link-local addresses, refused unless allowed
+	AllowPrivateTargets bool `yaml:"allow_private_targets" json:"allow_private_targets"`
+}
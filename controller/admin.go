@@ -0,0 +1,211 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"simplewallet/controller/validator"
+	"simplewallet/data"
+	"simplewallet/service"
+	"simplewallet/util"
+	"simplewallet/util/db"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminConf lists the admins allowed on the admin routes, none when empty
+type AdminConf struct {
+	Admins []AdminAccount `yaml:"admins"`
+}
+
+type AdminAccount struct {
+	Name  string `yaml:"name"`  // the actor of the audit records
+	Token string `yaml:"token"` // sent as Authorization: Bearer <token>
+}
+
+var adminConf = &AdminConf{}
+
+// SetAdminConf sets the admins of the admin routes, set by main on start
+func SetAdminConf(conf *AdminConf) {
+	adminConf = conf
+}
+
+// keys of the gin context set by AdminAudit
+const (
+	ctxKeyLogID = "log_id"
+	ctxKeyActor = "audit_actor"
+)
+
+// AdminAudit authenticates the admin of a request to the admin routes and hands the handlers the actor its
+// actions are recorded under in the audit trail, with the log id and source ip of the request.
+func AdminAudit() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		logID := util.Uniqid()
+		name, ok := authAdmin(ctx.GetHeader("Authorization"))
+		if !ok {
+			log.Printf("%s|admin request unauthorized:%s %s from %s\n", logID, ctx.Request.Method, ctx.Request.URL.Path, ctx.ClientIP())
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		ctx.Set(ctxKeyLogID, logID)
+		ctx.Set(ctxKeyActor, &service.AuditActor{Name: name, LogID: logID, SourceIP: ctx.ClientIP()})
+		start := time.Now()
+		ctx.Next()
+		log.Printf("%s|admin %s %s %s from %s:%d %s\n", logID, name, ctx.Request.Method, ctx.Request.URL.Path, ctx.ClientIP(),
+			ctx.Writer.Status(), time.Since(start).String())
+	}
+}
+
+// authAdmin returns the admin whose token is the bearer token of the header
+func authAdmin(header string) (string, bool) {
+	token, found := strings.CutPrefix(header, "Bearer ")
+	if !found || token == "" {
+		return "", false
+	}
+	name := ""
+	for _, admin := range adminConf.Admins {
+		// every token compared, in constant time
+		if admin.Token != "" && subtle.ConstantTimeCompare([]byte(admin.Token), []byte(token)) == 1 {
+			name = admin.Name
+		}
+	}
+	return name, name != ""
+}
+
+// adminActor returns the log id and the actor AdminAudit set on the request
+func adminActor(ctx *gin.Context) (string, *service.AuditActor) {
+	actor, _ := ctx.MustGet(ctxKeyActor).(*service.AuditActor)
+	return ctx.GetString(ctxKeyLogID), actor
+}
+
+func (w *WalletController) GetAuditLogList(ctx *gin.Context) {
+	logID, _ := adminActor(ctx)
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("%s|panic:%v\n", logID, p)
+		}
+	}()
+	limit, err := w.GetParamLimit(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req := &data.GetAuditLogListReq{Actor: ctx.Query("actor"), Action: ctx.Query("action"), TargetType: ctx.Query("target_type"),
+		Cursor: ctx.Query("cursor"), Limit: limit}
+	for key, value := range map[string]*int64{"target_id": &req.TargetID, "start_time": &req.StartTime, "end_time": &req.EndTime} {
+		if str := ctx.Query(key); str != "" {
+			if *value, err = strconv.ParseInt(str, 10, 64); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+	}
+	if err := validator.NewValidatorSvc().ValidatorGetAuditLogListReq(req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	dbCli := db.GetDbClient()
+	s := service.NewWalletService(ctx, logID, dbCli, nil)
+	rsp, err := s.GetAuditLogList(req)
+	if err != nil {
+		log.Printf("%s|fail to get audit log list:%s\n", logID, err.Error())
+	}
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+func (w *WalletController) Adjust(ctx *gin.Context) {
+	logID, actor := adminActor(ctx)
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("%s|panic:%v\n", logID, p)
+		}
+	}()
+	var req data.AdjustReq
+	if err := ctx.BindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validator.NewValidatorSvc().ValidatorAdjustReq(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	dbCli := db.GetDbClient()
+	locker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, "adjust:"+req.OrderID, 5)
+	s := service.NewWalletService(ctx, logID, dbCli, locker).WithAuditActor(actor)
+	rsp, err := s.Adjust(&req)
+	if err != nil {
+		log.Printf("%s|fail to adjust:%s\n", logID, err.Error())
+	}
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+func (w *WalletController) ApproveAdjustment(ctx *gin.Context) {
+	w.reviewAdjustment(ctx, true)
+}
+
+func (w *WalletController) RejectAdjustment(ctx *gin.Context) {
+	w.reviewAdjustment(ctx, false)
+}
+
+func (w *WalletController) reviewAdjustment(ctx *gin.Context, approve bool) {
+	logID, actor := adminActor(ctx)
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("%s|panic:%v\n", logID, p)
+		}
+	}()
+	var req data.ReviewAdjustmentReq
+	if err := ctx.BindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validator.NewValidatorSvc().ValidatorReviewAdjustmentReq(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	dbCli := db.GetDbClient()
+	locker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, "adjust:"+req.OrderID, 5)
+	s := service.NewWalletService(ctx, logID, dbCli, locker).WithAuditActor(actor)
+	review := s.RejectAdjustment
+	if approve {
+		review = s.ApproveAdjustment
+	}
+	rsp, err := review(&req)
+	if err != nil {
+		log.Printf("%s|fail to review adjustment:%s\n", logID, err.Error())
+	}
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+func (w *WalletController) SetWalletTerms(ctx *gin.Context) {
+	logID, actor := adminActor(ctx)
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("%s|panic:%v\n", logID, p)
+		}
+	}()
+	var req data.SetWalletTermsReq
+	if err := ctx.BindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validator.NewValidatorSvc().ValidatorSetWalletTermsReq(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	dbCli := db.GetDbClient()
+	lockKey := "terms:" + strconv.FormatInt(req.UserID, 10) + ":" + strconv.FormatInt(req.OrgID, 10) + ":" + req.Pocket
+	locker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, lockKey, 5)
+	s := service.NewWalletService(ctx, logID, dbCli, locker).WithAuditActor(actor)
+	rsp, err := s.SetWalletTerms(&req)
+	if err != nil {
+		log.Printf("%s|fail to set wallet terms:%s\n", logID, err.Error())
+	}
+	ctx.JSON(http.StatusOK, rsp)
+}
@@ -1,7 +1,11 @@
 package controller
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"simplewallet/controller/validator"
@@ -10,15 +14,18 @@ import (
 	"simplewallet/util"
 	"simplewallet/util/db"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 type WalletController struct {
+	approvals bool // transfers above the spending limit wait for approvals, approval.enable
 }
 
-func NewWalletController() *WalletController {
-	return &WalletController{}
+func NewWalletController(approvals bool) *WalletController {
+	return &WalletController{approvals: approvals}
 }
 func (w *WalletController) Deposit(ctx *gin.Context) {
 	logID := util.Uniqid()
@@ -39,7 +46,7 @@ func (w *WalletController) Deposit(ctx *gin.Context) {
 
 	dbCli := db.GetDbClient()
 	lockKey := "deposit:" + req.OrderID
-	locker := util.NewDistributedLock(logID, lockKey, 5)
+	locker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, lockKey, 5)
 
 	s := service.NewWalletService(ctx, logID, dbCli, locker)
 	rsp, err := s.Deposit(&req)
@@ -70,7 +77,7 @@ func (w *WalletController) Withdraw(ctx *gin.Context) {
 
 	dbCli := db.GetDbClient()
 	lockKey := "withdraw:" + req.OrderID
-	locker := util.NewDistributedLock(logID, lockKey, 5)
+	locker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, lockKey, 5)
 
 	s := service.NewWalletService(ctx, logID, dbCli, locker)
 	rsp, err := s.Withdraw(&req)
@@ -100,9 +107,9 @@ func (w *WalletController) Transfer(ctx *gin.Context) {
 
 	dbCli := db.GetDbClient()
 	lockKey := "transfer:" + req.OrderID
-	locker := util.NewDistributedLock(logID, lockKey, 5)
+	locker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, lockKey, 5)
 
-	s := service.NewWalletService(ctx, logID, dbCli, locker)
+	s := service.NewWalletService(ctx, logID, dbCli, locker).WithApprovals(w.approvals)
 	rsp, err := s.Transfer(&req)
 	if err != nil {
 		log.Printf("%s|fail to transfer:%s\n", logID, err.Error())
@@ -110,6 +117,148 @@ func (w *WalletController) Transfer(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, rsp)
 }
 
+func (w *WalletController) Move(ctx *gin.Context) {
+	logID := util.Uniqid()
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("%s|panic:%v\n", logID, p)
+		}
+	}()
+	var req data.MoveReq
+	if err := ctx.BindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validator.NewValidatorSvc().ValidatorMoveReq(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	dbCli := db.GetDbClient()
+	lockKey := "move:" + req.OrderID
+	locker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, lockKey, 5)
+
+	s := service.NewWalletService(ctx, logID, dbCli, locker)
+	rsp, err := s.Move(&req)
+	if err != nil {
+		log.Printf("%s|fail to move:%s\n", logID, err.Error())
+	}
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+func (w *WalletController) CreateOrg(ctx *gin.Context) {
+	logID := util.Uniqid()
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("%s|panic:%v\n", logID, p)
+		}
+	}()
+	var req data.CreateOrgReq
+	if err := ctx.BindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validator.NewValidatorSvc().ValidatorCreateOrgReq(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	dbCli := db.GetDbClient()
+	s := service.NewWalletService(ctx, logID, dbCli, nil)
+	rsp, err := s.CreateOrg(&req)
+	if err != nil {
+		log.Printf("%s|fail to create org:%s\n", logID, err.Error())
+	}
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+func (w *WalletController) SetOrgMember(ctx *gin.Context) {
+	logID := util.Uniqid()
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("%s|panic:%v\n", logID, p)
+		}
+	}()
+	var req data.SetOrgMemberReq
+	if err := ctx.BindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validator.NewValidatorSvc().ValidatorSetOrgMemberReq(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	dbCli := db.GetDbClient()
+	s := service.NewWalletService(ctx, logID, dbCli, nil)
+	rsp, err := s.SetOrgMember(&req)
+	if err != nil {
+		log.Printf("%s|fail to set org member:%s\n", logID, err.Error())
+	}
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+func (w *WalletController) ApproveTransfer(ctx *gin.Context) {
+	logID := util.Uniqid()
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("%s|panic:%v\n", logID, p)
+		}
+	}()
+	var req data.ApproveTransferReq
+	if err := ctx.BindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validator.NewValidatorSvc().ValidatorApproveTransferReq(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	dbCli := db.GetDbClient()
+	// same lock as the transfer, approvals of one transfer run one at a time
+	lockKey := "transfer:" + req.OrderID
+	locker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, lockKey, 5)
+
+	s := service.NewWalletService(ctx, logID, dbCli, locker)
+	rsp, err := s.ApproveTransfer(&req)
+	if err != nil {
+		log.Printf("%s|fail to approve transfer:%s\n", logID, err.Error())
+	}
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+func (w *WalletController) GetApprovalList(ctx *gin.Context) {
+	logID := util.Uniqid()
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("%s|panic:%v\n", logID, p)
+		}
+	}()
+	userID, err := w.GetParamUserID(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	orgID, err := w.GetParamOrgID(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req := &data.GetApprovalListReq{OrgID: orgID, UserID: userID}
+	if err := validator.NewValidatorSvc().ValidatorGetApprovalListReq(req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	dbCli := db.GetDbClient()
+	s := service.NewWalletService(ctx, logID, dbCli, nil)
+	rsp, err := s.GetApprovalList(req)
+	if err != nil {
+		log.Printf("%s|fail to get approval list:%s\n", logID, err.Error())
+	}
+	ctx.JSON(http.StatusOK, rsp)
+}
+
 func (w *WalletController) GetBalance(ctx *gin.Context) {
 	logID := util.Uniqid()
 	defer func() {
@@ -122,7 +271,12 @@ func (w *WalletController) GetBalance(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	req := &data.GetBalanceReq{UserID: userID}
+	orgID, err := w.GetParamOrgID(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req := &data.GetBalanceReq{UserID: userID, OrgID: orgID}
 	if err := validator.NewValidatorSvc().ValidatorGetBalanceReq(req); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -158,8 +312,17 @@ func (w *WalletController) GetTransactionHistory(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	orgID, err := w.GetParamOrgID(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	req := &data.GetTransactionHistoryReq{UserID: userID, Page: page, Limit: limit}
+	req := &data.GetTransactionHistoryReq{UserID: userID, Cursor: ctx.Query("cursor"), Page: page, Limit: limit, Pocket: ctx.Query("pocket"), OrgID: orgID}
+	if err := w.GetParamHistoryFilter(ctx, req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err := validator.NewValidatorSvc().ValidatorGetTransactionHistoryReq(req); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -173,6 +336,183 @@ func (w *WalletController) GetTransactionHistory(ctx *gin.Context) {
 	}
 	ctx.JSON(http.StatusOK, rsp)
 }
+func (w *WalletController) CreateWebhook(ctx *gin.Context) {
+	logID := util.Uniqid()
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("%s|panic:%v\n", logID, p)
+		}
+	}()
+	var req data.CreateWebhookReq
+	if err := ctx.BindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validator.NewValidatorSvc().ValidatorCreateWebhookReq(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	dbCli := db.GetDbClient()
+	s := service.NewWalletService(ctx, logID, dbCli, nil)
+	rsp, err := s.CreateWebhook(&req)
+	if err != nil {
+		log.Printf("%s|fail to create webhook:%s\n", logID, err.Error())
+	}
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+func (w *WalletController) GetWebhookDeliveryList(ctx *gin.Context) {
+	logID := util.Uniqid()
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("%s|panic:%v\n", logID, p)
+		}
+	}()
+	userID, err := w.GetParamUserID(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	limit, err := w.GetParamLimit(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var beforeID int64
+	if beforeIDStr := ctx.Query("before_id"); beforeIDStr != "" {
+		if beforeID, err = strconv.ParseInt(beforeIDStr, 10, 64); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	req := &data.GetWebhookDeliveryListReq{UserID: userID, Status: ctx.Query("status"), BeforeID: beforeID, Limit: limit}
+	if err := validator.NewValidatorSvc().ValidatorGetWebhookDeliveryListReq(req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	dbCli := db.GetDbClient()
+	s := service.NewWalletService(ctx, logID, dbCli, nil)
+	rsp, err := s.GetWebhookDeliveryList(req)
+	if err != nil {
+		log.Printf("%s|fail to get webhook delivery list:%s\n", logID, err.Error())
+	}
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+func (w *WalletController) RedeliverWebhook(ctx *gin.Context) {
+	logID := util.Uniqid()
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("%s|panic:%v\n", logID, p)
+		}
+	}()
+	var req data.RedeliverWebhookReq
+	if err := ctx.BindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validator.NewValidatorSvc().ValidatorRedeliverWebhookReq(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	dbCli := db.GetDbClient()
+	s := service.NewWalletService(ctx, logID, dbCli, nil)
+	rsp, err := s.RedeliverWebhook(&req)
+	if err != nil {
+		log.Printf("%s|fail to redeliver webhook:%s\n", logID, err.Error())
+	}
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+// streamWriteTimeout bounds each write of a stream, it outlives the write timeout of the server
+const streamWriteTimeout = 10 * time.Second
+
+// streamsCtx ends the open streams, which never end by themselves, when the server shuts down
+var streamsCtx, stopStreams = context.WithCancel(context.Background())
+
+// StopStreams ends the open streams, registered to run on the shutdown of the server
+func StopStreams() {
+	stopStreams()
+}
+
+// Stream pushes the balance and new transactions of the user as server-sent events. A reconnecting
+// EventSource sends the id of the last event it got as Last-Event-ID, the stream resumes after it.
+func (w *WalletController) Stream(ctx *gin.Context) {
+	logID := util.Uniqid()
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("%s|panic:%v\n", logID, p)
+		}
+	}()
+	userID, err := w.GetParamUserID(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	lastEventID := ctx.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = ctx.Query("last_event_id")
+	}
+	req := &data.StreamReq{UserID: userID, LastEventID: lastEventID}
+	if err := validator.NewValidatorSvc().ValidatorStreamReq(req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(streamsCtx, cancel)()
+
+	dbCli := db.GetDbClient()
+	s := service.NewWalletService(streamCtx, logID, dbCli, nil)
+	rc := http.NewResponseController(ctx.Writer)
+	started := false
+	rsp, err := s.Stream(req, func(event *data.StreamEvent) error {
+		if !started {
+			started = true
+			ctx.Header("Content-Type", "text/event-stream")
+			ctx.Header("Cache-Control", "no-cache")
+			ctx.Header("Connection", "keep-alive")
+			ctx.Header("X-Accel-Buffering", "no")
+			ctx.Status(http.StatusOK)
+		}
+		if err := rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+		if err := writeStreamEvent(ctx.Writer, event); err != nil {
+			return err
+		}
+		return rc.Flush()
+	})
+	if err != nil {
+		log.Printf("%s|fail to stream:%s\n", logID, err.Error())
+	}
+	if !started {
+		ctx.JSON(http.StatusOK, rsp)
+	}
+}
+
+// writeStreamEvent writes the event in the text/event-stream format, a heartbeat as a comment
+func writeStreamEvent(wr io.Writer, event *data.StreamEvent) error {
+	if event.Event == "" {
+		_, err := io.WriteString(wr, ": ping\n\n")
+		return err
+	}
+	payload, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+	msg := ""
+	if event.ID != "" {
+		msg += "id: " + event.ID + "\n"
+	}
+	msg += "event: " + event.Event + "\ndata: " + string(payload) + "\n\n"
+	_, err = io.WriteString(wr, msg)
+	return err
+}
+
 func (w *WalletController) GetParamUserID(ctx *gin.Context) (int64, error) {
 	userIDStr := ctx.Query("user_id")
 	if userIDStr == "" {
@@ -184,10 +524,51 @@ func (w *WalletController) GetParamUserID(ctx *gin.Context) (int64, error) {
 	}
 	return userID, nil
 }
+func (w *WalletController) GetParamOrgID(ctx *gin.Context) (int64, error) {
+	orgIDStr := ctx.Query("org_id")
+	if orgIDStr == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(orgIDStr, 10, 64)
+}
+
+// GetParamHistoryFilter fills the optional filters of the history from the query,
+// tx_types=1,2&start_time=&end_time=&min_amount=&max_amount=&related_user_id=&order_id_prefix=
+func (w *WalletController) GetParamHistoryFilter(ctx *gin.Context, req *data.GetTransactionHistoryReq) error {
+	if txTypesStr := ctx.Query("tx_types"); txTypesStr != "" {
+		for _, txTypeStr := range strings.Split(txTypesStr, ",") {
+			txType, err := strconv.ParseInt(strings.TrimSpace(txTypeStr), 10, 32)
+			if err != nil {
+				return fmt.Errorf("tx_types: %w", err)
+			}
+			req.TxTypes = append(req.TxTypes, int32(txType))
+		}
+	}
+	for key, dst := range map[string]*int64{"start_time": &req.StartTime, "end_time": &req.EndTime, "related_user_id": &req.RelatedUserID} {
+		if str := ctx.Query(key); str != "" {
+			v, err := strconv.ParseInt(str, 10, 64)
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			*dst = v
+		}
+	}
+	for key, dst := range map[string]*float64{"min_amount": &req.MinAmount, "max_amount": &req.MaxAmount} {
+		if str := ctx.Query(key); str != "" {
+			v, err := strconv.ParseFloat(str, 64)
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			*dst = v
+		}
+	}
+	req.OrderIDPrefix = ctx.Query("order_id_prefix")
+	return nil
+}
 func (w *WalletController) GetParamPage(ctx *gin.Context) (int32, error) {
 	pageStr := ctx.Query("page")
 	if pageStr == "" {
-		pageStr = "1"
+		return 0, nil // deprecated, 0 pages by cursor
 	}
 	page, err := strconv.Atoi(pageStr)
 	if err != nil {
@@ -2,10 +2,16 @@ package validator
 
 import (
 	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
 	"simplewallet/data"
 	"simplewallet/util"
+	"slices"
 )
 
+var pocketNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
 type ValidatorSvc struct {
 }
 
@@ -22,6 +28,12 @@ func (v *ValidatorSvc) ValidatorDepositReq(req *data.DepositReq) error {
 	if req.UserID <= 0 {
 		return errors.New("user_id should > 0")
 	}
+	if req.Pocket != "" && !pocketNameRegexp.MatchString(req.Pocket) {
+		return errors.New("pocket should be 1-32 letters, digits, '_' or '-'")
+	}
+	if req.OrgID < 0 {
+		return errors.New("org_id should >= 0")
+	}
 	return nil
 }
 func (v *ValidatorSvc) ValidatorWithdrawReq(req *data.WithdrawReq) error {
@@ -34,6 +46,12 @@ func (v *ValidatorSvc) ValidatorWithdrawReq(req *data.WithdrawReq) error {
 	if req.UserID <= 0 {
 		return errors.New("user_id should > 0")
 	}
+	if req.Pocket != "" && !pocketNameRegexp.MatchString(req.Pocket) {
+		return errors.New("pocket should be 1-32 letters, digits, '_' or '-'")
+	}
+	if req.OrgID < 0 {
+		return errors.New("org_id should >= 0")
+	}
 	return nil
 }
 func (v *ValidatorSvc) ValidatorTransferReq(req *data.TransferReq) error {
@@ -49,23 +67,285 @@ func (v *ValidatorSvc) ValidatorTransferReq(req *data.TransferReq) error {
 	if req.ToUserID <= 0 {
 		return errors.New("to_user_id should > 0")
 	}
-	if req.FromUserID == req.ToUserID {
+	if req.OrgID < 0 {
+		return errors.New("org_id should >= 0")
+	}
+	// a member may pay out of the shared wallet to themselves
+	if req.OrgID == 0 && req.FromUserID == req.ToUserID {
 		return errors.New("from_user_id and to_user_id must be different")
 	}
 	return nil
 }
+func (v *ValidatorSvc) ValidatorMoveReq(req *data.MoveReq) error {
+	if req.OrderID == "" {
+		return errors.New("order_id is required")
+	}
+	if util.CompareFloat(req.Amount, 1e-8, 8) < 0 {
+		return errors.New("amount must >= 1e-8")
+	}
+	if req.UserID <= 0 {
+		return errors.New("user_id should > 0")
+	}
+	if !pocketNameRegexp.MatchString(req.FromPocket) {
+		return errors.New("from_pocket should be 1-32 letters, digits, '_' or '-'")
+	}
+	if !pocketNameRegexp.MatchString(req.ToPocket) {
+		return errors.New("to_pocket should be 1-32 letters, digits, '_' or '-'")
+	}
+	if req.FromPocket == req.ToPocket {
+		return errors.New("from_pocket and to_pocket must be different")
+	}
+	return nil
+}
+func (v *ValidatorSvc) ValidatorCreateOrgReq(req *data.CreateOrgReq) error {
+	if req.UserID <= 0 {
+		return errors.New("user_id should > 0")
+	}
+	if req.Name == "" || len(req.Name) > 64 {
+		return errors.New("name should be 1-64 characters")
+	}
+	if req.RequiredApprovals < 0 {
+		return errors.New("required_approvals should >= 0")
+	}
+	if req.ApprovalExpireSecond < 0 {
+		return errors.New("approval_expire_second should >= 0")
+	}
+	return nil
+}
+func (v *ValidatorSvc) ValidatorSetOrgMemberReq(req *data.SetOrgMemberReq) error {
+	if req.OrgID <= 0 {
+		return errors.New("org_id should > 0")
+	}
+	if req.UserID <= 0 {
+		return errors.New("user_id should > 0")
+	}
+	if req.MemberUserID <= 0 {
+		return errors.New("member_user_id should > 0")
+	}
+	if data.OrgRoleRank(req.Role) == 0 {
+		return errors.New("role should be owner, spender or viewer")
+	}
+	if util.CompareFloat(req.SpendingLimit, 0, 8) < 0 {
+		return errors.New("spending_limit should >= 0")
+	}
+	return nil
+}
+func (v *ValidatorSvc) ValidatorApproveTransferReq(req *data.ApproveTransferReq) error {
+	if req.OrderID == "" {
+		return errors.New("order_id is required")
+	}
+	if req.OrgID <= 0 {
+		return errors.New("org_id should > 0")
+	}
+	if req.UserID <= 0 {
+		return errors.New("user_id should > 0")
+	}
+	return nil
+}
+func (v *ValidatorSvc) ValidatorGetApprovalListReq(req *data.GetApprovalListReq) error {
+	if req.OrgID <= 0 {
+		return errors.New("org_id should > 0")
+	}
+	if req.UserID <= 0 {
+		return errors.New("user_id should > 0")
+	}
+	return nil
+}
 func (v *ValidatorSvc) ValidatorGetBalanceReq(req *data.GetBalanceReq) error {
 	if req.UserID <= 0 {
 		return errors.New("user_id should > 0")
 	}
+	if req.OrgID < 0 {
+		return errors.New("org_id should >= 0")
+	}
 	return nil
 }
 func (v *ValidatorSvc) ValidatorGetTransactionHistoryReq(req *data.GetTransactionHistoryReq) error {
 	if req.UserID <= 0 {
 		return errors.New("user_id should > 0")
 	}
-	if req.Page <= 0 {
-		return errors.New("page should > 0")
+	if req.Page < 0 {
+		return errors.New("page should >= 0")
+	}
+	if req.Page > 0 && req.Cursor != "" {
+		return errors.New("page is deprecated and can't be used with cursor")
+	}
+	if len(req.Cursor) > 128 {
+		return errors.New("cursor too long")
+	}
+	if req.Limit <= 0 {
+		return errors.New("limit should > 0")
+	}
+	if req.Limit > 100 {
+		return errors.New("limit should <= 100")
+	}
+	if req.Pocket != "" && !pocketNameRegexp.MatchString(req.Pocket) {
+		return errors.New("pocket should be 1-32 letters, digits, '_' or '-'")
+	}
+	if req.OrgID < 0 {
+		return errors.New("org_id should >= 0")
+	}
+	if len(req.TxTypes) > 10 {
+		return errors.New("tx_types should have <= 10 types")
+	}
+	for _, txType := range req.TxTypes {
+		if data.TxTypeSign(txType) == 0 {
+			return fmt.Errorf("tx_type %d is unknown", txType)
+		}
+	}
+	if req.StartTime < 0 || req.EndTime < 0 {
+		return errors.New("start_time and end_time should >= 0")
+	}
+	if req.StartTime > 0 && req.EndTime > 0 && req.StartTime >= req.EndTime {
+		return errors.New("start_time should < end_time")
+	}
+	if req.MinAmount < 0 || req.MaxAmount < 0 {
+		return errors.New("min_amount and max_amount should >= 0")
+	}
+	if req.MinAmount > 0 && req.MaxAmount > 0 && util.CompareFloat(req.MinAmount, req.MaxAmount, 8) > 0 {
+		return errors.New("min_amount should <= max_amount")
+	}
+	if req.RelatedUserID < 0 {
+		return errors.New("related_user_id should >= 0")
+	}
+	if len(req.OrderIDPrefix) > 64 {
+		return errors.New("order_id_prefix should have <= 64 characters")
+	}
+	return nil
+}
+func (v *ValidatorSvc) ValidatorCreateWebhookReq(req *data.CreateWebhookReq) error {
+	if req.UserID <= 0 {
+		return errors.New("user_id should > 0")
+	}
+	if len(req.URL) > 2048 {
+		return errors.New("url should have <= 2048 characters")
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url should be an http or https url")
+	}
+	for _, eventType := range req.EventTypes {
+		if !slices.Contains([]string{data.EventTypeDeposit, data.EventTypeWithdraw, data.EventTypeTransfer, data.EventTypeTransferRefund,
+			data.EventTypeAdjustmentCredit, data.EventTypeAdjustmentDebit, data.EventTypeInterest, data.EventTypeMove}, eventType) {
+			return fmt.Errorf("event_type %q is unknown", eventType)
+		}
+	}
+	if req.Secret != "" && (len(req.Secret) < 16 || len(req.Secret) > 128) {
+		return errors.New("secret should have 16-128 characters")
+	}
+	return nil
+}
+func (v *ValidatorSvc) ValidatorGetWebhookDeliveryListReq(req *data.GetWebhookDeliveryListReq) error {
+	if req.UserID <= 0 {
+		return errors.New("user_id should > 0")
+	}
+	if req.Status != "" && req.Status != "pending" && req.Status != "delivered" && req.Status != "dead" {
+		return errors.New("status should be pending, delivered or dead")
+	}
+	if req.BeforeID < 0 {
+		return errors.New("before_id should >= 0")
+	}
+	if req.Limit <= 0 {
+		return errors.New("limit should > 0")
+	}
+	if req.Limit > 100 {
+		return errors.New("limit should <= 100")
+	}
+	return nil
+}
+func (v *ValidatorSvc) ValidatorRedeliverWebhookReq(req *data.RedeliverWebhookReq) error {
+	if req.UserID <= 0 {
+		return errors.New("user_id should > 0")
+	}
+	if req.DeliveryID <= 0 {
+		return errors.New("delivery_id should > 0")
+	}
+	return nil
+}
+func (v *ValidatorSvc) ValidatorStreamReq(req *data.StreamReq) error {
+	if req.UserID <= 0 {
+		return errors.New("user_id should > 0")
+	}
+	if len(req.LastEventID) > 128 {
+		return errors.New("last_event_id too long")
+	}
+	return nil
+}
+func (v *ValidatorSvc) ValidatorAdjustReq(req *data.AdjustReq) error {
+	if req.OrderID == "" {
+		return errors.New("order_id is required")
+	}
+	if util.CompareFloat(req.Amount, 1e-8, 8) < 0 {
+		return errors.New("amount must >= 1e-8")
+	}
+	if req.UserID < 0 || req.OrgID < 0 {
+		return errors.New("user_id and org_id should >= 0")
+	}
+	if (req.UserID > 0) == (req.OrgID > 0) {
+		return errors.New("either user_id or org_id is required")
+	}
+	if req.Pocket != "" && !pocketNameRegexp.MatchString(req.Pocket) {
+		return errors.New("pocket should be 1-32 letters, digits, '_' or '-'")
+	}
+	if req.Direction != data.AdjustDirectionCredit && req.Direction != data.AdjustDirectionDebit {
+		return errors.New("direction should be credit or debit")
+	}
+	if _, ok := data.AdjustReasonCodes[req.ReasonCode]; !ok {
+		return fmt.Errorf("reason_code %q is unknown", req.ReasonCode)
+	}
+	if len(req.Reason) > 200 {
+		return errors.New("reason should <= 200 bytes")
+	}
+	return nil
+}
+func (v *ValidatorSvc) ValidatorReviewAdjustmentReq(req *data.ReviewAdjustmentReq) error {
+	if req.OrderID == "" {
+		return errors.New("order_id is required")
+	}
+	if req.UserID < 0 || req.OrgID < 0 {
+		return errors.New("user_id and org_id should >= 0")
+	}
+	if (req.UserID > 0) == (req.OrgID > 0) {
+		return errors.New("either user_id or org_id is required")
+	}
+	return nil
+}
+func (v *ValidatorSvc) ValidatorSetWalletTermsReq(req *data.SetWalletTermsReq) error {
+	if req.UserID < 0 || req.OrgID < 0 {
+		return errors.New("user_id and org_id should >= 0")
+	}
+	if (req.UserID > 0) == (req.OrgID > 0) {
+		return errors.New("either user_id or org_id is required")
+	}
+	if req.Pocket != "" && !pocketNameRegexp.MatchString(req.Pocket) {
+		return errors.New("pocket should be 1-32 letters, digits, '_' or '-'")
+	}
+	if req.CreditLimit < 0 {
+		return errors.New("credit_limit should >= 0")
+	}
+	if req.Product != "" && !pocketNameRegexp.MatchString(req.Product) {
+		return errors.New("product should be 1-32 letters, digits, '_' or '-'")
+	}
+	if req.Reason == "" || len(req.Reason) > 200 {
+		return errors.New("reason should be 1-200 bytes")
+	}
+	return nil
+}
+func (v *ValidatorSvc) ValidatorGetAuditLogListReq(req *data.GetAuditLogListReq) error {
+	if req.TargetID < 0 {
+		return errors.New("target_id should >= 0")
+	}
+	if req.TargetID > 0 && req.TargetType == "" {
+		return errors.New("target_id needs target_type")
+	}
+	if req.StartTime < 0 || req.EndTime < 0 {
+		return errors.New("start_time and end_time should >= 0")
+	}
+	if req.EndTime > 0 && req.StartTime >= req.EndTime {
+		return errors.New("start_time should < end_time")
+	}
+	if len(req.Cursor) > 128 {
+		return errors.New("cursor too long")
 	}
 	if req.Limit <= 0 {
 		return errors.New("limit should > 0")
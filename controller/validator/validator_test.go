@@ -4,6 +4,7 @@ import (
 	"errors"
 	"simplewallet/controller/validator"
 	"simplewallet/data"
+	"strings"
 	"testing"
 
 	"go.uber.org/goleak"
@@ -92,6 +93,8 @@ func TestValidatorTransferReq(t *testing.T) {
 		{Name: "case10: ValidatorTransferReq success-[amount = 0.00000001]", args: &data.TransferReq{OrderID: "123", FromUserID: 101, ToUserID: 102, Amount: 0.00000001}, want: nil},
 		{Name: "case11: ValidatorTransferReq fail-   [amount =-0.00000001]", args: &data.TransferReq{OrderID: "123", FromUserID: 101, ToUserID: 102, Amount: -0.00000001}, want: errors.New("amount should > 0")},
 		{Name: "case12: ValidatorTransferReq fail-   [amount = 0.000000001]", args: &data.TransferReq{OrderID: "123", FromUserID: 101, ToUserID: 102, Amount: 0.000000001}, want: errors.New("amount should >= 1e-8")},
+		{Name: "case13: ValidatorTransferReq success-[org member pays themselves]", args: &data.TransferReq{OrderID: "123", FromUserID: 101, ToUserID: 101, Amount: 1000.00, OrgID: 1}, want: nil},
+		{Name: "case14: ValidatorTransferReq fail-[org_id < 0]", args: &data.TransferReq{OrderID: "123", FromUserID: 101, ToUserID: 102, Amount: 1000.00, OrgID: -1}, want: errors.New("org_id should >= 0")},
 	}
 	v := validator.NewValidatorSvc()
 	for _, tt := range tests {
@@ -106,6 +109,140 @@ func TestValidatorTransferReq(t *testing.T) {
 	}
 }
 
+func TestValidatorMoveReq(t *testing.T) {
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	type args struct {
+		Name string
+		args *data.MoveReq
+		want error
+	}
+	tests := []args{
+		{Name: "case1: ValidatorMoveReq success", args: &data.MoveReq{OrderID: "123", UserID: 101, FromPocket: "main", ToPocket: "savings", Amount: 1000.00}, want: nil},
+		{Name: "case2: ValidatorMoveReq fail-[order_id is empty]", args: &data.MoveReq{OrderID: "", UserID: 101, FromPocket: "main", ToPocket: "savings", Amount: 1000.00}, want: errors.New("order_id is required")},
+		{Name: "case3: ValidatorMoveReq fail-[UserID = 0]", args: &data.MoveReq{OrderID: "123", UserID: 0, FromPocket: "main", ToPocket: "savings", Amount: 1000.00}, want: errors.New("user_id should > 0")},
+		{Name: "case4: ValidatorMoveReq fail-[amount = 0]", args: &data.MoveReq{OrderID: "123", UserID: 101, FromPocket: "main", ToPocket: "savings", Amount: 0}, want: errors.New("amount must >= 1e-8")},
+		{Name: "case5: ValidatorMoveReq fail-[from_pocket is empty]", args: &data.MoveReq{OrderID: "123", UserID: 101, FromPocket: "", ToPocket: "savings", Amount: 1000.00}, want: errors.New("from_pocket should be 1-32 letters, digits, '_' or '-'")},
+		{Name: "case6: ValidatorMoveReq fail-[to_pocket too long]", args: &data.MoveReq{OrderID: "123", UserID: 101, FromPocket: "main", ToPocket: "savings_savings_savings_savings_1", Amount: 1000.00}, want: errors.New("to_pocket should be 1-32 letters, digits, '_' or '-'")},
+		{Name: "case7: ValidatorMoveReq fail-[same pocket]", args: &data.MoveReq{OrderID: "123", UserID: 101, FromPocket: "main", ToPocket: "main", Amount: 1000.00}, want: errors.New("from_pocket and to_pocket must be different")},
+	}
+	v := validator.NewValidatorSvc()
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			err := v.ValidatorMoveReq(tt.args)
+			if tt.want == nil && err != nil {
+				t.Errorf("ValidatorMoveReq()case:%s error = %v, wantErr %v", tt.Name, err, tt.want)
+			} else if tt.want != nil && err == nil {
+				t.Errorf("ValidatorMoveReq()case:%s error = %v, wantErr %v", tt.Name, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatorCreateOrgReq(t *testing.T) {
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	type args struct {
+		Name string
+		args *data.CreateOrgReq
+		want error
+	}
+	tests := []args{
+		{Name: "case1: ValidatorCreateOrgReq success", args: &data.CreateOrgReq{UserID: 101, Name: "acme"}, want: nil},
+		{Name: "case2: ValidatorCreateOrgReq fail-[UserID = 0]", args: &data.CreateOrgReq{UserID: 0, Name: "acme"}, want: errors.New("user_id should > 0")},
+		{Name: "case3: ValidatorCreateOrgReq fail-[name is empty]", args: &data.CreateOrgReq{UserID: 101, Name: ""}, want: errors.New("name should be 1-64 characters")},
+		{Name: "case4: ValidatorCreateOrgReq fail-[required_approvals < 0]", args: &data.CreateOrgReq{UserID: 101, Name: "acme", RequiredApprovals: -1}, want: errors.New("required_approvals should >= 0")},
+		{Name: "case5: ValidatorCreateOrgReq fail-[approval_expire_second < 0]", args: &data.CreateOrgReq{UserID: 101, Name: "acme", ApprovalExpireSecond: -1}, want: errors.New("approval_expire_second should >= 0")},
+	}
+	v := validator.NewValidatorSvc()
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			err := v.ValidatorCreateOrgReq(tt.args)
+			if tt.want == nil && err != nil {
+				t.Errorf("ValidatorCreateOrgReq()case:%s error = %v, wantErr %v", tt.Name, err, tt.want)
+			} else if tt.want != nil && err == nil {
+				t.Errorf("ValidatorCreateOrgReq()case:%s error = %v, wantErr %v", tt.Name, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatorSetOrgMemberReq(t *testing.T) {
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	type args struct {
+		Name string
+		args *data.SetOrgMemberReq
+		want error
+	}
+	tests := []args{
+		{Name: "case1: ValidatorSetOrgMemberReq success", args: &data.SetOrgMemberReq{OrgID: 1, UserID: 101, MemberUserID: 102, Role: data.OrgRoleSpender, SpendingLimit: 500.00}, want: nil},
+		{Name: "case2: ValidatorSetOrgMemberReq fail-[OrgID = 0]", args: &data.SetOrgMemberReq{OrgID: 0, UserID: 101, MemberUserID: 102, Role: data.OrgRoleSpender}, want: errors.New("org_id should > 0")},
+		{Name: "case3: ValidatorSetOrgMemberReq fail-[MemberUserID = 0]", args: &data.SetOrgMemberReq{OrgID: 1, UserID: 101, MemberUserID: 0, Role: data.OrgRoleSpender}, want: errors.New("member_user_id should > 0")},
+		{Name: "case4: ValidatorSetOrgMemberReq fail-[unknown role]", args: &data.SetOrgMemberReq{OrgID: 1, UserID: 101, MemberUserID: 102, Role: "admin"}, want: errors.New("role should be owner, spender or viewer")},
+		{Name: "case5: ValidatorSetOrgMemberReq fail-[spending_limit < 0]", args: &data.SetOrgMemberReq{OrgID: 1, UserID: 101, MemberUserID: 102, Role: data.OrgRoleSpender, SpendingLimit: -1}, want: errors.New("spending_limit should >= 0")},
+	}
+	v := validator.NewValidatorSvc()
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			err := v.ValidatorSetOrgMemberReq(tt.args)
+			if tt.want == nil && err != nil {
+				t.Errorf("ValidatorSetOrgMemberReq()case:%s error = %v, wantErr %v", tt.Name, err, tt.want)
+			} else if tt.want != nil && err == nil {
+				t.Errorf("ValidatorSetOrgMemberReq()case:%s error = %v, wantErr %v", tt.Name, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatorApproveTransferReq(t *testing.T) {
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	type args struct {
+		Name string
+		args *data.ApproveTransferReq
+		want error
+	}
+	tests := []args{
+		{Name: "case1: ValidatorApproveTransferReq success", args: &data.ApproveTransferReq{OrgID: 1, UserID: 101, OrderID: "ABCD1234"}, want: nil},
+		{Name: "case2: ValidatorApproveTransferReq fail-[OrderID is empty]", args: &data.ApproveTransferReq{OrgID: 1, UserID: 101, OrderID: ""}, want: errors.New("order_id is required")},
+		{Name: "case3: ValidatorApproveTransferReq fail-[OrgID = 0]", args: &data.ApproveTransferReq{OrgID: 0, UserID: 101, OrderID: "ABCD1234"}, want: errors.New("org_id should > 0")},
+		{Name: "case4: ValidatorApproveTransferReq fail-[UserID = 0]", args: &data.ApproveTransferReq{OrgID: 1, UserID: 0, OrderID: "ABCD1234"}, want: errors.New("user_id should > 0")},
+	}
+	v := validator.NewValidatorSvc()
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			err := v.ValidatorApproveTransferReq(tt.args)
+			if tt.want == nil && err != nil {
+				t.Errorf("ValidatorApproveTransferReq()case:%s error = %v, wantErr %v", tt.Name, err, tt.want)
+			} else if tt.want != nil && err == nil {
+				t.Errorf("ValidatorApproveTransferReq()case:%s error = %v, wantErr %v", tt.Name, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatorGetApprovalListReq(t *testing.T) {
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	type args struct {
+		Name string
+		args *data.GetApprovalListReq
+		want error
+	}
+	tests := []args{
+		{Name: "case1: ValidatorGetApprovalListReq success", args: &data.GetApprovalListReq{OrgID: 1, UserID: 101}, want: nil},
+		{Name: "case2: ValidatorGetApprovalListReq fail-[OrgID = 0]", args: &data.GetApprovalListReq{OrgID: 0, UserID: 101}, want: errors.New("org_id should > 0")},
+		{Name: "case3: ValidatorGetApprovalListReq fail-[UserID = 0]", args: &data.GetApprovalListReq{OrgID: 1, UserID: 0}, want: errors.New("user_id should > 0")},
+	}
+	v := validator.NewValidatorSvc()
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			err := v.ValidatorGetApprovalListReq(tt.args)
+			if tt.want == nil && err != nil {
+				t.Errorf("ValidatorGetApprovalListReq()case:%s error = %v, wantErr %v", tt.Name, err, tt.want)
+			} else if tt.want != nil && err == nil {
+				t.Errorf("ValidatorGetApprovalListReq()case:%s error = %v, wantErr %v", tt.Name, err, tt.want)
+			}
+		})
+	}
+}
+
 func TestValidatorGetBalanceReq(t *testing.T) {
 	defer goleak.VerifyNone(t) // check for goroutine leaks
 	type args struct {
@@ -143,11 +280,24 @@ func TestValidatorGetTransactionHistoryReq(t *testing.T) {
 		{Name: "case1: GetTransactionHistoryReq success", args: &data.GetTransactionHistoryReq{UserID: 101, Page: 1, Limit: 10}, want: nil},
 		{Name: "case2: GetTransactionHistoryReq fail-[UserID = 0]", args: &data.GetTransactionHistoryReq{UserID: 0, Page: 1, Limit: 10}, want: errors.New("user_id should > 0")},
 		{Name: "case3: GetTransactionHistoryReq fail-[UserID < 0]", args: &data.GetTransactionHistoryReq{UserID: -101, Page: 1, Limit: 10}, want: errors.New("user_id should > 0")},
-		{Name: "case3: GetTransactionHistoryReq fail-[page = 0]", args: &data.GetTransactionHistoryReq{UserID: 101, Page: 0, Limit: 10}, want: errors.New("page should > 0")},
-		{Name: "case3: GetTransactionHistoryReq fail-[page < 0]", args: &data.GetTransactionHistoryReq{UserID: 101, Page: -1, Limit: 10}, want: errors.New("page should > 0")},
-		{Name: "case3: GetTransactionHistoryReq fail-[limit = 0]", args: &data.GetTransactionHistoryReq{UserID: 101, Page: 0, Limit: 10}, want: errors.New("limit should > 0")},
-		{Name: "case3: GetTransactionHistoryReq fail-[limit < 0]", args: &data.GetTransactionHistoryReq{UserID: 101, Page: -1, Limit: 10}, want: errors.New("limit should > 0")},
-		{Name: "case3: GetTransactionHistoryReq fail-[limit > 100]", args: &data.GetTransactionHistoryReq{UserID: 101, Page: -1, Limit: 10}, want: errors.New("limit should <= 100")},
+		{Name: "case3: GetTransactionHistoryReq success-[page = 0, first page by cursor]", args: &data.GetTransactionHistoryReq{UserID: 101, Page: 0, Limit: 10}, want: nil},
+		{Name: "case3: GetTransactionHistoryReq fail-[page < 0]", args: &data.GetTransactionHistoryReq{UserID: 101, Page: -1, Limit: 10}, want: errors.New("page should >= 0")},
+		{Name: "case3: GetTransactionHistoryReq fail-[limit = 0]", args: &data.GetTransactionHistoryReq{UserID: 101, Page: 1, Limit: 0}, want: errors.New("limit should > 0")},
+		{Name: "case3: GetTransactionHistoryReq fail-[limit < 0]", args: &data.GetTransactionHistoryReq{UserID: 101, Page: 1, Limit: -1}, want: errors.New("limit should > 0")},
+		{Name: "case3: GetTransactionHistoryReq fail-[limit > 100]", args: &data.GetTransactionHistoryReq{UserID: 101, Page: 1, Limit: 101}, want: errors.New("limit should <= 100")},
+		{Name: "case4: GetTransactionHistoryReq success-[pocket filter]", args: &data.GetTransactionHistoryReq{UserID: 101, Page: 1, Limit: 10, Pocket: "savings"}, want: nil},
+		{Name: "case5: GetTransactionHistoryReq fail-[invalid pocket]", args: &data.GetTransactionHistoryReq{UserID: 101, Page: 1, Limit: 10, Pocket: "my savings"}, want: errors.New("pocket should be 1-32 letters, digits, '_' or '-'")},
+		{Name: "case6: GetTransactionHistoryReq success-[cursor]", args: &data.GetTransactionHistoryReq{UserID: 101, Cursor: "djE6bjoxNzMwMjA0MzcyOjQ", Limit: 10}, want: nil},
+		{Name: "case7: GetTransactionHistoryReq fail-[page with cursor]", args: &data.GetTransactionHistoryReq{UserID: 101, Cursor: "djE6bjoxNzMwMjA0MzcyOjQ", Page: 2, Limit: 10}, want: errors.New("page is deprecated and can't be used with cursor")},
+		{Name: "case8: GetTransactionHistoryReq success-[all filters]", args: &data.GetTransactionHistoryReq{UserID: 101, Limit: 10, TxTypes: []int32{data.TxTypeDeposit, data.TxTypeTransferOut}, StartTime: 1730000000, EndTime: 1730086400, MinAmount: 1.5, MaxAmount: 100, RelatedUserID: 102, OrderIDPrefix: "2024"}, want: nil},
+		{Name: "case9: GetTransactionHistoryReq fail-[unknown tx type]", args: &data.GetTransactionHistoryReq{UserID: 101, Limit: 10, TxTypes: []int32{data.TxTypeDeposit, 99}}, want: errors.New("tx_type 99 is unknown")},
+		{Name: "case9: GetTransactionHistoryReq fail-[tx type 0]", args: &data.GetTransactionHistoryReq{UserID: 101, Limit: 10, TxTypes: []int32{data.TxTypeUnknown}}, want: errors.New("tx_type 0 is unknown")},
+		{Name: "case10: GetTransactionHistoryReq fail-[start_time < 0]", args: &data.GetTransactionHistoryReq{UserID: 101, Limit: 10, StartTime: -1}, want: errors.New("start_time and end_time should >= 0")},
+		{Name: "case10: GetTransactionHistoryReq fail-[start_time >= end_time]", args: &data.GetTransactionHistoryReq{UserID: 101, Limit: 10, StartTime: 1730086400, EndTime: 1730086400}, want: errors.New("start_time should < end_time")},
+		{Name: "case11: GetTransactionHistoryReq fail-[min_amount < 0]", args: &data.GetTransactionHistoryReq{UserID: 101, Limit: 10, MinAmount: -1}, want: errors.New("min_amount and max_amount should >= 0")},
+		{Name: "case11: GetTransactionHistoryReq fail-[min_amount > max_amount]", args: &data.GetTransactionHistoryReq{UserID: 101, Limit: 10, MinAmount: 10, MaxAmount: 9.99}, want: errors.New("min_amount should <= max_amount")},
+		{Name: "case12: GetTransactionHistoryReq fail-[related_user_id < 0]", args: &data.GetTransactionHistoryReq{UserID: 101, Limit: 10, RelatedUserID: -1}, want: errors.New("related_user_id should >= 0")},
+		{Name: "case13: GetTransactionHistoryReq fail-[order_id_prefix too long]", args: &data.GetTransactionHistoryReq{UserID: 101, Limit: 10, OrderIDPrefix: strings.Repeat("1", 65)}, want: errors.New("order_id_prefix should have <= 64 characters")},
 	}
 	v := validator.NewValidatorSvc()
 	for _, tt := range tests {
@@ -161,3 +311,223 @@ func TestValidatorGetTransactionHistoryReq(t *testing.T) {
 		})
 	}
 }
+
+func TestValidatorCreateWebhookReq(t *testing.T) {
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	type args struct {
+		Name string
+		args *data.CreateWebhookReq
+		want error
+	}
+	tests := []args{
+		{Name: "case1: CreateWebhookReq success", args: &data.CreateWebhookReq{UserID: 101, URL: "https://merchant.example.com/hooks"}, want: nil},
+		{Name: "case2: CreateWebhookReq success-[event types and secret]", args: &data.CreateWebhookReq{UserID: 101, URL: "http://127.0.0.1:9000/hooks", EventTypes: []string{data.EventTypeDeposit, data.EventTypeTransfer}, Secret: "0123456789abcdef"}, want: nil},
+		{Name: "case3: CreateWebhookReq fail-[user_id = 0]", args: &data.CreateWebhookReq{UserID: 0, URL: "https://merchant.example.com/hooks"}, want: errors.New("user_id should > 0")},
+		{Name: "case4: CreateWebhookReq fail-[not an http url]", args: &data.CreateWebhookReq{UserID: 101, URL: "ftp://merchant.example.com/hooks"}, want: errors.New("url should be an http or https url")},
+		{Name: "case4: CreateWebhookReq fail-[no host]", args: &data.CreateWebhookReq{UserID: 101, URL: "https:///hooks"}, want: errors.New("url should be an http or https url")},
+		{Name: "case5: CreateWebhookReq fail-[unknown event type]", args: &data.CreateWebhookReq{UserID: 101, URL: "https://merchant.example.com/hooks", EventTypes: []string{"refund"}}, want: errors.New(`event_type "refund" is unknown`)},
+		{Name: "case6: CreateWebhookReq fail-[secret too short]", args: &data.CreateWebhookReq{UserID: 101, URL: "https://merchant.example.com/hooks", Secret: "short"}, want: errors.New("secret should have 16-128 characters")},
+	}
+	v := validator.NewValidatorSvc()
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			err := v.ValidatorCreateWebhookReq(tt.args)
+			if tt.want == nil && err != nil {
+				t.Errorf("ValidatorCreateWebhookReq()case:%s error = %v, wantErr %v", tt.Name, err, tt.want)
+			} else if tt.want != nil && err == nil {
+				t.Errorf("ValidatorCreateWebhookReq()case:%s error = %v, wantErr %v", tt.Name, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatorGetWebhookDeliveryListReq(t *testing.T) {
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	type args struct {
+		Name string
+		args *data.GetWebhookDeliveryListReq
+		want error
+	}
+	tests := []args{
+		{Name: "case1: GetWebhookDeliveryListReq success", args: &data.GetWebhookDeliveryListReq{UserID: 101, Limit: 10}, want: nil},
+		{Name: "case2: GetWebhookDeliveryListReq success-[status and before_id]", args: &data.GetWebhookDeliveryListReq{UserID: 101, Status: "dead", BeforeID: 20, Limit: 10}, want: nil},
+		{Name: "case3: GetWebhookDeliveryListReq fail-[user_id = 0]", args: &data.GetWebhookDeliveryListReq{UserID: 0, Limit: 10}, want: errors.New("user_id should > 0")},
+		{Name: "case4: GetWebhookDeliveryListReq fail-[unknown status]", args: &data.GetWebhookDeliveryListReq{UserID: 101, Status: "failed", Limit: 10}, want: errors.New("status should be pending, delivered or dead")},
+		{Name: "case5: GetWebhookDeliveryListReq fail-[limit > 100]", args: &data.GetWebhookDeliveryListReq{UserID: 101, Limit: 101}, want: errors.New("limit should <= 100")},
+	}
+	v := validator.NewValidatorSvc()
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			err := v.ValidatorGetWebhookDeliveryListReq(tt.args)
+			if tt.want == nil && err != nil {
+				t.Errorf("ValidatorGetWebhookDeliveryListReq()case:%s error = %v, wantErr %v", tt.Name, err, tt.want)
+			} else if tt.want != nil && err == nil {
+				t.Errorf("ValidatorGetWebhookDeliveryListReq()case:%s error = %v, wantErr %v", tt.Name, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatorRedeliverWebhookReq(t *testing.T) {
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	type args struct {
+		Name string
+		args *data.RedeliverWebhookReq
+		want error
+	}
+	tests := []args{
+		{Name: "case1: RedeliverWebhookReq success", args: &data.RedeliverWebhookReq{UserID: 101, DeliveryID: 1}, want: nil},
+		{Name: "case2: RedeliverWebhookReq fail-[user_id = 0]", args: &data.RedeliverWebhookReq{UserID: 0, DeliveryID: 1}, want: errors.New("user_id should > 0")},
+		{Name: "case3: RedeliverWebhookReq fail-[delivery_id = 0]", args: &data.RedeliverWebhookReq{UserID: 101, DeliveryID: 0}, want: errors.New("delivery_id should > 0")},
+	}
+	v := validator.NewValidatorSvc()
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			err := v.ValidatorRedeliverWebhookReq(tt.args)
+			if tt.want == nil && err != nil {
+				t.Errorf("ValidatorRedeliverWebhookReq()case:%s error = %v, wantErr %v", tt.Name, err, tt.want)
+			} else if tt.want != nil && err == nil {
+				t.Errorf("ValidatorRedeliverWebhookReq()case:%s error = %v, wantErr %v", tt.Name, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatorStreamReq(t *testing.T) {
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	type args struct {
+		Name string
+		args *data.StreamReq
+		want error
+	}
+	tests := []args{
+		{Name: "case1: StreamReq success", args: &data.StreamReq{UserID: 101}, want: nil},
+		{Name: "case2: StreamReq success-[last event id]", args: &data.StreamReq{UserID: 101, LastEventID: "djE6bjoxNzMwMjA0MzcyOjQ"}, want: nil},
+		{Name: "case3: StreamReq fail-[user_id = 0]", args: &data.StreamReq{UserID: 0}, want: errors.New("user_id should > 0")},
+		{Name: "case4: StreamReq fail-[last event id too long]", args: &data.StreamReq{UserID: 101, LastEventID: strings.Repeat("a", 129)}, want: errors.New("last_event_id too long")},
+	}
+	v := validator.NewValidatorSvc()
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			err := v.ValidatorStreamReq(tt.args)
+			if tt.want == nil && err != nil {
+				t.Errorf("ValidatorStreamReq()case:%s error = %v, wantErr %v", tt.Name, err, tt.want)
+			} else if tt.want != nil && err == nil {
+				t.Errorf("ValidatorStreamReq()case:%s error = %v, wantErr %v", tt.Name, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatorGetAuditLogListReq(t *testing.T) {
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	type args struct {
+		Name string
+		args *data.GetAuditLogListReq
+		want error
+	}
+	tests := []args{
+		{Name: "case1: GetAuditLogListReq success", args: &data.GetAuditLogListReq{Limit: 10}, want: nil},
+		{Name: "case2: GetAuditLogListReq success-[filters]", args: &data.GetAuditLogListReq{Actor: "alice", Action: "reconcile.fix", TargetType: "wallet", TargetID: 1, StartTime: 1, EndTime: 2, Limit: 10}, want: nil},
+		{Name: "case3: GetAuditLogListReq fail-[target_id without target_type]", args: &data.GetAuditLogListReq{TargetID: 1, Limit: 10}, want: errors.New("target_id needs target_type")},
+		{Name: "case4: GetAuditLogListReq fail-[start_time >= end_time]", args: &data.GetAuditLogListReq{StartTime: 2, EndTime: 2, Limit: 10}, want: errors.New("start_time should < end_time")},
+		{Name: "case5: GetAuditLogListReq fail-[limit = 0]", args: &data.GetAuditLogListReq{Limit: 0}, want: errors.New("limit should > 0")},
+		{Name: "case6: GetAuditLogListReq fail-[limit > 100]", args: &data.GetAuditLogListReq{Limit: 101}, want: errors.New("limit should <= 100")},
+		{Name: "case7: GetAuditLogListReq fail-[cursor too long]", args: &data.GetAuditLogListReq{Cursor: strings.Repeat("a", 129), Limit: 10}, want: errors.New("cursor too long")},
+	}
+	v := validator.NewValidatorSvc()
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			err := v.ValidatorGetAuditLogListReq(tt.args)
+			if tt.want == nil && err != nil {
+				t.Errorf("ValidatorGetAuditLogListReq()case:%s error = %v, wantErr %v", tt.Name, err, tt.want)
+			} else if tt.want != nil && err == nil {
+				t.Errorf("ValidatorGetAuditLogListReq()case:%s error = %v, wantErr %v", tt.Name, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatorAdjustReq(t *testing.T) {
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	type args struct {
+		Name string
+		args *data.AdjustReq
+		want error
+	}
+	tests := []args{
+		{Name: "case1: AdjustReq success", args: &data.AdjustReq{OrderID: "adj-1", UserID: 101, Direction: "credit", Amount: 10, ReasonCode: "incident", Reason: "double debit"}, want: nil},
+		{Name: "case2: AdjustReq success-[shared wallet]", args: &data.AdjustReq{OrderID: "adj-1", OrgID: 1, Pocket: "ops", Direction: "debit", Amount: 10, ReasonCode: "chargeback"}, want: nil},
+		{Name: "case3: AdjustReq fail-[no order_id]", args: &data.AdjustReq{UserID: 101, Direction: "credit", Amount: 10, ReasonCode: "incident"}, want: errors.New("order_id is required")},
+		{Name: "case4: AdjustReq fail-[user_id and org_id]", args: &data.AdjustReq{OrderID: "adj-1", UserID: 101, OrgID: 1, Direction: "credit", Amount: 10, ReasonCode: "incident"}, want: errors.New("either user_id or org_id is required")},
+		{Name: "case5: AdjustReq fail-[unknown direction]", args: &data.AdjustReq{OrderID: "adj-1", UserID: 101, Direction: "up", Amount: 10, ReasonCode: "incident"}, want: errors.New("direction should be credit or debit")},
+		{Name: "case6: AdjustReq fail-[no reason_code]", args: &data.AdjustReq{OrderID: "adj-1", UserID: 101, Direction: "credit", Amount: 10}, want: errors.New(`reason_code "" is unknown`)},
+		{Name: "case7: AdjustReq fail-[amount < 1e-8]", args: &data.AdjustReq{OrderID: "adj-1", UserID: 101, Direction: "credit", Amount: 0, ReasonCode: "incident"}, want: errors.New("amount must >= 1e-8")},
+		{Name: "case8: AdjustReq fail-[reason too long]", args: &data.AdjustReq{OrderID: "adj-1", UserID: 101, Direction: "credit", Amount: 10, ReasonCode: "incident", Reason: strings.Repeat("r", 201)}, want: errors.New("reason should <= 200 bytes")},
+	}
+	v := validator.NewValidatorSvc()
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			err := v.ValidatorAdjustReq(tt.args)
+			if tt.want == nil && err != nil {
+				t.Errorf("ValidatorAdjustReq()case:%s error = %v, wantErr %v", tt.Name, err, tt.want)
+			} else if tt.want != nil && err == nil {
+				t.Errorf("ValidatorAdjustReq()case:%s error = %v, wantErr %v", tt.Name, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatorReviewAdjustmentReq(t *testing.T) {
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	type args struct {
+		Name string
+		args *data.ReviewAdjustmentReq
+		want error
+	}
+	tests := []args{
+		{Name: "case1: ReviewAdjustmentReq success", args: &data.ReviewAdjustmentReq{OrderID: "adj-1", UserID: 101}, want: nil},
+		{Name: "case2: ReviewAdjustmentReq success-[shared wallet]", args: &data.ReviewAdjustmentReq{OrderID: "adj-1", OrgID: 1}, want: nil},
+		{Name: "case3: ReviewAdjustmentReq fail-[no order_id]", args: &data.ReviewAdjustmentReq{UserID: 101}, want: errors.New("order_id is required")},
+		{Name: "case4: ReviewAdjustmentReq fail-[neither user_id nor org_id]", args: &data.ReviewAdjustmentReq{OrderID: "adj-1"}, want: errors.New("either user_id or org_id is required")},
+	}
+	v := validator.NewValidatorSvc()
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			err := v.ValidatorReviewAdjustmentReq(tt.args)
+			if tt.want == nil && err != nil {
+				t.Errorf("ValidatorReviewAdjustmentReq()case:%s error = %v, wantErr %v", tt.Name, err, tt.want)
+			} else if tt.want != nil && err == nil {
+				t.Errorf("ValidatorReviewAdjustmentReq()case:%s error = %v, wantErr %v", tt.Name, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatorSetWalletTermsReq(t *testing.T) {
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	type args struct {
+		Name string
+		args *data.SetWalletTermsReq
+		want error
+	}
+	tests := []args{
+		{Name: "case1: SetWalletTermsReq success", args: &data.SetWalletTermsReq{UserID: 101, CreditLimit: 500.00, Product: "savings", Reason: "ticket 42"}, want: nil},
+		{Name: "case2: SetWalletTermsReq success-[shared wallet, no product]", args: &data.SetWalletTermsReq{OrgID: 1, Reason: "ticket 42"}, want: nil},
+		{Name: "case3: SetWalletTermsReq fail-[neither user_id nor org_id]", args: &data.SetWalletTermsReq{CreditLimit: 500.00, Reason: "ticket 42"}, want: errors.New("either user_id or org_id is required")},
+		{Name: "case4: SetWalletTermsReq fail-[negative credit_limit]", args: &data.SetWalletTermsReq{UserID: 101, CreditLimit: -1, Reason: "ticket 42"}, want: errors.New("credit_limit should >= 0")},
+		{Name: "case5: SetWalletTermsReq fail-[bad product]", args: &data.SetWalletTermsReq{UserID: 101, Product: "savings plan", Reason: "ticket 42"}, want: errors.New("product should be 1-32 letters, digits, '_' or '-'")},
+		{Name: "case6: SetWalletTermsReq fail-[no reason]", args: &data.SetWalletTermsReq{UserID: 101, CreditLimit: 500.00}, want: errors.New("reason should be 1-200 bytes")},
+	}
+	v := validator.NewValidatorSvc()
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			err := v.ValidatorSetWalletTermsReq(tt.args)
+			if tt.want == nil && err != nil {
+				t.Errorf("ValidatorSetWalletTermsReq()case:%s error = %v, wantErr %v", tt.Name, err, tt.want)
+			} else if tt.want != nil && err == nil {
+				t.Errorf("ValidatorSetWalletTermsReq()case:%s error = %v, wantErr %v", tt.Name, err, tt.want)
+			}
+		})
+	}
+}
@@ -2,11 +2,154 @@ package data
 
 const LogIdParam string = "logId"
 
-// 0: undefine 1: deposit, 2: withdrawal, 3: transfer in, 4: transfer out
+// 0: undefine 1: deposit, 2: withdrawal, 3: transfer in, 4: transfer out, 5: interest, 6: pocket move in, 7: pocket move out,
+// 8: adjustment credit, 9: adjustment debit, 10: transfer refund
 const (
 	TxTypeUnknown     int32 = 0
 	TxTypeDeposit     int32 = 1
 	TxTypeWithdraw    int32 = 2
 	TxTypeTransferIn  int32 = 3
 	TxTypeTransferOut int32 = 4
+	TxTypeInterest    int32 = 5
+	TxTypePocketIn    int32 = 6 // moves between pockets of the same user, never count as transfers
+	TxTypePocketOut   int32 = 7
+	TxTypeAdjustIn    int32 = 8 // manual adjustments of the admins, posted once another admin approved them
+	TxTypeAdjustOut   int32 = 9
+	TxTypeRefund      int32 = 10 // credits back the sender of a transfer between shards whose recipient could not be credited
 )
+
+// every user has a main pocket, it is used when no pocket is given
+const DefaultPocket string = "main"
+
+// member roles of an organization, each role includes the rights of the roles below it
+const (
+	OrgRoleViewer  string = "viewer"  // read balance and transactions of the shared wallets
+	OrgRoleSpender string = "spender" // deposit, and debit up to the daily spending limit
+	OrgRoleOwner   string = "owner"   // debit without limit and manage members
+)
+
+// OrgRoleRank orders the member roles, unknown roles rank 0
+func OrgRoleRank(role string) int {
+	switch role {
+	case OrgRoleViewer:
+		return 1
+	case OrgRoleSpender:
+		return 2
+	case OrgRoleOwner:
+		return 3
+	}
+	return 0
+}
+
+// status of transfers waiting for approvals
+const (
+	ApprovalStatusPending  int32 = 0
+	ApprovalStatusExecuted int32 = 1
+	ApprovalStatusExpired  int32 = 2
+)
+
+// status of transfers to a user on another shard
+const (
+	ShardTransferStatusDebited  int32 = 0
+	ShardTransferStatusCredited int32 = 1
+	ShardTransferStatusRefunded int32 = 2 // the credit failed for good, the sender was credited back
+)
+
+// status of the events in the outbox
+const (
+	OutboxStatusPending int32 = 0
+	OutboxStatusSent    int32 = 1
+)
+
+// types of the wallet events published from the outbox
+const (
+	EventTypeDeposit          string = "deposit"
+	EventTypeWithdraw         string = "withdraw"
+	EventTypeTransfer         string = "transfer"
+	EventTypeTransferRefund   string = "transfer_refund"
+	EventTypeAdjustmentCredit string = "adjustment_credit"
+	EventTypeAdjustmentDebit  string = "adjustment_debit"
+	EventTypeInterest         string = "interest"
+	EventTypeMove             string = "move"
+)
+
+// status of the deliveries of wallet events to webhooks
+const (
+	WebhookDeliveryPending   int32 = 0
+	WebhookDeliveryDelivered int32 = 1
+	WebhookDeliveryDead      int32 = 2 // gave up after the last retry, until redelivered
+)
+
+// WebhookDeliveryStatusNames names the status of the deliveries in the api
+var WebhookDeliveryStatusNames = map[int32]string{
+	WebhookDeliveryPending:   "pending",
+	WebhookDeliveryDelivered: "delivered",
+	WebhookDeliveryDead:      "dead",
+}
+
+// status of the manual balance adjustments
+const (
+	AdjustmentStatusPending  int32 = 0
+	AdjustmentStatusPosted   int32 = 1
+	AdjustmentStatusRejected int32 = 2
+)
+
+// AdjustmentStatusNames names the status of the adjustments in the audit trail
+var AdjustmentStatusNames = map[int32]string{
+	AdjustmentStatusPending:  "pending",
+	AdjustmentStatusPosted:   "posted",
+	AdjustmentStatusRejected: "rejected",
+}
+
+// directions of the manual balance adjustments
+const (
+	AdjustDirectionCredit string = "credit"
+	AdjustDirectionDebit  string = "debit"
+)
+
+// AdjustReasonCodes are the reasons a balance may be adjusted for, one is mandatory
+var AdjustReasonCodes = map[string]string{
+	"incident":   "fix of a balance left wrong by an incident",
+	"duplicate":  "reversal of an operation applied twice",
+	"chargeback": "chargeback of a card payment",
+	"fee_refund": "refund of a fee charged in error",
+	"goodwill":   "goodwill credit",
+}
+
+// actions of the admin audit trail
+const (
+	AuditActionReconcileFix  string = "reconcile.fix"      // a drifted balance set to the one rebuilt from the transactions
+	AuditActionAdjustCreate  string = "adjustment.create"  // a balance adjustment waiting for approval
+	AuditActionAdjustApprove string = "adjustment.approve" // approved and posted to the wallet
+	AuditActionAdjustReject  string = "adjustment.reject"
+	AuditActionWalletTerms   string = "wallet.terms" // credit limit and interest product of a wallet set
+)
+
+// what the actions of the admin audit trail act on
+const (
+	AuditTargetWallet     string = "wallet"
+	AuditTargetAdjustment string = "adjustment"
+)
+
+// events of the balance stream
+const (
+	StreamEventBalance     string = "balance"
+	StreamEventTransaction string = "transaction"
+)
+
+// approval policy of organizations created without one
+const (
+	DefaultRequiredApprovals    int32 = 1
+	DefaultApprovalExpireSecond int64 = 86400
+)
+
+// TxTypeSign returns 1 for tx types crediting the wallet, -1 for debits and 0 otherwise
+func TxTypeSign(txType int32) int {
+	switch txType {
+	case TxTypeDeposit, TxTypeTransferIn, TxTypeInterest, TxTypePocketIn, TxTypeAdjustIn, TxTypeRefund:
+		return 1
+	case TxTypeWithdraw, TxTypeTransferOut, TxTypePocketOut, TxTypeAdjustOut:
+		return -1
+	}
+	return 0
+}
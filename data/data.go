@@ -1,9 +1,13 @@
 package data
 
+import "encoding/json"
+
 type DepositReq struct {
 	OrderID string  `json:"order_id"`
 	UserID  int64   `json:"user_id"`
 	Amount  float64 `json:"amount"`
+	Pocket  string  `json:"pocket"` // optional, default main
+	OrgID   int64   `json:"org_id"` // optional, use the shared wallet of the org, user_id is the acting member
 }
 type CommRsp struct {
 	Code    int32  `json:"code"`
@@ -15,6 +19,8 @@ type WithdrawReq struct {
 	OrderID string  `json:"order_id"`
 	UserID  int64   `json:"user_id"`
 	Amount  float64 `json:"amount"`
+	Pocket  string  `json:"pocket"` // optional, default main
+	OrgID   int64   `json:"org_id"` // optional, use the shared wallet of the org, user_id is the acting member
 }
 
 type TransferReq struct {
@@ -22,10 +28,83 @@ type TransferReq struct {
 	FromUserID int64   `json:"from_user_id"`
 	ToUserID   int64   `json:"to_user_id"`
 	Amount     float64 `json:"amount"`
+	OrgID      int64   `json:"org_id"` // optional, send from the shared wallet of the org, from_user_id is the acting member
+}
+
+type MoveReq struct {
+	OrderID    string  `json:"order_id"`
+	UserID     int64   `json:"user_id"`
+	FromPocket string  `json:"from_pocket"`
+	ToPocket   string  `json:"to_pocket"`
+	Amount     float64 `json:"amount"`
+}
+
+type PayInterestReq struct {
+	OrderID    string  `json:"order_id"`
+	UserID     int64   `json:"user_id"`
+	WalletID   int64   `json:"wallet_id"`
+	Amount     float64 `json:"amount"`
+	PeriodFrom int32   `json:"period_from"` // first accrual date, yyyymmdd
+	PeriodTo   int32   `json:"period_to"`   // last accrual date, yyyymmdd
+}
+
+type CreateOrgReq struct {
+	UserID               int64  `json:"user_id"` // becomes the first owner
+	Name                 string `json:"name"`
+	RequiredApprovals    int32  `json:"required_approvals"`     // optional, approvals needed above the spending limit, default 1
+	ApprovalExpireSecond int64  `json:"approval_expire_second"` // optional, default 86400
+}
+type CreateOrgRsp struct {
+	Code    int32             `json:"code"`
+	Message string            `json:"message"`
+	Data    *CreateOrgRspData `json:"data"`
+	LogID   string            `json:"log_id"`
+}
+type CreateOrgRspData struct {
+	OrgID    int64 `json:"org_id"`
+	WalletID int64 `json:"wallet_id"` // main pocket of the shared wallet
+}
+
+type SetOrgMemberReq struct {
+	OrgID         int64   `json:"org_id"`
+	UserID        int64   `json:"user_id"` // acting owner
+	MemberUserID  int64   `json:"member_user_id"`
+	Role          string  `json:"role"`           // owner, spender or viewer
+	SpendingLimit float64 `json:"spending_limit"` // daily limit of a spender
+}
+
+type ApproveTransferReq struct {
+	OrgID   int64  `json:"org_id"`
+	UserID  int64  `json:"user_id"`  // approving member
+	OrderID string `json:"order_id"` // order id of the pending transfer
+}
+
+type GetApprovalListReq struct {
+	OrgID  int64 `json:"org_id"`
+	UserID int64 `json:"user_id"`
+}
+type GetApprovalListRsp struct {
+	Code    int32                   `json:"code"`
+	Message string                  `json:"message"`
+	Data    *GetApprovalListRspData `json:"data"`
+	LogID   string                  `json:"log_id"`
+}
+type GetApprovalListRspData struct {
+	Items []*GetApprovalListRspDataItem `json:"items"`
+}
+type GetApprovalListRspDataItem struct {
+	OrderID           string  `json:"order_id"`
+	RequesterUserID   int64   `json:"requester_user_id"`
+	ToUserID          int64   `json:"to_user_id"`
+	Amount            float64 `json:"amount"`
+	RequiredApprovals int32   `json:"required_approvals"`
+	ApproverUserIDs   []int64 `json:"approver_user_ids"`
+	ExpireAt          string  `json:"expire_at"`
 }
 
 type GetBalanceReq struct {
 	UserID int64 `json:"user_id"`
+	OrgID  int64 `json:"org_id"` // optional, balance of the shared wallets of the org
 }
 type GetBalanceRsp struct {
 	Code    int32              `json:"code"`
@@ -34,13 +113,36 @@ type GetBalanceRsp struct {
 	LogID   string             `json:"log_id"`
 }
 type GetBalanceRspData struct {
-	Balance float64 `json:"balance"`
+	Balance          float64                    `json:"balance"` // balance of the main pocket
+	CreditLimit      float64                    `json:"credit_limit"`
+	Held             float64                    `json:"held"`              // held by transfers pending approval
+	AvailableBalance float64                    `json:"available_balance"` // balance + credit_limit - held
+	Pockets          []*GetBalanceRspDataPocket `json:"pockets"`
+}
+type GetBalanceRspDataPocket struct {
+	WalletID         int64   `json:"wallet_id"`
+	Name             string  `json:"name"`
+	Balance          float64 `json:"balance"`
+	CreditLimit      float64 `json:"credit_limit"`
+	Held             float64 `json:"held"`
+	AvailableBalance float64 `json:"available_balance"`
 }
 
 type GetTransactionHistoryReq struct {
-	UserID int64 `json:"user_id"`
-	Page   int32 `json:"page"`
-	Limit  int32 `json:"limit"`
+	UserID int64  `json:"user_id"`
+	Cursor string `json:"cursor"` // optional, next_cursor or prev_cursor of the last page, empty for the first page
+	Page   int32  `json:"page"`   // Deprecated: offset paging, only used when > 0, use Cursor instead
+	Limit  int32  `json:"limit"`
+	Pocket string `json:"pocket"` // optional, empty means all pockets
+	OrgID  int64  `json:"org_id"` // optional, history of a shared pocket of the org, default main
+	// optional filters, zero values don't filter
+	TxTypes       []int32 `json:"tx_types"`        // any of these tx types
+	StartTime     int64   `json:"start_time"`      // unix second, created_at >= start_time
+	EndTime       int64   `json:"end_time"`        // unix second, created_at < end_time
+	MinAmount     float64 `json:"min_amount"`      // amount >= min_amount
+	MaxAmount     float64 `json:"max_amount"`      // amount <= max_amount
+	RelatedUserID int64   `json:"related_user_id"` // counterparty of transfers
+	OrderIDPrefix string  `json:"order_id_prefix"`
 }
 type GetTransactionHistoryRsp struct {
 	Code    int32                         `json:"code"`
@@ -49,13 +151,156 @@ type GetTransactionHistoryRsp struct {
 	LogID   string                        `json:"log_id"`
 }
 type GetTransactionHistoryRspData struct {
-	Items []*GetTransactionHistoryRspDataItem `json:"items"`
+	Total      int64                               `json:"total"`       // transactions matching the filters, on all pages
+	Items      []*GetTransactionHistoryRspDataItem `json:"items"`       // ordered by (created_at, id)
+	NextCursor string                              `json:"next_cursor"` // cursor of the later items, empty on the last page
+	PrevCursor string                              `json:"prev_cursor"` // cursor of the earlier items, empty on the first page
 }
 type GetTransactionHistoryRspDataItem struct {
 	OrderID       string  `json:"order_id"`
 	UserID        int64   `json:"user_id"`
-	TxType        int32   `json:"tx_type"` //0: undefine 1: deposit, 2: withdrawal, 3: transfer in, 4: transfer out
+	WalletID      int64   `json:"wallet_id"`
+	TxType        int32   `json:"tx_type"` //0: undefine 1: deposit, 2: withdrawal, 3: transfer in, 4: transfer out, 5: interest, 6: pocket move in, 7: pocket move out
 	Amount        float64 `json:"amount"`
 	RelatedUserID int64   `json:"related_user_id"`
+	ActorUserID   int64   `json:"actor_user_id"`
 	CreatedAt     string  `json:"created_at"`
 }
+
+type CreateWebhookReq struct {
+	UserID     int64    `json:"user_id"`
+	URL        string   `json:"url"`         // http or https url the events are posted to
+	EventTypes []string `json:"event_types"` // optional, deposit, withdraw and transfer, default all
+	Secret     string   `json:"secret"`      // optional, hmac-sha256 key of the signatures, default a random one
+}
+type CreateWebhookRsp struct {
+	Code    int32                 `json:"code"`
+	Message string                `json:"message"`
+	Data    *CreateWebhookRspData `json:"data"`
+	LogID   string                `json:"log_id"`
+}
+type CreateWebhookRspData struct {
+	SubscriptionID int64  `json:"subscription_id"`
+	Secret         string `json:"secret"` // only returned here, keep it to verify the signatures
+}
+
+type GetWebhookDeliveryListReq struct {
+	UserID   int64  `json:"user_id"`
+	Status   string `json:"status"`    // optional, pending, delivered or dead, default all
+	BeforeID int64  `json:"before_id"` // optional, list the deliveries older than this one
+	Limit    int32  `json:"limit"`
+}
+type GetWebhookDeliveryListRsp struct {
+	Code    int32                          `json:"code"`
+	Message string                         `json:"message"`
+	Data    *GetWebhookDeliveryListRspData `json:"data"`
+	LogID   string                         `json:"log_id"`
+}
+type GetWebhookDeliveryListRspData struct {
+	Items    []*GetWebhookDeliveryListRspDataItem `json:"items"`     // newest first
+	BeforeID int64                                `json:"before_id"` // before_id of the next page, 0 on the last page
+}
+type GetWebhookDeliveryListRspDataItem struct {
+	DeliveryID     int64  `json:"delivery_id"`
+	SubscriptionID int64  `json:"subscription_id"`
+	EventID        string `json:"event_id"`
+	EventType      string `json:"event_type"`
+	Payload        string `json:"payload"`
+	Status         string `json:"status"` // pending, delivered or dead
+	Attempts       int32  `json:"attempts"`
+	NextAttemptAt  string `json:"next_attempt_at"`
+	LastStatusCode int32  `json:"last_status_code"`
+	LastError      string `json:"last_error"`
+	CreatedAt      string `json:"created_at"`
+}
+
+type RedeliverWebhookReq struct {
+	UserID     int64 `json:"user_id"`
+	DeliveryID int64 `json:"delivery_id"`
+}
+
+type AdjustReq struct {
+	OrderID    string  `json:"order_id"`
+	UserID     int64   `json:"user_id"`   // owner of the wallet, or 0 with org_id
+	OrgID      int64   `json:"org_id"`    // optional, the shared wallet of the organization
+	Pocket     string  `json:"pocket"`    // optional, main by default
+	Direction  string  `json:"direction"` // credit or debit
+	Amount     float64 `json:"amount"`
+	ReasonCode string  `json:"reason_code"` // incident, duplicate, chargeback, fee_refund or goodwill
+	Reason     string  `json:"reason"`      // optional, free text
+}
+
+type ReviewAdjustmentReq struct {
+	OrderID string `json:"order_id"`
+	UserID  int64  `json:"user_id"` // the owner of the wallet adjusted, or 0 with org_id
+	OrgID   int64  `json:"org_id"`
+}
+
+type SetWalletTermsReq struct {
+	UserID      int64   `json:"user_id"` // owner of the wallet, or 0 with org_id
+	OrgID       int64   `json:"org_id"`  // optional, the shared wallet of the organization
+	Pocket      string  `json:"pocket"`  // optional, main by default
+	CreditLimit float64 `json:"credit_limit"`
+	Product     string  `json:"product"` // interest product, a key of interest.products, empty for none
+	Reason      string  `json:"reason"`
+}
+
+type GetAuditLogListReq struct {
+	Actor      string `json:"actor"`       // optional
+	Action     string `json:"action"`      // optional, e.g. reconcile.fix
+	TargetType string `json:"target_type"` // optional, e.g. wallet
+	TargetID   int64  `json:"target_id"`   // optional, with target_type
+	StartTime  int64  `json:"start_time"`  // optional, unix second, inclusive
+	EndTime    int64  `json:"end_time"`    // optional, unix second, exclusive
+	Cursor     string `json:"cursor"`      // optional, next_cursor of the page before
+	Limit      int32  `json:"limit"`
+}
+type GetAuditLogListRsp struct {
+	Code    int32                   `json:"code"`
+	Message string                  `json:"message"`
+	Data    *GetAuditLogListRspData `json:"data"`
+	LogID   string                  `json:"log_id"`
+}
+type GetAuditLogListRspData struct {
+	Items      []*GetAuditLogListRspDataItem `json:"items"`       // newest first
+	NextCursor string                        `json:"next_cursor"` // empty on the last page
+}
+type GetAuditLogListRspDataItem struct {
+	Shard      int             `json:"shard"` // ids are per shard
+	ID         int64           `json:"id"`
+	Actor      string          `json:"actor"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetID   int64           `json:"target_id"`
+	Before     json.RawMessage `json:"before"` // the target before the action, null when it did not exist
+	After      json.RawMessage `json:"after"`
+	Reason     string          `json:"reason"`
+	LogID      string          `json:"log_id"`
+	SourceIP   string          `json:"source_ip"`
+	CreatedAt  string          `json:"created_at"`
+}
+
+type StreamReq struct {
+	UserID      int64  `json:"user_id"`
+	LastEventID string `json:"last_event_id"` // optional, id of the last event received, the stream resumes after it
+}
+
+// StreamEvent is one server-sent event of a stream, an empty Event is a heartbeat
+type StreamEvent struct {
+	ID    string `json:"id"`    // cursor of the newest transaction sent so far
+	Event string `json:"event"` // balance or transaction
+	Data  any    `json:"data"`  // GetBalanceRspData or GetTransactionHistoryRspDataItem
+}
+
+// WalletEvent is the event published for a committed deposit, withdrawal or transfer
+type WalletEvent struct {
+	EventID     string  `json:"event_id"`   // unique, consumers drop the events they already handled
+	EventType   string  `json:"event_type"` // deposit, withdraw or transfer
+	OrderID     string  `json:"order_id"`
+	UserID      int64   `json:"user_id"` // owner of the wallet, the sender of a transfer
+	WalletID    int64   `json:"wallet_id"`
+	Amount      float64 `json:"amount"`
+	ToUserID    int64   `json:"to_user_id,omitempty"` // recipient of a transfer
+	ActorUserID int64   `json:"actor_user_id"`
+	CreatedAt   int64   `json:"created_at"` // unix second
+}
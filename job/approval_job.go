@@ -0,0 +1,53 @@
+package job
+
+import (
+	"context"
+	"log"
+	"simplewallet/config"
+	"simplewallet/service"
+	"simplewallet/util"
+	"simplewallet/util/db"
+	"time"
+)
+
+// ApprovalJob expires transfers whose approval time is over on every tick, releasing their holds.
+type ApprovalJob struct {
+	conf *config.ApprovalConf
+}
+
+func NewApprovalJob(conf *config.ApprovalConf) *ApprovalJob {
+	return &ApprovalJob{conf: conf}
+}
+
+func (j *ApprovalJob) Start(ctx context.Context) {
+	interval := time.Duration(j.conf.IntervalSecond) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			j.RunOnce(ctx, time.Now())
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (j *ApprovalJob) RunOnce(ctx context.Context, now time.Time) {
+	logID := util.Uniqid()
+	newLocker := func(key string) util.DistributedLock {
+		return util.NewDistributedLock(ctx, db.GetRedisClient(), logID, key, 5)
+	}
+	expired, err := service.NewApprovalService(ctx, logID, db.GetDbClient(), newLocker).ExpireDue(now)
+	if err != nil {
+		log.Printf("%s|fail to expire approvals:%s\n", logID, err.Error())
+	}
+	if expired > 0 {
+		log.Printf("%s|%d approvals expired\n", logID, expired)
+	}
+}
@@ -0,0 +1,59 @@
+package job
+
+import (
+	"context"
+	"log"
+	"simplewallet/config"
+	"simplewallet/service"
+	"simplewallet/util"
+	"simplewallet/util/db"
+	"time"
+)
+
+// ArchiveJob creates the partitions of transactions ahead of time and moves the transactions older than
+// db.archive_retention_days to the archive on every tick.
+type ArchiveJob struct {
+	conf *config.ArchiveConf
+}
+
+func NewArchiveJob(conf *config.ArchiveConf) *ArchiveJob {
+	return &ArchiveJob{conf: conf}
+}
+
+func (j *ArchiveJob) Start(ctx context.Context) {
+	interval := time.Duration(j.conf.IntervalSecond) * time.Second
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			j.RunOnce(ctx, time.Now())
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (j *ArchiveJob) RunOnce(ctx context.Context, now time.Time) {
+	logID := util.Uniqid()
+	timeout := time.Duration(j.conf.TimeoutSecond) * time.Second
+	if timeout <= 0 {
+		timeout = service.DefaultArchiveTimeout
+	}
+	// moving a month of transactions takes a while, one instance at a time: the lock outlives a run
+	// whose two db transactions on every shard take their whole timeout
+	expire := int(2*timeout/time.Second)*len(db.GetShardClients()) + 60
+	locker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, "archive:transactions", expire)
+	moved, err := service.NewArchiveService(ctx, logID, db.GetDbClient(), db.GetArchiveRetention(), timeout, locker).Run(now)
+	if err != nil {
+		log.Printf("%s|fail to archive transactions:%s\n", logID, err.Error())
+	}
+	if moved > 0 {
+		log.Printf("%s|%d transactions archived\n", logID, moved)
+	}
+}
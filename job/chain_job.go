@@ -0,0 +1,53 @@
+package job
+
+import (
+	"context"
+	"log"
+	"simplewallet/config"
+	"simplewallet/service"
+	"simplewallet/util"
+	"simplewallet/util/db"
+	"time"
+)
+
+// ChainJob appends the heads of the transaction chains that moved to the anchor file on every tick.
+type ChainJob struct {
+	conf  *config.ChainConf
+	index *service.AnchorIndex // kept across ticks, a tick reads only the lines appended since
+}
+
+func NewChainJob(conf *config.ChainConf) *ChainJob {
+	return &ChainJob{conf: conf, index: service.NewAnchorIndex(conf.AnchorFile)}
+}
+
+func (j *ChainJob) Start(ctx context.Context) {
+	interval := time.Duration(j.conf.IntervalSecond) * time.Second
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			j.RunOnce(ctx, time.Now())
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (j *ChainJob) RunOnce(ctx context.Context, now time.Time) {
+	logID := util.Uniqid()
+	// the file is appended to by one instance at a time
+	locker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, "chain:anchor", 300)
+	n, err := service.NewChainService(ctx, logID, db.GetDbClient(), j.conf.BatchSize, locker).Anchor(j.index, now)
+	if err != nil {
+		log.Printf("%s|fail to anchor the transaction chains:%s\n", logID, err.Error())
+	}
+	if n > 0 {
+		log.Printf("%s|%d chain heads anchored\n", logID, n)
+	}
+}
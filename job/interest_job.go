@@ -0,0 +1,65 @@
+package job
+
+import (
+	"context"
+	"log"
+	"simplewallet/config"
+	"simplewallet/service"
+	"simplewallet/util"
+	"simplewallet/util/db"
+	"time"
+)
+
+// InterestJob accrues yesterday's interest on every tick and pays out the previous
+// month once per process; both steps are idempotent, so restarts simply rerun them.
+type InterestJob struct {
+	conf       *config.InterestConf
+	paidPeriod string
+}
+
+func NewInterestJob(conf *config.InterestConf) *InterestJob {
+	return &InterestJob{conf: conf}
+}
+
+func (j *InterestJob) Start(ctx context.Context) {
+	interval := time.Duration(j.conf.IntervalSecond) * time.Second
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			j.RunOnce(ctx, time.Now())
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (j *InterestJob) RunOnce(ctx context.Context, now time.Time) {
+	logID := util.Uniqid()
+	newLocker := func(key string) util.DistributedLock {
+		return util.NewDistributedLock(ctx, db.GetRedisClient(), logID, key, 5)
+	}
+	s := service.NewInterestService(ctx, logID, db.GetDbClient(), j.conf.Products, newLocker)
+
+	if err := s.AccrueDay(now.AddDate(0, 0, -1)); err != nil {
+		log.Printf("%s|fail to accrue interest:%s\n", logID, err.Error())
+		return
+	}
+	prevMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()).AddDate(0, -1, 0)
+	period := prevMonth.Format("200601")
+	if j.paidPeriod == period {
+		return
+	}
+	if err := s.PayoutMonth(prevMonth); err != nil {
+		log.Printf("%s|fail to pay interest of %s:%s\n", logID, period, err.Error())
+		return
+	}
+	j.paidPeriod = period
+	log.Printf("%s|interest of %s paid\n", logID, period)
+}
@@ -0,0 +1,80 @@
+package job
+
+import (
+	"context"
+	"log"
+	"os"
+	"simplewallet/config"
+	"simplewallet/service"
+	"simplewallet/util"
+	"simplewallet/util/db"
+	"time"
+)
+
+// OutboxJob relays the wallet events of the outbox to the configured publisher on every tick, and to the
+// webhook subscriptions too when webhooks are on.
+type OutboxJob struct {
+	conf     *config.OutboxConf
+	webhooks bool
+}
+
+func NewOutboxJob(conf *config.OutboxConf, webhooks bool) *OutboxJob {
+	return &OutboxJob{conf: conf, webhooks: webhooks}
+}
+
+func (j *OutboxJob) newPublisher(ctx context.Context, logID string) service.Publisher {
+	publisher := newPublisher(j.conf)
+	if !j.webhooks {
+		return publisher
+	}
+	// fanned out to the webhook subscriptions first, posted by the webhook job; an event published again
+	// records no second delivery
+	return service.NewMultiPublisher(service.NewWebhookPublisher(ctx, logID, db.GetDbClient()), publisher)
+}
+
+func newPublisher(conf *config.OutboxConf) service.Publisher {
+	switch conf.Publisher {
+	case "file":
+		return service.NewFilePublisher(conf.FilePath)
+	case "http":
+		timeout := time.Duration(conf.TimeoutMs) * time.Millisecond
+		if timeout <= 0 {
+			timeout = 5 * time.Second
+		}
+		return service.NewHTTPPublisher(conf.URL, timeout)
+	default:
+		return service.NewWriterPublisher(os.Stdout)
+	}
+}
+
+func (j *OutboxJob) Start(ctx context.Context) {
+	interval := time.Duration(j.conf.IntervalSecond) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			j.RunOnce(ctx, time.Now())
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (j *OutboxJob) RunOnce(ctx context.Context, now time.Time) {
+	logID := util.Uniqid()
+	// one relay at a time, or events get published twice and out of order
+	locker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, "outbox:relay", 60)
+	sent, err := service.NewOutboxRelay(ctx, logID, db.GetDbClient(), j.newPublisher(ctx, logID), j.conf.BatchSize, locker).Run()
+	if err != nil {
+		log.Printf("%s|fail to relay outbox events:%s\n", logID, err.Error())
+	}
+	if sent > 0 {
+		log.Printf("%s|%d outbox events published\n", logID, sent)
+	}
+}
@@ -0,0 +1,58 @@
+package job
+
+import (
+	"context"
+	"log"
+	"simplewallet/config"
+	"simplewallet/service"
+	"simplewallet/util"
+	"simplewallet/util/db"
+	"time"
+)
+
+// ShardTransferJob credits the transfers between shards left debited by a crash or a failed credit on every tick,
+// and refunds the ones whose credit kept failing.
+type ShardTransferJob struct {
+	conf *config.ShardTransferConf
+}
+
+func NewShardTransferJob(conf *config.ShardTransferConf) *ShardTransferJob {
+	return &ShardTransferJob{conf: conf}
+}
+
+func (j *ShardTransferJob) Start(ctx context.Context) {
+	interval := time.Duration(j.conf.IntervalSecond) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			j.RunOnce(ctx, time.Now())
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (j *ShardTransferJob) RunOnce(ctx context.Context, now time.Time) {
+	logID := util.Uniqid()
+	newLocker := func(key string) util.DistributedLock {
+		return util.NewDistributedLock(ctx, db.GetRedisClient(), logID, key, 5)
+	}
+	transferService := service.NewShardTransferService(ctx, logID, db.GetDbClient(), newLocker)
+	if j.conf.MaxAttempts > 0 {
+		transferService.WithMaxAttempts(j.conf.MaxAttempts)
+	}
+	resumed, err := transferService.ResumeDue(now)
+	if err != nil {
+		log.Printf("%s|fail to resume shard transfers:%s\n", logID, err.Error())
+	}
+	if resumed > 0 {
+		log.Printf("%s|%d shard transfers credited\n", logID, resumed)
+	}
+}
@@ -0,0 +1,68 @@
+package job
+
+import (
+	"context"
+	"log"
+	"simplewallet/config"
+	"simplewallet/service"
+	"simplewallet/util"
+	"simplewallet/util/db"
+	"time"
+)
+
+// WebhookJob posts the due webhook deliveries on every tick, retrying the failed ones with backoff.
+type WebhookJob struct {
+	conf   *config.WebhookConf
+	policy service.WebhookRetryPolicy
+}
+
+func NewWebhookJob(conf *config.WebhookConf) *WebhookJob {
+	policy := service.DefaultWebhookRetryPolicy
+	if conf.MaxAttempts > 0 {
+		policy.MaxAttempts = conf.MaxAttempts
+	}
+	if conf.BaseDelaySecond > 0 {
+		policy.BaseDelay = time.Duration(conf.BaseDelaySecond) * time.Second
+	}
+	if conf.MaxDelaySecond > 0 {
+		policy.MaxDelay = time.Duration(conf.MaxDelaySecond) * time.Second
+	}
+	return &WebhookJob{conf: conf, policy: policy}
+}
+
+func (j *WebhookJob) Start(ctx context.Context) {
+	interval := time.Duration(j.conf.IntervalSecond) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			j.RunOnce(ctx, time.Now())
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (j *WebhookJob) RunOnce(ctx context.Context, now time.Time) {
+	logID := util.Uniqid()
+	timeout := time.Duration(j.conf.TimeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	// one dispatcher at a time, or deliveries get posted twice
+	locker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, "webhook:dispatch", 600)
+	delivered, err := service.NewWebhookDispatcher(ctx, logID, db.GetDbClient(), timeout, j.policy, j.conf.BatchSize, locker).
+		WithPrivateTargets(j.conf.AllowPrivateTargets).Run(now)
+	if err != nil {
+		log.Printf("%s|fail to dispatch webhook deliveries:%s\n", logID, err.Error())
+	}
+	if delivered > 0 {
+		log.Printf("%s|%d webhook deliveries delivered\n", logID, delivered)
+	}
+}
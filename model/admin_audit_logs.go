@@ -0,0 +1,15 @@
+package model
+
+type AdminAuditLog struct {
+	ID          int64  `db:"id"`
+	Actor       string `db:"actor"`
+	Action      string `db:"action"`
+	TargetType  string `db:"target_type"`
+	TargetID    int64  `db:"target_id"`
+	BeforeState string `db:"before_state"` // json
+	AfterState  string `db:"after_state"`  // json
+	Reason      string `db:"reason"`
+	LogID       string `db:"log_id"`
+	SourceIP    string `db:"source_ip"`
+	CreatedAt   int64  `db:"created_at"`
+}
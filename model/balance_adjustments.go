@@ -0,0 +1,18 @@
+package model
+
+type BalanceAdjustment struct {
+	ID         int64   `db:"id"`
+	OrderID    string  `db:"order_id"`
+	UserID     int64   `db:"user_id"`
+	OrgID      int64   `db:"org_id"`
+	WalletID   int64   `db:"wallet_id"`
+	TxType     int32   `db:"tx_type"`
+	Amount     float64 `db:"amount"`
+	ReasonCode string  `db:"reason_code"`
+	Reason     string  `db:"reason"`
+	Status     int32   `db:"status"`
+	CreatedBy  string  `db:"created_by"`
+	ReviewedBy string  `db:"reviewed_by"`
+	CreatedAt  int64   `db:"created_at"`
+	UpdatedAt  int64   `db:"updated_at"`
+}
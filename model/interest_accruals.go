@@ -0,0 +1,14 @@
+package model
+
+type InterestAccrual struct {
+	ID            int64   `db:"id"`
+	WalletID      int64   `db:"wallet_id"`
+	UserID        int64   `db:"user_id"`
+	AccrualDate   int32   `db:"accrual_date"`
+	Balance       float64 `db:"balance"`
+	Rate          float64 `db:"rate"`
+	Amount        float64 `db:"amount"`
+	PayoutOrderID string  `db:"payout_order_id"`
+	CreatedAt     int64   `db:"created_at"`
+	UpdatedAt     int64   `db:"updated_at"`
+}
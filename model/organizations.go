@@ -0,0 +1,20 @@
+package model
+
+type Organization struct {
+	ID                   int64  `db:"id"`
+	Name                 string `db:"name"`
+	RequiredApprovals    int32  `db:"required_approvals"`
+	ApprovalExpireSecond int64  `db:"approval_expire_second"`
+	CreatedAt            int64  `db:"created_at"`
+	UpdatedAt            int64  `db:"updated_at"`
+}
+
+type OrgMember struct {
+	ID            int64   `db:"id"`
+	OrgID         int64   `db:"org_id"`
+	UserID        int64   `db:"user_id"`
+	Role          string  `db:"role"`
+	SpendingLimit float64 `db:"spending_limit"`
+	CreatedAt     int64   `db:"created_at"`
+	UpdatedAt     int64   `db:"updated_at"`
+}
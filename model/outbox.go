@@ -0,0 +1,15 @@
+package model
+
+type OutboxEvent struct {
+	ID        int64  `db:"id"`
+	EventID   string `db:"event_id"`
+	EventType string `db:"event_type"`
+	OrderID   string `db:"order_id"`
+	UserID    int64  `db:"user_id"`
+	Payload   string `db:"payload"`
+	Status    int32  `db:"status"`
+	Attempts  int32  `db:"attempts"`
+	LastError string `db:"last_error"`
+	CreatedAt int64  `db:"created_at"`
+	UpdatedAt int64  `db:"updated_at"`
+}
@@ -0,0 +1,14 @@
+package model
+
+type ShardTransfer struct {
+	ID           int64   `db:"id"`
+	OrderID      string  `db:"order_id"`
+	FromUserID   int64   `db:"from_user_id"`
+	FromWalletID int64   `db:"from_wallet_id"`
+	ToUserID     int64   `db:"to_user_id"`
+	Amount       float64 `db:"amount"`
+	Status       int32   `db:"status"`
+	Attempts     int32   `db:"attempts"` // failed credits
+	CreatedAt    int64   `db:"created_at"`
+	UpdatedAt    int64   `db:"updated_at"`
+}
@@ -4,9 +4,13 @@ type Transactions struct {
 	ID            int64   `db:"id"`
 	OrderID       string  `db:"order_id"`
 	UserID        int64   `db:"user_id"`
+	WalletID      int64   `db:"wallet_id"`
 	TxType        int32   `db:"tx_type"`
 	Amount        float64 `db:"amount"`
 	RelatedUserID int64   `db:"related_user_id"`
+	ActorUserID   int64   `db:"actor_user_id"`
 	CreatedAt     int64   `db:"created_at"`
 	UpdatedAt     int64   `db:"updated_at"`
+	PrevHash      string  `db:"prev_hash"` // read by the chain verification only
+	Hash          string  `db:"hash"`
 }
@@ -0,0 +1,23 @@
+package model
+
+type TransferApproval struct {
+	ID                int64   `db:"id"`
+	OrderID           string  `db:"order_id"`
+	OrgID             int64   `db:"org_id"`
+	WalletID          int64   `db:"wallet_id"`
+	RequesterUserID   int64   `db:"requester_user_id"`
+	ToUserID          int64   `db:"to_user_id"`
+	Amount            float64 `db:"amount"`
+	RequiredApprovals int32   `db:"required_approvals"`
+	Status            int32   `db:"status"`
+	ExpireAt          int64   `db:"expire_at"`
+	CreatedAt         int64   `db:"created_at"`
+	UpdatedAt         int64   `db:"updated_at"`
+}
+
+type ApprovalVote struct {
+	ID             int64 `db:"id"`
+	ApprovalID     int64 `db:"approval_id"`
+	ApproverUserID int64 `db:"approver_user_id"`
+	CreatedAt      int64 `db:"created_at"`
+}
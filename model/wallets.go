@@ -1,9 +1,14 @@
 package model
 
 type Wallet struct {
-	ID        int64   `db:"id"`
-	UserID    int64   `db:"user_id"`
-	Balance   float64 `db:"balance"`
-	CreatedAt int64   `db:"created_at"`
-	UpdatedAt int64   `db:"updated_at"`
+	ID          int64   `db:"id"`
+	UserID      int64   `db:"user_id"`
+	OrgID       int64   `db:"org_id"`
+	Name        string  `db:"name"`
+	Balance     float64 `db:"balance"`
+	CreditLimit float64 `db:"credit_limit"`
+	Held        float64 `db:"held"`
+	Product     string  `db:"product"`
+	CreatedAt   int64   `db:"created_at"`
+	UpdatedAt   int64   `db:"updated_at"`
 }
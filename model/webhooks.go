@@ -0,0 +1,27 @@
+package model
+
+type WebhookSubscription struct {
+	ID         int64  `db:"id"`
+	UserID     int64  `db:"user_id"`
+	URL        string `db:"url"`
+	EventTypes string `db:"event_types"` // comma separated, empty for all
+	Secret     string `db:"secret"`
+	CreatedAt  int64  `db:"created_at"`
+	UpdatedAt  int64  `db:"updated_at"`
+}
+
+type WebhookDelivery struct {
+	ID             int64  `db:"id"`
+	SubscriptionID int64  `db:"subscription_id"`
+	UserID         int64  `db:"user_id"`
+	EventID        string `db:"event_id"`
+	EventType      string `db:"event_type"`
+	Payload        string `db:"payload"`
+	Status         int32  `db:"status"`
+	Attempts       int32  `db:"attempts"`
+	NextAttemptAt  int64  `db:"next_attempt_at"`
+	LastStatusCode int32  `db:"last_status_code"`
+	LastError      string `db:"last_error"`
+	CreatedAt      int64  `db:"created_at"`
+	UpdatedAt      int64  `db:"updated_at"`
+}
@@ -1,6 +1,7 @@
 package router
 
 import (
+	"expvar"
 	"net/http"
 	"simplewallet/controller"
 	"time"
@@ -8,8 +9,16 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func InitRouter() *gin.Engine {
+// InitRouter builds the routes; the client ip of a request is read from X-Forwarded-For only when it
+// comes from one of trustedProxies, ips or cidrs, and is the peer address otherwise. ctl serves the routes.
+func InitRouter(trustedProxies []string, ctl *controller.WalletController) (*gin.Engine, error) {
 	router := gin.Default()
+	// gin trusts every proxy by default, anyone could then set the ip recorded in the audit trail
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		return nil, err
+	}
+	// the handlers pass the gin context down to the db calls, a client gone cancels them
+	router.ContextWithFallback = true
 
 	router.NoRoute(func(c *gin.Context) {
 		c.JSON(200, gin.H{
@@ -34,15 +43,35 @@ func InitRouter() *gin.Engine {
 		})
 	})
 
-	ctl := controller.NewWalletController()
+	// counters of the service, e.g. the hit ratio of the balance cache
+	router.GET("/debug/vars", gin.WrapH(expvar.Handler()))
+
 	api := router.Group("")
 	{
 		api.POST("/deposit", ctl.Deposit)
 		api.POST("/withdraw", ctl.Withdraw)
 		api.POST("/transfer", ctl.Transfer)
+		api.POST("/move", ctl.Move)
+		api.POST("/org/create", ctl.CreateOrg)
+		api.POST("/org/member", ctl.SetOrgMember)
+		api.POST("/org/approve", ctl.ApproveTransfer)
+		api.GET("/org/approvals", ctl.GetApprovalList)
 		api.GET("/balance", ctl.GetBalance)
 		api.GET("/transactions", ctl.GetTransactionHistory)
+		api.POST("/webhook/create", ctl.CreateWebhook)
+		api.GET("/webhook/deliveries", ctl.GetWebhookDeliveryList)
+		api.POST("/webhook/redeliver", ctl.RedeliverWebhook)
+		api.GET("/stream", ctl.Stream)
+	}
+	// non-customer actions, authenticated and recorded in the audit trail
+	admin := router.Group("/admin", controller.AdminAudit())
+	{
+		admin.GET("/audit", ctl.GetAuditLogList)
+		admin.POST("/adjust", ctl.Adjust)
+		admin.POST("/adjust/approve", ctl.ApproveAdjustment)
+		admin.POST("/adjust/reject", ctl.RejectAdjustment)
+		admin.POST("/wallet/terms", ctl.SetWalletTerms)
 	}
 
-	return router
+	return router, nil
 }
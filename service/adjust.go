@@ -0,0 +1,306 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"simplewallet/data"
+	"simplewallet/model"
+	"simplewallet/service/dao"
+	"simplewallet/util"
+	"simplewallet/util/errcode"
+)
+
+// adjustmentState is an adjustment in the audit trail, with the balance of its wallet at the time
+type adjustmentState struct {
+	OrderID    string  `json:"order_id"`
+	WalletID   int64   `json:"wallet_id"`
+	TxType     int32   `json:"tx_type"`
+	Amount     float64 `json:"amount"`
+	ReasonCode string  `json:"reason_code"`
+	Status     string  `json:"status"`
+	CreatedBy  string  `json:"created_by"`
+	ReviewedBy string  `json:"reviewed_by"`
+	Balance    float64 `json:"balance"`
+}
+
+func newAdjustmentState(adjustment *model.BalanceAdjustment, balance float64) *adjustmentState {
+	return &adjustmentState{
+		OrderID:    adjustment.OrderID,
+		WalletID:   adjustment.WalletID,
+		TxType:     adjustment.TxType,
+		Amount:     adjustment.Amount,
+		ReasonCode: adjustment.ReasonCode,
+		Status:     data.AdjustmentStatusNames[adjustment.Status],
+		CreatedBy:  adjustment.CreatedBy,
+		ReviewedBy: adjustment.ReviewedBy,
+		Balance:    balance,
+	}
+}
+
+// auditReason is the reason of the audit records of an adjustment, its reason code and text
+func (a *adjustmentState) auditReason(reason string) string {
+	if reason == "" {
+		return a.ReasonCode
+	}
+	return a.ReasonCode + ": " + reason
+}
+
+// Adjust records a manual credit or debit of a wallet by an admin, e.g. to fix an incident. Nothing is
+// posted yet: the adjustment waits until another admin approves it with ApproveAdjustment.
+func (s *WalletService) Adjust(req *data.AdjustReq) (rsp *data.CommRsp, err error) {
+	rsp = &data.CommRsp{Code: errcode.ErrCodeInternalErr, Message: errcode.ErrMsgMap[errcode.ErrCodeInternalErr], LogID: s.logID}
+	defer func() { mapDbError(err, &rsp.Code, &rsp.Message) }()
+
+	if s.actor == nil || s.actor.Name == "" {
+		err = errors.New("adjustments need an admin")
+		rsp.Code = errcode.ErrCodePermissionDenied
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return rsp, err
+	}
+	err = s.locker.Lock()
+	if err != nil {
+		rsp.Code = errcode.ErrCodeLockFail
+		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
+		return rsp, err
+	}
+	defer func() {
+		if errt := s.locker.UnLock(); errt != nil {
+			rsp.Code = errcode.ErrCodeUnLockFail
+			rsp.Message = errcode.ErrMsgMap[rsp.Code] + errt.Error()
+		}
+	}()
+
+	err = s.runTx(s.storeFor(ownerOf(req.UserID, req.OrgID)), rsp, func(tx dao.UnitOfWork) error {
+		// the order_id is the one of the transaction once posted
+		adjustmentDao := tx.Adjustments()
+		adjustment, err := adjustmentDao.GetAdjustmentByOrderID(req.OrderID)
+		if err != nil {
+			rsp.Code = errcode.ErrCodeQueryDBFail
+			rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
+			return err
+		}
+		trans, err := tx.Transactions().GetTransactionByOrderID(req.OrderID)
+		if err != nil {
+			rsp.Code = errcode.ErrCodeQueryDBFail
+			rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
+			return err
+		}
+		if adjustment != nil || trans != nil {
+			err = errors.New("order_id already exists")
+			rsp.Code = errcode.ErrCodeOrderIDRepeat
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+
+		// admins adjust any wallet, they are not members of the organizations
+		var wallet *model.Wallet
+		if req.OrgID > 0 {
+			wallet, err = tx.Wallets().GetWalletByOrgID(req.OrgID, pocketName(req.Pocket))
+		} else {
+			wallet, err = tx.Wallets().GetWalletByUserID(req.UserID, pocketName(req.Pocket))
+		}
+		if err != nil {
+			rsp.Code = errcode.ErrCodeQueryDBFail
+			rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
+			return err
+		}
+		if wallet == nil {
+			err = errors.New("wallet not exist")
+			rsp.Code = errcode.ErrCodeUserWalletNotExist
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+
+		adjustment = &model.BalanceAdjustment{OrderID: req.OrderID, UserID: req.UserID, OrgID: req.OrgID, WalletID: wallet.ID, TxType: data.TxTypeAdjustIn,
+			Amount: req.Amount, ReasonCode: req.ReasonCode, Reason: req.Reason, Status: data.AdjustmentStatusPending, CreatedBy: s.actor.Name}
+		if req.Direction == data.AdjustDirectionDebit {
+			adjustment.TxType = data.TxTypeAdjustOut
+		}
+		if adjustment.ID, err = adjustmentDao.InsertAdjustment(adjustment); err != nil {
+			log.Println("Failed to record adjustment" + err.Error())
+			rsp.Code = errcode.ErrCodeDbError
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+		state := newAdjustmentState(adjustment, wallet.Balance)
+		if err = recordAudit(tx, s.actor, data.AuditActionAdjustCreate, data.AuditTargetAdjustment, adjustment.ID, nil, state, state.auditReason(req.Reason)); err != nil {
+			log.Println("Failed to record adjustment audit" + err.Error())
+			rsp.Code = errcode.ErrCodeDbError
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return rsp, err
+	}
+
+	rsp.Code = errcode.ErrCodeSuccess
+	rsp.Message = "Adjustment pending approval"
+	return rsp, nil
+}
+
+// ApproveAdjustment posts a pending adjustment to its wallet, as a transaction with the order_id of the
+// adjustment. The admin who created it can not approve it.
+func (s *WalletService) ApproveAdjustment(req *data.ReviewAdjustmentReq) (rsp *data.CommRsp, err error) {
+	return s.reviewAdjustment(req, true)
+}
+
+// RejectAdjustment drops a pending adjustment, nothing is posted. The admin who created it can not reject it.
+func (s *WalletService) RejectAdjustment(req *data.ReviewAdjustmentReq) (rsp *data.CommRsp, err error) {
+	return s.reviewAdjustment(req, false)
+}
+
+// reviewAdjustment approves or rejects a pending adjustment, the review and its audit record in one db transaction
+func (s *WalletService) reviewAdjustment(req *data.ReviewAdjustmentReq, approve bool) (rsp *data.CommRsp, err error) {
+	rsp = &data.CommRsp{Code: errcode.ErrCodeInternalErr, Message: errcode.ErrMsgMap[errcode.ErrCodeInternalErr], LogID: s.logID}
+	defer func() { mapDbError(err, &rsp.Code, &rsp.Message) }()
+
+	if s.actor == nil || s.actor.Name == "" {
+		err = errors.New("adjustments need an admin")
+		rsp.Code = errcode.ErrCodePermissionDenied
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return rsp, err
+	}
+	err = s.locker.Lock()
+	if err != nil {
+		rsp.Code = errcode.ErrCodeLockFail
+		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
+		return rsp, err
+	}
+	defer func() {
+		if errt := s.locker.UnLock(); errt != nil {
+			rsp.Code = errcode.ErrCodeUnLockFail
+			rsp.Message = errcode.ErrMsgMap[rsp.Code] + errt.Error()
+		}
+	}()
+
+	status, action := data.AdjustmentStatusRejected, data.AuditActionAdjustReject
+	if approve {
+		status, action = data.AdjustmentStatusPosted, data.AuditActionAdjustApprove
+	}
+	err = s.runTx(s.storeFor(ownerOf(req.UserID, req.OrgID)), rsp, func(tx dao.UnitOfWork) error {
+		adjustmentDao := tx.Adjustments()
+		adjustment, err := adjustmentDao.GetAdjustmentByOrderID(req.OrderID)
+		if err != nil {
+			rsp.Code = errcode.ErrCodeQueryDBFail
+			rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
+			return err
+		}
+		if adjustment == nil || adjustment.UserID != req.UserID || adjustment.OrgID != req.OrgID || adjustment.Status != data.AdjustmentStatusPending {
+			err = errors.New("pending adjustment not exist")
+			rsp.Code = errcode.ErrCodeAdjustmentNotExist
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+		if adjustment.CreatedBy == s.actor.Name {
+			err = errors.New("creator can not review own adjustment")
+			rsp.Code = errcode.ErrCodePermissionDenied
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+
+		walletDao := tx.Wallets()
+		wallet, err := walletDao.GetWalletByID(adjustment.WalletID)
+		if err != nil {
+			rsp.Code = errcode.ErrCodeQueryDBFail
+			rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
+			return err
+		}
+		if wallet == nil {
+			err = errors.New("wallet not exist")
+			rsp.Code = errcode.ErrCodeUserWalletNotExist
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+		before := newAdjustmentState(adjustment, wallet.Balance)
+		if approve {
+			if code, err := s.postAdjustment(tx, adjustment, wallet); err != nil {
+				rsp.Code = code
+				rsp.Message = errcode.ErrMsgMap[rsp.Code]
+				return err
+			}
+			if wallet, err = walletDao.GetWalletByID(adjustment.WalletID); err != nil {
+				rsp.Code = errcode.ErrCodeQueryDBFail
+				rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
+				return err
+			}
+		}
+
+		reviewed, err := adjustmentDao.ReviewAdjustment(adjustment.ID, data.AdjustmentStatusPending, status, s.actor.Name)
+		if err != nil {
+			rsp.Code = errcode.ErrCodeDbError
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+		if !reviewed {
+			err = errors.New("pending adjustment not exist")
+			rsp.Code = errcode.ErrCodeAdjustmentNotExist
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+		adjustment.Status, adjustment.ReviewedBy = status, s.actor.Name
+		if err = recordAudit(tx, s.actor, action, data.AuditTargetAdjustment, adjustment.ID, before, newAdjustmentState(adjustment, wallet.Balance),
+			before.auditReason(adjustment.Reason)); err != nil {
+			log.Println("Failed to record adjustment audit" + err.Error())
+			rsp.Code = errcode.ErrCodeDbError
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return rsp, err
+	}
+
+	rsp.Code = errcode.ErrCodeSuccess
+	rsp.Message = "Adjustment rejected"
+	if approve {
+		s.written(req.UserID)
+		rsp.Message = "Adjustment posted"
+	}
+	return rsp, nil
+}
+
+// postAdjustment credits or debits the wallet by the adjustment and records its transaction and event. A debit
+// takes available funds like a withdrawal, an adjustment that no longer fits stays pending.
+func (s *WalletService) postAdjustment(tx dao.UnitOfWork, adjustment *model.BalanceAdjustment, wallet *model.Wallet) (int32, error) {
+	transDao := tx.Transactions()
+	trans, err := transDao.GetTransactionByOrderID(adjustment.OrderID)
+	if err != nil {
+		return errcode.ErrCodeQueryDBFail, err
+	}
+	if trans != nil {
+		return errcode.ErrCodeOrderIDRepeat, errors.New("order_id already exists")
+	}
+	debit := data.TxTypeSign(adjustment.TxType) < 0
+	if debit && util.CompareFloat(wallet.Balance+wallet.CreditLimit-wallet.Held, adjustment.Amount, 8) < 0 {
+		return errcode.ErrCodeBalanceNotEnough, errors.New("balance not enough")
+	}
+
+	if err = tx.Wallets().UpdateWalletBalance(wallet.ID, adjustment.TxType, adjustment.Amount); err != nil {
+		log.Println("Failed to update balance" + err.Error())
+		return errcode.ErrCodeDbError, err
+	}
+	if debit {
+		if err = s.onOverdraft(tx, wallet, adjustment.Amount); err != nil {
+			log.Println("Failed to accrue overdraft" + err.Error())
+			return errcode.ErrCodeDbError, err
+		}
+	}
+	// no customer acted, the admins are in the audit trail
+	record := &model.Transactions{OrderID: adjustment.OrderID, UserID: wallet.UserID, WalletID: wallet.ID, TxType: adjustment.TxType, Amount: adjustment.Amount}
+	if err = transDao.InsertTransaction(record); err != nil {
+		log.Println("Failed to record transaction" + err.Error())
+		return errcode.ErrCodeDbError, err
+	}
+	eventType := data.EventTypeAdjustmentCredit
+	if debit {
+		eventType = data.EventTypeAdjustmentDebit
+	}
+	if err = recordEvent(tx, eventType, record); err != nil {
+		log.Println("Failed to record adjustment event" + err.Error())
+		return errcode.ErrCodeDbError, err
+	}
+	return errcode.ErrCodeSuccess, nil
+}
@@ -0,0 +1,166 @@
+package service_test
+
+import (
+	"simplewallet/data"
+	"simplewallet/service"
+	"simplewallet/service/dao"
+	"simplewallet/util/errcode"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+func newAdminWalletService(store dao.Store, admin string) *service.WalletService {
+	s := newMemoryWalletService(store, "adjust")
+	return s.WithAuditActor(&service.AuditActor{Name: admin, LogID: "admin", SourceIP: "10.0.0.1"})
+}
+
+func TestAdjust(t *testing.T) {
+	ConnectDBRedis()
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	defer DisconnectDBRedis()
+
+	// seed deposits 100.00 to 101
+	seed := func(t *testing.T, store dao.Store) {
+		rsp, err := newMemoryWalletService(store, "deposit").Deposit(&data.DepositReq{OrderID: "1001", UserID: 101, Amount: 100.00})
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+	}
+	balanceOf := func(t *testing.T, store dao.Store, userID int64) float64 {
+		wallet, err := store.Wallets().GetWalletByUserID(userID, data.DefaultPocket)
+		require.Nil(t, err)
+		return wallet.Balance
+	}
+
+	t.Run("case1: adjust success-[credit posted once approved by another admin, audited]", func(t *testing.T) {
+		store := dao.NewMemoryStore()
+		seed(t, store)
+		rsp, err := newAdminWalletService(store, "alice").Adjust(&data.AdjustReq{OrderID: "adj-1", UserID: 101, Direction: data.AdjustDirectionCredit,
+			Amount: 25.50, ReasonCode: "incident", Reason: "deposit lost in the outage"})
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		assert.Equal(t, 100.00, balanceOf(t, store, 101), "nothing posted before the approval")
+
+		review := &data.ReviewAdjustmentReq{OrderID: "adj-1", UserID: 101}
+		rsp, err = newAdminWalletService(store, "alice").ApproveAdjustment(review)
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodePermissionDenied, rsp.Code, "the creator can't approve")
+		rsp, err = newAdminWalletService(store, "bob").ApproveAdjustment(&data.ReviewAdjustmentReq{OrderID: "adj-1", UserID: 102})
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodeAdjustmentNotExist, rsp.Code, "not an adjustment of 102")
+		rsp, err = newAdminWalletService(store, "bob").ApproveAdjustment(review)
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		assert.Equal(t, 125.50, balanceOf(t, store, 101))
+		trans, err := store.Transactions().GetTransactionByOrderID("adj-1")
+		require.Nil(t, err)
+		require.NotNil(t, trans)
+		assert.Equal(t, data.TxTypeAdjustIn, trans.TxType)
+		assert.Equal(t, 25.50, trans.Amount)
+		eventList, err := store.Outbox().GetPendingEventList(10)
+		require.Nil(t, err)
+		require.Len(t, eventList, 2, "the deposit and the adjustment")
+		assert.Equal(t, data.EventTypeAdjustmentCredit, eventList[1].EventType)
+		assert.Equal(t, "adj-1", eventList[1].OrderID)
+		assert.Equal(t, int64(101), eventList[1].UserID)
+		rsp, err = newAdminWalletService(store, "carol").ApproveAdjustment(review)
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodeAdjustmentNotExist, rsp.Code, "posted once")
+
+		entries, err := store.Audit().GetAuditLogList(&dao.AuditFilter{TargetType: data.AuditTargetAdjustment}, nil, 10)
+		require.Nil(t, err)
+		require.Len(t, entries, 2)
+		assert.Equal(t, data.AuditActionAdjustApprove, entries[0].Action)
+		assert.Equal(t, "bob", entries[0].Actor)
+		assert.JSONEq(t, `{"order_id":"adj-1","wallet_id":1,"tx_type":8,"amount":25.5,"reason_code":"incident","status":"posted","created_by":"alice","reviewed_by":"bob","balance":125.5}`, entries[0].AfterState)
+		assert.Equal(t, "incident: deposit lost in the outage", entries[0].Reason)
+		assert.Equal(t, data.AuditActionAdjustCreate, entries[1].Action)
+		assert.Equal(t, "alice", entries[1].Actor)
+		assert.Empty(t, entries[1].BeforeState)
+		assert.Equal(t, "10.0.0.1", entries[1].SourceIP)
+
+		result, _ := runReconcile(t, store, 0, false)
+		assert.Equal(t, int64(0), result.Drifted, "adjustments are in the ledger")
+	})
+
+	t.Run("case2: adjust success-[rejected, nothing posted]", func(t *testing.T) {
+		store := dao.NewMemoryStore()
+		seed(t, store)
+		rsp, err := newAdminWalletService(store, "alice").Adjust(&data.AdjustReq{OrderID: "adj-1", UserID: 101, Direction: data.AdjustDirectionDebit, Amount: 10.00, ReasonCode: "duplicate"})
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		review := &data.ReviewAdjustmentReq{OrderID: "adj-1", UserID: 101}
+		rsp, err = newAdminWalletService(store, "alice").RejectAdjustment(review)
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodePermissionDenied, rsp.Code, "the creator can't reject")
+		rsp, err = newAdminWalletService(store, "bob").RejectAdjustment(review)
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+
+		rsp, err = newAdminWalletService(store, "carol").ApproveAdjustment(review)
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodeAdjustmentNotExist, rsp.Code)
+		assert.Equal(t, 100.00, balanceOf(t, store, 101))
+		trans, err := store.Transactions().GetTransactionByOrderID("adj-1")
+		require.Nil(t, err)
+		assert.Nil(t, trans)
+		eventList, err := store.Outbox().GetPendingEventList(10)
+		require.Nil(t, err)
+		assert.Len(t, eventList, 1, "the deposit only")
+		entries, err := store.Audit().GetAuditLogList(&dao.AuditFilter{Action: data.AuditActionAdjustReject}, nil, 10)
+		require.Nil(t, err)
+		assert.Len(t, entries, 1)
+	})
+
+	t.Run("case3: adjust success-[debit above the funds stays pending until they are there]", func(t *testing.T) {
+		store := dao.NewMemoryStore()
+		seed(t, store)
+		rsp, err := newAdminWalletService(store, "alice").Adjust(&data.AdjustReq{OrderID: "adj-1", UserID: 101, Direction: data.AdjustDirectionDebit, Amount: 150.00, ReasonCode: "chargeback"})
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		review := &data.ReviewAdjustmentReq{OrderID: "adj-1", UserID: 101}
+		rsp, err = newAdminWalletService(store, "bob").ApproveAdjustment(review)
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodeBalanceNotEnough, rsp.Code)
+		entries, err := store.Audit().GetAuditLogList(&dao.AuditFilter{Action: data.AuditActionAdjustApprove}, nil, 10)
+		require.Nil(t, err)
+		assert.Empty(t, entries, "rolled back with the approval")
+
+		rsp, err = newMemoryWalletService(store, "deposit").Deposit(&data.DepositReq{OrderID: "1002", UserID: 101, Amount: 60.00})
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		rsp, err = newAdminWalletService(store, "bob").ApproveAdjustment(review)
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		assert.Equal(t, 10.00, balanceOf(t, store, 101))
+		eventList, err := store.Outbox().GetPendingEventList(10)
+		require.Nil(t, err)
+		require.Len(t, eventList, 3, "the deposits and the adjustment, none of the approval rolled back")
+		assert.Equal(t, data.EventTypeAdjustmentDebit, eventList[2].EventType)
+	})
+
+	t.Run("case4: adjust fail-[no admin, order_id taken, no wallet]", func(t *testing.T) {
+		store := dao.NewMemoryStore()
+		seed(t, store)
+		req := &data.AdjustReq{OrderID: "adj-1", UserID: 101, Direction: data.AdjustDirectionCredit, Amount: 1.00, ReasonCode: "goodwill"}
+		rsp, err := newMemoryWalletService(store, "adjust").Adjust(req)
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodePermissionDenied, rsp.Code)
+
+		rsp, err = newAdminWalletService(store, "alice").Adjust(&data.AdjustReq{OrderID: "1001", UserID: 101, Direction: data.AdjustDirectionCredit, Amount: 1.00, ReasonCode: "goodwill"})
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodeOrderIDRepeat, rsp.Code, "the order_id of a deposit")
+		rsp, err = newAdminWalletService(store, "alice").Adjust(req)
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		rsp, err = newAdminWalletService(store, "bob").Adjust(req)
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodeOrderIDRepeat, rsp.Code)
+
+		rsp, err = newAdminWalletService(store, "alice").Adjust(&data.AdjustReq{OrderID: "adj-2", UserID: 102, Direction: data.AdjustDirectionCredit, Amount: 1.00, ReasonCode: "goodwill"})
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodeUserWalletNotExist, rsp.Code)
+	})
+}
@@ -0,0 +1,421 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"simplewallet/data"
+	"simplewallet/model"
+	"simplewallet/service/dao"
+	"simplewallet/util"
+	"simplewallet/util/errcode"
+	"time"
+)
+
+const (
+	approvalListLimit int32 = 100
+	approvalDueBatch  int32 = 500
+)
+
+// holdForApproval parks a shared wallet transfer above the spender's limit: the amount is held on the
+// wallet and the transfer waits for approvals of other members. The caller commits the db transaction.
+func (s *WalletService) holdForApproval(tx dao.UnitOfWork, rsp *data.CommRsp, req *data.TransferReq, wallet *model.Wallet) error {
+	org, err := tx.Orgs().GetOrgByID(req.OrgID)
+	if err != nil {
+		rsp.Code = errcode.ErrCodeQueryDBFail
+		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
+		return err
+	}
+	if org == nil || org.RequiredApprovals <= 0 {
+		err = errors.New("spending limit exceeded")
+		rsp.Code = errcode.ErrCodeSpendingLimit
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return err
+	}
+
+	// the order_id of a pending transfer is not in the transactions table yet
+	approvalDao := tx.Approvals()
+	approval, err := approvalDao.GetApprovalByOrderID(req.OrderID)
+	if err != nil {
+		rsp.Code = errcode.ErrCodeQueryDBFail
+		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
+		return err
+	}
+	if approval != nil {
+		err = errors.New("order_id already exists")
+		rsp.Code = errcode.ErrCodeOrderIDRepeat
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return err
+	}
+
+	err = tx.Wallets().HoldBalance(wallet.ID, req.Amount)
+	if err != nil {
+		rsp.Code = errcode.ErrCodeDbError
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return err
+	}
+	_, err = approvalDao.InsertApproval(&model.TransferApproval{
+		OrderID:           req.OrderID,
+		OrgID:             req.OrgID,
+		WalletID:          wallet.ID,
+		RequesterUserID:   req.FromUserID,
+		ToUserID:          req.ToUserID,
+		Amount:            req.Amount,
+		RequiredApprovals: org.RequiredApprovals,
+		ExpireAt:          time.Now().Unix() + org.ApprovalExpireSecond,
+	})
+	if err != nil {
+		rsp.Code = errcode.ErrCodeDbError
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return err
+	}
+
+	return nil
+}
+
+// ApproveTransfer records the approval of a member on a pending transfer, every approval is kept with
+// the approver id. The requester can not approve their own transfer. Once enough members approved,
+// the held amount is released and the transfer executes in the same db transaction.
+func (s *WalletService) ApproveTransfer(req *data.ApproveTransferReq) (rsp *data.CommRsp, err error) {
+	rsp = &data.CommRsp{Code: errcode.ErrCodeInternalErr, Message: errcode.ErrMsgMap[errcode.ErrCodeInternalErr], LogID: s.logID}
+	defer func() { mapDbError(err, &rsp.Code, &rsp.Message) }()
+
+	err = s.locker.Lock()
+	if err != nil {
+		rsp.Code = errcode.ErrCodeLockFail
+		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
+		return rsp, err
+	}
+	defer func() {
+		if errt := s.locker.UnLock(); errt != nil {
+			rsp.Code = errcode.ErrCodeUnLockFail
+			rsp.Message = errcode.ErrMsgMap[rsp.Code] + errt.Error()
+		}
+	}()
+
+	tx, err := s.store.Begin()
+	if err != nil {
+		rsp.Code = errcode.ErrCodeDbError
+		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
+		return rsp, err
+	}
+
+	approvalDao := tx.Approvals()
+	approval, err := approvalDao.GetApprovalByOrderID(req.OrderID)
+	if err != nil {
+		_ = tx.Rollback()
+		rsp.Code = errcode.ErrCodeQueryDBFail
+		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
+		return rsp, err
+	}
+	if approval == nil || approval.OrgID != req.OrgID || approval.Status != data.ApprovalStatusPending {
+		_ = tx.Rollback()
+		err = errors.New("pending approval not exist")
+		rsp.Code = errcode.ErrCodeApprovalNotExist
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return rsp, err
+	}
+
+	// approvers need the spender role and must not be the requester
+	if _, code, err := s.checkOrgRole(tx, req.OrgID, req.UserID, data.OrgRoleSpender); err != nil {
+		_ = tx.Rollback()
+		rsp.Code = code
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return rsp, err
+	}
+	if req.UserID == approval.RequesterUserID {
+		_ = tx.Rollback()
+		err = errors.New("requester can not approve own transfer")
+		rsp.Code = errcode.ErrCodePermissionDenied
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return rsp, err
+	}
+
+	// an expired approval releases its hold right away, the job would do the same later
+	if approval.ExpireAt <= time.Now().Unix() {
+		if err = s.expireApproval(tx, approval); err != nil {
+			_ = tx.Rollback()
+			rsp.Code = errcode.ErrCodeDbError
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return rsp, err
+		}
+		if err = tx.Commit(); err != nil {
+			log.Println("Failed to commit transaction" + err.Error())
+			rsp.Code = errcode.ErrCodeDbError
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return rsp, err
+		}
+		err = errors.New("approval expired")
+		rsp.Code = errcode.ErrCodeApprovalExpired
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return rsp, err
+	}
+
+	inserted, err := approvalDao.InsertVote(approval.ID, req.UserID)
+	if err != nil {
+		_ = tx.Rollback()
+		rsp.Code = errcode.ErrCodeDbError
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return rsp, err
+	}
+	if !inserted {
+		_ = tx.Rollback()
+		err = errors.New("already approved")
+		rsp.Code = errcode.ErrCodeApprovalRepeat
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return rsp, err
+	}
+	voteList, err := approvalDao.GetVoteList(approval.ID)
+	if err != nil {
+		_ = tx.Rollback()
+		rsp.Code = errcode.ErrCodeQueryDBFail
+		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
+		return rsp, err
+	}
+
+	message := "Approval recorded"
+	var shardTransfer *model.ShardTransfer
+	if len(voteList) >= int(approval.RequiredApprovals) {
+		if shardTransfer, err = s.executeApproval(tx, approval); err != nil {
+			_ = tx.Rollback()
+			log.Println("Failed to execute approved transfer" + err.Error())
+			rsp.Code = errcode.ErrCodeDbError
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return rsp, err
+		}
+		message = "Transfer successful"
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Failed to commit transaction" + err.Error())
+		rsp.Code = errcode.ErrCodeDbError
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return rsp, err
+	}
+
+	s.written(req.UserID, approval.RequesterUserID, approval.ToUserID)
+	if shardTransfer != nil {
+		if err = s.creditShardTransfer(s.store, shardTransfer); err != nil {
+			log.Println("Failed to credit the recipient on their shard" + err.Error())
+			rsp.Code = errcode.ErrCodeTransferPending
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return rsp, err
+		}
+	}
+	rsp.Code = errcode.ErrCodeSuccess
+	rsp.Message = message
+	return rsp, nil
+}
+
+// ExpireApproval releases the hold of a pending transfer whose approval time is over.
+func (s *WalletService) ExpireApproval(orderID string) (rsp *data.CommRsp, err error) {
+	rsp = &data.CommRsp{Code: errcode.ErrCodeInternalErr, Message: errcode.ErrMsgMap[errcode.ErrCodeInternalErr], LogID: s.logID}
+	defer func() { mapDbError(err, &rsp.Code, &rsp.Message) }()
+
+	err = s.locker.Lock()
+	if err != nil {
+		rsp.Code = errcode.ErrCodeLockFail
+		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
+		return rsp, err
+	}
+	defer func() {
+		if errt := s.locker.UnLock(); errt != nil {
+			rsp.Code = errcode.ErrCodeUnLockFail
+			rsp.Message = errcode.ErrMsgMap[rsp.Code] + errt.Error()
+		}
+	}()
+
+	tx, err := s.store.Begin()
+	if err != nil {
+		rsp.Code = errcode.ErrCodeDbError
+		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
+		return rsp, err
+	}
+	approval, err := tx.Approvals().GetApprovalByOrderID(orderID)
+	if err != nil {
+		_ = tx.Rollback()
+		rsp.Code = errcode.ErrCodeQueryDBFail
+		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
+		return rsp, err
+	}
+	if approval == nil || approval.Status != data.ApprovalStatusPending || approval.ExpireAt > time.Now().Unix() {
+		_ = tx.Rollback()
+		err = errors.New("no expired approval")
+		rsp.Code = errcode.ErrCodeApprovalNotExist
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return rsp, err
+	}
+	if err = s.expireApproval(tx, approval); err != nil {
+		_ = tx.Rollback()
+		rsp.Code = errcode.ErrCodeDbError
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return rsp, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Failed to commit transaction" + err.Error())
+		rsp.Code = errcode.ErrCodeDbError
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return rsp, err
+	}
+
+	rsp.Code = errcode.ErrCodeSuccess
+	rsp.Message = "Approval expired"
+	return rsp, nil
+}
+
+// GetApprovalList lists the transfers of an organization waiting for approvals, with the members approved so far.
+func (s *WalletService) GetApprovalList(req *data.GetApprovalListReq) (rsp *data.GetApprovalListRsp, err error) {
+	rsp = &data.GetApprovalListRsp{Code: errcode.ErrCodeInternalErr, Message: errcode.ErrMsgMap[errcode.ErrCodeInternalErr], LogID: s.logID}
+	defer func() { mapDbError(err, &rsp.Code, &rsp.Message) }()
+
+	if _, code, err := s.checkOrgRole(s.store, req.OrgID, req.UserID, data.OrgRoleViewer); err != nil {
+		rsp.Code = code
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return rsp, err
+	}
+
+	approvalDao := s.store.Approvals()
+	approvalList, err := approvalDao.GetPendingApprovalListByOrgID(req.OrgID, approvalListLimit)
+	if err != nil {
+		rsp.Code = errcode.ErrCodeQueryDBFail
+		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
+		return rsp, err
+	}
+	rspItems := make([]*data.GetApprovalListRspDataItem, 0, len(approvalList))
+	for _, approval := range approvalList {
+		voteList, err := approvalDao.GetVoteList(approval.ID)
+		if err != nil {
+			rsp.Code = errcode.ErrCodeQueryDBFail
+			rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
+			return rsp, err
+		}
+		approverUserIDs := make([]int64, 0, len(voteList))
+		for _, vote := range voteList {
+			approverUserIDs = append(approverUserIDs, vote.ApproverUserID)
+		}
+		rspItems = append(rspItems, &data.GetApprovalListRspDataItem{
+			OrderID:           approval.OrderID,
+			RequesterUserID:   approval.RequesterUserID,
+			ToUserID:          approval.ToUserID,
+			Amount:            approval.Amount,
+			RequiredApprovals: approval.RequiredApprovals,
+			ApproverUserIDs:   approverUserIDs,
+			ExpireAt:          time.Unix(approval.ExpireAt, 0).Format("2006-01-02 15:04:05"),
+		})
+	}
+
+	rsp.Code = errcode.ErrCodeSuccess
+	rsp.Message = "Success"
+	rsp.Data = &data.GetApprovalListRspData{Items: rspItems}
+	return rsp, nil
+}
+
+// executeApproval turns the held amount into the transfer, recorded on behalf of the requester.
+// A recipient on another shard is credited after commit, with the returned shard transfer.
+func (s *WalletService) executeApproval(tx dao.UnitOfWork, approval *model.TransferApproval) (*model.ShardTransfer, error) {
+	walletDao := tx.Wallets()
+	wallet, err := walletDao.GetWalletByID(approval.WalletID)
+	if err != nil {
+		return nil, err
+	}
+	if wallet == nil {
+		return nil, errors.New("sender wallet not exist")
+	}
+	if err = walletDao.ReleaseHold(wallet.ID, approval.Amount); err != nil {
+		return nil, err
+	}
+	if err = walletDao.UpdateWalletBalance(wallet.ID, data.TxTypeTransferOut, approval.Amount); err != nil {
+		return nil, err
+	}
+	if err = s.onOverdraft(tx, wallet, approval.Amount); err != nil {
+		return nil, err
+	}
+	var toWalletID int64
+	var shardTransfer *model.ShardTransfer
+	if s.sameShard(wallet.UserID, approval.ToUserID) {
+		toWalletID, err = walletDao.CreateOrUpdateWallet(approval.ToUserID, data.DefaultPocket, approval.Amount)
+	} else {
+		shardTransfer = &model.ShardTransfer{OrderID: approval.OrderID, FromUserID: approval.RequesterUserID, FromWalletID: wallet.ID, ToUserID: approval.ToUserID, Amount: approval.Amount}
+		_, err = tx.ShardTransfers().InsertShardTransfer(shardTransfer)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	transDao := tx.Transactions()
+	trans := &model.Transactions{OrderID: approval.OrderID, UserID: wallet.UserID, WalletID: wallet.ID, TxType: data.TxTypeTransferOut, Amount: approval.Amount, RelatedUserID: approval.ToUserID, ActorUserID: approval.RequesterUserID}
+	if err = transDao.InsertTransaction(trans); err != nil {
+		return nil, err
+	}
+	if err = recordEvent(tx, data.EventTypeTransfer, trans); err != nil {
+		return nil, err
+	}
+	if shardTransfer == nil {
+		err = transDao.InsertTransaction(&model.Transactions{OrderID: approval.OrderID, UserID: approval.ToUserID, WalletID: toWalletID, TxType: data.TxTypeTransferIn, Amount: approval.Amount, RelatedUserID: approval.RequesterUserID, ActorUserID: approval.RequesterUserID})
+		if err != nil {
+			return nil, err
+		}
+	}
+	updated, err := tx.Approvals().UpdateApprovalStatus(approval.ID, data.ApprovalStatusPending, data.ApprovalStatusExecuted)
+	if err != nil {
+		return nil, err
+	}
+	if !updated {
+		return nil, errors.New("approval is not pending")
+	}
+	return shardTransfer, nil
+}
+
+// expireApproval marks a pending approval expired and releases its hold
+func (s *WalletService) expireApproval(tx dao.UnitOfWork, approval *model.TransferApproval) error {
+	updated, err := tx.Approvals().UpdateApprovalStatus(approval.ID, data.ApprovalStatusPending, data.ApprovalStatusExpired)
+	if err != nil || !updated {
+		return err
+	}
+	return tx.Wallets().ReleaseHold(approval.WalletID, approval.Amount)
+}
+
+// ApprovalService expires pending transfers whose approval time is over, so their holds are released.
+type ApprovalService struct {
+	logID     string
+	ctx       context.Context
+	store     dao.Store
+	newLocker func(key string) util.DistributedLock
+}
+
+func NewApprovalService(ctx context.Context, logID string, dbCli *sql.DB, newLocker func(key string) util.DistributedLock) *ApprovalService {
+	return &ApprovalService{
+		ctx:       ctx,
+		logID:     logID,
+		store:     newSqlStore(ctx, logID, dbCli),
+		newLocker: newLocker,
+	}
+}
+
+// ExpireDue expires every pending approval due at the given time, returns how many were expired.
+// Each approval takes the lock of its transfer, the same one ApproveTransfer holds.
+func (s *ApprovalService) ExpireDue(now time.Time) (int, error) {
+	approvalDao := s.store.Approvals()
+	expired := 0
+	for {
+		approvalList, err := approvalDao.GetDueApprovalList(now.Unix(), approvalDueBatch)
+		if err != nil {
+			return expired, err
+		}
+		for _, approval := range approvalList {
+			rsp, err := NewWalletServiceWithStore(s.ctx, s.logID, s.store, s.newLocker("transfer:"+approval.OrderID)).ExpireApproval(approval.OrderID)
+			if err != nil {
+				if rsp.Code == errcode.ErrCodeApprovalNotExist {
+					continue
+				}
+				return expired, err
+			}
+			expired++
+		}
+		if len(approvalList) < int(approvalDueBatch) {
+			return expired, nil
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package service_test
+
+import (
+	"context"
+	"regexp"
+	"simplewallet/data"
+	"simplewallet/service"
+	"simplewallet/util"
+	"simplewallet/util/db"
+	"simplewallet/util/errcode"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
+)
+
+const (
+	approvalQuery = "SELECT id,order_id,org_id,wallet_id,requester_user_id,to_user_id,amount,required_approvals,status,expire_at,created_at,updated_at FROM transfer_approvals WHERE order_id = $1"
+	memberQuery   = "SELECT id,org_id,user_id,role,spending_limit,created_at,updated_at FROM org_members WHERE org_id = $1 AND user_id = $2"
+)
+
+var (
+	approvalColumns = []string{"id", "order_id", "org_id", "wallet_id", "requester_user_id", "to_user_id", "amount", "required_approvals", "status", "expire_at", "created_at", "updated_at"}
+	memberColumns   = []string{"id", "org_id", "user_id", "role", "spending_limit", "created_at", "updated_at"}
+)
+
+func TestOrgTransferApproval(t *testing.T) {
+	ConnectDBRedis()
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	defer DisconnectDBRedis()
+
+	mockDBCli := db.GetDbClientMock()
+	mock := db.GetSqlMock()
+	t.Run("case1: org transfer success-[above spending limit, held for approval]", func(t *testing.T) {
+		logID := util.Uniqid()
+		ctx := context.Background()
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, "transfer:"+logID, 5)
+		transferReq := &data.TransferReq{OrderID: logID, FromUserID: 102, ToUserID: 201, Amount: 800.00, OrgID: 1}
+		tn := time.Now().Unix()
+		// mock DB data
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(transferReq.OrderID).WillReturnRows(sqlmock.NewRows([]string{}))
+		mock.ExpectQuery(regexp.QuoteMeta(memberQuery)).WithArgs(transferReq.OrgID, transferReq.FromUserID).
+			WillReturnRows(sqlmock.NewRows(memberColumns).AddRow(2, 1, 102, data.OrgRoleSpender, 500.00, tn, tn))
+		walletRows := sqlmock.NewRows([]string{"id", "user_id", "org_id", "name", "balance", "credit_limit", "held", "product", "created_at", "updated_at"}).AddRow(9, 0, 1, data.DefaultPocket, 2000.00, 0, 0, "", tn, tn)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE org_id = $1 AND user_id = 0 AND name = $2")).
+			WithArgs(transferReq.OrgID, data.DefaultPocket).WillReturnRows(walletRows)
+		expectWalletLock(mock, 9)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(SUM(amount), 0) FROM transactions WHERE wallet_id = $1 AND actor_user_id = $2 AND tx_type IN ($3, $4) AND created_at >= $5")).
+			WithArgs(9, transferReq.FromUserID, data.TxTypeWithdraw, data.TxTypeTransferOut, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(0))
+		orgRows := sqlmock.NewRows([]string{"id", "name", "required_approvals", "approval_expire_second", "created_at", "updated_at"}).AddRow(1, "acme", 2, 3600, tn, tn)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,name,required_approvals,approval_expire_second,created_at,updated_at FROM organizations WHERE id = $1")).
+			WithArgs(transferReq.OrgID).WillReturnRows(orgRows)
+		mock.ExpectQuery(regexp.QuoteMeta(approvalQuery)).WithArgs(transferReq.OrderID).WillReturnRows(sqlmock.NewRows([]string{}))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET held = wallets.held + $1, updated_at = $2 WHERE id = $3")).
+			WithArgs(transferReq.Amount, tn, 9).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO transfer_approvals (order_id, org_id, wallet_id, requester_user_id, to_user_id, amount, required_approvals, status, expire_at, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id")).
+			WithArgs(transferReq.OrderID, transferReq.OrgID, 9, transferReq.FromUserID, transferReq.ToUserID, transferReq.Amount, 2, data.ApprovalStatusPending, tn+3600, tn, tn).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+		mock.ExpectCommit()
+
+		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
+		rsp, err := walletService.Transfer(transferReq)
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeApprovalPending, rsp.Code)
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestApproveTransfer(t *testing.T) {
+	ConnectDBRedis()
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	defer DisconnectDBRedis()
+
+	mockDBCli := db.GetDbClientMock()
+	mock := db.GetSqlMock()
+	t.Run("case1: approve transfer success-[approval recorded, more approvals needed]", func(t *testing.T) {
+		logID := util.Uniqid()
+		ctx := context.Background()
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, "transfer:"+logID, 5)
+		approveReq := &data.ApproveTransferReq{OrgID: 1, UserID: 101, OrderID: logID}
+		tn := time.Now().Unix()
+		// mock DB data
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(approvalQuery)).WithArgs(approveReq.OrderID).
+			WillReturnRows(sqlmock.NewRows(approvalColumns).AddRow(5, approveReq.OrderID, 1, 9, 102, 201, 800.00, 2, data.ApprovalStatusPending, tn+3600, tn, tn))
+		mock.ExpectQuery(regexp.QuoteMeta(memberQuery)).WithArgs(approveReq.OrgID, approveReq.UserID).
+			WillReturnRows(sqlmock.NewRows(memberColumns).AddRow(1, 1, 101, data.OrgRoleOwner, 0, tn, tn))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO approval_votes (approval_id, approver_user_id, created_at) VALUES ($1, $2, $3) ON CONFLICT (approval_id, approver_user_id) DO NOTHING")).
+			WithArgs(5, approveReq.UserID, tn).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,approval_id,approver_user_id,created_at FROM approval_votes WHERE approval_id = $1 ORDER BY id")).
+			WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"id", "approval_id", "approver_user_id", "created_at"}).AddRow(1, 5, 101, tn))
+		mock.ExpectCommit()
+
+		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
+		rsp, err := walletService.ApproveTransfer(approveReq)
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		assert.Equal(t, "Approval recorded", rsp.Message)
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("case2: approve transfer success-[last approval executes the transfer]", func(t *testing.T) {
+		logID := util.Uniqid()
+		ctx := context.Background()
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, "transfer:"+logID, 5)
+		approveReq := &data.ApproveTransferReq{OrgID: 1, UserID: 103, OrderID: logID}
+		tn := time.Now().Unix()
+		// mock DB data
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(approvalQuery)).WithArgs(approveReq.OrderID).
+			WillReturnRows(sqlmock.NewRows(approvalColumns).AddRow(5, approveReq.OrderID, 1, 9, 102, 201, 800.00, 2, data.ApprovalStatusPending, tn+3600, tn, tn))
+		mock.ExpectQuery(regexp.QuoteMeta(memberQuery)).WithArgs(approveReq.OrgID, approveReq.UserID).
+			WillReturnRows(sqlmock.NewRows(memberColumns).AddRow(3, 1, 103, data.OrgRoleSpender, 100.00, tn, tn))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO approval_votes (approval_id, approver_user_id, created_at) VALUES ($1, $2, $3) ON CONFLICT (approval_id, approver_user_id) DO NOTHING")).
+			WithArgs(5, approveReq.UserID, tn).WillReturnResult(sqlmock.NewResult(2, 1))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,approval_id,approver_user_id,created_at FROM approval_votes WHERE approval_id = $1 ORDER BY id")).
+			WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"id", "approval_id", "approver_user_id", "created_at"}).AddRow(1, 5, 101, tn).AddRow(2, 5, 103, tn))
+		walletRows := sqlmock.NewRows([]string{"id", "user_id", "org_id", "name", "balance", "credit_limit", "held", "product", "created_at", "updated_at"}).AddRow(9, 0, 1, data.DefaultPocket, 2000.00, 0, 800.00, "", tn, tn)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE id = $1")).
+			WithArgs(9).WillReturnRows(walletRows)
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET held = wallets.held - $1, updated_at = $2 WHERE id = $3")).
+			WithArgs(800.00, tn, 9).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET balance = wallets.balance - $1, updated_at = $2 WHERE id = $3")).
+			WithArgs(800.00, tn, 9).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 AND name = $2")).
+			WithArgs(201, data.DefaultPocket).WillReturnRows(sqlmock.NewRows([]string{}))
+		mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO wallets (user_id, name, balance, created_at, updated_at) VALUES ($1, $2, $3, $4, $5) RETURNING id")).
+			WithArgs(201, data.DefaultPocket, 800.00, tn, tn).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(12))
+		expectChainHead(mock, 9)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions (order_id, user_id, wallet_id, tx_type, amount, related_user_id, actor_user_id, created_at, updated_at, prev_hash, hash) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)")).
+			WithArgs(approveReq.OrderID, 0, 9, data.TxTypeTransferOut, 800.00, 201, 102, tn, tn, "", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		expectChainHeadUpdate(mock, 9)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO outbox")).WillReturnResult(sqlmock.NewResult(1, 1))
+		expectChainHead(mock, 12)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions (order_id, user_id, wallet_id, tx_type, amount, related_user_id, actor_user_id, created_at, updated_at, prev_hash, hash) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)")).
+			WithArgs(approveReq.OrderID, 201, 12, data.TxTypeTransferIn, 800.00, 102, 102, tn, tn, "", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		expectChainHeadUpdate(mock, 12)
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE transfer_approvals SET status = $1, updated_at = $2 WHERE id = $3 AND status = $4")).
+			WithArgs(data.ApprovalStatusExecuted, tn, 5, data.ApprovalStatusPending).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
+		rsp, err := walletService.ApproveTransfer(approveReq)
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		assert.Equal(t, "Transfer successful", rsp.Message)
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("case3: approve transfer fail-[requester approves own transfer]", func(t *testing.T) {
+		logID := util.Uniqid()
+		ctx := context.Background()
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, "transfer:"+logID, 5)
+		approveReq := &data.ApproveTransferReq{OrgID: 1, UserID: 102, OrderID: logID}
+		tn := time.Now().Unix()
+		// mock DB data
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(approvalQuery)).WithArgs(approveReq.OrderID).
+			WillReturnRows(sqlmock.NewRows(approvalColumns).AddRow(5, approveReq.OrderID, 1, 9, 102, 201, 800.00, 2, data.ApprovalStatusPending, tn+3600, tn, tn))
+		mock.ExpectQuery(regexp.QuoteMeta(memberQuery)).WithArgs(approveReq.OrgID, approveReq.UserID).
+			WillReturnRows(sqlmock.NewRows(memberColumns).AddRow(2, 1, 102, data.OrgRoleSpender, 500.00, tn, tn))
+		mock.ExpectRollback()
+
+		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
+		rsp, err := walletService.ApproveTransfer(approveReq)
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodePermissionDenied, rsp.Code)
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("case4: approve transfer fail-[approval expired, hold released]", func(t *testing.T) {
+		logID := util.Uniqid()
+		ctx := context.Background()
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, "transfer:"+logID, 5)
+		approveReq := &data.ApproveTransferReq{OrgID: 1, UserID: 101, OrderID: logID}
+		tn := time.Now().Unix()
+		// mock DB data
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(approvalQuery)).WithArgs(approveReq.OrderID).
+			WillReturnRows(sqlmock.NewRows(approvalColumns).AddRow(5, approveReq.OrderID, 1, 9, 102, 201, 800.00, 2, data.ApprovalStatusPending, tn-1, tn-3601, tn-3601))
+		mock.ExpectQuery(regexp.QuoteMeta(memberQuery)).WithArgs(approveReq.OrgID, approveReq.UserID).
+			WillReturnRows(sqlmock.NewRows(memberColumns).AddRow(1, 1, 101, data.OrgRoleOwner, 0, tn, tn))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE transfer_approvals SET status = $1, updated_at = $2 WHERE id = $3 AND status = $4")).
+			WithArgs(data.ApprovalStatusExpired, tn, 5, data.ApprovalStatusPending).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET held = wallets.held - $1, updated_at = $2 WHERE id = $3")).
+			WithArgs(800.00, tn, 9).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
+		rsp, err := walletService.ApproveTransfer(approveReq)
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodeApprovalExpired, rsp.Code)
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
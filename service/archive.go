@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"simplewallet/service/dao"
+	"simplewallet/util"
+	"simplewallet/util/db"
+	"time"
+)
+
+// partitionsAhead is how many months of partitions exist past the current one
+const partitionsAhead = 2
+
+// DefaultArchiveTimeout bounds each db transaction of the archive on a shard, moving a month of transactions
+// takes far longer than the db timeouts of the requests
+const DefaultArchiveTimeout = 10 * time.Minute
+
+// WithArchive makes the history read the archive of the store when its range reaches before the archive watermark
+func (s *WalletService) WithArchive(archive bool) *WalletService {
+	s.archive = archive
+	return s
+}
+
+// archivedFilter sets the filter to read the archive too when the transactions it selects may be archived
+func (s *WalletService) archivedFilter(repos dao.Repos, filter *dao.TxFilter) error {
+	if !s.archive {
+		return nil
+	}
+	before, err := repos.Archive().GetArchivedBefore()
+	if err != nil {
+		return err
+	}
+	filter.Archived = before > 0 && filter.StartTime < before
+	return nil
+}
+
+// ArchiveService keeps the monthly partitions of transactions ahead of time and moves
+// the months older than the retention to transactions_archive, on every shard.
+type ArchiveService struct {
+	logID     string
+	ctx       context.Context
+	stores    []dao.Store   // the shards, or the one store
+	retention time.Duration // 0 only creates the partitions
+	locker    util.DistributedLock
+}
+
+// NewArchiveService returns the service on every shard of util/db. Its stores are bounded by timeout instead of
+// the db timeouts of the requests, each statement and each db transaction, DefaultArchiveTimeout when 0.
+func NewArchiveService(ctx context.Context, logID string, dbCli *sql.DB, retention time.Duration, timeout time.Duration, locker util.DistributedLock) *ArchiveService {
+	if timeout <= 0 {
+		timeout = DefaultArchiveTimeout
+	}
+	stores := make([]dao.Store, 0)
+	for _, cli := range shardClients(dbCli) {
+		stores = append(stores, dao.NewSqlStore(ctx, logID, cli, db.GetDbDriver()).
+			WithTimeouts(dao.Timeouts{Query: timeout, Exec: timeout, Tx: timeout}).WithIsolation(db.GetDbIsolation()))
+	}
+	return NewArchiveServiceWithStores(ctx, logID, stores, retention, locker)
+}
+
+func NewArchiveServiceWithStores(ctx context.Context, logID string, stores []dao.Store, retention time.Duration, locker util.DistributedLock) *ArchiveService {
+	return &ArchiveService{
+		ctx:       ctx,
+		logID:     logID,
+		stores:    stores,
+		retention: retention,
+		locker:    locker,
+	}
+}
+
+// monthStart returns the start of the utc month of t
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// Run creates the partitions up to partitionsAhead months after now, then archives the transactions
+// of the months ended before now minus the retention. Returns how many transactions moved.
+func (s *ArchiveService) Run(now time.Time) (moved int64, err error) {
+	if err = s.locker.Lock(); err != nil {
+		return 0, err
+	}
+	defer func() {
+		if errt := s.locker.UnLock(); errt != nil && err == nil {
+			err = errt
+		}
+	}()
+
+	var errs []error
+	for shard, store := range s.stores {
+		n, err := s.runStore(store, now)
+		if err != nil {
+			log.Printf("%s|fail to archive transactions of shard %d:%s\n", s.logID, shard, err.Error())
+			errs = append(errs, err)
+			continue
+		}
+		moved += n
+	}
+	return moved, errors.Join(errs...)
+}
+
+func (s *ArchiveService) runStore(store dao.Store, now time.Time) (int64, error) {
+	// no retries, the archive job runs again on its next tick
+	runner := dao.NewTxRunner(s.ctx, s.logID, store, dao.RetryPolicy{})
+	err := runner.Run(func(tx dao.UnitOfWork) error {
+		created, err := tx.Archive().CreatePartitions(now.Unix(), monthStart(now).AddDate(0, partitionsAhead+1, 0).Unix())
+		for _, name := range created {
+			log.Printf("%s|partition %s created\n", s.logID, name)
+		}
+		return err
+	})
+	if err != nil || s.retention <= 0 {
+		return 0, err
+	}
+	var moved int64
+	before := monthStart(now.Add(-s.retention)).Unix()
+	err = runner.Run(func(tx dao.UnitOfWork) error {
+		var err error
+		moved, err = tx.Archive().ArchiveTransactions(before)
+		return err
+	})
+	return moved, err
+}
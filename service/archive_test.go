@@ -0,0 +1,96 @@
+package service_test
+
+import (
+	"context"
+	"fmt"
+	"simplewallet/data"
+	"simplewallet/service"
+	"simplewallet/service/dao"
+	"simplewallet/util"
+	"simplewallet/util/db"
+	"simplewallet/util/errcode"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+func newArchiveService(stores []dao.Store, retention time.Duration) *service.ArchiveService {
+	logID := util.Uniqid()
+	ctx := context.Background()
+	return service.NewArchiveServiceWithStores(ctx, logID, stores, retention, util.NewDistributedLock(ctx, db.GetRedisClient(), logID, "archive:"+logID, 5))
+}
+
+func TestArchive(t *testing.T) {
+	ConnectDBRedis()
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	defer DisconnectDBRedis()
+
+	t.Run("case1: archive success-[months past the retention moved, history reads them]", func(t *testing.T) {
+		store := dao.NewMemoryStore()
+		for i, amount := range []float64{100.00, 50.00} {
+			rsp, err := newMemoryWalletService(store, "deposit").Deposit(&data.DepositReq{OrderID: fmt.Sprintf("100%d", i+1), UserID: 101, Amount: amount})
+			assert.Nil(t, err)
+			assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		}
+
+		// nothing is old enough yet
+		moved, err := newArchiveService([]dao.Store{store}, 30*24*time.Hour).Run(time.Now())
+		assert.Nil(t, err)
+		assert.Equal(t, int64(0), moved)
+		// three months later the month of the deposits ended before the retention
+		moved, err = newArchiveService([]dao.Store{store}, 30*24*time.Hour).Run(time.Now().AddDate(0, 3, 0))
+		assert.Nil(t, err)
+		assert.Equal(t, int64(2), moved)
+
+		rsp, err := newMemoryWalletService(store, "deposit").Deposit(&data.DepositReq{OrderID: "1003", UserID: 101, Amount: 10.00})
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		hisRsp, err := newMemoryWalletService(store, "").WithArchive(true).GetTransactionHistory(&data.GetTransactionHistoryReq{UserID: 101, Limit: 10})
+		assert.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, hisRsp.Code)
+		assert.Equal(t, int64(3), hisRsp.Data.Total)
+		assert.Equal(t, []string{"1001", "1002", "1003"}, []string{hisRsp.Data.Items[0].OrderID, hisRsp.Data.Items[1].OrderID, hisRsp.Data.Items[2].OrderID})
+		// a range starting after the watermark reads the live transactions only
+		hisRsp, err = newMemoryWalletService(store, "").WithArchive(true).GetTransactionHistory(&data.GetTransactionHistoryReq{UserID: 101, Limit: 10, StartTime: time.Now().AddDate(0, 3, 0).Unix()})
+		assert.Nil(t, err)
+		assert.Equal(t, int64(0), hisRsp.Data.Total)
+		// without the archive only the live one is read
+		hisRsp, err = newMemoryWalletService(store, "").GetTransactionHistory(&data.GetTransactionHistoryReq{UserID: 101, Limit: 10})
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1), hisRsp.Data.Total)
+
+		balanceRsp, err := newMemoryWalletService(store, "").GetBalance(&data.GetBalanceReq{UserID: 101})
+		assert.Nil(t, err)
+		assert.Equal(t, 160.00, balanceRsp.Data.Balance)
+	})
+
+	t.Run("case2: deposit fail-[order_id of an archived transaction]", func(t *testing.T) {
+		store := dao.NewMemoryStore()
+		rsp, err := newMemoryWalletService(store, "deposit").Deposit(&data.DepositReq{OrderID: "1001", UserID: 101, Amount: 100.00})
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		moved, err := newArchiveService([]dao.Store{store}, 24*time.Hour).Run(time.Now().AddDate(0, 2, 0))
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1), moved)
+
+		rsp, err = newMemoryWalletService(store, "deposit").Deposit(&data.DepositReq{OrderID: "1001", UserID: 101, Amount: 100.00})
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodeOrderIDRepeat, rsp.Code)
+	})
+
+	t.Run("case3: archive success-[no retention keeps every transaction]", func(t *testing.T) {
+		store := dao.NewMemoryStore()
+		rsp, err := newMemoryWalletService(store, "deposit").Deposit(&data.DepositReq{OrderID: "1001", UserID: 101, Amount: 100.00})
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		moved, err := newArchiveService([]dao.Store{store}, 0).Run(time.Now().AddDate(1, 0, 0))
+		assert.Nil(t, err)
+		assert.Equal(t, int64(0), moved)
+		before, err := store.Archive().GetArchivedBefore()
+		assert.Nil(t, err)
+		assert.Equal(t, int64(0), before)
+	})
+}
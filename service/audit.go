@@ -0,0 +1,144 @@
+package service
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"simplewallet/data"
+	"simplewallet/model"
+	"simplewallet/service/dao"
+	"simplewallet/util/errcode"
+	"slices"
+	"time"
+)
+
+// auditMaxList bounds a page of the audit trail
+const auditMaxList int32 = 100
+
+// AuditActor is who performs a non-customer action: an admin authenticated by the admin routes, or an operator command
+type AuditActor struct {
+	Name     string
+	LogID    string
+	SourceIP string // empty for a command
+}
+
+// WithAuditActor sets the admin the actions of the service are done by, recorded in the audit trail
+func (s *WalletService) WithAuditActor(actor *AuditActor) *WalletService {
+	s.actor = actor
+	return s
+}
+
+// recordAudit writes the audit record of an action in the repos of the action, a unit of work, so an action
+// committed always has its record and one rolled back has none. before and after are the target as json,
+// a nil before when the action created it.
+func recordAudit(repos dao.Repos, actor *AuditActor, action string, targetType string, targetID int64, before any, after any, reason string) error {
+	if actor == nil || actor.Name == "" {
+		return errors.New("no audit actor")
+	}
+	entry := &model.AdminAuditLog{Actor: actor.Name, Action: action, TargetType: targetType, TargetID: targetID, Reason: reason, LogID: actor.LogID, SourceIP: actor.SourceIP}
+	if before != nil {
+		b, err := json.Marshal(before)
+		if err != nil {
+			return err
+		}
+		entry.BeforeState = string(b)
+	}
+	b, err := json.Marshal(after)
+	if err != nil {
+		return err
+	}
+	entry.AfterState = string(b)
+	_, err = repos.Audit().InsertAuditLog(entry)
+	return err
+}
+
+// auditEntry is a record of the trail with its shard
+type auditEntry struct {
+	shard int
+	*model.AdminAuditLog
+}
+
+// GetAuditLogList lists the audit trail of every shard, newest first. The records of the shards are merged by
+// (created_at, shard, id), the cursor is the position of the last record of the page in that order.
+func (s *WalletService) GetAuditLogList(req *data.GetAuditLogListReq) (rsp *data.GetAuditLogListRsp, err error) {
+	rsp = &data.GetAuditLogListRsp{Code: errcode.ErrCodeInternalErr, Message: errcode.ErrMsgMap[errcode.ErrCodeInternalErr], LogID: s.logID}
+	defer func() { mapDbError(err, &rsp.Code, &rsp.Message) }()
+
+	after, afterShard, err := decodeAuditCursor(req.Cursor)
+	if err != nil {
+		rsp.Code = errcode.ErrCodeBadRequestParam
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return rsp, err
+	}
+	limit := req.Limit
+	if limit <= 0 || limit > auditMaxList {
+		limit = auditMaxList
+	}
+	filter := &dao.AuditFilter{Actor: req.Actor, Action: req.Action, TargetType: req.TargetType, TargetID: req.TargetID, StartTime: req.StartTime, EndTime: req.EndTime}
+
+	stores := []dao.Store{s.store}
+	if s.shards != nil {
+		for i := 1; i < s.shards.Len(); i++ {
+			stores = append(stores, s.shards.Shard(i))
+		}
+	}
+	entries := make([]auditEntry, 0)
+	for shard, store := range stores {
+		var before *dao.AuditKey
+		switch {
+		case after == nil:
+		case shard < afterShard:
+			// the records of the same second on the lower shards come after the cursor
+			before = &dao.AuditKey{CreatedAt: after.CreatedAt + 1}
+		case shard == afterShard:
+			before = after
+		default:
+			before = &dao.AuditKey{CreatedAt: after.CreatedAt}
+		}
+		entryList, err := store.Audit().GetAuditLogList(filter, before, limit+1)
+		if err != nil {
+			rsp.Code = errcode.ErrCodeQueryDBFail
+			rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
+			return rsp, err
+		}
+		for _, entry := range entryList {
+			entries = append(entries, auditEntry{shard: shard, AdminAuditLog: entry})
+		}
+	}
+	slices.SortFunc(entries, func(a, b auditEntry) int {
+		return cmp.Or(cmp.Compare(b.CreatedAt, a.CreatedAt), cmp.Compare(b.shard, a.shard), cmp.Compare(b.ID, a.ID))
+	})
+
+	rspData := &data.GetAuditLogListRspData{Items: make([]*data.GetAuditLogListRspDataItem, 0, min(len(entries), int(limit)))}
+	if len(entries) > int(limit) {
+		entries = entries[:limit]
+		last := entries[len(entries)-1]
+		rspData.NextCursor = encodeAuditCursor(dao.AuditKey{CreatedAt: last.CreatedAt, ID: last.ID}, last.shard)
+	}
+	for _, entry := range entries {
+		item := &data.GetAuditLogListRspDataItem{
+			Shard:      entry.shard,
+			ID:         entry.ID,
+			Actor:      entry.Actor,
+			Action:     entry.Action,
+			TargetType: entry.TargetType,
+			TargetID:   entry.TargetID,
+			Reason:     entry.Reason,
+			LogID:      entry.LogID,
+			SourceIP:   entry.SourceIP,
+			CreatedAt:  time.Unix(entry.CreatedAt, 0).Format("2006-01-02 15:04:05"),
+		}
+		if entry.BeforeState != "" {
+			item.Before = json.RawMessage(entry.BeforeState)
+		}
+		if entry.AfterState != "" {
+			item.After = json.RawMessage(entry.AfterState)
+		}
+		rspData.Items = append(rspData.Items, item)
+	}
+
+	rsp.Code = errcode.ErrCodeSuccess
+	rsp.Message = "Success"
+	rsp.Data = rspData
+	return rsp, nil
+}
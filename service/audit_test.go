@@ -0,0 +1,137 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"simplewallet/data"
+	"simplewallet/model"
+	"simplewallet/service"
+	"simplewallet/service/dao"
+	"simplewallet/util"
+	"simplewallet/util/errcode"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+func TestAudit(t *testing.T) {
+	ConnectDBRedis()
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	defer DisconnectDBRedis()
+
+	insertAudit := func(t *testing.T, store dao.Store, actor string, action string, targetID int64) {
+		_, err := store.Audit().InsertAuditLog(&model.AdminAuditLog{Actor: actor, Action: action, TargetType: data.AuditTargetWallet, TargetID: targetID, AfterState: "{}"})
+		require.Nil(t, err)
+	}
+
+	t.Run("case1: list success-[every shard merged, filtered and paged]", func(t *testing.T) {
+		shards := newTwoShards(dao.NewMemoryStore(), dao.NewMemoryStore())
+		for i := int64(1); i <= 3; i++ {
+			insertAudit(t, shards.Shard(0), "alice", data.AuditActionReconcileFix, i)
+			insertAudit(t, shards.Shard(1), "bob", data.AuditActionReconcileFix, i)
+		}
+
+		s := newShardedWalletService(shards, "audit")
+		seen := make(map[[2]int64]bool)
+		cursor := ""
+		for page := 0; page < 3; page++ {
+			rsp, err := s.GetAuditLogList(&data.GetAuditLogListReq{Cursor: cursor, Limit: 2})
+			require.Nil(t, err)
+			require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+			require.Len(t, rsp.Data.Items, 2)
+			for _, item := range rsp.Data.Items {
+				key := [2]int64{int64(item.Shard), item.ID}
+				assert.False(t, seen[key], "listed once")
+				seen[key] = true
+			}
+			cursor = rsp.Data.NextCursor
+		}
+		assert.Len(t, seen, 6)
+		assert.Empty(t, cursor, "no page after the last one")
+
+		rsp, err := s.GetAuditLogList(&data.GetAuditLogListReq{Actor: "bob", TargetType: data.AuditTargetWallet, TargetID: 2, Limit: 10})
+		require.Nil(t, err)
+		require.Len(t, rsp.Data.Items, 1)
+		assert.Equal(t, 1, rsp.Data.Items[0].Shard)
+		assert.Equal(t, int64(2), rsp.Data.Items[0].TargetID)
+		assert.Nil(t, rsp.Data.Items[0].Before)
+		assert.JSONEq(t, "{}", string(rsp.Data.Items[0].After))
+	})
+
+	t.Run("case2: list fail-[bad cursor]", func(t *testing.T) {
+		rsp, err := newMemoryWalletService(dao.NewMemoryStore(), "audit").GetAuditLogList(&data.GetAuditLogListReq{Cursor: "bm90LWEtY3Vyc29y", Limit: 10})
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodeBadRequestParam, rsp.Code)
+	})
+
+	t.Run("case3: reconcile success-[a fix recorded with its actor, a drift not fixed not recorded]", func(t *testing.T) {
+		store := dao.NewMemoryStore()
+		rsp, err := newMemoryWalletService(store, "deposit").Deposit(&data.DepositReq{OrderID: "1001", UserID: 101, Amount: 10.00})
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		wallet, err := store.Wallets().GetWalletByUserID(101, data.DefaultPocket)
+		require.Nil(t, err)
+		require.Nil(t, store.Wallets().UpdateWalletBalance(wallet.ID, data.TxTypeDeposit, 5.00))
+
+		result, _ := runReconcile(t, store, 0, false)
+		assert.Equal(t, int64(1), result.Drifted)
+		entries, err := store.Audit().GetAuditLogList(&dao.AuditFilter{}, nil, 10)
+		require.Nil(t, err)
+		assert.Empty(t, entries)
+
+		actor := &service.AuditActor{Name: "cli:ops", LogID: "reconcile"}
+		result, err = service.NewReconcileServiceWithStores(context.Background(), util.Uniqid(), []dao.Store{store}, 0, true).
+			WithAuditActor(actor).Run(func(drift *service.BalanceDrift) {})
+		require.Nil(t, err)
+		assert.Equal(t, int64(1), result.Fixed)
+		entries, err = store.Audit().GetAuditLogList(&dao.AuditFilter{}, nil, 10)
+		require.Nil(t, err)
+		require.Len(t, entries, 1)
+		assert.Equal(t, "cli:ops", entries[0].Actor)
+		assert.Equal(t, data.AuditActionReconcileFix, entries[0].Action)
+		assert.Equal(t, wallet.ID, entries[0].TargetID)
+		var before, after map[string]float64
+		require.Nil(t, json.Unmarshal([]byte(entries[0].BeforeState), &before))
+		require.Nil(t, json.Unmarshal([]byte(entries[0].AfterState), &after))
+		assert.Equal(t, 15.00, before["balance"])
+		assert.Equal(t, 10.00, after["balance"])
+	})
+
+	t.Run("case4: reconcile fail-[the audit record not written, the fix rolled back]", func(t *testing.T) {
+		store := dao.NewMemoryStore()
+		rsp, err := newMemoryWalletService(store, "deposit").Deposit(&data.DepositReq{OrderID: "1001", UserID: 101, Amount: 10.00})
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		wallet, err := store.Wallets().GetWalletByUserID(101, data.DefaultPocket)
+		require.Nil(t, err)
+		require.Nil(t, store.Wallets().UpdateWalletBalance(wallet.ID, data.TxTypeDeposit, 5.00))
+
+		drifts := make([]*service.BalanceDrift, 0)
+		result, err := service.NewReconcileServiceWithStores(context.Background(), util.Uniqid(), []dao.Store{store}, 0, true).
+			WithAuditActor(nil).Run(func(drift *service.BalanceDrift) {
+			drifts = append(drifts, drift)
+		})
+		require.Nil(t, err)
+		assert.Equal(t, int64(0), result.Fixed)
+		require.Len(t, drifts, 1)
+		assert.NotNil(t, drifts[0].Err)
+		wallet, err = store.Wallets().GetWalletByID(wallet.ID)
+		require.Nil(t, err)
+		assert.Equal(t, 15.00, wallet.Balance)
+	})
+
+	t.Run("case5: audit fail-[records can't be updated or deleted]", func(t *testing.T) {
+		store, dbCli := newChainStore(t)
+		insertAudit(t, store, "alice", data.AuditActionReconcileFix, 1)
+		_, err := dbCli.Exec("UPDATE admin_audit_logs SET actor = 'mallory'")
+		assert.NotNil(t, err)
+		_, err = dbCli.Exec("DELETE FROM admin_audit_logs")
+		assert.NotNil(t, err)
+		entries, err := store.Audit().GetAuditLogList(&dao.AuditFilter{}, nil, 10)
+		require.Nil(t, err)
+		require.Len(t, entries, 1)
+		assert.Equal(t, "alice", entries[0].Actor)
+	})
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"log"
+	"simplewallet/data"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// balanceCacheStats counts the cached balance reads of every service instance, served on /debug/vars
+var balanceCacheStats = expvar.NewMap("balance_cache")
+
+func init() {
+	balanceCacheStats.Set("hit_ratio", expvar.Func(func() any {
+		_, _, ratio := BalanceCacheStats()
+		return ratio
+	}))
+}
+
+// BalanceCacheStats returns how many balance reads were served from the cache and how many went to the db
+func BalanceCacheStats() (hits int64, misses int64, ratio float64) {
+	if v, ok := balanceCacheStats.Get("hits").(*expvar.Int); ok {
+		hits = v.Value()
+	}
+	if v, ok := balanceCacheStats.Get("misses").(*expvar.Int); ok {
+		misses = v.Value()
+	}
+	if hits+misses > 0 {
+		ratio = float64(hits) / float64(hits+misses)
+	}
+	return hits, misses, ratio
+}
+
+// setBalanceScript caches a balance read at a version only while the version is still the current one,
+// a read which raced with a write never overwrites the balance of the write
+var setBalanceScript = redis.NewScript(`
+local version = redis.call("GET", KEYS[1]) or "0"
+if version ~= ARGV[1] then return 0 end
+redis.call("SET", KEYS[2], ARGV[1] .. "|" .. ARGV[2], "PX", ARGV[3])
+return 1`)
+
+// BalanceCache caches the balances of the users' own wallets in redis. Every committed write to the
+// wallets of a user bumps their version, a cached balance of another version is never served. The
+// versions never expire: a version which expired would count again from 0, and a read in flight at
+// a version reused that way could cache a balance older than the last write.
+type BalanceCache struct {
+	cli redis.Cmdable
+	ttl time.Duration
+}
+
+func NewBalanceCache(cli redis.Cmdable, ttl time.Duration) *BalanceCache {
+	return &BalanceCache{cli: cli, ttl: ttl}
+}
+
+// the keys of a user share a hash tag, so the scripts also run on a redis cluster
+func balanceVersionKey(userID int64) string {
+	return "balance:{" + strconv.FormatInt(userID, 10) + "}:version"
+}
+
+func balanceKey(userID int64) string {
+	return "balance:{" + strconv.FormatInt(userID, 10) + "}"
+}
+
+// Get returns the cached balance of the user, nil when there is none of the current version or redis
+// fails. The current version is returned either way, a balance read from the db is cached at it.
+func (c *BalanceCache) Get(ctx context.Context, userID int64) (*data.GetBalanceRspData, string) {
+	if c == nil {
+		return nil, ""
+	}
+	vals, err := c.cli.MGet(ctx, balanceVersionKey(userID), balanceKey(userID)).Result()
+	if err != nil {
+		log.Println("Failed to get cached balance" + err.Error())
+		balanceCacheStats.Add("misses", 1)
+		return nil, ""
+	}
+	version := "0"
+	if v, ok := vals[0].(string); ok {
+		version = v
+	}
+	entry, _ := vals[1].(string)
+	cachedVersion, payload, found := strings.Cut(entry, "|")
+	if !found || cachedVersion != version {
+		balanceCacheStats.Add("misses", 1)
+		return nil, version
+	}
+	balance := &data.GetBalanceRspData{}
+	if err = json.Unmarshal([]byte(payload), balance); err != nil {
+		log.Println("Failed to decode cached balance" + err.Error())
+		balanceCacheStats.Add("misses", 1)
+		return nil, version
+	}
+	balanceCacheStats.Add("hits", 1)
+	return balance, version
+}
+
+// Set caches the balance of the user read from the db at the version Get returned. It is dropped
+// when the version changed since, the balance may have been read before the write which changed it.
+func (c *BalanceCache) Set(ctx context.Context, userID int64, version string, balance *data.GetBalanceRspData) bool {
+	if c == nil || version == "" {
+		return false
+	}
+	payload, err := json.Marshal(balance)
+	if err != nil {
+		log.Println("Failed to encode balance" + err.Error())
+		return false
+	}
+	set, err := setBalanceScript.Run(ctx, c.cli, []string{balanceVersionKey(userID), balanceKey(userID)}, version, payload, c.ttl.Milliseconds()).Int()
+	if err != nil {
+		log.Println("Failed to cache balance" + err.Error())
+		return false
+	}
+	return set == 1
+}
+
+// Invalidate bumps the versions of the users after a write to their wallets committed and drops their
+// cached balances. Should redis fail, a stale balance is served for the ttl at most.
+func (c *BalanceCache) Invalidate(ctx context.Context, userIDs ...int64) {
+	if c == nil {
+		return
+	}
+	_, err := c.cli.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, userID := range userIDs {
+			pipe.Incr(ctx, balanceVersionKey(userID))
+			pipe.Del(ctx, balanceKey(userID))
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("Failed to invalidate cached balances" + err.Error())
+	}
+}
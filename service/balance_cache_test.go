@@ -0,0 +1,178 @@
+package service_test
+
+import (
+	"context"
+	"simplewallet/data"
+	"simplewallet/service"
+	"simplewallet/service/dao"
+	"simplewallet/util"
+	"simplewallet/util/db"
+	"simplewallet/util/errcode"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+// ctxBus records the error of the contexts it was published with
+type ctxBus struct {
+	service.EventBus
+	errs []error
+}
+
+func (b *ctxBus) Publish(ctx context.Context, userID int64) error {
+	b.errs = append(b.errs, ctx.Err())
+	return b.EventBus.Publish(ctx, userID)
+}
+
+// newCachedWalletService returns a wallet service on the store caching balances in the in-process redis
+func newCachedWalletService(store dao.Store, op string) *service.WalletService {
+	return newMemoryWalletService(store, op).WithBalanceCache(service.NewBalanceCache(db.GetRedisClient(), time.Minute))
+}
+
+func TestBalanceCache(t *testing.T) {
+	ConnectDBRedis()
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	defer DisconnectDBRedis()
+
+	deposit := func(t *testing.T, store dao.Store, orderID string, userID int64, amount float64) {
+		rsp, err := newCachedWalletService(store, "deposit").Deposit(&data.DepositReq{OrderID: orderID, UserID: userID, Amount: amount})
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+	}
+	balanceOf := func(t *testing.T, store dao.Store, userID int64) float64 {
+		rsp, err := newCachedWalletService(store, "").GetBalance(&data.GetBalanceReq{UserID: userID})
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		return rsp.Data.Balance
+	}
+
+	t.Run("case1: get balance success-[cached on read, served from the cache]", func(t *testing.T) {
+		db.GetRedisMock().FlushAll()
+		store := dao.NewMemoryStore()
+		deposit(t, store, "1001", 101, 100.00)
+		hits, misses, _ := service.BalanceCacheStats()
+
+		assert.Equal(t, 100.00, balanceOf(t, store, 101))
+		// changed behind the cache's back, the cached balance is served
+		_, err := store.Wallets().CreateOrUpdateWallet(101, data.DefaultPocket, 1.00)
+		require.Nil(t, err)
+		assert.Equal(t, 100.00, balanceOf(t, store, 101))
+
+		hitsAfter, missesAfter, ratio := service.BalanceCacheStats()
+		assert.Equal(t, hits+1, hitsAfter)
+		assert.Equal(t, misses+1, missesAfter)
+		assert.Greater(t, ratio, 0.0)
+	})
+
+	t.Run("case2: get balance success-[invalidated by deposit, withdraw and transfer]", func(t *testing.T) {
+		db.GetRedisMock().FlushAll()
+		store := dao.NewMemoryStore()
+		deposit(t, store, "1001", 101, 100.00)
+		assert.Equal(t, 100.00, balanceOf(t, store, 101))
+		version, err := db.GetRedisMock().Get("balance:{101}:version")
+		require.Nil(t, err)
+		assert.Equal(t, "1", version, "bumped by the deposit")
+
+		deposit(t, store, "1002", 101, 50.00)
+		assert.Equal(t, 150.00, balanceOf(t, store, 101))
+		rsp, err := newCachedWalletService(store, "withdraw").Withdraw(&data.WithdrawReq{OrderID: "1003", UserID: 101, Amount: 20.00})
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		assert.Equal(t, 130.00, balanceOf(t, store, 101))
+
+		deposit(t, store, "1004", 102, 1.00)
+		assert.Equal(t, 1.00, balanceOf(t, store, 102))
+		rsp, err = newCachedWalletService(store, "transfer").Transfer(&data.TransferReq{OrderID: "1005", FromUserID: 101, ToUserID: 102, Amount: 30.00})
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		assert.Equal(t, 100.00, balanceOf(t, store, 101))
+		assert.Equal(t, 31.00, balanceOf(t, store, 102))
+	})
+
+	t.Run("case3: set balance fail-[read before a write, never overwrites it]", func(t *testing.T) {
+		db.GetRedisMock().FlushAll()
+		ctx := context.Background()
+		cache := service.NewBalanceCache(db.GetRedisClient(), time.Minute)
+		balance, version := cache.Get(ctx, 101)
+		assert.Nil(t, balance)
+		assert.Equal(t, "0", version)
+
+		// a write commits and invalidates between the db read and the set of the read
+		cache.Invalidate(ctx, 101)
+		assert.False(t, cache.Set(ctx, 101, version, &data.GetBalanceRspData{Balance: 100.00}))
+		balance, version = cache.Get(ctx, 101)
+		assert.Nil(t, balance)
+		assert.Equal(t, "1", version)
+
+		assert.True(t, cache.Set(ctx, 101, version, &data.GetBalanceRspData{Balance: 150.00}))
+		balance, _ = cache.Get(ctx, 101)
+		require.NotNil(t, balance)
+		assert.Equal(t, 150.00, balance.Balance)
+	})
+
+	t.Run("case4: get balance success-[version mismatch read from the db]", func(t *testing.T) {
+		db.GetRedisMock().FlushAll()
+		store := dao.NewMemoryStore()
+		deposit(t, store, "1001", 101, 100.00)
+		assert.Equal(t, 100.00, balanceOf(t, store, 101))
+
+		// an entry of another version, e.g. left by a set which lost its race
+		db.GetRedisMock().Set("balance:{101}", `7|{"balance":1000000}`)
+		assert.Equal(t, 100.00, balanceOf(t, store, 101))
+		db.GetRedisMock().Set("balance:{101}", "not an entry")
+		assert.Equal(t, 100.00, balanceOf(t, store, 101))
+	})
+
+	t.Run("case5: get balance success-[redis down, read from the db]", func(t *testing.T) {
+		store := dao.NewMemoryStore()
+		deposit(t, store, "1001", 101, 100.00)
+		cli := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", DialTimeout: 50 * time.Millisecond, MaxRetries: -1})
+		defer cli.Close()
+		s := newMemoryWalletService(store, "").WithBalanceCache(service.NewBalanceCache(cli, time.Minute))
+		rsp, err := s.GetBalance(&data.GetBalanceReq{UserID: 101})
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		assert.Equal(t, 100.00, rsp.Data.Balance)
+	})
+
+	t.Run("case6: get balance success-[request cancelled after the commit, invalidated and notified anyway]", func(t *testing.T) {
+		db.GetRedisMock().FlushAll()
+		store := dao.NewMemoryStore()
+		deposit(t, store, "1001", 101, 100.00)
+		assert.Equal(t, 100.00, balanceOf(t, store, 101))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		bus := &ctxBus{EventBus: service.NewLocalBus()}
+		logID := util.Uniqid()
+		s := service.NewWalletServiceWithStore(ctx, logID, store, util.NewDistributedLock(context.Background(), db.GetRedisClient(), logID, "deposit:"+logID, 5)).
+			WithBalanceCache(service.NewBalanceCache(db.GetRedisClient(), time.Minute)).WithEventBus(bus)
+		rsp, err := s.Deposit(&data.DepositReq{OrderID: "1002", UserID: 101, Amount: 50.00})
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		assert.Equal(t, 150.00, balanceOf(t, store, 101))
+		assert.Equal(t, []error{nil}, bus.errs)
+	})
+
+	t.Run("case7: set balance fail-[a version is never reused, however long the user is idle]", func(t *testing.T) {
+		db.GetRedisMock().FlushAll()
+		ctx := context.Background()
+		cache := service.NewBalanceCache(db.GetRedisClient(), time.Minute)
+		cache.Invalidate(ctx, 101)
+		_, version := cache.Get(ctx, 101)
+		assert.Equal(t, "1", version)
+
+		// a slow read at version 1, a write, the user idle for long, another write
+		cache.Invalidate(ctx, 101)
+		db.GetRedisMock().FastForward(time.Hour)
+		cache.Invalidate(ctx, 101)
+		assert.False(t, cache.Set(ctx, 101, version, &data.GetBalanceRspData{Balance: 100.00}))
+		balance, version := cache.Get(ctx, 101)
+		assert.Nil(t, balance)
+		assert.Equal(t, "3", version)
+	})
+}
@@ -0,0 +1,408 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"simplewallet/service/dao"
+	"simplewallet/util"
+	"time"
+)
+
+const (
+	// ChainBatch is how many wallets, or transactions of a wallet, the chain verification reads at a time
+	ChainBatch int32 = 500
+	// the wallets written to within this window before the last anchor are read again, a write committing late is not missed
+	anchorLookback = 5 * time.Minute
+	// heads per line of the anchor file
+	anchorLineHeads = 500
+	// a wallet written to on every attempt to verify it is left to the next run
+	chainVerifyAttempts = 3
+)
+
+// ChainBreak is the first broken link of the chain of a wallet
+type ChainBreak struct {
+	Shard    int
+	WalletID int64
+	TxID     int64 // the transaction breaking the chain, 0 when it is the head of the wallet
+	Reason   string
+}
+
+type ChainResult struct {
+	Wallets      int64
+	Transactions int64 // chained
+	Unchained    int64 // recorded before the chains
+	Broken       int64 // wallets
+}
+
+// ChainAnchor is a line of the anchor file: heads of the chains at a time, chained to the line before by its hash
+type ChainAnchor struct {
+	AnchoredAt int64           `json:"anchored_at"`
+	Heads      []*AnchoredHead `json:"heads"`
+	Prev       string          `json:"prev"` // hash of the line before, empty on the first one
+	Hash       string          `json:"hash"` // sha256 of the line with an empty hash, hex
+}
+
+type AnchoredHead struct {
+	Shard    int    `json:"shard"`
+	WalletID int64  `json:"wallet_id"`
+	Hash     string `json:"hash"`
+}
+
+type anchorKey struct {
+	shard    int
+	walletID int64
+}
+
+// hash returns the hash of the line, its json with an empty hash
+func (a *ChainAnchor) hash() (string, error) {
+	c := *a
+	c.Hash = ""
+	b, err := json.Marshal(&c)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// ChainService verifies the hash chains of the transactions of every wallet, on every shard, and anchors their
+// heads to an append-only file. A row altered or deleted breaks its chain; a chain rewritten whole from the
+// altered row on, heads included, no longer holds the head anchored before.
+type ChainService struct {
+	logID  string
+	ctx    context.Context
+	stores []dao.Store // the shards, or the one store
+	batch  int32
+	locker util.DistributedLock // held while anchoring
+}
+
+func NewChainService(ctx context.Context, logID string, dbCli *sql.DB, batch int32, locker util.DistributedLock) *ChainService {
+	return NewChainServiceWithStores(ctx, logID, shardStores(ctx, logID, dbCli), batch, locker)
+}
+
+func NewChainServiceWithStores(ctx context.Context, logID string, stores []dao.Store, batch int32, locker util.DistributedLock) *ChainService {
+	if batch <= 0 {
+		batch = ChainBatch
+	}
+	return &ChainService{
+		ctx:    ctx,
+		logID:  logID,
+		stores: stores,
+		batch:  batch,
+		locker: locker,
+	}
+}
+
+// LoadAnchors reads the anchor file, checking that every line is chained to the one before. A file not created yet has no anchors.
+func LoadAnchors(path string) ([]*ChainAnchor, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	anchors := make([]*ChainAnchor, 0)
+	prev := ""
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<24)
+	for line := 1; scanner.Scan(); line++ {
+		anchor := &ChainAnchor{}
+		if err = json.Unmarshal(scanner.Bytes(), anchor); err != nil {
+			return nil, fmt.Errorf("anchor line %d: %w", line, err)
+		}
+		hash, err := anchor.hash()
+		if err != nil {
+			return nil, fmt.Errorf("anchor line %d: %w", line, err)
+		}
+		if anchor.Prev != prev || anchor.Hash != hash {
+			return nil, fmt.Errorf("anchor line %d: not chained to the line before", line)
+		}
+		prev = anchor.Hash
+		anchors = append(anchors, anchor)
+	}
+	return anchors, scanner.Err()
+}
+
+// latestAnchored returns the last hash anchored of every wallet
+func latestAnchored(anchors []*ChainAnchor) map[anchorKey]string {
+	latest := make(map[anchorKey]string)
+	for _, anchor := range anchors {
+		for _, head := range anchor.Heads {
+			latest[anchorKey{head.Shard, head.WalletID}] = head.Hash
+		}
+	}
+	return latest
+}
+
+// Verify walks the chain of every wallet and reports the first broken link of each, the heads anchored
+// in anchors must still be on their chains. A shard failing to be read is logged and the others are
+// still verified, the failures are returned joined.
+func (s *ChainService) Verify(anchors []*ChainAnchor, report func(brk *ChainBreak)) (*ChainResult, error) {
+	result := &ChainResult{}
+	anchored := latestAnchored(anchors)
+	var errs []error
+	for shard, store := range s.stores {
+		if err := s.verifyStore(shard, store, anchored, result, report); err != nil {
+			log.Printf("%s|fail to verify the chains of shard %d:%s\n", s.logID, shard, err.Error())
+			errs = append(errs, err)
+		}
+	}
+	return result, errors.Join(errs...)
+}
+
+func (s *ChainService) verifyStore(shard int, store dao.Store, anchored map[anchorKey]string, result *ChainResult, report func(brk *ChainBreak)) error {
+	lastID := int64(0)
+	for {
+		heads, err := store.Chains().GetChainHeadList(0, lastID, s.batch)
+		if err != nil {
+			return err
+		}
+		for _, head := range heads {
+			result.Wallets++
+			brk, err := s.verifyWallet(store, head, anchored[anchorKey{shard, head.WalletID}], result)
+			if err != nil {
+				return err
+			}
+			if brk != nil {
+				brk.Shard = shard
+				result.Broken++
+				report(brk)
+			}
+		}
+		if len(heads) < int(s.batch) {
+			return nil
+		}
+		lastID = heads[len(heads)-1].WalletID
+	}
+}
+
+// verifyWallet returns the first broken link of the chain of the wallet, nil when there is none. The chain
+// has to reach the head read, a wallet whose head moved meanwhile is read again.
+func (s *ChainService) verifyWallet(store dao.Store, head *dao.ChainHead, anchored string, result *ChainResult) (*ChainBreak, error) {
+	for attempt := 1; ; attempt++ {
+		var chained, unchained int64
+		prev, found, reached := "", anchored == "", false
+		brk, err := func() (*ChainBreak, error) {
+			afterID := int64(0)
+			for {
+				links, err := store.Chains().GetChainLinkList(head.WalletID, afterID, s.batch)
+				if err != nil {
+					return nil, err
+				}
+				for _, tx := range links {
+					if tx.Hash == "" {
+						if prev != "" {
+							return &ChainBreak{WalletID: head.WalletID, TxID: tx.ID, Reason: "not chained, after chained transactions"}, nil
+						}
+						unchained++
+						continue
+					}
+					chained++
+					if tx.PrevHash != prev {
+						return &ChainBreak{WalletID: head.WalletID, TxID: tx.ID, Reason: "prev_hash is not the hash of the transaction before"}, nil
+					}
+					if dao.TxHash(tx) != tx.Hash {
+						return &ChainBreak{WalletID: head.WalletID, TxID: tx.ID, Reason: "hash does not match the contents"}, nil
+					}
+					prev = tx.Hash
+					found = found || prev == anchored
+					// the transactions after the head were written since it was read
+					reached = reached || prev == head.Hash
+				}
+				if len(links) < int(s.batch) {
+					return nil, nil
+				}
+				afterID = links[len(links)-1].ID
+			}
+		}()
+		if err != nil {
+			return nil, err
+		}
+		if brk == nil && prev != head.Hash && !reached {
+			heads, err := store.Chains().GetChainHeadList(0, head.WalletID-1, 1)
+			if err != nil {
+				return nil, err
+			}
+			if attempt < chainVerifyAttempts && len(heads) > 0 && heads[0].WalletID == head.WalletID && heads[0].Hash != head.Hash {
+				head = heads[0]
+				continue
+			}
+			brk = &ChainBreak{WalletID: head.WalletID, Reason: "head is not the hash of the last transaction"}
+		}
+		if brk == nil && !found {
+			brk = &ChainBreak{WalletID: head.WalletID, Reason: "anchored head " + anchored + " is not on the chain"}
+		}
+		result.Transactions += chained
+		result.Unchained += unchained
+		return brk, nil
+	}
+}
+
+// AnchorIndex follows the anchor file across anchoring runs: the hash and time of its last line and the last
+// hash anchored of every wallet. Only the lines appended since it last read the file are read and checked,
+// the whole file is left to the verification. Not safe for concurrent use.
+type AnchorIndex struct {
+	path   string
+	offset int64 // bytes of the file read
+	lines  int
+	last   *ChainAnchor
+	heads  map[anchorKey]string
+}
+
+func NewAnchorIndex(path string) *AnchorIndex {
+	return &AnchorIndex{path: path, heads: make(map[anchorKey]string)}
+}
+
+// tail reads the lines appended since the last read, each must be chained to the one before
+func (x *AnchorIndex) tail() error {
+	f, err := os.Open(x.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && x.offset == 0 {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < x.offset {
+		return fmt.Errorf("anchor file is shorter than the %d bytes read before", x.offset)
+	}
+	if _, err = f.Seek(x.offset, io.SeekStart); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(f)
+	for {
+		b, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(b) > 0 {
+				return fmt.Errorf("anchor line %d: incomplete", x.lines+1)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		anchor := &ChainAnchor{}
+		if err = json.Unmarshal(b, anchor); err != nil {
+			return fmt.Errorf("anchor line %d: %w", x.lines+1, err)
+		}
+		if err = x.add(anchor, int64(len(b))); err != nil {
+			return err
+		}
+	}
+}
+
+// add indexes a line of size bytes, it must be chained to the last one
+func (x *AnchorIndex) add(anchor *ChainAnchor, size int64) error {
+	hash, err := anchor.hash()
+	if err != nil {
+		return fmt.Errorf("anchor line %d: %w", x.lines+1, err)
+	}
+	prev := ""
+	if x.last != nil {
+		prev = x.last.Hash
+	}
+	if anchor.Prev != prev || anchor.Hash != hash {
+		return fmt.Errorf("anchor line %d: not chained to the line before", x.lines+1)
+	}
+	for _, head := range anchor.Heads {
+		x.heads[anchorKey{head.Shard, head.WalletID}] = head.Hash
+	}
+	x.offset += size
+	x.lines++
+	x.last = anchor
+	return nil
+}
+
+// Anchor appends the heads of the chains written to since the last anchor to the anchor file of index, one
+// instance at a time, and returns how many. Nothing is appended when no head moved.
+func (s *ChainService) Anchor(index *AnchorIndex, now time.Time) (n int, err error) {
+	if err = s.locker.Lock(); err != nil {
+		return 0, err
+	}
+	defer func() {
+		if errt := s.locker.UnLock(); errt != nil && err == nil {
+			err = errt
+		}
+	}()
+
+	// the lines appended by the other instances since
+	if err = index.tail(); err != nil {
+		return 0, err
+	}
+	since, prev := int64(0), ""
+	if index.last != nil {
+		since, prev = index.last.AnchoredAt-int64(anchorLookback/time.Second), index.last.Hash
+	}
+	heads := make([]*AnchoredHead, 0)
+	for shard, store := range s.stores {
+		lastID := int64(0)
+		for {
+			headList, err := store.Chains().GetChainHeadList(since, lastID, s.batch)
+			if err != nil {
+				log.Printf("%s|fail to read the chain heads of shard %d:%s\n", s.logID, shard, err.Error())
+				return 0, err
+			}
+			for _, head := range headList {
+				if head.Hash != "" && head.Hash != index.heads[anchorKey{shard, head.WalletID}] {
+					heads = append(heads, &AnchoredHead{Shard: shard, WalletID: head.WalletID, Hash: head.Hash})
+				}
+			}
+			if len(headList) < int(s.batch) {
+				break
+			}
+			lastID = headList[len(headList)-1].WalletID
+		}
+	}
+	if len(heads) == 0 {
+		return 0, nil
+	}
+
+	var buf bytes.Buffer
+	lines, sizes := make([]*ChainAnchor, 0), make([]int64, 0)
+	for start := 0; start < len(heads); start += anchorLineHeads {
+		anchor := &ChainAnchor{AnchoredAt: now.Unix(), Heads: heads[start:min(start+anchorLineHeads, len(heads))], Prev: prev}
+		if anchor.Hash, err = anchor.hash(); err != nil {
+			return 0, err
+		}
+		line, err := json.Marshal(anchor)
+		if err != nil {
+			return 0, err
+		}
+		buf.Write(append(line, '\n'))
+		lines, sizes = append(lines, anchor), append(sizes, int64(len(line)+1))
+		prev = anchor.Hash
+	}
+	f, err := os.OpenFile(index.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if _, err = f.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	if err = f.Sync(); err != nil {
+		return 0, err
+	}
+	for i, anchor := range lines {
+		if err = index.add(anchor, sizes[i]); err != nil {
+			return 0, err
+		}
+	}
+	return len(heads), nil
+}
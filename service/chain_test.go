@@ -0,0 +1,249 @@
+package service_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"simplewallet/data"
+	"simplewallet/service"
+	"simplewallet/service/dao"
+	"simplewallet/util"
+	"simplewallet/util/db"
+	"simplewallet/util/errcode"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+// newChainStore returns a sqlite store, whose rows the cases alter behind the daos
+func newChainStore(t *testing.T) (*dao.SqlStore, *sql.DB) {
+	dbCli, err := db.OpenSqlite(filepath.Join(t.TempDir(), "wallet.db"))
+	require.Nil(t, err)
+	t.Cleanup(func() { dbCli.Close() })
+	migrator, err := db.NewMigrator(dbCli, db.DriverSqlite)
+	require.Nil(t, err)
+	_, err = migrator.Up(context.Background())
+	require.Nil(t, err)
+	return dao.NewSqliteStore(context.Background(), "test", dbCli), dbCli
+}
+
+func newChainService(store dao.Store) *service.ChainService {
+	logID := util.Uniqid()
+	ctx := context.Background()
+	return service.NewChainServiceWithStores(ctx, logID, []dao.Store{store}, 2, util.NewDistributedLock(ctx, db.GetRedisClient(), logID, "chain:"+logID, 5))
+}
+
+func runVerify(t *testing.T, store dao.Store, anchors []*service.ChainAnchor) (*service.ChainResult, []*service.ChainBreak) {
+	brks := make([]*service.ChainBreak, 0)
+	result, err := newChainService(store).Verify(anchors, func(brk *service.ChainBreak) {
+		brks = append(brks, brk)
+	})
+	require.Nil(t, err)
+	return result, brks
+}
+
+func TestChain(t *testing.T) {
+	ConnectDBRedis()
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	defer DisconnectDBRedis()
+
+	// seed writes 3 transactions on the wallet of 101 and 2 on the one of 102
+	seed := func(t *testing.T, store dao.Store) {
+		for _, req := range []*data.DepositReq{{OrderID: "1001", UserID: 101, Amount: 100.10}, {OrderID: "1002", UserID: 102, Amount: 50.00}} {
+			rsp, err := newMemoryWalletService(store, "deposit").Deposit(req)
+			require.Nil(t, err)
+			require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		}
+		rsp, err := newMemoryWalletService(store, "withdraw").Withdraw(&data.WithdrawReq{OrderID: "1003", UserID: 101, Amount: 0.20})
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		rsp, err = newMemoryWalletService(store, "transfer").Transfer(&data.TransferReq{OrderID: "1004", FromUserID: 102, ToUserID: 101, Amount: 20.00})
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+	}
+	txID := func(t *testing.T, dbCli *sql.DB, orderID string, userID int64) int64 {
+		var id int64
+		require.Nil(t, dbCli.QueryRow("SELECT id FROM transactions WHERE order_id = $1 AND user_id = $2", orderID, userID).Scan(&id))
+		return id
+	}
+
+	t.Run("case1: verify success-[chains intact, archived transactions included]", func(t *testing.T) {
+		store := dao.NewMemoryStore()
+		seed(t, store)
+		_, err := newArchiveService([]dao.Store{store}, 30*24*time.Hour).Run(time.Now().AddDate(0, 3, 0))
+		require.Nil(t, err)
+		rsp, err := newMemoryWalletService(store, "deposit").Deposit(&data.DepositReq{OrderID: "1005", UserID: 101, Amount: 1.00})
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+
+		result, brks := runVerify(t, store, nil)
+		assert.Equal(t, &service.ChainResult{Wallets: 2, Transactions: 6}, result)
+		assert.Empty(t, brks)
+	})
+
+	t.Run("case2: verify fail-[altered and deleted rows break their chains]", func(t *testing.T) {
+		store, dbCli := newChainStore(t)
+		seed(t, store)
+		wallet101, err := store.Wallets().GetWalletByUserID(101, data.DefaultPocket)
+		require.Nil(t, err)
+		wallet102, err := store.Wallets().GetWalletByUserID(102, data.DefaultPocket)
+		require.Nil(t, err)
+		result, brks := runVerify(t, store, nil)
+		assert.Equal(t, &service.ChainResult{Wallets: 2, Transactions: 5}, result)
+		assert.Empty(t, brks)
+
+		altered := txID(t, dbCli, "1003", 101)
+		_, err = dbCli.Exec("UPDATE transactions SET amount = 0.02 WHERE id = $1", altered)
+		require.Nil(t, err)
+		// the last transaction of 102 gone
+		_, err = dbCli.Exec("DELETE FROM transactions WHERE id = $1", txID(t, dbCli, "1004", 102))
+		require.Nil(t, err)
+		result, brks = runVerify(t, store, nil)
+		assert.Equal(t, int64(2), result.Broken)
+		require.Len(t, brks, 2)
+		assert.Equal(t, &service.ChainBreak{WalletID: wallet101.ID, TxID: altered, Reason: "hash does not match the contents"}, brks[0])
+		assert.Equal(t, &service.ChainBreak{WalletID: wallet102.ID, Reason: "head is not the hash of the last transaction"}, brks[1])
+
+		// the first transaction of 101 gone, the next one is chained to nothing
+		_, err = dbCli.Exec("UPDATE transactions SET amount = 0.20 WHERE id = $1", altered)
+		require.Nil(t, err)
+		_, err = dbCli.Exec("DELETE FROM transactions WHERE id = $1", txID(t, dbCli, "1001", 101))
+		require.Nil(t, err)
+		_, brks = runVerify(t, store, nil)
+		require.Len(t, brks, 2)
+		assert.Equal(t, &service.ChainBreak{WalletID: wallet101.ID, TxID: altered, Reason: "prev_hash is not the hash of the transaction before"}, brks[0])
+	})
+
+	t.Run("case3: verify success-[transactions recorded before the chains, only ahead of them]", func(t *testing.T) {
+		store, dbCli := newChainStore(t)
+		walletID, err := store.Wallets().CreateOrUpdateWallet(101, data.DefaultPocket, 10.00)
+		require.Nil(t, err)
+		insertUnchained := func(orderID string) {
+			_, err := dbCli.Exec("INSERT INTO transactions (order_id, user_id, wallet_id, tx_type, amount, related_user_id, actor_user_id, created_at, updated_at) "+
+				"VALUES ($1, 101, $2, $3, 5.00, 0, 101, $4, $4)", orderID, walletID, data.TxTypeDeposit, time.Now().Unix())
+			require.Nil(t, err)
+		}
+		insertUnchained("1001")
+		rsp, err := newMemoryWalletService(store, "deposit").Deposit(&data.DepositReq{OrderID: "1002", UserID: 101, Amount: 5.00})
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		result, brks := runVerify(t, store, nil)
+		assert.Equal(t, &service.ChainResult{Wallets: 1, Transactions: 1, Unchained: 1}, result)
+		assert.Empty(t, brks)
+
+		insertUnchained("1003")
+		result, brks = runVerify(t, store, nil)
+		assert.Equal(t, int64(1), result.Broken)
+		require.Len(t, brks, 1)
+		assert.Equal(t, txID(t, dbCli, "1003", 101), brks[0].TxID)
+		assert.Equal(t, "not chained, after chained transactions", brks[0].Reason)
+	})
+
+	t.Run("case4: anchor success-[moved heads appended, a rewritten chain misses its anchored head]", func(t *testing.T) {
+		store, dbCli := newChainStore(t)
+		seed(t, store)
+		anchorFile := filepath.Join(t.TempDir(), "anchors.jsonl")
+		index := service.NewAnchorIndex(anchorFile)
+
+		n, err := newChainService(store).Anchor(index, time.Now())
+		require.Nil(t, err)
+		assert.Equal(t, 2, n)
+		n, err = newChainService(store).Anchor(index, time.Now())
+		require.Nil(t, err)
+		assert.Equal(t, 0, n, "no head moved")
+		rsp, err := newMemoryWalletService(store, "deposit").Deposit(&data.DepositReq{OrderID: "1005", UserID: 101, Amount: 1.00})
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		n, err = newChainService(store).Anchor(index, time.Now())
+		require.Nil(t, err)
+		assert.Equal(t, 1, n)
+		anchors, err := service.LoadAnchors(anchorFile)
+		require.Nil(t, err)
+		require.Len(t, anchors, 2)
+		assert.Equal(t, anchors[0].Hash, anchors[1].Prev)
+		_, brks := runVerify(t, store, anchors)
+		assert.Empty(t, brks)
+
+		// the chain of 101 rewritten from an altered amount on, its head included
+		wallet, err := store.Wallets().GetWalletByUserID(101, data.DefaultPocket)
+		require.Nil(t, err)
+		links, err := store.Chains().GetChainLinkList(wallet.ID, 0, 10)
+		require.Nil(t, err)
+		links[1].Amount = 0.02
+		prevHash := ""
+		for _, link := range links {
+			link.PrevHash = prevHash
+			link.Hash = dao.TxHash(link)
+			_, err = dbCli.Exec("UPDATE transactions SET amount = $1, prev_hash = $2, hash = $3 WHERE id = $4", link.Amount, link.PrevHash, link.Hash, link.ID)
+			require.Nil(t, err)
+			prevHash = link.Hash
+		}
+		_, err = dbCli.Exec("UPDATE wallets SET tx_hash = $1 WHERE id = $2", prevHash, wallet.ID)
+		require.Nil(t, err)
+		_, brks = runVerify(t, store, nil)
+		assert.Empty(t, brks, "consistent without the anchors")
+		_, brks = runVerify(t, store, anchors)
+		require.Len(t, brks, 1)
+		assert.Equal(t, wallet.ID, brks[0].WalletID)
+		assert.Contains(t, brks[0].Reason, "is not on the chain")
+	})
+
+	t.Run("case5: anchor fail-[altered anchor file]", func(t *testing.T) {
+		store := dao.NewMemoryStore()
+		seed(t, store)
+		anchorFile := filepath.Join(t.TempDir(), "anchors.jsonl")
+		_, err := newChainService(store).Anchor(service.NewAnchorIndex(anchorFile), time.Now())
+		require.Nil(t, err)
+		content, err := os.ReadFile(anchorFile)
+		require.Nil(t, err)
+		require.Nil(t, os.WriteFile(anchorFile, []byte(strings.Replace(string(content), `"wallet_id":1`, `"wallet_id":3`, 1)), 0644))
+
+		_, err = service.LoadAnchors(anchorFile)
+		assert.NotNil(t, err)
+		_, err = newChainService(store).Anchor(service.NewAnchorIndex(anchorFile), time.Now())
+		assert.NotNil(t, err, "nothing appended to an altered file")
+	})
+
+	t.Run("case6: anchor success-[lines of another instance tailed, the lines read before are left to verify]", func(t *testing.T) {
+		store := dao.NewMemoryStore()
+		seed(t, store)
+		anchorFile := filepath.Join(t.TempDir(), "anchors.jsonl")
+		index := service.NewAnchorIndex(anchorFile)
+		n, err := newChainService(store).Anchor(index, time.Now())
+		require.Nil(t, err)
+		assert.Equal(t, 2, n)
+
+		// another instance anchors a moved head
+		rsp, err := newMemoryWalletService(store, "deposit").Deposit(&data.DepositReq{OrderID: "1005", UserID: 101, Amount: 1.00})
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		n, err = newChainService(store).Anchor(service.NewAnchorIndex(anchorFile), time.Now())
+		require.Nil(t, err)
+		assert.Equal(t, 1, n)
+		n, err = newChainService(store).Anchor(index, time.Now())
+		require.Nil(t, err)
+		assert.Equal(t, 0, n, "the head anchored by the other instance is not anchored again")
+
+		rsp, err = newMemoryWalletService(store, "deposit").Deposit(&data.DepositReq{OrderID: "1006", UserID: 101, Amount: 1.00})
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		content, err := os.ReadFile(anchorFile)
+		require.Nil(t, err)
+		require.Nil(t, os.WriteFile(anchorFile, []byte(strings.Replace(string(content), `"wallet_id":1`, `"wallet_id":3`, 1)), 0644))
+		n, err = newChainService(store).Anchor(index, time.Now())
+		require.Nil(t, err, "the lines read before are not read again")
+		assert.Equal(t, 1, n)
+		anchors, err := service.LoadAnchors(anchorFile)
+		assert.NotNil(t, err, "verify reads the whole file")
+		assert.Nil(t, anchors)
+
+		require.Nil(t, os.WriteFile(anchorFile, content[:len(content)/2], 0644))
+		_, err = newChainService(store).Anchor(index, time.Now())
+		assert.NotNil(t, err, "nothing appended to a truncated file")
+	})
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"simplewallet/model"
+	"simplewallet/service/dao"
+)
+
+var errBadCursor = errors.New("bad cursor")
+
+// encodeTxCursor returns the opaque cursor of the history page after the key, or before it when backward
+func encodeTxCursor(key dao.TxKey, backward bool) string {
+	direction := "n"
+	if backward {
+		direction = "p"
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("v1:%s:%d:%d", direction, key.CreatedAt, key.ID)))
+}
+
+// decodeTxCursor returns the key and direction of a cursor, a nil key for the empty cursor
+func decodeTxCursor(cursor string) (*dao.TxKey, bool, error) {
+	if cursor == "" {
+		return nil, false, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, false, errBadCursor
+	}
+	var direction string
+	key := &dao.TxKey{}
+	n, err := fmt.Sscanf(string(raw), "v1:%1s:%d:%d", &direction, &key.CreatedAt, &key.ID)
+	if err != nil || n != 3 || (direction != "n" && direction != "p") || encodeTxCursor(*key, direction == "p") != cursor {
+		return nil, false, errBadCursor
+	}
+	return key, direction == "p", nil
+}
+
+func txKeyOf(tx *model.Transactions) dao.TxKey {
+	return dao.TxKey{CreatedAt: tx.CreatedAt, ID: tx.ID}
+}
+
+// txPageCursors returns the cursors around a page read from the key, more tells if the page was cut at the limit
+func txPageCursors(txList []*model.Transactions, key *dao.TxKey, backward bool, more bool) (prev string, next string) {
+	// an empty page after a key still leads back to it
+	first, last := key, key
+	if len(txList) > 0 {
+		firstKey, lastKey := txKeyOf(txList[0]), txKeyOf(txList[len(txList)-1])
+		first, last = &firstKey, &lastKey
+	}
+	// the side read towards goes on if the page was cut, the side of the key always does
+	hasPrev, hasNext := key != nil, more
+	if backward {
+		hasPrev, hasNext = more, key != nil
+	}
+	if hasPrev && first != nil {
+		prev = encodeTxCursor(*first, true)
+	}
+	if hasNext && last != nil {
+		next = encodeTxCursor(*last, false)
+	}
+	return prev, next
+}
+
+// encodeAuditCursor returns the opaque cursor of the audit page after the record of the shard, which has the key
+func encodeAuditCursor(key dao.AuditKey, shard int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("v1:a:%d:%d:%d", key.CreatedAt, shard, key.ID)))
+}
+
+// decodeAuditCursor returns the key and shard of a cursor, a nil key for the empty cursor
+func decodeAuditCursor(cursor string) (*dao.AuditKey, int, error) {
+	if cursor == "" {
+		return nil, 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, 0, errBadCursor
+	}
+	var shard int
+	key := &dao.AuditKey{}
+	n, err := fmt.Sscanf(string(raw), "v1:a:%d:%d:%d", &key.CreatedAt, &shard, &key.ID)
+	if err != nil || n != 3 || shard < 0 || encodeAuditCursor(*key, shard) != cursor {
+		return nil, 0, errBadCursor
+	}
+	return key, shard, nil
+}
@@ -0,0 +1,79 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"simplewallet/data"
+	"simplewallet/model"
+	"time"
+)
+
+type AdjustmentDao struct {
+	dbConn
+}
+
+func NewAdjustmentDao(ctx context.Context, logID string, db DBTX) *AdjustmentDao {
+	return &AdjustmentDao{dbConn: dbConn{ctx: ctx, logID: logID, db: db}}
+}
+
+const adjustmentColumns = "id,order_id,user_id,org_id,wallet_id,tx_type,amount,reason_code,reason,status,created_by,reviewed_by,created_at,updated_at"
+
+// maxAdjustmentReasonLen is the size of balance_adjustments.reason
+const maxAdjustmentReasonLen = 255
+
+func scanAdjustment(row rowScanner, adjustment *model.BalanceAdjustment) error {
+	return row.Scan(&adjustment.ID, &adjustment.OrderID, &adjustment.UserID, &adjustment.OrgID, &adjustment.WalletID, &adjustment.TxType,
+		&adjustment.Amount, &adjustment.ReasonCode, &adjustment.Reason, &adjustment.Status, &adjustment.CreatedBy, &adjustment.ReviewedBy,
+		&adjustment.CreatedAt, &adjustment.UpdatedAt)
+}
+
+// record a pending adjustment
+func (d *AdjustmentDao) InsertAdjustment(adjustment *model.BalanceAdjustment) (int64, error) {
+	tn := time.Now().Unix()
+	reason := adjustment.Reason
+	if len(reason) > maxAdjustmentReasonLen {
+		reason = reason[:maxAdjustmentReasonLen]
+	}
+	var adjustmentID int64
+	err := d.execRow("INSERT INTO balance_adjustments (order_id, user_id, org_id, wallet_id, tx_type, amount, reason_code, reason, status, created_by, created_at, updated_at) "+
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id",
+		adjustment.OrderID, adjustment.UserID, adjustment.OrgID, adjustment.WalletID, adjustment.TxType, adjustment.Amount,
+		adjustment.ReasonCode, reason, data.AdjustmentStatusPending, adjustment.CreatedBy, tn, tn).Scan(&adjustmentID)
+	if err != nil {
+		log.Printf("%s|[%s] Failed to insert balance adjustment: %v", d.logID, adjustment.OrderID, err)
+		return 0, err
+	}
+	return adjustmentID, nil
+}
+
+func (d *AdjustmentDao) GetAdjustmentByOrderID(orderID string) (*model.BalanceAdjustment, error) {
+	adjustment := &model.BalanceAdjustment{}
+	err := scanAdjustment(d.queryRow("SELECT "+adjustmentColumns+" FROM balance_adjustments WHERE order_id = $1", orderID), adjustment)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		log.Printf("%s|[%s] Failed to get balance adjustment by order id: %v", d.logID, orderID, err)
+		return nil, err
+	}
+	return adjustment, nil
+}
+
+// move an adjustment out of the given status on behalf of the reviewer, returns false if it was not in
+// that status any more. The creator never reviews their own adjustment, the table rejects it.
+func (d *AdjustmentDao) ReviewAdjustment(adjustmentID int64, from int32, to int32, reviewedBy string) (bool, error) {
+	tn := time.Now().Unix()
+	result, err := d.exec("UPDATE balance_adjustments SET status = $1, reviewed_by = $2, updated_at = $3 WHERE id = $4 AND status = $5",
+		to, reviewedBy, tn, adjustmentID, from)
+	if err != nil {
+		log.Printf("%s|[%d] Failed to review balance adjustment: %v", d.logID, adjustmentID, err)
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
@@ -0,0 +1,137 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"simplewallet/data"
+	"simplewallet/model"
+	"time"
+)
+
+type ApprovalDao struct {
+	dbConn
+}
+
+func NewApprovalDao(ctx context.Context, logID string, db DBTX) *ApprovalDao {
+	return &ApprovalDao{dbConn: dbConn{ctx: ctx, logID: logID, db: db}}
+}
+
+const approvalColumns = "id,order_id,org_id,wallet_id,requester_user_id,to_user_id,amount,required_approvals,status,expire_at,created_at,updated_at"
+
+func scanApproval(row rowScanner, approval *model.TransferApproval) error {
+	return row.Scan(&approval.ID, &approval.OrderID, &approval.OrgID, &approval.WalletID, &approval.RequesterUserID, &approval.ToUserID,
+		&approval.Amount, &approval.RequiredApprovals, &approval.Status, &approval.ExpireAt, &approval.CreatedAt, &approval.UpdatedAt)
+}
+
+func (d *ApprovalDao) InsertApproval(approval *model.TransferApproval) (int64, error) {
+	tn := time.Now().Unix()
+	var approvalID int64
+	err := d.execRow("INSERT INTO transfer_approvals (order_id, org_id, wallet_id, requester_user_id, to_user_id, amount, required_approvals, status, expire_at, created_at, updated_at) "+
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id",
+		approval.OrderID, approval.OrgID, approval.WalletID, approval.RequesterUserID, approval.ToUserID, approval.Amount,
+		approval.RequiredApprovals, data.ApprovalStatusPending, approval.ExpireAt, tn, tn).Scan(&approvalID)
+	if err != nil {
+		log.Printf("%s|[%s] Failed to insert transfer approval: %v", d.logID, approval.OrderID, err)
+		return 0, err
+	}
+	return approvalID, nil
+}
+
+func (d *ApprovalDao) GetApprovalByOrderID(orderID string) (*model.TransferApproval, error) {
+	approval := &model.TransferApproval{}
+	err := scanApproval(d.queryRow("SELECT "+approvalColumns+" FROM transfer_approvals WHERE order_id = $1", orderID), approval)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		log.Printf("%s|[%s] Failed to get transfer approval by order id: %v", d.logID, orderID, err)
+		return nil, err
+	}
+	return approval, nil
+}
+
+// list pending approvals of an organization, oldest first
+func (d *ApprovalDao) GetPendingApprovalListByOrgID(orgID int64, limit int32) ([]*model.TransferApproval, error) {
+	rows, err := d.query("SELECT "+approvalColumns+" FROM transfer_approvals WHERE org_id = $1 AND status = $2 ORDER BY id LIMIT $3", orgID, data.ApprovalStatusPending, limit)
+	if err != nil {
+		log.Printf("%s|[%d] Failed to get pending approval list: %v", d.logID, orgID, err)
+		return nil, err
+	}
+	return d.scanApprovalList(rows)
+}
+
+// list pending approvals expired at the given time
+func (d *ApprovalDao) GetDueApprovalList(now int64, limit int32) ([]*model.TransferApproval, error) {
+	rows, err := d.query("SELECT "+approvalColumns+" FROM transfer_approvals WHERE status = $1 AND expire_at <= $2 ORDER BY id LIMIT $3", data.ApprovalStatusPending, now, limit)
+	if err != nil {
+		log.Printf("%s|[%d] Failed to get due approval list: %v", d.logID, now, err)
+		return nil, err
+	}
+	return d.scanApprovalList(rows)
+}
+
+func (d *ApprovalDao) scanApprovalList(rows *timedRows) ([]*model.TransferApproval, error) {
+	defer rows.Close()
+	approvalList := make([]*model.TransferApproval, 0)
+	for rows.Next() {
+		approval := &model.TransferApproval{}
+		if err := scanApproval(rows, approval); err != nil {
+			log.Printf("%s|Failed to scan transfer approval: %v", d.logID, err)
+			return nil, err
+		}
+		approvalList = append(approvalList, approval)
+	}
+	return approvalList, rows.Err()
+}
+
+// move an approval out of the given status, returns false if it was not in that status any more
+func (d *ApprovalDao) UpdateApprovalStatus(approvalID int64, from int32, to int32) (bool, error) {
+	tn := time.Now().Unix()
+	result, err := d.exec("UPDATE transfer_approvals SET status = $1, updated_at = $2 WHERE id = $3 AND status = $4", to, tn, approvalID, from)
+	if err != nil {
+		log.Printf("%s|[%d] Failed to update approval status: %v", d.logID, approvalID, err)
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// record an approval vote, returns false if the approver has voted already
+func (d *ApprovalDao) InsertVote(approvalID int64, approverUserID int64) (bool, error) {
+	tn := time.Now().Unix()
+	result, err := d.exec("INSERT INTO approval_votes (approval_id, approver_user_id, created_at) VALUES ($1, $2, $3) ON CONFLICT (approval_id, approver_user_id) DO NOTHING",
+		approvalID, approverUserID, tn)
+	if err != nil {
+		log.Printf("%s|[%d] Failed to insert approval vote: %v", d.logID, approvalID, err)
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+func (d *ApprovalDao) GetVoteList(approvalID int64) ([]*model.ApprovalVote, error) {
+	rows, err := d.query("SELECT id,approval_id,approver_user_id,created_at FROM approval_votes WHERE approval_id = $1 ORDER BY id", approvalID)
+	if err != nil {
+		log.Printf("%s|[%d] Failed to get approval votes: %v", d.logID, approvalID, err)
+		return nil, err
+	}
+	defer rows.Close()
+	voteList := make([]*model.ApprovalVote, 0)
+	for rows.Next() {
+		vote := &model.ApprovalVote{}
+		if err = rows.Scan(&vote.ID, &vote.ApprovalID, &vote.ApproverUserID, &vote.CreatedAt); err != nil {
+			log.Printf("%s|[%d] Failed to scan approval vote: %v", d.logID, approvalID, err)
+			return nil, err
+		}
+		voteList = append(voteList, vote)
+	}
+	return voteList, rows.Err()
+}
@@ -0,0 +1,162 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// ArchiveDao moves old transactions to transactions_archive. On postgres transactions is partitioned
+// by the utc month of created_at, a partition is named transactions_pYYYYMM.
+type ArchiveDao struct {
+	dbConn
+	dialect Dialect
+}
+
+func NewArchiveDao(ctx context.Context, logID string, db DBTX, dialect Dialect) *ArchiveDao {
+	return &ArchiveDao{dbConn: dbConn{ctx: ctx, logID: logID, db: db}, dialect: dialect}
+}
+
+const (
+	partitionPrefix = "transactions_p"
+	partitionLayout = "200601"
+	archivedTxTable = "transactions"
+)
+
+// monthOf returns the start of the utc month of the unix time
+func monthOf(unix int64) time.Time {
+	t := time.Unix(unix, 0).UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// get the time the transactions are archived before, 0 when none is
+func (d *ArchiveDao) GetArchivedBefore() (int64, error) {
+	var before int64
+	err := d.queryRow("SELECT archived_before FROM archive_watermarks WHERE table_name = $1", archivedTxTable).Scan(&before)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		log.Printf("%s|Failed to get archive watermark: %v", d.logID, err)
+		return 0, err
+	}
+	return before, nil
+}
+
+// partitionList returns the partitions of transactions by name, the default one included
+func (d *ArchiveDao) partitionList() (map[string]bool, error) {
+	rows, err := d.query("SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid WHERE i.inhparent = 'transactions'::regclass")
+	if err != nil {
+		log.Printf("%s|Failed to list partitions: %v", d.logID, err)
+		return nil, err
+	}
+	defer rows.Close()
+	partitions := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		partitions[name] = true
+	}
+	return partitions, rows.Err()
+}
+
+// create the missing monthly partitions from the month of from until to, returns their names.
+// Rows of a new partition already in transactions_default move into it. Nothing to do on sqlite.
+func (d *ArchiveDao) CreatePartitions(from int64, to int64) ([]string, error) {
+	if d.dialect != DialectPostgres {
+		return nil, nil
+	}
+	partitions, err := d.partitionList()
+	if err != nil {
+		return nil, err
+	}
+	created := make([]string, 0)
+	for month := monthOf(from); month.Unix() < to; month = month.AddDate(0, 1, 0) {
+		name := partitionPrefix + month.Format(partitionLayout)
+		if partitions[name] {
+			continue
+		}
+		start, end := month.Unix(), month.AddDate(0, 1, 0).Unix()
+		_, err = d.exec(fmt.Sprintf("CREATE TABLE %s (LIKE transactions INCLUDING DEFAULTS)", name))
+		if err == nil {
+			_, err = d.exec(fmt.Sprintf("WITH moved AS (DELETE FROM transactions_default WHERE created_at >= $1 AND created_at < $2 RETURNING *) "+
+				"INSERT INTO %s SELECT * FROM moved", name), start, end)
+		}
+		if err == nil {
+			_, err = d.exec(fmt.Sprintf("ALTER TABLE transactions ATTACH PARTITION %s FOR VALUES FROM (%d) TO (%d)", name, start, end))
+		}
+		if err != nil {
+			log.Printf("%s|[%s] Failed to create partition: %v", d.logID, name, err)
+			return nil, err
+		}
+		created = append(created, name)
+	}
+	return created, nil
+}
+
+// move the transactions created before the given time to transactions_archive and raise the watermark
+// to it, returns how many moved. The partitions ending by then move whole and are dropped.
+// Run it in a unit of work, a failure leaves every row where it was.
+func (d *ArchiveDao) ArchiveTransactions(before int64) (int64, error) {
+	var moved int64
+	if d.dialect == DialectPostgres {
+		partitions, err := d.partitionList()
+		if err != nil {
+			return 0, err
+		}
+		for name := range partitions {
+			if !strings.HasPrefix(name, partitionPrefix) {
+				continue
+			}
+			month, err := time.Parse(partitionLayout, strings.TrimPrefix(name, partitionPrefix))
+			if err != nil || month.AddDate(0, 1, 0).Unix() > before {
+				continue
+			}
+			n, err := d.moveRows("INSERT INTO transactions_archive ("+chainColumns+") SELECT "+chainColumns+" FROM "+name, "DROP TABLE "+name)
+			if err != nil {
+				log.Printf("%s|[%s] Failed to archive partition: %v", d.logID, name, err)
+				return 0, err
+			}
+			moved += n
+		}
+	}
+	// rows out of the whole partitions, all of them on sqlite
+	n, err := d.moveRows("INSERT INTO transactions_archive ("+chainColumns+") SELECT "+chainColumns+" FROM transactions WHERE created_at < $1",
+		"DELETE FROM transactions WHERE created_at < $1", before)
+	if err != nil {
+		log.Printf("%s|[%d] Failed to archive transactions: %v", d.logID, before, err)
+		return 0, err
+	}
+	moved += n
+	tn := time.Now().Unix()
+	_, err = d.exec("INSERT INTO archive_watermarks (table_name, archived_before, updated_at) VALUES ($1, $2, $3) "+
+		"ON CONFLICT (table_name) DO UPDATE SET archived_before = excluded.archived_before, updated_at = excluded.updated_at "+
+		"WHERE archive_watermarks.archived_before < excluded.archived_before", archivedTxTable, before, tn)
+	if err != nil {
+		log.Printf("%s|[%d] Failed to update archive watermark: %v", d.logID, before, err)
+		return 0, err
+	}
+	return moved, nil
+}
+
+// moveRows copies rows to the archive then removes them, returns how many were copied
+func (d *ArchiveDao) moveRows(copyQuery string, removeQuery string, args ...any) (int64, error) {
+	result, err := d.exec(copyQuery, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if _, err = d.exec(removeQuery, args...); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,94 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"simplewallet/model"
+	"strings"
+	"time"
+)
+
+type AuditDao struct {
+	dbConn
+}
+
+func NewAuditDao(ctx context.Context, logID string, db DBTX) *AuditDao {
+	return &AuditDao{dbConn: dbConn{ctx: ctx, logID: logID, db: db}}
+}
+
+const auditLogColumns = "id,actor,action,target_type,target_id,before_state,after_state,reason,log_id,source_ip,created_at"
+
+// maxAuditReasonLen is the size of admin_audit_logs.reason
+const maxAuditReasonLen = 255
+
+func scanAuditLog(row rowScanner, entry *model.AdminAuditLog) error {
+	return row.Scan(&entry.ID, &entry.Actor, &entry.Action, &entry.TargetType, &entry.TargetID, &entry.BeforeState, &entry.AfterState,
+		&entry.Reason, &entry.LogID, &entry.SourceIP, &entry.CreatedAt)
+}
+
+// record an action, in the unit of work of the action so there is no record of one rolled back
+func (d *AuditDao) InsertAuditLog(entry *model.AdminAuditLog) (int64, error) {
+	tn := time.Now().Unix()
+	reason := entry.Reason
+	if len(reason) > maxAuditReasonLen {
+		reason = reason[:maxAuditReasonLen]
+	}
+	var entryID int64
+	err := d.execRow("INSERT INTO admin_audit_logs (actor, action, target_type, target_id, before_state, after_state, reason, log_id, source_ip, created_at) "+
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id",
+		entry.Actor, entry.Action, entry.TargetType, entry.TargetID, entry.BeforeState, entry.AfterState, reason, entry.LogID, entry.SourceIP, tn).Scan(&entryID)
+	if err != nil {
+		log.Printf("%s|[%d] Failed to insert audit log of %s: %v", d.logID, entry.TargetID, entry.Action, err)
+		return 0, err
+	}
+	return entryID, nil
+}
+
+// list the records matching the filter before the key, newest first
+func (d *AuditDao) GetAuditLogList(filter *AuditFilter, before *AuditKey, limit int32) ([]*model.AdminAuditLog, error) {
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	conds := []string{"1 = 1"}
+	if filter.Actor != "" {
+		conds = append(conds, "actor = "+arg(filter.Actor))
+	}
+	if filter.Action != "" {
+		conds = append(conds, "action = "+arg(filter.Action))
+	}
+	if filter.TargetType != "" {
+		conds = append(conds, "target_type = "+arg(filter.TargetType))
+	}
+	if filter.TargetID > 0 {
+		conds = append(conds, "target_id = "+arg(filter.TargetID))
+	}
+	if filter.StartTime > 0 {
+		conds = append(conds, "created_at >= "+arg(filter.StartTime))
+	}
+	if filter.EndTime > 0 {
+		conds = append(conds, "created_at < "+arg(filter.EndTime))
+	}
+	if before != nil {
+		conds = append(conds, "(created_at, id) < ("+arg(before.CreatedAt)+", "+arg(before.ID)+")")
+	}
+	rows, err := d.query("SELECT "+auditLogColumns+" FROM admin_audit_logs WHERE "+strings.Join(conds, " AND ")+
+		" ORDER BY created_at DESC, id DESC LIMIT "+arg(limit), args...)
+	if err != nil {
+		log.Printf("%s|Failed to get audit log list: %v", d.logID, err)
+		return nil, err
+	}
+	defer rows.Close()
+	entries := make([]*model.AdminAuditLog, 0)
+	for rows.Next() {
+		entry := &model.AdminAuditLog{}
+		if err = scanAuditLog(rows, entry); err != nil {
+			log.Printf("%s|Failed to scan audit log: %v", d.logID, err)
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,56 @@
+package dao
+
+import (
+	"context"
+	"log"
+	"simplewallet/model"
+)
+
+type ChainDao struct {
+	dbConn
+}
+
+func NewChainDao(ctx context.Context, logID string, db DBTX) *ChainDao {
+	return &ChainDao{dbConn: dbConn{ctx: ctx, logID: logID, db: db}}
+}
+
+// list the heads of the chains of the wallets written to since the given time, in id order after lastID
+func (d *ChainDao) GetChainHeadList(since int64, lastID int64, limit int32) ([]*ChainHead, error) {
+	heads := make([]*ChainHead, 0)
+	rows, err := d.query("SELECT id, COALESCE(tx_hash, '') FROM wallets WHERE updated_at >= $1 AND id > $2 ORDER BY id LIMIT $3", since, lastID, limit)
+	if err != nil {
+		log.Printf("%s|[%d] Failed to get chain head list: %v", d.logID, lastID, err)
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		head := &ChainHead{}
+		if err = rows.Scan(&head.WalletID, &head.Hash); err != nil {
+			log.Printf("%s|[%d] Failed to scan chain head: %v", d.logID, lastID, err)
+			return nil, err
+		}
+		heads = append(heads, head)
+	}
+	return heads, rows.Err()
+}
+
+// list the transactions of a wallet after the given id with their hashes, the archived ones included, in id order
+func (d *ChainDao) GetChainLinkList(walletID int64, afterID int64, limit int32) ([]*model.Transactions, error) {
+	txList := make([]*model.Transactions, 0)
+	rows, err := d.query("SELECT "+chainColumns+" FROM (SELECT "+chainColumns+" FROM transactions WHERE wallet_id = $1 AND id > $2 "+
+		"UNION ALL SELECT "+chainColumns+" FROM transactions_archive WHERE wallet_id = $1 AND id > $2) t ORDER BY id LIMIT $3", walletID, afterID, limit)
+	if err != nil {
+		log.Printf("%s|[%d] Failed to get chain link list: %v", d.logID, walletID, err)
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		tx := &model.Transactions{}
+		if err = scanChainLink(rows, tx); err != nil {
+			log.Printf("%s|[%d] Failed to scan chain link: %v", d.logID, walletID, err)
+			return nil, err
+		}
+		txList = append(txList, tx)
+	}
+	return txList, rows.Err()
+}
@@ -0,0 +1,101 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Timeouts bound the db operations of the daos, zero leaves only the context of the request.
+type Timeouts struct {
+	Query time.Duration // one read
+	Exec  time.Duration // one write
+	Tx    time.Duration // a unit of work, from Begin to Commit
+}
+
+// dbConn is what the daos work on: the db or a transaction, the context of the request
+// and the timeouts of every operation derived from it.
+type dbConn struct {
+	ctx      context.Context
+	logID    string
+	db       DBTX
+	timeouts Timeouts
+}
+
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// ctxErr returns err as the error of the context once it is done. Drivers report a cancelled
+// statement with their own error, e.g. pq: canceling statement due to user request.
+func ctxErr(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() == nil || errors.Is(err, ctx.Err()) || errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ctx.Err(), err)
+}
+
+func (c *dbConn) exec(query string, args ...any) (sql.Result, error) {
+	ctx, cancel := withTimeout(c.ctx, c.timeouts.Exec)
+	defer cancel()
+	result, err := c.db.ExecContext(ctx, query, args...)
+	return result, ctxErr(ctx, err)
+}
+
+func (c *dbConn) query(query string, args ...any) (*timedRows, error) {
+	ctx, cancel := withTimeout(c.ctx, c.timeouts.Query)
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		err = ctxErr(ctx, err)
+		cancel()
+		return nil, err
+	}
+	return &timedRows{Rows: rows, ctx: ctx, cancel: cancel}, nil
+}
+
+func (c *dbConn) queryRow(query string, args ...any) *timedRow {
+	ctx, cancel := withTimeout(c.ctx, c.timeouts.Query)
+	return &timedRow{row: c.db.QueryRowContext(ctx, query, args...), ctx: ctx, cancel: cancel}
+}
+
+// execRow runs a write returning a row, INSERT ... RETURNING
+func (c *dbConn) execRow(query string, args ...any) *timedRow {
+	ctx, cancel := withTimeout(c.ctx, c.timeouts.Exec)
+	return &timedRow{row: c.db.QueryRowContext(ctx, query, args...), ctx: ctx, cancel: cancel}
+}
+
+// timedRow is a row whose timeout ends once it is scanned
+type timedRow struct {
+	row    *sql.Row
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (r *timedRow) Scan(dest ...any) error {
+	defer r.cancel()
+	return ctxErr(r.ctx, r.row.Scan(dest...))
+}
+
+// timedRows are rows whose timeout ends once they are closed
+type timedRows struct {
+	*sql.Rows
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (r *timedRows) Close() error {
+	defer r.cancel()
+	return r.Rows.Close()
+}
+
+func (r *timedRows) Err() error {
+	return ctxErr(r.ctx, r.Rows.Err())
+}
@@ -0,0 +1,858 @@
+// Package daotest holds the conformance suite every dao.Store implementation has to pass.
+package daotest
+
+import (
+	"fmt"
+	"simplewallet/data"
+	"simplewallet/model"
+	"simplewallet/service/dao"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// RunConformance runs the suite, newStore must return an empty store for every case.
+func RunConformance(t *testing.T, newStore func(t *testing.T) dao.Store) {
+	t.Run("case1: wallets success-[create, credit and debit pockets]", func(t *testing.T) {
+		wallets := newStore(t).Wallets()
+		wallet, err := wallets.GetWalletByUserID(101, data.DefaultPocket)
+		require.NoError(t, err)
+		assert.Nil(t, wallet)
+
+		mainID, err := wallets.CreateOrUpdateWallet(101, data.DefaultPocket, 100)
+		require.NoError(t, err)
+		savingsID, err := wallets.CreateOrUpdateWallet(101, "savings", 50)
+		require.NoError(t, err)
+		sameID, err := wallets.CreateOrUpdateWallet(101, data.DefaultPocket, 20.5)
+		require.NoError(t, err)
+		assert.Equal(t, mainID, sameID)
+		require.NoError(t, wallets.UpdateWalletBalance(mainID, data.TxTypeWithdraw, 0.5))
+		require.NoError(t, wallets.UpdateWalletBalance(savingsID, data.TxTypeDeposit, 10))
+
+		wallet, err = wallets.GetWalletByUserID(101, data.DefaultPocket)
+		require.NoError(t, err)
+		require.NotNil(t, wallet)
+		assert.Equal(t, mainID, wallet.ID)
+		assert.Equal(t, int64(101), wallet.UserID)
+		assert.Equal(t, 120.0, wallet.Balance)
+		wallet, err = wallets.GetWalletByID(savingsID)
+		require.NoError(t, err)
+		require.NotNil(t, wallet)
+		assert.Equal(t, "savings", wallet.Name)
+		assert.Equal(t, 60.0, wallet.Balance)
+
+		walletList, err := wallets.GetWalletListByUserID(101)
+		require.NoError(t, err)
+		require.Len(t, walletList, 2)
+		assert.Equal(t, mainID, walletList[0].ID)
+		assert.Equal(t, savingsID, walletList[1].ID)
+		walletList, err = wallets.GetWalletListByUserID(102)
+		require.NoError(t, err)
+		assert.Empty(t, walletList)
+	})
+
+	t.Run("case2: wallets fail-[balance below the credit limit]", func(t *testing.T) {
+		wallets := newStore(t).Wallets()
+		walletID, err := wallets.CreateOrUpdateWallet(101, data.DefaultPocket, 10)
+		require.NoError(t, err)
+		assert.Error(t, wallets.UpdateWalletBalance(walletID, data.TxTypeWithdraw, 10.5))
+		wallet, err := wallets.GetWalletByID(walletID)
+		require.NoError(t, err)
+		assert.Equal(t, 10.0, wallet.Balance)
+	})
+
+	t.Run("case3: wallets success-[hold and release]", func(t *testing.T) {
+		wallets := newStore(t).Wallets()
+		walletID, err := wallets.CreateOrUpdateWallet(101, data.DefaultPocket, 100)
+		require.NoError(t, err)
+		require.NoError(t, wallets.HoldBalance(walletID, 80))
+		assert.Error(t, wallets.HoldBalance(walletID, 30), "held above the balance")
+		assert.Error(t, wallets.UpdateWalletBalance(walletID, data.TxTypeWithdraw, 30), "debit of held funds")
+		require.NoError(t, wallets.ReleaseHold(walletID, 50))
+		assert.Error(t, wallets.ReleaseHold(walletID, 40), "negative held")
+		wallet, err := wallets.GetWalletByID(walletID)
+		require.NoError(t, err)
+		assert.Equal(t, 100.0, wallet.Balance)
+		assert.Equal(t, 30.0, wallet.Held)
+	})
+
+	t.Run("case4: wallets success-[shared pockets of an org]", func(t *testing.T) {
+		wallets := newStore(t).Wallets()
+		_, err := wallets.CreateOrUpdateWallet(101, data.DefaultPocket, 10)
+		require.NoError(t, err)
+		walletID, err := wallets.CreateOrgWallet(1, data.DefaultPocket)
+		require.NoError(t, err)
+		_, err = wallets.CreateOrgWallet(1, data.DefaultPocket)
+		assert.Error(t, err, "duplicate pocket name")
+
+		wallet, err := wallets.GetWalletByOrgID(1, data.DefaultPocket)
+		require.NoError(t, err)
+		require.NotNil(t, wallet)
+		assert.Equal(t, walletID, wallet.ID)
+		assert.Equal(t, int64(0), wallet.UserID)
+		assert.Equal(t, int64(1), wallet.OrgID)
+		assert.Equal(t, 0.0, wallet.Balance)
+		wallet, err = wallets.GetWalletByOrgID(2, data.DefaultPocket)
+		require.NoError(t, err)
+		assert.Nil(t, wallet)
+		walletList, err := wallets.GetWalletListByOrgID(1)
+		require.NoError(t, err)
+		require.Len(t, walletList, 1)
+		assert.Equal(t, walletID, walletList[0].ID)
+	})
+
+	t.Run("case5: wallets success-[list by product in id order]", func(t *testing.T) {
+		wallets := newStore(t).Wallets()
+		var walletIDs []int64
+		for userID := int64(101); userID <= 103; userID++ {
+			walletID, err := wallets.CreateOrUpdateWallet(userID, data.DefaultPocket, 1)
+			require.NoError(t, err)
+			walletIDs = append(walletIDs, walletID)
+		}
+		walletList, err := wallets.GetWalletListByProduct("", 0, 2)
+		require.NoError(t, err)
+		require.Len(t, walletList, 2)
+		assert.Equal(t, walletIDs[0], walletList[0].ID)
+		assert.Equal(t, walletIDs[1], walletList[1].ID)
+		walletList, err = wallets.GetWalletListByProduct("", walletIDs[1], 2)
+		require.NoError(t, err)
+		require.Len(t, walletList, 1)
+		assert.Equal(t, walletIDs[2], walletList[0].ID)
+		walletList, err = wallets.GetWalletListByProduct("gold", 0, 2)
+		require.NoError(t, err)
+		assert.Empty(t, walletList)
+	})
+
+	t.Run("case6: transactions success-[record, list and sum]", func(t *testing.T) {
+		trans := newStore(t).Transactions()
+		tx, err := trans.GetTransactionByOrderID("1001")
+		require.NoError(t, err)
+		assert.Nil(t, tx)
+
+		since := time.Now().Unix()
+		txList := []*model.Transactions{
+			{OrderID: "1001", UserID: 101, WalletID: 1, TxType: data.TxTypeDeposit, Amount: 100, ActorUserID: 101},
+			{OrderID: "1002", UserID: 101, WalletID: 1, TxType: data.TxTypeWithdraw, Amount: 20, ActorUserID: 101},
+			{OrderID: "1003", UserID: 101, WalletID: 1, TxType: data.TxTypeTransferOut, Amount: 30, RelatedUserID: 102, ActorUserID: 101},
+			{OrderID: "1003", UserID: 102, WalletID: 2, TxType: data.TxTypeTransferIn, Amount: 30, RelatedUserID: 101, ActorUserID: 101},
+			{OrderID: "1004", UserID: 101, WalletID: 3, TxType: data.TxTypePocketIn, Amount: 5, RelatedUserID: 101, ActorUserID: 101},
+			{OrderID: "1005", UserID: 0, WalletID: 9, TxType: data.TxTypeWithdraw, Amount: 7, ActorUserID: 102},
+		}
+		for _, tx := range txList {
+			require.NoError(t, trans.InsertTransaction(tx))
+		}
+
+		tx, err = trans.GetTransactionByOrderID("1002")
+		require.NoError(t, err)
+		require.NotNil(t, tx)
+		assert.Equal(t, int64(101), tx.UserID)
+		assert.Equal(t, data.TxTypeWithdraw, tx.TxType)
+		assert.Equal(t, 20.0, tx.Amount)
+		assert.GreaterOrEqual(t, tx.CreatedAt, since)
+
+		page, err := trans.GetTransactionList(&dao.TxFilter{UserID: 101}, 1, 3)
+		require.NoError(t, err)
+		require.Len(t, page, 3)
+		assert.Equal(t, []string{"1001", "1002", "1003"}, orderIDs(page))
+		page, err = trans.GetTransactionList(&dao.TxFilter{UserID: 101}, 2, 3)
+		require.NoError(t, err)
+		assert.Len(t, page, 1)
+		page, err = trans.GetTransactionList(&dao.TxFilter{UserID: 101, WalletID: 3}, 1, 10)
+		require.NoError(t, err)
+		require.Len(t, page, 1)
+		assert.Equal(t, "1004", page[0].OrderID)
+
+		// keyset pages by (created_at, id), forward from the start and backward from a key
+		page, err = trans.GetTransactionListByCursor(&dao.TxFilter{UserID: 101}, nil, false, 2)
+		require.NoError(t, err)
+		require.Equal(t, []string{"1001", "1002"}, orderIDs(page))
+		key := &dao.TxKey{CreatedAt: page[1].CreatedAt, ID: page[1].ID}
+		page, err = trans.GetTransactionListByCursor(&dao.TxFilter{UserID: 101}, key, false, 2)
+		require.NoError(t, err)
+		require.Equal(t, []string{"1003", "1004"}, orderIDs(page))
+		page, err = trans.GetTransactionListByCursor(&dao.TxFilter{UserID: 101}, &dao.TxKey{CreatedAt: page[1].CreatedAt, ID: page[1].ID}, false, 2)
+		require.NoError(t, err)
+		assert.Empty(t, page)
+		page, err = trans.GetTransactionListByCursor(&dao.TxFilter{UserID: 101}, key, true, 5)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"1001"}, orderIDs(page))
+		page, err = trans.GetTransactionListByCursor(&dao.TxFilter{UserID: 101}, nil, true, 2)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"1003", "1004"}, orderIDs(page))
+		page, err = trans.GetTransactionListByCursor(&dao.TxFilter{UserID: 101, WalletID: 1}, nil, false, 10)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"1001", "1002", "1003"}, orderIDs(page))
+
+		// filters, each alone and together, the count ignores paging
+		filters := []struct {
+			filter *dao.TxFilter
+			want   []string
+		}{
+			{&dao.TxFilter{UserID: 101}, []string{"1001", "1002", "1003", "1004"}},
+			{&dao.TxFilter{UserID: 101, TxTypes: []int32{data.TxTypeWithdraw, data.TxTypeTransferOut}}, []string{"1002", "1003"}},
+			{&dao.TxFilter{UserID: 101, StartTime: since, EndTime: time.Now().Unix() + 1}, []string{"1001", "1002", "1003", "1004"}},
+			{&dao.TxFilter{UserID: 101, EndTime: since}, []string{}},
+			{&dao.TxFilter{UserID: 101, MinAmount: 20, MaxAmount: 30}, []string{"1002", "1003"}},
+			{&dao.TxFilter{UserID: 101, RelatedUserID: 102}, []string{"1003"}},
+			{&dao.TxFilter{UserID: 101, OrderIDPrefix: "100"}, []string{"1001", "1002", "1003", "1004"}},
+			{&dao.TxFilter{UserID: 101, OrderIDPrefix: "1003"}, []string{"1003"}},
+			{&dao.TxFilter{UserID: 101, OrderIDPrefix: "10_"}, []string{}},
+			{&dao.TxFilter{UserID: 101, WalletID: 1, TxTypes: []int32{data.TxTypeDeposit, data.TxTypeWithdraw}, MinAmount: 50}, []string{"1001"}},
+		}
+		for _, f := range filters {
+			page, err = trans.GetTransactionListByCursor(f.filter, nil, false, 10)
+			require.NoError(t, err)
+			assert.Equal(t, f.want, orderIDs(page), "%+v", f.filter)
+			page, err = trans.GetTransactionList(f.filter, 1, 10)
+			require.NoError(t, err)
+			assert.Equal(t, f.want, orderIDs(page), "%+v", f.filter)
+			total, err := trans.CountTransactions(f.filter)
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(f.want)), total, "%+v", f.filter)
+		}
+
+		sums, err := trans.GetAmountSumByTxType(1, time.Now().Unix()+1)
+		require.NoError(t, err)
+		assert.Equal(t, map[int32]float64{data.TxTypeDeposit: 100, data.TxTypeWithdraw: 20, data.TxTypeTransferOut: 30}, sums)
+		sums, err = trans.GetAmountSumByTxType(1, since)
+		require.NoError(t, err)
+		assert.Empty(t, sums)
+
+		spent, err := trans.GetDebitSumByActor(1, 101, since)
+		require.NoError(t, err)
+		assert.Equal(t, 50.0, spent)
+		spent, err = trans.GetDebitSumByActor(9, 101, since)
+		require.NoError(t, err)
+		assert.Equal(t, 0.0, spent)
+	})
+
+	t.Run("case7: unit of work success-[commit and rollback]", func(t *testing.T) {
+		store := newStore(t)
+		uow, err := store.Begin()
+		require.NoError(t, err)
+		walletID, err := uow.Wallets().CreateOrUpdateWallet(101, data.DefaultPocket, 100)
+		require.NoError(t, err)
+		require.NoError(t, uow.Transactions().InsertTransaction(&model.Transactions{OrderID: "1001", UserID: 101, WalletID: walletID, TxType: data.TxTypeDeposit, Amount: 100}))
+		wallet, err := uow.Wallets().GetWalletByUserID(101, data.DefaultPocket)
+		require.NoError(t, err)
+		require.NotNil(t, wallet, "a unit of work reads its own writes")
+		require.NoError(t, uow.Commit())
+
+		uow, err = store.Begin()
+		require.NoError(t, err)
+		require.NoError(t, uow.Wallets().UpdateWalletBalance(walletID, data.TxTypeWithdraw, 40))
+		require.NoError(t, uow.Transactions().InsertTransaction(&model.Transactions{OrderID: "1002", UserID: 101, WalletID: walletID, TxType: data.TxTypeWithdraw, Amount: 40}))
+		require.NoError(t, uow.Rollback())
+
+		wallet, err = store.Wallets().GetWalletByID(walletID)
+		require.NoError(t, err)
+		require.NotNil(t, wallet)
+		assert.Equal(t, 100.0, wallet.Balance)
+		tx, err := store.Transactions().GetTransactionByOrderID("1001")
+		require.NoError(t, err)
+		assert.NotNil(t, tx)
+		tx, err = store.Transactions().GetTransactionByOrderID("1002")
+		require.NoError(t, err)
+		assert.Nil(t, tx)
+	})
+
+	t.Run("case8: orgs success-[create org and save members]", func(t *testing.T) {
+		orgs := newStore(t).Orgs()
+		orgID, err := orgs.CreateOrg(&model.Organization{Name: "acme", RequiredApprovals: 2, ApprovalExpireSecond: 3600})
+		require.NoError(t, err)
+		org, err := orgs.GetOrgByID(orgID)
+		require.NoError(t, err)
+		require.NotNil(t, org)
+		assert.Equal(t, "acme", org.Name)
+		assert.Equal(t, int32(2), org.RequiredApprovals)
+		assert.Equal(t, int64(3600), org.ApprovalExpireSecond)
+		org, err = orgs.GetOrgByID(orgID + 1)
+		require.NoError(t, err)
+		assert.Nil(t, org)
+
+		require.NoError(t, orgs.SaveMember(&model.OrgMember{OrgID: orgID, UserID: 102, Role: data.OrgRoleSpender, SpendingLimit: 500}))
+		require.NoError(t, orgs.SaveMember(&model.OrgMember{OrgID: orgID, UserID: 102, Role: data.OrgRoleViewer}))
+		member, err := orgs.GetMember(orgID, 102)
+		require.NoError(t, err)
+		require.NotNil(t, member)
+		assert.Equal(t, data.OrgRoleViewer, member.Role)
+		assert.Equal(t, 0.0, member.SpendingLimit)
+		member, err = orgs.GetMember(orgID, 103)
+		require.NoError(t, err)
+		assert.Nil(t, member)
+	})
+
+	t.Run("case9: approvals success-[votes and status changes]", func(t *testing.T) {
+		approvals := newStore(t).Approvals()
+		now := time.Now().Unix()
+		approvalID, err := approvals.InsertApproval(&model.TransferApproval{OrderID: "2001", OrgID: 1, WalletID: 9, RequesterUserID: 102, ToUserID: 201, Amount: 800, RequiredApprovals: 2, ExpireAt: now + 3600})
+		require.NoError(t, err)
+		_, err = approvals.InsertApproval(&model.TransferApproval{OrderID: "2002", OrgID: 1, WalletID: 9, RequesterUserID: 102, ToUserID: 201, Amount: 10, RequiredApprovals: 2, ExpireAt: now - 1})
+		require.NoError(t, err)
+		_, err = approvals.InsertApproval(&model.TransferApproval{OrderID: "2001", OrgID: 1})
+		assert.Error(t, err, "duplicate order_id")
+
+		approval, err := approvals.GetApprovalByOrderID("2001")
+		require.NoError(t, err)
+		require.NotNil(t, approval)
+		assert.Equal(t, approvalID, approval.ID)
+		assert.Equal(t, data.ApprovalStatusPending, approval.Status)
+		assert.Equal(t, 800.0, approval.Amount)
+		pendingList, err := approvals.GetPendingApprovalListByOrgID(1, 10)
+		require.NoError(t, err)
+		assert.Len(t, pendingList, 2)
+		dueList, err := approvals.GetDueApprovalList(now, 10)
+		require.NoError(t, err)
+		require.Len(t, dueList, 1)
+		assert.Equal(t, "2002", dueList[0].OrderID)
+
+		inserted, err := approvals.InsertVote(approvalID, 101)
+		require.NoError(t, err)
+		assert.True(t, inserted)
+		inserted, err = approvals.InsertVote(approvalID, 101)
+		require.NoError(t, err)
+		assert.False(t, inserted)
+		inserted, err = approvals.InsertVote(approvalID, 103)
+		require.NoError(t, err)
+		assert.True(t, inserted)
+		voteList, err := approvals.GetVoteList(approvalID)
+		require.NoError(t, err)
+		require.Len(t, voteList, 2)
+		assert.Equal(t, int64(101), voteList[0].ApproverUserID)
+		assert.Equal(t, int64(103), voteList[1].ApproverUserID)
+
+		updated, err := approvals.UpdateApprovalStatus(approvalID, data.ApprovalStatusPending, data.ApprovalStatusExecuted)
+		require.NoError(t, err)
+		assert.True(t, updated)
+		updated, err = approvals.UpdateApprovalStatus(approvalID, data.ApprovalStatusPending, data.ApprovalStatusExpired)
+		require.NoError(t, err)
+		assert.False(t, updated)
+		pendingList, err = approvals.GetPendingApprovalListByOrgID(1, 10)
+		require.NoError(t, err)
+		assert.Len(t, pendingList, 1)
+	})
+
+	t.Run("case10: interest success-[accrue and mark paid]", func(t *testing.T) {
+		interest := newStore(t).Interest()
+		inserted, err := interest.InsertAccrual(&model.InterestAccrual{WalletID: 7, UserID: 101, AccrualDate: 20250101, Balance: 1000, Rate: 0.0365, Amount: 0.1})
+		require.NoError(t, err)
+		assert.True(t, inserted)
+		inserted, err = interest.InsertAccrual(&model.InterestAccrual{WalletID: 7, UserID: 101, AccrualDate: 20250101, Balance: 1000, Rate: 0.0365, Amount: 0.1})
+		require.NoError(t, err)
+		assert.False(t, inserted)
+		_, err = interest.InsertAccrual(&model.InterestAccrual{WalletID: 7, UserID: 101, AccrualDate: 20250102, Balance: 1000, Rate: 0.0365, Amount: 0.1})
+		require.NoError(t, err)
+		_, err = interest.InsertAccrual(&model.InterestAccrual{WalletID: 8, UserID: 102, AccrualDate: 20250101, Rate: 0.0365, Amount: 0})
+		require.NoError(t, err)
+
+		walletIDs, err := interest.GetUnpaidWalletIDs(20250101, 20250131)
+		require.NoError(t, err)
+		assert.Equal(t, []int64{7}, walletIDs)
+		userID, amount, err := interest.GetUnpaidAmount(7, 20250101, 20250131)
+		require.NoError(t, err)
+		assert.Equal(t, int64(101), userID)
+		assert.Equal(t, 0.2, amount)
+
+		require.NoError(t, interest.MarkAccrualsPaid(7, 20250101, 20250131, "interest:7:202501"))
+		walletIDs, err = interest.GetUnpaidWalletIDs(20250101, 20250131)
+		require.NoError(t, err)
+		assert.Empty(t, walletIDs)
+		_, amount, err = interest.GetUnpaidAmount(7, 20250101, 20250131)
+		require.NoError(t, err)
+		assert.Equal(t, 0.0, amount)
+	})
+	t.Run("case11: shard transfers success-[debit, list due, mark credited, count attempts and mark refunded]", func(t *testing.T) {
+		shardTransfers := newStore(t).ShardTransfers()
+		now := time.Now().Unix()
+		transferID, err := shardTransfers.InsertShardTransfer(&model.ShardTransfer{OrderID: "3001", FromUserID: 101, FromWalletID: 7, ToUserID: 202, Amount: 50})
+		require.NoError(t, err)
+		assert.True(t, transferID > 0)
+		_, err = shardTransfers.InsertShardTransfer(&model.ShardTransfer{OrderID: "3002", FromUserID: 101, FromWalletID: 7, ToUserID: 203, Amount: 20})
+		require.NoError(t, err)
+		_, err = shardTransfers.InsertShardTransfer(&model.ShardTransfer{OrderID: "3001", FromUserID: 101})
+		assert.Error(t, err, "duplicate order_id")
+
+		transfer, err := shardTransfers.GetShardTransferByOrderID("3001")
+		require.NoError(t, err)
+		require.NotNil(t, transfer)
+		assert.Equal(t, transferID, transfer.ID)
+		assert.Equal(t, int64(202), transfer.ToUserID)
+		assert.Equal(t, 50.0, transfer.Amount)
+		assert.Equal(t, data.ShardTransferStatusDebited, transfer.Status)
+		transfer, err = shardTransfers.GetShardTransferByOrderID("3999")
+		require.NoError(t, err)
+		assert.Nil(t, transfer)
+
+		dueList, err := shardTransfers.GetDebitedShardTransferList(now-60, 10)
+		require.NoError(t, err)
+		assert.Empty(t, dueList)
+		dueList, err = shardTransfers.GetDebitedShardTransferList(now+60, 10)
+		require.NoError(t, err)
+		require.Len(t, dueList, 2)
+		assert.Equal(t, "3001", dueList[0].OrderID)
+
+		marked, err := shardTransfers.MarkShardTransferCredited("3001")
+		require.NoError(t, err)
+		assert.True(t, marked)
+		marked, err = shardTransfers.MarkShardTransferCredited("3001")
+		require.NoError(t, err)
+		assert.False(t, marked)
+		dueList, err = shardTransfers.GetDebitedShardTransferList(now+60, 10)
+		require.NoError(t, err)
+		require.Len(t, dueList, 1)
+		assert.Equal(t, "3002", dueList[0].OrderID)
+
+		attempts, err := shardTransfers.AddShardTransferAttempt("3002")
+		require.NoError(t, err)
+		assert.Equal(t, int32(1), attempts)
+		attempts, err = shardTransfers.AddShardTransferAttempt("3002")
+		require.NoError(t, err)
+		assert.Equal(t, int32(2), attempts)
+		attempts, err = shardTransfers.AddShardTransferAttempt("3001")
+		require.NoError(t, err)
+		assert.Equal(t, int32(0), attempts, "credited already")
+		marked, err = shardTransfers.MarkShardTransferRefunded("3001")
+		require.NoError(t, err)
+		assert.False(t, marked, "credited already")
+		marked, err = shardTransfers.MarkShardTransferRefunded("3002")
+		require.NoError(t, err)
+		assert.True(t, marked)
+		transfer, err = shardTransfers.GetShardTransferByOrderID("3002")
+		require.NoError(t, err)
+		assert.Equal(t, data.ShardTransferStatusRefunded, transfer.Status)
+		assert.Equal(t, int32(2), transfer.Attempts)
+		marked, err = shardTransfers.MarkShardTransferCredited("3002")
+		require.NoError(t, err)
+		assert.False(t, marked, "refunded already")
+		dueList, err = shardTransfers.GetDebitedShardTransferList(now+60, 10)
+		require.NoError(t, err)
+		assert.Empty(t, dueList)
+	})
+	t.Run("case12: archive success-[move transactions, read them with the live ones]", func(t *testing.T) {
+		store := newStore(t)
+		trans, archive := store.Transactions(), store.Archive()
+		before, err := archive.GetArchivedBefore()
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), before)
+		for _, tx := range []*model.Transactions{
+			{OrderID: "1001", UserID: 101, WalletID: 1, TxType: data.TxTypeDeposit, Amount: 100, ActorUserID: 101},
+			{OrderID: "1002", UserID: 101, WalletID: 1, TxType: data.TxTypeWithdraw, Amount: 20, ActorUserID: 101},
+		} {
+			require.NoError(t, trans.InsertTransaction(tx))
+		}
+
+		archivedBefore := time.Now().Unix() + 1
+		uow, err := store.Begin()
+		require.NoError(t, err)
+		moved, err := uow.Archive().ArchiveTransactions(archivedBefore)
+		require.NoError(t, err)
+		require.NoError(t, uow.Commit())
+		assert.Equal(t, int64(2), moved)
+		before, err = archive.GetArchivedBefore()
+		require.NoError(t, err)
+		assert.Equal(t, archivedBefore, before)
+		// the watermark never goes back
+		moved, err = archive.ArchiveTransactions(archivedBefore - 3600)
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), moved)
+		before, err = archive.GetArchivedBefore()
+		require.NoError(t, err)
+		assert.Equal(t, archivedBefore, before)
+
+		for _, tx := range []*model.Transactions{
+			{OrderID: "1003", UserID: 101, WalletID: 1, TxType: data.TxTypeDeposit, Amount: 50, ActorUserID: 101},
+			{OrderID: "1004", UserID: 101, WalletID: 1, TxType: data.TxTypeWithdraw, Amount: 5, ActorUserID: 101},
+		} {
+			require.NoError(t, trans.InsertTransaction(tx))
+		}
+		tx, err := trans.GetTransactionByOrderID("1001")
+		require.NoError(t, err)
+		require.NotNil(t, tx, "archived order_id found")
+		assert.Equal(t, 100.0, tx.Amount)
+
+		page, err := trans.GetTransactionListByCursor(&dao.TxFilter{UserID: 101}, nil, false, 10)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"1003", "1004"}, orderIDs(page))
+		archived := &dao.TxFilter{UserID: 101, Archived: true}
+		page, err = trans.GetTransactionListByCursor(archived, nil, false, 3)
+		require.NoError(t, err)
+		require.Equal(t, []string{"1001", "1002", "1003"}, orderIDs(page))
+		page, err = trans.GetTransactionListByCursor(archived, &dao.TxKey{CreatedAt: page[2].CreatedAt, ID: page[2].ID}, true, 10)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"1001", "1002"}, orderIDs(page))
+		page, err = trans.GetTransactionList(&dao.TxFilter{UserID: 101, Archived: true, TxTypes: []int32{data.TxTypeWithdraw}}, 1, 10)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"1002", "1004"}, orderIDs(page))
+		total, err := trans.CountTransactions(archived)
+		require.NoError(t, err)
+		assert.Equal(t, int64(4), total)
+		total, err = trans.CountTransactions(&dao.TxFilter{UserID: 101})
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), total)
+
+		sums, err := trans.GetAmountSumByTxType(1, time.Now().Unix()+60)
+		require.NoError(t, err)
+		assert.Equal(t, map[int32]float64{data.TxTypeDeposit: 150, data.TxTypeWithdraw: 25}, sums)
+	})
+	t.Run("case13: outbox success-[events pending in id order until sent, failures counted]", func(t *testing.T) {
+		store := newStore(t)
+		uow, err := store.Begin()
+		require.NoError(t, err)
+		require.NoError(t, uow.Outbox().InsertEvent(&model.OutboxEvent{EventID: "e1", EventType: data.EventTypeDeposit, OrderID: "1001", UserID: 101, Payload: `{"event_id":"e1"}`}))
+		require.NoError(t, uow.Rollback())
+		eventList, err := store.Outbox().GetPendingEventList(10)
+		require.NoError(t, err)
+		assert.Empty(t, eventList, "rolled back with its unit of work")
+
+		outbox := store.Outbox()
+		for _, event := range []*model.OutboxEvent{
+			{EventID: "e1", EventType: data.EventTypeDeposit, OrderID: "1001", UserID: 101, Payload: `{"event_id":"e1"}`},
+			{EventID: "e2", EventType: data.EventTypeWithdraw, OrderID: "1002", UserID: 101, Payload: `{"event_id":"e2"}`},
+			{EventID: "e3", EventType: data.EventTypeTransfer, OrderID: "1003", UserID: 102, Payload: `{"event_id":"e3"}`},
+		} {
+			require.NoError(t, outbox.InsertEvent(event))
+		}
+		assert.Error(t, outbox.InsertEvent(&model.OutboxEvent{EventID: "e1"}), "duplicate event_id")
+
+		eventList, err = outbox.GetPendingEventList(2)
+		require.NoError(t, err)
+		require.Len(t, eventList, 2)
+		assert.Equal(t, "e1", eventList[0].EventID)
+		assert.Equal(t, "e2", eventList[1].EventID)
+		assert.Equal(t, data.EventTypeDeposit, eventList[0].EventType)
+		assert.Equal(t, "1001", eventList[0].OrderID)
+		assert.Equal(t, int64(101), eventList[0].UserID)
+		assert.Equal(t, `{"event_id":"e1"}`, eventList[0].Payload)
+		assert.Equal(t, data.OutboxStatusPending, eventList[0].Status)
+
+		sent, err := outbox.MarkEventSent(eventList[0].ID)
+		require.NoError(t, err)
+		assert.True(t, sent)
+		sent, err = outbox.MarkEventSent(eventList[0].ID)
+		require.NoError(t, err)
+		assert.False(t, sent, "already sent")
+		require.NoError(t, outbox.MarkEventFailed(eventList[1].ID, "connection refused"))
+		require.NoError(t, outbox.MarkEventFailed(eventList[1].ID, strings.Repeat("x", 300)))
+
+		eventList, err = outbox.GetPendingEventList(10)
+		require.NoError(t, err)
+		require.Len(t, eventList, 2)
+		assert.Equal(t, "e2", eventList[0].EventID)
+		assert.Equal(t, int32(2), eventList[0].Attempts)
+		assert.Len(t, eventList[0].LastError, 255)
+		assert.Equal(t, "e3", eventList[1].EventID)
+	})
+	t.Run("case14: webhooks success-[one delivery per event, due in order, retried, redelivered]", func(t *testing.T) {
+		store := newStore(t)
+		webhooks := store.Webhooks()
+		subID, err := webhooks.CreateSubscription(&model.WebhookSubscription{UserID: 101, URL: "https://merchant.example.com/hooks", EventTypes: "deposit,transfer", Secret: "s1"})
+		require.NoError(t, err)
+		_, err = webhooks.CreateSubscription(&model.WebhookSubscription{UserID: 102, URL: "https://other.example.com/hooks", Secret: "s2"})
+		require.NoError(t, err)
+		sub, err := webhooks.GetSubscriptionByID(subID)
+		require.NoError(t, err)
+		require.NotNil(t, sub)
+		assert.Equal(t, "https://merchant.example.com/hooks", sub.URL)
+		assert.Equal(t, "deposit,transfer", sub.EventTypes)
+		assert.Equal(t, "s1", sub.Secret)
+		sub, err = webhooks.GetSubscriptionByID(subID + 100)
+		require.NoError(t, err)
+		assert.Nil(t, sub)
+		subList, err := webhooks.GetSubscriptionListByUserID(101)
+		require.NoError(t, err)
+		require.Len(t, subList, 1)
+		assert.Equal(t, subID, subList[0].ID)
+
+		for i, nextAttemptAt := range []int64{300, 100, 200} {
+			inserted, err := webhooks.InsertDelivery(&model.WebhookDelivery{SubscriptionID: subID, UserID: 101, EventID: fmt.Sprintf("e%d", i+1),
+				EventType: data.EventTypeDeposit, Payload: `{"amount":1}`, NextAttemptAt: nextAttemptAt})
+			require.NoError(t, err)
+			assert.True(t, inserted)
+		}
+		inserted, err := webhooks.InsertDelivery(&model.WebhookDelivery{SubscriptionID: subID, UserID: 101, EventID: "e1", NextAttemptAt: 100})
+		require.NoError(t, err)
+		assert.False(t, inserted, "event delivered to the subscription already")
+
+		deliveryList, err := webhooks.GetDueDeliveryList(250, 10)
+		require.NoError(t, err)
+		require.Len(t, deliveryList, 2)
+		assert.Equal(t, "e2", deliveryList[0].EventID)
+		assert.Equal(t, "e3", deliveryList[1].EventID)
+		assert.Equal(t, data.WebhookDeliveryPending, deliveryList[0].Status)
+		assert.Equal(t, `{"amount":1}`, deliveryList[0].Payload)
+
+		updated, err := webhooks.RecordDeliveryAttempt(deliveryList[0].ID, data.WebhookDeliveryPending, 500, strings.Repeat("x", 300), 400)
+		require.NoError(t, err)
+		assert.True(t, updated)
+		updated, err = webhooks.RecordDeliveryAttempt(deliveryList[1].ID, data.WebhookDeliveryDelivered, 200, "", 250)
+		require.NoError(t, err)
+		assert.True(t, updated)
+		updated, err = webhooks.RecordDeliveryAttempt(deliveryList[1].ID, data.WebhookDeliveryDead, 500, "late", 250)
+		require.NoError(t, err)
+		assert.False(t, updated, "not pending any more")
+		deliveryList, err = webhooks.GetDueDeliveryList(300, 10)
+		require.NoError(t, err)
+		require.Len(t, deliveryList, 1)
+		assert.Equal(t, "e1", deliveryList[0].EventID)
+
+		deliveryList, err = webhooks.GetDeliveryList(101, -1, 0, 2)
+		require.NoError(t, err)
+		require.Len(t, deliveryList, 2)
+		assert.Equal(t, "e3", deliveryList[0].EventID, "newest first")
+		assert.Equal(t, data.WebhookDeliveryDelivered, deliveryList[0].Status)
+		assert.Equal(t, int32(200), deliveryList[0].LastStatusCode)
+		assert.Equal(t, "e2", deliveryList[1].EventID)
+		assert.Equal(t, int32(1), deliveryList[1].Attempts)
+		assert.Equal(t, int64(400), deliveryList[1].NextAttemptAt)
+		assert.Len(t, deliveryList[1].LastError, 255)
+		deliveryList, err = webhooks.GetDeliveryList(101, -1, deliveryList[1].ID, 10)
+		require.NoError(t, err)
+		require.Len(t, deliveryList, 1)
+		assert.Equal(t, "e1", deliveryList[0].EventID)
+		deliveryList, err = webhooks.GetDeliveryList(101, data.WebhookDeliveryDelivered, 0, 10)
+		require.NoError(t, err)
+		require.Len(t, deliveryList, 1)
+		assert.Equal(t, "e3", deliveryList[0].EventID)
+
+		updated, err = webhooks.RedeliverDelivery(deliveryList[0].ID, 102, 500)
+		require.NoError(t, err)
+		assert.False(t, updated, "delivery of another user")
+		updated, err = webhooks.RedeliverDelivery(deliveryList[0].ID, 101, 500)
+		require.NoError(t, err)
+		assert.True(t, updated)
+		deliveryList, err = webhooks.GetDueDeliveryList(500, 10)
+		require.NoError(t, err)
+		require.Len(t, deliveryList, 3)
+		assert.Equal(t, "e1", deliveryList[0].EventID)
+		assert.Equal(t, "e2", deliveryList[1].EventID)
+		assert.Equal(t, "e3", deliveryList[2].EventID)
+		assert.Equal(t, int32(0), deliveryList[2].Attempts)
+	})
+
+	t.Run("case15: wallets success-[ledger sums with the archive, balance reset only from the balance read]", func(t *testing.T) {
+		store := newStore(t)
+		wallets, trans := store.Wallets(), store.Transactions()
+		walletID1, err := wallets.CreateOrUpdateWallet(101, data.DefaultPocket, 100)
+		require.NoError(t, err)
+		require.NoError(t, trans.InsertTransaction(&model.Transactions{OrderID: "1001", UserID: 101, WalletID: walletID1, TxType: data.TxTypeDeposit, Amount: 100, ActorUserID: 101}))
+		_, err = store.Archive().ArchiveTransactions(time.Now().Unix() + 1)
+		require.NoError(t, err)
+		require.NoError(t, wallets.UpdateWalletBalance(walletID1, data.TxTypeWithdraw, 30))
+		require.NoError(t, trans.InsertTransaction(&model.Transactions{OrderID: "1002", UserID: 101, WalletID: walletID1, TxType: data.TxTypeWithdraw, Amount: 30, ActorUserID: 101}))
+		walletID2, err := wallets.CreateOrUpdateWallet(102, data.DefaultPocket, 50)
+		require.NoError(t, err)
+		walletID3, err := wallets.CreateOrUpdateWallet(101, "savings", 5)
+		require.NoError(t, err)
+		require.NoError(t, trans.InsertTransaction(&model.Transactions{OrderID: "1003", UserID: 101, WalletID: walletID3, TxType: data.TxTypeDeposit, Amount: 2.5, ActorUserID: 101}))
+		require.NoError(t, trans.InsertTransaction(&model.Transactions{OrderID: "1004", UserID: 101, WalletID: walletID3, TxType: data.TxTypeDeposit, Amount: 2.5, ActorUserID: 101}))
+
+		ledgerList, err := wallets.GetWalletLedgerList(0, 2)
+		require.NoError(t, err)
+		require.Len(t, ledgerList, 2)
+		assert.Equal(t, walletID1, ledgerList[0].Wallet.ID)
+		assert.Equal(t, 70.0, ledgerList[0].Wallet.Balance)
+		assert.Equal(t, map[int32]float64{data.TxTypeDeposit: 100, data.TxTypeWithdraw: 30}, ledgerList[0].Sums)
+		assert.Equal(t, walletID2, ledgerList[1].Wallet.ID)
+		assert.Equal(t, int64(102), ledgerList[1].Wallet.UserID)
+		assert.Empty(t, ledgerList[1].Sums, "no transactions")
+		ledgerList, err = wallets.GetWalletLedgerList(walletID2, 10)
+		require.NoError(t, err)
+		require.Len(t, ledgerList, 1)
+		assert.Equal(t, walletID3, ledgerList[0].Wallet.ID)
+		assert.Equal(t, map[int32]float64{data.TxTypeDeposit: 5}, ledgerList[0].Sums)
+
+		reset, err := wallets.ResetWalletBalance(walletID2, 40, 0)
+		require.NoError(t, err)
+		assert.False(t, reset, "not the balance read")
+		reset, err = wallets.ResetWalletBalance(walletID2, 50, 0)
+		require.NoError(t, err)
+		assert.True(t, reset)
+		wallet, err := wallets.GetWalletByID(walletID2)
+		require.NoError(t, err)
+		assert.Equal(t, 0.0, wallet.Balance)
+		_, err = wallets.ResetWalletBalance(walletID1, 70, -10)
+		assert.Error(t, err, "below the credit limit")
+		wallet, err = wallets.GetWalletByID(walletID1)
+		require.NoError(t, err)
+		assert.Equal(t, 70.0, wallet.Balance)
+	})
+
+	t.Run("case16: chains success-[transactions chained per wallet, hashes carried to the archive]", func(t *testing.T) {
+		store := newStore(t)
+		wallets, trans, chains := store.Wallets(), store.Transactions(), store.Chains()
+		walletID1, err := wallets.CreateOrUpdateWallet(101, data.DefaultPocket, 100)
+		require.NoError(t, err)
+		walletID2, err := wallets.CreateOrUpdateWallet(102, data.DefaultPocket, 0)
+		require.NoError(t, err)
+		require.NoError(t, trans.InsertTransaction(&model.Transactions{OrderID: "1001", UserID: 101, WalletID: walletID1, TxType: data.TxTypeDeposit, Amount: 100, ActorUserID: 101}))
+		_, err = store.Archive().ArchiveTransactions(time.Now().Unix() + 1)
+		require.NoError(t, err)
+		require.NoError(t, trans.InsertTransaction(&model.Transactions{OrderID: "1002", UserID: 101, WalletID: walletID1, TxType: data.TxTypeWithdraw, Amount: 0.1, ActorUserID: 101}))
+		require.NoError(t, trans.InsertTransaction(&model.Transactions{OrderID: "1003", UserID: 101, WalletID: walletID1, TxType: data.TxTypeWithdraw, Amount: 0.2, ActorUserID: 101}))
+
+		links, err := chains.GetChainLinkList(walletID1, 0, 10)
+		require.NoError(t, err)
+		require.Equal(t, []string{"1001", "1002", "1003"}, orderIDs(links), "the archived one first")
+		prevHash := ""
+		for _, link := range links {
+			assert.Equal(t, prevHash, link.PrevHash)
+			assert.Equal(t, dao.TxHash(link), link.Hash)
+			prevHash = link.Hash
+		}
+		links, err = chains.GetChainLinkList(walletID1, links[0].ID, 1)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"1002"}, orderIDs(links))
+		links, err = chains.GetChainLinkList(walletID2, 0, 10)
+		require.NoError(t, err)
+		assert.Empty(t, links)
+
+		heads, err := chains.GetChainHeadList(0, 0, 10)
+		require.NoError(t, err)
+		assert.Equal(t, []*dao.ChainHead{{WalletID: walletID1, Hash: prevHash}, {WalletID: walletID2}}, heads)
+		heads, err = chains.GetChainHeadList(0, walletID1, 10)
+		require.NoError(t, err)
+		assert.Equal(t, []*dao.ChainHead{{WalletID: walletID2}}, heads)
+		heads, err = chains.GetChainHeadList(time.Now().Unix()+1, 0, 10)
+		require.NoError(t, err)
+		assert.Empty(t, heads, "not written to since")
+	})
+
+	t.Run("case17: audit success-[records listed newest first, filtered and paged, rolled back with their unit of work]", func(t *testing.T) {
+		store := newStore(t)
+		audit := store.Audit()
+		for i, action := range []string{"adjust.create", "adjust.approve", "reconcile.fix"} {
+			entryID, err := audit.InsertAuditLog(&model.AdminAuditLog{Actor: "alice", Action: action, TargetType: "wallet", TargetID: int64(i%2 + 1),
+				BeforeState: `{"balance":1}`, AfterState: `{"balance":2}`, Reason: strings.Repeat("r", 300), LogID: "log", SourceIP: "10.0.0.1"})
+			require.NoError(t, err)
+			assert.Greater(t, entryID, int64(0))
+		}
+
+		entries, err := audit.GetAuditLogList(&dao.AuditFilter{}, nil, 2)
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+		assert.Equal(t, "reconcile.fix", entries[0].Action)
+		assert.Equal(t, "adjust.approve", entries[1].Action)
+		assert.Equal(t, "alice", entries[0].Actor)
+		assert.Equal(t, int64(1), entries[0].TargetID)
+		assert.Equal(t, `{"balance":1}`, entries[0].BeforeState)
+		assert.Equal(t, `{"balance":2}`, entries[0].AfterState)
+		assert.Equal(t, "10.0.0.1", entries[0].SourceIP)
+		assert.NotZero(t, entries[0].CreatedAt)
+		entries, err = audit.GetAuditLogList(&dao.AuditFilter{}, &dao.AuditKey{CreatedAt: entries[1].CreatedAt, ID: entries[1].ID}, 2)
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		assert.Equal(t, "adjust.create", entries[0].Action)
+		assert.Len(t, entries[0].Reason, 255, "cut to the column")
+
+		entries, err = audit.GetAuditLogList(&dao.AuditFilter{TargetType: "wallet", TargetID: 1}, nil, 10)
+		require.NoError(t, err)
+		assert.Len(t, entries, 2)
+		entries, err = audit.GetAuditLogList(&dao.AuditFilter{Actor: "alice", Action: "adjust.approve"}, nil, 10)
+		require.NoError(t, err)
+		assert.Len(t, entries, 1)
+		entries, err = audit.GetAuditLogList(&dao.AuditFilter{Actor: "bob"}, nil, 10)
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+		entries, err = audit.GetAuditLogList(&dao.AuditFilter{StartTime: time.Now().Unix() + 1}, nil, 10)
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+
+		tx, err := store.Begin()
+		require.NoError(t, err)
+		_, err = tx.Audit().InsertAuditLog(&model.AdminAuditLog{Actor: "alice", Action: "adjust.reject"})
+		require.NoError(t, err)
+		require.NoError(t, tx.Rollback())
+		entries, err = audit.GetAuditLogList(&dao.AuditFilter{Action: "adjust.reject"}, nil, 10)
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+
+	t.Run("case18: adjustments success-[pending until reviewed once, never by their creator]", func(t *testing.T) {
+		adjustments := newStore(t).Adjustments()
+		adjustmentID, err := adjustments.InsertAdjustment(&model.BalanceAdjustment{OrderID: "adj-1", UserID: 101, WalletID: 1, TxType: data.TxTypeAdjustIn,
+			Amount: 12.5, ReasonCode: "incident", Reason: strings.Repeat("r", 300), CreatedBy: "alice"})
+		require.NoError(t, err)
+		_, err = adjustments.InsertAdjustment(&model.BalanceAdjustment{OrderID: "adj-1", UserID: 102, WalletID: 2, TxType: data.TxTypeAdjustOut, Amount: 1, CreatedBy: "bob"})
+		assert.Error(t, err, "order_id is unique")
+
+		adjustment, err := adjustments.GetAdjustmentByOrderID("adj-1")
+		require.NoError(t, err)
+		require.NotNil(t, adjustment)
+		assert.Equal(t, adjustmentID, adjustment.ID)
+		assert.Equal(t, int64(101), adjustment.UserID)
+		assert.Equal(t, data.TxTypeAdjustIn, adjustment.TxType)
+		assert.Equal(t, 12.5, adjustment.Amount)
+		assert.Equal(t, "incident", adjustment.ReasonCode)
+		assert.Len(t, adjustment.Reason, 255, "cut to the column")
+		assert.Equal(t, data.AdjustmentStatusPending, adjustment.Status)
+		assert.Equal(t, "alice", adjustment.CreatedBy)
+		assert.Empty(t, adjustment.ReviewedBy)
+		adjustment, err = adjustments.GetAdjustmentByOrderID("adj-2")
+		require.NoError(t, err)
+		assert.Nil(t, adjustment)
+
+		_, err = adjustments.ReviewAdjustment(adjustmentID, data.AdjustmentStatusPending, data.AdjustmentStatusPosted, "alice")
+		assert.Error(t, err, "reviewed by its creator")
+		reviewed, err := adjustments.ReviewAdjustment(adjustmentID, data.AdjustmentStatusPending, data.AdjustmentStatusPosted, "bob")
+		require.NoError(t, err)
+		assert.True(t, reviewed)
+		reviewed, err = adjustments.ReviewAdjustment(adjustmentID, data.AdjustmentStatusPending, data.AdjustmentStatusRejected, "carol")
+		require.NoError(t, err)
+		assert.False(t, reviewed, "no longer pending")
+		adjustment, err = adjustments.GetAdjustmentByOrderID("adj-1")
+		require.NoError(t, err)
+		assert.Equal(t, data.AdjustmentStatusPosted, adjustment.Status)
+		assert.Equal(t, "bob", adjustment.ReviewedBy)
+	})
+
+	t.Run("case19: transactions success-[count the ones without wallet_id, archived included]", func(t *testing.T) {
+		store := newStore(t)
+		trans := store.Transactions()
+		count, err := trans.CountTransactionsWithoutWallet()
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), count)
+		for _, tx := range []*model.Transactions{
+			{OrderID: "1001", UserID: 101, TxType: data.TxTypeDeposit, Amount: 100, ActorUserID: 101},
+			{OrderID: "1002", UserID: 101, WalletID: 1, TxType: data.TxTypeDeposit, Amount: 20, ActorUserID: 101},
+		} {
+			require.NoError(t, trans.InsertTransaction(tx))
+		}
+		_, err = store.Archive().ArchiveTransactions(time.Now().Unix() + 1)
+		require.NoError(t, err)
+		require.NoError(t, trans.InsertTransaction(&model.Transactions{OrderID: "1003", UserID: 102, TxType: data.TxTypeDeposit, Amount: 5, ActorUserID: 102}))
+		count, err = trans.CountTransactionsWithoutWallet()
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), count)
+	})
+
+	t.Run("case20: wallets success-[set the credit limit and product, never below the overdraft]", func(t *testing.T) {
+		wallets := newStore(t).Wallets()
+		walletID, err := wallets.CreateOrUpdateWallet(101, data.DefaultPocket, 10)
+		require.NoError(t, err)
+		require.NoError(t, wallets.UpdateWalletTerms(walletID, 100, "savings"))
+		require.NoError(t, wallets.UpdateWalletBalance(walletID, data.TxTypeWithdraw, 60))
+		assert.Error(t, wallets.UpdateWalletTerms(walletID, 40, "savings"), "limit below the overdraft")
+		assert.Error(t, wallets.UpdateWalletTerms(walletID, -1, ""), "negative limit")
+
+		wallet, err := wallets.GetWalletByID(walletID)
+		require.NoError(t, err)
+		assert.Equal(t, 100.0, wallet.CreditLimit)
+		assert.Equal(t, "savings", wallet.Product)
+		assert.Equal(t, -50.0, wallet.Balance)
+		walletList, err := wallets.GetWalletListByProduct("savings", 0, 10)
+		require.NoError(t, err)
+		require.Len(t, walletList, 1)
+		assert.Equal(t, walletID, walletList[0].ID)
+	})
+}
+
+func orderIDs(txList []*model.Transactions) []string {
+	ids := make([]string, 0, len(txList))
+	for _, tx := range txList {
+		ids = append(ids, tx.OrderID)
+	}
+	return ids
+}
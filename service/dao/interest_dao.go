@@ -0,0 +1,72 @@
+package dao
+
+import (
+	"context"
+	"log"
+	"simplewallet/model"
+	"time"
+)
+
+type InterestDao struct {
+	dbConn
+}
+
+func NewInterestDao(ctx context.Context, logID string, db DBTX) *InterestDao {
+	return &InterestDao{dbConn: dbConn{ctx: ctx, logID: logID, db: db}}
+}
+
+// insert accrual, do nothing if the wallet already accrued on that day
+func (d *InterestDao) InsertAccrual(accrual *model.InterestAccrual) (bool, error) {
+	tn := time.Now().Unix()
+	res, err := d.exec("INSERT INTO interest_accruals (wallet_id, user_id, accrual_date, balance, rate, amount, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) ON CONFLICT (wallet_id, accrual_date) DO NOTHING",
+		accrual.WalletID, accrual.UserID, accrual.AccrualDate, accrual.Balance, accrual.Rate, accrual.Amount, tn, tn)
+	if err != nil {
+		log.Printf("%s|[%d] Failed to insert interest accrual: %v", d.logID, accrual.WalletID, err)
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// wallet ids having unpaid accruals between [from, to]
+func (d *InterestDao) GetUnpaidWalletIDs(from int32, to int32) ([]int64, error) {
+	walletIDs := make([]int64, 0)
+	rows, err := d.query("SELECT DISTINCT wallet_id FROM interest_accruals WHERE accrual_date >= $1 AND accrual_date <= $2 AND payout_order_id = '' AND amount > 0 ORDER BY wallet_id", from, to)
+	if err != nil {
+		log.Printf("%s|[%d-%d] Failed to get unpaid interest wallets: %v", d.logID, from, to, err)
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var walletID int64
+		if err = rows.Scan(&walletID); err != nil {
+			log.Printf("%s|[%d-%d] Failed to scan unpaid interest wallet: %v", d.logID, from, to, err)
+			return nil, err
+		}
+		walletIDs = append(walletIDs, walletID)
+	}
+	return walletIDs, rows.Err()
+}
+
+// unpaid interest of a wallet between [from, to], with the owner user id
+func (d *InterestDao) GetUnpaidAmount(walletID int64, from int32, to int32) (int64, float64, error) {
+	var userID int64
+	var amount float64
+	err := d.queryRow("SELECT COALESCE(MAX(user_id), 0), COALESCE(SUM(amount), 0) FROM interest_accruals WHERE wallet_id = $1 AND accrual_date >= $2 AND accrual_date <= $3 AND payout_order_id = ''", walletID, from, to).Scan(&userID, &amount)
+	if err != nil {
+		log.Printf("%s|[%d] Failed to get unpaid interest amount: %v", d.logID, walletID, err)
+		return 0, 0, err
+	}
+	return userID, amount, nil
+}
+
+// mark accruals between [from, to] paid by the payout order
+func (d *InterestDao) MarkAccrualsPaid(walletID int64, from int32, to int32, orderID string) error {
+	tn := time.Now().Unix()
+	_, err := d.exec("UPDATE interest_accruals SET payout_order_id = $1, updated_at = $2 WHERE wallet_id = $3 AND accrual_date >= $4 AND accrual_date <= $5 AND payout_order_id = ''",
+		orderID, tn, walletID, from, to)
+	return err
+}
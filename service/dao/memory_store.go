@@ -0,0 +1,1376 @@
+package dao
+
+import (
+	"cmp"
+	"errors"
+	"simplewallet/data"
+	"simplewallet/model"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+var (
+	errMemUniqueViolation = errors.New("duplicate key value violates unique constraint")
+	errMemCheckViolation  = errors.New("new row violates check constraint")
+)
+
+// memData holds the tables of the memory store, rows are kept by value so a copy is a snapshot
+type memData struct {
+	lastID       map[string]int64
+	wallets      map[int64]model.Wallet
+	txHeads      map[int64]string // wallets.tx_hash, the head of the chain of each wallet
+	transactions []model.Transactions
+	archive      []model.Transactions
+	archivedTo   int64
+	orgs         map[int64]model.Organization
+	members      map[[2]int64]model.OrgMember
+	approvals    map[int64]model.TransferApproval
+	votes        []model.ApprovalVote
+	accruals     []model.InterestAccrual
+	shardTrans   map[string]model.ShardTransfer
+	outbox       []model.OutboxEvent
+	webhookSubs  []model.WebhookSubscription
+	deliveries   []model.WebhookDelivery
+	auditLogs    []model.AdminAuditLog
+	adjustments  map[int64]model.BalanceAdjustment
+}
+
+func newMemData() *memData {
+	return &memData{
+		lastID:      make(map[string]int64),
+		wallets:     make(map[int64]model.Wallet),
+		txHeads:     make(map[int64]string),
+		orgs:        make(map[int64]model.Organization),
+		members:     make(map[[2]int64]model.OrgMember),
+		approvals:   make(map[int64]model.TransferApproval),
+		shardTrans:  make(map[string]model.ShardTransfer),
+		adjustments: make(map[int64]model.BalanceAdjustment),
+	}
+}
+
+func (d *memData) clone() *memData {
+	c := newMemData()
+	for k, v := range d.lastID {
+		c.lastID[k] = v
+	}
+	for k, v := range d.wallets {
+		c.wallets[k] = v
+	}
+	for k, v := range d.txHeads {
+		c.txHeads[k] = v
+	}
+	for k, v := range d.orgs {
+		c.orgs[k] = v
+	}
+	for k, v := range d.members {
+		c.members[k] = v
+	}
+	for k, v := range d.approvals {
+		c.approvals[k] = v
+	}
+	for k, v := range d.shardTrans {
+		c.shardTrans[k] = v
+	}
+	for k, v := range d.adjustments {
+		c.adjustments[k] = v
+	}
+	c.transactions = append(c.transactions, d.transactions...)
+	c.archive = append(c.archive, d.archive...)
+	c.archivedTo = d.archivedTo
+	c.votes = append(c.votes, d.votes...)
+	c.accruals = append(c.accruals, d.accruals...)
+	c.outbox = append(c.outbox, d.outbox...)
+	c.webhookSubs = append(c.webhookSubs, d.webhookSubs...)
+	c.deliveries = append(c.deliveries, d.deliveries...)
+	c.auditLogs = append(c.auditLogs, d.auditLogs...)
+	return c
+}
+
+func (d *memData) nextID(table string) int64 {
+	d.lastID[table]++
+	return d.lastID[table]
+}
+
+// MemoryStore keeps everything in process memory, for tests and local runs.
+// A unit of work takes the store lock until it ends and works on a copy of the data,
+// so units of work are serialized and Rollback just drops the copy.
+type MemoryStore struct {
+	mu   sync.Mutex
+	data *memData
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: newMemData()}
+}
+
+func (s *MemoryStore) Begin() (UnitOfWork, error) {
+	s.mu.Lock()
+	return &memUnitOfWork{memRepos: memRepos{data: s.data.clone()}, store: s}, nil
+}
+
+func (s *MemoryStore) Wallets() WalletRepo {
+	return memWallets{s.repos()}
+}
+
+func (s *MemoryStore) Transactions() TransactionsRepo {
+	return memTransactions{s.repos()}
+}
+
+func (s *MemoryStore) Orgs() OrgRepo {
+	return memOrgs{s.repos()}
+}
+
+func (s *MemoryStore) Approvals() ApprovalRepo {
+	return memApprovals{s.repos()}
+}
+
+func (s *MemoryStore) Interest() InterestRepo {
+	return memInterest{s.repos()}
+}
+
+func (s *MemoryStore) ShardTransfers() ShardTransferRepo {
+	return memShardTransfers{s.repos()}
+}
+
+// repos outside a unit of work lock the store for each call
+func (s *MemoryStore) Archive() ArchiveRepo {
+	return memArchive{s.repos()}
+}
+
+func (s *MemoryStore) Outbox() OutboxRepo {
+	return memOutbox{s.repos()}
+}
+
+func (s *MemoryStore) Webhooks() WebhookRepo {
+	return memWebhooks{s.repos()}
+}
+
+func (s *MemoryStore) Chains() ChainRepo {
+	return memChains{s.repos()}
+}
+
+func (s *MemoryStore) Audit() AuditRepo {
+	return memAudit{s.repos()}
+}
+
+func (s *MemoryStore) Adjustments() AdjustmentRepo {
+	return memAdjustments{s.repos()}
+}
+
+func (s *MemoryStore) repos() *memRepos {
+	return &memRepos{store: s}
+}
+
+type memUnitOfWork struct {
+	memRepos
+	store *MemoryStore
+	done  bool
+}
+
+func (u *memUnitOfWork) Commit() error {
+	if u.done {
+		return errors.New("unit of work already ended")
+	}
+	u.done = true
+	u.store.data = u.data
+	u.store.mu.Unlock()
+	return nil
+}
+
+func (u *memUnitOfWork) Rollback() error {
+	if u.done {
+		return errors.New("unit of work already ended")
+	}
+	u.done = true
+	u.store.mu.Unlock()
+	return nil
+}
+
+func (u *memUnitOfWork) Wallets() WalletRepo {
+	return memWallets{&u.memRepos}
+}
+
+func (u *memUnitOfWork) Transactions() TransactionsRepo {
+	return memTransactions{&u.memRepos}
+}
+
+func (u *memUnitOfWork) Orgs() OrgRepo {
+	return memOrgs{&u.memRepos}
+}
+
+func (u *memUnitOfWork) Approvals() ApprovalRepo {
+	return memApprovals{&u.memRepos}
+}
+
+func (u *memUnitOfWork) Interest() InterestRepo {
+	return memInterest{&u.memRepos}
+}
+
+func (u *memUnitOfWork) ShardTransfers() ShardTransferRepo {
+	return memShardTransfers{&u.memRepos}
+}
+
+func (u *memUnitOfWork) Archive() ArchiveRepo {
+	return memArchive{&u.memRepos}
+}
+
+func (u *memUnitOfWork) Outbox() OutboxRepo {
+	return memOutbox{&u.memRepos}
+}
+
+func (u *memUnitOfWork) Webhooks() WebhookRepo {
+	return memWebhooks{&u.memRepos}
+}
+
+func (u *memUnitOfWork) Chains() ChainRepo {
+	return memChains{&u.memRepos}
+}
+
+func (u *memUnitOfWork) Audit() AuditRepo {
+	return memAudit{&u.memRepos}
+}
+
+func (u *memUnitOfWork) Adjustments() AdjustmentRepo {
+	return memAdjustments{&u.memRepos}
+}
+
+// memRepos works on the data of a unit of work, or on the store data under the store lock
+type memRepos struct {
+	data  *memData
+	store *MemoryStore
+}
+
+// with runs fn on the data the repos work on. Writes outside a unit of work go to a copy
+// that replaces the store data when fn succeeds, like an autocommit statement.
+func (r *memRepos) with(write bool, fn func(d *memData) error) error {
+	if r.store == nil {
+		return fn(r.data)
+	}
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	if !write {
+		return fn(r.store.data)
+	}
+	d := r.store.data.clone()
+	if err := fn(d); err != nil {
+		return err
+	}
+	r.store.data = d
+	return nil
+}
+
+// addAmount adds with the precision of the DECIMAL(15, 8) columns
+func addAmount(a float64, b float64) float64 {
+	return decimal.NewFromFloat(a).Add(decimal.NewFromFloat(b)).Round(8).InexactFloat64()
+}
+
+// checkWallet applies the check constraints of the wallets table
+func checkWallet(wallet *model.Wallet) error {
+	floor := decimal.NewFromFloat(wallet.CreditLimit).Neg()
+	balance := decimal.NewFromFloat(wallet.Balance)
+	held := decimal.NewFromFloat(wallet.Held)
+	if wallet.CreditLimit < 0 || balance.LessThan(floor) || held.IsNegative() || balance.Sub(held).LessThan(floor) {
+		return errMemCheckViolation
+	}
+	return nil
+}
+
+type memWallets struct{ *memRepos }
+
+func (r memWallets) GetWalletByUserID(userID int64, name string) (*model.Wallet, error) {
+	var wallet *model.Wallet
+	err := r.with(false, func(d *memData) error {
+		wallet = findWallet(d, func(w *model.Wallet) bool { return w.UserID == userID && w.Name == name })
+		return nil
+	})
+	return wallet, err
+}
+
+func (r memWallets) GetWalletListByUserID(userID int64) ([]*model.Wallet, error) {
+	var walletList []*model.Wallet
+	err := r.with(false, func(d *memData) error {
+		walletList = listWallets(d, func(w *model.Wallet) bool { return w.UserID == userID })
+		return nil
+	})
+	return walletList, err
+}
+
+func (r memWallets) GetWalletByID(walletID int64) (*model.Wallet, error) {
+	var wallet *model.Wallet
+	err := r.with(false, func(d *memData) error {
+		if w, ok := d.wallets[walletID]; ok {
+			wallet = &w
+		}
+		return nil
+	})
+	return wallet, err
+}
+
+func (r memWallets) GetWalletByOrgID(orgID int64, name string) (*model.Wallet, error) {
+	var wallet *model.Wallet
+	err := r.with(false, func(d *memData) error {
+		wallet = findWallet(d, func(w *model.Wallet) bool { return w.OrgID == orgID && w.UserID == 0 && w.Name == name })
+		return nil
+	})
+	return wallet, err
+}
+
+func (r memWallets) GetWalletListByOrgID(orgID int64) ([]*model.Wallet, error) {
+	var walletList []*model.Wallet
+	err := r.with(false, func(d *memData) error {
+		walletList = listWallets(d, func(w *model.Wallet) bool { return w.OrgID == orgID && w.UserID == 0 })
+		return nil
+	})
+	return walletList, err
+}
+
+func (r memWallets) CreateOrgWallet(orgID int64, name string) (int64, error) {
+	var walletID int64
+	err := r.with(true, func(d *memData) error {
+		if findWallet(d, func(w *model.Wallet) bool { return w.OrgID == orgID && w.UserID == 0 && w.Name == name }) != nil {
+			return errMemUniqueViolation
+		}
+		walletID = insertWallet(d, model.Wallet{OrgID: orgID, Name: name})
+		return nil
+	})
+	return walletID, err
+}
+
+func (r memWallets) GetWalletListByProduct(product string, lastID int64, limit int32) ([]*model.Wallet, error) {
+	var walletList []*model.Wallet
+	err := r.with(false, func(d *memData) error {
+		walletList = listWallets(d, func(w *model.Wallet) bool { return w.Product == product && w.ID > lastID })
+		if len(walletList) > int(limit) {
+			walletList = walletList[:limit]
+		}
+		return nil
+	})
+	return walletList, err
+}
+
+func (r memWallets) CreateOrUpdateWallet(userID int64, name string, balance float64) (int64, error) {
+	var walletID int64
+	err := r.with(true, func(d *memData) error {
+		wallet := findWallet(d, func(w *model.Wallet) bool { return w.UserID == userID && w.Name == name })
+		if wallet == nil {
+			newWallet := model.Wallet{UserID: userID, Name: name, Balance: balance}
+			if err := checkWallet(&newWallet); err != nil {
+				return err
+			}
+			walletID = insertWallet(d, newWallet)
+			return nil
+		}
+		walletID = wallet.ID
+		return updateWallet(d, walletID, func(w *model.Wallet) { w.Balance = addAmount(w.Balance, balance) })
+	})
+	return walletID, err
+}
+
+func (r memWallets) UpdateWalletBalance(walletID int64, txType int32, balance float64) error {
+	sign := data.TxTypeSign(txType)
+	if sign == 0 {
+		return nil
+	}
+	return r.with(true, func(d *memData) error {
+		return updateWallet(d, walletID, func(w *model.Wallet) { w.Balance = addAmount(w.Balance, balance*float64(sign)) })
+	})
+}
+
+// LockWallet has nothing to do, a unit of work holds the whole store
+func (r memWallets) LockWallet(walletID int64) error {
+	return nil
+}
+
+func (r memWallets) HoldBalance(walletID int64, amount float64) error {
+	return r.with(true, func(d *memData) error {
+		return updateWallet(d, walletID, func(w *model.Wallet) { w.Held = addAmount(w.Held, amount) })
+	})
+}
+
+func (r memWallets) ReleaseHold(walletID int64, amount float64) error {
+	return r.with(true, func(d *memData) error {
+		return updateWallet(d, walletID, func(w *model.Wallet) { w.Held = addAmount(w.Held, -amount) })
+	})
+}
+
+func (r memWallets) UpdateWalletTerms(walletID int64, creditLimit float64, product string) error {
+	return r.with(true, func(d *memData) error {
+		return updateWallet(d, walletID, func(w *model.Wallet) { w.CreditLimit, w.Product = creditLimit, product })
+	})
+}
+
+func (r memWallets) GetWalletLedgerList(lastID int64, limit int32) ([]*WalletLedger, error) {
+	var ledgerList []*WalletLedger
+	err := r.with(false, func(d *memData) error {
+		walletList := listWallets(d, func(w *model.Wallet) bool { return w.ID > lastID })
+		if len(walletList) > int(limit) {
+			walletList = walletList[:limit]
+		}
+		ledgerList = make([]*WalletLedger, 0, len(walletList))
+		byID := make(map[int64]*WalletLedger, len(walletList))
+		for _, wallet := range walletList {
+			ledger := &WalletLedger{Wallet: wallet, Sums: make(map[int32]float64)}
+			ledgerList = append(ledgerList, ledger)
+			byID[wallet.ID] = ledger
+		}
+		for _, t := range append(d.transactions[:len(d.transactions):len(d.transactions)], d.archive...) {
+			if ledger, ok := byID[t.WalletID]; ok {
+				ledger.Sums[t.TxType] = addAmount(ledger.Sums[t.TxType], t.Amount)
+			}
+		}
+		return nil
+	})
+	return ledgerList, err
+}
+
+func (r memWallets) ResetWalletBalance(walletID int64, from float64, to float64) (bool, error) {
+	var reset bool
+	err := r.with(true, func(d *memData) error {
+		if wallet, ok := d.wallets[walletID]; !ok || wallet.Balance != from {
+			return nil
+		}
+		if err := updateWallet(d, walletID, func(w *model.Wallet) { w.Balance = to }); err != nil {
+			return err
+		}
+		reset = true
+		return nil
+	})
+	return reset, err
+}
+
+func findWallet(d *memData, match func(w *model.Wallet) bool) *model.Wallet {
+	walletList := listWallets(d, match)
+	if len(walletList) == 0 {
+		return nil
+	}
+	return walletList[0]
+}
+
+// listWallets returns copies of the matching wallets in id order
+func listWallets(d *memData, match func(w *model.Wallet) bool) []*model.Wallet {
+	walletList := make([]*model.Wallet, 0)
+	for _, w := range d.wallets {
+		wallet := w
+		if match(&wallet) {
+			walletList = append(walletList, &wallet)
+		}
+	}
+	sort.Slice(walletList, func(i, j int) bool { return walletList[i].ID < walletList[j].ID })
+	return walletList
+}
+
+func insertWallet(d *memData, wallet model.Wallet) int64 {
+	tn := time.Now().Unix()
+	wallet.ID = d.nextID("wallets")
+	wallet.CreatedAt, wallet.UpdatedAt = tn, tn
+	d.wallets[wallet.ID] = wallet
+	return wallet.ID
+}
+
+// updateWallet changes a wallet if it exists, like an UPDATE matching no row
+func updateWallet(d *memData, walletID int64, change func(w *model.Wallet)) error {
+	wallet, ok := d.wallets[walletID]
+	if !ok {
+		return nil
+	}
+	change(&wallet)
+	if err := checkWallet(&wallet); err != nil {
+		return err
+	}
+	wallet.UpdatedAt = time.Now().Unix()
+	d.wallets[walletID] = wallet
+	return nil
+}
+
+type memTransactions struct{ *memRepos }
+
+func (r memTransactions) GetTransactionByOrderID(orderID string) (*model.Transactions, error) {
+	var tx *model.Transactions
+	err := r.with(false, func(d *memData) error {
+		for _, t := range append(d.transactions[:len(d.transactions):len(d.transactions)], d.archive...) {
+			if t.OrderID == orderID {
+				trans := t
+				tx = &trans
+				break
+			}
+		}
+		return nil
+	})
+	return tx, err
+}
+
+func (r memTransactions) GetTransactionList(filter *TxFilter, page int32, limit int32) ([]*model.Transactions, error) {
+	txList := make([]*model.Transactions, 0)
+	offset := int((page - 1) * limit)
+	err := r.with(false, func(d *memData) error {
+		for _, trans := range sortedTransactions(d, filter) {
+			if offset > 0 {
+				offset--
+				continue
+			}
+			if len(txList) >= int(limit) {
+				break
+			}
+			txList = append(txList, trans)
+		}
+		return nil
+	})
+	return txList, err
+}
+
+func (r memTransactions) GetTransactionListByCursor(filter *TxFilter, key *TxKey, backward bool, limit int32) ([]*model.Transactions, error) {
+	txList := make([]*model.Transactions, 0)
+	err := r.with(false, func(d *memData) error {
+		all := sortedTransactions(d, filter)
+		if backward {
+			slices.Reverse(all)
+		}
+		for _, trans := range all {
+			if key != nil {
+				c := compareTxKey(TxKey{CreatedAt: trans.CreatedAt, ID: trans.ID}, *key)
+				if (backward && c >= 0) || (!backward && c <= 0) {
+					continue
+				}
+			}
+			if len(txList) >= int(limit) {
+				break
+			}
+			txList = append(txList, trans)
+		}
+		return nil
+	})
+	if backward {
+		slices.Reverse(txList)
+	}
+	return txList, err
+}
+
+func (r memTransactions) CountTransactions(filter *TxFilter) (int64, error) {
+	var total int64
+	err := r.with(false, func(d *memData) error {
+		total = int64(len(sortedTransactions(d, filter)))
+		return nil
+	})
+	return total, err
+}
+
+func (r memTransactions) CountTransactionsWithoutWallet() (int64, error) {
+	var total int64
+	err := r.with(false, func(d *memData) error {
+		for _, t := range append(d.transactions[:len(d.transactions):len(d.transactions)], d.archive...) {
+			if t.WalletID == 0 {
+				total++
+			}
+		}
+		return nil
+	})
+	return total, err
+}
+
+// sortedTransactions returns copies of the transactions matching the filter ordered by (created_at, id)
+func sortedTransactions(d *memData, filter *TxFilter) []*model.Transactions {
+	txList := make([]*model.Transactions, 0)
+	all := d.transactions
+	if filter.Archived {
+		all = append(all[:len(all):len(all)], d.archive...)
+	}
+	for _, t := range all {
+		if !filter.match(&t) {
+			continue
+		}
+		trans := t
+		txList = append(txList, &trans)
+	}
+	sort.Slice(txList, func(i, j int) bool {
+		return compareTxKey(TxKey{CreatedAt: txList[i].CreatedAt, ID: txList[i].ID}, TxKey{CreatedAt: txList[j].CreatedAt, ID: txList[j].ID}) < 0
+	})
+	return txList
+}
+
+func (f *TxFilter) match(t *model.Transactions) bool {
+	switch {
+	case t.UserID != f.UserID,
+		f.WalletID > 0 && t.WalletID != f.WalletID,
+		len(f.TxTypes) > 0 && !slices.Contains(f.TxTypes, t.TxType),
+		f.StartTime > 0 && t.CreatedAt < f.StartTime,
+		f.EndTime > 0 && t.CreatedAt >= f.EndTime,
+		f.MinAmount > 0 && t.Amount < f.MinAmount,
+		f.MaxAmount > 0 && t.Amount > f.MaxAmount,
+		f.RelatedUserID > 0 && t.RelatedUserID != f.RelatedUserID,
+		!strings.HasPrefix(t.OrderID, f.OrderIDPrefix):
+		return false
+	}
+	return true
+}
+
+func compareTxKey(a, b TxKey) int {
+	if c := cmp.Compare(a.CreatedAt, b.CreatedAt); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.ID, b.ID)
+}
+
+func (r memTransactions) GetAmountSumByTxType(walletID int64, before int64) (map[int32]float64, error) {
+	sums := make(map[int32]float64)
+	err := r.with(false, func(d *memData) error {
+		for _, t := range append(d.transactions[:len(d.transactions):len(d.transactions)], d.archive...) {
+			if t.WalletID == walletID && t.CreatedAt < before {
+				sums[t.TxType] = addAmount(sums[t.TxType], t.Amount)
+			}
+		}
+		return nil
+	})
+	return sums, err
+}
+
+func (r memTransactions) GetDebitSumByActor(walletID int64, actorUserID int64, since int64) (float64, error) {
+	var amount float64
+	err := r.with(false, func(d *memData) error {
+		for _, t := range d.transactions {
+			if t.WalletID == walletID && t.ActorUserID == actorUserID && t.CreatedAt >= since &&
+				(t.TxType == data.TxTypeWithdraw || t.TxType == data.TxTypeTransferOut) {
+				amount = addAmount(amount, t.Amount)
+			}
+		}
+		return nil
+	})
+	return amount, err
+}
+
+func (r memTransactions) InsertTransaction(tx *model.Transactions) error {
+	return r.with(true, func(d *memData) error {
+		// the order_id of a transfer waiting for approvals is its own, until the last vote executes it
+		for _, a := range d.approvals {
+			if a.OrderID == tx.OrderID && a.Status == data.ApprovalStatusPending && countVotes(d, a.ID) < int(a.RequiredApprovals) {
+				return ErrOrderIDPending
+			}
+		}
+		tn := time.Now().Unix()
+		trans := *tx
+		trans.ID = d.nextID("transactions")
+		trans.CreatedAt, trans.UpdatedAt = tn, tn
+		trans.PrevHash = d.txHeads[trans.WalletID]
+		trans.Hash = TxHash(&trans)
+		d.transactions = append(d.transactions, trans)
+		d.txHeads[trans.WalletID] = trans.Hash
+		if wallet, ok := d.wallets[trans.WalletID]; ok {
+			wallet.UpdatedAt = tn
+			d.wallets[trans.WalletID] = wallet
+		}
+		return nil
+	})
+}
+
+type memArchive struct{ *memRepos }
+
+func (r memArchive) GetArchivedBefore() (int64, error) {
+	var before int64
+	err := r.with(false, func(d *memData) error {
+		before = d.archivedTo
+		return nil
+	})
+	return before, err
+}
+
+// the memory store has no partitions
+func (r memArchive) CreatePartitions(from int64, to int64) ([]string, error) {
+	return nil, nil
+}
+
+func (r memArchive) ArchiveTransactions(before int64) (int64, error) {
+	var moved int64
+	err := r.with(true, func(d *memData) error {
+		kept := make([]model.Transactions, 0, len(d.transactions))
+		for _, t := range d.transactions {
+			if t.CreatedAt < before {
+				d.archive = append(d.archive, t)
+				moved++
+				continue
+			}
+			kept = append(kept, t)
+		}
+		d.transactions = kept
+		d.archivedTo = max(d.archivedTo, before)
+		return nil
+	})
+	return moved, err
+}
+
+type memOrgs struct{ *memRepos }
+
+func (r memOrgs) CreateOrg(org *model.Organization) (int64, error) {
+	var orgID int64
+	err := r.with(true, func(d *memData) error {
+		tn := time.Now().Unix()
+		o := *org
+		o.ID = d.nextID("organizations")
+		o.CreatedAt, o.UpdatedAt = tn, tn
+		d.orgs[o.ID] = o
+		orgID = o.ID
+		return nil
+	})
+	return orgID, err
+}
+
+func (r memOrgs) GetOrgByID(orgID int64) (*model.Organization, error) {
+	var org *model.Organization
+	err := r.with(false, func(d *memData) error {
+		if o, ok := d.orgs[orgID]; ok {
+			org = &o
+		}
+		return nil
+	})
+	return org, err
+}
+
+func (r memOrgs) GetMember(orgID int64, userID int64) (*model.OrgMember, error) {
+	var member *model.OrgMember
+	err := r.with(false, func(d *memData) error {
+		if m, ok := d.members[[2]int64{orgID, userID}]; ok {
+			member = &m
+		}
+		return nil
+	})
+	return member, err
+}
+
+func (r memOrgs) SaveMember(member *model.OrgMember) error {
+	return r.with(true, func(d *memData) error {
+		tn := time.Now().Unix()
+		key := [2]int64{member.OrgID, member.UserID}
+		m, ok := d.members[key]
+		if !ok {
+			m = model.OrgMember{ID: d.nextID("org_members"), OrgID: member.OrgID, UserID: member.UserID, CreatedAt: tn}
+		}
+		m.Role, m.SpendingLimit, m.UpdatedAt = member.Role, member.SpendingLimit, tn
+		d.members[key] = m
+		return nil
+	})
+}
+
+type memApprovals struct{ *memRepos }
+
+func (r memApprovals) InsertApproval(approval *model.TransferApproval) (int64, error) {
+	var approvalID int64
+	err := r.with(true, func(d *memData) error {
+		for _, a := range d.approvals {
+			if a.OrderID == approval.OrderID {
+				return errMemUniqueViolation
+			}
+		}
+		tn := time.Now().Unix()
+		a := *approval
+		a.ID = d.nextID("transfer_approvals")
+		a.Status = data.ApprovalStatusPending
+		a.CreatedAt, a.UpdatedAt = tn, tn
+		d.approvals[a.ID] = a
+		approvalID = a.ID
+		return nil
+	})
+	return approvalID, err
+}
+
+func (r memApprovals) GetApprovalByOrderID(orderID string) (*model.TransferApproval, error) {
+	var approval *model.TransferApproval
+	err := r.with(false, func(d *memData) error {
+		approvalList := listApprovals(d, func(a *model.TransferApproval) bool { return a.OrderID == orderID }, 1)
+		if len(approvalList) > 0 {
+			approval = approvalList[0]
+		}
+		return nil
+	})
+	return approval, err
+}
+
+func (r memApprovals) GetPendingApprovalListByOrgID(orgID int64, limit int32) ([]*model.TransferApproval, error) {
+	var approvalList []*model.TransferApproval
+	err := r.with(false, func(d *memData) error {
+		approvalList = listApprovals(d, func(a *model.TransferApproval) bool {
+			return a.OrgID == orgID && a.Status == data.ApprovalStatusPending
+		}, limit)
+		return nil
+	})
+	return approvalList, err
+}
+
+func (r memApprovals) GetDueApprovalList(now int64, limit int32) ([]*model.TransferApproval, error) {
+	var approvalList []*model.TransferApproval
+	err := r.with(false, func(d *memData) error {
+		approvalList = listApprovals(d, func(a *model.TransferApproval) bool {
+			return a.Status == data.ApprovalStatusPending && a.ExpireAt <= now
+		}, limit)
+		return nil
+	})
+	return approvalList, err
+}
+
+func (r memApprovals) UpdateApprovalStatus(approvalID int64, from int32, to int32) (bool, error) {
+	updated := false
+	err := r.with(true, func(d *memData) error {
+		a, ok := d.approvals[approvalID]
+		if !ok || a.Status != from {
+			return nil
+		}
+		a.Status, a.UpdatedAt = to, time.Now().Unix()
+		d.approvals[approvalID] = a
+		updated = true
+		return nil
+	})
+	return updated, err
+}
+
+func (r memApprovals) InsertVote(approvalID int64, approverUserID int64) (bool, error) {
+	inserted := false
+	err := r.with(true, func(d *memData) error {
+		for _, v := range d.votes {
+			if v.ApprovalID == approvalID && v.ApproverUserID == approverUserID {
+				return nil
+			}
+		}
+		d.votes = append(d.votes, model.ApprovalVote{ID: d.nextID("approval_votes"), ApprovalID: approvalID, ApproverUserID: approverUserID, CreatedAt: time.Now().Unix()})
+		inserted = true
+		return nil
+	})
+	return inserted, err
+}
+
+func (r memApprovals) GetVoteList(approvalID int64) ([]*model.ApprovalVote, error) {
+	voteList := make([]*model.ApprovalVote, 0)
+	err := r.with(false, func(d *memData) error {
+		for _, v := range d.votes {
+			if v.ApprovalID == approvalID {
+				vote := v
+				voteList = append(voteList, &vote)
+			}
+		}
+		return nil
+	})
+	return voteList, err
+}
+
+// countVotes returns the number of votes recorded on the approval
+func countVotes(d *memData, approvalID int64) int {
+	n := 0
+	for _, v := range d.votes {
+		if v.ApprovalID == approvalID {
+			n++
+		}
+	}
+	return n
+}
+
+// listApprovals returns copies of the matching approvals in id order, at most limit
+func listApprovals(d *memData, match func(a *model.TransferApproval) bool, limit int32) []*model.TransferApproval {
+	approvalList := make([]*model.TransferApproval, 0)
+	for _, a := range d.approvals {
+		approval := a
+		if match(&approval) {
+			approvalList = append(approvalList, &approval)
+		}
+	}
+	sort.Slice(approvalList, func(i, j int) bool { return approvalList[i].ID < approvalList[j].ID })
+	if len(approvalList) > int(limit) {
+		approvalList = approvalList[:limit]
+	}
+	return approvalList
+}
+
+type memInterest struct{ *memRepos }
+
+func (r memInterest) InsertAccrual(accrual *model.InterestAccrual) (bool, error) {
+	inserted := false
+	err := r.with(true, func(d *memData) error {
+		for _, a := range d.accruals {
+			if a.WalletID == accrual.WalletID && a.AccrualDate == accrual.AccrualDate {
+				return nil
+			}
+		}
+		tn := time.Now().Unix()
+		a := *accrual
+		a.ID = d.nextID("interest_accruals")
+		a.PayoutOrderID = ""
+		a.CreatedAt, a.UpdatedAt = tn, tn
+		d.accruals = append(d.accruals, a)
+		inserted = true
+		return nil
+	})
+	return inserted, err
+}
+
+func (r memInterest) GetUnpaidWalletIDs(from int32, to int32) ([]int64, error) {
+	walletIDs := make([]int64, 0)
+	err := r.with(false, func(d *memData) error {
+		seen := make(map[int64]bool)
+		for _, a := range d.accruals {
+			if a.AccrualDate >= from && a.AccrualDate <= to && a.PayoutOrderID == "" && a.Amount > 0 && !seen[a.WalletID] {
+				seen[a.WalletID] = true
+				walletIDs = append(walletIDs, a.WalletID)
+			}
+		}
+		sort.Slice(walletIDs, func(i, j int) bool { return walletIDs[i] < walletIDs[j] })
+		return nil
+	})
+	return walletIDs, err
+}
+
+func (r memInterest) GetUnpaidAmount(walletID int64, from int32, to int32) (int64, float64, error) {
+	var userID int64
+	var amount float64
+	err := r.with(false, func(d *memData) error {
+		for _, a := range d.accruals {
+			if a.WalletID == walletID && a.AccrualDate >= from && a.AccrualDate <= to && a.PayoutOrderID == "" {
+				userID = max(userID, a.UserID)
+				amount = addAmount(amount, a.Amount)
+			}
+		}
+		return nil
+	})
+	return userID, amount, err
+}
+
+func (r memInterest) MarkAccrualsPaid(walletID int64, from int32, to int32, orderID string) error {
+	return r.with(true, func(d *memData) error {
+		tn := time.Now().Unix()
+		for i, a := range d.accruals {
+			if a.WalletID == walletID && a.AccrualDate >= from && a.AccrualDate <= to && a.PayoutOrderID == "" {
+				d.accruals[i].PayoutOrderID = orderID
+				d.accruals[i].UpdatedAt = tn
+			}
+		}
+		return nil
+	})
+}
+
+type memShardTransfers struct{ *memRepos }
+
+func (r memShardTransfers) InsertShardTransfer(transfer *model.ShardTransfer) (int64, error) {
+	var transferID int64
+	err := r.with(true, func(d *memData) error {
+		if _, ok := d.shardTrans[transfer.OrderID]; ok {
+			return errMemUniqueViolation
+		}
+		tn := time.Now().Unix()
+		t := *transfer
+		t.ID = d.nextID("shard_transfers")
+		t.Status = data.ShardTransferStatusDebited
+		t.CreatedAt, t.UpdatedAt = tn, tn
+		d.shardTrans[t.OrderID] = t
+		transferID = t.ID
+		return nil
+	})
+	return transferID, err
+}
+
+func (r memShardTransfers) GetShardTransferByOrderID(orderID string) (*model.ShardTransfer, error) {
+	var transfer *model.ShardTransfer
+	err := r.with(false, func(d *memData) error {
+		if t, ok := d.shardTrans[orderID]; ok {
+			transfer = &t
+		}
+		return nil
+	})
+	return transfer, err
+}
+
+func (r memShardTransfers) GetDebitedShardTransferList(before int64, limit int32) ([]*model.ShardTransfer, error) {
+	transferList := make([]*model.ShardTransfer, 0)
+	err := r.with(false, func(d *memData) error {
+		for _, t := range d.shardTrans {
+			transfer := t
+			if transfer.Status == data.ShardTransferStatusDebited && transfer.CreatedAt <= before {
+				transferList = append(transferList, &transfer)
+			}
+		}
+		sort.Slice(transferList, func(i, j int) bool { return transferList[i].ID < transferList[j].ID })
+		if len(transferList) > int(limit) {
+			transferList = transferList[:limit]
+		}
+		return nil
+	})
+	return transferList, err
+}
+
+func (r memShardTransfers) MarkShardTransferCredited(orderID string) (bool, error) {
+	updated := false
+	err := r.with(true, func(d *memData) error {
+		t, ok := d.shardTrans[orderID]
+		if !ok || t.Status != data.ShardTransferStatusDebited {
+			return nil
+		}
+		t.Status, t.UpdatedAt = data.ShardTransferStatusCredited, time.Now().Unix()
+		d.shardTrans[orderID] = t
+		updated = true
+		return nil
+	})
+	return updated, err
+}
+
+func (r memShardTransfers) AddShardTransferAttempt(orderID string) (int32, error) {
+	var attempts int32
+	err := r.with(true, func(d *memData) error {
+		t, ok := d.shardTrans[orderID]
+		if !ok || t.Status != data.ShardTransferStatusDebited {
+			return nil
+		}
+		t.Attempts, t.UpdatedAt = t.Attempts+1, time.Now().Unix()
+		d.shardTrans[orderID] = t
+		attempts = t.Attempts
+		return nil
+	})
+	return attempts, err
+}
+
+func (r memShardTransfers) MarkShardTransferRefunded(orderID string) (bool, error) {
+	updated := false
+	err := r.with(true, func(d *memData) error {
+		t, ok := d.shardTrans[orderID]
+		if !ok || t.Status != data.ShardTransferStatusDebited {
+			return nil
+		}
+		t.Status, t.UpdatedAt = data.ShardTransferStatusRefunded, time.Now().Unix()
+		d.shardTrans[orderID] = t
+		updated = true
+		return nil
+	})
+	return updated, err
+}
+
+type memOutbox struct{ *memRepos }
+
+func (r memOutbox) InsertEvent(event *model.OutboxEvent) error {
+	return r.with(true, func(d *memData) error {
+		for _, e := range d.outbox {
+			if e.EventID == event.EventID {
+				return errMemUniqueViolation
+			}
+		}
+		tn := time.Now().Unix()
+		e := *event
+		e.ID = d.nextID("outbox")
+		e.Status, e.Attempts, e.LastError = data.OutboxStatusPending, 0, ""
+		e.CreatedAt, e.UpdatedAt = tn, tn
+		d.outbox = append(d.outbox, e)
+		return nil
+	})
+}
+
+func (r memOutbox) GetPendingEventList(limit int32) ([]*model.OutboxEvent, error) {
+	eventList := make([]*model.OutboxEvent, 0)
+	err := r.with(false, func(d *memData) error {
+		// kept in id order
+		for _, e := range d.outbox {
+			if len(eventList) == int(limit) {
+				break
+			}
+			if e.Status == data.OutboxStatusPending {
+				event := e
+				eventList = append(eventList, &event)
+			}
+		}
+		return nil
+	})
+	return eventList, err
+}
+
+func (r memOutbox) MarkEventSent(eventID int64) (bool, error) {
+	updated := false
+	err := r.with(true, func(d *memData) error {
+		for i := range d.outbox {
+			if d.outbox[i].ID == eventID && d.outbox[i].Status == data.OutboxStatusPending {
+				d.outbox[i].Status, d.outbox[i].UpdatedAt = data.OutboxStatusSent, time.Now().Unix()
+				updated = true
+			}
+		}
+		return nil
+	})
+	return updated, err
+}
+
+func (r memOutbox) MarkEventFailed(eventID int64, lastError string) error {
+	if len(lastError) > maxOutboxErrorLen {
+		lastError = lastError[:maxOutboxErrorLen]
+	}
+	return r.with(true, func(d *memData) error {
+		for i := range d.outbox {
+			if d.outbox[i].ID == eventID && d.outbox[i].Status == data.OutboxStatusPending {
+				d.outbox[i].Attempts++
+				d.outbox[i].LastError, d.outbox[i].UpdatedAt = lastError, time.Now().Unix()
+			}
+		}
+		return nil
+	})
+}
+
+type memWebhooks struct{ *memRepos }
+
+func (r memWebhooks) CreateSubscription(sub *model.WebhookSubscription) (int64, error) {
+	var subID int64
+	err := r.with(true, func(d *memData) error {
+		tn := time.Now().Unix()
+		s := *sub
+		s.ID = d.nextID("webhook_subscriptions")
+		s.CreatedAt, s.UpdatedAt = tn, tn
+		d.webhookSubs = append(d.webhookSubs, s)
+		subID = s.ID
+		return nil
+	})
+	return subID, err
+}
+
+func (r memWebhooks) GetSubscriptionByID(subID int64) (*model.WebhookSubscription, error) {
+	var found *model.WebhookSubscription
+	err := r.with(false, func(d *memData) error {
+		for _, s := range d.webhookSubs {
+			if s.ID == subID {
+				sub := s
+				found = &sub
+			}
+		}
+		return nil
+	})
+	return found, err
+}
+
+func (r memWebhooks) GetSubscriptionListByUserID(userID int64) ([]*model.WebhookSubscription, error) {
+	subList := make([]*model.WebhookSubscription, 0)
+	err := r.with(false, func(d *memData) error {
+		for _, s := range d.webhookSubs {
+			if s.UserID == userID {
+				sub := s
+				subList = append(subList, &sub)
+			}
+		}
+		return nil
+	})
+	return subList, err
+}
+
+func (r memWebhooks) InsertDelivery(delivery *model.WebhookDelivery) (bool, error) {
+	inserted := false
+	err := r.with(true, func(d *memData) error {
+		for _, e := range d.deliveries {
+			if e.SubscriptionID == delivery.SubscriptionID && e.EventID == delivery.EventID {
+				return nil
+			}
+		}
+		tn := time.Now().Unix()
+		e := *delivery
+		e.ID = d.nextID("webhook_deliveries")
+		e.Status, e.Attempts, e.LastStatusCode, e.LastError = data.WebhookDeliveryPending, 0, 0, ""
+		e.CreatedAt, e.UpdatedAt = tn, tn
+		d.deliveries = append(d.deliveries, e)
+		inserted = true
+		return nil
+	})
+	return inserted, err
+}
+
+func (r memWebhooks) GetDueDeliveryList(now int64, limit int32) ([]*model.WebhookDelivery, error) {
+	deliveryList := make([]*model.WebhookDelivery, 0)
+	err := r.with(false, func(d *memData) error {
+		for _, e := range d.deliveries {
+			if e.Status == data.WebhookDeliveryPending && e.NextAttemptAt <= now {
+				delivery := e
+				deliveryList = append(deliveryList, &delivery)
+			}
+		}
+		return nil
+	})
+	sort.SliceStable(deliveryList, func(i, j int) bool { return deliveryList[i].NextAttemptAt < deliveryList[j].NextAttemptAt })
+	if len(deliveryList) > int(limit) {
+		deliveryList = deliveryList[:limit]
+	}
+	return deliveryList, err
+}
+
+func (r memWebhooks) GetDeliveryList(userID int64, status int32, beforeID int64, limit int32) ([]*model.WebhookDelivery, error) {
+	deliveryList := make([]*model.WebhookDelivery, 0)
+	err := r.with(false, func(d *memData) error {
+		// kept in id order, listed newest first
+		for i := len(d.deliveries) - 1; i >= 0 && len(deliveryList) < int(limit); i-- {
+			e := d.deliveries[i]
+			if e.UserID == userID && (status < 0 || e.Status == status) && (beforeID <= 0 || e.ID < beforeID) {
+				deliveryList = append(deliveryList, &e)
+			}
+		}
+		return nil
+	})
+	return deliveryList, err
+}
+
+func (r memWebhooks) RecordDeliveryAttempt(deliveryID int64, status int32, statusCode int32, lastError string, nextAttemptAt int64) (bool, error) {
+	if len(lastError) > maxWebhookErrorLen {
+		lastError = lastError[:maxWebhookErrorLen]
+	}
+	updated := false
+	err := r.with(true, func(d *memData) error {
+		for i := range d.deliveries {
+			e := &d.deliveries[i]
+			if e.ID == deliveryID && e.Status == data.WebhookDeliveryPending {
+				e.Status, e.LastStatusCode, e.LastError, e.NextAttemptAt = status, statusCode, lastError, nextAttemptAt
+				e.Attempts++
+				e.UpdatedAt = time.Now().Unix()
+				updated = true
+			}
+		}
+		return nil
+	})
+	return updated, err
+}
+
+func (r memWebhooks) RedeliverDelivery(deliveryID int64, userID int64, now int64) (bool, error) {
+	updated := false
+	err := r.with(true, func(d *memData) error {
+		for i := range d.deliveries {
+			e := &d.deliveries[i]
+			if e.ID == deliveryID && e.UserID == userID {
+				e.Status, e.Attempts, e.NextAttemptAt, e.UpdatedAt = data.WebhookDeliveryPending, 0, now, time.Now().Unix()
+				updated = true
+			}
+		}
+		return nil
+	})
+	return updated, err
+}
+
+type memChains struct{ *memRepos }
+
+func (r memChains) GetChainHeadList(since int64, lastID int64, limit int32) ([]*ChainHead, error) {
+	heads := make([]*ChainHead, 0)
+	err := r.with(false, func(d *memData) error {
+		for _, w := range d.wallets {
+			if w.ID > lastID && w.UpdatedAt >= since {
+				heads = append(heads, &ChainHead{WalletID: w.ID, Hash: d.txHeads[w.ID]})
+			}
+		}
+		return nil
+	})
+	slices.SortFunc(heads, func(a, b *ChainHead) int { return cmp.Compare(a.WalletID, b.WalletID) })
+	if len(heads) > int(limit) {
+		heads = heads[:limit]
+	}
+	return heads, err
+}
+
+func (r memChains) GetChainLinkList(walletID int64, afterID int64, limit int32) ([]*model.Transactions, error) {
+	txList := make([]*model.Transactions, 0)
+	err := r.with(false, func(d *memData) error {
+		for _, t := range append(d.archive[:len(d.archive):len(d.archive)], d.transactions...) {
+			if t.WalletID == walletID && t.ID > afterID {
+				txList = append(txList, &t)
+			}
+		}
+		return nil
+	})
+	slices.SortFunc(txList, func(a, b *model.Transactions) int { return cmp.Compare(a.ID, b.ID) })
+	if len(txList) > int(limit) {
+		txList = txList[:limit]
+	}
+	return txList, err
+}
+
+type memAudit struct{ *memRepos }
+
+func (r memAudit) InsertAuditLog(entry *model.AdminAuditLog) (int64, error) {
+	var entryID int64
+	err := r.with(true, func(d *memData) error {
+		e := *entry
+		if len(e.Reason) > maxAuditReasonLen {
+			e.Reason = e.Reason[:maxAuditReasonLen]
+		}
+		e.ID = d.nextID("admin_audit_logs")
+		e.CreatedAt = time.Now().Unix()
+		d.auditLogs = append(d.auditLogs, e)
+		entryID = e.ID
+		return nil
+	})
+	return entryID, err
+}
+
+func (r memAudit) GetAuditLogList(filter *AuditFilter, before *AuditKey, limit int32) ([]*model.AdminAuditLog, error) {
+	entries := make([]*model.AdminAuditLog, 0)
+	err := r.with(false, func(d *memData) error {
+		for i := len(d.auditLogs) - 1; i >= 0; i-- {
+			e := d.auditLogs[i]
+			if (filter.Actor != "" && e.Actor != filter.Actor) || (filter.Action != "" && e.Action != filter.Action) ||
+				(filter.TargetType != "" && e.TargetType != filter.TargetType) || (filter.TargetID > 0 && e.TargetID != filter.TargetID) ||
+				(filter.StartTime > 0 && e.CreatedAt < filter.StartTime) || (filter.EndTime > 0 && e.CreatedAt >= filter.EndTime) {
+				continue
+			}
+			if before != nil && (e.CreatedAt > before.CreatedAt || (e.CreatedAt == before.CreatedAt && e.ID >= before.ID)) {
+				continue
+			}
+			entries = append(entries, &e)
+		}
+		return nil
+	})
+	slices.SortStableFunc(entries, func(a, b *model.AdminAuditLog) int {
+		return cmp.Or(cmp.Compare(b.CreatedAt, a.CreatedAt), cmp.Compare(b.ID, a.ID))
+	})
+	if len(entries) > int(limit) {
+		entries = entries[:limit]
+	}
+	return entries, err
+}
+
+type memAdjustments struct{ *memRepos }
+
+func (r memAdjustments) InsertAdjustment(adjustment *model.BalanceAdjustment) (int64, error) {
+	var adjustmentID int64
+	err := r.with(true, func(d *memData) error {
+		for _, a := range d.adjustments {
+			if a.OrderID == adjustment.OrderID {
+				return errMemUniqueViolation
+			}
+		}
+		tn := time.Now().Unix()
+		a := *adjustment
+		if len(a.Reason) > maxAdjustmentReasonLen {
+			a.Reason = a.Reason[:maxAdjustmentReasonLen]
+		}
+		a.ID = d.nextID("balance_adjustments")
+		a.Status, a.ReviewedBy = data.AdjustmentStatusPending, ""
+		a.CreatedAt, a.UpdatedAt = tn, tn
+		d.adjustments[a.ID] = a
+		adjustmentID = a.ID
+		return nil
+	})
+	return adjustmentID, err
+}
+
+func (r memAdjustments) GetAdjustmentByOrderID(orderID string) (*model.BalanceAdjustment, error) {
+	var adjustment *model.BalanceAdjustment
+	err := r.with(false, func(d *memData) error {
+		for _, a := range d.adjustments {
+			if a.OrderID == orderID {
+				adjustment = &a
+				return nil
+			}
+		}
+		return nil
+	})
+	return adjustment, err
+}
+
+func (r memAdjustments) ReviewAdjustment(adjustmentID int64, from int32, to int32, reviewedBy string) (bool, error) {
+	updated := false
+	err := r.with(true, func(d *memData) error {
+		a, ok := d.adjustments[adjustmentID]
+		if !ok || a.Status != from {
+			return nil
+		}
+		if a.CreatedBy == reviewedBy {
+			return errMemCheckViolation
+		}
+		a.Status, a.ReviewedBy, a.UpdatedAt = to, reviewedBy, time.Now().Unix()
+		d.adjustments[adjustmentID] = a
+		updated = true
+		return nil
+	})
+	return updated, err
+}
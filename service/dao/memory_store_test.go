@@ -0,0 +1,16 @@
+package dao_test
+
+import (
+	"simplewallet/service/dao"
+	"simplewallet/service/dao/daotest"
+	"testing"
+
+	"go.uber.org/goleak"
+)
+
+func TestMemoryStore(t *testing.T) {
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	daotest.RunConformance(t, func(t *testing.T) dao.Store {
+		return dao.NewMemoryStore()
+	})
+}
@@ -0,0 +1,75 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"simplewallet/model"
+	"time"
+)
+
+type OrgDao struct {
+	dbConn
+}
+
+func NewOrgDao(ctx context.Context, logID string, db DBTX) *OrgDao {
+	return &OrgDao{dbConn: dbConn{ctx: ctx, logID: logID, db: db}}
+}
+
+const orgMemberColumns = "id,org_id,user_id,role,spending_limit,created_at,updated_at"
+
+const orgColumns = "id,name,required_approvals,approval_expire_second,created_at,updated_at"
+
+// create an organization, returns the org id
+func (d *OrgDao) CreateOrg(org *model.Organization) (int64, error) {
+	tn := time.Now().Unix()
+	var orgID int64
+	err := d.execRow("INSERT INTO organizations (name, required_approvals, approval_expire_second, created_at, updated_at) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		org.Name, org.RequiredApprovals, org.ApprovalExpireSecond, tn, tn).Scan(&orgID)
+	if err != nil {
+		log.Printf("%s|[%s] Failed to create organization: %v", d.logID, org.Name, err)
+		return 0, err
+	}
+	return orgID, nil
+}
+
+func (d *OrgDao) GetOrgByID(orgID int64) (*model.Organization, error) {
+	org := &model.Organization{}
+	err := d.queryRow("SELECT "+orgColumns+" FROM organizations WHERE id = $1", orgID).
+		Scan(&org.ID, &org.Name, &org.RequiredApprovals, &org.ApprovalExpireSecond, &org.CreatedAt, &org.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		log.Printf("%s|[%d] Failed to get organization: %v", d.logID, orgID, err)
+		return nil, err
+	}
+	return org, nil
+}
+
+// get the membership of a user in an organization
+func (d *OrgDao) GetMember(orgID int64, userID int64) (*model.OrgMember, error) {
+	member := &model.OrgMember{}
+	err := d.queryRow("SELECT "+orgMemberColumns+" FROM org_members WHERE org_id = $1 AND user_id = $2", orgID, userID).Scan(&member.ID, &member.OrgID, &member.UserID, &member.Role, &member.SpendingLimit, &member.CreatedAt, &member.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		log.Printf("%s|[%d] Failed to get org member: %v", d.logID, orgID, err)
+		return nil, err
+	}
+	return member, nil
+}
+
+// add a member or update role and spending limit of an existing one
+func (d *OrgDao) SaveMember(member *model.OrgMember) error {
+	tn := time.Now().Unix()
+	_, err := d.exec("INSERT INTO org_members (org_id, user_id, role, spending_limit, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6) "+
+		"ON CONFLICT (org_id, user_id) DO UPDATE SET role = EXCLUDED.role, spending_limit = EXCLUDED.spending_limit, updated_at = EXCLUDED.updated_at",
+		member.OrgID, member.UserID, member.Role, member.SpendingLimit, tn, tn)
+	if err != nil {
+		log.Printf("%s|[%d] Failed to save org member: %v", d.logID, member.OrgID, err)
+	}
+	return err
+}
@@ -0,0 +1,88 @@
+package dao
+
+import (
+	"context"
+	"log"
+	"simplewallet/data"
+	"simplewallet/model"
+	"time"
+)
+
+type OutboxDao struct {
+	dbConn
+}
+
+func NewOutboxDao(ctx context.Context, logID string, db DBTX) *OutboxDao {
+	return &OutboxDao{dbConn: dbConn{ctx: ctx, logID: logID, db: db}}
+}
+
+const outboxColumns = "id,event_id,event_type,order_id,user_id,payload,status,attempts,last_error,created_at,updated_at"
+
+// maxOutboxErrorLen is the size of outbox.last_error
+const maxOutboxErrorLen = 255
+
+func scanOutboxEvent(row rowScanner, event *model.OutboxEvent) error {
+	return row.Scan(&event.ID, &event.EventID, &event.EventType, &event.OrderID, &event.UserID, &event.Payload,
+		&event.Status, &event.Attempts, &event.LastError, &event.CreatedAt, &event.UpdatedAt)
+}
+
+// record a pending event, in the unit of work of the balance change it tells about
+func (d *OutboxDao) InsertEvent(event *model.OutboxEvent) error {
+	tn := time.Now().Unix()
+	_, err := d.exec("INSERT INTO outbox (event_id, event_type, order_id, user_id, payload, status, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		event.EventID, event.EventType, event.OrderID, event.UserID, event.Payload, data.OutboxStatusPending, tn, tn)
+	if err != nil {
+		log.Printf("%s|[%s] Failed to insert outbox event: %v", d.logID, event.OrderID, err)
+	}
+	return err
+}
+
+// list the pending events, oldest first
+func (d *OutboxDao) GetPendingEventList(limit int32) ([]*model.OutboxEvent, error) {
+	rows, err := d.query("SELECT "+outboxColumns+" FROM outbox WHERE status = $1 ORDER BY id LIMIT $2", data.OutboxStatusPending, limit)
+	if err != nil {
+		log.Printf("%s|Failed to get pending outbox events: %v", d.logID, err)
+		return nil, err
+	}
+	defer rows.Close()
+	eventList := make([]*model.OutboxEvent, 0)
+	for rows.Next() {
+		event := &model.OutboxEvent{}
+		if err = scanOutboxEvent(rows, event); err != nil {
+			log.Printf("%s|Failed to scan outbox event: %v", d.logID, err)
+			return nil, err
+		}
+		eventList = append(eventList, event)
+	}
+	return eventList, rows.Err()
+}
+
+// mark a published event sent, returns false if it was not pending any more
+func (d *OutboxDao) MarkEventSent(eventID int64) (bool, error) {
+	tn := time.Now().Unix()
+	result, err := d.exec("UPDATE outbox SET status = $1, updated_at = $2 WHERE id = $3 AND status = $4",
+		data.OutboxStatusSent, tn, eventID, data.OutboxStatusPending)
+	if err != nil {
+		log.Printf("%s|[%d] Failed to mark outbox event sent: %v", d.logID, eventID, err)
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// count a failed publish of a pending event, it stays pending
+func (d *OutboxDao) MarkEventFailed(eventID int64, lastError string) error {
+	if len(lastError) > maxOutboxErrorLen {
+		lastError = lastError[:maxOutboxErrorLen]
+	}
+	tn := time.Now().Unix()
+	_, err := d.exec("UPDATE outbox SET attempts = attempts + 1, last_error = $1, updated_at = $2 WHERE id = $3 AND status = $4",
+		lastError, tn, eventID, data.OutboxStatusPending)
+	if err != nil {
+		log.Printf("%s|[%d] Failed to mark outbox event failed: %v", d.logID, eventID, err)
+	}
+	return err
+}
@@ -0,0 +1,122 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"simplewallet/data"
+	"simplewallet/model"
+	"time"
+)
+
+type ShardTransferDao struct {
+	dbConn
+}
+
+func NewShardTransferDao(ctx context.Context, logID string, db DBTX) *ShardTransferDao {
+	return &ShardTransferDao{dbConn: dbConn{ctx: ctx, logID: logID, db: db}}
+}
+
+const shardTransferColumns = "id,order_id,from_user_id,from_wallet_id,to_user_id,amount,status,attempts,created_at,updated_at"
+
+func scanShardTransfer(row rowScanner, transfer *model.ShardTransfer) error {
+	return row.Scan(&transfer.ID, &transfer.OrderID, &transfer.FromUserID, &transfer.FromWalletID, &transfer.ToUserID,
+		&transfer.Amount, &transfer.Status, &transfer.Attempts, &transfer.CreatedAt, &transfer.UpdatedAt)
+}
+
+// record a debited transfer whose credit is pending on the shard of the recipient
+func (d *ShardTransferDao) InsertShardTransfer(transfer *model.ShardTransfer) (int64, error) {
+	tn := time.Now().Unix()
+	var transferID int64
+	err := d.execRow("INSERT INTO shard_transfers (order_id, from_user_id, from_wallet_id, to_user_id, amount, status, created_at, updated_at) "+
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id",
+		transfer.OrderID, transfer.FromUserID, transfer.FromWalletID, transfer.ToUserID, transfer.Amount, data.ShardTransferStatusDebited, tn, tn).Scan(&transferID)
+	if err != nil {
+		log.Printf("%s|[%s] Failed to insert shard transfer: %v", d.logID, transfer.OrderID, err)
+		return 0, err
+	}
+	return transferID, nil
+}
+
+func (d *ShardTransferDao) GetShardTransferByOrderID(orderID string) (*model.ShardTransfer, error) {
+	transfer := &model.ShardTransfer{}
+	err := scanShardTransfer(d.queryRow("SELECT "+shardTransferColumns+" FROM shard_transfers WHERE order_id = $1", orderID), transfer)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		log.Printf("%s|[%s] Failed to get shard transfer by order id: %v", d.logID, orderID, err)
+		return nil, err
+	}
+	return transfer, nil
+}
+
+// list debited transfers created at or before the given time, oldest first
+func (d *ShardTransferDao) GetDebitedShardTransferList(before int64, limit int32) ([]*model.ShardTransfer, error) {
+	rows, err := d.query("SELECT "+shardTransferColumns+" FROM shard_transfers WHERE status = $1 AND created_at <= $2 ORDER BY id LIMIT $3",
+		data.ShardTransferStatusDebited, before, limit)
+	if err != nil {
+		log.Printf("%s|[%d] Failed to get debited shard transfer list: %v", d.logID, before, err)
+		return nil, err
+	}
+	defer rows.Close()
+	transferList := make([]*model.ShardTransfer, 0)
+	for rows.Next() {
+		transfer := &model.ShardTransfer{}
+		if err = scanShardTransfer(rows, transfer); err != nil {
+			log.Printf("%s|Failed to scan shard transfer: %v", d.logID, err)
+			return nil, err
+		}
+		transferList = append(transferList, transfer)
+	}
+	return transferList, rows.Err()
+}
+
+// mark a transfer credited on the shard of the recipient, returns false if it was not debited any more
+func (d *ShardTransferDao) MarkShardTransferCredited(orderID string) (bool, error) {
+	tn := time.Now().Unix()
+	result, err := d.exec("UPDATE shard_transfers SET status = $1, updated_at = $2 WHERE order_id = $3 AND status = $4",
+		data.ShardTransferStatusCredited, tn, orderID, data.ShardTransferStatusDebited)
+	if err != nil {
+		log.Printf("%s|[%s] Failed to mark shard transfer credited: %v", d.logID, orderID, err)
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// count a failed credit of a debited transfer, returns the failed credits so far, 0 if it was not debited any more
+func (d *ShardTransferDao) AddShardTransferAttempt(orderID string) (int32, error) {
+	tn := time.Now().Unix()
+	var attempts int32
+	err := d.execRow("UPDATE shard_transfers SET attempts = attempts + 1, updated_at = $1 WHERE order_id = $2 AND status = $3 RETURNING attempts",
+		tn, orderID, data.ShardTransferStatusDebited).Scan(&attempts)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		log.Printf("%s|[%s] Failed to count shard transfer attempt: %v", d.logID, orderID, err)
+		return 0, err
+	}
+	return attempts, nil
+}
+
+// mark a transfer refunded on the shard of the sender, returns false if it was not debited any more
+func (d *ShardTransferDao) MarkShardTransferRefunded(orderID string) (bool, error) {
+	tn := time.Now().Unix()
+	result, err := d.exec("UPDATE shard_transfers SET status = $1, updated_at = $2 WHERE order_id = $3 AND status = $4",
+		data.ShardTransferStatusRefunded, tn, orderID, data.ShardTransferStatusDebited)
+	if err != nil {
+		log.Printf("%s|[%s] Failed to mark shard transfer refunded: %v", d.logID, orderID, err)
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
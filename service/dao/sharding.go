@@ -0,0 +1,34 @@
+package dao
+
+// ShardedStore spreads the users over the stores of the shards: the wallets and transactions of a user
+// live on the shard of the user. Organizations, their shared wallets and approvals live on shard 0,
+// the shard of user 0, which owns the shared wallets.
+type ShardedStore struct {
+	shards  []Store
+	shardOf func(userID int64) int
+}
+
+// NewShardedStore returns the store over the shards, shardOf maps a user to the index of their shard.
+func NewShardedStore(shards []Store, shardOf func(userID int64) int) *ShardedStore {
+	return &ShardedStore{shards: shards, shardOf: shardOf}
+}
+
+// ShardOf returns the index of the shard of the user
+func (s *ShardedStore) ShardOf(userID int64) int {
+	return s.shardOf(userID)
+}
+
+// ForUser returns the store of the shard of the user
+func (s *ShardedStore) ForUser(userID int64) Store {
+	return s.shards[s.shardOf(userID)]
+}
+
+// Shard returns the store of the shard at the index
+func (s *ShardedStore) Shard(i int) Store {
+	return s.shards[i]
+}
+
+// Len returns the number of shards
+func (s *ShardedStore) Len() int {
+	return len(s.shards)
+}
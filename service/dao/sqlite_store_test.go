@@ -0,0 +1,30 @@
+package dao_test
+
+import (
+	"context"
+	"path/filepath"
+	"simplewallet/service/dao"
+	"simplewallet/service/dao/daotest"
+	"simplewallet/util/db"
+	"testing"
+)
+
+// TestSqliteStore runs the conformance suite on a new sqlite database file per case.
+func TestSqliteStore(t *testing.T) {
+	daotest.RunConformance(t, func(t *testing.T) dao.Store {
+		dbCli, err := db.OpenSqlite(filepath.Join(t.TempDir(), "wallet.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { dbCli.Close() })
+		migrator, err := db.NewMigrator(dbCli, db.DriverSqlite)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = migrator.Up(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dao.NewSqliteStore(context.Background(), "test", dbCli)
+	})
+}
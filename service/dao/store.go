@@ -0,0 +1,352 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"simplewallet/model"
+)
+
+// DBTX is what the daos run their queries on, the *sql.DB or a *sql.Tx
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// WalletRepo stores the wallets, one row per pocket of a user or an organization.
+type WalletRepo interface {
+	GetWalletByUserID(userID int64, name string) (*model.Wallet, error)
+	GetWalletListByUserID(userID int64) ([]*model.Wallet, error)
+	GetWalletByID(walletID int64) (*model.Wallet, error)
+	GetWalletByOrgID(orgID int64, name string) (*model.Wallet, error)
+	GetWalletListByOrgID(orgID int64) ([]*model.Wallet, error)
+	CreateOrgWallet(orgID int64, name string) (int64, error)
+	GetWalletListByProduct(product string, lastID int64, limit int32) ([]*model.Wallet, error)
+	CreateOrUpdateWallet(userID int64, name string, balance float64) (int64, error)
+	UpdateWalletBalance(walletID int64, txType int32, balance float64) error
+	LockWallet(walletID int64) error
+	HoldBalance(walletID int64, amount float64) error
+	ReleaseHold(walletID int64, amount float64) error
+	GetWalletLedgerList(lastID int64, limit int32) ([]*WalletLedger, error)
+	ResetWalletBalance(walletID int64, from float64, to float64) (bool, error)
+	UpdateWalletTerms(walletID int64, creditLimit float64, product string) error
+}
+
+// WalletLedger is a wallet with the sums of its transactions by tx_type, the archived ones included.
+// Both are read in one statement, a write committing meanwhile is either in the balance and the sums or in neither.
+type WalletLedger struct {
+	Wallet *model.Wallet
+	Sums   map[int32]float64
+}
+
+// TxKey is the position of a transaction in the history, ordered by (created_at, id).
+type TxKey struct {
+	CreatedAt int64
+	ID        int64
+}
+
+// TxFilter selects the transactions of a user, zero fields don't filter.
+type TxFilter struct {
+	UserID        int64
+	WalletID      int64   // one pocket only
+	TxTypes       []int32 // any of them
+	StartTime     int64   // created_at >= StartTime
+	EndTime       int64   // created_at < EndTime
+	MinAmount     float64 // amount >= MinAmount
+	MaxAmount     float64 // amount <= MaxAmount
+	RelatedUserID int64
+	OrderIDPrefix string
+	Archived      bool // transactions_archive too, the range reaches before the archive watermark
+}
+
+// TransactionsRepo stores the transactions, the ledger of every balance change.
+type TransactionsRepo interface {
+	GetTransactionByOrderID(orderID string) (*model.Transactions, error)
+	GetTransactionList(filter *TxFilter, page int32, limit int32) ([]*model.Transactions, error)
+	GetTransactionListByCursor(filter *TxFilter, key *TxKey, backward bool, limit int32) ([]*model.Transactions, error)
+	CountTransactions(filter *TxFilter) (int64, error)
+	GetAmountSumByTxType(walletID int64, before int64) (map[int32]float64, error)
+	GetDebitSumByActor(walletID int64, actorUserID int64, since int64) (float64, error)
+	InsertTransaction(tx *model.Transactions) error
+	CountTransactionsWithoutWallet() (int64, error)
+}
+
+// OrgRepo stores the organizations and their members.
+type OrgRepo interface {
+	CreateOrg(org *model.Organization) (int64, error)
+	GetOrgByID(orgID int64) (*model.Organization, error)
+	GetMember(orgID int64, userID int64) (*model.OrgMember, error)
+	SaveMember(member *model.OrgMember) error
+}
+
+// ApprovalRepo stores the transfers pending approval and the votes on them.
+type ApprovalRepo interface {
+	InsertApproval(approval *model.TransferApproval) (int64, error)
+	GetApprovalByOrderID(orderID string) (*model.TransferApproval, error)
+	GetPendingApprovalListByOrgID(orgID int64, limit int32) ([]*model.TransferApproval, error)
+	GetDueApprovalList(now int64, limit int32) ([]*model.TransferApproval, error)
+	UpdateApprovalStatus(approvalID int64, from int32, to int32) (bool, error)
+	InsertVote(approvalID int64, approverUserID int64) (bool, error)
+	GetVoteList(approvalID int64) ([]*model.ApprovalVote, error)
+}
+
+// InterestRepo stores the daily interest accruals.
+type InterestRepo interface {
+	InsertAccrual(accrual *model.InterestAccrual) (bool, error)
+	GetUnpaidWalletIDs(from int32, to int32) ([]int64, error)
+	GetUnpaidAmount(walletID int64, from int32, to int32) (int64, float64, error)
+	MarkAccrualsPaid(walletID int64, from int32, to int32, orderID string) error
+}
+
+// ShardTransferRepo stores the transfers to a user on another shard, on the shard of the sender.
+type ShardTransferRepo interface {
+	InsertShardTransfer(transfer *model.ShardTransfer) (int64, error)
+	GetShardTransferByOrderID(orderID string) (*model.ShardTransfer, error)
+	GetDebitedShardTransferList(before int64, limit int32) ([]*model.ShardTransfer, error)
+	MarkShardTransferCredited(orderID string) (bool, error)
+	AddShardTransferAttempt(orderID string) (int32, error)
+	MarkShardTransferRefunded(orderID string) (bool, error)
+}
+
+// ArchiveRepo moves the transactions older than the retention to transactions_archive.
+// On postgres transactions is partitioned by month, whole partitions are moved and the next ones created ahead.
+type ArchiveRepo interface {
+	GetArchivedBefore() (int64, error)
+	CreatePartitions(from int64, to int64) ([]string, error)
+	ArchiveTransactions(before int64) (int64, error)
+}
+
+// OutboxRepo stores the events of committed balance changes until the relay published them.
+type OutboxRepo interface {
+	InsertEvent(event *model.OutboxEvent) error
+	GetPendingEventList(limit int32) ([]*model.OutboxEvent, error)
+	MarkEventSent(eventID int64) (bool, error)
+	MarkEventFailed(eventID int64, lastError string) error
+}
+
+// WebhookRepo stores the webhook subscriptions of the users and the deliveries of wallet events to them.
+type WebhookRepo interface {
+	CreateSubscription(sub *model.WebhookSubscription) (int64, error)
+	GetSubscriptionByID(subID int64) (*model.WebhookSubscription, error)
+	GetSubscriptionListByUserID(userID int64) ([]*model.WebhookSubscription, error)
+	InsertDelivery(delivery *model.WebhookDelivery) (bool, error)
+	GetDueDeliveryList(now int64, limit int32) ([]*model.WebhookDelivery, error)
+	GetDeliveryList(userID int64, status int32, beforeID int64, limit int32) ([]*model.WebhookDelivery, error)
+	RecordDeliveryAttempt(deliveryID int64, status int32, statusCode int32, lastError string, nextAttemptAt int64) (bool, error)
+	RedeliverDelivery(deliveryID int64, userID int64, now int64) (bool, error)
+}
+
+// ChainRepo reads the hash chains of the transactions of every wallet, for their verification and anchoring.
+type ChainRepo interface {
+	GetChainHeadList(since int64, lastID int64, limit int32) ([]*ChainHead, error)
+	GetChainLinkList(walletID int64, afterID int64, limit int32) ([]*model.Transactions, error)
+}
+
+// ChainHead is the hash of the last transaction of a wallet, empty before its first one
+type ChainHead struct {
+	WalletID int64
+	Hash     string
+}
+
+// AuditRepo stores the audit trail of the non-customer actions, append-only.
+type AuditRepo interface {
+	InsertAuditLog(entry *model.AdminAuditLog) (int64, error)
+	GetAuditLogList(filter *AuditFilter, before *AuditKey, limit int32) ([]*model.AdminAuditLog, error)
+}
+
+// AuditFilter selects audit records, zero fields don't filter.
+type AuditFilter struct {
+	Actor      string
+	Action     string
+	TargetType string
+	TargetID   int64
+	StartTime  int64 // created_at >= StartTime
+	EndTime    int64 // created_at < EndTime
+}
+
+// AuditKey is the position of an audit record in the trail, ordered by (created_at, id)
+type AuditKey struct {
+	CreatedAt int64
+	ID        int64
+}
+
+// AdjustmentRepo stores the manual balance adjustments of the admins, waiting for review or reviewed.
+type AdjustmentRepo interface {
+	InsertAdjustment(adjustment *model.BalanceAdjustment) (int64, error)
+	GetAdjustmentByOrderID(orderID string) (*model.BalanceAdjustment, error)
+	ReviewAdjustment(adjustmentID int64, from int32, to int32, reviewedBy string) (bool, error)
+}
+
+// Repos gives the repositories working on the same connection or unit of work.
+type Repos interface {
+	Wallets() WalletRepo
+	Transactions() TransactionsRepo
+	Orgs() OrgRepo
+	Approvals() ApprovalRepo
+	Interest() InterestRepo
+	ShardTransfers() ShardTransferRepo
+	Archive() ArchiveRepo
+	Outbox() OutboxRepo
+	Webhooks() WebhookRepo
+	Chains() ChainRepo
+	Audit() AuditRepo
+	Adjustments() AdjustmentRepo
+}
+
+// UnitOfWork is one db transaction, the repositories it gives read and write inside it.
+// Nothing is visible to others before Commit, Rollback drops every write.
+type UnitOfWork interface {
+	Repos
+	Commit() error
+	Rollback() error
+}
+
+// Store is the storage backend of the wallet service.
+// Its own repositories read and write outside of any transaction.
+type Store interface {
+	Repos
+	Begin() (UnitOfWork, error)
+}
+
+// Dialect is the sql database the daos talk to, named like its database/sql driver
+type Dialect string
+
+const (
+	DialectPostgres Dialect = "postgres"
+	DialectSqlite   Dialect = "sqlite"
+)
+
+// roundAmount wraps an expression stored into a DECIMAL(15, 8) column. sqlite keeps decimals as
+// floating point, rounding to 8 places keeps the stored amounts and the check constraints exact.
+func (d Dialect) roundAmount(expr string) string {
+	if d == DialectSqlite {
+		return "ROUND(" + expr + ", 8)"
+	}
+	return expr
+}
+
+// sqlRepos binds the daos to one connection or transaction
+type sqlRepos struct {
+	conn    dbConn
+	dialect Dialect
+}
+
+func (r *sqlRepos) Wallets() WalletRepo {
+	return &WalletDao{dbConn: r.conn, dialect: r.dialect}
+}
+
+func (r *sqlRepos) Transactions() TransactionsRepo {
+	return &TransactionsDao{dbConn: r.conn}
+}
+
+func (r *sqlRepos) Orgs() OrgRepo {
+	return &OrgDao{dbConn: r.conn}
+}
+
+func (r *sqlRepos) Approvals() ApprovalRepo {
+	return &ApprovalDao{dbConn: r.conn}
+}
+
+func (r *sqlRepos) Interest() InterestRepo {
+	return &InterestDao{dbConn: r.conn}
+}
+
+func (r *sqlRepos) ShardTransfers() ShardTransferRepo {
+	return &ShardTransferDao{dbConn: r.conn}
+}
+
+func (r *sqlRepos) Archive() ArchiveRepo {
+	return &ArchiveDao{dbConn: r.conn, dialect: r.dialect}
+}
+
+func (r *sqlRepos) Outbox() OutboxRepo {
+	return &OutboxDao{dbConn: r.conn}
+}
+
+func (r *sqlRepos) Webhooks() WebhookRepo {
+	return &WebhookDao{dbConn: r.conn}
+}
+
+func (r *sqlRepos) Chains() ChainRepo {
+	return &ChainDao{dbConn: r.conn}
+}
+
+func (r *sqlRepos) Audit() AuditRepo {
+	return &AuditDao{dbConn: r.conn}
+}
+
+func (r *sqlRepos) Adjustments() AdjustmentRepo {
+	return &AdjustmentDao{dbConn: r.conn}
+}
+
+// SqlStore is the store on a sql database, postgres or sqlite.
+type SqlStore struct {
+	sqlRepos
+	dbCli     *sql.DB
+	isolation sql.IsolationLevel
+}
+
+// NewSqlStore returns the store on the db client opened with the given driver, postgres if empty.
+func NewSqlStore(ctx context.Context, logID string, dbCli *sql.DB, driver string) *SqlStore {
+	dialect := Dialect(driver)
+	if dialect == "" {
+		dialect = DialectPostgres
+	}
+	return &SqlStore{sqlRepos: sqlRepos{conn: dbConn{ctx: ctx, logID: logID, db: dbCli}, dialect: dialect}, dbCli: dbCli}
+}
+
+// WithTimeouts bounds every db operation of the store and its units of work.
+func (s *SqlStore) WithTimeouts(timeouts Timeouts) *SqlStore {
+	s.conn.timeouts = timeouts
+	return s
+}
+
+// WithIsolation begins the units of work of the store at the isolation level, the db default when
+// sql.LevelDefault. sqlite ignores it, its transactions are serializable.
+func (s *SqlStore) WithIsolation(isolation sql.IsolationLevel) *SqlStore {
+	s.isolation = isolation
+	return s
+}
+
+// NewPgStore returns the postgres store on the db client, logging with the logID of the request.
+func NewPgStore(ctx context.Context, logID string, dbCli *sql.DB) *SqlStore {
+	return NewSqlStore(ctx, logID, dbCli, string(DialectPostgres))
+}
+
+// NewSqliteStore returns the sqlite store on a db client from db.OpenSqlite.
+func NewSqliteStore(ctx context.Context, logID string, dbCli *sql.DB) *SqlStore {
+	return NewSqlStore(ctx, logID, dbCli, string(DialectSqlite))
+}
+
+// Begin starts a db transaction at the isolation level of the store, bounded by the Tx timeout:
+// the db rolls it back once it is over.
+// On sqlite the db client opens it with BEGIN IMMEDIATE, so the write lock is taken up front
+// and concurrent units of work queue on busy_timeout.
+func (s *SqlStore) Begin() (UnitOfWork, error) {
+	ctx, cancel := withTimeout(s.conn.ctx, s.conn.timeouts.Tx)
+	tx, err := s.dbCli.BeginTx(ctx, &sql.TxOptions{Isolation: s.isolation})
+	if err != nil {
+		err = ctxErr(ctx, err)
+		cancel()
+		return nil, err
+	}
+	conn := s.conn
+	conn.ctx, conn.db = ctx, tx
+	return &sqlUnitOfWork{sqlRepos: sqlRepos{conn: conn, dialect: s.dialect}, tx: tx, cancel: cancel}, nil
+}
+
+type sqlUnitOfWork struct {
+	sqlRepos
+	tx     *sql.Tx
+	cancel context.CancelFunc
+}
+
+func (u *sqlUnitOfWork) Commit() error {
+	defer u.cancel()
+	return ctxErr(u.conn.ctx, u.tx.Commit())
+}
+
+func (u *sqlUnitOfWork) Rollback() error {
+	defer u.cancel()
+	return u.tx.Rollback()
+}
@@ -0,0 +1,85 @@
+package dao_test
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"simplewallet/service/dao"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
+)
+
+func TestSqlStoreTimeouts(t *testing.T) {
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	timeouts := dao.Timeouts{Query: 20 * time.Millisecond, Exec: 20 * time.Millisecond, Tx: 50 * time.Millisecond}
+	walletColumns := []string{"id", "user_id", "org_id", "name", "balance", "credit_limit", "held", "product", "created_at", "updated_at"}
+
+	t.Run("case1: query fail-[out of time, deadline exceeded]", func(t *testing.T) {
+		dbCli, mock, err := sqlmock.New()
+		assert.Nil(t, err)
+		defer dbCli.Close()
+		store := dao.NewPgStore(context.Background(), "test", dbCli).WithTimeouts(timeouts)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE id = $1")).
+			WithArgs(1).WillDelayFor(time.Second).WillReturnRows(sqlmock.NewRows(walletColumns))
+		wallet, err := store.Wallets().GetWalletByID(1)
+		assert.Nil(t, wallet)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+	})
+
+	t.Run("case2: exec fail-[out of time, deadline exceeded]", func(t *testing.T) {
+		dbCli, mock, err := sqlmock.New()
+		assert.Nil(t, err)
+		defer dbCli.Close()
+		store := dao.NewPgStore(context.Background(), "test", dbCli).WithTimeouts(timeouts)
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET held = wallets.held + $1")).WillDelayFor(time.Second).WillReturnResult(sqlmock.NewResult(0, 1))
+		err = store.Wallets().HoldBalance(1, 10)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+	})
+
+	t.Run("case3: query success-[within time]", func(t *testing.T) {
+		dbCli, mock, err := sqlmock.New()
+		assert.Nil(t, err)
+		defer dbCli.Close()
+		store := dao.NewPgStore(context.Background(), "test", dbCli).WithTimeouts(timeouts)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 ORDER BY id")).
+			WithArgs(101).WillReturnRows(sqlmock.NewRows(walletColumns).AddRow(1, 101, 0, "main", 10, 0, 0, "", 0, 0).AddRow(2, 101, 0, "savings", 5, 0, 0, "", 0, 0))
+		walletList, err := store.Wallets().GetWalletListByUserID(101)
+		assert.Nil(t, err)
+		assert.Len(t, walletList, 2)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("case4: commit fail-[unit of work out of time, rolled back]", func(t *testing.T) {
+		dbCli, mock, err := sqlmock.New()
+		assert.Nil(t, err)
+		defer dbCli.Close()
+		store := dao.NewPgStore(context.Background(), "test", dbCli).WithTimeouts(timeouts)
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET held = wallets.held + $1")).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectRollback()
+		tx, err := store.Begin()
+		assert.Nil(t, err)
+		assert.Nil(t, tx.Wallets().HoldBalance(1, 10))
+		time.Sleep(2 * timeouts.Tx)
+		err = tx.Commit()
+		assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("case5: query fail-[request cancelled]", func(t *testing.T) {
+		dbCli, mock, err := sqlmock.New()
+		assert.Nil(t, err)
+		defer dbCli.Close()
+		ctx, cancel := context.WithCancel(context.Background())
+		store := dao.NewPgStore(ctx, "test", dbCli).WithTimeouts(timeouts)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE id = $1")).
+			WithArgs(1).WillReturnRows(sqlmock.NewRows(walletColumns))
+		cancel()
+		_, err = store.Wallets().GetWalletByID(1)
+		assert.True(t, errors.Is(err, context.Canceled), err)
+	})
+}
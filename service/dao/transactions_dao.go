@@ -2,26 +2,62 @@ package dao
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"log"
+	"simplewallet/data"
 	"simplewallet/model"
+	"slices"
+	"strings"
 	"time"
+	"unicode/utf8"
+
+	"github.com/shopspring/decimal"
 )
 
 type TransactionsDao struct {
-	ctx   context.Context
-	logID string
+	dbConn
+}
+
+func NewTransactionsDao(ctx context.Context, logID string, db DBTX) *TransactionsDao {
+	return &TransactionsDao{dbConn: dbConn{ctx: ctx, logID: logID, db: db}}
+}
+
+// ErrOrderIDPending is returned by InsertTransaction when a transfer waiting for approvals holds the order_id,
+// the schema reserves it until the transfer executes
+var ErrOrderIDPending = errors.New("order_id is taken by a transfer waiting for approvals")
+
+const (
+	transactionColumns = "id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at"
+	// chainColumns adds the hash chain, read by the chain verification and carried to the archive
+	chainColumns = transactionColumns + ",prev_hash,hash"
+)
+
+func scanTransaction(row rowScanner, tx *model.Transactions) error {
+	return row.Scan(&tx.ID, &tx.OrderID, &tx.UserID, &tx.WalletID, &tx.TxType, &tx.Amount, &tx.RelatedUserID, &tx.ActorUserID, &tx.CreatedAt, &tx.UpdatedAt)
 }
 
-func NewTransactionsDao(ctx context.Context, logID string) *TransactionsDao {
-	return &TransactionsDao{ctx: ctx, logID: logID}
+func scanChainLink(row rowScanner, tx *model.Transactions) error {
+	return row.Scan(&tx.ID, &tx.OrderID, &tx.UserID, &tx.WalletID, &tx.TxType, &tx.Amount, &tx.RelatedUserID, &tx.ActorUserID, &tx.CreatedAt, &tx.UpdatedAt,
+		&tx.PrevHash, &tx.Hash)
 }
 
-func (d *TransactionsDao) GetTransactionByOrderID(dbTx *sql.Tx, orderID string) (*model.Transactions, error) {
+// TxHash is the hash of a transaction chained to the one before it on the wallet: the sha256 of its prev_hash
+// and of every column written once, hex encoded. updated_at is left out, and the id, which a restore may change.
+func TxHash(tx *model.Transactions) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%q|%d|%d|%d|%s|%d|%d|%d", tx.PrevHash, tx.OrderID, tx.UserID, tx.WalletID, tx.TxType,
+		decimal.NewFromFloat(tx.Amount).StringFixed(8), tx.RelatedUserID, tx.ActorUserID, tx.CreatedAt)))
+	return hex.EncodeToString(sum[:])
+}
+
+// get a transaction of the order, archived ones too so an old order_id is never used again
+func (d *TransactionsDao) GetTransactionByOrderID(orderID string) (*model.Transactions, error) {
 	tx := &model.Transactions{}
-	err := dbTx.QueryRow("SELECT id,order_id,user_id,tx_type,amount,related_user_id,created_at,updated_at FROM transactions WHERE order_id = $1", orderID).
-		Scan(&tx.ID, &tx.OrderID, &tx.UserID, &tx.TxType, &tx.Amount, &tx.RelatedUserID, &tx.CreatedAt, &tx.UpdatedAt)
+	err := scanTransaction(d.queryRow("SELECT "+transactionColumns+" FROM transactions WHERE order_id = $1 "+
+		"UNION ALL SELECT "+transactionColumns+" FROM transactions_archive WHERE order_id = $1 LIMIT 1", orderID), tx)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -32,21 +68,68 @@ func (d *TransactionsDao) GetTransactionByOrderID(dbTx *sql.Tx, orderID string)
 	return tx, nil
 }
 
-func (d *TransactionsDao) GetTransactionListByUserID(db *sql.DB, userID int64, page int32, limit int32) ([]*model.Transactions, error) {
+// where returns the conditions of the filter and their args, numbered after the given args
+func (f *TxFilter) where(args []any) (string, []any) {
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	conds := []string{"user_id = " + arg(f.UserID)}
+	if f.WalletID > 0 {
+		conds = append(conds, "wallet_id = "+arg(f.WalletID))
+	}
+	if len(f.TxTypes) > 0 {
+		in := make([]string, 0, len(f.TxTypes))
+		for _, txType := range f.TxTypes {
+			in = append(in, arg(txType))
+		}
+		conds = append(conds, "tx_type IN ("+strings.Join(in, ", ")+")")
+	}
+	if f.StartTime > 0 {
+		conds = append(conds, "created_at >= "+arg(f.StartTime))
+	}
+	if f.EndTime > 0 {
+		conds = append(conds, "created_at < "+arg(f.EndTime))
+	}
+	if f.MinAmount > 0 {
+		conds = append(conds, "amount >= "+arg(f.MinAmount))
+	}
+	if f.MaxAmount > 0 {
+		conds = append(conds, "amount <= "+arg(f.MaxAmount))
+	}
+	if f.RelatedUserID > 0 {
+		conds = append(conds, "related_user_id = "+arg(f.RelatedUserID))
+	}
+	if f.OrderIDPrefix != "" {
+		// not LIKE, whose wildcards would need escaping and which ignores case on sqlite
+		conds = append(conds, "SUBSTR(order_id, 1, "+arg(utf8.RuneCountInString(f.OrderIDPrefix))+") = "+arg(f.OrderIDPrefix))
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// from returns the rows the conditions select from: transactions, or with the archive the union of both tables
+func (f *TxFilter) from(where string) string {
+	if !f.Archived {
+		return "transactions WHERE " + where
+	}
+	return "(SELECT " + transactionColumns + " FROM transactions WHERE " + where +
+		" UNION ALL SELECT " + transactionColumns + " FROM transactions_archive WHERE " + where + ") t"
+}
+
+func (d *TransactionsDao) queryTransactionList(query string, args []any, userID int64) ([]*model.Transactions, error) {
 	txList := make([]*model.Transactions, 0)
-	offset := (page - 1) * limit
-	rows, err := db.Query("SELECT id, order_id, user_id, tx_type, amount, related_user_id, created_at, updated_at FROM transactions WHERE user_id = $1 LIMIT $2 OFFSET $3", userID, limit, offset)
+	rows, err := d.query(query, args...)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
-		log.Printf("%s|[%d] Failed to get transaction list by user_id: %v", d.logID, userID, err)
+		log.Printf("%s|[%d] Failed to get transaction list: %v", d.logID, userID, err)
 		return nil, err
 	}
 	defer rows.Close()
 	for rows.Next() {
 		tx := &model.Transactions{}
-		if err = rows.Scan(&tx.ID, &tx.OrderID, &tx.UserID, &tx.TxType, &tx.Amount, &tx.RelatedUserID, &tx.CreatedAt, &tx.UpdatedAt); err != nil {
+		if err = scanTransaction(rows, tx); err != nil {
 			log.Printf("%s|[%d] Failed to scan transaction: %v", d.logID, userID, err)
 			return nil, err
 		}
@@ -55,9 +138,118 @@ func (d *TransactionsDao) GetTransactionListByUserID(db *sql.DB, userID int64, p
 	return txList, nil
 }
 
-func (d *TransactionsDao) InsertTransaction(dbTx *sql.Tx, tx *model.Transactions) error {
+// list the transactions matching the filter ordered by (created_at, id), page by page
+func (d *TransactionsDao) GetTransactionList(filter *TxFilter, page int32, limit int32) ([]*model.Transactions, error) {
+	where, args := filter.where(nil)
+	args = append(args, limit, (page-1)*limit)
+	query := fmt.Sprintf("SELECT "+transactionColumns+" FROM %s ORDER BY created_at, id LIMIT $%d OFFSET $%d", filter.from(where), len(args)-1, len(args))
+	return d.queryTransactionList(query, args, filter.UserID)
+}
+
+// list the transactions matching the filter ordered by (created_at, id), the ones after the key, or before it when backward.
+// A nil key starts from the oldest, or the newest when backward. The list is always in ascending order.
+func (d *TransactionsDao) GetTransactionListByCursor(filter *TxFilter, key *TxKey, backward bool, limit int32) ([]*model.Transactions, error) {
+	where, args := filter.where(nil)
+	cmp, order := ">", "created_at, id"
+	if backward {
+		cmp, order = "<", "created_at DESC, id DESC"
+	}
+	if key != nil {
+		args = append(args, key.CreatedAt, key.ID)
+		where += fmt.Sprintf(" AND (created_at, id) %s ($%d, $%d)", cmp, len(args)-1, len(args))
+	}
+	args = append(args, limit)
+	query := fmt.Sprintf("SELECT "+transactionColumns+" FROM %s ORDER BY %s LIMIT $%d", filter.from(where), order, len(args))
+	txList, err := d.queryTransactionList(query, args, filter.UserID)
+	if backward {
+		slices.Reverse(txList)
+	}
+	return txList, err
+}
+
+// count the transactions matching the filter
+func (d *TransactionsDao) CountTransactions(filter *TxFilter) (int64, error) {
+	where, args := filter.where(nil)
+	var total int64
+	err := d.queryRow("SELECT COUNT(*) FROM "+filter.from(where), args...).Scan(&total)
+	if err != nil {
+		log.Printf("%s|[%d] Failed to count transactions: %v", d.logID, filter.UserID, err)
+		return 0, err
+	}
+	return total, nil
+}
+
+// count the transactions recorded before they had a wallet_id, the archived ones included. The migrations
+// backfill them, the ones left belong to a user without a main pocket and are in no ledger.
+func (d *TransactionsDao) CountTransactionsWithoutWallet() (int64, error) {
+	var total int64
+	err := d.queryRow("SELECT (SELECT COUNT(*) FROM transactions WHERE wallet_id = 0) + (SELECT COUNT(*) FROM transactions_archive WHERE wallet_id = 0)").Scan(&total)
+	if err != nil {
+		log.Printf("%s|Failed to count transactions without wallet: %v", d.logID, err)
+		return 0, err
+	}
+	return total, nil
+}
+
+// sum the amounts of a wallet before the given time by tx_type, the archived transactions included
+func (d *TransactionsDao) GetAmountSumByTxType(walletID int64, before int64) (map[int32]float64, error) {
+	sums := make(map[int32]float64)
+	rows, err := d.query("SELECT tx_type, COALESCE(SUM(amount), 0) FROM transactions WHERE wallet_id = $1 AND created_at < $2 GROUP BY tx_type "+
+		"UNION ALL SELECT tx_type, COALESCE(SUM(amount), 0) FROM transactions_archive WHERE wallet_id = $1 AND created_at < $2 GROUP BY tx_type", walletID, before)
+	if err != nil {
+		log.Printf("%s|[%d] Failed to sum transactions by tx_type: %v", d.logID, walletID, err)
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var txType int32
+		var amount float64
+		if err = rows.Scan(&txType, &amount); err != nil {
+			log.Printf("%s|[%d] Failed to scan transaction sum: %v", d.logID, walletID, err)
+			return nil, err
+		}
+		sums[txType] = addAmount(sums[txType], amount)
+	}
+	return sums, rows.Err()
+}
+
+// sum what the acting user debited from a wallet since the given time, pocket moves excluded.
+// The time is recent, never before the archive watermark.
+func (d *TransactionsDao) GetDebitSumByActor(walletID int64, actorUserID int64, since int64) (float64, error) {
+	var amount float64
+	err := d.queryRow("SELECT COALESCE(SUM(amount), 0) FROM transactions WHERE wallet_id = $1 AND actor_user_id = $2 AND tx_type IN ($3, $4) AND created_at >= $5",
+		walletID, actorUserID, data.TxTypeWithdraw, data.TxTypeTransferOut, since).Scan(&amount)
+	if err != nil {
+		log.Printf("%s|[%d] Failed to sum debits by actor: %v", d.logID, walletID, err)
+		return 0, err
+	}
+	return amount, nil
+}
+
+// insert a transaction chained to the last one of its wallet. The head of the chain is read with the wallet row
+// locked, so the transactions of a wallet chain in id order; run it in a unit of work.
+func (d *TransactionsDao) InsertTransaction(tx *model.Transactions) error {
 	tn := time.Now().Unix()
-	_, err := dbTx.Exec("INSERT INTO transactions (order_id, user_id, tx_type, amount, related_user_id, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7)",
-		tx.OrderID, tx.UserID, tx.TxType, tx.Amount, tx.RelatedUserID, tn, tn)
+	trans := *tx
+	trans.CreatedAt, trans.UpdatedAt = tn, tn
+	err := d.execRow("UPDATE wallets SET tx_hash = tx_hash WHERE id = $1 RETURNING tx_hash", tx.WalletID).Scan(&trans.PrevHash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("%s|[%d] Failed to lock the chain head: %v", d.logID, tx.WalletID, err)
+		return err
+	}
+	trans.Hash = TxHash(&trans)
+	_, err = d.exec("INSERT INTO transactions (order_id, user_id, wallet_id, tx_type, amount, related_user_id, actor_user_id, created_at, updated_at, prev_hash, hash) "+
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)",
+		trans.OrderID, trans.UserID, trans.WalletID, trans.TxType, trans.Amount, trans.RelatedUserID, trans.ActorUserID, tn, tn, trans.PrevHash, trans.Hash)
+	if err != nil && strings.Contains(err.Error(), ErrOrderIDPending.Error()) {
+		return ErrOrderIDPending
+	}
+	if err != nil {
+		return err
+	}
+	_, err = d.exec("UPDATE wallets SET tx_hash = $1, updated_at = $2 WHERE id = $3", trans.Hash, tn, trans.WalletID)
+	if err != nil {
+		log.Printf("%s|[%d] Failed to move the chain head: %v", d.logID, tx.WalletID, err)
+	}
 	return err
 }
@@ -0,0 +1,99 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// RetryPolicy bounds the retries of a unit of work failing on a serialization failure or a deadlock.
+type RetryPolicy struct {
+	MaxRetries  int           // retries after the first attempt, 0 runs it once
+	BaseBackoff time.Duration // wait before the first retry, doubled on every retry
+	MaxBackoff  time.Duration // cap of the wait, 0 leaves it uncapped
+}
+
+const (
+	// postgres aborts one of the concurrent transactions, running it again usually succeeds
+	pqSerializationFailure pq.ErrorCode = "40001"
+	pqDeadlockDetected     pq.ErrorCode = "40P01"
+)
+
+// IsRetryable reports whether err aborted the db transaction only because of concurrent ones.
+func IsRetryable(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == pqSerializationFailure || pqErr.Code == pqDeadlockDetected
+	}
+	return false
+}
+
+// TxRunner runs a closure in a unit of work of the store: it begins, rolls back when the closure
+// fails and commits otherwise. A retryable failure of the closure or of the commit runs the whole
+// closure again in a new unit of work, after a backoff, at most MaxRetries times.
+type TxRunner struct {
+	ctx   context.Context
+	logID string
+	store Store
+	retry RetryPolicy
+}
+
+func NewTxRunner(ctx context.Context, logID string, store Store, retry RetryPolicy) *TxRunner {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &TxRunner{ctx: ctx, logID: logID, store: store, retry: retry}
+}
+
+// Run returns the error of the last attempt. The closure may run more than once,
+// it must not keep anything from a failed attempt.
+func (r *TxRunner) Run(fn func(tx UnitOfWork) error) error {
+	for attempt := 0; ; attempt++ {
+		err := r.runOnce(fn)
+		if err == nil || !IsRetryable(err) || attempt >= r.retry.MaxRetries {
+			return err
+		}
+		backoff := r.backoff(attempt)
+		log.Printf("%s|[%d] Retrying db transaction in %v: %v", r.logID, attempt+1, backoff, err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-r.ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+func (r *TxRunner) runOnce(fn func(tx UnitOfWork) error) error {
+	tx, err := r.store.Begin()
+	if err != nil {
+		log.Printf("%s|[0] Failed to begin transaction: %v", r.logID, err)
+		return err
+	}
+	if err = fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		log.Printf("%s|[0] Failed to commit transaction: %v", r.logID, err)
+		return err
+	}
+	return nil
+}
+
+// backoff is exponential with full jitter, so retries of transactions aborted together spread out
+func (r *TxRunner) backoff(attempt int) time.Duration {
+	backoff := r.retry.BaseBackoff << attempt
+	if backoff <= 0 || (r.retry.MaxBackoff > 0 && backoff > r.retry.MaxBackoff) {
+		backoff = r.retry.MaxBackoff
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(backoff))) + 1
+}
@@ -0,0 +1,116 @@
+package dao_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"simplewallet/service/dao"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
+)
+
+func TestTxRunner(t *testing.T) {
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	retry := dao.RetryPolicy{MaxRetries: 2, BaseBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+	holdSql := regexp.QuoteMeta("UPDATE wallets SET held = wallets.held + $1")
+	serializationFailure := &pq.Error{Code: "40001", Message: "could not serialize access due to concurrent update"}
+	deadlock := &pq.Error{Code: "40P01", Message: "deadlock detected"}
+	hold := func(tx dao.UnitOfWork) error {
+		return tx.Wallets().HoldBalance(1, 10)
+	}
+
+	t.Run("case1: run success-[retryable errors]", func(t *testing.T) {
+		assert.True(t, dao.IsRetryable(serializationFailure))
+		assert.True(t, dao.IsRetryable(deadlock))
+		assert.True(t, dao.IsRetryable(fmt.Errorf("update balance: %w", deadlock)))
+		assert.False(t, dao.IsRetryable(&pq.Error{Code: "23505"})) // unique violation
+		assert.False(t, dao.IsRetryable(context.DeadlineExceeded))
+		assert.False(t, dao.IsRetryable(nil))
+	})
+
+	t.Run("case2: run success-[serialization failure and deadlock retried]", func(t *testing.T) {
+		dbCli, mock, err := sqlmock.New()
+		assert.Nil(t, err)
+		defer dbCli.Close()
+		store := dao.NewPgStore(context.Background(), "test", dbCli).WithIsolation(sql.LevelSerializable)
+		mock.ExpectBegin()
+		mock.ExpectExec(holdSql).WillReturnError(serializationFailure)
+		mock.ExpectRollback()
+		mock.ExpectBegin()
+		mock.ExpectExec(holdSql).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit().WillReturnError(deadlock)
+		mock.ExpectBegin()
+		mock.ExpectExec(holdSql).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		attempts := 0
+		err = dao.NewTxRunner(context.Background(), "test", store, retry).Run(func(tx dao.UnitOfWork) error {
+			attempts++
+			return hold(tx)
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, 3, attempts)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("case3: run fail-[retries exhausted]", func(t *testing.T) {
+		dbCli, mock, err := sqlmock.New()
+		assert.Nil(t, err)
+		defer dbCli.Close()
+		store := dao.NewPgStore(context.Background(), "test", dbCli)
+		for i := 0; i <= retry.MaxRetries; i++ {
+			mock.ExpectBegin()
+			mock.ExpectExec(holdSql).WillReturnError(serializationFailure)
+			mock.ExpectRollback()
+		}
+
+		err = dao.NewTxRunner(context.Background(), "test", store, retry).Run(hold)
+		assert.True(t, errors.Is(err, serializationFailure), err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("case4: run fail-[other errors not retried]", func(t *testing.T) {
+		dbCli, mock, err := sqlmock.New()
+		assert.Nil(t, err)
+		defer dbCli.Close()
+		store := dao.NewPgStore(context.Background(), "test", dbCli)
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+
+		errBalance := errors.New("balance not enough")
+		attempts := 0
+		err = dao.NewTxRunner(context.Background(), "test", store, retry).Run(func(tx dao.UnitOfWork) error {
+			attempts++
+			return errBalance
+		})
+		assert.Equal(t, errBalance, err)
+		assert.Equal(t, 1, attempts)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("case5: run fail-[request cancelled during backoff]", func(t *testing.T) {
+		dbCli, mock, err := sqlmock.New()
+		assert.Nil(t, err)
+		defer dbCli.Close()
+		ctx, cancel := context.WithCancel(context.Background())
+		store := dao.NewPgStore(ctx, "test", dbCli)
+		mock.ExpectBegin()
+		mock.ExpectExec(holdSql).WillReturnError(deadlock)
+		mock.ExpectRollback()
+
+		err = dao.NewTxRunner(ctx, "test", store, dao.RetryPolicy{MaxRetries: 5, BaseBackoff: time.Hour}).Run(func(tx dao.UnitOfWork) error {
+			err := hold(tx)
+			cancel()
+			return err
+		})
+		assert.True(t, errors.Is(err, deadlock), err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
@@ -11,24 +11,28 @@ import (
 )
 
 type WalletDao struct {
-	ctx   context.Context
-	logID string
+	dbConn
+	dialect Dialect
 }
 
-func NewWalletDao(ctx context.Context, logID string) *WalletDao {
-	return &WalletDao{ctx: ctx, logID: logID}
+func NewWalletDao(ctx context.Context, logID string, db DBTX, dialect Dialect) *WalletDao {
+	return &WalletDao{dbConn: dbConn{ctx: ctx, logID: logID, db: db}, dialect: dialect}
 }
 
-func (d *WalletDao) GetWalletByUserID(db *sql.DB, dbTx *sql.Tx, userID int64) (*model.Wallet, error) {
+const walletColumns = "id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at"
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanWallet(row rowScanner, wallet *model.Wallet) error {
+	return row.Scan(&wallet.ID, &wallet.UserID, &wallet.OrgID, &wallet.Name, &wallet.Balance, &wallet.CreditLimit, &wallet.Held, &wallet.Product, &wallet.CreatedAt, &wallet.UpdatedAt)
+}
+
+// get the pocket of a user by name
+func (d *WalletDao) GetWalletByUserID(userID int64, name string) (*model.Wallet, error) {
 	wallet := &model.Wallet{}
-	querySql := "SELECT id,user_id,balance,created_at,updated_at FROM wallets WHERE user_id = $1"
-	var sqlRow *sql.Row
-	if dbTx != nil {
-		sqlRow = dbTx.QueryRow(querySql, userID)
-	} else {
-		sqlRow = db.QueryRow(querySql, userID)
-	}
-	err := sqlRow.Scan(&wallet.ID, &wallet.UserID, &wallet.Balance, &wallet.CreatedAt, &wallet.UpdatedAt)
+	err := scanWallet(d.queryRow("SELECT "+walletColumns+" FROM wallets WHERE user_id = $1 AND name = $2", userID, name), wallet)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -39,34 +43,225 @@ func (d *WalletDao) GetWalletByUserID(db *sql.DB, dbTx *sql.Tx, userID int64) (*
 	return wallet, nil
 }
 
-// create or update
-func (d *WalletDao) CreateOrUpdateWallet(dbTx *sql.Tx, userID int64, balance float64) error {
+// list all pockets of a user
+func (d *WalletDao) GetWalletListByUserID(userID int64) ([]*model.Wallet, error) {
+	walletList := make([]*model.Wallet, 0)
+	rows, err := d.query("SELECT "+walletColumns+" FROM wallets WHERE user_id = $1 ORDER BY id", userID)
+	if err != nil {
+		log.Printf("%s|[%d] Failed to get wallet list by user id: %v", d.logID, userID, err)
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		wallet := &model.Wallet{}
+		if err = scanWallet(rows, wallet); err != nil {
+			log.Printf("%s|[%d] Failed to scan wallet: %v", d.logID, userID, err)
+			return nil, err
+		}
+		walletList = append(walletList, wallet)
+	}
+	return walletList, rows.Err()
+}
+
+func (d *WalletDao) GetWalletByID(walletID int64) (*model.Wallet, error) {
+	wallet := &model.Wallet{}
+	err := scanWallet(d.queryRow("SELECT "+walletColumns+" FROM wallets WHERE id = $1", walletID), wallet)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		log.Printf("%s|[%d] Failed to get wallet by id: %v", d.logID, walletID, err)
+		return nil, err
+	}
+	return wallet, nil
+}
+
+// get the shared pocket of an organization by name
+func (d *WalletDao) GetWalletByOrgID(orgID int64, name string) (*model.Wallet, error) {
+	wallet := &model.Wallet{}
+	err := scanWallet(d.queryRow("SELECT "+walletColumns+" FROM wallets WHERE org_id = $1 AND user_id = 0 AND name = $2", orgID, name), wallet)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		log.Printf("%s|[%d] Failed to get wallet by org id: %v", d.logID, orgID, err)
+		return nil, err
+	}
+	return wallet, nil
+}
+
+// list all shared pockets of an organization
+func (d *WalletDao) GetWalletListByOrgID(orgID int64) ([]*model.Wallet, error) {
+	walletList := make([]*model.Wallet, 0)
+	rows, err := d.query("SELECT "+walletColumns+" FROM wallets WHERE org_id = $1 AND user_id = 0 ORDER BY id", orgID)
+	if err != nil {
+		log.Printf("%s|[%d] Failed to get wallet list by org id: %v", d.logID, orgID, err)
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		wallet := &model.Wallet{}
+		if err = scanWallet(rows, wallet); err != nil {
+			log.Printf("%s|[%d] Failed to scan wallet: %v", d.logID, orgID, err)
+			return nil, err
+		}
+		walletList = append(walletList, wallet)
+	}
+	return walletList, rows.Err()
+}
+
+// create an empty shared pocket of an organization, returns the wallet id
+func (d *WalletDao) CreateOrgWallet(orgID int64, name string) (int64, error) {
+	tn := time.Now().Unix()
+	var walletID int64
+	err := d.execRow("INSERT INTO wallets (org_id, name, balance, created_at, updated_at) VALUES ($1, $2, 0, $3, $4) RETURNING id", orgID, name, tn, tn).Scan(&walletID)
+	if err != nil {
+		log.Printf("%s|[%d] Failed to create org wallet: %v", d.logID, orgID, err)
+		return 0, err
+	}
+	return walletID, nil
+}
+
+// list wallets of a product in id order, starting after lastID
+func (d *WalletDao) GetWalletListByProduct(product string, lastID int64, limit int32) ([]*model.Wallet, error) {
+	walletList := make([]*model.Wallet, 0)
+	rows, err := d.query("SELECT "+walletColumns+" FROM wallets WHERE product = $1 AND id > $2 ORDER BY id LIMIT $3", product, lastID, limit)
+	if err != nil {
+		log.Printf("%s|[%s] Failed to get wallet list by product: %v", d.logID, product, err)
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		wallet := &model.Wallet{}
+		if err = scanWallet(rows, wallet); err != nil {
+			log.Printf("%s|[%s] Failed to scan wallet: %v", d.logID, product, err)
+			return nil, err
+		}
+		walletList = append(walletList, wallet)
+	}
+	return walletList, rows.Err()
+}
+
+// create or update the pocket of a user, returns the wallet id
+func (d *WalletDao) CreateOrUpdateWallet(userID int64, name string, balance float64) (int64, error) {
 	tn := time.Now().Unix()
-	wallet, err := d.GetWalletByUserID(nil, dbTx, userID)
+	wallet, err := d.GetWalletByUserID(userID, name)
 	if err != nil {
 		log.Printf("%s|[%d] Failed to query wallet: %v", d.logID, userID, err)
-		return err
+		return 0, err
 	}
+	var walletID int64
 	if wallet == nil {
-		_, err = dbTx.Exec("INSERT INTO wallets (user_id, balance,created_at,updated_at) VALUES ($1, $2, $3, $4)", userID, balance, tn, tn)
+		err = d.execRow("INSERT INTO wallets (user_id, name, balance, created_at, updated_at) VALUES ($1, $2, $3, $4, $5) RETURNING id", userID, name, balance, tn, tn).Scan(&walletID)
 	} else {
-		_, err = dbTx.Exec("UPDATE wallets SET balance = wallets.balance + $1, updated_at = $2 WHERE user_id = $3", balance, tn, userID)
+		walletID = wallet.ID
+		_, err = d.exec("UPDATE wallets SET balance = "+d.dialect.roundAmount("wallets.balance + $1")+", updated_at = $2 WHERE id = $3", balance, tn, walletID)
 	}
 	if err != nil {
 		log.Printf("%s|[%d] Failed to create or update wallet: %v", d.logID, userID, err)
-		return err
+		return 0, err
 	}
-	return nil
+	return walletID, nil
 }
 
-// update wallet balance
-func (d *WalletDao) UpdateWalletBalance(dbTx *sql.Tx, userID int64, txType int32, balance float64) error {
+// update wallet balance, the tx type decides credit or debit
+func (d *WalletDao) UpdateWalletBalance(walletID int64, txType int32, balance float64) error {
 	tn := time.Now().Unix()
 	var err error
-	if txType == data.TxTypeDeposit || txType == data.TxTypeTransferIn {
-		_, err = dbTx.Exec("UPDATE wallets SET balance = wallets.balance + $1, updated_at = $2 WHERE user_id = $3", balance, tn, userID)
-	} else if txType == data.TxTypeWithdraw || txType == data.TxTypeTransferOut {
-		_, err = dbTx.Exec("UPDATE wallets SET balance = wallets.balance - $1, updated_at = $2 WHERE user_id = $3", balance, tn, userID)
+	if data.TxTypeSign(txType) > 0 {
+		_, err = d.exec("UPDATE wallets SET balance = "+d.dialect.roundAmount("wallets.balance + $1")+", updated_at = $2 WHERE id = $3", balance, tn, walletID)
+	} else if data.TxTypeSign(txType) < 0 {
+		_, err = d.exec("UPDATE wallets SET balance = "+d.dialect.roundAmount("wallets.balance - $1")+", updated_at = $2 WHERE id = $3", balance, tn, walletID)
+	}
+	return err
+}
+
+// lock the wallet row until the unit of work ends, the checks reading other rows of the wallet then see
+// every debit committed before them; run it in a unit of work.
+func (d *WalletDao) LockWallet(walletID int64) error {
+	_, err := d.exec("UPDATE wallets SET balance = balance WHERE id = $1", walletID)
+	if err != nil {
+		log.Printf("%s|[%d] Failed to lock wallet: %v", d.logID, walletID, err)
+	}
+	return err
+}
+
+// hold an amount for a transfer pending approval, held funds are not available for debits
+func (d *WalletDao) HoldBalance(walletID int64, amount float64) error {
+	tn := time.Now().Unix()
+	_, err := d.exec("UPDATE wallets SET held = "+d.dialect.roundAmount("wallets.held + $1")+", updated_at = $2 WHERE id = $3", amount, tn, walletID)
+	if err != nil {
+		log.Printf("%s|[%d] Failed to hold balance: %v", d.logID, walletID, err)
+	}
+	return err
+}
+
+// release a held amount, the transfer was executed or has expired
+func (d *WalletDao) ReleaseHold(walletID int64, amount float64) error {
+	tn := time.Now().Unix()
+	_, err := d.exec("UPDATE wallets SET held = "+d.dialect.roundAmount("wallets.held - $1")+", updated_at = $2 WHERE id = $3", amount, tn, walletID)
+	if err != nil {
+		log.Printf("%s|[%d] Failed to release held balance: %v", d.logID, walletID, err)
+	}
+	return err
+}
+
+// set the credit limit and the interest product of a wallet, a limit below its overdraft fails the check constraints
+func (d *WalletDao) UpdateWalletTerms(walletID int64, creditLimit float64, product string) error {
+	tn := time.Now().Unix()
+	_, err := d.exec("UPDATE wallets SET credit_limit = "+d.dialect.roundAmount("$1")+", product = $2, updated_at = $3 WHERE id = $4", creditLimit, product, tn, walletID)
+	if err != nil {
+		log.Printf("%s|[%d] Failed to update wallet terms: %v", d.logID, walletID, err)
 	}
 	return err
 }
+
+// list the wallets after lastID in id order, each with the sums of its transactions by tx_type, the archived ones included
+func (d *WalletDao) GetWalletLedgerList(lastID int64, limit int32) ([]*WalletLedger, error) {
+	rows, err := d.query("WITH page AS (SELECT "+walletColumns+" FROM wallets WHERE id > $1 ORDER BY id LIMIT $2), "+
+		"sums AS (SELECT wallet_id, tx_type, SUM(amount) AS amount FROM ("+
+		"SELECT wallet_id, tx_type, amount FROM transactions WHERE wallet_id IN (SELECT id FROM page) "+
+		"UNION ALL SELECT wallet_id, tx_type, amount FROM transactions_archive WHERE wallet_id IN (SELECT id FROM page)) t GROUP BY wallet_id, tx_type) "+
+		"SELECT "+walletColumns+", COALESCE(sums.tx_type, 0), COALESCE(sums.amount, 0) FROM page LEFT JOIN sums ON sums.wallet_id = page.id ORDER BY page.id", lastID, limit)
+	if err != nil {
+		log.Printf("%s|[%d] Failed to get wallet ledger list: %v", d.logID, lastID, err)
+		return nil, err
+	}
+	defer rows.Close()
+	ledgerList := make([]*WalletLedger, 0)
+	for rows.Next() {
+		wallet := &model.Wallet{}
+		var txType int32
+		var amount float64
+		err = rows.Scan(&wallet.ID, &wallet.UserID, &wallet.OrgID, &wallet.Name, &wallet.Balance, &wallet.CreditLimit, &wallet.Held, &wallet.Product, &wallet.CreatedAt, &wallet.UpdatedAt,
+			&txType, &amount)
+		if err != nil {
+			log.Printf("%s|[%d] Failed to scan wallet ledger: %v", d.logID, lastID, err)
+			return nil, err
+		}
+		// one row per tx_type of a wallet
+		if n := len(ledgerList); n == 0 || ledgerList[n-1].Wallet.ID != wallet.ID {
+			ledgerList = append(ledgerList, &WalletLedger{Wallet: wallet, Sums: make(map[int32]float64)})
+		}
+		if txType != data.TxTypeUnknown || amount != 0 {
+			ledgerList[len(ledgerList)-1].Sums[txType] = amount
+		}
+	}
+	return ledgerList, rows.Err()
+}
+
+// set the balance of a wallet to the one rebuilt from its transactions, while it still is the balance it was read with.
+// A write since moved the balance and the transactions alike, returns false then: the wallet has to be read again.
+func (d *WalletDao) ResetWalletBalance(walletID int64, from float64, to float64) (bool, error) {
+	tn := time.Now().Unix()
+	result, err := d.exec("UPDATE wallets SET balance = "+d.dialect.roundAmount("$1")+", updated_at = $2 WHERE id = $3 AND balance = $4", to, tn, walletID, from)
+	if err != nil {
+		log.Printf("%s|[%d] Failed to reset wallet balance: %v", d.logID, walletID, err)
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
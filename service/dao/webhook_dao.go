@@ -0,0 +1,182 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"simplewallet/data"
+	"simplewallet/model"
+	"time"
+)
+
+type WebhookDao struct {
+	dbConn
+}
+
+func NewWebhookDao(ctx context.Context, logID string, db DBTX) *WebhookDao {
+	return &WebhookDao{dbConn: dbConn{ctx: ctx, logID: logID, db: db}}
+}
+
+const (
+	webhookSubscriptionColumns = "id,user_id,url,event_types,secret,created_at,updated_at"
+	webhookDeliveryColumns     = "id,subscription_id,user_id,event_id,event_type,payload,status,attempts,next_attempt_at,last_status_code,last_error,created_at,updated_at"
+)
+
+// maxWebhookErrorLen is the size of webhook_deliveries.last_error
+const maxWebhookErrorLen = 255
+
+func scanWebhookSubscription(row rowScanner, sub *model.WebhookSubscription) error {
+	return row.Scan(&sub.ID, &sub.UserID, &sub.URL, &sub.EventTypes, &sub.Secret, &sub.CreatedAt, &sub.UpdatedAt)
+}
+
+func scanWebhookDelivery(row rowScanner, delivery *model.WebhookDelivery) error {
+	return row.Scan(&delivery.ID, &delivery.SubscriptionID, &delivery.UserID, &delivery.EventID, &delivery.EventType, &delivery.Payload,
+		&delivery.Status, &delivery.Attempts, &delivery.NextAttemptAt, &delivery.LastStatusCode, &delivery.LastError, &delivery.CreatedAt, &delivery.UpdatedAt)
+}
+
+func (d *WebhookDao) CreateSubscription(sub *model.WebhookSubscription) (int64, error) {
+	tn := time.Now().Unix()
+	var subID int64
+	err := d.execRow("INSERT INTO webhook_subscriptions (user_id, url, event_types, secret, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		sub.UserID, sub.URL, sub.EventTypes, sub.Secret, tn, tn).Scan(&subID)
+	if err != nil {
+		log.Printf("%s|[%d] Failed to create webhook subscription: %v", d.logID, sub.UserID, err)
+		return 0, err
+	}
+	return subID, nil
+}
+
+func (d *WebhookDao) GetSubscriptionByID(subID int64) (*model.WebhookSubscription, error) {
+	sub := &model.WebhookSubscription{}
+	err := scanWebhookSubscription(d.queryRow("SELECT "+webhookSubscriptionColumns+" FROM webhook_subscriptions WHERE id = $1", subID), sub)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		log.Printf("%s|[%d] Failed to get webhook subscription: %v", d.logID, subID, err)
+		return nil, err
+	}
+	return sub, nil
+}
+
+func (d *WebhookDao) GetSubscriptionListByUserID(userID int64) ([]*model.WebhookSubscription, error) {
+	rows, err := d.query("SELECT "+webhookSubscriptionColumns+" FROM webhook_subscriptions WHERE user_id = $1 ORDER BY id", userID)
+	if err != nil {
+		log.Printf("%s|[%d] Failed to get webhook subscription list: %v", d.logID, userID, err)
+		return nil, err
+	}
+	defer rows.Close()
+	subList := make([]*model.WebhookSubscription, 0)
+	for rows.Next() {
+		sub := &model.WebhookSubscription{}
+		if err = scanWebhookSubscription(rows, sub); err != nil {
+			log.Printf("%s|Failed to scan webhook subscription: %v", d.logID, err)
+			return nil, err
+		}
+		subList = append(subList, sub)
+	}
+	return subList, rows.Err()
+}
+
+// record a pending delivery of an event to a subscription, returns false if the event was delivered to it already
+func (d *WebhookDao) InsertDelivery(delivery *model.WebhookDelivery) (bool, error) {
+	tn := time.Now().Unix()
+	result, err := d.exec("INSERT INTO webhook_deliveries (subscription_id, user_id, event_id, event_type, payload, status, next_attempt_at, created_at, updated_at) "+
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) ON CONFLICT (subscription_id, event_id) DO NOTHING",
+		delivery.SubscriptionID, delivery.UserID, delivery.EventID, delivery.EventType, delivery.Payload, data.WebhookDeliveryPending, delivery.NextAttemptAt, tn, tn)
+	if err != nil {
+		log.Printf("%s|[%s] Failed to insert webhook delivery: %v", d.logID, delivery.EventID, err)
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// list the pending deliveries due at the given time, the longest due first
+func (d *WebhookDao) GetDueDeliveryList(now int64, limit int32) ([]*model.WebhookDelivery, error) {
+	rows, err := d.query("SELECT "+webhookDeliveryColumns+" FROM webhook_deliveries WHERE status = $1 AND next_attempt_at <= $2 ORDER BY next_attempt_at, id LIMIT $3",
+		data.WebhookDeliveryPending, now, limit)
+	if err != nil {
+		log.Printf("%s|[%d] Failed to get due webhook deliveries: %v", d.logID, now, err)
+		return nil, err
+	}
+	return d.scanDeliveryList(rows)
+}
+
+// list the deliveries of a user, newest first, the ones before beforeID when it is > 0.
+// A status < 0 lists every status.
+func (d *WebhookDao) GetDeliveryList(userID int64, status int32, beforeID int64, limit int32) ([]*model.WebhookDelivery, error) {
+	args := []any{userID}
+	where := "user_id = $1"
+	if status >= 0 {
+		args = append(args, status)
+		where += fmt.Sprintf(" AND status = $%d", len(args))
+	}
+	if beforeID > 0 {
+		args = append(args, beforeID)
+		where += fmt.Sprintf(" AND id < $%d", len(args))
+	}
+	args = append(args, limit)
+	rows, err := d.query(fmt.Sprintf("SELECT "+webhookDeliveryColumns+" FROM webhook_deliveries WHERE %s ORDER BY id DESC LIMIT $%d", where, len(args)), args...)
+	if err != nil {
+		log.Printf("%s|[%d] Failed to get webhook delivery list: %v", d.logID, userID, err)
+		return nil, err
+	}
+	return d.scanDeliveryList(rows)
+}
+
+func (d *WebhookDao) scanDeliveryList(rows *timedRows) ([]*model.WebhookDelivery, error) {
+	defer rows.Close()
+	deliveryList := make([]*model.WebhookDelivery, 0)
+	for rows.Next() {
+		delivery := &model.WebhookDelivery{}
+		if err := scanWebhookDelivery(rows, delivery); err != nil {
+			log.Printf("%s|Failed to scan webhook delivery: %v", d.logID, err)
+			return nil, err
+		}
+		deliveryList = append(deliveryList, delivery)
+	}
+	return deliveryList, rows.Err()
+}
+
+// record a post of a pending delivery and move it to the status, retried at nextAttemptAt while it stays pending.
+// Returns false if it was not pending any more.
+func (d *WebhookDao) RecordDeliveryAttempt(deliveryID int64, status int32, statusCode int32, lastError string, nextAttemptAt int64) (bool, error) {
+	if len(lastError) > maxWebhookErrorLen {
+		lastError = lastError[:maxWebhookErrorLen]
+	}
+	tn := time.Now().Unix()
+	result, err := d.exec("UPDATE webhook_deliveries SET status = $1, attempts = attempts + 1, last_status_code = $2, last_error = $3, next_attempt_at = $4, updated_at = $5 "+
+		"WHERE id = $6 AND status = $7", status, statusCode, lastError, nextAttemptAt, tn, deliveryID, data.WebhookDeliveryPending)
+	if err != nil {
+		log.Printf("%s|[%d] Failed to record webhook delivery attempt: %v", d.logID, deliveryID, err)
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// move a delivery of the user back to pending with a new round of retries, due at the given time.
+// Returns false if the user has no such delivery.
+func (d *WebhookDao) RedeliverDelivery(deliveryID int64, userID int64, now int64) (bool, error) {
+	tn := time.Now().Unix()
+	result, err := d.exec("UPDATE webhook_deliveries SET status = $1, attempts = 0, next_attempt_at = $2, updated_at = $3 WHERE id = $4 AND user_id = $5",
+		data.WebhookDeliveryPending, now, tn, deliveryID, userID)
+	if err != nil {
+		log.Printf("%s|[%d] Failed to redeliver webhook delivery: %v", d.logID, deliveryID, err)
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
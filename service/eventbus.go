@@ -0,0 +1,193 @@
+package service
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// EventBus tells the streams of a user that their wallets changed. A notice carries no data, the streams
+// read what changed from the db, so notices may be coalesced or lost without a stream missing a change
+// for longer than the next one.
+type EventBus interface {
+	// Publish notifies the streams of the user, after a write to their wallets committed
+	Publish(ctx context.Context, userID int64) error
+	// Subscribe returns the notices of the user until cancel is called
+	Subscribe(userID int64) (notices <-chan struct{}, cancel func())
+}
+
+// eventBus is the bus of the process, the wallet services notify their writes on it
+var eventBus EventBus
+
+// SetEventBus sets the bus the wallet services notify their writes on and stream from, nil turns streams off
+func SetEventBus(bus EventBus) {
+	eventBus = bus
+}
+
+// LocalBus fans the notices out to the streams of this process.
+type LocalBus struct {
+	mu   sync.Mutex
+	subs map[int64]map[chan struct{}]struct{}
+}
+
+func NewLocalBus() *LocalBus {
+	return &LocalBus{subs: make(map[int64]map[chan struct{}]struct{})}
+}
+
+func (b *LocalBus) Publish(ctx context.Context, userID int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs[userID] {
+		// a notice still pending covers this one
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (b *LocalBus) Subscribe(userID int64) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	b.mu.Lock()
+	if b.subs[userID] == nil {
+		b.subs[userID] = make(map[chan struct{}]struct{})
+	}
+	b.subs[userID][ch] = struct{}{}
+	b.mu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.subs[userID], ch)
+			if len(b.subs[userID]) == 0 {
+				delete(b.subs, userID)
+			}
+		})
+	}
+}
+
+const (
+	// streamChannelPrefix is the redis channel of the notices of a user, followed by the user id
+	streamChannelPrefix = "wallet:stream:"
+	// how long Subscribe waits for redis to confirm a subscription, the notices published before are not received
+	subscribeTimeout = time.Second
+)
+
+// RedisBus fans the notices out to the streams of every instance with redis pub/sub. An instance
+// subscribes to the channel of a user while it streams to them, and hands the notices to its LocalBus.
+// Should redis fail, a notice still reaches the streams of the instance it was published on.
+type RedisBus struct {
+	ctx    context.Context
+	cli    *redis.Client
+	local  *LocalBus
+	pubsub *redis.PubSub
+	mu     sync.Mutex
+	users  map[int64]int            // local subscribers of each user
+	ready  map[string]chan struct{} // closed once redis confirmed the subscription to the channel
+	done   chan struct{}
+}
+
+func NewRedisBus(ctx context.Context, cli *redis.Client) *RedisBus {
+	b := &RedisBus{
+		ctx:    ctx,
+		cli:    cli,
+		local:  NewLocalBus(),
+		pubsub: cli.Subscribe(ctx),
+		users:  make(map[int64]int),
+		ready:  make(map[string]chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go b.receive()
+	return b
+}
+
+func streamChannel(userID int64) string {
+	return streamChannelPrefix + strconv.FormatInt(userID, 10)
+}
+
+// receive hands the notices of redis to the local streams until the bus is closed
+func (b *RedisBus) receive() {
+	defer close(b.done)
+	for msg := range b.pubsub.ChannelWithSubscriptions(b.ctx, 100) {
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			if msg.Kind == "subscribe" {
+				b.mu.Lock()
+				if ready, ok := b.ready[msg.Channel]; ok {
+					close(ready)
+					delete(b.ready, msg.Channel)
+				}
+				b.mu.Unlock()
+			}
+		case *redis.Message:
+			userID, err := strconv.ParseInt(strings.TrimPrefix(msg.Channel, streamChannelPrefix), 10, 64)
+			if err != nil {
+				continue
+			}
+			_ = b.local.Publish(b.ctx, userID)
+		}
+	}
+}
+
+func (b *RedisBus) Publish(ctx context.Context, userID int64) error {
+	if err := b.cli.Publish(ctx, streamChannel(userID), "").Err(); err != nil {
+		log.Println("Failed to publish stream notice" + err.Error())
+		return b.local.Publish(ctx, userID)
+	}
+	return nil
+}
+
+// Subscribe returns once redis confirmed the subscription, or after subscribeTimeout, so the notices of the
+// writes committed after it are received
+func (b *RedisBus) Subscribe(userID int64) (<-chan struct{}, func()) {
+	notices, cancel := b.local.Subscribe(userID)
+	channel := streamChannel(userID)
+	b.mu.Lock()
+	b.users[userID]++
+	if b.users[userID] == 1 {
+		b.ready[channel] = make(chan struct{})
+		if err := b.pubsub.Subscribe(b.ctx, channel); err != nil {
+			log.Println("Failed to subscribe stream notices" + err.Error())
+			delete(b.ready, channel)
+		}
+	}
+	ready := b.ready[channel]
+	b.mu.Unlock()
+	if ready != nil {
+		select {
+		case <-ready:
+		case <-time.After(subscribeTimeout):
+			log.Println("Failed to subscribe stream notices: not confirmed by redis")
+		}
+	}
+	var once sync.Once
+	return notices, func() {
+		once.Do(func() {
+			cancel()
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			b.users[userID]--
+			if b.users[userID] == 0 {
+				delete(b.users, userID)
+				delete(b.ready, channel)
+				if err := b.pubsub.Unsubscribe(b.ctx, channel); err != nil {
+					log.Println("Failed to unsubscribe stream notices" + err.Error())
+				}
+			}
+		})
+	}
+}
+
+// Close stops receiving the notices of the other instances
+func (b *RedisBus) Close() error {
+	err := b.pubsub.Close()
+	<-b.done
+	return err
+}
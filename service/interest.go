@@ -0,0 +1,234 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"simplewallet/data"
+	"simplewallet/model"
+	"simplewallet/service/dao"
+	"simplewallet/util"
+	"simplewallet/util/errcode"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const interestWalletBatch int32 = 500
+
+// PayInterest credits the accrued interest of a period to the wallet as an interest transaction.
+// The accruals of the period are marked paid in the same db transaction, and the order_id is
+// derived from the period, so a rerun either finds nothing unpaid or hits ErrCodeOrderIDRepeat.
+func (s *WalletService) PayInterest(req *data.PayInterestReq) (rsp *data.CommRsp, err error) {
+	rsp = &data.CommRsp{Code: errcode.ErrCodeInternalErr, Message: errcode.ErrMsgMap[errcode.ErrCodeInternalErr], LogID: s.logID}
+	defer func() { mapDbError(err, &rsp.Code, &rsp.Message) }()
+
+	err = s.locker.Lock()
+	if err != nil {
+		rsp.Code = errcode.ErrCodeLockFail
+		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
+		return rsp, err
+	}
+	defer func() {
+		if errt := s.locker.UnLock(); errt != nil {
+			rsp.Code = errcode.ErrCodeUnLockFail
+			rsp.Message = errcode.ErrMsgMap[rsp.Code] + errt.Error()
+		}
+	}()
+
+	err = s.runTx(s.store, rsp, func(tx dao.UnitOfWork) error {
+		// check order_id
+		transDao := tx.Transactions()
+		if code, err := s.checkOrderID(tx, req.UserID, req.OrderID); err != nil {
+			rsp.Code = code
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+
+		// Update balance
+		err := tx.Wallets().UpdateWalletBalance(req.WalletID, data.TxTypeInterest, req.Amount)
+		if err != nil {
+			log.Println("Failed to update balance" + err.Error())
+			rsp.Code = errcode.ErrCodeDbError
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+
+		// Mark accruals paid
+		err = tx.Interest().MarkAccrualsPaid(req.WalletID, req.PeriodFrom, req.PeriodTo, req.OrderID)
+		if err != nil {
+			log.Println("Failed to mark interest accruals paid" + err.Error())
+			rsp.Code = errcode.ErrCodeDbError
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+
+		// Record transaction and its event
+		record := &model.Transactions{OrderID: req.OrderID, UserID: req.UserID, WalletID: req.WalletID, TxType: data.TxTypeInterest, Amount: req.Amount}
+		err = transDao.InsertTransaction(record)
+		if err != nil {
+			log.Println("Failed to record transaction" + err.Error())
+			rsp.Code = errcode.ErrCodeDbError
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+		if err = recordEvent(tx, data.EventTypeInterest, record); err != nil {
+			log.Println("Failed to record interest event" + err.Error())
+			rsp.Code = errcode.ErrCodeDbError
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return rsp, err
+	}
+
+	s.written(req.UserID)
+	rsp.Code = errcode.ErrCodeSuccess
+	rsp.Message = "Interest payout successful"
+	return rsp, nil
+}
+
+// InterestService accrues daily interest on the end-of-day balance of wallets
+// whose product has an annual rate, and pays the accruals out monthly.
+// A wallet, its transactions and accruals are on one shard, every shard is accrued and paid on its own.
+type InterestService struct {
+	logID     string
+	ctx       context.Context
+	stores    []dao.Store        // the shards, or the one store
+	rates     map[string]float64 // wallet product -> annual rate
+	newLocker func(key string) util.DistributedLock
+}
+
+func NewInterestService(ctx context.Context, logID string, dbCli *sql.DB, rates map[string]float64, newLocker func(key string) util.DistributedLock) *InterestService {
+	stores := []dao.Store{newSqlStore(ctx, logID, dbCli)}
+	if shards := newShardedStore(ctx, logID, stores[0]); shards != nil {
+		for i := 1; i < shards.Len(); i++ {
+			stores = append(stores, shards.Shard(i))
+		}
+	}
+	return &InterestService{
+		ctx:       ctx,
+		logID:     logID,
+		stores:    stores,
+		rates:     rates,
+		newLocker: newLocker,
+	}
+}
+
+// AccrueDay records one day of interest for every wallet with a rated product.
+// Accruals are unique per wallet and day, so rerunning a day is a no-op.
+func (s *InterestService) AccrueDay(day time.Time) error {
+	for _, store := range s.stores {
+		if err := s.accrueDay(store, day); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *InterestService) accrueDay(store dao.Store, day time.Time) error {
+	accrualDate := dateInt(day)
+	dayEnd := time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, day.Location()).Unix()
+	daysOfYear := decimal.NewFromInt(int64(time.Date(day.Year(), 12, 31, 0, 0, 0, 0, day.Location()).YearDay()))
+
+	walletDao := store.Wallets()
+	transDao := store.Transactions()
+	interestDao := store.Interest()
+	for product, rate := range s.rates {
+		if util.CompareFloat(rate, 0, 8) <= 0 {
+			continue
+		}
+		lastID := int64(0)
+		for {
+			walletList, err := walletDao.GetWalletListByProduct(product, lastID, interestWalletBatch)
+			if err != nil {
+				return err
+			}
+			for _, wallet := range walletList {
+				sums, err := transDao.GetAmountSumByTxType(wallet.ID, dayEnd)
+				if err != nil {
+					return err
+				}
+				balance := ledgerBalance(sums)
+				// negative balances are overdraft, they earn nothing
+				amount := decimal.Max(balance, decimal.Zero).Mul(decimal.NewFromFloat(rate)).Div(daysOfYear).Round(8)
+				_, err = interestDao.InsertAccrual(&model.InterestAccrual{
+					WalletID:    wallet.ID,
+					UserID:      wallet.UserID,
+					AccrualDate: accrualDate,
+					Balance:     balance.InexactFloat64(),
+					Rate:        rate,
+					Amount:      amount.InexactFloat64(),
+				})
+				if err != nil {
+					return err
+				}
+			}
+			if len(walletList) < int(interestWalletBatch) {
+				break
+			}
+			lastID = walletList[len(walletList)-1].ID
+		}
+	}
+	return nil
+}
+
+// PayoutMonth makes sure every elapsed day of the month is accrued, then pays out
+// the unpaid accruals of each wallet as one interest transaction.
+func (s *InterestService) PayoutMonth(month time.Time) error {
+	first := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, month.Location())
+	last := first.AddDate(0, 1, -1)
+	now := time.Now().In(month.Location())
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, month.Location())
+	for day := first; !day.After(last) && day.Before(today); day = day.AddDate(0, 0, 1) {
+		if err := s.AccrueDay(day); err != nil {
+			return err
+		}
+	}
+
+	from, to := dateInt(first), dateInt(last)
+	var firstErr error
+	for _, store := range s.stores {
+		if err := s.payout(store, from, to); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// payout pays the unpaid accruals of the period on one store, returns the first failed payout
+func (s *InterestService) payout(store dao.Store, from int32, to int32) error {
+	interestDao := store.Interest()
+	walletIDs, err := interestDao.GetUnpaidWalletIDs(from, to)
+	if err != nil {
+		return err
+	}
+	var firstErr error
+	for _, walletID := range walletIDs {
+		userID, amount, err := interestDao.GetUnpaidAmount(walletID, from, to)
+		if err != nil {
+			return err
+		}
+		if util.CompareFloat(amount, 1e-8, 8) < 0 {
+			continue
+		}
+		orderID := fmt.Sprintf("interest:%d:%d", walletID, from/100)
+		req := &data.PayInterestReq{OrderID: orderID, UserID: userID, WalletID: walletID, Amount: amount, PeriodFrom: from, PeriodTo: to}
+		rsp, err := NewWalletServiceWithStore(s.ctx, s.logID, store, s.newLocker("interest:"+orderID)).PayInterest(req)
+		if err != nil && rsp.Code != errcode.ErrCodeOrderIDRepeat {
+			log.Printf("%s|[%d] fail to pay interest:%s\n", s.logID, walletID, err.Error())
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// dateInt formats the day as yyyymmdd
+func dateInt(day time.Time) int32 {
+	return int32(day.Year()*10000 + int(day.Month())*100 + day.Day())
+}
@@ -0,0 +1,110 @@
+package service_test
+
+import (
+	"context"
+	"regexp"
+	"simplewallet/data"
+	"simplewallet/service"
+	"simplewallet/util"
+	"simplewallet/util/db"
+	"simplewallet/util/errcode"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
+)
+
+func TestPayInterest(t *testing.T) {
+	ConnectDBRedis()
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	defer DisconnectDBRedis()
+
+	mockDBCli := db.GetDbClientMock()
+	mock := db.GetSqlMock()
+	t.Run("case1: pay interest success", func(t *testing.T) {
+		logID := util.Uniqid()
+		ctx := context.Background()
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, "interest:"+logID, 5)
+		payReq := &data.PayInterestReq{OrderID: logID, UserID: 101, WalletID: 7, Amount: 2.5, PeriodFrom: 20250101, PeriodTo: 20250131}
+		tn := time.Now().Unix()
+		// mock DB data
+		mock.ExpectBegin()
+		transRows := sqlmock.NewRows([]string{})
+		mock.ExpectQuery("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(payReq.OrderID).WillReturnRows(transRows)
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET balance = wallets.balance + $1, updated_at = $2 WHERE id = $3")).
+			WithArgs(payReq.Amount, tn, payReq.WalletID).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE interest_accruals SET payout_order_id = $1, updated_at = $2 WHERE wallet_id = $3 AND accrual_date >= $4 AND accrual_date <= $5 AND payout_order_id = ''")).
+			WithArgs(payReq.OrderID, tn, payReq.WalletID, payReq.PeriodFrom, payReq.PeriodTo).WillReturnResult(sqlmock.NewResult(1, 31))
+		expectChainHead(mock, payReq.WalletID)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions (order_id, user_id, wallet_id, tx_type, amount, related_user_id, actor_user_id, created_at, updated_at, prev_hash, hash) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)")).
+			WithArgs(payReq.OrderID, payReq.UserID, payReq.WalletID, data.TxTypeInterest, payReq.Amount, 0, 0, tn, tn, "", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		expectChainHeadUpdate(mock, payReq.WalletID)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO outbox")).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
+		rsp, err := walletService.PayInterest(payReq)
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("case2: pay interest fail-[period already paid]", func(t *testing.T) {
+		logID := util.Uniqid()
+		ctx := context.Background()
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, "interest:"+logID, 5)
+		payReq := &data.PayInterestReq{OrderID: logID, UserID: 101, WalletID: 7, Amount: 2.5, PeriodFrom: 20250101, PeriodTo: 20250131}
+		tn := time.Now().Unix()
+		// mock DB data
+		mock.ExpectBegin()
+		transRows := sqlmock.NewRows([]string{"id", "order_id", "user_id", "wallet_id", "tx_type", "amount", "related_user_id", "actor_user_id", "created_at", "updated_at"})
+		transRows.AddRow(1, payReq.OrderID, payReq.UserID, payReq.WalletID, data.TxTypeInterest, payReq.Amount, 0, payReq.UserID, tn, tn)
+		mock.ExpectQuery("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(payReq.OrderID).WillReturnRows(transRows)
+		mock.ExpectRollback()
+
+		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
+		rsp, err := walletService.PayInterest(payReq)
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodeOrderIDRepeat, rsp.Code)
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestAccrueDay(t *testing.T) {
+	ConnectDBRedis()
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	defer DisconnectDBRedis()
+
+	mockDBCli := db.GetDbClientMock()
+	mock := db.GetSqlMock()
+	t.Run("case1: accrue day success-[end-of-day balance from transactions]", func(t *testing.T) {
+		logID := util.Uniqid()
+		ctx := context.Background()
+		day := time.Date(2025, 1, 10, 15, 0, 0, 0, time.Local)
+		dayEnd := time.Date(2025, 1, 11, 0, 0, 0, 0, time.Local).Unix()
+		tn := time.Now().Unix()
+		// mock DB data
+		walletRows := sqlmock.NewRows([]string{"id", "user_id", "org_id", "name", "balance", "credit_limit", "held", "product", "created_at", "updated_at"}).AddRow(7, 101, 0, data.DefaultPocket, 300.00, 0, 0, "savings", tn, tn)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE product = $1 AND id > $2 ORDER BY id LIMIT $3")).
+			WithArgs("savings", 0, 500).WillReturnRows(walletRows)
+		sumRows := sqlmock.NewRows([]string{"tx_type", "sum"}).AddRow(data.TxTypeDeposit, 1000.00).AddRow(data.TxTypeWithdraw, 200.00)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT tx_type, COALESCE(SUM(amount), 0) FROM transactions WHERE wallet_id = $1 AND created_at < $2 GROUP BY tx_type")).
+			WithArgs(7, dayEnd).WillReturnRows(sumRows)
+		// 800 * 3.65% / 365 days
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO interest_accruals (wallet_id, user_id, accrual_date, balance, rate, amount, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) ON CONFLICT (wallet_id, accrual_date) DO NOTHING")).
+			WithArgs(7, 101, 20250110, 800.00, 0.0365, 0.08, tn, tn).WillReturnResult(sqlmock.NewResult(1, 1))
+
+		interestService := service.NewInterestService(ctx, logID, mockDBCli, map[string]float64{"savings": 0.0365}, nil)
+		err := interestService.AccrueDay(day)
+		assert.Nil(t, err)
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
@@ -0,0 +1,175 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"simplewallet/data"
+	"simplewallet/model"
+	"simplewallet/service/dao"
+	"simplewallet/util"
+	"simplewallet/util/errcode"
+	"time"
+)
+
+// CreateOrg creates an organization with the acting user as its first owner, and the main pocket of its shared wallet.
+// Transfers of spenders above their limit need RequiredApprovals approvals of other members.
+func (s *WalletService) CreateOrg(req *data.CreateOrgReq) (rsp *data.CreateOrgRsp, err error) {
+	rsp = &data.CreateOrgRsp{Code: errcode.ErrCodeInternalErr, Message: errcode.ErrMsgMap[errcode.ErrCodeInternalErr], LogID: s.logID}
+	defer func() { mapDbError(err, &rsp.Code, &rsp.Message) }()
+
+	tx, err := s.store.Begin()
+	if err != nil {
+		rsp.Code = errcode.ErrCodeDbError
+		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
+		return rsp, err
+	}
+
+	orgDao := tx.Orgs()
+	org := &model.Organization{Name: req.Name, RequiredApprovals: req.RequiredApprovals, ApprovalExpireSecond: req.ApprovalExpireSecond}
+	if org.RequiredApprovals <= 0 {
+		org.RequiredApprovals = data.DefaultRequiredApprovals
+	}
+	if org.ApprovalExpireSecond <= 0 {
+		org.ApprovalExpireSecond = data.DefaultApprovalExpireSecond
+	}
+	orgID, err := orgDao.CreateOrg(org)
+	if err != nil {
+		_ = tx.Rollback()
+		rsp.Code = errcode.ErrCodeDbError
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return rsp, err
+	}
+	err = orgDao.SaveMember(&model.OrgMember{OrgID: orgID, UserID: req.UserID, Role: data.OrgRoleOwner})
+	if err != nil {
+		_ = tx.Rollback()
+		rsp.Code = errcode.ErrCodeDbError
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return rsp, err
+	}
+	walletID, err := tx.Wallets().CreateOrgWallet(orgID, data.DefaultPocket)
+	if err != nil {
+		_ = tx.Rollback()
+		rsp.Code = errcode.ErrCodeDbError
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return rsp, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Failed to commit transaction" + err.Error())
+		rsp.Code = errcode.ErrCodeDbError
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return rsp, err
+	}
+
+	rsp.Code = errcode.ErrCodeSuccess
+	rsp.Message = "Success"
+	rsp.Data = &data.CreateOrgRspData{OrgID: orgID, WalletID: walletID}
+	return rsp, nil
+}
+
+// SetOrgMember adds a member to an organization or changes the role and spending limit of a member.
+// Only owners manage members, and owners can not step down themselves so an org always keeps an owner.
+func (s *WalletService) SetOrgMember(req *data.SetOrgMemberReq) (rsp *data.CommRsp, err error) {
+	rsp = &data.CommRsp{Code: errcode.ErrCodeInternalErr, Message: errcode.ErrMsgMap[errcode.ErrCodeInternalErr], LogID: s.logID}
+	defer func() { mapDbError(err, &rsp.Code, &rsp.Message) }()
+
+	tx, err := s.store.Begin()
+	if err != nil {
+		rsp.Code = errcode.ErrCodeDbError
+		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
+		return rsp, err
+	}
+
+	if _, code, err := s.checkOrgRole(tx, req.OrgID, req.UserID, data.OrgRoleOwner); err != nil {
+		_ = tx.Rollback()
+		rsp.Code = code
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return rsp, err
+	}
+	if req.MemberUserID == req.UserID && req.Role != data.OrgRoleOwner {
+		_ = tx.Rollback()
+		err = errors.New("owner can not step down")
+		rsp.Code = errcode.ErrCodePermissionDenied
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return rsp, err
+	}
+
+	err = tx.Orgs().SaveMember(&model.OrgMember{OrgID: req.OrgID, UserID: req.MemberUserID, Role: req.Role, SpendingLimit: req.SpendingLimit})
+	if err != nil {
+		_ = tx.Rollback()
+		rsp.Code = errcode.ErrCodeDbError
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return rsp, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Failed to commit transaction" + err.Error())
+		rsp.Code = errcode.ErrCodeDbError
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return rsp, err
+	}
+
+	rsp.Code = errcode.ErrCodeSuccess
+	rsp.Message = "Success"
+	return rsp, nil
+}
+
+// checkOrgRole makes sure the user is a member of the org holding at least minRole.
+// On failure the returned code is the response code.
+func (s *WalletService) checkOrgRole(repos dao.Repos, orgID int64, userID int64, minRole string) (*model.OrgMember, int32, error) {
+	member, err := repos.Orgs().GetMember(orgID, userID)
+	if err != nil {
+		return nil, errcode.ErrCodeQueryDBFail, err
+	}
+	if member == nil || data.OrgRoleRank(member.Role) < data.OrgRoleRank(minRole) {
+		return nil, errcode.ErrCodePermissionDenied, errors.New("permission denied")
+	}
+	return member, errcode.ErrCodeSuccess, nil
+}
+
+// loadWallet loads the pocket the acting user works on: their own pocket, or the shared pocket
+// of the org when orgID > 0. For shared pockets the member is returned as well, nil otherwise.
+func (s *WalletService) loadWallet(repos dao.Repos, userID int64, orgID int64, name string, minRole string) (*model.Wallet, *model.OrgMember, int32, error) {
+	walletDao := repos.Wallets()
+	var wallet *model.Wallet
+	var member *model.OrgMember
+	var err error
+	if orgID > 0 {
+		var code int32
+		if member, code, err = s.checkOrgRole(repos, orgID, userID, minRole); err != nil {
+			return nil, nil, code, err
+		}
+		wallet, err = walletDao.GetWalletByOrgID(orgID, name)
+	} else {
+		wallet, err = walletDao.GetWalletByUserID(userID, name)
+	}
+	if err != nil {
+		return nil, nil, errcode.ErrCodeDbError, err
+	}
+	if wallet == nil {
+		return nil, nil, errcode.ErrCodeUserWalletNotExist, errors.New("wallet not exist")
+	}
+	return wallet, member, errcode.ErrCodeSuccess, nil
+}
+
+// checkSpendingLimit makes sure a spender stays within the daily spending limit on a shared pocket.
+// Owners and personal wallets are not limited.
+func (s *WalletService) checkSpendingLimit(repos dao.Repos, wallet *model.Wallet, member *model.OrgMember, amount float64) (int32, error) {
+	if member == nil || member.Role == data.OrgRoleOwner {
+		return errcode.ErrCodeSuccess, nil
+	}
+	// debits of the spender with other order_ids wait here, the sum includes the ones committed before
+	if err := repos.Wallets().LockWallet(wallet.ID); err != nil {
+		return errcode.ErrCodeDbError, err
+	}
+	now := time.Now()
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).Unix()
+	spent, err := repos.Transactions().GetDebitSumByActor(wallet.ID, member.UserID, dayStart)
+	if err != nil {
+		return errcode.ErrCodeQueryDBFail, err
+	}
+	if util.CompareFloat(spent+amount, member.SpendingLimit, 8) > 0 {
+		return errcode.ErrCodeSpendingLimit, errors.New("spending limit exceeded")
+	}
+	return errcode.ErrCodeSuccess, nil
+}
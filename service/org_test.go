@@ -0,0 +1,199 @@
+package service_test
+
+import (
+	"context"
+	"regexp"
+	"simplewallet/data"
+	"simplewallet/service"
+	"simplewallet/util"
+	"simplewallet/util/db"
+	"simplewallet/util/errcode"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
+)
+
+func TestCreateOrg(t *testing.T) {
+	ConnectDBRedis()
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	defer DisconnectDBRedis()
+
+	mockDBCli := db.GetDbClientMock()
+	mock := db.GetSqlMock()
+	t.Run("case1: create org success", func(t *testing.T) {
+		logID := util.Uniqid()
+		ctx := context.Background()
+		createReq := &data.CreateOrgReq{UserID: 101, Name: "acme"}
+		tn := time.Now().Unix()
+		// mock DB data
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO organizations (name, required_approvals, approval_expire_second, created_at, updated_at) VALUES ($1, $2, $3, $4, $5) RETURNING id")).
+			WithArgs(createReq.Name, data.DefaultRequiredApprovals, data.DefaultApprovalExpireSecond, tn, tn).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO org_members (org_id, user_id, role, spending_limit, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (org_id, user_id) DO UPDATE")).
+			WithArgs(1, createReq.UserID, data.OrgRoleOwner, 0.0, tn, tn).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO wallets (org_id, name, balance, created_at, updated_at) VALUES ($1, $2, 0, $3, $4) RETURNING id")).
+			WithArgs(1, data.DefaultPocket, tn, tn).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+		mock.ExpectCommit()
+
+		walletService := service.NewWalletService(ctx, logID, mockDBCli, nil)
+		rsp, err := walletService.CreateOrg(createReq)
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		assert.Equal(t, int64(1), rsp.Data.OrgID)
+		assert.Equal(t, int64(9), rsp.Data.WalletID)
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestSetOrgMember(t *testing.T) {
+	ConnectDBRedis()
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	defer DisconnectDBRedis()
+
+	mockDBCli := db.GetDbClientMock()
+	mock := db.GetSqlMock()
+	t.Run("case1: set org member fail-[acting user is not an owner]", func(t *testing.T) {
+		logID := util.Uniqid()
+		ctx := context.Background()
+		setReq := &data.SetOrgMemberReq{OrgID: 1, UserID: 102, MemberUserID: 103, Role: data.OrgRoleSpender, SpendingLimit: 500.00}
+		tn := time.Now().Unix()
+		// mock DB data
+		mock.ExpectBegin()
+		memberRows := sqlmock.NewRows([]string{"id", "org_id", "user_id", "role", "spending_limit", "created_at", "updated_at"}).AddRow(2, 1, 102, data.OrgRoleSpender, 100.00, tn, tn)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,org_id,user_id,role,spending_limit,created_at,updated_at FROM org_members WHERE org_id = $1 AND user_id = $2")).
+			WithArgs(setReq.OrgID, setReq.UserID).WillReturnRows(memberRows)
+		mock.ExpectRollback()
+
+		walletService := service.NewWalletService(ctx, logID, mockDBCli, nil)
+		rsp, err := walletService.SetOrgMember(setReq)
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodePermissionDenied, rsp.Code)
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("case2: set org member success-[owner adds a spender]", func(t *testing.T) {
+		logID := util.Uniqid()
+		ctx := context.Background()
+		setReq := &data.SetOrgMemberReq{OrgID: 1, UserID: 101, MemberUserID: 103, Role: data.OrgRoleSpender, SpendingLimit: 500.00}
+		tn := time.Now().Unix()
+		// mock DB data
+		mock.ExpectBegin()
+		memberRows := sqlmock.NewRows([]string{"id", "org_id", "user_id", "role", "spending_limit", "created_at", "updated_at"}).AddRow(1, 1, 101, data.OrgRoleOwner, 0, tn, tn)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,org_id,user_id,role,spending_limit,created_at,updated_at FROM org_members WHERE org_id = $1 AND user_id = $2")).
+			WithArgs(setReq.OrgID, setReq.UserID).WillReturnRows(memberRows)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO org_members (org_id, user_id, role, spending_limit, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (org_id, user_id) DO UPDATE")).
+			WithArgs(setReq.OrgID, setReq.MemberUserID, setReq.Role, setReq.SpendingLimit, tn, tn).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		walletService := service.NewWalletService(ctx, logID, mockDBCli, nil)
+		rsp, err := walletService.SetOrgMember(setReq)
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestOrgWithdraw(t *testing.T) {
+	ConnectDBRedis()
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	defer DisconnectDBRedis()
+
+	mockDBCli := db.GetDbClientMock()
+	mock := db.GetSqlMock()
+	t.Run("case1: org withdraw success-[spender within daily limit]", func(t *testing.T) {
+		logID := util.Uniqid()
+		ctx := context.Background()
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, "withdraw:"+logID, 5)
+		withdrawReq := &data.WithdrawReq{OrderID: logID, UserID: 102, Amount: 300.00, OrgID: 1}
+		tn := time.Now().Unix()
+		// mock DB data
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(withdrawReq.OrderID).WillReturnRows(sqlmock.NewRows([]string{}))
+		memberRows := sqlmock.NewRows([]string{"id", "org_id", "user_id", "role", "spending_limit", "created_at", "updated_at"}).AddRow(2, 1, 102, data.OrgRoleSpender, 500.00, tn, tn)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,org_id,user_id,role,spending_limit,created_at,updated_at FROM org_members WHERE org_id = $1 AND user_id = $2")).
+			WithArgs(withdrawReq.OrgID, withdrawReq.UserID).WillReturnRows(memberRows)
+		walletRows := sqlmock.NewRows([]string{"id", "user_id", "org_id", "name", "balance", "credit_limit", "held", "product", "created_at", "updated_at"}).AddRow(9, 0, 1, data.DefaultPocket, 2000.00, 0, 0, "", tn, tn)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE org_id = $1 AND user_id = 0 AND name = $2")).
+			WithArgs(withdrawReq.OrgID, data.DefaultPocket).WillReturnRows(walletRows)
+		expectWalletLock(mock, 9)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(SUM(amount), 0) FROM transactions WHERE wallet_id = $1 AND actor_user_id = $2 AND tx_type IN ($3, $4) AND created_at >= $5")).
+			WithArgs(9, withdrawReq.UserID, data.TxTypeWithdraw, data.TxTypeTransferOut, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(200.00))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET balance = wallets.balance - $1, updated_at = $2 WHERE id = $3")).
+			WithArgs(withdrawReq.Amount, tn, 9).WillReturnResult(sqlmock.NewResult(1, 1))
+		expectChainHead(mock, 9)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions (order_id, user_id, wallet_id, tx_type, amount, related_user_id, actor_user_id, created_at, updated_at, prev_hash, hash) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)")).
+			WithArgs(withdrawReq.OrderID, 0, 9, data.TxTypeWithdraw, withdrawReq.Amount, 0, withdrawReq.UserID, tn, tn, "", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		expectChainHeadUpdate(mock, 9)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO outbox")).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
+		rsp, err := walletService.Withdraw(withdrawReq)
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("case2: org withdraw fail-[spender over daily limit]", func(t *testing.T) {
+		logID := util.Uniqid()
+		ctx := context.Background()
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, "withdraw:"+logID, 5)
+		withdrawReq := &data.WithdrawReq{OrderID: logID, UserID: 102, Amount: 300.01, OrgID: 1}
+		tn := time.Now().Unix()
+		// mock DB data
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(withdrawReq.OrderID).WillReturnRows(sqlmock.NewRows([]string{}))
+		memberRows := sqlmock.NewRows([]string{"id", "org_id", "user_id", "role", "spending_limit", "created_at", "updated_at"}).AddRow(2, 1, 102, data.OrgRoleSpender, 500.00, tn, tn)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,org_id,user_id,role,spending_limit,created_at,updated_at FROM org_members WHERE org_id = $1 AND user_id = $2")).
+			WithArgs(withdrawReq.OrgID, withdrawReq.UserID).WillReturnRows(memberRows)
+		walletRows := sqlmock.NewRows([]string{"id", "user_id", "org_id", "name", "balance", "credit_limit", "held", "product", "created_at", "updated_at"}).AddRow(9, 0, 1, data.DefaultPocket, 2000.00, 0, 0, "", tn, tn)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE org_id = $1 AND user_id = 0 AND name = $2")).
+			WithArgs(withdrawReq.OrgID, data.DefaultPocket).WillReturnRows(walletRows)
+		expectWalletLock(mock, 9)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(SUM(amount), 0) FROM transactions WHERE wallet_id = $1 AND actor_user_id = $2 AND tx_type IN ($3, $4) AND created_at >= $5")).
+			WithArgs(9, withdrawReq.UserID, data.TxTypeWithdraw, data.TxTypeTransferOut, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(200.00))
+		mock.ExpectRollback()
+
+		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
+		rsp, err := walletService.Withdraw(withdrawReq)
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodeSpendingLimit, rsp.Code)
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("case3: org withdraw fail-[viewer can not debit]", func(t *testing.T) {
+		logID := util.Uniqid()
+		ctx := context.Background()
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, "withdraw:"+logID, 5)
+		withdrawReq := &data.WithdrawReq{OrderID: logID, UserID: 104, Amount: 1.00, OrgID: 1}
+		tn := time.Now().Unix()
+		// mock DB data
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(withdrawReq.OrderID).WillReturnRows(sqlmock.NewRows([]string{}))
+		memberRows := sqlmock.NewRows([]string{"id", "org_id", "user_id", "role", "spending_limit", "created_at", "updated_at"}).AddRow(3, 1, 104, data.OrgRoleViewer, 0, tn, tn)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,org_id,user_id,role,spending_limit,created_at,updated_at FROM org_members WHERE org_id = $1 AND user_id = $2")).
+			WithArgs(withdrawReq.OrgID, withdrawReq.UserID).WillReturnRows(memberRows)
+		mock.ExpectRollback()
+
+		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
+		rsp, err := walletService.Withdraw(withdrawReq)
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodePermissionDenied, rsp.Code)
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
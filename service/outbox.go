@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"simplewallet/data"
+	"simplewallet/model"
+	"simplewallet/service/dao"
+	"simplewallet/util"
+	"time"
+)
+
+// DefaultOutboxBatch is how many events of a shard one relay run publishes at most
+const DefaultOutboxBatch int32 = 100
+
+// recordEvent writes the event of a balance change to the outbox, in the unit of work of the change:
+// it is published once the change committed, and never when it rolled back
+func recordEvent(tx dao.Repos, eventType string, trans *model.Transactions) error {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	event := &data.WalletEvent{
+		EventID:     hex.EncodeToString(id),
+		EventType:   eventType,
+		OrderID:     trans.OrderID,
+		UserID:      trans.UserID,
+		WalletID:    trans.WalletID,
+		Amount:      trans.Amount,
+		ToUserID:    trans.RelatedUserID,
+		ActorUserID: trans.ActorUserID,
+		CreatedAt:   time.Now().Unix(),
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return tx.Outbox().InsertEvent(&model.OutboxEvent{EventID: event.EventID, EventType: eventType, OrderID: trans.OrderID, UserID: trans.UserID, Payload: string(payload)})
+}
+
+// OutboxRelay publishes the pending events of the outbox of every shard, oldest first.
+// An event is marked sent once it was published, a crash in between publishes it again:
+// delivery is at least once, consumers drop the event ids they already handled.
+type OutboxRelay struct {
+	logID     string
+	ctx       context.Context
+	stores    []dao.Store // the shards, or the one store
+	publisher Publisher
+	batch     int32
+	locker    util.DistributedLock
+}
+
+// NewOutboxRelay returns the relay on the shards of util/db, publishing up to batch events of a shard per run
+func NewOutboxRelay(ctx context.Context, logID string, dbCli *sql.DB, publisher Publisher, batch int32, locker util.DistributedLock) *OutboxRelay {
+	return NewOutboxRelayWithStores(ctx, logID, shardStores(ctx, logID, dbCli), publisher, batch, locker)
+}
+
+func NewOutboxRelayWithStores(ctx context.Context, logID string, stores []dao.Store, publisher Publisher, batch int32, locker util.DistributedLock) *OutboxRelay {
+	if batch <= 0 {
+		batch = DefaultOutboxBatch
+	}
+	return &OutboxRelay{
+		ctx:       ctx,
+		logID:     logID,
+		stores:    stores,
+		publisher: publisher,
+		batch:     batch,
+		locker:    locker,
+	}
+}
+
+// Run publishes one batch of pending events of every shard, returns how many were sent. The lock keeps
+// a second relay from publishing the same events. A failed publish stops the batch of its shard, so the
+// events of a shard are published in order, and it is published again on the next run.
+func (r *OutboxRelay) Run() (sent int, err error) {
+	if err = r.locker.Lock(); err != nil {
+		return 0, err
+	}
+	defer func() {
+		if errt := r.locker.UnLock(); errt != nil && err == nil {
+			err = errt
+		}
+	}()
+
+	var errs []error
+	for shard, store := range r.stores {
+		n, err := r.runStore(store)
+		sent += n
+		if err != nil {
+			log.Printf("%s|fail to relay outbox events of shard %d:%s\n", r.logID, shard, err.Error())
+			errs = append(errs, err)
+		}
+	}
+	return sent, errors.Join(errs...)
+}
+
+func (r *OutboxRelay) runStore(store dao.Store) (int, error) {
+	outbox := store.Outbox()
+	eventList, err := outbox.GetPendingEventList(r.batch)
+	if err != nil {
+		return 0, err
+	}
+	sent := 0
+	for _, event := range eventList {
+		if err = r.publisher.Publish(r.ctx, event); err != nil {
+			if errt := outbox.MarkEventFailed(event.ID, err.Error()); errt != nil {
+				log.Printf("%s|[%s] fail to record the failed publish:%s\n", r.logID, event.EventID, errt.Error())
+			}
+			return sent, err
+		}
+		// not marked, it is published again on the next run
+		if _, err = outbox.MarkEventSent(event.ID); err != nil {
+			return sent, err
+		}
+		sent++
+	}
+	return sent, nil
+}
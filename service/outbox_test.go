@@ -0,0 +1,176 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"simplewallet/data"
+	"simplewallet/model"
+	"simplewallet/service"
+	"simplewallet/service/dao"
+	"simplewallet/util"
+	"simplewallet/util/db"
+	"simplewallet/util/errcode"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+// failingPublisher fails every publish until it is told otherwise
+type failingPublisher struct {
+	fail      bool
+	published []string
+}
+
+func (p *failingPublisher) Publish(ctx context.Context, event *model.OutboxEvent) error {
+	if p.fail {
+		return errors.New("downstream unavailable")
+	}
+	p.published = append(p.published, event.EventID)
+	return nil
+}
+
+func newOutboxRelay(store dao.Store, publisher service.Publisher) *service.OutboxRelay {
+	logID := util.Uniqid()
+	ctx := context.Background()
+	locker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, "outbox:"+logID, 5)
+	return service.NewOutboxRelayWithStores(ctx, logID, []dao.Store{store}, publisher, 0, locker)
+}
+
+func TestOutboxRelay(t *testing.T) {
+	ConnectDBRedis()
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	defer DisconnectDBRedis()
+
+	deposit := func(t *testing.T, store dao.Store, orderID string, userID int64, amount float64) {
+		rsp, err := newMemoryWalletService(store, "deposit").Deposit(&data.DepositReq{OrderID: orderID, UserID: userID, Amount: amount})
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+	}
+
+	t.Run("case1: relay success-[events published in order, once]", func(t *testing.T) {
+		store := dao.NewMemoryStore()
+		deposit(t, store, "1001", 101, 100.00)
+		rsp, err := newMemoryWalletService(store, "withdraw").Withdraw(&data.WithdrawReq{OrderID: "1002", UserID: 101, Amount: 20.00})
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		rsp, err = newMemoryWalletService(store, "transfer").Transfer(&data.TransferReq{OrderID: "1003", FromUserID: 101, ToUserID: 102, Amount: 30.00})
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+
+		var out bytes.Buffer
+		sent, err := newOutboxRelay(store, service.NewWriterPublisher(&out)).Run()
+		require.Nil(t, err)
+		assert.Equal(t, 3, sent)
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		require.Len(t, lines, 3)
+		events := make([]data.WalletEvent, len(lines))
+		for i, line := range lines {
+			require.Nil(t, json.Unmarshal([]byte(line), &events[i]))
+			assert.NotEmpty(t, events[i].EventID)
+		}
+		assert.Equal(t, data.WalletEvent{EventID: events[0].EventID, EventType: data.EventTypeDeposit, OrderID: "1001", UserID: 101, WalletID: events[0].WalletID, Amount: 100.00, ActorUserID: 101, CreatedAt: events[0].CreatedAt}, events[0])
+		assert.Equal(t, data.EventTypeWithdraw, events[1].EventType)
+		assert.Equal(t, 20.00, events[1].Amount)
+		assert.Equal(t, data.EventTypeTransfer, events[2].EventType)
+		assert.Equal(t, int64(102), events[2].ToUserID)
+		assert.Equal(t, 30.00, events[2].Amount)
+
+		sent, err = newOutboxRelay(store, service.NewWriterPublisher(&out)).Run()
+		require.Nil(t, err)
+		assert.Equal(t, 0, sent, "already sent")
+	})
+
+	t.Run("case2: relay success-[a failed withdraw records no event]", func(t *testing.T) {
+		store := dao.NewMemoryStore()
+		deposit(t, store, "1001", 101, 10.00)
+		rsp, err := newMemoryWalletService(store, "withdraw").Withdraw(&data.WithdrawReq{OrderID: "1002", UserID: 101, Amount: 20.00})
+		require.NotNil(t, err)
+		require.Equal(t, errcode.ErrCodeBalanceNotEnough, rsp.Code)
+
+		eventList, err := store.Outbox().GetPendingEventList(10)
+		require.Nil(t, err)
+		require.Len(t, eventList, 1)
+		assert.Equal(t, data.EventTypeDeposit, eventList[0].EventType)
+	})
+
+	t.Run("case3: relay fail-[publish fails, kept pending and retried]", func(t *testing.T) {
+		store := dao.NewMemoryStore()
+		deposit(t, store, "1001", 101, 100.00)
+		deposit(t, store, "1002", 101, 50.00)
+
+		publisher := &failingPublisher{fail: true}
+		sent, err := newOutboxRelay(store, publisher).Run()
+		assert.NotNil(t, err)
+		assert.Equal(t, 0, sent)
+		eventList, err := store.Outbox().GetPendingEventList(10)
+		require.Nil(t, err)
+		require.Len(t, eventList, 2)
+		assert.Equal(t, int32(1), eventList[0].Attempts)
+		assert.Equal(t, "downstream unavailable", eventList[0].LastError)
+		assert.Equal(t, int32(0), eventList[1].Attempts, "the batch stopped at the first failure")
+
+		publisher.fail = false
+		sent, err = newOutboxRelay(store, publisher).Run()
+		require.Nil(t, err)
+		assert.Equal(t, 2, sent)
+		assert.Equal(t, []string{eventList[0].EventID, eventList[1].EventID}, publisher.published)
+	})
+
+	t.Run("case4: relay fail-[locked by another relay]", func(t *testing.T) {
+		store := dao.NewMemoryStore()
+		deposit(t, store, "1001", 101, 100.00)
+		ctx := context.Background()
+		held := util.NewDistributedLock(ctx, db.GetRedisClient(), "other", "outbox:relay", 5)
+		require.Nil(t, held.Lock())
+		defer held.UnLock()
+
+		locker := util.NewDistributedLock(ctx, db.GetRedisClient(), "relay", "outbox:relay", 5)
+		sent, err := service.NewOutboxRelayWithStores(ctx, "relay", []dao.Store{store}, &failingPublisher{}, 0, locker).Run()
+		assert.NotNil(t, err)
+		assert.Equal(t, 0, sent)
+	})
+
+	t.Run("case5: http publisher success-[event id header, non 2xx fails]", func(t *testing.T) {
+		status := http.StatusOK
+		var gotID, gotType, gotBody string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotID, gotType = r.Header.Get("X-Event-ID"), r.Header.Get("X-Event-Type")
+			var buf bytes.Buffer
+			_, _ = buf.ReadFrom(r.Body)
+			gotBody = buf.String()
+			w.WriteHeader(status)
+		}))
+		defer srv.Close()
+
+		publisher := service.NewHTTPPublisher(srv.URL, time.Second)
+		event := &model.OutboxEvent{EventID: "e1", EventType: data.EventTypeDeposit, Payload: `{"event_id":"e1"}`}
+		require.Nil(t, publisher.Publish(context.Background(), event))
+		assert.Equal(t, "e1", gotID)
+		assert.Equal(t, data.EventTypeDeposit, gotType)
+		assert.Equal(t, `{"event_id":"e1"}`, gotBody)
+
+		status = http.StatusInternalServerError
+		assert.NotNil(t, publisher.Publish(context.Background(), event))
+	})
+
+	t.Run("case6: file publisher success-[events appended as lines]", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "events.jsonl")
+		publisher := service.NewFilePublisher(path)
+		require.Nil(t, publisher.Publish(context.Background(), &model.OutboxEvent{EventID: "e1", Payload: `{"event_id":"e1"}`}))
+		require.Nil(t, publisher.Publish(context.Background(), &model.OutboxEvent{EventID: "e2", Payload: `{"event_id":"e2"}`}))
+		content, err := os.ReadFile(path)
+		require.Nil(t, err)
+		assert.Equal(t, "{\"event_id\":\"e1\"}\n{\"event_id\":\"e2\"}\n", string(content))
+	})
+}
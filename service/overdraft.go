@@ -0,0 +1,27 @@
+package service
+
+import (
+	"simplewallet/model"
+	"simplewallet/service/dao"
+	"simplewallet/util"
+)
+
+// OverdraftHook is called when a debit leaves a wallet with a negative balance.
+// It runs inside the same unit of work as the debit, so interest or fees can
+// be accrued atomically; returning an error rolls the debit back.
+type OverdraftHook interface {
+	OnOverdraft(repos dao.Repos, wallet *model.Wallet, amount float64, newBalance float64) error
+}
+
+// SetOverdraftHook registers the hook used for wallets drawn below zero.
+func (s *WalletService) SetOverdraftHook(hook OverdraftHook) {
+	s.overdraftHook = hook
+}
+
+func (s *WalletService) onOverdraft(repos dao.Repos, wallet *model.Wallet, amount float64) error {
+	newBalance := wallet.Balance - amount
+	if s.overdraftHook == nil || util.CompareFloat(newBalance, 0, 8) >= 0 {
+		return nil
+	}
+	return s.overdraftHook.OnOverdraft(repos, wallet, amount, newBalance)
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"simplewallet/data"
+	"simplewallet/model"
+	"simplewallet/service/dao"
+	"simplewallet/util"
+	"simplewallet/util/errcode"
+)
+
+// pocketName falls back to the main pocket when no pocket is given
+func pocketName(name string) string {
+	if name == "" {
+		return data.DefaultPocket
+	}
+	return name
+}
+
+// Move sets money aside between pockets of the same user. The target pocket is created on first use.
+// Moves are recorded as pocket in/out transactions, so they never count as transfers.
+func (s *WalletService) Move(req *data.MoveReq) (rsp *data.CommRsp, err error) {
+	rsp = &data.CommRsp{Code: errcode.ErrCodeInternalErr, Message: errcode.ErrMsgMap[errcode.ErrCodeInternalErr], LogID: s.logID}
+	defer func() { mapDbError(err, &rsp.Code, &rsp.Message) }()
+
+	err = s.locker.Lock()
+	if err != nil {
+		rsp.Code = errcode.ErrCodeLockFail
+		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
+		return rsp, err
+	}
+	defer func() {
+		if errt := s.locker.UnLock(); errt != nil {
+			rsp.Code = errcode.ErrCodeUnLockFail
+			rsp.Message = errcode.ErrMsgMap[rsp.Code] + errt.Error()
+		}
+	}()
+
+	// a move within one pocket would record a debit and a credit of the same wallet
+	if pocketName(req.FromPocket) == pocketName(req.ToPocket) {
+		err = errors.New("from_pocket and to_pocket must be different")
+		rsp.Code = errcode.ErrCodeBadRequestParam
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return rsp, err
+	}
+
+	err = s.runTx(s.storeFor(req.UserID), rsp, func(tx dao.UnitOfWork) error {
+		// check order_id
+		transDao := tx.Transactions()
+		if code, err := s.checkOrderID(tx, req.UserID, req.OrderID); err != nil {
+			rsp.Code = code
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+
+		// Check balance of source pocket
+		walletDao := tx.Wallets()
+		wallet, err := walletDao.GetWalletByUserID(req.UserID, pocketName(req.FromPocket))
+		if err != nil {
+			rsp.Code = errcode.ErrCodeDbError
+			rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
+			return err
+		}
+		if wallet == nil {
+			err = errors.New("source pocket not exist")
+			rsp.Code = errcode.ErrCodeUserWalletNotExist
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+
+		// Moves never draw on the credit limit, setting borrowed money aside is not allowed
+		if util.CompareFloat(wallet.Balance-wallet.Held, req.Amount, 8) < 0 {
+			err = errors.New("balance not enough")
+			rsp.Code = errcode.ErrCodeBalanceNotEnough
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+
+		// Update source pocket
+		err = walletDao.UpdateWalletBalance(wallet.ID, data.TxTypePocketOut, req.Amount)
+		if err != nil {
+			log.Println("Failed to update source pocket balance" + err.Error())
+			rsp.Code = errcode.ErrCodeDbError
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+
+		// Update target pocket
+		toWalletID, err := walletDao.CreateOrUpdateWallet(req.UserID, pocketName(req.ToPocket), req.Amount)
+		if err != nil {
+			log.Println("Failed to update target pocket balance" + err.Error())
+			rsp.Code = errcode.ErrCodeDbError
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+
+		// Record transactions and the event of the move
+		record := &model.Transactions{OrderID: req.OrderID, UserID: req.UserID, WalletID: wallet.ID, TxType: data.TxTypePocketOut, Amount: req.Amount, RelatedUserID: req.UserID, ActorUserID: req.UserID}
+		err = transDao.InsertTransaction(record)
+		if err != nil {
+			log.Println("Failed to record source pocket transaction" + err.Error())
+			rsp.Code = errcode.ErrCodeDbError
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+		err = transDao.InsertTransaction(&model.Transactions{OrderID: req.OrderID, UserID: req.UserID, WalletID: toWalletID, TxType: data.TxTypePocketIn, Amount: req.Amount, RelatedUserID: req.UserID, ActorUserID: req.UserID})
+		if err != nil {
+			log.Println("Failed to record target pocket transaction" + err.Error())
+			rsp.Code = errcode.ErrCodeDbError
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+		if err = recordEvent(tx, data.EventTypeMove, record); err != nil {
+			log.Println("Failed to record move event" + err.Error())
+			rsp.Code = errcode.ErrCodeDbError
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return rsp, err
+	}
+
+	s.written(req.UserID)
+	rsp.Code = errcode.ErrCodeSuccess
+	rsp.Message = "Move successful"
+	return rsp, nil
+}
@@ -0,0 +1,123 @@
+package service_test
+
+import (
+	"context"
+	"regexp"
+	"simplewallet/data"
+	"simplewallet/service"
+	"simplewallet/util"
+	"simplewallet/util/db"
+	"simplewallet/util/errcode"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
+)
+
+func TestMove(t *testing.T) {
+	ConnectDBRedis()
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	defer DisconnectDBRedis()
+
+	mockDBCli := db.GetDbClientMock()
+	mock := db.GetSqlMock()
+	t.Run("case1: move success-[target pocket not exist]", func(t *testing.T) {
+		logID := util.Uniqid()
+		ctx := context.Background()
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, "move:"+logID, 5)
+		moveReq := &data.MoveReq{OrderID: logID, UserID: 101, FromPocket: data.DefaultPocket, ToPocket: "rent", Amount: 300.00}
+		tn := time.Now().Unix()
+		// mock DB data
+		mock.ExpectBegin()
+		transRows := sqlmock.NewRows([]string{})
+		mock.ExpectQuery("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(moveReq.OrderID).WillReturnRows(transRows)
+		walletRows := sqlmock.NewRows([]string{"id", "user_id", "org_id", "name", "balance", "credit_limit", "held", "product", "created_at", "updated_at"}).AddRow(1, moveReq.UserID, 0, data.DefaultPocket, 1000.00, 0, 0, "", tn, tn)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 AND name = $2")).WithArgs(moveReq.UserID, moveReq.FromPocket).WillReturnRows(walletRows)
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET balance = wallets.balance - $1, updated_at = $2 WHERE id = $3")).
+			WithArgs(moveReq.Amount, tn, 1).WillReturnResult(sqlmock.NewResult(1, 1))
+
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 AND name = $2")).WithArgs(moveReq.UserID, moveReq.ToPocket).WillReturnRows(sqlmock.NewRows([]string{}))
+		mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO wallets (user_id, name, balance, created_at, updated_at) VALUES ($1, $2, $3, $4, $5) RETURNING id")).
+			WithArgs(moveReq.UserID, moveReq.ToPocket, moveReq.Amount, tn, tn).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+
+		expectChainHead(mock, 1)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions (order_id, user_id, wallet_id, tx_type, amount, related_user_id, actor_user_id, created_at, updated_at, prev_hash, hash) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)")).
+			WithArgs(moveReq.OrderID, moveReq.UserID, 1, data.TxTypePocketOut, moveReq.Amount, moveReq.UserID, moveReq.UserID, tn, tn, "", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		expectChainHeadUpdate(mock, 1)
+		expectChainHead(mock, 2)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions (order_id, user_id, wallet_id, tx_type, amount, related_user_id, actor_user_id, created_at, updated_at, prev_hash, hash) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)")).
+			WithArgs(moveReq.OrderID, moveReq.UserID, 2, data.TxTypePocketIn, moveReq.Amount, moveReq.UserID, moveReq.UserID, tn, tn, "", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		expectChainHeadUpdate(mock, 2)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO outbox")).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
+		rsp, err := walletService.Move(moveReq)
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("case2: move fail-[credit limit can not be moved]", func(t *testing.T) {
+		logID := util.Uniqid()
+		ctx := context.Background()
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, "move:"+logID, 5)
+		moveReq := &data.MoveReq{OrderID: logID, UserID: 101, FromPocket: data.DefaultPocket, ToPocket: "savings", Amount: 300.00}
+		tn := time.Now().Unix()
+		// mock DB data
+		mock.ExpectBegin()
+		transRows := sqlmock.NewRows([]string{})
+		mock.ExpectQuery("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(moveReq.OrderID).WillReturnRows(transRows)
+		walletRows := sqlmock.NewRows([]string{"id", "user_id", "org_id", "name", "balance", "credit_limit", "held", "product", "created_at", "updated_at"}).AddRow(1, moveReq.UserID, 0, data.DefaultPocket, 100.00, 1000.00, 0, "", tn, tn)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 AND name = $2")).WithArgs(moveReq.UserID, moveReq.FromPocket).WillReturnRows(walletRows)
+		mock.ExpectRollback()
+
+		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
+		rsp, err := walletService.Move(moveReq)
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodeBalanceNotEnough, rsp.Code)
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("case3: move fail-[source pocket not exist]", func(t *testing.T) {
+		logID := util.Uniqid()
+		ctx := context.Background()
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, "move:"+logID, 5)
+		moveReq := &data.MoveReq{OrderID: logID, UserID: 101, FromPocket: "rent", ToPocket: data.DefaultPocket, Amount: 300.00}
+		// mock DB data
+		mock.ExpectBegin()
+		transRows := sqlmock.NewRows([]string{})
+		mock.ExpectQuery("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(moveReq.OrderID).WillReturnRows(transRows)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 AND name = $2")).WithArgs(moveReq.UserID, moveReq.FromPocket).WillReturnRows(sqlmock.NewRows([]string{}))
+		mock.ExpectRollback()
+
+		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
+		rsp, err := walletService.Move(moveReq)
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodeUserWalletNotExist, rsp.Code)
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("case4: move fail-[same pocket, nothing written]", func(t *testing.T) {
+		logID := util.Uniqid()
+		ctx := context.Background()
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, "move:"+logID, 5)
+		moveReq := &data.MoveReq{OrderID: logID, UserID: 101, FromPocket: "", ToPocket: data.DefaultPocket, Amount: 300.00}
+
+		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
+		rsp, err := walletService.Move(moveReq)
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodeBadRequestParam, rsp.Code)
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"simplewallet/model"
+	"sync"
+	"time"
+)
+
+// Publisher delivers an outbox event downstream. It returns nil only once the event was delivered,
+// the relay marks it sent then.
+type Publisher interface {
+	Publish(ctx context.Context, event *model.OutboxEvent) error
+}
+
+// MultiPublisher delivers each event to every publisher in order, the event is delivered once all of them
+// delivered it. A failure publishes it again to all of them on the next run: the ones before the failed one
+// get it twice, so the idempotent ones, e.g. the WebhookPublisher, go first.
+type MultiPublisher struct {
+	publishers []Publisher
+}
+
+func NewMultiPublisher(publishers ...Publisher) *MultiPublisher {
+	return &MultiPublisher{publishers: publishers}
+}
+
+func (p *MultiPublisher) Publish(ctx context.Context, event *model.OutboxEvent) error {
+	for _, publisher := range p.publishers {
+		if err := publisher.Publish(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriterPublisher writes each event as a json line, e.g. to os.Stdout.
+type WriterPublisher struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterPublisher(w io.Writer) *WriterPublisher {
+	return &WriterPublisher{w: w}
+}
+
+func (p *WriterPublisher) Publish(ctx context.Context, event *model.OutboxEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err := io.WriteString(p.w, event.Payload+"\n")
+	return err
+}
+
+// FilePublisher appends each event as a json line to a file, synced before the event counts as delivered.
+// The file is opened for every event, so it can be rotated.
+type FilePublisher struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFilePublisher(path string) *FilePublisher {
+	return &FilePublisher{path: path}
+}
+
+func (p *FilePublisher) Publish(ctx context.Context, event *model.OutboxEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f, err := os.OpenFile(p.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.WriteString(event.Payload + "\n"); err == nil {
+		err = f.Sync()
+	}
+	if errt := f.Close(); err == nil {
+		err = errt
+	}
+	return err
+}
+
+// HTTPPublisher posts each event as json to a url, any 2xx status delivers it.
+// The X-Event-ID header carries the event id for the receiver to drop duplicates.
+type HTTPPublisher struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPPublisher returns the publisher to the url, a request taking longer than timeout fails
+func NewHTTPPublisher(url string, timeout time.Duration) *HTTPPublisher {
+	return &HTTPPublisher{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+func (p *HTTPPublisher) Publish(ctx context.Context, event *model.OutboxEvent) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewBufferString(event.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-ID", event.EventID)
+	req.Header.Set("X-Event-Type", event.EventType)
+	rsp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(rsp.Body, 64<<10))
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		return fmt.Errorf("publish event %s: http status %d", event.EventID, rsp.StatusCode)
+	}
+	return nil
+}
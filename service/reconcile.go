@@ -0,0 +1,232 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"simplewallet/data"
+	"simplewallet/model"
+	"simplewallet/service/dao"
+	"simplewallet/util/db"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// ReconcileBatch is how many wallets a page of the reconciliation reads, with the sums of their transactions
+	ReconcileBatch int32 = 500
+	// a wallet written to on every attempt to fix it is left to the next run
+	reconcileFixAttempts = 3
+)
+
+// BalanceDrift is a wallet whose balance is not the one its transactions add up to
+type BalanceDrift struct {
+	Shard  int
+	Wallet *model.Wallet // as read with its transactions
+	Ledger float64       // the balance rebuilt from the transactions
+	Fixed  bool          // the balance was set to Ledger
+	Err    error         // why it was not fixed
+}
+
+// Drift is what the balance has more than the transactions add up to, negative when less
+func (d *BalanceDrift) Drift() float64 {
+	return decimal.NewFromFloat(d.Wallet.Balance).Sub(decimal.NewFromFloat(d.Ledger)).Round(8).InexactFloat64()
+}
+
+type ReconcileResult struct {
+	Checked int64 // wallets
+	Drifted int64
+	Fixed   int64
+}
+
+// ReconcileService rebuilds the balance of every wallet from its transactions, the archived ones included,
+// and compares it to wallets.balance, on every shard. It reads the wallets page by page in id order, each page
+// with the sums of its transactions in one statement, so it holds no lock and needs no quiet database: a write
+// committing meanwhile is in both or in neither. With fix, a drifted balance is set to the rebuilt one only
+// while it still is the balance read, a wallet written to since is read again.
+type ReconcileService struct {
+	logID        string
+	ctx          context.Context
+	stores       []dao.Store // the shards, or the one store
+	batch        int32
+	fix          bool
+	balanceCache *BalanceCache
+	bus          EventBus
+	actor        *AuditActor // of the audit records of the fixes
+}
+
+func NewReconcileService(ctx context.Context, logID string, dbCli *sql.DB, batch int32, fix bool) *ReconcileService {
+	s := NewReconcileServiceWithStores(ctx, logID, shardStores(ctx, logID, dbCli), batch, fix).WithEventBus(eventBus)
+	if ttl := db.GetBalanceCacheTTL(); ttl > 0 {
+		s.WithBalanceCache(NewBalanceCache(db.GetRedisClient(), ttl))
+	}
+	return s
+}
+
+func NewReconcileServiceWithStores(ctx context.Context, logID string, stores []dao.Store, batch int32, fix bool) *ReconcileService {
+	if batch <= 0 {
+		batch = ReconcileBatch
+	}
+	return &ReconcileService{
+		ctx:    ctx,
+		logID:  logID,
+		stores: stores,
+		batch:  batch,
+		fix:    fix,
+		actor:  &AuditActor{Name: "reconcile", LogID: logID},
+	}
+}
+
+// WithAuditActor records the fixes in the audit trail as done by the actor, the reconcile command by default
+func (s *ReconcileService) WithAuditActor(actor *AuditActor) *ReconcileService {
+	s.actor = actor
+	return s
+}
+
+// WithBalanceCache drops the cached balance of the users whose wallet was fixed
+func (s *ReconcileService) WithBalanceCache(cache *BalanceCache) *ReconcileService {
+	s.balanceCache = cache
+	return s
+}
+
+// WithEventBus notifies the streams of the users whose wallet was fixed
+func (s *ReconcileService) WithEventBus(bus EventBus) *ReconcileService {
+	s.bus = bus
+	return s
+}
+
+// Run checks every wallet and reports each drifted one once, fixed or not. A shard failing to be read
+// is logged and the others are still checked, the failures are returned joined. With fix, nothing is checked
+// while a shard has transactions without wallet_id: they are in no ledger, a fix would drop them from the balance.
+func (s *ReconcileService) Run(report func(drift *BalanceDrift)) (*ReconcileResult, error) {
+	result := &ReconcileResult{}
+	if s.fix {
+		for shard, store := range s.stores {
+			n, err := store.Transactions().CountTransactionsWithoutWallet()
+			if err != nil {
+				return result, err
+			}
+			if n > 0 {
+				return result, fmt.Errorf("shard %d has %d transactions without wallet_id, migrate up to backfill them before fixing", shard, n)
+			}
+		}
+	}
+	var errs []error
+	for shard, store := range s.stores {
+		if err := s.runStore(shard, store, result, report); err != nil {
+			log.Printf("%s|fail to reconcile balances of shard %d:%s\n", s.logID, shard, err.Error())
+			errs = append(errs, err)
+		}
+	}
+	return result, errors.Join(errs...)
+}
+
+func (s *ReconcileService) runStore(shard int, store dao.Store, result *ReconcileResult, report func(drift *BalanceDrift)) error {
+	lastID := int64(0)
+	for {
+		ledgerList, err := store.Wallets().GetWalletLedgerList(lastID, s.batch)
+		if err != nil {
+			return err
+		}
+		for _, ledger := range ledgerList {
+			result.Checked++
+			drift, err := s.reconcileWallet(store, ledger)
+			if err != nil {
+				return err
+			}
+			if drift == nil {
+				continue
+			}
+			drift.Shard = shard
+			result.Drifted++
+			if drift.Fixed {
+				result.Fixed++
+			}
+			report(drift)
+		}
+		if len(ledgerList) < int(s.batch) {
+			return nil
+		}
+		lastID = ledgerList[len(ledgerList)-1].Wallet.ID
+	}
+}
+
+// reconcileWallet returns the drift of the wallet, nil when there is none
+func (s *ReconcileService) reconcileWallet(store dao.Store, ledger *dao.WalletLedger) (*BalanceDrift, error) {
+	for attempt := 1; ; attempt++ {
+		rebuilt := ledgerBalance(ledger.Sums)
+		if rebuilt.Equal(decimal.NewFromFloat(ledger.Wallet.Balance).Round(8)) {
+			return nil, nil
+		}
+		drift := &BalanceDrift{Wallet: ledger.Wallet, Ledger: rebuilt.InexactFloat64()}
+		if !s.fix {
+			return drift, nil
+		}
+		if attempt > reconcileFixAttempts {
+			drift.Err = errors.New("wallet written to on every attempt to fix it")
+			return drift, nil
+		}
+		reset, err := s.resetBalance(store, ledger.Wallet, drift.Ledger)
+		if err != nil {
+			drift.Err = err
+			return drift, nil
+		}
+		if reset {
+			drift.Fixed = true
+			log.Printf("%s|[%d] balance %v rebuilt to %v\n", s.logID, ledger.Wallet.ID, ledger.Wallet.Balance, drift.Ledger)
+			s.written(ledger.Wallet.UserID)
+			return drift, nil
+		}
+		// written to since it was read, read it again
+		ledgerList, err := store.Wallets().GetWalletLedgerList(ledger.Wallet.ID-1, 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(ledgerList) == 0 || ledgerList[0].Wallet.ID != ledger.Wallet.ID {
+			return nil, nil
+		}
+		ledger = ledgerList[0]
+	}
+}
+
+// resetBalance sets the balance of the wallet to the rebuilt one while it still is the balance read,
+// and records it in the audit trail in the same unit of work
+func (s *ReconcileService) resetBalance(store dao.Store, wallet *model.Wallet, rebuilt float64) (bool, error) {
+	var reset bool
+	err := dao.NewTxRunner(s.ctx, s.logID, store, dao.RetryPolicy{}).Run(func(tx dao.UnitOfWork) error {
+		var err error
+		reset, err = tx.Wallets().ResetWalletBalance(wallet.ID, wallet.Balance, rebuilt)
+		if err != nil || !reset {
+			return err
+		}
+		return recordAudit(tx, s.actor, data.AuditActionReconcileFix, data.AuditTargetWallet, wallet.ID,
+			map[string]float64{"balance": wallet.Balance}, map[string]float64{"balance": rebuilt}, "balance rebuilt from the transactions")
+	})
+	return reset, err
+}
+
+// written tells the cache and the streams of the owner that the balance changed, the shared wallets have neither
+func (s *ReconcileService) written(userID int64) {
+	if userID == 0 {
+		return
+	}
+	ctx, cancel := afterCommit(s.ctx)
+	defer cancel()
+	s.balanceCache.Invalidate(ctx, userID)
+	if s.bus != nil {
+		if err := s.bus.Publish(ctx, userID); err != nil {
+			log.Println("Failed to notify the streams" + err.Error())
+		}
+	}
+}
+
+// ledgerBalance adds the sums of the transactions of a wallet by tx_type up to its balance
+func ledgerBalance(sums map[int32]float64) decimal.Decimal {
+	balance := decimal.Zero
+	for txType, amount := range sums {
+		balance = balance.Add(decimal.NewFromFloat(amount).Mul(decimal.NewFromInt(int64(data.TxTypeSign(txType)))))
+	}
+	return balance.Round(8)
+}
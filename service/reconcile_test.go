@@ -0,0 +1,201 @@
+package service_test
+
+import (
+	"context"
+	"simplewallet/data"
+	"simplewallet/model"
+	"simplewallet/service"
+	"simplewallet/service/dao"
+	"simplewallet/util"
+	"simplewallet/util/db"
+	"simplewallet/util/errcode"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+// racingStore runs write right before the first unit of work begins, like a request committing in between
+type racingStore struct {
+	*dao.MemoryStore
+	write func()
+}
+
+func (s *racingStore) Begin() (dao.UnitOfWork, error) {
+	if write := s.write; write != nil {
+		s.write = nil
+		write()
+	}
+	return s.MemoryStore.Begin()
+}
+
+func runReconcile(t *testing.T, store dao.Store, batch int32, fix bool) (*service.ReconcileResult, []*service.BalanceDrift) {
+	drifts := make([]*service.BalanceDrift, 0)
+	result, err := service.NewReconcileServiceWithStores(context.Background(), util.Uniqid(), []dao.Store{store}, batch, fix).Run(func(drift *service.BalanceDrift) {
+		drifts = append(drifts, drift)
+	})
+	require.Nil(t, err)
+	return result, drifts
+}
+
+func TestReconcile(t *testing.T) {
+	ConnectDBRedis()
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	defer DisconnectDBRedis()
+
+	// seed writes the wallets of 101 (main and savings) and 102 with every kind of transaction
+	seed := func(t *testing.T, store dao.Store) {
+		for _, req := range []*data.DepositReq{{OrderID: "1001", UserID: 101, Amount: 100.10}, {OrderID: "1002", UserID: 102, Amount: 50.00}} {
+			rsp, err := newMemoryWalletService(store, "deposit").Deposit(req)
+			require.Nil(t, err)
+			require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		}
+		rsp, err := newMemoryWalletService(store, "withdraw").Withdraw(&data.WithdrawReq{OrderID: "1003", UserID: 101, Amount: 0.20})
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		rsp, err = newMemoryWalletService(store, "transfer").Transfer(&data.TransferReq{OrderID: "1004", FromUserID: 102, ToUserID: 101, Amount: 20.00})
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		rsp, err = newMemoryWalletService(store, "move").Move(&data.MoveReq{OrderID: "1005", UserID: 101, FromPocket: data.DefaultPocket, ToPocket: "savings", Amount: 30.00})
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+	}
+
+	t.Run("case1: reconcile success-[balances add up, archived transactions included]", func(t *testing.T) {
+		store := dao.NewMemoryStore()
+		seed(t, store)
+		_, err := newArchiveService([]dao.Store{store}, 30*24*time.Hour).Run(time.Now().AddDate(0, 3, 0))
+		require.Nil(t, err)
+		rsp, err := newMemoryWalletService(store, "deposit").Deposit(&data.DepositReq{OrderID: "1006", UserID: 102, Amount: 1.00})
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+
+		result, drifts := runReconcile(t, store, 2, false)
+		assert.Equal(t, &service.ReconcileResult{Checked: 3}, result)
+		assert.Empty(t, drifts)
+	})
+
+	t.Run("case2: reconcile success-[drift reported, fixed with fix]", func(t *testing.T) {
+		store := dao.NewMemoryStore()
+		seed(t, store)
+		wallet101, err := store.Wallets().GetWalletByUserID(101, data.DefaultPocket)
+		require.Nil(t, err)
+		wallet102, err := store.Wallets().GetWalletByUserID(102, data.DefaultPocket)
+		require.Nil(t, err)
+		// balances changed without a transaction
+		require.Nil(t, store.Wallets().UpdateWalletBalance(wallet101.ID, data.TxTypeDeposit, 5.00))
+		require.Nil(t, store.Wallets().UpdateWalletBalance(wallet102.ID, data.TxTypeWithdraw, 0.01))
+
+		result, drifts := runReconcile(t, store, 1, false)
+		assert.Equal(t, &service.ReconcileResult{Checked: 3, Drifted: 2}, result)
+		require.Len(t, drifts, 2)
+		assert.Equal(t, wallet101.ID, drifts[0].Wallet.ID)
+		assert.Equal(t, 94.90, drifts[0].Wallet.Balance)
+		assert.Equal(t, 89.90, drifts[0].Ledger)
+		assert.Equal(t, 5.00, drifts[0].Drift())
+		assert.False(t, drifts[0].Fixed)
+		assert.Equal(t, wallet102.ID, drifts[1].Wallet.ID)
+		assert.Equal(t, -0.01, drifts[1].Drift())
+		wallet, err := store.Wallets().GetWalletByID(wallet101.ID)
+		require.Nil(t, err)
+		assert.Equal(t, 94.90, wallet.Balance, "nothing fixed")
+
+		// the drifted balance is cached and streamed, the fix replaces both
+		cache := service.NewBalanceCache(db.GetRedisClient(), time.Minute)
+		bus := service.NewLocalBus()
+		notices, cancel := bus.Subscribe(101)
+		defer cancel()
+		balanceRsp, err := newMemoryWalletService(store, "").WithBalanceCache(cache).GetBalance(&data.GetBalanceReq{UserID: 101})
+		require.Nil(t, err)
+		assert.Equal(t, 94.90, balanceRsp.Data.Balance)
+		drifts = make([]*service.BalanceDrift, 0)
+		result, err = service.NewReconcileServiceWithStores(context.Background(), util.Uniqid(), []dao.Store{store}, 0, true).
+			WithBalanceCache(cache).WithEventBus(bus).Run(func(drift *service.BalanceDrift) {
+			drifts = append(drifts, drift)
+		})
+		require.Nil(t, err)
+		assert.Equal(t, &service.ReconcileResult{Checked: 3, Drifted: 2, Fixed: 2}, result)
+		require.Len(t, drifts, 2)
+		assert.True(t, drifts[0].Fixed)
+		assert.Nil(t, drifts[0].Err)
+		assert.Len(t, notices, 1)
+		balanceRsp, err = newMemoryWalletService(store, "").WithBalanceCache(cache).GetBalance(&data.GetBalanceReq{UserID: 101})
+		require.Nil(t, err)
+		assert.Equal(t, 89.90, balanceRsp.Data.Balance)
+		wallet, err = store.Wallets().GetWalletByID(wallet102.ID)
+		require.Nil(t, err)
+		assert.Equal(t, 30.00, wallet.Balance)
+
+		result, drifts = runReconcile(t, store, 0, false)
+		assert.Equal(t, &service.ReconcileResult{Checked: 3}, result)
+		assert.Empty(t, drifts)
+	})
+
+	t.Run("case3: reconcile fail-[rebuilt balance below the credit limit, not fixed]", func(t *testing.T) {
+		store := dao.NewMemoryStore()
+		seed(t, store)
+		wallet, err := store.Wallets().GetWalletByUserID(102, data.DefaultPocket)
+		require.Nil(t, err)
+		// a debit recorded without the balance
+		require.Nil(t, store.Transactions().InsertTransaction(&model.Transactions{OrderID: "2001", UserID: 102, WalletID: wallet.ID, TxType: data.TxTypeWithdraw, Amount: 40.00, ActorUserID: 102}))
+
+		result, drifts := runReconcile(t, store, 0, true)
+		assert.Equal(t, &service.ReconcileResult{Checked: 3, Drifted: 1}, result)
+		require.Len(t, drifts, 1)
+		assert.Equal(t, -10.00, drifts[0].Ledger)
+		assert.False(t, drifts[0].Fixed)
+		assert.NotNil(t, drifts[0].Err)
+		wallet, err = store.Wallets().GetWalletByID(wallet.ID)
+		require.Nil(t, err)
+		assert.Equal(t, 30.00, wallet.Balance)
+	})
+
+	t.Run("case4: reconcile success-[written to while fixing, read again]", func(t *testing.T) {
+		store := &racingStore{MemoryStore: dao.NewMemoryStore()}
+		seed(t, store.MemoryStore)
+		wallet, err := store.Wallets().GetWalletByUserID(101, data.DefaultPocket)
+		require.Nil(t, err)
+		require.Nil(t, store.MemoryStore.Wallets().UpdateWalletBalance(wallet.ID, data.TxTypeDeposit, 5.00))
+		store.write = func() {
+			rsp, err := newMemoryWalletService(store.MemoryStore, "deposit").Deposit(&data.DepositReq{OrderID: "2001", UserID: 101, Amount: 10.00})
+			require.Nil(t, err)
+			require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		}
+
+		result, drifts := runReconcile(t, store, 0, true)
+		assert.Equal(t, &service.ReconcileResult{Checked: 3, Drifted: 1, Fixed: 1}, result)
+		require.Len(t, drifts, 1)
+		assert.Equal(t, 104.90, drifts[0].Wallet.Balance, "read again after the deposit")
+		assert.Equal(t, 99.90, drifts[0].Ledger)
+		wallet, err = store.Wallets().GetWalletByID(wallet.ID)
+		require.Nil(t, err)
+		assert.Equal(t, 99.90, wallet.Balance, "the deposit kept")
+	})
+
+	t.Run("case5: reconcile fail-[transactions without wallet_id, fix refused]", func(t *testing.T) {
+		store := dao.NewMemoryStore()
+		seed(t, store)
+		// recorded before transactions had a wallet_id
+		require.Nil(t, store.Transactions().InsertTransaction(&model.Transactions{OrderID: "1000", UserID: 101, TxType: data.TxTypeDeposit, Amount: 7.00, ActorUserID: 101}))
+		wallet101, err := store.Wallets().GetWalletByUserID(101, data.DefaultPocket)
+		require.Nil(t, err)
+		require.Nil(t, store.Wallets().UpdateWalletBalance(wallet101.ID, data.TxTypeDeposit, 7.00))
+
+		result, drifts := runReconcile(t, store, 10, false)
+		assert.Equal(t, int64(1), result.Drifted, "reported without the fix")
+		require.Len(t, drifts, 1)
+		assert.Equal(t, 7.00, drifts[0].Drift())
+
+		result, err = service.NewReconcileServiceWithStores(context.Background(), util.Uniqid(), []dao.Store{store}, 10, true).Run(func(drift *service.BalanceDrift) {
+			assert.Fail(t, "nothing is checked")
+		})
+		require.NotNil(t, err)
+		assert.Contains(t, err.Error(), "1 transactions without wallet_id")
+		assert.Equal(t, &service.ReconcileResult{}, result)
+		wallet, err := store.Wallets().GetWalletByID(wallet101.ID)
+		require.Nil(t, err)
+		assert.Equal(t, 96.90, wallet.Balance, "not fixed")
+	})
+}
@@ -9,103 +9,278 @@ import (
 	"simplewallet/model"
 	"simplewallet/service/dao"
 	"simplewallet/util"
+	"simplewallet/util/db"
 	"simplewallet/util/errcode"
 	"time"
 )
 
 type WalletService struct {
-	logID  string
-	ctx    context.Context
-	dbCli  *sql.DB
-	locker util.DistributedLock
+	logID         string
+	ctx           context.Context
+	store         dao.Store
+	retry         dao.RetryPolicy
+	replicas      *db.ReplicaPool
+	shards        *dao.ShardedStore
+	archive       bool // the history reads transactions_archive too
+	balanceCache  *BalanceCache
+	bus           EventBus
+	locker        util.DistributedLock
+	overdraftHook OverdraftHook
+	actor         *AuditActor // the admin acting, nil for customers
+	approvals     bool        // transfers above the spending limit wait for approvals instead of failing
 }
 
+// NewWalletService returns the wallet service on the sql store of the db client, postgres or sqlite as configured.
+// Balance and history reads go to the replicas of util/db when there are, users to their shard when it is sharded.
+// The history reads the archive when the transactions are archived, balances are cached in redis when configured.
 func NewWalletService(ctx context.Context, logID string, dbCli *sql.DB, locker util.DistributedLock) *WalletService {
-	return &WalletService{
-		ctx:    ctx,
-		logID:  logID,
-		dbCli:  dbCli,
-		locker: locker,
+	store := newSqlStore(ctx, logID, dbCli)
+	s := NewWalletServiceWithStore(ctx, logID, store, locker).WithReplicas(db.GetReplicaPool()).WithShards(newShardedStore(ctx, logID, store)).
+		WithArchive(db.GetArchiveRetention() > 0)
+	if ttl := db.GetBalanceCacheTTL(); ttl > 0 {
+		s.WithBalanceCache(NewBalanceCache(db.GetRedisClient(), ttl))
 	}
+	return s.WithEventBus(eventBus)
 }
 
-func (s *WalletService) Deposit(req *data.DepositReq) (*data.CommRsp, error) {
-	rsp := &data.CommRsp{Code: errcode.ErrCodeInternalErr, Message: errcode.ErrMsgMap[errcode.ErrCodeInternalErr], LogID: s.logID}
+// newSqlStore returns the store on the db client with the driver and timeouts of util/db
+func newSqlStore(ctx context.Context, logID string, dbCli *sql.DB) *dao.SqlStore {
+	query, exec, tx := db.GetDbTimeouts()
+	return dao.NewSqlStore(ctx, logID, dbCli, db.GetDbDriver()).WithTimeouts(dao.Timeouts{Query: query, Exec: exec, Tx: tx}).WithIsolation(db.GetDbIsolation())
+}
 
-	err := s.locker.Lock()
-	if err != nil {
-		rsp.Code = errcode.ErrCodeLockFail
-		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
-		return rsp, err
+// NewWalletServiceWithStore returns the wallet service on any storage backend,
+// retrying aborted transactions as configured in util/db.
+func NewWalletServiceWithStore(ctx context.Context, logID string, store dao.Store, locker util.DistributedLock) *WalletService {
+	maxRetries, backoff, maxBackoff := db.GetDbTxRetry()
+	return &WalletService{
+		ctx:       ctx,
+		logID:     logID,
+		store:     store,
+		retry:     dao.RetryPolicy{MaxRetries: maxRetries, BaseBackoff: backoff, MaxBackoff: maxBackoff},
+		locker:    locker,
+		approvals: true,
 	}
-	defer func() {
-		if errt := s.locker.UnLock(); errt != nil {
-			rsp.Code = errcode.ErrCodeUnLockFail
-			rsp.Message = errcode.ErrMsgMap[rsp.Code] + errt.Error()
+}
+
+// WithApprovals turns holding the transfers above the spending limit for approvals on or off, on by default.
+// Off, they are rejected with the spending limit code and nothing is held.
+func (s *WalletService) WithApprovals(on bool) *WalletService {
+	s.approvals = on
+	return s
+}
+
+// WithRetryPolicy replaces the retries of the transactions aborted by a serialization failure or a deadlock.
+func (s *WalletService) WithRetryPolicy(retry dao.RetryPolicy) *WalletService {
+	s.retry = retry
+	return s
+}
+
+// WithReplicas routes the balance and history reads to the replicas, nil reads from the store.
+func (s *WalletService) WithReplicas(replicas *db.ReplicaPool) *WalletService {
+	s.replicas = replicas
+	return s
+}
+
+// WithBalanceCache serves the balances of the users' own wallets from the cache, nil always reads them.
+func (s *WalletService) WithBalanceCache(cache *BalanceCache) *WalletService {
+	s.balanceCache = cache
+	return s
+}
+
+// WithEventBus notifies the writes on the bus and streams from it, nil neither notifies nor streams.
+func (s *WalletService) WithEventBus(bus EventBus) *WalletService {
+	s.bus = bus
+	return s
+}
+
+// writtenTimeout bounds the work done once a write committed, see afterCommit
+const writtenTimeout = 2 * time.Second
+
+// afterCommit returns the context of the work done once a write committed. It is not cancelled with the
+// request: a client gone after the commit must not leave a stale cached balance or a stream not notified.
+func afterCommit(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), writtenTimeout)
+}
+
+// written is called once a write to the wallets of the users committed: they read their own writes
+// from the primary for a while, their cached balances are invalidated and their streams notified.
+func (s *WalletService) written(userIDs ...int64) {
+	ctx, cancel := afterCommit(s.ctx)
+	defer cancel()
+	s.replicas.MarkWrite(ctx, userIDs...)
+	s.balanceCache.Invalidate(ctx, userIDs...)
+	if s.bus != nil {
+		for _, userID := range userIDs {
+			if err := s.bus.Publish(ctx, userID); err != nil {
+				log.Println("Failed to notify the streams" + err.Error())
+			}
 		}
-	}()
+	}
+}
 
-	tx, err := s.dbCli.Begin()
-	if err != nil {
-		rsp.Code = errcode.ErrCodeDbError
-		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
-		return rsp, err
+// readRepos returns the repositories for the reads of a user in the wallets of the owner: a replica within
+// the max staleness, or the store while the user is in the read-your-writes window of a recent write.
+// The replicas are those of the primary, the owners on the other shards read from their shard.
+func (s *WalletService) readRepos(userID int64, ownerID int64) dao.Repos {
+	store := s.storeFor(ownerID)
+	if store != s.store {
+		return store
+	}
+	if replica := s.replicas.Pick(s.ctx, userID); replica != nil {
+		return newSqlStore(s.ctx, s.logID, replica)
 	}
+	return s.store
+}
 
-	// check order_id
-	transDao := dao.NewTransactionsDao(s.ctx, s.logID)
-	trans, err := transDao.GetTransactionByOrderID(tx, req.OrderID)
+// runTx runs fn in a unit of work of the store, retried on serialization failures and deadlocks.
+// fn sets the code of its own failures, a failed begin or commit is a db error.
+func (s *WalletService) runTx(store dao.Store, rsp *data.CommRsp, fn func(tx dao.UnitOfWork) error) error {
+	rsp.Code = errcode.ErrCodeDbError
+	rsp.Message = errcode.ErrMsgMap[rsp.Code]
+	return dao.NewTxRunner(s.ctx, s.logID, store, s.retry).Run(func(tx dao.UnitOfWork) error {
+		rsp.Code = errcode.ErrCodeDbError
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return fn(tx)
+	})
+}
+
+// checkOrderID makes sure no transaction has the order_id, on failure the returned code is the response code.
+// The order_id of a transfer waiting for approvals is reserved by the store, recording it fails with dao.ErrOrderIDPending.
+// Sharded, an order_id is unique over the shards: tx runs on the shard of ownerID, the other shards are read
+// outside of it, shard 0 for the transfers waiting for approvals too, which the store of another shard can't see.
+func (s *WalletService) checkOrderID(tx dao.Repos, ownerID int64, orderID string) (int32, error) {
+	trans, err := tx.Transactions().GetTransactionByOrderID(orderID)
 	if err != nil {
-		_ = tx.Rollback()
-		rsp.Code = errcode.ErrCodeQueryDBFail
-		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
-		return rsp, err
+		return errcode.ErrCodeQueryDBFail, err
 	}
 	if trans != nil {
-		_ = tx.Rollback()
-		err = errors.New("order_id already exists")
-		rsp.Code = errcode.ErrCodeOrderIDRepeat
-		rsp.Message = errcode.ErrMsgMap[rsp.Code]
-		return rsp, err
+		return errcode.ErrCodeOrderIDRepeat, errors.New("order_id already exists")
 	}
-
-	// Update balance
-	walletDao := dao.NewWalletDao(s.ctx, s.logID)
-	err = walletDao.CreateOrUpdateWallet(tx, req.UserID, req.Amount)
+	if s.shards == nil {
+		return errcode.ErrCodeSuccess, nil
+	}
+	own := s.shards.ShardOf(ownerID)
+	for i := 0; i < s.shards.Len(); i++ {
+		if i == own {
+			continue
+		}
+		trans, err = s.shards.Shard(i).Transactions().GetTransactionByOrderID(orderID)
+		if err != nil {
+			return errcode.ErrCodeQueryDBFail, err
+		}
+		if trans != nil {
+			return errcode.ErrCodeOrderIDRepeat, errors.New("order_id already exists")
+		}
+	}
+	if own == 0 {
+		return errcode.ErrCodeSuccess, nil
+	}
+	approval, err := s.shards.Shard(0).Approvals().GetApprovalByOrderID(orderID)
 	if err != nil {
-		_ = tx.Rollback()
-		log.Println("Failed to update balance" + err.Error())
-		rsp.Code = errcode.ErrCodeDbError
-		rsp.Message = errcode.ErrMsgMap[rsp.Code]
-		return rsp, err
+		return errcode.ErrCodeQueryDBFail, err
 	}
+	if approval != nil && approval.Status == data.ApprovalStatusPending {
+		return errcode.ErrCodeOrderIDRepeat, errors.New("order_id taken by a transfer waiting for approvals")
+	}
+	return errcode.ErrCodeSuccess, nil
+}
+
+// mapDbError reports a db operation out of time, and an order_id reserved by a transfer waiting for approvals,
+// with their own codes, whichever step they failed
+func mapDbError(err error, code *int32, message *string) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		*code = errcode.ErrCodeDbTimeout
+		*message = errcode.ErrMsgMap[*code]
+	}
+	if errors.Is(err, dao.ErrOrderIDPending) {
+		*code = errcode.ErrCodeOrderIDRepeat
+		*message = errcode.ErrMsgMap[*code]
+	}
+}
+
+func (s *WalletService) Deposit(req *data.DepositReq) (rsp *data.CommRsp, err error) {
+	rsp = &data.CommRsp{Code: errcode.ErrCodeInternalErr, Message: errcode.ErrMsgMap[errcode.ErrCodeInternalErr], LogID: s.logID}
+	defer func() { mapDbError(err, &rsp.Code, &rsp.Message) }()
 
-	// Record transaction
-	err = transDao.InsertTransaction(tx, &model.Transactions{OrderID: req.OrderID, UserID: req.UserID, TxType: data.TxTypeDeposit, Amount: req.Amount})
+	err = s.locker.Lock()
 	if err != nil {
-		_ = tx.Rollback()
-		log.Println("Failed to record transaction" + err.Error())
-		rsp.Code = errcode.ErrCodeDbError
-		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		rsp.Code = errcode.ErrCodeLockFail
+		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
 		return rsp, err
 	}
+	defer func() {
+		if errt := s.locker.UnLock(); errt != nil {
+			rsp.Code = errcode.ErrCodeUnLockFail
+			rsp.Message = errcode.ErrMsgMap[rsp.Code] + errt.Error()
+		}
+	}()
 
-	if err = tx.Commit(); err != nil {
-		log.Println("Failed to commit transaction" + err.Error())
-		rsp.Code = errcode.ErrCodeDbError
-		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+	err = s.runTx(s.storeFor(ownerOf(req.UserID, req.OrgID)), rsp, func(tx dao.UnitOfWork) error {
+		// check order_id
+		transDao := tx.Transactions()
+		if code, err := s.checkOrderID(tx, ownerOf(req.UserID, req.OrgID), req.OrderID); err != nil {
+			rsp.Code = code
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+
+		// Update balance
+		walletDao := tx.Wallets()
+		var walletID int64
+		ownerID := req.UserID
+		if req.OrgID > 0 {
+			// every member may fund the shared wallet
+			wallet, _, code, errt := s.loadWallet(tx, req.UserID, req.OrgID, pocketName(req.Pocket), data.OrgRoleViewer)
+			if errt != nil {
+				rsp.Code = code
+				rsp.Message = errcode.ErrMsgMap[rsp.Code]
+				return errt
+			}
+			walletID, ownerID = wallet.ID, wallet.UserID
+			err = walletDao.UpdateWalletBalance(walletID, data.TxTypeDeposit, req.Amount)
+		} else {
+			walletID, err = walletDao.CreateOrUpdateWallet(req.UserID, pocketName(req.Pocket), req.Amount)
+		}
+		if err != nil {
+			log.Println("Failed to update balance" + err.Error())
+			rsp.Code = errcode.ErrCodeDbError
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+
+		// Record transaction and its event
+		record := &model.Transactions{OrderID: req.OrderID, UserID: ownerID, WalletID: walletID, TxType: data.TxTypeDeposit, Amount: req.Amount, ActorUserID: req.UserID}
+		err = transDao.InsertTransaction(record)
+		if err != nil {
+			log.Println("Failed to record transaction" + err.Error())
+			rsp.Code = errcode.ErrCodeDbError
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+		if err = recordEvent(tx, data.EventTypeDeposit, record); err != nil {
+			log.Println("Failed to record deposit event" + err.Error())
+			rsp.Code = errcode.ErrCodeDbError
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+		return nil
+	})
+	if err != nil {
 		return rsp, err
 	}
 
+	s.written(req.UserID)
 	rsp.Code = errcode.ErrCodeSuccess
 	rsp.Message = "Deposit successful"
 	return rsp, nil
 }
 
-func (s *WalletService) Withdraw(req *data.WithdrawReq) (*data.CommRsp, error) {
-	rsp := &data.CommRsp{Code: errcode.ErrCodeInternalErr, Message: errcode.ErrMsgMap[errcode.ErrCodeInternalErr], LogID: s.logID}
+func (s *WalletService) Withdraw(req *data.WithdrawReq) (rsp *data.CommRsp, err error) {
+	rsp = &data.CommRsp{Code: errcode.ErrCodeInternalErr, Message: errcode.ErrMsgMap[errcode.ErrCodeInternalErr], LogID: s.logID}
+	defer func() { mapDbError(err, &rsp.Code, &rsp.Message) }()
 
-	err := s.locker.Lock()
+	err = s.locker.Lock()
 	if err != nil {
 		rsp.Code = errcode.ErrCodeLockFail
 		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
@@ -118,91 +293,84 @@ func (s *WalletService) Withdraw(req *data.WithdrawReq) (*data.CommRsp, error) {
 		}
 	}()
 
-	tx, err := s.dbCli.Begin()
-	if err != nil {
-		rsp.Code = errcode.ErrCodeDbError
-		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
-		return rsp, err
-	}
-	// check order_id
-	transDao := dao.NewTransactionsDao(s.ctx, s.logID)
-	trans, err := transDao.GetTransactionByOrderID(tx, req.OrderID)
-	if err != nil {
-		_ = tx.Rollback()
-		rsp.Code = errcode.ErrCodeQueryDBFail
-		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
-		return rsp, err
-	}
-	if trans != nil {
-		_ = tx.Rollback()
-		err = errors.New("order_id already exists")
-		rsp.Code = errcode.ErrCodeOrderIDRepeat
-		rsp.Message = errcode.ErrMsgMap[rsp.Code]
-		return rsp, err
-	}
+	err = s.runTx(s.storeFor(ownerOf(req.UserID, req.OrgID)), rsp, func(tx dao.UnitOfWork) error {
+		// check order_id
+		transDao := tx.Transactions()
+		if code, err := s.checkOrderID(tx, ownerOf(req.UserID, req.OrgID), req.OrderID); err != nil {
+			rsp.Code = code
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
 
-	// Check balance
-	walletDao := dao.NewWalletDao(s.ctx, s.logID)
-	wallet, err := walletDao.GetWalletByUserID(nil, tx, req.UserID)
-	if err != nil {
-		_ = tx.Rollback()
-		rsp.Code = errcode.ErrCodeDbError
-		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
-		return rsp, err
-	}
-	if wallet == nil {
-		_ = tx.Rollback()
-		err = errors.New("user wallet not exist")
-		rsp.Code = errcode.ErrCodeUserWalletNotExist
-		rsp.Message = errcode.ErrMsgMap[rsp.Code]
-		return rsp, err
-	}
+		// Check balance, debiting a shared wallet needs the spender role
+		walletDao := tx.Wallets()
+		wallet, member, code, err := s.loadWallet(tx, req.UserID, req.OrgID, pocketName(req.Pocket), data.OrgRoleSpender)
+		if err != nil {
+			rsp.Code = code
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
 
-	// Compare balance[float64] with withdraw amount[float64]
-	if util.CompareFloat(wallet.Balance, req.Amount, 8) < 0 {
-		_ = tx.Rollback()
-		err = errors.New("balance not enough")
-		rsp.Code = errcode.ErrCodeBalanceNotEnough
-		rsp.Message = errcode.ErrMsgMap[rsp.Code]
-		return rsp, err
-	}
+		// Compare available funds[balance + credit limit - held] with withdraw amount[float64]
+		if util.CompareFloat(wallet.Balance+wallet.CreditLimit-wallet.Held, req.Amount, 8) < 0 {
+			err = errors.New("balance not enough")
+			rsp.Code = errcode.ErrCodeBalanceNotEnough
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+		if code, err = s.checkSpendingLimit(tx, wallet, member, req.Amount); err != nil {
+			rsp.Code = code
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
 
-	// Update balance
-	err = walletDao.UpdateWalletBalance(tx, req.UserID, data.TxTypeWithdraw, req.Amount)
-	if err != nil {
-		_ = tx.Rollback()
-		log.Println("Failed to update balance" + err.Error())
-		rsp.Code = errcode.ErrCodeDbError
-		rsp.Message = errcode.ErrMsgMap[rsp.Code]
-		return rsp, err
-	}
+		// Update balance
+		err = walletDao.UpdateWalletBalance(wallet.ID, data.TxTypeWithdraw, req.Amount)
+		if err != nil {
+			log.Println("Failed to update balance" + err.Error())
+			rsp.Code = errcode.ErrCodeDbError
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+		if err = s.onOverdraft(tx, wallet, req.Amount); err != nil {
+			log.Println("Failed to accrue overdraft" + err.Error())
+			rsp.Code = errcode.ErrCodeDbError
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
 
-	// Record transaction
-	err = transDao.InsertTransaction(tx, &model.Transactions{OrderID: req.OrderID, UserID: req.UserID, TxType: data.TxTypeWithdraw, Amount: req.Amount})
+		// Record transaction and its event
+		record := &model.Transactions{OrderID: req.OrderID, UserID: wallet.UserID, WalletID: wallet.ID, TxType: data.TxTypeWithdraw, Amount: req.Amount, ActorUserID: req.UserID}
+		err = transDao.InsertTransaction(record)
+		if err != nil {
+			log.Println("Failed to record transaction" + err.Error())
+			rsp.Code = errcode.ErrCodeDbError
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+		if err = recordEvent(tx, data.EventTypeWithdraw, record); err != nil {
+			log.Println("Failed to record withdraw event" + err.Error())
+			rsp.Code = errcode.ErrCodeDbError
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+		return nil
+	})
 	if err != nil {
-		_ = tx.Rollback()
-		log.Println("Failed to record transaction" + err.Error())
-		rsp.Code = errcode.ErrCodeDbError
-		rsp.Message = errcode.ErrMsgMap[rsp.Code]
-		return rsp, err
-	}
-
-	if err = tx.Commit(); err != nil {
-		log.Println("Failed to commit transaction" + err.Error())
-		rsp.Code = errcode.ErrCodeDbError
-		rsp.Message = errcode.ErrMsgMap[rsp.Code]
 		return rsp, err
 	}
 
+	s.written(req.UserID)
 	rsp.Code = errcode.ErrCodeSuccess
 	rsp.Message = "Withdrawal successful"
 	return rsp, nil
 }
 
-func (s *WalletService) Transfer(req *data.TransferReq) (*data.CommRsp, error) {
-	rsp := &data.CommRsp{Code: errcode.ErrCodeInternalErr, Message: errcode.ErrMsgMap[errcode.ErrCodeInternalErr], LogID: s.logID}
+func (s *WalletService) Transfer(req *data.TransferReq) (rsp *data.CommRsp, err error) {
+	rsp = &data.CommRsp{Code: errcode.ErrCodeInternalErr, Message: errcode.ErrMsgMap[errcode.ErrCodeInternalErr], LogID: s.logID}
+	defer func() { mapDbError(err, &rsp.Code, &rsp.Message) }()
 
-	err := s.locker.Lock()
+	err = s.locker.Lock()
 	if err != nil {
 		rsp.Code = errcode.ErrCodeLockFail
 		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
@@ -215,98 +383,120 @@ func (s *WalletService) Transfer(req *data.TransferReq) (*data.CommRsp, error) {
 		}
 	}()
 
-	tx, err := s.dbCli.Begin()
-	if err != nil {
-		rsp.Code = errcode.ErrCodeDbError
-		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
-		return rsp, err
-	}
-	// check order_id
-	transDao := dao.NewTransactionsDao(s.ctx, s.logID)
-	trans, err := transDao.GetTransactionByOrderID(tx, req.OrderID)
-	if err != nil {
-		_ = tx.Rollback()
-		rsp.Code = errcode.ErrCodeQueryDBFail
-		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
-		return rsp, err
-	}
-	if trans != nil {
-		_ = tx.Rollback()
-		err = errors.New("order_id already exists")
-		rsp.Code = errcode.ErrCodeOrderIDRepeat
-		rsp.Message = errcode.ErrMsgMap[rsp.Code]
-		return rsp, err
-	}
+	// the recipient on another shard is credited once the debit committed on the shard of the sender
+	fromStore := s.storeFor(ownerOf(req.FromUserID, req.OrgID))
+	pending := false
+	var shardTransfer *model.ShardTransfer
+	err = s.runTx(fromStore, rsp, func(tx dao.UnitOfWork) error {
+		pending, shardTransfer = false, nil
+		// check order_id
+		transDao := tx.Transactions()
+		if code, err := s.checkOrderID(tx, ownerOf(req.FromUserID, req.OrgID), req.OrderID); err != nil {
+			rsp.Code = code
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
 
-	// Check balance of sender
-	walletDao := dao.NewWalletDao(s.ctx, s.logID)
-	wallet, err := walletDao.GetWalletByUserID(nil, tx, req.FromUserID)
-	if err != nil {
-		_ = tx.Rollback()
-		rsp.Code = errcode.ErrCodeDbError
-		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
-		return rsp, err
-	}
-	if wallet == nil {
-		_ = tx.Rollback()
-		err = errors.New("sender wallet not exist")
-		rsp.Code = errcode.ErrCodeUserWalletNotExist
-		rsp.Message = errcode.ErrMsgMap[rsp.Code]
-		return rsp, err
-	}
+		// Check balance of sender, debiting a shared wallet needs the spender role
+		walletDao := tx.Wallets()
+		wallet, member, code, err := s.loadWallet(tx, req.FromUserID, req.OrgID, data.DefaultPocket, data.OrgRoleSpender)
+		if err != nil {
+			rsp.Code = code
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
 
-	// Compare balance[float64] with transfer amount[float64]
-	if util.CompareFloat(wallet.Balance, req.Amount, 8) < 0 {
-		_ = tx.Rollback()
-		err = errors.New("balance not enough")
-		rsp.Code = errcode.ErrCodeBalanceNotEnough
-		rsp.Message = errcode.ErrMsgMap[rsp.Code]
-		return rsp, err
-	}
+		// Compare available funds[balance + credit limit - held] with transfer amount[float64]
+		if util.CompareFloat(wallet.Balance+wallet.CreditLimit-wallet.Held, req.Amount, 8) < 0 {
+			err = errors.New("balance not enough")
+			rsp.Code = errcode.ErrCodeBalanceNotEnough
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+		if code, err = s.checkSpendingLimit(tx, wallet, member, req.Amount); err != nil {
+			if code == errcode.ErrCodeSpendingLimit && s.approvals {
+				// above the limit the transfer waits for approvals of other members
+				pending = true
+				return s.holdForApproval(tx, rsp, req, wallet)
+			}
+			rsp.Code = code
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
 
-	// Update sender's balance
-	err = walletDao.UpdateWalletBalance(tx, req.FromUserID, data.TxTypeTransferOut, req.Amount)
-	if err != nil {
-		_ = tx.Rollback()
-		log.Println("Failed to update sender's balance" + err.Error())
-		rsp.Code = errcode.ErrCodeDbError
-		rsp.Message = errcode.ErrMsgMap[rsp.Code]
-		return rsp, err
-	}
+		// Update sender's balance
+		err = walletDao.UpdateWalletBalance(wallet.ID, data.TxTypeTransferOut, req.Amount)
+		if err != nil {
+			log.Println("Failed to update sender's balance" + err.Error())
+			rsp.Code = errcode.ErrCodeDbError
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+		if err = s.onOverdraft(tx, wallet, req.Amount); err != nil {
+			log.Println("Failed to accrue sender's overdraft" + err.Error())
+			rsp.Code = errcode.ErrCodeDbError
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
 
-	// Update recipient's balance
-	err = walletDao.CreateOrUpdateWallet(tx, req.ToUserID, req.Amount)
-	if err != nil {
-		_ = tx.Rollback()
-		log.Println("Failed to update recipient's balance" + err.Error())
-		rsp.Code = errcode.ErrCodeDbError
-		rsp.Message = errcode.ErrMsgMap[rsp.Code]
-		return rsp, err
-	}
+		// Update recipient's balance
+		var toWalletID int64
+		if s.sameShard(wallet.UserID, req.ToUserID) {
+			toWalletID, err = walletDao.CreateOrUpdateWallet(req.ToUserID, data.DefaultPocket, req.Amount)
+		} else {
+			shardTransfer = &model.ShardTransfer{OrderID: req.OrderID, FromUserID: req.FromUserID, FromWalletID: wallet.ID, ToUserID: req.ToUserID, Amount: req.Amount}
+			_, err = tx.ShardTransfers().InsertShardTransfer(shardTransfer)
+		}
+		if err != nil {
+			log.Println("Failed to update recipient's balance" + err.Error())
+			rsp.Code = errcode.ErrCodeDbError
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
 
-	// Record transactions
-	err = transDao.InsertTransaction(tx, &model.Transactions{OrderID: req.OrderID, UserID: req.FromUserID, TxType: data.TxTypeTransferOut, Amount: req.Amount, RelatedUserID: req.ToUserID})
+		// Record transactions and the event of the transfer
+		record := &model.Transactions{OrderID: req.OrderID, UserID: wallet.UserID, WalletID: wallet.ID, TxType: data.TxTypeTransferOut, Amount: req.Amount, RelatedUserID: req.ToUserID, ActorUserID: req.FromUserID}
+		err = transDao.InsertTransaction(record)
+		if err != nil {
+			log.Println("Failed to record sender's transaction" + err.Error())
+			rsp.Code = errcode.ErrCodeDbError
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+		if err = recordEvent(tx, data.EventTypeTransfer, record); err != nil {
+			log.Println("Failed to record transfer event" + err.Error())
+			rsp.Code = errcode.ErrCodeDbError
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+		if shardTransfer != nil {
+			return nil
+		}
+		err = transDao.InsertTransaction(&model.Transactions{OrderID: req.OrderID, UserID: req.ToUserID, WalletID: toWalletID, TxType: data.TxTypeTransferIn, Amount: req.Amount, RelatedUserID: req.FromUserID, ActorUserID: req.FromUserID})
+		if err != nil {
+			log.Println("Failed to record recipient's transaction" + err.Error())
+			rsp.Code = errcode.ErrCodeDbError
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+		return nil
+	})
 	if err != nil {
-		_ = tx.Rollback()
-		log.Println("Failed to record sender's transaction" + err.Error())
-		rsp.Code = errcode.ErrCodeDbError
-		rsp.Message = errcode.ErrMsgMap[rsp.Code]
 		return rsp, err
 	}
-	err = transDao.InsertTransaction(tx, &model.Transactions{OrderID: req.OrderID, UserID: req.ToUserID, TxType: data.TxTypeTransferIn, Amount: req.Amount, RelatedUserID: req.FromUserID})
-	if err != nil {
-		_ = tx.Rollback()
-		log.Println("Failed to record recipient's transaction" + err.Error())
-		rsp.Code = errcode.ErrCodeDbError
-		rsp.Message = errcode.ErrMsgMap[rsp.Code]
-		return rsp, err
+	s.written(req.FromUserID, req.ToUserID)
+	if shardTransfer != nil {
+		if err = s.creditShardTransfer(fromStore, shardTransfer); err != nil {
+			log.Println("Failed to credit the recipient on their shard" + err.Error())
+			rsp.Code = errcode.ErrCodeTransferPending
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return rsp, err
+		}
 	}
-
-	if err = tx.Commit(); err != nil {
-		log.Println("Failed to commit transaction" + err.Error())
-		rsp.Code = errcode.ErrCodeDbError
+	if pending {
+		rsp.Code = errcode.ErrCodeApprovalPending
 		rsp.Message = errcode.ErrMsgMap[rsp.Code]
-		return rsp, err
+		return rsp, nil
 	}
 
 	rsp.Code = errcode.ErrCodeSuccess
@@ -314,34 +504,145 @@ func (s *WalletService) Transfer(req *data.TransferReq) (*data.CommRsp, error) {
 	return rsp, nil
 }
 
-func (s *WalletService) GetBalance(req *data.GetBalanceReq) (*data.GetBalanceRsp, error) {
-	rsp := &data.GetBalanceRsp{Code: errcode.ErrCodeInternalErr, Message: errcode.ErrMsgMap[errcode.ErrCodeInternalErr], LogID: s.logID}
+func (s *WalletService) GetBalance(req *data.GetBalanceReq) (rsp *data.GetBalanceRsp, err error) {
+	rsp = &data.GetBalanceRsp{Code: errcode.ErrCodeInternalErr, Message: errcode.ErrMsgMap[errcode.ErrCodeInternalErr], LogID: s.logID}
+	defer func() { mapDbError(err, &rsp.Code, &rsp.Message) }()
+
+	// the balance of the user's own wallets may be cached, one not cached is read from the primary
+	// so a replica behind it is never cached
+	var version string
+	cached := s.balanceCache != nil && req.OrgID == 0
+	if cached {
+		var balance *data.GetBalanceRspData
+		if balance, version = s.balanceCache.Get(s.ctx, req.UserID); balance != nil {
+			rsp.Code = errcode.ErrCodeSuccess
+			rsp.Message = "Success"
+			rsp.Data = balance
+			return rsp, nil
+		}
+	}
 
-	wallet, err := dao.NewWalletDao(s.ctx, s.logID).GetWalletByUserID(s.dbCli, nil, req.UserID)
+	var walletList []*model.Wallet
+	repos := s.readRepos(req.UserID, ownerOf(req.UserID, req.OrgID))
+	if cached {
+		repos = s.storeFor(req.UserID)
+	}
+	if req.OrgID > 0 {
+		if _, code, errt := s.checkOrgRole(repos, req.OrgID, req.UserID, data.OrgRoleViewer); errt != nil {
+			rsp.Code = code
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return rsp, errt
+		}
+		walletList, err = repos.Wallets().GetWalletListByOrgID(req.OrgID)
+	} else {
+		walletList, err = repos.Wallets().GetWalletListByUserID(req.UserID)
+	}
 	if err != nil {
 		log.Println("Failed to get balance" + err.Error())
 		rsp.Code = errcode.ErrCodeQueryDBFail
 		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
 		return rsp, err
 	}
-	if wallet == nil {
+	if len(walletList) == 0 {
 		err = errors.New("wallet not exist")
 		rsp.Code = errcode.ErrCodeUserWalletNotExist
 		rsp.Message = errcode.ErrMsgMap[rsp.Code]
 		return rsp, err
 	}
+	rspData := &data.GetBalanceRspData{}
+	for _, wallet := range walletList {
+		pocket := &data.GetBalanceRspDataPocket{
+			WalletID:         wallet.ID,
+			Name:             wallet.Name,
+			Balance:          wallet.Balance,
+			CreditLimit:      wallet.CreditLimit,
+			Held:             wallet.Held,
+			AvailableBalance: wallet.Balance + wallet.CreditLimit - wallet.Held,
+		}
+		if wallet.Name == data.DefaultPocket {
+			rspData.Balance, rspData.CreditLimit, rspData.Held, rspData.AvailableBalance = pocket.Balance, pocket.CreditLimit, pocket.Held, pocket.AvailableBalance
+		}
+		rspData.Pockets = append(rspData.Pockets, pocket)
+	}
+	if cached {
+		s.balanceCache.Set(s.ctx, req.UserID, version, rspData)
+	}
 	rsp.Code = errcode.ErrCodeSuccess
 	rsp.Message = "Success"
-	rsp.Data = &data.GetBalanceRspData{Balance: wallet.Balance}
+	rsp.Data = rspData
 	return rsp, nil
 }
 
-func (s *WalletService) GetTransactionHistory(req *data.GetTransactionHistoryReq) (*data.GetTransactionHistoryRsp, error) {
-	rsp := &data.GetTransactionHistoryRsp{Code: errcode.ErrCodeInternalErr, Message: errcode.ErrMsgMap[errcode.ErrCodeInternalErr], LogID: s.logID}
+func (s *WalletService) GetTransactionHistory(req *data.GetTransactionHistoryReq) (rsp *data.GetTransactionHistoryRsp, err error) {
+	rsp = &data.GetTransactionHistoryRsp{Code: errcode.ErrCodeInternalErr, Message: errcode.ErrMsgMap[errcode.ErrCodeInternalErr], LogID: s.logID}
+	defer func() { mapDbError(err, &rsp.Code, &rsp.Message) }()
 	var rspItems []*data.GetTransactionHistoryRspDataItem
 
-	transDao := dao.NewTransactionsDao(s.ctx, s.logID)
-	txList, err := transDao.GetTransactionListByUserID(s.dbCli, req.UserID, req.Page, req.Limit)
+	// filter by pocket, shared wallets are always read one pocket at a time
+	repos := s.readRepos(req.UserID, ownerOf(req.UserID, req.OrgID))
+	userID, walletID := req.UserID, int64(0)
+	if req.Pocket != "" || req.OrgID > 0 {
+		wallet, _, code, err := s.loadWallet(repos, req.UserID, req.OrgID, pocketName(req.Pocket), data.OrgRoleViewer)
+		if err != nil {
+			if code == errcode.ErrCodeDbError {
+				code = errcode.ErrCodeQueryDBFail
+			}
+			rsp.Code = code
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return rsp, err
+		}
+		userID, walletID = wallet.UserID, wallet.ID
+	}
+
+	key, backward, err := decodeTxCursor(req.Cursor)
+	if err != nil {
+		rsp.Code = errcode.ErrCodeBadRequestParam
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return rsp, err
+	}
+
+	filter := &dao.TxFilter{
+		UserID:        userID,
+		WalletID:      walletID,
+		TxTypes:       req.TxTypes,
+		StartTime:     req.StartTime,
+		EndTime:       req.EndTime,
+		MinAmount:     req.MinAmount,
+		MaxAmount:     req.MaxAmount,
+		RelatedUserID: req.RelatedUserID,
+		OrderIDPrefix: req.OrderIDPrefix,
+	}
+	if err = s.archivedFilter(repos, filter); err != nil {
+		log.Println("Failed to get the archive watermark" + err.Error())
+		rsp.Code = errcode.ErrCodeQueryDBFail
+		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
+		return rsp, err
+	}
+	transDao := repos.Transactions()
+	var txList []*model.Transactions
+	var prevCursor, nextCursor string
+	if req.Page > 0 {
+		// deprecated offset paging, the cursors let old clients move on to keyset paging
+		txList, err = transDao.GetTransactionList(filter, req.Page, req.Limit)
+		if err == nil && len(txList) > 0 {
+			if req.Page > 1 {
+				prevCursor = encodeTxCursor(txKeyOf(txList[0]), true)
+			}
+			if len(txList) == int(req.Limit) {
+				nextCursor = encodeTxCursor(txKeyOf(txList[len(txList)-1]), false)
+			}
+		}
+	} else {
+		// one more item than the limit tells if there is a page further on
+		txList, err = transDao.GetTransactionListByCursor(filter, key, backward, req.Limit+1)
+		more := len(txList) > int(req.Limit)
+		if more && backward {
+			txList = txList[1:]
+		} else if more {
+			txList = txList[:req.Limit]
+		}
+		prevCursor, nextCursor = txPageCursors(txList, key, backward, more)
+	}
 	if err != nil {
 		log.Println("Failed to get transaction history" + err.Error())
 		rsp.Code = errcode.ErrCodeQueryDBFail
@@ -353,19 +654,28 @@ func (s *WalletService) GetTransactionHistory(req *data.GetTransactionHistoryReq
 		rsp.Message = errcode.ErrMsgMap[rsp.Code]
 		return rsp, nil
 	}
+	total, err := transDao.CountTransactions(filter)
+	if err != nil {
+		log.Println("Failed to count transaction history" + err.Error())
+		rsp.Code = errcode.ErrCodeQueryDBFail
+		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
+		return rsp, err
+	}
 	for _, tx := range txList {
 		rspItems = append(rspItems, &data.GetTransactionHistoryRspDataItem{
 			OrderID:       tx.OrderID,
 			UserID:        tx.UserID,
+			WalletID:      tx.WalletID,
 			TxType:        tx.TxType,
 			Amount:        tx.Amount,
 			RelatedUserID: tx.RelatedUserID,
+			ActorUserID:   tx.ActorUserID,
 			CreatedAt:     time.Unix(tx.CreatedAt, 0).Format("2006-01-02 15:04:05"),
 		})
 	}
 
 	rsp.Code = errcode.ErrCodeSuccess
 	rsp.Message = "Success"
-	rsp.Data = &data.GetTransactionHistoryRspData{Items: rspItems}
+	rsp.Data = &data.GetTransactionHistoryRspData{Total: total, Items: rspItems, NextCursor: nextCursor, PrevCursor: prevCursor}
 	return rsp, nil
 }
@@ -3,11 +3,14 @@ package service_test
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"log"
 	"regexp"
 	"simplewallet/data"
+	"simplewallet/model"
 	"simplewallet/service"
+	"simplewallet/service/dao"
 	"simplewallet/util"
 	"simplewallet/util/db"
 	"simplewallet/util/errcode"
@@ -15,6 +18,7 @@ import (
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/goleak"
 )
@@ -33,17 +37,45 @@ func ConnectDBRedis() {
 }
 func DisconnectDBRedis() {
 	dbCli := db.GetDbClientMock()
-	redisCli := db.GetRedisClientMock()
 	err := dbCli.Close()
 	if err != nil {
 		log.Println("fail to close db", err)
 	}
-	err = redisCli.Close()
+	err = db.CloseRedisMock()
 	if err != nil {
 		log.Println("fail to close redis", err)
 	}
 }
 
+type overdraftHookMock struct {
+	calls      int
+	newBalance float64
+}
+
+func (h *overdraftHookMock) OnOverdraft(repos dao.Repos, wallet *model.Wallet, amount float64, newBalance float64) error {
+	h.calls++
+	h.newBalance = newBalance
+	return nil
+}
+
+// expectChainHead expects the head of the chain of the wallet locked and read by an insert of a transaction, the first one
+func expectChainHead(mock sqlmock.Sqlmock, walletID int64) {
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE wallets SET tx_hash = tx_hash WHERE id = $1 RETURNING tx_hash")).
+		WithArgs(walletID).WillReturnRows(sqlmock.NewRows([]string{"tx_hash"}).AddRow(""))
+}
+
+// expectWalletLock expects the wallet row locked before the debits of a spender are summed
+func expectWalletLock(mock sqlmock.Sqlmock, walletID int64) {
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET balance = balance WHERE id = $1")).
+		WithArgs(walletID).WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// expectChainHeadUpdate expects the head of the chain of the wallet moved to the transaction inserted
+func expectChainHeadUpdate(mock sqlmock.Sqlmock, walletID int64) {
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET tx_hash = $1, updated_at = $2 WHERE id = $3")).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), walletID).WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
 func TestDeposit(t *testing.T) {
 	ConnectDBRedis()
 	defer goleak.VerifyNone(t) // check for goroutine leaks
@@ -55,23 +87,26 @@ func TestDeposit(t *testing.T) {
 		logID := util.Uniqid()
 		ctx := context.Background()
 		lockKey := "deposit:" + logID
-		loker := util.NewDistributedLockMock(logID, lockKey, 5, ctx)
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, lockKey, 5)
 
 		depositReq := &data.DepositReq{OrderID: logID, UserID: 101, Amount: 1000.00}
 		tn := time.Now().Unix()
 		// mock DB data
 		mock.ExpectBegin()
 		transRows := sqlmock.NewRows([]string{})
-		mock.ExpectQuery("SELECT id,order_id,user_id,tx_type,amount,related_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(depositReq.OrderID).WillReturnRows(transRows)
+		mock.ExpectQuery("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(depositReq.OrderID).WillReturnRows(transRows)
 
 		walletRows := sqlmock.NewRows([]string{})
-		mock.ExpectQuery("SELECT id,user_id,balance,created_at,updated_at FROM wallets WHERE user_id = ?").WithArgs(depositReq.UserID).WillReturnRows(walletRows)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 AND name = $2")).WithArgs(depositReq.UserID, data.DefaultPocket).WillReturnRows(walletRows)
 
-		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO wallets (user_id, balance,created_at,updated_at) VALUES ($1, $2, $3, $4)")).
-			WithArgs(depositReq.UserID, depositReq.Amount, tn, tn).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO wallets (user_id, name, balance, created_at, updated_at) VALUES ($1, $2, $3, $4, $5) RETURNING id")).
+			WithArgs(depositReq.UserID, data.DefaultPocket, depositReq.Amount, tn, tn).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 
-		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions (order_id, user_id, tx_type, amount, related_user_id, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7)")).
-			WithArgs(depositReq.OrderID, depositReq.UserID, data.TxTypeDeposit, depositReq.Amount, 0, tn, tn).WillReturnResult(sqlmock.NewResult(1, 1))
+		expectChainHead(mock, 1)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions (order_id, user_id, wallet_id, tx_type, amount, related_user_id, actor_user_id, created_at, updated_at, prev_hash, hash) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)")).
+			WithArgs(depositReq.OrderID, depositReq.UserID, 1, data.TxTypeDeposit, depositReq.Amount, 0, depositReq.UserID, tn, tn, "", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		expectChainHeadUpdate(mock, 1)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO outbox")).WillReturnResult(sqlmock.NewResult(1, 1))
 		mock.ExpectCommit()
 
 		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
@@ -87,23 +122,26 @@ func TestDeposit(t *testing.T) {
 		logID := util.Uniqid()
 		ctx := context.Background()
 		lockKey := "deposit:" + logID
-		loker := util.NewDistributedLockMock(logID, lockKey, 5, ctx)
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, lockKey, 5)
 
 		depositReq := &data.DepositReq{OrderID: logID, UserID: 101, Amount: 2000.00}
 		tn := time.Now().Unix()
 		// mock DB data
 		mock.ExpectBegin()
 		transRows := sqlmock.NewRows([]string{})
-		mock.ExpectQuery("SELECT id,order_id,user_id,tx_type,amount,related_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(depositReq.OrderID).WillReturnRows(transRows)
+		mock.ExpectQuery("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(depositReq.OrderID).WillReturnRows(transRows)
 
-		walletRows := sqlmock.NewRows([]string{"id", "user_id", "balance", "created_at", "updated_at"}).AddRow(1, depositReq.UserID, 1000.00, tn, tn)
-		mock.ExpectQuery("SELECT id,user_id,balance,created_at,updated_at FROM wallets WHERE user_id = ?").WithArgs(depositReq.UserID).WillReturnRows(walletRows)
+		walletRows := sqlmock.NewRows([]string{"id", "user_id", "org_id", "name", "balance", "credit_limit", "held", "product", "created_at", "updated_at"}).AddRow(1, depositReq.UserID, 0, data.DefaultPocket, 1000.00, 0, 0, "", tn, tn)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 AND name = $2")).WithArgs(depositReq.UserID, data.DefaultPocket).WillReturnRows(walletRows)
 
-		mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET balance = wallets.balance + $1, updated_at = $2 WHERE user_id = $3")).
-			WithArgs(depositReq.Amount, tn, depositReq.UserID).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET balance = wallets.balance + $1, updated_at = $2 WHERE id = $3")).
+			WithArgs(depositReq.Amount, tn, 1).WillReturnResult(sqlmock.NewResult(1, 1))
 
-		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions (order_id, user_id, tx_type, amount, related_user_id, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7)")).
-			WithArgs(depositReq.OrderID, depositReq.UserID, data.TxTypeDeposit, depositReq.Amount, 0, tn, tn).WillReturnResult(sqlmock.NewResult(1, 1))
+		expectChainHead(mock, 1)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions (order_id, user_id, wallet_id, tx_type, amount, related_user_id, actor_user_id, created_at, updated_at, prev_hash, hash) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)")).
+			WithArgs(depositReq.OrderID, depositReq.UserID, 1, data.TxTypeDeposit, depositReq.Amount, 0, depositReq.UserID, tn, tn, "", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		expectChainHeadUpdate(mock, 1)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO outbox")).WillReturnResult(sqlmock.NewResult(1, 1))
 		mock.ExpectCommit()
 
 		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
@@ -119,20 +157,20 @@ func TestDeposit(t *testing.T) {
 		logID := util.Uniqid()
 		ctx := context.Background()
 		lockKey := "deposit:" + logID
-		loker := util.NewDistributedLockMock(logID, lockKey, 5, ctx)
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, lockKey, 5)
 
 		depositReq := &data.DepositReq{OrderID: logID, UserID: 101, Amount: 1000.00}
 		tn := time.Now().Unix()
 		// mock DB data
 		mock.ExpectBegin()
 		transRows := sqlmock.NewRows([]string{})
-		mock.ExpectQuery("SELECT id,order_id,user_id,tx_type,amount,related_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(depositReq.OrderID).WillReturnRows(transRows)
+		mock.ExpectQuery("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(depositReq.OrderID).WillReturnRows(transRows)
 
 		walletRows := sqlmock.NewRows([]string{})
-		mock.ExpectQuery("SELECT id,user_id,balance,created_at,updated_at FROM wallets WHERE user_id = ?").WithArgs(depositReq.UserID).WillReturnRows(walletRows)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 AND name = $2")).WithArgs(depositReq.UserID, data.DefaultPocket).WillReturnRows(walletRows)
 
-		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO wallets (user_id, balance,created_at,updated_at) VALUES ($1, $2, $3, $4)")).
-			WithArgs(depositReq.UserID, depositReq.Amount, tn, tn).WillReturnError(errors.New("insert wallet fail"))
+		mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO wallets (user_id, name, balance, created_at, updated_at) VALUES ($1, $2, $3, $4, $5) RETURNING id")).
+			WithArgs(depositReq.UserID, data.DefaultPocket, depositReq.Amount, tn, tn).WillReturnError(errors.New("insert wallet fail"))
 
 		mock.ExpectRollback()
 
@@ -148,23 +186,24 @@ func TestDeposit(t *testing.T) {
 		logID := util.Uniqid()
 		ctx := context.Background()
 		lockKey := "deposit:" + logID
-		loker := util.NewDistributedLockMock(logID, lockKey, 5, ctx)
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, lockKey, 5)
 
 		depositReq := &data.DepositReq{OrderID: logID, UserID: 101, Amount: 1000.00}
 		tn := time.Now().Unix()
 		// mock DB data
 		mock.ExpectBegin()
 		transRows := sqlmock.NewRows([]string{})
-		mock.ExpectQuery("SELECT id,order_id,user_id,tx_type,amount,related_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(depositReq.OrderID).WillReturnRows(transRows)
+		mock.ExpectQuery("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(depositReq.OrderID).WillReturnRows(transRows)
 
 		walletRows := sqlmock.NewRows([]string{})
-		mock.ExpectQuery("SELECT id,user_id,balance,created_at,updated_at FROM wallets WHERE user_id = ?").WithArgs(depositReq.UserID).WillReturnRows(walletRows)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 AND name = $2")).WithArgs(depositReq.UserID, data.DefaultPocket).WillReturnRows(walletRows)
 
-		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO wallets (user_id, balance,created_at,updated_at) VALUES ($1, $2, $3, $4)")).
-			WithArgs(depositReq.UserID, depositReq.Amount, tn, tn).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO wallets (user_id, name, balance, created_at, updated_at) VALUES ($1, $2, $3, $4, $5) RETURNING id")).
+			WithArgs(depositReq.UserID, data.DefaultPocket, depositReq.Amount, tn, tn).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 
-		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions (order_id, user_id, tx_type, amount, related_user_id, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7)")).
-			WithArgs(depositReq.OrderID, depositReq.UserID, data.TxTypeDeposit, depositReq.Amount, 0, tn, tn).WillReturnError(errors.New("insert transaction fail"))
+		expectChainHead(mock, 1)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions (order_id, user_id, wallet_id, tx_type, amount, related_user_id, actor_user_id, created_at, updated_at, prev_hash, hash) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)")).
+			WithArgs(depositReq.OrderID, depositReq.UserID, 1, data.TxTypeDeposit, depositReq.Amount, 0, depositReq.UserID, tn, tn, "", sqlmock.AnyArg()).WillReturnError(errors.New("insert transaction fail"))
 		mock.ExpectRollback()
 
 		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
@@ -180,15 +219,15 @@ func TestDeposit(t *testing.T) {
 		logID := util.Uniqid()
 		ctx := context.Background()
 		lockKey := "deposit:" + logID
-		loker := util.NewDistributedLockMock(logID, lockKey, 5, ctx)
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, lockKey, 5)
 
 		depositReq := &data.DepositReq{OrderID: logID, UserID: 101, Amount: 2000.00}
 		tn := time.Now().Unix()
 		// mock DB data
 		mock.ExpectBegin()
-		transRows := sqlmock.NewRows([]string{"id", "order_id", "user_id", "tx_type", "amount", "related_user_id", "created_at", "updated_at"})
-		transRows.AddRow(1, depositReq.OrderID, depositReq.UserID, data.TxTypeDeposit, depositReq.Amount, 0, tn, tn)
-		mock.ExpectQuery("SELECT id,order_id,user_id,tx_type,amount,related_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(depositReq.OrderID).WillReturnRows(transRows)
+		transRows := sqlmock.NewRows([]string{"id", "order_id", "user_id", "wallet_id", "tx_type", "amount", "related_user_id", "actor_user_id", "created_at", "updated_at"})
+		transRows.AddRow(1, depositReq.OrderID, depositReq.UserID, 1, data.TxTypeDeposit, depositReq.Amount, 0, depositReq.UserID, tn, tn)
+		mock.ExpectQuery("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(depositReq.OrderID).WillReturnRows(transRows)
 		mock.ExpectRollback()
 
 		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
@@ -204,7 +243,7 @@ func TestDeposit(t *testing.T) {
 		logID := util.Uniqid()
 		ctx := context.Background()
 		lockKey := "deposit:" + logID
-		loker := util.NewDistributedLockMock(logID, lockKey, -1, ctx) // expire time < 0
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, lockKey, -1) // expire time < 0
 		depositReq := &data.DepositReq{OrderID: logID, UserID: 101, Amount: 2000.00}
 		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
 		rsp, err := walletService.Deposit(depositReq)
@@ -212,6 +251,59 @@ func TestDeposit(t *testing.T) {
 		assert.Equal(t, errcode.ErrCodeLockFail, rsp.Code)
 	})
 
+	t.Run("case7: deposit fail-[db timeout]", func(t *testing.T) {
+		logID := util.Uniqid()
+		ctx := context.Background()
+		lockKey := "deposit:" + logID
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, lockKey, 5)
+
+		depositReq := &data.DepositReq{OrderID: logID, UserID: 101, Amount: 2000.00}
+		// mock DB data
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(depositReq.OrderID).
+			WillDelayFor(time.Second).WillReturnRows(sqlmock.NewRows([]string{}))
+		mock.ExpectRollback()
+
+		store := dao.NewPgStore(ctx, logID, mockDBCli).WithTimeouts(dao.Timeouts{Query: 20 * time.Millisecond})
+		walletService := service.NewWalletServiceWithStore(ctx, logID, store, loker)
+		rsp, err := walletService.Deposit(depositReq)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, errcode.ErrCodeDbTimeout, rsp.Code)
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("case8: deposit fail-[insert outbox event fail]", func(t *testing.T) {
+		logID := util.Uniqid()
+		ctx := context.Background()
+		lockKey := "deposit:" + logID
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, lockKey, 5)
+
+		depositReq := &data.DepositReq{OrderID: logID, UserID: 101, Amount: 1000.00}
+		tn := time.Now().Unix()
+		// mock DB data
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(depositReq.OrderID).WillReturnRows(sqlmock.NewRows([]string{}))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 AND name = $2")).WithArgs(depositReq.UserID, data.DefaultPocket).WillReturnRows(sqlmock.NewRows([]string{}))
+		mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO wallets (user_id, name, balance, created_at, updated_at) VALUES ($1, $2, $3, $4, $5) RETURNING id")).
+			WithArgs(depositReq.UserID, data.DefaultPocket, depositReq.Amount, tn, tn).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		expectChainHead(mock, 1)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions (order_id, user_id, wallet_id, tx_type, amount, related_user_id, actor_user_id, created_at, updated_at, prev_hash, hash) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)")).
+			WithArgs(depositReq.OrderID, depositReq.UserID, 1, data.TxTypeDeposit, depositReq.Amount, 0, depositReq.UserID, tn, tn, "", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		expectChainHeadUpdate(mock, 1)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO outbox")).WillReturnError(errors.New("insert outbox event fail"))
+		mock.ExpectRollback()
+
+		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
+		rsp, err := walletService.Deposit(depositReq)
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodeDbError, rsp.Code)
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
 }
 
 func TestWithdraw(t *testing.T) {
@@ -225,21 +317,24 @@ func TestWithdraw(t *testing.T) {
 		logID := util.Uniqid()
 		ctx := context.Background()
 		lockKey := "withdraw:" + logID
-		loker := util.NewDistributedLockMock(logID, lockKey, 5, ctx)
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, lockKey, 5)
 		withdrawReq := &data.WithdrawReq{OrderID: logID, UserID: 101, Amount: 1000.00}
 		tn := time.Now().Unix()
 		// mock DB data
 		mock.ExpectBegin()
 		transRows := sqlmock.NewRows([]string{})
-		mock.ExpectQuery("SELECT id,order_id,user_id,tx_type,amount,related_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(withdrawReq.OrderID).WillReturnRows(transRows)
-		walletRows := sqlmock.NewRows([]string{"id", "user_id", "balance", "created_at", "updated_at"}).AddRow(1, withdrawReq.UserID, 2000.00, tn, tn)
-		mock.ExpectQuery("SELECT id,user_id,balance,created_at,updated_at FROM wallets WHERE user_id = ?").WithArgs(withdrawReq.UserID).WillReturnRows(walletRows)
-
-		mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET balance = wallets.balance - $1, updated_at = $2 WHERE user_id = $3")).
-			WithArgs(withdrawReq.Amount, tn, withdrawReq.UserID).WillReturnResult(sqlmock.NewResult(1, 1))
-
-		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions (order_id, user_id, tx_type, amount, related_user_id, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7)")).
-			WithArgs(withdrawReq.OrderID, withdrawReq.UserID, data.TxTypeWithdraw, withdrawReq.Amount, 0, tn, tn).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(withdrawReq.OrderID).WillReturnRows(transRows)
+		walletRows := sqlmock.NewRows([]string{"id", "user_id", "org_id", "name", "balance", "credit_limit", "held", "product", "created_at", "updated_at"}).AddRow(1, withdrawReq.UserID, 0, data.DefaultPocket, 2000.00, 0, 0, "", tn, tn)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 AND name = $2")).WithArgs(withdrawReq.UserID, data.DefaultPocket).WillReturnRows(walletRows)
+
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET balance = wallets.balance - $1, updated_at = $2 WHERE id = $3")).
+			WithArgs(withdrawReq.Amount, tn, 1).WillReturnResult(sqlmock.NewResult(1, 1))
+
+		expectChainHead(mock, 1)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions (order_id, user_id, wallet_id, tx_type, amount, related_user_id, actor_user_id, created_at, updated_at, prev_hash, hash) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)")).
+			WithArgs(withdrawReq.OrderID, withdrawReq.UserID, 1, data.TxTypeWithdraw, withdrawReq.Amount, 0, withdrawReq.UserID, tn, tn, "", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		expectChainHeadUpdate(mock, 1)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO outbox")).WillReturnResult(sqlmock.NewResult(1, 1))
 		mock.ExpectCommit()
 
 		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
@@ -255,21 +350,24 @@ func TestWithdraw(t *testing.T) {
 		logID := util.Uniqid()
 		ctx := context.Background()
 		lockKey := "withdraw:" + logID
-		loker := util.NewDistributedLockMock(logID, lockKey, 5, ctx)
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, lockKey, 5)
 		withdrawReq := &data.WithdrawReq{OrderID: logID, UserID: 101, Amount: 1000.00}
 		tn := time.Now().Unix()
 		// mock DB data
 		mock.ExpectBegin()
 		transRows := sqlmock.NewRows([]string{})
-		mock.ExpectQuery("SELECT id,order_id,user_id,tx_type,amount,related_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(withdrawReq.OrderID).WillReturnRows(transRows)
-		walletRows := sqlmock.NewRows([]string{"id", "user_id", "balance", "created_at", "updated_at"}).AddRow(1, withdrawReq.UserID, 1000.00, tn, tn)
-		mock.ExpectQuery("SELECT id,user_id,balance,created_at,updated_at FROM wallets WHERE user_id = ?").WithArgs(withdrawReq.UserID).WillReturnRows(walletRows)
-
-		mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET balance = wallets.balance - $1, updated_at = $2 WHERE user_id = $3")).
-			WithArgs(withdrawReq.Amount, tn, withdrawReq.UserID).WillReturnResult(sqlmock.NewResult(1, 1))
-
-		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions (order_id, user_id, tx_type, amount, related_user_id, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7)")).
-			WithArgs(withdrawReq.OrderID, withdrawReq.UserID, data.TxTypeWithdraw, withdrawReq.Amount, 0, tn, tn).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(withdrawReq.OrderID).WillReturnRows(transRows)
+		walletRows := sqlmock.NewRows([]string{"id", "user_id", "org_id", "name", "balance", "credit_limit", "held", "product", "created_at", "updated_at"}).AddRow(1, withdrawReq.UserID, 0, data.DefaultPocket, 1000.00, 0, 0, "", tn, tn)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 AND name = $2")).WithArgs(withdrawReq.UserID, data.DefaultPocket).WillReturnRows(walletRows)
+
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET balance = wallets.balance - $1, updated_at = $2 WHERE id = $3")).
+			WithArgs(withdrawReq.Amount, tn, 1).WillReturnResult(sqlmock.NewResult(1, 1))
+
+		expectChainHead(mock, 1)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions (order_id, user_id, wallet_id, tx_type, amount, related_user_id, actor_user_id, created_at, updated_at, prev_hash, hash) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)")).
+			WithArgs(withdrawReq.OrderID, withdrawReq.UserID, 1, data.TxTypeWithdraw, withdrawReq.Amount, 0, withdrawReq.UserID, tn, tn, "", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		expectChainHeadUpdate(mock, 1)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO outbox")).WillReturnResult(sqlmock.NewResult(1, 1))
 		mock.ExpectCommit()
 
 		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
@@ -285,15 +383,15 @@ func TestWithdraw(t *testing.T) {
 		logID := util.Uniqid()
 		ctx := context.Background()
 		lockKey := "withdraw:" + logID
-		loker := util.NewDistributedLockMock(logID, lockKey, 5, ctx)
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, lockKey, 5)
 		withdrawReq := &data.WithdrawReq{OrderID: logID, UserID: 101, Amount: 1000.00}
 		tn := time.Now().Unix()
 		// mock DB data
 		mock.ExpectBegin()
 		transRows := sqlmock.NewRows([]string{})
-		mock.ExpectQuery("SELECT id,order_id,user_id,tx_type,amount,related_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(withdrawReq.OrderID).WillReturnRows(transRows)
-		walletRows := sqlmock.NewRows([]string{"id", "user_id", "balance", "created_at", "updated_at"}).AddRow(1, withdrawReq.UserID, 500.00, tn, tn)
-		mock.ExpectQuery("SELECT id,user_id,balance,created_at,updated_at FROM wallets WHERE user_id = ?").WithArgs(withdrawReq.UserID).WillReturnRows(walletRows)
+		mock.ExpectQuery("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(withdrawReq.OrderID).WillReturnRows(transRows)
+		walletRows := sqlmock.NewRows([]string{"id", "user_id", "org_id", "name", "balance", "credit_limit", "held", "product", "created_at", "updated_at"}).AddRow(1, withdrawReq.UserID, 0, data.DefaultPocket, 500.00, 0, 0, "", tn, tn)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 AND name = $2")).WithArgs(withdrawReq.UserID, data.DefaultPocket).WillReturnRows(walletRows)
 		mock.ExpectRollback()
 
 		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
@@ -309,14 +407,14 @@ func TestWithdraw(t *testing.T) {
 		logID := util.Uniqid()
 		ctx := context.Background()
 		lockKey := "withdraw:" + logID
-		loker := util.NewDistributedLockMock(logID, lockKey, 5, ctx)
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, lockKey, 5)
 		withdrawReq := &data.WithdrawReq{OrderID: logID, UserID: 101, Amount: 1000.00}
 		// mock DB data
 		mock.ExpectBegin()
 		transRows := sqlmock.NewRows([]string{})
-		mock.ExpectQuery("SELECT id,order_id,user_id,tx_type,amount,related_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(withdrawReq.OrderID).WillReturnRows(transRows)
+		mock.ExpectQuery("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(withdrawReq.OrderID).WillReturnRows(transRows)
 		walletRows := sqlmock.NewRows([]string{})
-		mock.ExpectQuery("SELECT id,user_id,balance,created_at,updated_at FROM wallets WHERE user_id = ?").WithArgs(withdrawReq.UserID).WillReturnRows(walletRows)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 AND name = $2")).WithArgs(withdrawReq.UserID, data.DefaultPocket).WillReturnRows(walletRows)
 		mock.ExpectRollback()
 
 		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
@@ -332,14 +430,14 @@ func TestWithdraw(t *testing.T) {
 		logID := util.Uniqid()
 		ctx := context.Background()
 		lockKey := "withdraw:" + logID
-		loker := util.NewDistributedLockMock(logID, lockKey, 5, ctx)
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, lockKey, 5)
 		withdrawReq := &data.WithdrawReq{OrderID: logID, UserID: 101, Amount: 1000.00}
 		tn := time.Now().Unix()
 		// mock DB data
 		mock.ExpectBegin()
-		transRows := sqlmock.NewRows([]string{"id", "order_id", "user_id", "tx_type", "amount", "related_user_id", "created_at", "updated_at"})
-		transRows.AddRow(1, withdrawReq.OrderID, withdrawReq.UserID, data.TxTypeWithdraw, withdrawReq.Amount, 0, tn, tn)
-		mock.ExpectQuery("SELECT id,order_id,user_id,tx_type,amount,related_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(withdrawReq.OrderID).WillReturnRows(transRows)
+		transRows := sqlmock.NewRows([]string{"id", "order_id", "user_id", "wallet_id", "tx_type", "amount", "related_user_id", "actor_user_id", "created_at", "updated_at"})
+		transRows.AddRow(1, withdrawReq.OrderID, withdrawReq.UserID, 1, data.TxTypeWithdraw, withdrawReq.Amount, 0, withdrawReq.UserID, tn, tn)
+		mock.ExpectQuery("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(withdrawReq.OrderID).WillReturnRows(transRows)
 		mock.ExpectRollback()
 
 		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
@@ -355,18 +453,18 @@ func TestWithdraw(t *testing.T) {
 		logID := util.Uniqid()
 		ctx := context.Background()
 		lockKey := "withdraw:" + logID
-		loker := util.NewDistributedLockMock(logID, lockKey, 5, ctx)
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, lockKey, 5)
 		withdrawReq := &data.WithdrawReq{OrderID: logID, UserID: 101, Amount: 1000.00}
 		tn := time.Now().Unix()
 		// mock DB data
 		mock.ExpectBegin()
 		transRows := sqlmock.NewRows([]string{})
-		mock.ExpectQuery("SELECT id,order_id,user_id,tx_type,amount,related_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(withdrawReq.OrderID).WillReturnRows(transRows)
-		walletRows := sqlmock.NewRows([]string{"id", "user_id", "balance", "created_at", "updated_at"}).AddRow(1, withdrawReq.UserID, 2000.00, tn, tn)
-		mock.ExpectQuery("SELECT id,user_id,balance,created_at,updated_at FROM wallets WHERE user_id = ?").WithArgs(withdrawReq.UserID).WillReturnRows(walletRows)
+		mock.ExpectQuery("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(withdrawReq.OrderID).WillReturnRows(transRows)
+		walletRows := sqlmock.NewRows([]string{"id", "user_id", "org_id", "name", "balance", "credit_limit", "held", "product", "created_at", "updated_at"}).AddRow(1, withdrawReq.UserID, 0, data.DefaultPocket, 2000.00, 0, 0, "", tn, tn)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 AND name = $2")).WithArgs(withdrawReq.UserID, data.DefaultPocket).WillReturnRows(walletRows)
 
-		mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET balance = wallets.balance - $1, updated_at = $2 WHERE user_id = $3")).
-			WithArgs(withdrawReq.Amount, tn, withdrawReq.UserID).WillReturnError(errors.New("update wallet fail"))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET balance = wallets.balance - $1, updated_at = $2 WHERE id = $3")).
+			WithArgs(withdrawReq.Amount, tn, 1).WillReturnError(errors.New("update wallet fail"))
 		mock.ExpectRollback()
 
 		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
@@ -382,21 +480,22 @@ func TestWithdraw(t *testing.T) {
 		logID := util.Uniqid()
 		ctx := context.Background()
 		lockKey := "withdraw:" + logID
-		loker := util.NewDistributedLockMock(logID, lockKey, 5, ctx)
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, lockKey, 5)
 		withdrawReq := &data.WithdrawReq{OrderID: logID, UserID: 101, Amount: 1000.00}
 		tn := time.Now().Unix()
 		// mock DB data
 		mock.ExpectBegin()
 		transRows := sqlmock.NewRows([]string{})
-		mock.ExpectQuery("SELECT id,order_id,user_id,tx_type,amount,related_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(withdrawReq.OrderID).WillReturnRows(transRows)
-		walletRows := sqlmock.NewRows([]string{"id", "user_id", "balance", "created_at", "updated_at"}).AddRow(1, withdrawReq.UserID, 2000.00, tn, tn)
-		mock.ExpectQuery("SELECT id,user_id,balance,created_at,updated_at FROM wallets WHERE user_id = ?").WithArgs(withdrawReq.UserID).WillReturnRows(walletRows)
+		mock.ExpectQuery("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(withdrawReq.OrderID).WillReturnRows(transRows)
+		walletRows := sqlmock.NewRows([]string{"id", "user_id", "org_id", "name", "balance", "credit_limit", "held", "product", "created_at", "updated_at"}).AddRow(1, withdrawReq.UserID, 0, data.DefaultPocket, 2000.00, 0, 0, "", tn, tn)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 AND name = $2")).WithArgs(withdrawReq.UserID, data.DefaultPocket).WillReturnRows(walletRows)
 
-		mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET balance = wallets.balance - $1, updated_at = $2 WHERE user_id = $3")).
-			WithArgs(withdrawReq.Amount, tn, withdrawReq.UserID).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET balance = wallets.balance - $1, updated_at = $2 WHERE id = $3")).
+			WithArgs(withdrawReq.Amount, tn, 1).WillReturnResult(sqlmock.NewResult(1, 1))
 
-		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions (order_id, user_id, tx_type, amount, related_user_id, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7)")).
-			WithArgs(withdrawReq.OrderID, withdrawReq.UserID, data.TxTypeWithdraw, withdrawReq.Amount, 0, tn, tn).WillReturnError(errors.New("db error"))
+		expectChainHead(mock, 1)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions (order_id, user_id, wallet_id, tx_type, amount, related_user_id, actor_user_id, created_at, updated_at, prev_hash, hash) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)")).
+			WithArgs(withdrawReq.OrderID, withdrawReq.UserID, 1, data.TxTypeWithdraw, withdrawReq.Amount, 0, withdrawReq.UserID, tn, tn, "", sqlmock.AnyArg()).WillReturnError(errors.New("db error"))
 		mock.ExpectRollback()
 
 		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
@@ -412,13 +511,77 @@ func TestWithdraw(t *testing.T) {
 		logID := util.Uniqid()
 		ctx := context.Background()
 		lockKey := "withdraw:" + logID
-		loker := util.NewDistributedLockMock(logID, lockKey, -1, ctx)
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, lockKey, -1)
 		withdrawReq := &data.WithdrawReq{OrderID: logID, UserID: 101, Amount: 1000.00}
 		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
 		rsp, err := walletService.Withdraw(withdrawReq)
 		assert.NotNil(t, err)
 		assert.Equal(t, errcode.ErrCodeLockFail, rsp.Code)
 	})
+
+	t.Run("case9: withdraw success-[overdraft within credit limit]", func(t *testing.T) {
+		logID := util.Uniqid()
+		ctx := context.Background()
+		lockKey := "withdraw:" + logID
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, lockKey, 5)
+		withdrawReq := &data.WithdrawReq{OrderID: logID, UserID: 101, Amount: 1000.00}
+		tn := time.Now().Unix()
+		// mock DB data
+		mock.ExpectBegin()
+		transRows := sqlmock.NewRows([]string{})
+		mock.ExpectQuery("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(withdrawReq.OrderID).WillReturnRows(transRows)
+		walletRows := sqlmock.NewRows([]string{"id", "user_id", "org_id", "name", "balance", "credit_limit", "held", "product", "created_at", "updated_at"}).AddRow(1, withdrawReq.UserID, 0, data.DefaultPocket, 200.00, 1000.00, 0, "", tn, tn)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 AND name = $2")).WithArgs(withdrawReq.UserID, data.DefaultPocket).WillReturnRows(walletRows)
+
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET balance = wallets.balance - $1, updated_at = $2 WHERE id = $3")).
+			WithArgs(withdrawReq.Amount, tn, 1).WillReturnResult(sqlmock.NewResult(1, 1))
+
+		expectChainHead(mock, 1)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions (order_id, user_id, wallet_id, tx_type, amount, related_user_id, actor_user_id, created_at, updated_at, prev_hash, hash) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)")).
+			WithArgs(withdrawReq.OrderID, withdrawReq.UserID, 1, data.TxTypeWithdraw, withdrawReq.Amount, 0, withdrawReq.UserID, tn, tn, "", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		expectChainHeadUpdate(mock, 1)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO outbox")).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		hook := &overdraftHookMock{}
+		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
+		walletService.SetOverdraftHook(hook)
+		rsp, err := walletService.Withdraw(withdrawReq)
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		assert.Equal(t, 1, hook.calls)
+		assert.Equal(t, -800.00, hook.newBalance)
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("case10: withdraw fail-[amount exceeds credit limit]", func(t *testing.T) {
+		logID := util.Uniqid()
+		ctx := context.Background()
+		lockKey := "withdraw:" + logID
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, lockKey, 5)
+		withdrawReq := &data.WithdrawReq{OrderID: logID, UserID: 101, Amount: 1000.00}
+		tn := time.Now().Unix()
+		// mock DB data
+		mock.ExpectBegin()
+		transRows := sqlmock.NewRows([]string{})
+		mock.ExpectQuery("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(withdrawReq.OrderID).WillReturnRows(transRows)
+		walletRows := sqlmock.NewRows([]string{"id", "user_id", "org_id", "name", "balance", "credit_limit", "held", "product", "created_at", "updated_at"}).AddRow(1, withdrawReq.UserID, 0, data.DefaultPocket, 200.00, 500.00, 0, "", tn, tn)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 AND name = $2")).WithArgs(withdrawReq.UserID, data.DefaultPocket).WillReturnRows(walletRows)
+		mock.ExpectRollback()
+
+		hook := &overdraftHookMock{}
+		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
+		walletService.SetOverdraftHook(hook)
+		rsp, err := walletService.Withdraw(withdrawReq)
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodeBalanceNotEnough, rsp.Code)
+		assert.Equal(t, 0, hook.calls)
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
 }
 
 func TestTransfer(t *testing.T) {
@@ -432,28 +595,33 @@ func TestTransfer(t *testing.T) {
 		logID := util.Uniqid()
 		ctx := context.Background()
 		lockKey := "transfer:" + logID
-		loker := util.NewDistributedLockMock(logID, lockKey, 5, ctx)
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, lockKey, 5)
 		transferReq := &data.TransferReq{OrderID: logID, FromUserID: 101, ToUserID: 102, Amount: 1000.00}
 		tn := time.Now().Unix()
 		// mock DB data
 		mock.ExpectBegin()
 		transRows := sqlmock.NewRows([]string{})
-		mock.ExpectQuery("SELECT id,order_id,user_id,tx_type,amount,related_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(transferReq.OrderID).WillReturnRows(transRows)
-		walletRows := sqlmock.NewRows([]string{"id", "user_id", "balance", "created_at", "updated_at"}).AddRow(1, transferReq.FromUserID, 2000.00, tn, tn)
-		mock.ExpectQuery("SELECT id,user_id,balance,created_at,updated_at FROM wallets WHERE user_id = ?").WithArgs(transferReq.FromUserID).WillReturnRows(walletRows)
+		mock.ExpectQuery("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(transferReq.OrderID).WillReturnRows(transRows)
+		walletRows := sqlmock.NewRows([]string{"id", "user_id", "org_id", "name", "balance", "credit_limit", "held", "product", "created_at", "updated_at"}).AddRow(1, transferReq.FromUserID, 0, data.DefaultPocket, 2000.00, 0, 0, "", tn, tn)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 AND name = $2")).WithArgs(transferReq.FromUserID, data.DefaultPocket).WillReturnRows(walletRows)
 
-		mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET balance = wallets.balance - $1, updated_at = $2 WHERE user_id = $3")).
-			WithArgs(transferReq.Amount, tn, transferReq.FromUserID).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET balance = wallets.balance - $1, updated_at = $2 WHERE id = $3")).
+			WithArgs(transferReq.Amount, tn, 1).WillReturnResult(sqlmock.NewResult(1, 1))
 
 		walletRows2 := sqlmock.NewRows([]string{})
-		mock.ExpectQuery("SELECT id,user_id,balance,created_at,updated_at FROM wallets WHERE user_id = ?").WithArgs(transferReq.ToUserID).WillReturnRows(walletRows2)
-		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO wallets (user_id, balance,created_at,updated_at) VALUES ($1, $2, $3, $4)")).
-			WithArgs(transferReq.ToUserID, transferReq.Amount, tn, tn).WillReturnResult(sqlmock.NewResult(1, 1))
-
-		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions (order_id, user_id, tx_type, amount, related_user_id, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7)")).
-			WithArgs(transferReq.OrderID, transferReq.FromUserID, data.TxTypeTransferOut, transferReq.Amount, transferReq.ToUserID, tn, tn).WillReturnResult(sqlmock.NewResult(1, 1))
-		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions (order_id, user_id, tx_type, amount, related_user_id, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7)")).
-			WithArgs(transferReq.OrderID, transferReq.ToUserID, data.TxTypeTransferIn, transferReq.Amount, transferReq.FromUserID, tn, tn).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 AND name = $2")).WithArgs(transferReq.ToUserID, data.DefaultPocket).WillReturnRows(walletRows2)
+		mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO wallets (user_id, name, balance, created_at, updated_at) VALUES ($1, $2, $3, $4, $5) RETURNING id")).
+			WithArgs(transferReq.ToUserID, data.DefaultPocket, transferReq.Amount, tn, tn).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+
+		expectChainHead(mock, 1)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions (order_id, user_id, wallet_id, tx_type, amount, related_user_id, actor_user_id, created_at, updated_at, prev_hash, hash) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)")).
+			WithArgs(transferReq.OrderID, transferReq.FromUserID, 1, data.TxTypeTransferOut, transferReq.Amount, transferReq.ToUserID, transferReq.FromUserID, tn, tn, "", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		expectChainHeadUpdate(mock, 1)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO outbox")).WillReturnResult(sqlmock.NewResult(1, 1))
+		expectChainHead(mock, 2)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions (order_id, user_id, wallet_id, tx_type, amount, related_user_id, actor_user_id, created_at, updated_at, prev_hash, hash) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)")).
+			WithArgs(transferReq.OrderID, transferReq.ToUserID, 2, data.TxTypeTransferIn, transferReq.Amount, transferReq.FromUserID, transferReq.FromUserID, tn, tn, "", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		expectChainHeadUpdate(mock, 2)
 		mock.ExpectCommit()
 
 		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
@@ -469,28 +637,33 @@ func TestTransfer(t *testing.T) {
 		logID := util.Uniqid()
 		ctx := context.Background()
 		lockKey := "transfer:" + logID
-		loker := util.NewDistributedLockMock(logID, lockKey, 5, ctx)
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, lockKey, 5)
 		transferReq := &data.TransferReq{OrderID: logID, FromUserID: 101, ToUserID: 102, Amount: 1000.00}
 		tn := time.Now().Unix()
 		// mock DB data
 		mock.ExpectBegin()
 		transRows := sqlmock.NewRows([]string{})
-		mock.ExpectQuery("SELECT id,order_id,user_id,tx_type,amount,related_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(transferReq.OrderID).WillReturnRows(transRows)
-		walletRows := sqlmock.NewRows([]string{"id", "user_id", "balance", "created_at", "updated_at"}).AddRow(1, transferReq.FromUserID, 1000.00, tn, tn)
-		mock.ExpectQuery("SELECT id,user_id,balance,created_at,updated_at FROM wallets WHERE user_id = ?").WithArgs(transferReq.FromUserID).WillReturnRows(walletRows)
+		mock.ExpectQuery("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(transferReq.OrderID).WillReturnRows(transRows)
+		walletRows := sqlmock.NewRows([]string{"id", "user_id", "org_id", "name", "balance", "credit_limit", "held", "product", "created_at", "updated_at"}).AddRow(1, transferReq.FromUserID, 0, data.DefaultPocket, 1000.00, 0, 0, "", tn, tn)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 AND name = $2")).WithArgs(transferReq.FromUserID, data.DefaultPocket).WillReturnRows(walletRows)
 
-		mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET balance = wallets.balance - $1, updated_at = $2 WHERE user_id = $3")).
-			WithArgs(transferReq.Amount, tn, transferReq.FromUserID).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET balance = wallets.balance - $1, updated_at = $2 WHERE id = $3")).
+			WithArgs(transferReq.Amount, tn, 1).WillReturnResult(sqlmock.NewResult(1, 1))
 
 		walletRows2 := sqlmock.NewRows([]string{})
-		mock.ExpectQuery("SELECT id,user_id,balance,created_at,updated_at FROM wallets WHERE user_id = ?").WithArgs(transferReq.ToUserID).WillReturnRows(walletRows2)
-		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO wallets (user_id, balance,created_at,updated_at) VALUES ($1, $2, $3, $4)")).
-			WithArgs(transferReq.ToUserID, transferReq.Amount, tn, tn).WillReturnResult(sqlmock.NewResult(1, 1))
-
-		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions (order_id, user_id, tx_type, amount, related_user_id, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7)")).
-			WithArgs(transferReq.OrderID, transferReq.FromUserID, data.TxTypeTransferOut, transferReq.Amount, transferReq.ToUserID, tn, tn).WillReturnResult(sqlmock.NewResult(1, 1))
-		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions (order_id, user_id, tx_type, amount, related_user_id, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7)")).
-			WithArgs(transferReq.OrderID, transferReq.ToUserID, data.TxTypeTransferIn, transferReq.Amount, transferReq.FromUserID, tn, tn).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 AND name = $2")).WithArgs(transferReq.ToUserID, data.DefaultPocket).WillReturnRows(walletRows2)
+		mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO wallets (user_id, name, balance, created_at, updated_at) VALUES ($1, $2, $3, $4, $5) RETURNING id")).
+			WithArgs(transferReq.ToUserID, data.DefaultPocket, transferReq.Amount, tn, tn).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+
+		expectChainHead(mock, 1)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions (order_id, user_id, wallet_id, tx_type, amount, related_user_id, actor_user_id, created_at, updated_at, prev_hash, hash) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)")).
+			WithArgs(transferReq.OrderID, transferReq.FromUserID, 1, data.TxTypeTransferOut, transferReq.Amount, transferReq.ToUserID, transferReq.FromUserID, tn, tn, "", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		expectChainHeadUpdate(mock, 1)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO outbox")).WillReturnResult(sqlmock.NewResult(1, 1))
+		expectChainHead(mock, 2)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions (order_id, user_id, wallet_id, tx_type, amount, related_user_id, actor_user_id, created_at, updated_at, prev_hash, hash) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)")).
+			WithArgs(transferReq.OrderID, transferReq.ToUserID, 2, data.TxTypeTransferIn, transferReq.Amount, transferReq.FromUserID, transferReq.FromUserID, tn, tn, "", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		expectChainHeadUpdate(mock, 2)
 		mock.ExpectCommit()
 
 		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
@@ -506,15 +679,15 @@ func TestTransfer(t *testing.T) {
 		logID := util.Uniqid()
 		ctx := context.Background()
 		lockKey := "transfer:" + logID
-		loker := util.NewDistributedLockMock(logID, lockKey, 5, ctx)
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, lockKey, 5)
 		transferReq := &data.TransferReq{OrderID: logID, FromUserID: 101, ToUserID: 102, Amount: 1000.00}
 		tn := time.Now().Unix()
 		// mock DB data
 		mock.ExpectBegin()
 		transRows := sqlmock.NewRows([]string{})
-		mock.ExpectQuery("SELECT id,order_id,user_id,tx_type,amount,related_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(transferReq.OrderID).WillReturnRows(transRows)
-		walletRows := sqlmock.NewRows([]string{"id", "user_id", "balance", "created_at", "updated_at"}).AddRow(1, transferReq.FromUserID, 500.00, tn, tn)
-		mock.ExpectQuery("SELECT id,user_id,balance,created_at,updated_at FROM wallets WHERE user_id = ?").WithArgs(transferReq.FromUserID).WillReturnRows(walletRows)
+		mock.ExpectQuery("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(transferReq.OrderID).WillReturnRows(transRows)
+		walletRows := sqlmock.NewRows([]string{"id", "user_id", "org_id", "name", "balance", "credit_limit", "held", "product", "created_at", "updated_at"}).AddRow(1, transferReq.FromUserID, 0, data.DefaultPocket, 500.00, 0, 0, "", tn, tn)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 AND name = $2")).WithArgs(transferReq.FromUserID, data.DefaultPocket).WillReturnRows(walletRows)
 		mock.ExpectRollback()
 
 		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
@@ -530,15 +703,15 @@ func TestTransfer(t *testing.T) {
 		logID := util.Uniqid()
 		ctx := context.Background()
 		lockKey := "transfer:" + logID
-		loker := util.NewDistributedLockMock(logID, lockKey, 5, ctx)
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, lockKey, 5)
 		transferReq := &data.TransferReq{OrderID: logID, FromUserID: 101, ToUserID: 102, Amount: 1000.00}
 
 		// mock DB data
 		mock.ExpectBegin()
 		transRows := sqlmock.NewRows([]string{})
-		mock.ExpectQuery("SELECT id,order_id,user_id,tx_type,amount,related_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(transferReq.OrderID).WillReturnRows(transRows)
+		mock.ExpectQuery("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(transferReq.OrderID).WillReturnRows(transRows)
 		walletRows := sqlmock.NewRows([]string{})
-		mock.ExpectQuery("SELECT id,user_id,balance,created_at,updated_at FROM wallets WHERE user_id = ?").WithArgs(transferReq.FromUserID).WillReturnRows(walletRows)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 AND name = $2")).WithArgs(transferReq.FromUserID, data.DefaultPocket).WillReturnRows(walletRows)
 		mock.ExpectRollback()
 
 		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
@@ -554,18 +727,18 @@ func TestTransfer(t *testing.T) {
 		logID := util.Uniqid()
 		ctx := context.Background()
 		lockKey := "transfer:" + logID
-		loker := util.NewDistributedLockMock(logID, lockKey, 5, ctx)
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, lockKey, 5)
 		transferReq := &data.TransferReq{OrderID: logID, FromUserID: 101, ToUserID: 102, Amount: 1000.00}
 		tn := time.Now().Unix()
 		// mock DB data
 		mock.ExpectBegin()
 		transRows := sqlmock.NewRows([]string{})
-		mock.ExpectQuery("SELECT id,order_id,user_id,tx_type,amount,related_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(transferReq.OrderID).WillReturnRows(transRows)
-		walletRows := sqlmock.NewRows([]string{"id", "user_id", "balance", "created_at", "updated_at"}).AddRow(1, transferReq.FromUserID, 2000.00, tn, tn)
-		mock.ExpectQuery("SELECT id,user_id,balance,created_at,updated_at FROM wallets WHERE user_id = ?").WithArgs(transferReq.FromUserID).WillReturnRows(walletRows)
+		mock.ExpectQuery("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(transferReq.OrderID).WillReturnRows(transRows)
+		walletRows := sqlmock.NewRows([]string{"id", "user_id", "org_id", "name", "balance", "credit_limit", "held", "product", "created_at", "updated_at"}).AddRow(1, transferReq.FromUserID, 0, data.DefaultPocket, 2000.00, 0, 0, "", tn, tn)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 AND name = $2")).WithArgs(transferReq.FromUserID, data.DefaultPocket).WillReturnRows(walletRows)
 
-		mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET balance = wallets.balance - $1, updated_at = $2 WHERE user_id = $3")).
-			WithArgs(transferReq.Amount, tn, transferReq.FromUserID).WillReturnError(errors.New("db error"))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET balance = wallets.balance - $1, updated_at = $2 WHERE id = $3")).
+			WithArgs(transferReq.Amount, tn, 1).WillReturnError(errors.New("db error"))
 		mock.ExpectRollback()
 
 		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
@@ -581,24 +754,24 @@ func TestTransfer(t *testing.T) {
 		logID := util.Uniqid()
 		ctx := context.Background()
 		lockKey := "transfer:" + logID
-		loker := util.NewDistributedLockMock(logID, lockKey, 5, ctx)
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, lockKey, 5)
 		transferReq := &data.TransferReq{OrderID: logID, FromUserID: 101, ToUserID: 102, Amount: 1000.00}
 		tn := time.Now().Unix()
 		// mock DB data
 		mock.ExpectBegin()
 		transRows := sqlmock.NewRows([]string{})
-		mock.ExpectQuery("SELECT id,order_id,user_id,tx_type,amount,related_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(transferReq.OrderID).WillReturnRows(transRows)
-		walletRows := sqlmock.NewRows([]string{"id", "user_id", "balance", "created_at", "updated_at"}).AddRow(1, transferReq.FromUserID, 2000.00, tn, tn)
-		mock.ExpectQuery("SELECT id,user_id,balance,created_at,updated_at FROM wallets WHERE user_id = ?").WithArgs(transferReq.FromUserID).WillReturnRows(walletRows)
+		mock.ExpectQuery("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(transferReq.OrderID).WillReturnRows(transRows)
+		walletRows := sqlmock.NewRows([]string{"id", "user_id", "org_id", "name", "balance", "credit_limit", "held", "product", "created_at", "updated_at"}).AddRow(1, transferReq.FromUserID, 0, data.DefaultPocket, 2000.00, 0, 0, "", tn, tn)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 AND name = $2")).WithArgs(transferReq.FromUserID, data.DefaultPocket).WillReturnRows(walletRows)
 
-		mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET balance = wallets.balance - $1, updated_at = $2 WHERE user_id = $3")).
-			WithArgs(transferReq.Amount, tn, transferReq.FromUserID).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET balance = wallets.balance - $1, updated_at = $2 WHERE id = $3")).
+			WithArgs(transferReq.Amount, tn, 1).WillReturnResult(sqlmock.NewResult(1, 1))
 
-		walletRowsRecv := sqlmock.NewRows([]string{"id", "user_id", "balance", "created_at", "updated_at"}).AddRow(1, transferReq.ToUserID, 2000.00, tn, tn)
-		mock.ExpectQuery("SELECT id,user_id,balance,created_at,updated_at FROM wallets WHERE user_id = ?").WithArgs(transferReq.ToUserID).WillReturnRows(walletRowsRecv)
+		walletRowsRecv := sqlmock.NewRows([]string{"id", "user_id", "org_id", "name", "balance", "credit_limit", "held", "product", "created_at", "updated_at"}).AddRow(2, transferReq.ToUserID, 0, data.DefaultPocket, 2000.00, 0, 0, "", tn, tn)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 AND name = $2")).WithArgs(transferReq.ToUserID, data.DefaultPocket).WillReturnRows(walletRowsRecv)
 
-		mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET balance = wallets.balance + $1, updated_at = $2 WHERE user_id = $3")).
-			WithArgs(transferReq.Amount, tn, transferReq.ToUserID).WillReturnError(errors.New("db error"))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET balance = wallets.balance + $1, updated_at = $2 WHERE id = $3")).
+			WithArgs(transferReq.Amount, tn, 2).WillReturnError(errors.New("db error"))
 		mock.ExpectRollback()
 
 		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
@@ -614,23 +787,23 @@ func TestTransfer(t *testing.T) {
 		logID := util.Uniqid()
 		ctx := context.Background()
 		lockKey := "transfer:" + logID
-		loker := util.NewDistributedLockMock(logID, lockKey, 5, ctx)
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, lockKey, 5)
 		transferReq := &data.TransferReq{OrderID: logID, FromUserID: 101, ToUserID: 102, Amount: 1000.00}
 		tn := time.Now().Unix()
 		// mock DB data
 		mock.ExpectBegin()
 		transRows := sqlmock.NewRows([]string{})
-		mock.ExpectQuery("SELECT id,order_id,user_id,tx_type,amount,related_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(transferReq.OrderID).WillReturnRows(transRows)
-		walletRows := sqlmock.NewRows([]string{"id", "user_id", "balance", "created_at", "updated_at"}).AddRow(1, transferReq.FromUserID, 2000.00, tn, tn)
-		mock.ExpectQuery("SELECT id,user_id,balance,created_at,updated_at FROM wallets WHERE user_id = ?").WithArgs(transferReq.FromUserID).WillReturnRows(walletRows)
+		mock.ExpectQuery("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(transferReq.OrderID).WillReturnRows(transRows)
+		walletRows := sqlmock.NewRows([]string{"id", "user_id", "org_id", "name", "balance", "credit_limit", "held", "product", "created_at", "updated_at"}).AddRow(1, transferReq.FromUserID, 0, data.DefaultPocket, 2000.00, 0, 0, "", tn, tn)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 AND name = $2")).WithArgs(transferReq.FromUserID, data.DefaultPocket).WillReturnRows(walletRows)
 
-		mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET balance = wallets.balance - $1, updated_at = $2 WHERE user_id = $3")).
-			WithArgs(transferReq.Amount, tn, transferReq.FromUserID).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET balance = wallets.balance - $1, updated_at = $2 WHERE id = $3")).
+			WithArgs(transferReq.Amount, tn, 1).WillReturnResult(sqlmock.NewResult(1, 1))
 
-		mock.ExpectQuery("SELECT id,user_id,balance,created_at,updated_at FROM wallets WHERE user_id = ?").WithArgs(transferReq.ToUserID).WillReturnError(sql.ErrNoRows)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 AND name = $2")).WithArgs(transferReq.ToUserID, data.DefaultPocket).WillReturnError(sql.ErrNoRows)
 
-		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO wallets (user_id, balance,created_at,updated_at) VALUES ($1, $2, $3, $4)")).
-			WithArgs(transferReq.ToUserID, transferReq.Amount, tn, tn).WillReturnError(errors.New("db error"))
+		mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO wallets (user_id, name, balance, created_at, updated_at) VALUES ($1, $2, $3, $4, $5) RETURNING id")).
+			WithArgs(transferReq.ToUserID, data.DefaultPocket, transferReq.Amount, tn, tn).WillReturnError(errors.New("db error"))
 		mock.ExpectRollback()
 
 		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
@@ -646,26 +819,27 @@ func TestTransfer(t *testing.T) {
 		logID := util.Uniqid()
 		ctx := context.Background()
 		lockKey := "transfer:" + logID
-		loker := util.NewDistributedLockMock(logID, lockKey, 5, ctx)
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, lockKey, 5)
 		transferReq := &data.TransferReq{OrderID: logID, FromUserID: 101, ToUserID: 102, Amount: 1000.00}
 		tn := time.Now().Unix()
 		// mock DB data
 		mock.ExpectBegin()
 		transRows := sqlmock.NewRows([]string{})
-		mock.ExpectQuery("SELECT id,order_id,user_id,tx_type,amount,related_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(transferReq.OrderID).WillReturnRows(transRows)
-		walletRows := sqlmock.NewRows([]string{"id", "user_id", "balance", "created_at", "updated_at"}).AddRow(1, transferReq.FromUserID, 2000.00, tn, tn)
-		mock.ExpectQuery("SELECT id,user_id,balance,created_at,updated_at FROM wallets WHERE user_id = ?").WithArgs(transferReq.FromUserID).WillReturnRows(walletRows)
+		mock.ExpectQuery("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(transferReq.OrderID).WillReturnRows(transRows)
+		walletRows := sqlmock.NewRows([]string{"id", "user_id", "org_id", "name", "balance", "credit_limit", "held", "product", "created_at", "updated_at"}).AddRow(1, transferReq.FromUserID, 0, data.DefaultPocket, 2000.00, 0, 0, "", tn, tn)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 AND name = $2")).WithArgs(transferReq.FromUserID, data.DefaultPocket).WillReturnRows(walletRows)
 
-		mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET balance = wallets.balance - $1, updated_at = $2 WHERE user_id = $3")).
-			WithArgs(transferReq.Amount, tn, transferReq.FromUserID).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET balance = wallets.balance - $1, updated_at = $2 WHERE id = $3")).
+			WithArgs(transferReq.Amount, tn, 1).WillReturnResult(sqlmock.NewResult(1, 1))
 
 		walletRows2 := sqlmock.NewRows([]string{})
-		mock.ExpectQuery("SELECT id,user_id,balance,created_at,updated_at FROM wallets WHERE user_id = ?").WithArgs(transferReq.ToUserID).WillReturnRows(walletRows2)
-		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO wallets (user_id, balance,created_at,updated_at) VALUES ($1, $2, $3, $4)")).
-			WithArgs(transferReq.ToUserID, transferReq.Amount, tn, tn).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 AND name = $2")).WithArgs(transferReq.ToUserID, data.DefaultPocket).WillReturnRows(walletRows2)
+		mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO wallets (user_id, name, balance, created_at, updated_at) VALUES ($1, $2, $3, $4, $5) RETURNING id")).
+			WithArgs(transferReq.ToUserID, data.DefaultPocket, transferReq.Amount, tn, tn).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
 
-		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions (order_id, user_id, tx_type, amount, related_user_id, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7)")).
-			WithArgs(transferReq.OrderID, transferReq.FromUserID, data.TxTypeTransferOut, transferReq.Amount, transferReq.ToUserID, tn, tn).WillReturnError(errors.New("db error"))
+		expectChainHead(mock, 1)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions (order_id, user_id, wallet_id, tx_type, amount, related_user_id, actor_user_id, created_at, updated_at, prev_hash, hash) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)")).
+			WithArgs(transferReq.OrderID, transferReq.FromUserID, 1, data.TxTypeTransferOut, transferReq.Amount, transferReq.ToUserID, transferReq.FromUserID, tn, tn, "", sqlmock.AnyArg()).WillReturnError(errors.New("db error"))
 		mock.ExpectRollback()
 
 		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
@@ -681,13 +855,57 @@ func TestTransfer(t *testing.T) {
 		logID := util.Uniqid()
 		ctx := context.Background()
 		lockKey := "transfer:" + logID
-		loker := util.NewDistributedLockMock(logID, lockKey, -1, ctx) // lock time < 0
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, lockKey, -1) // lock time < 0
 		transferReq := &data.TransferReq{OrderID: logID, FromUserID: 101, ToUserID: 102, Amount: 1000.00}
 		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker)
 		rsp, err := walletService.Transfer(transferReq)
 		assert.NotNil(t, err)
 		assert.Equal(t, errcode.ErrCodeLockFail, rsp.Code)
 	})
+
+	t.Run("case10: transfer success-[serialization failure retried]", func(t *testing.T) {
+		logID := util.Uniqid()
+		ctx := context.Background()
+		lockKey := "transfer:" + logID
+		loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, lockKey, 5)
+		transferReq := &data.TransferReq{OrderID: logID, FromUserID: 101, ToUserID: 102, Amount: 1000.00}
+		tn := time.Now().Unix()
+		// mock DB data, the first attempt is aborted by postgres at the sender's update
+		for attempt := 0; attempt < 2; attempt++ {
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE order_id = ?").WithArgs(transferReq.OrderID).WillReturnRows(sqlmock.NewRows([]string{}))
+			walletRows := sqlmock.NewRows([]string{"id", "user_id", "org_id", "name", "balance", "credit_limit", "held", "product", "created_at", "updated_at"}).AddRow(1, transferReq.FromUserID, 0, data.DefaultPocket, 2000.00, 0, 0, "", tn, tn)
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 AND name = $2")).WithArgs(transferReq.FromUserID, data.DefaultPocket).WillReturnRows(walletRows)
+			if attempt == 0 {
+				mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET balance = wallets.balance - $1, updated_at = $2 WHERE id = $3")).
+					WithArgs(transferReq.Amount, tn, 1).WillReturnError(&pq.Error{Code: "40001", Message: "could not serialize access due to concurrent update"})
+				mock.ExpectRollback()
+			}
+		}
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE wallets SET balance = wallets.balance - $1, updated_at = $2 WHERE id = $3")).
+			WithArgs(transferReq.Amount, tn, 1).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 AND name = $2")).WithArgs(transferReq.ToUserID, data.DefaultPocket).WillReturnRows(sqlmock.NewRows([]string{}))
+		mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO wallets (user_id, name, balance, created_at, updated_at) VALUES ($1, $2, $3, $4, $5) RETURNING id")).
+			WithArgs(transferReq.ToUserID, data.DefaultPocket, transferReq.Amount, tn, tn).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+		expectChainHead(mock, 1)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions (order_id, user_id, wallet_id, tx_type, amount, related_user_id, actor_user_id, created_at, updated_at, prev_hash, hash) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)")).
+			WithArgs(transferReq.OrderID, transferReq.FromUserID, 1, data.TxTypeTransferOut, transferReq.Amount, transferReq.ToUserID, transferReq.FromUserID, tn, tn, "", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		expectChainHeadUpdate(mock, 1)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO outbox")).WillReturnResult(sqlmock.NewResult(1, 1))
+		expectChainHead(mock, 2)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO transactions (order_id, user_id, wallet_id, tx_type, amount, related_user_id, actor_user_id, created_at, updated_at, prev_hash, hash) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)")).
+			WithArgs(transferReq.OrderID, transferReq.ToUserID, 2, data.TxTypeTransferIn, transferReq.Amount, transferReq.FromUserID, transferReq.FromUserID, tn, tn, "", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		expectChainHeadUpdate(mock, 2)
+		mock.ExpectCommit()
+
+		walletService := service.NewWalletService(ctx, logID, mockDBCli, loker).WithRetryPolicy(dao.RetryPolicy{MaxRetries: 1, BaseBackoff: time.Millisecond})
+		rsp, err := walletService.Transfer(transferReq)
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
 }
 
 func TestGetBalance(t *testing.T) {
@@ -702,20 +920,28 @@ func TestGetBalance(t *testing.T) {
 		ctx := context.Background()
 		getBalanceReq := &data.GetBalanceReq{UserID: 101}
 		tn := time.Now().Unix()
-		rows := sqlmock.NewRows([]string{"id", "user_id", "balance", "created_at", "updated_at"}).AddRow(1, getBalanceReq.UserID, 1000.00, tn, tn)
-		mock.ExpectQuery("SELECT id,user_id,balance,created_at,updated_at FROM wallets WHERE user_id = ? ").WithArgs(getBalanceReq.UserID).WillReturnRows(rows)
+		rows := sqlmock.NewRows([]string{"id", "user_id", "org_id", "name", "balance", "credit_limit", "held", "product", "created_at", "updated_at"})
+		rows.AddRow(1, getBalanceReq.UserID, 0, data.DefaultPocket, 1000.00, 500.00, 0, "", tn, tn)
+		rows.AddRow(2, getBalanceReq.UserID, 0, "savings", 300.00, 0, 0, "savings", tn, tn)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 ORDER BY id")).WithArgs(getBalanceReq.UserID).WillReturnRows(rows)
 		walletService := service.NewWalletService(ctx, logID, mockDBCli, nil)
 		rsp, err := walletService.GetBalance(getBalanceReq)
 		assert.Nil(t, err)
 		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
 		assert.Equal(t, 1000.00, rsp.Data.Balance)
+		assert.Equal(t, 500.00, rsp.Data.CreditLimit)
+		assert.Equal(t, 1500.00, rsp.Data.AvailableBalance)
+		assert.Equal(t, 2, len(rsp.Data.Pockets))
+		assert.Equal(t, "savings", rsp.Data.Pockets[1].Name)
+		assert.Equal(t, 300.00, rsp.Data.Pockets[1].Balance)
 	})
 
 	t.Run("case2: get balance fail-[user wallet record not exist]", func(t *testing.T) {
 		logID := util.Uniqid()
 		ctx := context.Background()
 		getBalanceReq := &data.GetBalanceReq{UserID: 101}
-		mock.ExpectQuery("SELECT id,user_id,balance,created_at,updated_at FROM wallets WHERE user_id = ?").WithArgs(getBalanceReq.UserID).WillReturnError(sql.ErrNoRows)
+		rows := sqlmock.NewRows([]string{"id", "user_id", "org_id", "name", "balance", "credit_limit", "held", "product", "created_at", "updated_at"})
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 ORDER BY id")).WithArgs(getBalanceReq.UserID).WillReturnRows(rows)
 		walletService := service.NewWalletService(ctx, logID, mockDBCli, nil)
 		rsp, err := walletService.GetBalance(getBalanceReq)
 		assert.NotNil(t, err)
@@ -726,12 +952,42 @@ func TestGetBalance(t *testing.T) {
 		logID := util.Uniqid()
 		ctx := context.Background()
 		getBalanceReq := &data.GetBalanceReq{UserID: 101}
-		mock.ExpectQuery("SELECT id,user_id,balance,created_at,updated_at FROM wallets WHERE user_id = ? ").WithArgs(getBalanceReq.UserID).WillReturnError(errors.New("db error"))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 ORDER BY id")).WithArgs(getBalanceReq.UserID).WillReturnError(errors.New("db error"))
 		walletService := service.NewWalletService(ctx, logID, mockDBCli, nil)
 		rsp, err := walletService.GetBalance(getBalanceReq)
 		assert.NotNil(t, err)
 		assert.Equal(t, errcode.ErrCodeQueryDBFail, rsp.Code)
 	})
+
+	t.Run("case4: get balance success-[read from replica, primary after own write]", func(t *testing.T) {
+		logID := util.Uniqid()
+		ctx := context.Background()
+		replica, replicaMock, err := sqlmock.New()
+		assert.Nil(t, err)
+		pool := db.NewReplicaPool([]*sql.DB{replica}, 0, time.Minute)
+		defer pool.Close()
+		store := dao.NewMemoryStore()
+		newService := func() *service.WalletService {
+			loker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, "deposit:"+logID, 5)
+			return service.NewWalletServiceWithStore(ctx, logID, store, loker).WithReplicas(pool)
+		}
+
+		// the replica has not seen the deposit of user 101 yet
+		rsp, err := newService().Deposit(&data.DepositReq{OrderID: logID, UserID: 101, Amount: 1000.00})
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		balanceRsp, err := newService().GetBalance(&data.GetBalanceReq{UserID: 101})
+		assert.Nil(t, err)
+		assert.Equal(t, 1000.00, balanceRsp.Data.Balance)
+
+		tn := time.Now().Unix()
+		rows := sqlmock.NewRows([]string{"id", "user_id", "org_id", "name", "balance", "credit_limit", "held", "product", "created_at", "updated_at"}).AddRow(7, 102, 0, data.DefaultPocket, 20.00, 0, 0, "", tn, tn)
+		replicaMock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 ORDER BY id")).WithArgs(102).WillReturnRows(rows)
+		balanceRsp, err = newService().GetBalance(&data.GetBalanceReq{UserID: 102})
+		assert.Nil(t, err)
+		assert.Equal(t, 20.00, balanceRsp.Data.Balance)
+		assert.Nil(t, replicaMock.ExpectationsWereMet())
+	})
 }
 
 func TestGetTransactionHistory(t *testing.T) {
@@ -747,20 +1003,25 @@ func TestGetTransactionHistory(t *testing.T) {
 		page, limit := int32(1), int32(10)
 		getHisReq := &data.GetTransactionHistoryReq{UserID: 101, Page: page, Limit: limit}
 		tn := time.Now().Unix()
-		rows := sqlmock.NewRows([]string{"id", "order_id", "user_id", "tx_type", "amount", "related_user_id", "created_at", "updated_at"})
-		rows.AddRow(1, "111", 101, 1, 2000.00, 0, tn, tn)
-		rows.AddRow(2, "222", 101, 2, 1000.00, 0, tn, tn)
-		rows.AddRow(3, "333", 101, 3, 3000.00, 102, tn, tn)
-		rows.AddRow(4, "444", 101, 4, 3000.00, 102, tn, tn)
+		rows := sqlmock.NewRows([]string{"id", "order_id", "user_id", "wallet_id", "tx_type", "amount", "related_user_id", "actor_user_id", "created_at", "updated_at"})
+		rows.AddRow(1, "111", 101, 1, 1, 2000.00, 0, 101, tn, tn)
+		rows.AddRow(2, "222", 101, 1, 2, 1000.00, 0, 101, tn, tn)
+		rows.AddRow(3, "333", 101, 1, 3, 3000.00, 102, 101, tn, tn)
+		rows.AddRow(4, "444", 101, 1, 4, 3000.00, 102, 101, tn, tn)
 		offset := (page - 1) * limit
-		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, order_id, user_id, tx_type, amount, related_user_id, created_at, updated_at FROM transactions WHERE user_id = $1 LIMIT $2 OFFSET $3")).
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE user_id = $1 ORDER BY created_at, id LIMIT $2 OFFSET $3")).
 			WithArgs(getHisReq.UserID, limit, offset).WillReturnRows(rows)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM transactions WHERE user_id = $1")).
+			WithArgs(getHisReq.UserID).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(4))
 		walletService := service.NewWalletService(ctx, logID, mockDBCli, nil)
 		rsp, err := walletService.GetTransactionHistory(getHisReq)
 		assert.Nil(t, err)
 		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		assert.Equal(t, int64(4), rsp.Data.Total)
 		assert.Equal(t, 4, len(rsp.Data.Items))
 		assert.Equal(t, "222", rsp.Data.Items[1].OrderID)
+		assert.Empty(t, rsp.Data.NextCursor)
+		assert.Empty(t, rsp.Data.PrevCursor)
 	})
 
 	t.Run("case2: get transaction history success-[history empty]", func(t *testing.T) {
@@ -769,7 +1030,7 @@ func TestGetTransactionHistory(t *testing.T) {
 		page, limit := int32(1), int32(10)
 		getHisReq := &data.GetTransactionHistoryReq{UserID: 101, Page: page, Limit: limit}
 		offset := (page - 1) * limit
-		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, order_id, user_id, tx_type, amount, related_user_id, created_at, updated_at FROM transactions WHERE user_id = $1 LIMIT $2 OFFSET $3")).
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE user_id = $1 ORDER BY created_at, id LIMIT $2 OFFSET $3")).
 			WithArgs(getHisReq.UserID, limit, offset).WillReturnError(sql.ErrNoRows)
 		walletService := service.NewWalletService(ctx, logID, mockDBCli, nil)
 		rsp, err := walletService.GetTransactionHistory(getHisReq)
@@ -777,17 +1038,112 @@ func TestGetTransactionHistory(t *testing.T) {
 		assert.Equal(t, errcode.ErrCodeTransactionNotExist, rsp.Code)
 	})
 
+	t.Run("case4: get transaction history success-[filter by pocket]", func(t *testing.T) {
+		logID := util.Uniqid()
+		ctx := context.Background()
+		page, limit := int32(1), int32(10)
+		getHisReq := &data.GetTransactionHistoryReq{UserID: 101, Page: page, Limit: limit, Pocket: "savings"}
+		tn := time.Now().Unix()
+		walletRows := sqlmock.NewRows([]string{"id", "user_id", "org_id", "name", "balance", "credit_limit", "held", "product", "created_at", "updated_at"}).AddRow(2, getHisReq.UserID, 0, "savings", 300.00, 0, 0, "", tn, tn)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,user_id,org_id,name,balance,credit_limit,held,product,created_at,updated_at FROM wallets WHERE user_id = $1 AND name = $2")).WithArgs(getHisReq.UserID, getHisReq.Pocket).WillReturnRows(walletRows)
+		rows := sqlmock.NewRows([]string{"id", "order_id", "user_id", "wallet_id", "tx_type", "amount", "related_user_id", "actor_user_id", "created_at", "updated_at"})
+		rows.AddRow(5, "555", 101, 2, data.TxTypePocketIn, 300.00, 101, 101, tn, tn)
+		offset := (page - 1) * limit
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE user_id = $1 AND wallet_id = $2 ORDER BY created_at, id LIMIT $3 OFFSET $4")).
+			WithArgs(getHisReq.UserID, 2, limit, offset).WillReturnRows(rows)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM transactions WHERE user_id = $1 AND wallet_id = $2")).
+			WithArgs(getHisReq.UserID, 2).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		walletService := service.NewWalletService(ctx, logID, mockDBCli, nil)
+		rsp, err := walletService.GetTransactionHistory(getHisReq)
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		assert.Equal(t, 1, len(rsp.Data.Items))
+		assert.Equal(t, int64(2), rsp.Data.Items[0].WalletID)
+	})
+
 	t.Run("case3: get transaction history fail-[query db fail]", func(t *testing.T) {
 		logID := util.Uniqid()
 		ctx := context.Background()
 		page, limit := int32(1), int32(10)
 		getHisReq := &data.GetTransactionHistoryReq{UserID: 101, Page: page, Limit: limit}
 		offset := (page - 1) * limit
-		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, order_id, user_id, tx_type, amount, related_user_id, created_at, updated_at FROM transactions WHERE user_id = $1 LIMIT $2 OFFSET $3")).
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE user_id = $1 ORDER BY created_at, id LIMIT $2 OFFSET $3")).
 			WithArgs(getHisReq.UserID, limit, offset).WillReturnError(errors.New("query db fail"))
 		walletService := service.NewWalletService(ctx, logID, mockDBCli, nil)
 		rsp, err := walletService.GetTransactionHistory(getHisReq)
 		assert.NotNil(t, err)
 		assert.Equal(t, errcode.ErrCodeQueryDBFail, rsp.Code)
 	})
+
+	t.Run("case5: get transaction history success-[keyset pages forward and backward]", func(t *testing.T) {
+		ctx := context.Background()
+		limit := int32(2)
+		tn := time.Now().Unix()
+		columns := []string{"id", "order_id", "user_id", "wallet_id", "tx_type", "amount", "related_user_id", "actor_user_id", "created_at", "updated_at"}
+		// first page, one row more than the limit means there is a next page
+		rows := sqlmock.NewRows(columns).AddRow(1, "111", 101, 1, 1, 2000.00, 0, 101, tn, tn).AddRow(2, "222", 101, 1, 2, 1000.00, 0, 101, tn, tn).AddRow(3, "333", 101, 1, 1, 500.00, 0, 101, tn+1, tn+1)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE user_id = $1 ORDER BY created_at, id LIMIT $2")).
+			WithArgs(101, limit+1).WillReturnRows(rows)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM transactions WHERE user_id = $1")).WithArgs(101).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+		rsp, err := service.NewWalletService(ctx, util.Uniqid(), mockDBCli, nil).GetTransactionHistory(&data.GetTransactionHistoryReq{UserID: 101, Limit: limit})
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		assert.Equal(t, []string{"111", "222"}, []string{rsp.Data.Items[0].OrderID, rsp.Data.Items[1].OrderID})
+		assert.NotEmpty(t, rsp.Data.NextCursor)
+		assert.Empty(t, rsp.Data.PrevCursor)
+
+		// next page after (tn, 2), the last one
+		rows = sqlmock.NewRows(columns).AddRow(3, "333", 101, 1, 1, 500.00, 0, 101, tn+1, tn+1)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE user_id = $1 AND (created_at, id) > ($2, $3) ORDER BY created_at, id LIMIT $4")).
+			WithArgs(101, tn, 2, limit+1).WillReturnRows(rows)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM transactions WHERE user_id = $1")).WithArgs(101).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+		rsp, err = service.NewWalletService(ctx, util.Uniqid(), mockDBCli, nil).GetTransactionHistory(&data.GetTransactionHistoryReq{UserID: 101, Cursor: rsp.Data.NextCursor, Limit: limit})
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		assert.Equal(t, 1, len(rsp.Data.Items))
+		assert.Equal(t, "333", rsp.Data.Items[0].OrderID)
+		assert.Empty(t, rsp.Data.NextCursor)
+		assert.NotEmpty(t, rsp.Data.PrevCursor)
+
+		// back before (tn+1, 3), newest first from the db, oldest first in the page
+		rows = sqlmock.NewRows(columns).AddRow(2, "222", 101, 1, 2, 1000.00, 0, 101, tn, tn).AddRow(1, "111", 101, 1, 1, 2000.00, 0, 101, tn, tn)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE user_id = $1 AND (created_at, id) < ($2, $3) ORDER BY created_at DESC, id DESC LIMIT $4")).
+			WithArgs(101, tn+1, 3, limit+1).WillReturnRows(rows)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM transactions WHERE user_id = $1")).WithArgs(101).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+		rsp, err = service.NewWalletService(ctx, util.Uniqid(), mockDBCli, nil).GetTransactionHistory(&data.GetTransactionHistoryReq{UserID: 101, Cursor: rsp.Data.PrevCursor, Limit: limit})
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		assert.Equal(t, []string{"111", "222"}, []string{rsp.Data.Items[0].OrderID, rsp.Data.Items[1].OrderID})
+		assert.NotEmpty(t, rsp.Data.NextCursor)
+		assert.Empty(t, rsp.Data.PrevCursor)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("case6: get transaction history success-[filters in the query and the count]", func(t *testing.T) {
+		tn := time.Now().Unix()
+		getHisReq := &data.GetTransactionHistoryReq{UserID: 101, Limit: 10, TxTypes: []int32{data.TxTypeTransferIn, data.TxTypeTransferOut}, StartTime: tn - 86400, EndTime: tn,
+			MinAmount: 100.00, MaxAmount: 5000.00, RelatedUserID: 102, OrderIDPrefix: "10%"}
+		rows := sqlmock.NewRows([]string{"id", "order_id", "user_id", "wallet_id", "tx_type", "amount", "related_user_id", "actor_user_id", "created_at", "updated_at"})
+		rows.AddRow(3, "10%3", 101, 1, 4, 3000.00, 102, 101, tn-60, tn-60)
+		where := "user_id = $1 AND tx_type IN ($2, $3) AND created_at >= $4 AND created_at < $5 AND amount >= $6 AND amount <= $7 AND related_user_id = $8 AND SUBSTR(order_id, 1, $9) = $10"
+		args := []driver.Value{101, data.TxTypeTransferIn, data.TxTypeTransferOut, tn - 86400, tn, 100.00, 5000.00, 102, 3, "10%"}
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id,order_id,user_id,wallet_id,tx_type,amount,related_user_id,actor_user_id,created_at,updated_at FROM transactions WHERE " + where + " ORDER BY created_at, id LIMIT $11")).
+			WithArgs(append(args, int32(11))...).WillReturnRows(rows)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM transactions WHERE " + where)).
+			WithArgs(args...).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		rsp, err := service.NewWalletService(context.Background(), util.Uniqid(), mockDBCli, nil).GetTransactionHistory(getHisReq)
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		assert.Equal(t, int64(1), rsp.Data.Total)
+		assert.Equal(t, "10%3", rsp.Data.Items[0].OrderID)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("case7: get transaction history fail-[bad cursor]", func(t *testing.T) {
+		getHisReq := &data.GetTransactionHistoryReq{UserID: 101, Cursor: "not-a-cursor", Limit: 10}
+		walletService := service.NewWalletService(context.Background(), util.Uniqid(), mockDBCli, nil)
+		rsp, err := walletService.GetTransactionHistory(getHisReq)
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodeBadRequestParam, rsp.Code)
+	})
 }
@@ -0,0 +1,309 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"simplewallet/data"
+	"simplewallet/model"
+	"simplewallet/service/dao"
+	"simplewallet/util"
+	"simplewallet/util/db"
+	"simplewallet/util/errcode"
+	"time"
+)
+
+const (
+	shardTransferBatch int32 = 500
+	// a debited transfer younger than this may still be credited by its request
+	shardTransferGrace = time.Minute
+	// failed credits before a transfer is refunded, an hour of the shard_transfer job at its default interval
+	DefaultShardTransferMaxAttempts int32 = 60
+)
+
+// errShardCreditRejected is a credit the shard of the recipient never takes, the transfer is refunded at once
+var errShardCreditRejected = errors.New("order_id already used on the shard of the recipient")
+
+// newShardedStore returns the shards of util/db with the primary store as shard 0, nil when it is not sharded
+func newShardedStore(ctx context.Context, logID string, primary dao.Store) *dao.ShardedStore {
+	shardClis := db.GetShardClients()
+	if len(shardClis) <= 1 {
+		return nil
+	}
+	shards := []dao.Store{primary}
+	for _, shardCli := range shardClis[1:] {
+		shards = append(shards, newSqlStore(ctx, logID, shardCli))
+	}
+	n := len(shards)
+	return dao.NewShardedStore(shards, func(userID int64) int { return db.ShardOf(userID, n) })
+}
+
+// shardStores returns the store of every shard of util/db, the primary first, or the one store when not sharded
+func shardStores(ctx context.Context, logID string, dbCli *sql.DB) []dao.Store {
+	stores := []dao.Store{newSqlStore(ctx, logID, dbCli)}
+	if shards := newShardedStore(ctx, logID, stores[0]); shards != nil {
+		for i := 1; i < shards.Len(); i++ {
+			stores = append(stores, shards.Shard(i))
+		}
+	}
+	return stores
+}
+
+// shardClients returns dbCli then the db clients of the other shards of util/db
+func shardClients(dbCli *sql.DB) []*sql.DB {
+	clis := []*sql.DB{dbCli}
+	if shardClis := db.GetShardClients(); len(shardClis) > 1 {
+		clis = append(clis, shardClis[1:]...)
+	}
+	return clis
+}
+
+// WithShards spreads the users over the shards, nil keeps everything in the store.
+// The store becomes shard 0, with the organizations and their shared wallets.
+func (s *WalletService) WithShards(shards *dao.ShardedStore) *WalletService {
+	s.shards = shards
+	if shards != nil {
+		s.store = shards.Shard(0)
+	}
+	return s
+}
+
+// ownerOf returns the owner of the wallets a request works on, user 0 owns the shared wallets of an organization
+func ownerOf(userID int64, orgID int64) int64 {
+	if orgID > 0 {
+		return 0
+	}
+	return userID
+}
+
+// storeFor returns the store of the shard of the owner
+func (s *WalletService) storeFor(ownerID int64) dao.Store {
+	if s.shards == nil {
+		return s.store
+	}
+	return s.shards.ForUser(ownerID)
+}
+
+func (s *WalletService) sameShard(ownerID int64, otherID int64) bool {
+	return s.shards == nil || s.shards.ShardOf(ownerID) == s.shards.ShardOf(otherID)
+}
+
+// creditShardTransfer credits the recipient of a transfer debited on the shard fromStore, then marks it
+// credited there. Both steps can run again: a credit is recorded once per order_id on the shard of the
+// recipient, so a transfer left debited by a crash is resumed with ResumeShardTransfer.
+func (s *WalletService) creditShardTransfer(fromStore dao.Store, transfer *model.ShardTransfer) error {
+	err := dao.NewTxRunner(s.ctx, s.logID, s.storeFor(transfer.ToUserID), s.retry).Run(func(tx dao.UnitOfWork) error {
+		transDao := tx.Transactions()
+		trans, err := transDao.GetTransactionByOrderID(transfer.OrderID)
+		if err != nil {
+			return err
+		}
+		if trans != nil {
+			if trans.TxType == data.TxTypeTransferIn && trans.UserID == transfer.ToUserID {
+				return nil
+			}
+			return errShardCreditRejected
+		}
+		toWalletID, err := tx.Wallets().CreateOrUpdateWallet(transfer.ToUserID, data.DefaultPocket, transfer.Amount)
+		if err != nil {
+			return err
+		}
+		return transDao.InsertTransaction(&model.Transactions{OrderID: transfer.OrderID, UserID: transfer.ToUserID, WalletID: toWalletID, TxType: data.TxTypeTransferIn, Amount: transfer.Amount, RelatedUserID: transfer.FromUserID, ActorUserID: transfer.FromUserID})
+	})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := afterCommit(s.ctx)
+	s.balanceCache.Invalidate(ctx, transfer.ToUserID)
+	cancel()
+	_, err = fromStore.ShardTransfers().MarkShardTransferCredited(transfer.OrderID)
+	return err
+}
+
+// ResumeShardTransfer credits a transfer left debited on the shard, by a crash or a failed credit.
+// It takes the lock of the transfer, the same one Transfer holds. The transfer is refunded to the sender
+// after DefaultShardTransferMaxAttempts failed credits, see resumeShardTransfer.
+func (s *WalletService) ResumeShardTransfer(shard int, orderID string) (rsp *data.CommRsp, err error) {
+	return s.resumeShardTransfer(shard, orderID, DefaultShardTransferMaxAttempts)
+}
+
+// resumeShardTransfer credits a transfer left debited on the shard, each failed credit is counted on the transfer.
+// A credit the recipient's shard rejects, or the maxAttempts-th failed one, refunds the sender instead.
+func (s *WalletService) resumeShardTransfer(shard int, orderID string, maxAttempts int32) (rsp *data.CommRsp, err error) {
+	rsp = &data.CommRsp{Code: errcode.ErrCodeInternalErr, Message: errcode.ErrMsgMap[errcode.ErrCodeInternalErr], LogID: s.logID}
+	defer func() { mapDbError(err, &rsp.Code, &rsp.Message) }()
+	if s.shards == nil || shard < 0 || shard >= s.shards.Len() {
+		err = errors.New("shard not exist")
+		rsp.Code = errcode.ErrCodeBadRequestParam
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return rsp, err
+	}
+
+	err = s.locker.Lock()
+	if err != nil {
+		rsp.Code = errcode.ErrCodeLockFail
+		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
+		return rsp, err
+	}
+	defer func() {
+		if errt := s.locker.UnLock(); errt != nil {
+			rsp.Code = errcode.ErrCodeUnLockFail
+			rsp.Message = errcode.ErrMsgMap[rsp.Code] + errt.Error()
+		}
+	}()
+
+	fromStore := s.shards.Shard(shard)
+	transfer, err := fromStore.ShardTransfers().GetShardTransferByOrderID(orderID)
+	if err != nil {
+		rsp.Code = errcode.ErrCodeQueryDBFail
+		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
+		return rsp, err
+	}
+	if transfer == nil {
+		err = errors.New("transfer not exist")
+		rsp.Code = errcode.ErrCodeTransactionNotExist
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return rsp, err
+	}
+	if transfer.Status == data.ShardTransferStatusDebited {
+		if err = s.creditShardTransfer(fromStore, transfer); err != nil {
+			log.Println("Failed to credit the recipient on their shard" + err.Error())
+			refunded, errt := s.failShardTransfer(fromStore, transfer, err, maxAttempts)
+			if errt != nil {
+				log.Println("Failed to count the failed credit or refund the sender" + errt.Error())
+			}
+			if refunded {
+				s.written(transfer.FromUserID)
+				rsp.Code = errcode.ErrCodeTransferRefunded
+				rsp.Message = errcode.ErrMsgMap[rsp.Code]
+				return rsp, err
+			}
+			rsp.Code = errcode.ErrCodeTransferPending
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return rsp, err
+		}
+		s.written(transfer.ToUserID)
+	}
+	if transfer.Status == data.ShardTransferStatusRefunded {
+		err = errors.New("transfer refunded")
+		rsp.Code = errcode.ErrCodeTransferRefunded
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return rsp, err
+	}
+
+	rsp.Code = errcode.ErrCodeSuccess
+	rsp.Message = "Transfer successful"
+	return rsp, nil
+}
+
+// failShardTransfer counts a failed credit of the transfer, and refunds the sender once the credit was rejected
+// by the shard of the recipient or failed maxAttempts times. The refund is marked with the credit of the sender
+// in one unit of work on their shard, and only once the shard of the recipient was read to hold no credit of the
+// transfer: a credit committed but reported failed is marked credited instead of paid twice.
+func (s *WalletService) failShardTransfer(fromStore dao.Store, transfer *model.ShardTransfer, creditErr error, maxAttempts int32) (bool, error) {
+	attempts, err := fromStore.ShardTransfers().AddShardTransferAttempt(transfer.OrderID)
+	if err != nil {
+		return false, err
+	}
+	if !errors.Is(creditErr, errShardCreditRejected) && attempts < maxAttempts {
+		return false, nil
+	}
+	trans, err := s.storeFor(transfer.ToUserID).Transactions().GetTransactionByOrderID(transfer.OrderID)
+	if err != nil {
+		return false, err
+	}
+	if trans != nil && trans.TxType == data.TxTypeTransferIn && trans.UserID == transfer.ToUserID {
+		_, err = fromStore.ShardTransfers().MarkShardTransferCredited(transfer.OrderID)
+		return false, err
+	}
+	refunded := false
+	err = dao.NewTxRunner(s.ctx, s.logID, fromStore, s.retry).Run(func(tx dao.UnitOfWork) error {
+		marked, err := tx.ShardTransfers().MarkShardTransferRefunded(transfer.OrderID)
+		if err != nil || !marked {
+			refunded = false
+			return err
+		}
+		wallet, err := tx.Wallets().GetWalletByID(transfer.FromWalletID)
+		if err != nil {
+			return err
+		}
+		if wallet == nil {
+			return errors.New("wallet of the sender not exist")
+		}
+		if err = tx.Wallets().UpdateWalletBalance(wallet.ID, data.TxTypeRefund, transfer.Amount); err != nil {
+			return err
+		}
+		record := &model.Transactions{OrderID: transfer.OrderID, UserID: wallet.UserID, WalletID: wallet.ID, TxType: data.TxTypeRefund, Amount: transfer.Amount, RelatedUserID: transfer.ToUserID, ActorUserID: transfer.FromUserID}
+		if err = tx.Transactions().InsertTransaction(record); err != nil {
+			return err
+		}
+		refunded = true
+		return recordEvent(tx, data.EventTypeTransferRefund, record)
+	})
+	return refunded && err == nil, err
+}
+
+// ShardTransferService resumes the transfers between shards whose credit did not complete,
+// after a crash between the debit and the credit or a failure of the shard of the recipient.
+type ShardTransferService struct {
+	logID       string
+	ctx         context.Context
+	shards      *dao.ShardedStore
+	newLocker   func(key string) util.DistributedLock
+	maxAttempts int32
+}
+
+// NewShardTransferService returns the service on the shards of util/db, it has nothing to do when not sharded.
+func NewShardTransferService(ctx context.Context, logID string, dbCli *sql.DB, newLocker func(key string) util.DistributedLock) *ShardTransferService {
+	return NewShardTransferServiceWithShards(ctx, logID, newShardedStore(ctx, logID, newSqlStore(ctx, logID, dbCli)), newLocker)
+}
+
+func NewShardTransferServiceWithShards(ctx context.Context, logID string, shards *dao.ShardedStore, newLocker func(key string) util.DistributedLock) *ShardTransferService {
+	return &ShardTransferService{
+		ctx:         ctx,
+		logID:       logID,
+		shards:      shards,
+		newLocker:   newLocker,
+		maxAttempts: DefaultShardTransferMaxAttempts,
+	}
+}
+
+// WithMaxAttempts sets the failed credits before a transfer is refunded to the sender
+func (s *ShardTransferService) WithMaxAttempts(maxAttempts int32) *ShardTransferService {
+	s.maxAttempts = maxAttempts
+	return s
+}
+
+// ResumeDue credits one batch of the transfers of every shard debited before the grace period,
+// returns how many were credited. A transfer failing again stays debited for the next run, until
+// its credit failed maxAttempts times and it is refunded.
+func (s *ShardTransferService) ResumeDue(now time.Time) (int, error) {
+	if s.shards == nil {
+		return 0, nil
+	}
+	resumed := 0
+	var firstErr error
+	for shard := 0; shard < s.shards.Len(); shard++ {
+		transferList, err := s.shards.Shard(shard).ShardTransfers().GetDebitedShardTransferList(now.Add(-shardTransferGrace).Unix(), shardTransferBatch)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		for _, transfer := range transferList {
+			walletService := NewWalletServiceWithStore(s.ctx, s.logID, s.shards.Shard(0), s.newLocker("transfer:"+transfer.OrderID)).WithShards(s.shards)
+			_, err := walletService.resumeShardTransfer(shard, transfer.OrderID, s.maxAttempts)
+			if err != nil {
+				log.Printf("%s|[%s] fail to resume shard transfer:%s\n", s.logID, transfer.OrderID, err.Error())
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			resumed++
+		}
+	}
+	return resumed, firstErr
+}
@@ -0,0 +1,281 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"simplewallet/data"
+	"simplewallet/model"
+	"simplewallet/service"
+	"simplewallet/service/dao"
+	"simplewallet/util"
+	"simplewallet/util/db"
+	"simplewallet/util/errcode"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+// unavailableStore fails to begin a unit of work while down, like a shard that can't be reached
+type unavailableStore struct {
+	dao.Store
+	down bool
+}
+
+func (s *unavailableStore) Begin() (dao.UnitOfWork, error) {
+	if s.down {
+		return nil, errors.New("shard unavailable")
+	}
+	return s.Store.Begin()
+}
+
+// newTwoShards puts the even users on shard 0 and the odd ones on shard 1
+func newTwoShards(shard0 dao.Store, shard1 dao.Store) *dao.ShardedStore {
+	return dao.NewShardedStore([]dao.Store{shard0, shard1}, func(userID int64) int { return int(userID % 2) })
+}
+
+func newShardedWalletService(shards *dao.ShardedStore, op string) *service.WalletService {
+	return newMemoryWalletService(shards.Shard(0), op).WithShards(shards)
+}
+
+func newShardTransferService(shards *dao.ShardedStore) *service.ShardTransferService {
+	logID := util.Uniqid()
+	ctx := context.Background()
+	return service.NewShardTransferServiceWithShards(ctx, logID, shards, func(key string) util.DistributedLock {
+		return util.NewDistributedLock(ctx, db.GetRedisClient(), logID, key, 5)
+	})
+}
+
+func TestShardTransfer(t *testing.T) {
+	ConnectDBRedis()
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	defer DisconnectDBRedis()
+
+	t.Run("case1: transfer success-[debited on the shard of the sender, credited on the shard of the recipient]", func(t *testing.T) {
+		shard0, shard1 := dao.NewMemoryStore(), dao.NewMemoryStore()
+		shards := newTwoShards(shard0, shard1)
+		rsp, err := newShardedWalletService(shards, "deposit").Deposit(&data.DepositReq{OrderID: "1001", UserID: 101, Amount: 1000.00})
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		rsp, err = newShardedWalletService(shards, "transfer").Transfer(&data.TransferReq{OrderID: "1002", FromUserID: 101, ToUserID: 102, Amount: 300.00})
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+
+		balanceRsp, err := newShardedWalletService(shards, "").GetBalance(&data.GetBalanceReq{UserID: 101})
+		assert.Nil(t, err)
+		assert.Equal(t, 700.00, balanceRsp.Data.Balance)
+		balanceRsp, err = newShardedWalletService(shards, "").GetBalance(&data.GetBalanceReq{UserID: 102})
+		assert.Nil(t, err)
+		assert.Equal(t, 300.00, balanceRsp.Data.Balance)
+
+		wallet, err := shard0.Wallets().GetWalletByUserID(101, data.DefaultPocket)
+		assert.Nil(t, err)
+		assert.Nil(t, wallet)
+		trans, err := shard0.Transactions().GetTransactionByOrderID("1002")
+		assert.Nil(t, err)
+		require.NotNil(t, trans)
+		assert.Equal(t, data.TxTypeTransferIn, trans.TxType)
+		trans, err = shard1.Transactions().GetTransactionByOrderID("1002")
+		assert.Nil(t, err)
+		require.NotNil(t, trans)
+		assert.Equal(t, data.TxTypeTransferOut, trans.TxType)
+		transfer, err := shard1.ShardTransfers().GetShardTransferByOrderID("1002")
+		assert.Nil(t, err)
+		require.NotNil(t, transfer)
+		assert.Equal(t, data.ShardTransferStatusCredited, transfer.Status)
+	})
+
+	t.Run("case2: transfer fail-[credit pending while the shard of the recipient is down, resumed later]", func(t *testing.T) {
+		shard0, shard1 := &unavailableStore{Store: dao.NewMemoryStore()}, dao.NewMemoryStore()
+		shards := newTwoShards(shard0, shard1)
+		rsp, err := newShardedWalletService(shards, "deposit").Deposit(&data.DepositReq{OrderID: "1001", UserID: 101, Amount: 1000.00})
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+
+		shard0.down = true
+		rsp, err = newShardedWalletService(shards, "transfer").Transfer(&data.TransferReq{OrderID: "1002", FromUserID: 101, ToUserID: 102, Amount: 300.00})
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodeTransferPending, rsp.Code)
+		balanceRsp, err := newShardedWalletService(shards, "").GetBalance(&data.GetBalanceReq{UserID: 101})
+		assert.Nil(t, err)
+		assert.Equal(t, 700.00, balanceRsp.Data.Balance)
+		wallet, err := shard0.Wallets().GetWalletByUserID(102, data.DefaultPocket)
+		assert.Nil(t, err)
+		assert.Nil(t, wallet)
+
+		// still down, the transfer stays debited for the next run
+		resumed, err := newShardTransferService(shards).ResumeDue(time.Now().Add(2 * time.Minute))
+		assert.NotNil(t, err)
+		assert.Equal(t, 0, resumed)
+
+		shard0.down = false
+		resumed, err = newShardTransferService(shards).ResumeDue(time.Now())
+		assert.Nil(t, err)
+		assert.Equal(t, 0, resumed, "within the grace period")
+		resumed, err = newShardTransferService(shards).ResumeDue(time.Now().Add(2 * time.Minute))
+		assert.Nil(t, err)
+		assert.Equal(t, 1, resumed)
+		resumed, err = newShardTransferService(shards).ResumeDue(time.Now().Add(2 * time.Minute))
+		assert.Nil(t, err)
+		assert.Equal(t, 0, resumed)
+		balanceRsp, err = newShardedWalletService(shards, "").GetBalance(&data.GetBalanceReq{UserID: 102})
+		assert.Nil(t, err)
+		assert.Equal(t, 300.00, balanceRsp.Data.Balance)
+	})
+
+	t.Run("case3: resume success-[credited before a crash, marked without crediting twice]", func(t *testing.T) {
+		shard0, shard1 := dao.NewMemoryStore(), dao.NewMemoryStore()
+		shards := newTwoShards(shard0, shard1)
+		_, err := shard1.ShardTransfers().InsertShardTransfer(&model.ShardTransfer{OrderID: "1002", FromUserID: 101, FromWalletID: 1, ToUserID: 102, Amount: 300.00})
+		require.NoError(t, err)
+		walletID, err := shard0.Wallets().CreateOrUpdateWallet(102, data.DefaultPocket, 300.00)
+		require.NoError(t, err)
+		require.NoError(t, shard0.Transactions().InsertTransaction(&model.Transactions{OrderID: "1002", UserID: 102, WalletID: walletID, TxType: data.TxTypeTransferIn, Amount: 300.00, RelatedUserID: 101, ActorUserID: 101}))
+
+		rsp, err := newShardedWalletService(shards, "transfer").ResumeShardTransfer(1, "1002")
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		wallet, err := shard0.Wallets().GetWalletByUserID(102, data.DefaultPocket)
+		assert.Nil(t, err)
+		assert.Equal(t, 300.00, wallet.Balance)
+		transfer, err := shard1.ShardTransfers().GetShardTransferByOrderID("1002")
+		assert.Nil(t, err)
+		assert.Equal(t, data.ShardTransferStatusCredited, transfer.Status)
+
+		rsp, err = newShardedWalletService(shards, "transfer").ResumeShardTransfer(1, "1003")
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodeTransactionNotExist, rsp.Code)
+	})
+
+	t.Run("case4: resume fail-[order_id used on the shard of the recipient, the sender is refunded at once]", func(t *testing.T) {
+		shard0, shard1 := &unavailableStore{Store: dao.NewMemoryStore()}, dao.NewMemoryStore()
+		shards := newTwoShards(shard0, shard1)
+		rsp, err := newShardedWalletService(shards, "deposit").Deposit(&data.DepositReq{OrderID: "1001", UserID: 101, Amount: 1000.00})
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+
+		shard0.down = true
+		rsp, err = newShardedWalletService(shards, "transfer").Transfer(&data.TransferReq{OrderID: "1002", FromUserID: 101, ToUserID: 102, Amount: 300.00})
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodeTransferPending, rsp.Code)
+		// another user of the shard of the recipient wrote the order_id meanwhile, the shards are checked one after the other
+		shard0.down = false
+		walletID, err := shard0.Wallets().CreateOrUpdateWallet(104, data.DefaultPocket, 10.00)
+		assert.Nil(t, err)
+		assert.Nil(t, shard0.Transactions().InsertTransaction(&model.Transactions{OrderID: "1002", UserID: 104, WalletID: walletID, TxType: data.TxTypeDeposit, Amount: 10.00, ActorUserID: 104}))
+		rsp, err = newShardedWalletService(shards, "transfer").ResumeShardTransfer(1, "1002")
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodeTransferRefunded, rsp.Code)
+
+		balanceRsp, err := newShardedWalletService(shards, "").GetBalance(&data.GetBalanceReq{UserID: 101})
+		assert.Nil(t, err)
+		assert.Equal(t, 1000.00, balanceRsp.Data.Balance)
+		wallet, err := shard0.Wallets().GetWalletByUserID(102, data.DefaultPocket)
+		assert.Nil(t, err)
+		assert.Nil(t, wallet)
+		transfer, err := shard1.ShardTransfers().GetShardTransferByOrderID("1002")
+		assert.Nil(t, err)
+		require.NotNil(t, transfer)
+		assert.Equal(t, data.ShardTransferStatusRefunded, transfer.Status)
+		transList, err := shard1.Transactions().GetTransactionList(&dao.TxFilter{UserID: 101}, 1, 10)
+		assert.Nil(t, err)
+		require.Len(t, transList, 3)
+		refunds := 0
+		for _, trans := range transList {
+			if trans.TxType == data.TxTypeRefund {
+				refunds++
+				assert.Equal(t, 300.00, trans.Amount)
+				assert.Equal(t, int64(102), trans.RelatedUserID)
+			}
+		}
+		assert.Equal(t, 1, refunds)
+		eventList, err := shard1.Outbox().GetPendingEventList(10)
+		assert.Nil(t, err)
+		require.NotEmpty(t, eventList)
+		assert.Equal(t, data.EventTypeTransferRefund, eventList[len(eventList)-1].EventType)
+
+		rsp, err = newShardedWalletService(shards, "transfer").ResumeShardTransfer(1, "1002")
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodeTransferRefunded, rsp.Code)
+		balanceRsp, err = newShardedWalletService(shards, "").GetBalance(&data.GetBalanceReq{UserID: 101})
+		assert.Nil(t, err)
+		assert.Equal(t, 1000.00, balanceRsp.Data.Balance, "refunded once")
+	})
+
+	t.Run("case5: resume fail-[shard of the recipient down for the max attempts, the sender is refunded]", func(t *testing.T) {
+		shard0, shard1 := &unavailableStore{Store: dao.NewMemoryStore()}, dao.NewMemoryStore()
+		shards := newTwoShards(shard0, shard1)
+		rsp, err := newShardedWalletService(shards, "deposit").Deposit(&data.DepositReq{OrderID: "1001", UserID: 101, Amount: 1000.00})
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+
+		shard0.down = true
+		rsp, err = newShardedWalletService(shards, "transfer").Transfer(&data.TransferReq{OrderID: "1002", FromUserID: 101, ToUserID: 102, Amount: 300.00})
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodeTransferPending, rsp.Code)
+		for i := 0; i < 2; i++ {
+			resumed, err := newShardTransferService(shards).WithMaxAttempts(3).ResumeDue(time.Now().Add(2 * time.Minute))
+			assert.NotNil(t, err)
+			assert.Equal(t, 0, resumed)
+		}
+		balanceRsp, err := newShardedWalletService(shards, "").GetBalance(&data.GetBalanceReq{UserID: 101})
+		assert.Nil(t, err)
+		assert.Equal(t, 700.00, balanceRsp.Data.Balance, "still debited")
+
+		resumed, err := newShardTransferService(shards).WithMaxAttempts(3).ResumeDue(time.Now().Add(2 * time.Minute))
+		assert.NotNil(t, err)
+		assert.Equal(t, 0, resumed)
+		balanceRsp, err = newShardedWalletService(shards, "").GetBalance(&data.GetBalanceReq{UserID: 101})
+		assert.Nil(t, err)
+		assert.Equal(t, 1000.00, balanceRsp.Data.Balance)
+		transfer, err := shard1.ShardTransfers().GetShardTransferByOrderID("1002")
+		assert.Nil(t, err)
+		require.NotNil(t, transfer)
+		assert.Equal(t, data.ShardTransferStatusRefunded, transfer.Status)
+		assert.Equal(t, int32(3), transfer.Attempts)
+
+		shard0.down = false
+		resumed, err = newShardTransferService(shards).ResumeDue(time.Now().Add(2 * time.Minute))
+		assert.Nil(t, err)
+		assert.Equal(t, 0, resumed)
+		wallet, err := shard0.Wallets().GetWalletByUserID(102, data.DefaultPocket)
+		assert.Nil(t, err)
+		assert.Nil(t, wallet, "never credited after the refund")
+	})
+
+	t.Run("case6: order_id fail-[used on another shard, or by a transfer waiting for approvals on shard 0]", func(t *testing.T) {
+		shard0, shard1 := dao.NewMemoryStore(), dao.NewMemoryStore()
+		shards := newTwoShards(shard0, shard1)
+		rsp, err := newShardedWalletService(shards, "deposit").Deposit(&data.DepositReq{OrderID: "1001", UserID: 101, Amount: 1000.00})
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		rsp, err = newShardedWalletService(shards, "deposit").Deposit(&data.DepositReq{OrderID: "1001", UserID: 102, Amount: 10.00})
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodeOrderIDRepeat, rsp.Code)
+		wallet, err := shard0.Wallets().GetWalletByUserID(102, data.DefaultPocket)
+		assert.Nil(t, err)
+		assert.Nil(t, wallet, "nothing written on shard 0")
+
+		orgRsp, err := newShardedWalletService(shards, "").CreateOrg(&data.CreateOrgReq{UserID: 102, Name: "acme"})
+		assert.Nil(t, err)
+		orgID := orgRsp.Data.OrgID
+		rsp, err = newShardedWalletService(shards, "").SetOrgMember(&data.SetOrgMemberReq{OrgID: orgID, UserID: 102, MemberUserID: 104, Role: data.OrgRoleSpender, SpendingLimit: 500.00})
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		rsp, err = newShardedWalletService(shards, "deposit").Deposit(&data.DepositReq{OrderID: "2000", UserID: 102, Amount: 2000.00, OrgID: orgID})
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		rsp, err = newShardedWalletService(shards, "transfer").Transfer(&data.TransferReq{OrderID: "2001", FromUserID: 104, ToUserID: 106, Amount: 800.00, OrgID: orgID})
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeApprovalPending, rsp.Code)
+
+		rsp, err = newShardedWalletService(shards, "withdraw").Withdraw(&data.WithdrawReq{OrderID: "2001", UserID: 101, Amount: 1.00})
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodeOrderIDRepeat, rsp.Code)
+		balanceRsp, err := newShardedWalletService(shards, "").GetBalance(&data.GetBalanceReq{UserID: 101})
+		assert.Nil(t, err)
+		assert.Equal(t, 1000.00, balanceRsp.Data.Balance)
+	})
+}
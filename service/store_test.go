@@ -0,0 +1,246 @@
+package service_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"simplewallet/data"
+	"simplewallet/service"
+	"simplewallet/service/dao"
+	"simplewallet/util"
+	"simplewallet/util/db"
+	"simplewallet/util/errcode"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
+)
+
+// newMemoryWalletService returns a wallet service on the store, with the lock of the given operation
+func newMemoryWalletService(store dao.Store, op string) *service.WalletService {
+	logID := util.Uniqid()
+	ctx := context.Background()
+	return service.NewWalletServiceWithStore(ctx, logID, store, util.NewDistributedLock(ctx, db.GetRedisClient(), logID, op+":"+logID, 5))
+}
+
+func TestWalletServiceOnMemoryStore(t *testing.T) {
+	ConnectDBRedis()
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	defer DisconnectDBRedis()
+
+	t.Run("case1: deposit, withdraw and transfer success", func(t *testing.T) {
+		store := dao.NewMemoryStore()
+		rsp, err := newMemoryWalletService(store, "deposit").Deposit(&data.DepositReq{OrderID: "1001", UserID: 101, Amount: 1000.00})
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		rsp, err = newMemoryWalletService(store, "withdraw").Withdraw(&data.WithdrawReq{OrderID: "1002", UserID: 101, Amount: 200.00})
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		rsp, err = newMemoryWalletService(store, "transfer").Transfer(&data.TransferReq{OrderID: "1003", FromUserID: 101, ToUserID: 102, Amount: 300.00})
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		rsp, err = newMemoryWalletService(store, "deposit").Deposit(&data.DepositReq{OrderID: "1003", UserID: 101, Amount: 1.00})
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodeOrderIDRepeat, rsp.Code)
+
+		balanceRsp, err := newMemoryWalletService(store, "").GetBalance(&data.GetBalanceReq{UserID: 101})
+		assert.Nil(t, err)
+		assert.Equal(t, 500.00, balanceRsp.Data.Balance)
+		balanceRsp, err = newMemoryWalletService(store, "").GetBalance(&data.GetBalanceReq{UserID: 102})
+		assert.Nil(t, err)
+		assert.Equal(t, 300.00, balanceRsp.Data.Balance)
+		hisRsp, err := newMemoryWalletService(store, "").GetTransactionHistory(&data.GetTransactionHistoryReq{UserID: 101, Page: 1, Limit: 10})
+		assert.Nil(t, err)
+		assert.Len(t, hisRsp.Data.Items, 3)
+	})
+
+	t.Run("case2: withdraw fail-[balance not enough, nothing written]", func(t *testing.T) {
+		store := dao.NewMemoryStore()
+		rsp, err := newMemoryWalletService(store, "deposit").Deposit(&data.DepositReq{OrderID: "1001", UserID: 101, Amount: 100.00})
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		rsp, err = newMemoryWalletService(store, "withdraw").Withdraw(&data.WithdrawReq{OrderID: "1002", UserID: 101, Amount: 100.01})
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodeBalanceNotEnough, rsp.Code)
+
+		trans, err := store.Transactions().GetTransactionByOrderID("1002")
+		assert.Nil(t, err)
+		assert.Nil(t, trans)
+		wallet, err := store.Wallets().GetWalletByUserID(101, data.DefaultPocket)
+		assert.Nil(t, err)
+		assert.Equal(t, 100.00, wallet.Balance)
+	})
+
+	t.Run("case3: org transfer success-[held above the spending limit, executed once approved]", func(t *testing.T) {
+		store := dao.NewMemoryStore()
+		orgRsp, err := newMemoryWalletService(store, "").CreateOrg(&data.CreateOrgReq{UserID: 101, Name: "acme"})
+		assert.Nil(t, err)
+		orgID := orgRsp.Data.OrgID
+		rsp, err := newMemoryWalletService(store, "").SetOrgMember(&data.SetOrgMemberReq{OrgID: orgID, UserID: 101, MemberUserID: 102, Role: data.OrgRoleSpender, SpendingLimit: 500.00})
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		rsp, err = newMemoryWalletService(store, "deposit").Deposit(&data.DepositReq{OrderID: "2000", UserID: 101, Amount: 2000.00, OrgID: orgID})
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+
+		rsp, err = newMemoryWalletService(store, "transfer").Transfer(&data.TransferReq{OrderID: "2001", FromUserID: 102, ToUserID: 201, Amount: 800.00, OrgID: orgID})
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeApprovalPending, rsp.Code)
+		wallet, err := store.Wallets().GetWalletByOrgID(orgID, data.DefaultPocket)
+		assert.Nil(t, err)
+		assert.Equal(t, 2000.00, wallet.Balance)
+		assert.Equal(t, 800.00, wallet.Held)
+		// the order_id of the pending transfer is taken, the transfer executed later would post twice under it
+		rsp, err = newMemoryWalletService(store, "withdraw").Withdraw(&data.WithdrawReq{OrderID: "2001", UserID: 101, Amount: 1.00, OrgID: orgID})
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodeOrderIDRepeat, rsp.Code)
+
+		rsp, err = newMemoryWalletService(store, "transfer").ApproveTransfer(&data.ApproveTransferReq{OrgID: orgID, UserID: 101, OrderID: "2001"})
+		assert.Nil(t, err)
+		assert.Equal(t, "Transfer successful", rsp.Message)
+		wallet, err = store.Wallets().GetWalletByOrgID(orgID, data.DefaultPocket)
+		assert.Nil(t, err)
+		assert.Equal(t, 1200.00, wallet.Balance)
+		assert.Equal(t, 0.00, wallet.Held)
+		wallet, err = store.Wallets().GetWalletByUserID(201, data.DefaultPocket)
+		assert.Nil(t, err)
+		assert.Equal(t, 800.00, wallet.Balance)
+	})
+
+	t.Run("case4: org transfer fail-[above the spending limit with approvals off, nothing held]", func(t *testing.T) {
+		store := dao.NewMemoryStore()
+		orgRsp, err := newMemoryWalletService(store, "").CreateOrg(&data.CreateOrgReq{UserID: 101, Name: "acme"})
+		assert.Nil(t, err)
+		orgID := orgRsp.Data.OrgID
+		rsp, err := newMemoryWalletService(store, "").SetOrgMember(&data.SetOrgMemberReq{OrgID: orgID, UserID: 101, MemberUserID: 102, Role: data.OrgRoleSpender, SpendingLimit: 500.00})
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		rsp, err = newMemoryWalletService(store, "deposit").Deposit(&data.DepositReq{OrderID: "2000", UserID: 101, Amount: 2000.00, OrgID: orgID})
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+
+		rsp, err = newMemoryWalletService(store, "transfer").WithApprovals(false).Transfer(&data.TransferReq{OrderID: "2001", FromUserID: 102, ToUserID: 201, Amount: 800.00, OrgID: orgID})
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodeSpendingLimit, rsp.Code)
+		wallet, err := store.Wallets().GetWalletByOrgID(orgID, data.DefaultPocket)
+		assert.Nil(t, err)
+		assert.Equal(t, 2000.00, wallet.Balance)
+		assert.Equal(t, 0.00, wallet.Held)
+	})
+}
+
+func TestWalletServiceOnSqliteStore(t *testing.T) {
+	ConnectDBRedis()
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	defer DisconnectDBRedis()
+
+	t.Run("case1: concurrent transfers success-[balances never negative, total kept]", func(t *testing.T) {
+		dbCli, err := db.OpenSqlite(filepath.Join(t.TempDir(), "wallet.db"))
+		assert.Nil(t, err)
+		defer dbCli.Close()
+		migrator, err := db.NewMigrator(dbCli, db.DriverSqlite)
+		assert.Nil(t, err)
+		_, err = migrator.Up(context.Background())
+		assert.Nil(t, err)
+		store := dao.NewSqliteStore(context.Background(), "test", dbCli)
+		for i, userID := range []int64{101, 102} {
+			rsp, err := newMemoryWalletService(store, "deposit").Deposit(&data.DepositReq{OrderID: fmt.Sprintf("100%d", i), UserID: userID, Amount: 300.10})
+			assert.Nil(t, err)
+			assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		}
+
+		// the locks of the services differ, only the db transactions keep the transfers apart
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				from, to := int64(101), int64(102)
+				if i%2 == 1 {
+					from, to = to, from
+				}
+				rsp, _ := newMemoryWalletService(store, "transfer").Transfer(&data.TransferReq{OrderID: fmt.Sprintf("2%03d", i), FromUserID: from, ToUserID: to, Amount: 100.03 + float64(i%3)*50})
+				assert.Contains(t, []int32{errcode.ErrCodeSuccess, errcode.ErrCodeBalanceNotEnough}, rsp.Code)
+			}(i)
+		}
+		wg.Wait()
+
+		from, err := store.Wallets().GetWalletByUserID(101, data.DefaultPocket)
+		assert.Nil(t, err)
+		to, err := store.Wallets().GetWalletByUserID(102, data.DefaultPocket)
+		assert.Nil(t, err)
+		assert.GreaterOrEqual(t, from.Balance, 0.00)
+		assert.GreaterOrEqual(t, to.Balance, 0.00)
+		assert.InDelta(t, 600.20, from.Balance+to.Balance, 1e-9)
+		sums, err := store.Transactions().GetAmountSumByTxType(from.ID, time.Now().Unix()+1)
+		assert.Nil(t, err)
+		assert.InDelta(t, from.Balance, sums[data.TxTypeDeposit]+sums[data.TxTypeTransferIn]-sums[data.TxTypeTransferOut], 1e-9)
+	})
+
+	t.Run("case2: concurrent org withdraws success-[a spender never debits above the daily limit]", func(t *testing.T) {
+		store, _ := newChainStore(t)
+		orgRsp, err := newMemoryWalletService(store, "").CreateOrg(&data.CreateOrgReq{UserID: 101, Name: "acme"})
+		assert.Nil(t, err)
+		orgID := orgRsp.Data.OrgID
+		rsp, err := newMemoryWalletService(store, "").SetOrgMember(&data.SetOrgMemberReq{OrgID: orgID, UserID: 101, MemberUserID: 102, Role: data.OrgRoleSpender, SpendingLimit: 500.00})
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		rsp, err = newMemoryWalletService(store, "deposit").Deposit(&data.DepositReq{OrderID: "2000", UserID: 101, Amount: 2000.00, OrgID: orgID})
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+
+		// every withdraw has its own order_id, so its own lock: only the wallet lock keeps the sums apart
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				rsp, _ := newMemoryWalletService(store, "withdraw").Withdraw(&data.WithdrawReq{OrderID: fmt.Sprintf("3%03d", i), UserID: 102, Amount: 120.00, OrgID: orgID})
+				assert.Contains(t, []int32{errcode.ErrCodeSuccess, errcode.ErrCodeSpendingLimit}, rsp.Code)
+			}(i)
+		}
+		wg.Wait()
+
+		wallet, err := store.Wallets().GetWalletByOrgID(orgID, data.DefaultPocket)
+		assert.Nil(t, err)
+		spent, err := store.Transactions().GetDebitSumByActor(wallet.ID, 102, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, 480.00, spent, "4 withdraws within the 500.00 limit")
+		assert.Equal(t, 1520.00, wallet.Balance)
+	})
+
+	t.Run("case3: org transfer success-[the schema reserves the order_id until the approved transfer executes]", func(t *testing.T) {
+		store, _ := newChainStore(t)
+		orgRsp, err := newMemoryWalletService(store, "").CreateOrg(&data.CreateOrgReq{UserID: 101, Name: "acme"})
+		assert.Nil(t, err)
+		orgID := orgRsp.Data.OrgID
+		rsp, err := newMemoryWalletService(store, "").SetOrgMember(&data.SetOrgMemberReq{OrgID: orgID, UserID: 101, MemberUserID: 102, Role: data.OrgRoleSpender, SpendingLimit: 500.00})
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		rsp, err = newMemoryWalletService(store, "deposit").Deposit(&data.DepositReq{OrderID: "2000", UserID: 101, Amount: 2000.00, OrgID: orgID})
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		rsp, err = newMemoryWalletService(store, "transfer").Transfer(&data.TransferReq{OrderID: "2001", FromUserID: 102, ToUserID: 201, Amount: 800.00, OrgID: orgID})
+		assert.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeApprovalPending, rsp.Code)
+
+		rsp, err = newMemoryWalletService(store, "deposit").Deposit(&data.DepositReq{OrderID: "2001", UserID: 301, Amount: 1.00})
+		assert.ErrorIs(t, err, dao.ErrOrderIDPending)
+		assert.Equal(t, errcode.ErrCodeOrderIDRepeat, rsp.Code)
+		trans, err := store.Transactions().GetTransactionByOrderID("2001")
+		assert.Nil(t, err)
+		assert.Nil(t, trans)
+
+		rsp, err = newMemoryWalletService(store, "transfer").ApproveTransfer(&data.ApproveTransferReq{OrgID: orgID, UserID: 101, OrderID: "2001"})
+		assert.Nil(t, err)
+		assert.Equal(t, "Transfer successful", rsp.Message)
+		wallet, err := store.Wallets().GetWalletByUserID(201, data.DefaultPocket)
+		assert.Nil(t, err)
+		assert.Equal(t, 800.00, wallet.Balance)
+		wallet, err = store.Wallets().GetWalletByUserID(301, data.DefaultPocket)
+		assert.Nil(t, err)
+		assert.Nil(t, wallet, "the rejected deposit wrote nothing")
+	})
+}
@@ -0,0 +1,195 @@
+package service
+
+import (
+	"errors"
+	"simplewallet/data"
+	"simplewallet/model"
+	"simplewallet/service/dao"
+	"simplewallet/util/db"
+	"simplewallet/util/errcode"
+	"time"
+)
+
+const (
+	// StreamHeartbeat is how often an idle stream sends a heartbeat, so proxies keep it open
+	StreamHeartbeat       = 15 * time.Second
+	streamPage      int32 = 100
+)
+
+// streamLookback returns the seconds before the newest transaction sent a stream reads again. A transaction
+// commits up to a db transaction timeout after its created_at, a newer one may have been sent meanwhile.
+func streamLookback() int64 {
+	_, _, txTimeout := db.GetDbTimeouts()
+	if txTimeout <= 0 {
+		txTimeout = db.DefaultTxTimeoutMs * time.Millisecond
+	}
+	// created_at is rounded down to the second
+	return int64((txTimeout+time.Second-1)/time.Second) + 1
+}
+
+// txStream is what a stream has sent: the transactions after floor, the ones of the lookback window by id
+type txStream struct {
+	userID   int64
+	floor    *dao.TxKey
+	high     *dao.TxKey
+	lookback int64           // seconds, see streamLookback
+	sent     map[int64]int64 // created_at of the transactions sent within the lookback window
+}
+
+// id returns the id of the events, the cursor of the newest transaction sent, where a stream resumes
+func (st *txStream) id() string {
+	if st.high == nil {
+		return ""
+	}
+	return encodeTxCursor(*st.high, false)
+}
+
+// Stream sends the balance of the user's own wallets and then their new transactions and balance each time
+// a write to them committed, until the context of the service ends. Without a last event id it starts at the
+// newest transaction, with one it sends the transactions of the lookback window before it and after it first,
+// the client drops the ones it got before. The rsp tells why a stream could not start, an error after it
+// started is only returned.
+func (s *WalletService) Stream(req *data.StreamReq, send func(event *data.StreamEvent) error) (rsp *data.CommRsp, err error) {
+	rsp = &data.CommRsp{Code: errcode.ErrCodeInternalErr, Message: errcode.ErrMsgMap[errcode.ErrCodeInternalErr], LogID: s.logID}
+	defer func() { mapDbError(err, &rsp.Code, &rsp.Message) }()
+
+	if s.bus == nil {
+		return rsp, errors.New("event bus not set")
+	}
+	floor, backward, err := decodeTxCursor(req.LastEventID)
+	if err != nil || backward {
+		err = errBadCursor
+		rsp.Code = errcode.ErrCodeBadRequestParam
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return rsp, err
+	}
+	// subscribed before the first read, a write committing in between is sent on its notice
+	notices, cancel := s.bus.Subscribe(req.UserID)
+	defer cancel()
+	// notices come right after the commits, a replica may not have them yet
+	s.replicas = nil
+
+	st := &txStream{userID: req.UserID, floor: floor, high: floor, lookback: streamLookback(), sent: make(map[int64]int64)}
+	if floor != nil {
+		// a transaction before the last event id may have committed since, the lookback window is sent again
+		st.floor = &dao.TxKey{CreatedAt: floor.CreatedAt - st.lookback}
+	} else if err = s.skipStreamed(st); err != nil {
+		rsp.Code = errcode.ErrCodeQueryDBFail
+		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
+		return rsp, err
+	}
+	if err = s.streamChanges(st, send); err != nil {
+		rsp.Code = errcode.ErrCodeQueryDBFail
+		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
+		return rsp, err
+	}
+
+	rsp.Code = errcode.ErrCodeSuccess
+	rsp.Message = "Success"
+	heartbeat := time.NewTicker(StreamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return rsp, nil
+		case <-notices:
+			err = s.streamChanges(st, send)
+		case <-heartbeat.C:
+			err = send(&data.StreamEvent{ID: st.id()})
+		}
+		if err != nil {
+			return rsp, err
+		}
+	}
+}
+
+// skipStreamed starts a stream at the newest transaction of the user: the ones of the lookback window before it
+// are counted sent, newest first, so a transaction created in the window but committing later is still sent
+func (s *WalletService) skipStreamed(st *txStream) error {
+	transDao := s.storeFor(st.userID).Transactions()
+	filter := &dao.TxFilter{UserID: st.userID}
+	var from *dao.TxKey
+	for {
+		txList, err := transDao.GetTransactionListByCursor(filter, from, true, streamPage)
+		if err != nil {
+			return err
+		}
+		for _, tx := range txList {
+			if st.high == nil {
+				key := txKeyOf(tx)
+				st.floor, st.high = &dao.TxKey{CreatedAt: key.CreatedAt - st.lookback}, &key
+			}
+			if tx.CreatedAt < st.floor.CreatedAt {
+				return nil
+			}
+			st.sent[tx.ID] = tx.CreatedAt
+		}
+		if len(txList) < int(streamPage) {
+			return nil
+		}
+		last := txKeyOf(txList[len(txList)-1])
+		from = &last
+	}
+}
+
+// streamChanges sends the transactions of the user not sent yet, oldest first, then their balance
+func (s *WalletService) streamChanges(st *txStream, send func(event *data.StreamEvent) error) error {
+	transDao := s.storeFor(st.userID).Transactions()
+	filter := &dao.TxFilter{UserID: st.userID}
+	from := st.floor
+	if st.high != nil && (from == nil || st.high.CreatedAt-st.lookback > from.CreatedAt) {
+		from = &dao.TxKey{CreatedAt: st.high.CreatedAt - st.lookback}
+	}
+	for {
+		txList, err := transDao.GetTransactionListByCursor(filter, from, false, streamPage)
+		if err != nil {
+			return err
+		}
+		for _, tx := range txList {
+			if _, ok := st.sent[tx.ID]; ok {
+				continue
+			}
+			if err = s.sendTransaction(st, tx, send); err != nil {
+				return err
+			}
+		}
+		if len(txList) < int(streamPage) {
+			break
+		}
+		last := txKeyOf(txList[len(txList)-1])
+		from = &last
+	}
+	if st.high != nil {
+		for id, createdAt := range st.sent {
+			if createdAt < st.high.CreatedAt-st.lookback {
+				delete(st.sent, id)
+			}
+		}
+	}
+
+	rsp, err := s.GetBalance(&data.GetBalanceReq{UserID: st.userID})
+	if err != nil {
+		if rsp.Code == errcode.ErrCodeUserWalletNotExist {
+			return nil
+		}
+		return err
+	}
+	return send(&data.StreamEvent{ID: st.id(), Event: data.StreamEventBalance, Data: rsp.Data})
+}
+
+func (s *WalletService) sendTransaction(st *txStream, tx *model.Transactions, send func(event *data.StreamEvent) error) error {
+	st.sent[tx.ID] = tx.CreatedAt
+	if key := txKeyOf(tx); st.high == nil || key.CreatedAt > st.high.CreatedAt || (key.CreatedAt == st.high.CreatedAt && key.ID > st.high.ID) {
+		st.high = &key
+	}
+	return send(&data.StreamEvent{ID: st.id(), Event: data.StreamEventTransaction, Data: &data.GetTransactionHistoryRspDataItem{
+		OrderID:       tx.OrderID,
+		UserID:        tx.UserID,
+		WalletID:      tx.WalletID,
+		TxType:        tx.TxType,
+		Amount:        tx.Amount,
+		RelatedUserID: tx.RelatedUserID,
+		ActorUserID:   tx.ActorUserID,
+		CreatedAt:     time.Unix(tx.CreatedAt, 0).Format("2006-01-02 15:04:05"),
+	}})
+}
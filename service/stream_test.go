@@ -0,0 +1,267 @@
+package service_test
+
+import (
+	"context"
+	"simplewallet/data"
+	"simplewallet/service"
+	"simplewallet/service/dao"
+	"simplewallet/util"
+	"simplewallet/util/db"
+	"simplewallet/util/errcode"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+// startStream streams the user until the returned stop is called, which returns the rsp of the stream
+func startStream(t *testing.T, store dao.Store, bus service.EventBus, req *data.StreamReq) (<-chan *data.StreamEvent, func() *data.CommRsp) {
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan *data.StreamEvent, 100)
+	done := make(chan *data.CommRsp, 1)
+	s := service.NewWalletServiceWithStore(ctx, util.Uniqid(), store, nil).WithEventBus(bus)
+	go func() {
+		rsp, err := s.Stream(req, func(event *data.StreamEvent) error {
+			events <- event
+			return nil
+		})
+		assert.Nil(t, err)
+		done <- rsp
+	}()
+	return events, func() *data.CommRsp {
+		cancel()
+		return <-done
+	}
+}
+
+func nextStreamEvent(t *testing.T, events <-chan *data.StreamEvent) *data.StreamEvent {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(2 * time.Second):
+		require.FailNow(t, "no stream event")
+		return nil
+	}
+}
+
+func TestStream(t *testing.T) {
+	ConnectDBRedis()
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	defer DisconnectDBRedis()
+
+	deposit := func(t *testing.T, store dao.Store, bus service.EventBus, orderID string, userID int64, amount float64) {
+		rsp, err := newMemoryWalletService(store, "deposit").WithEventBus(bus).Deposit(&data.DepositReq{OrderID: orderID, UserID: userID, Amount: amount})
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+	}
+
+	t.Run("case1: stream success-[balance, then the transactions and balance of each write]", func(t *testing.T) {
+		store := dao.NewMemoryStore()
+		bus := service.NewLocalBus()
+		deposit(t, store, bus, "1001", 101, 100.00)
+		deposit(t, store, bus, "1002", 102, 100.00)
+		events, stop := startStream(t, store, bus, &data.StreamReq{UserID: 101})
+
+		event := nextStreamEvent(t, events)
+		assert.Equal(t, data.StreamEventBalance, event.Event, "the older transactions are not sent")
+		assert.Equal(t, 100.00, event.Data.(*data.GetBalanceRspData).Balance)
+		assert.NotEmpty(t, event.ID)
+		startID := event.ID
+
+		deposit(t, store, bus, "1003", 101, 50.00)
+		event = nextStreamEvent(t, events)
+		require.Equal(t, data.StreamEventTransaction, event.Event)
+		item := event.Data.(*data.GetTransactionHistoryRspDataItem)
+		assert.Equal(t, "1003", item.OrderID)
+		assert.Equal(t, data.TxTypeDeposit, item.TxType)
+		assert.Equal(t, 50.00, item.Amount)
+		assert.NotEqual(t, startID, event.ID)
+		event = nextStreamEvent(t, events)
+		require.Equal(t, data.StreamEventBalance, event.Event)
+		assert.Equal(t, 150.00, event.Data.(*data.GetBalanceRspData).Balance)
+
+		rsp, err := newMemoryWalletService(store, "transfer").WithEventBus(bus).Transfer(&data.TransferReq{OrderID: "1004", FromUserID: 102, ToUserID: 101, Amount: 30.00})
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		event = nextStreamEvent(t, events)
+		require.Equal(t, data.StreamEventTransaction, event.Event)
+		item = event.Data.(*data.GetTransactionHistoryRspDataItem)
+		assert.Equal(t, data.TxTypeTransferIn, item.TxType)
+		assert.Equal(t, int64(102), item.RelatedUserID)
+		event = nextStreamEvent(t, events)
+		require.Equal(t, data.StreamEventBalance, event.Event)
+		assert.Equal(t, 180.00, event.Data.(*data.GetBalanceRspData).Balance)
+
+		// the writes of others are not streamed
+		deposit(t, store, bus, "1005", 102, 1.00)
+		assert.Equal(t, errcode.ErrCodeSuccess, stop().Code)
+		assert.Empty(t, events)
+	})
+
+	t.Run("case2: stream success-[resumed after the last event id, the lookback window before it sent again]", func(t *testing.T) {
+		store := dao.NewMemoryStore()
+		bus := service.NewLocalBus()
+		for _, orderID := range []string{"1001", "1002", "1003"} {
+			deposit(t, store, bus, orderID, 101, 10.00)
+		}
+		historyRsp, err := newMemoryWalletService(store, "").GetTransactionHistory(&data.GetTransactionHistoryReq{UserID: 101, Limit: 1})
+		require.Nil(t, err)
+		require.NotEmpty(t, historyRsp.Data.NextCursor)
+
+		events, stop := startStream(t, store, bus, &data.StreamReq{UserID: 101, LastEventID: historyRsp.Data.NextCursor})
+		for _, orderID := range []string{"1001", "1002", "1003"} {
+			event := nextStreamEvent(t, events)
+			require.Equal(t, data.StreamEventTransaction, event.Event)
+			assert.Equal(t, orderID, event.Data.(*data.GetTransactionHistoryRspDataItem).OrderID)
+		}
+		event := nextStreamEvent(t, events)
+		require.Equal(t, data.StreamEventBalance, event.Event)
+		assert.Equal(t, 30.00, event.Data.(*data.GetBalanceRspData).Balance)
+		lastID := event.ID
+		stop()
+
+		// nothing new since, the window is sent again and the id stays
+		events, stop = startStream(t, store, bus, &data.StreamReq{UserID: 101, LastEventID: lastID})
+		for _, orderID := range []string{"1001", "1002", "1003"} {
+			event = nextStreamEvent(t, events)
+			require.Equal(t, data.StreamEventTransaction, event.Event)
+			assert.Equal(t, orderID, event.Data.(*data.GetTransactionHistoryRspDataItem).OrderID)
+		}
+		event = nextStreamEvent(t, events)
+		assert.Equal(t, data.StreamEventBalance, event.Event)
+		assert.Equal(t, lastID, event.ID)
+		stop()
+	})
+
+	t.Run("case3: stream success-[user without a wallet yet]", func(t *testing.T) {
+		store := dao.NewMemoryStore()
+		bus := service.NewLocalBus()
+		events, stop := startStream(t, store, bus, &data.StreamReq{UserID: 101})
+		deposit(t, store, bus, "1001", 101, 10.00)
+		event := nextStreamEvent(t, events)
+		require.Equal(t, data.StreamEventTransaction, event.Event)
+		assert.Equal(t, "1001", event.Data.(*data.GetTransactionHistoryRspDataItem).OrderID)
+		event = nextStreamEvent(t, events)
+		assert.Equal(t, data.StreamEventBalance, event.Event)
+		stop()
+	})
+
+	t.Run("case4: stream fail-[bad last event id, no bus]", func(t *testing.T) {
+		store := dao.NewMemoryStore()
+		s := service.NewWalletServiceWithStore(context.Background(), util.Uniqid(), store, nil).WithEventBus(service.NewLocalBus())
+		send := func(event *data.StreamEvent) error {
+			assert.Fail(t, "nothing sent")
+			return nil
+		}
+		rsp, err := s.Stream(&data.StreamReq{UserID: 101, LastEventID: "not a cursor"}, send)
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodeBadRequestParam, rsp.Code)
+
+		s = service.NewWalletServiceWithStore(context.Background(), util.Uniqid(), store, nil)
+		rsp, err = s.Stream(&data.StreamReq{UserID: 101}, send)
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodeInternalErr, rsp.Code)
+	})
+
+	t.Run("case5: redis bus success-[notices fan out to the other instances]", func(t *testing.T) {
+		ctx := context.Background()
+		busA := service.NewRedisBus(ctx, db.GetRedisClient())
+		defer busA.Close()
+		busB := service.NewRedisBus(ctx, db.GetRedisClient())
+		defer busB.Close()
+
+		notices, cancel := busB.Subscribe(101)
+		others, cancelOthers := busB.Subscribe(102)
+		defer cancelOthers()
+		require.Nil(t, busA.Publish(ctx, 101))
+		select {
+		case <-notices:
+		case <-time.After(2 * time.Second):
+			require.FailNow(t, "no notice from the other instance")
+		}
+		assert.Empty(t, others)
+
+		// streaming on instance B, a write on instance A
+		store := dao.NewMemoryStore()
+		deposit(t, store, busA, "1001", 103, 10.00)
+		events, stop := startStream(t, store, busB, &data.StreamReq{UserID: 103})
+		event := nextStreamEvent(t, events)
+		require.Equal(t, data.StreamEventBalance, event.Event, "sent once subscribed")
+		deposit(t, store, busA, "1002", 103, 10.00)
+		event = nextStreamEvent(t, events)
+		assert.Equal(t, data.StreamEventTransaction, event.Event)
+		stop()
+
+		cancel()
+		cancel()
+		time.Sleep(50 * time.Millisecond) // the unsubscribe reaches redis
+		require.Nil(t, busA.Publish(ctx, 101))
+		select {
+		case <-notices:
+			assert.Fail(t, "notice after cancel")
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+
+	t.Run("case6: redis bus success-[redis down, notices stay local]", func(t *testing.T) {
+		ctx := context.Background()
+		cli := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", DialTimeout: 50 * time.Millisecond, MaxRetries: -1})
+		defer cli.Close()
+		bus := service.NewRedisBus(ctx, cli)
+		defer bus.Close()
+		notices, cancel := bus.Subscribe(101)
+		defer cancel()
+		assert.Nil(t, bus.Publish(ctx, 101))
+		select {
+		case <-notices:
+		case <-time.After(2 * time.Second):
+			require.FailNow(t, "no local notice")
+		}
+	})
+
+	t.Run("case7: stream success-[transactions created before the newest one sent but committed later]", func(t *testing.T) {
+		store, dbCli := newChainStore(t)
+		bus := service.NewLocalBus()
+		// a deposit created at its insert, committed secs later, after the newer ones were sent
+		lateDeposit := func(t *testing.T, orderID string, secs int) {
+			deposit(t, store, nil, orderID, 101, 10.00)
+			_, err := dbCli.Exec("UPDATE transactions SET created_at = created_at - ? WHERE order_id = ?", secs, orderID)
+			require.Nil(t, err)
+		}
+		deposit(t, store, bus, "1001", 101, 10.00)
+		lateDeposit(t, "1002", 3)
+		events, stop := startStream(t, store, bus, &data.StreamReq{UserID: 101})
+		event := nextStreamEvent(t, events)
+		require.Equal(t, data.StreamEventBalance, event.Event, "the transactions before the start are not sent")
+
+		deposit(t, store, bus, "1003", 101, 10.00)
+		event = nextStreamEvent(t, events)
+		require.Equal(t, data.StreamEventTransaction, event.Event)
+		assert.Equal(t, "1003", event.Data.(*data.GetTransactionHistoryRspDataItem).OrderID)
+		nextStreamEvent(t, events)
+		lateDeposit(t, "1004", 8)
+		require.Nil(t, bus.Publish(context.Background(), 101))
+		event = nextStreamEvent(t, events)
+		require.Equal(t, data.StreamEventTransaction, event.Event)
+		assert.Equal(t, "1004", event.Data.(*data.GetTransactionHistoryRspDataItem).OrderID)
+		event = nextStreamEvent(t, events)
+		require.Equal(t, data.StreamEventBalance, event.Event)
+		assert.Equal(t, 40.00, event.Data.(*data.GetBalanceRspData).Balance)
+		lastID := event.ID
+		stop()
+
+		// committed while the client was away
+		lateDeposit(t, "1005", 8)
+		events, stop = startStream(t, store, bus, &data.StreamReq{UserID: 101, LastEventID: lastID})
+		var orderIDs []string
+		for event = nextStreamEvent(t, events); event.Event == data.StreamEventTransaction; event = nextStreamEvent(t, events) {
+			orderIDs = append(orderIDs, event.Data.(*data.GetTransactionHistoryRspDataItem).OrderID)
+		}
+		assert.Contains(t, orderIDs, "1005")
+		assert.Equal(t, 50.00, event.Data.(*data.GetBalanceRspData).Balance)
+		stop()
+	})
+}
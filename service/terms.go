@@ -0,0 +1,107 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"simplewallet/data"
+	"simplewallet/model"
+	"simplewallet/service/dao"
+	"simplewallet/util"
+	"simplewallet/util/errcode"
+)
+
+// walletTermsState is a wallet in the audit trail of its terms, with the balance they apply to
+type walletTermsState struct {
+	WalletID    int64   `json:"wallet_id"`
+	CreditLimit float64 `json:"credit_limit"`
+	Product     string  `json:"product"`
+	Balance     float64 `json:"balance"`
+	Held        float64 `json:"held"`
+}
+
+func newWalletTermsState(wallet *model.Wallet) *walletTermsState {
+	return &walletTermsState{WalletID: wallet.ID, CreditLimit: wallet.CreditLimit, Product: wallet.Product, Balance: wallet.Balance, Held: wallet.Held}
+}
+
+// SetWalletTerms sets the credit limit and the interest product of a wallet, as an admin. The wallet before
+// and after is recorded in the audit trail in the same db transaction. A limit below the overdraft of the
+// wallet is refused, the wallet would break its own constraints.
+func (s *WalletService) SetWalletTerms(req *data.SetWalletTermsReq) (rsp *data.CommRsp, err error) {
+	rsp = &data.CommRsp{Code: errcode.ErrCodeInternalErr, Message: errcode.ErrMsgMap[errcode.ErrCodeInternalErr], LogID: s.logID}
+	defer func() { mapDbError(err, &rsp.Code, &rsp.Message) }()
+
+	if s.actor == nil || s.actor.Name == "" {
+		err = errors.New("wallet terms need an admin")
+		rsp.Code = errcode.ErrCodePermissionDenied
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return rsp, err
+	}
+	err = s.locker.Lock()
+	if err != nil {
+		rsp.Code = errcode.ErrCodeLockFail
+		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
+		return rsp, err
+	}
+	defer func() {
+		if errt := s.locker.UnLock(); errt != nil {
+			rsp.Code = errcode.ErrCodeUnLockFail
+			rsp.Message = errcode.ErrMsgMap[rsp.Code] + errt.Error()
+		}
+	}()
+
+	err = s.runTx(s.storeFor(ownerOf(req.UserID, req.OrgID)), rsp, func(tx dao.UnitOfWork) error {
+		// admins set the terms of any wallet, they are not members of the organizations
+		var wallet *model.Wallet
+		var err error
+		if req.OrgID > 0 {
+			wallet, err = tx.Wallets().GetWalletByOrgID(req.OrgID, pocketName(req.Pocket))
+		} else {
+			wallet, err = tx.Wallets().GetWalletByUserID(req.UserID, pocketName(req.Pocket))
+		}
+		if err != nil {
+			rsp.Code = errcode.ErrCodeQueryDBFail
+			rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
+			return err
+		}
+		if wallet == nil {
+			err = errors.New("wallet not exist")
+			rsp.Code = errcode.ErrCodeUserWalletNotExist
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+
+		// the available funds [balance - held] may not fall below the new limit
+		if util.CompareFloat(wallet.Balance-wallet.Held, -req.CreditLimit, 8) < 0 {
+			err = errors.New("wallet overdrawn beyond the credit limit")
+			rsp.Code = errcode.ErrCodeBalanceNotEnough
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+
+		before := newWalletTermsState(wallet)
+		if err = tx.Wallets().UpdateWalletTerms(wallet.ID, req.CreditLimit, req.Product); err != nil {
+			log.Println("Failed to update wallet terms" + err.Error())
+			rsp.Code = errcode.ErrCodeDbError
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+		after := *before
+		after.CreditLimit, after.Product = req.CreditLimit, req.Product
+		if err = recordAudit(tx, s.actor, data.AuditActionWalletTerms, data.AuditTargetWallet, wallet.ID, before, &after, req.Reason); err != nil {
+			log.Println("Failed to record wallet terms audit" + err.Error())
+			rsp.Code = errcode.ErrCodeDbError
+			rsp.Message = errcode.ErrMsgMap[rsp.Code]
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return rsp, err
+	}
+
+	// the balance read back carries the credit limit
+	s.written(req.UserID)
+	rsp.Code = errcode.ErrCodeSuccess
+	rsp.Message = "Wallet terms set"
+	return rsp, nil
+}
@@ -0,0 +1,86 @@
+package service_test
+
+import (
+	"simplewallet/data"
+	"simplewallet/service/dao"
+	"simplewallet/util/errcode"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+func TestSetWalletTerms(t *testing.T) {
+	ConnectDBRedis()
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	defer DisconnectDBRedis()
+
+	// seed deposits 100.00 to 101
+	seed := func(t *testing.T, store dao.Store) {
+		rsp, err := newMemoryWalletService(store, "deposit").Deposit(&data.DepositReq{OrderID: "1001", UserID: 101, Amount: 100.00})
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+	}
+
+	t.Run("case1: set terms success-[credit limit and product set, audited]", func(t *testing.T) {
+		store := dao.NewMemoryStore()
+		seed(t, store)
+		rsp, err := newAdminWalletService(store, "alice").SetWalletTerms(&data.SetWalletTermsReq{UserID: 101, CreditLimit: 500.00, Product: "savings", Reason: "ticket 42"})
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+
+		wallet, err := store.Wallets().GetWalletByUserID(101, data.DefaultPocket)
+		require.Nil(t, err)
+		assert.Equal(t, 500.00, wallet.CreditLimit)
+		assert.Equal(t, "savings", wallet.Product)
+		rsp, err = newMemoryWalletService(store, "withdraw").Withdraw(&data.WithdrawReq{OrderID: "1002", UserID: 101, Amount: 300.00})
+		require.Nil(t, err)
+		assert.Equal(t, errcode.ErrCodeSuccess, rsp.Code, "drawn on the new limit")
+
+		entries, err := store.Audit().GetAuditLogList(&dao.AuditFilter{TargetType: data.AuditTargetWallet, TargetID: wallet.ID}, nil, 10)
+		require.Nil(t, err)
+		require.Len(t, entries, 1)
+		assert.Equal(t, data.AuditActionWalletTerms, entries[0].Action)
+		assert.Equal(t, "alice", entries[0].Actor)
+		assert.Equal(t, "ticket 42", entries[0].Reason)
+		assert.JSONEq(t, `{"wallet_id":1,"credit_limit":0,"product":"","balance":100,"held":0}`, entries[0].BeforeState)
+		assert.JSONEq(t, `{"wallet_id":1,"credit_limit":500,"product":"savings","balance":100,"held":0}`, entries[0].AfterState)
+	})
+
+	t.Run("case2: set terms fail-[limit below the overdraft, nothing written]", func(t *testing.T) {
+		store := dao.NewMemoryStore()
+		seed(t, store)
+		rsp, err := newAdminWalletService(store, "alice").SetWalletTerms(&data.SetWalletTermsReq{UserID: 101, CreditLimit: 500.00, Reason: "ticket 42"})
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		rsp, err = newMemoryWalletService(store, "withdraw").Withdraw(&data.WithdrawReq{OrderID: "1002", UserID: 101, Amount: 300.00})
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+
+		rsp, err = newAdminWalletService(store, "alice").SetWalletTerms(&data.SetWalletTermsReq{UserID: 101, CreditLimit: 100.00, Product: "savings", Reason: "ticket 43"})
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodeBalanceNotEnough, rsp.Code)
+		wallet, err := store.Wallets().GetWalletByUserID(101, data.DefaultPocket)
+		require.Nil(t, err)
+		assert.Equal(t, 500.00, wallet.CreditLimit)
+		assert.Empty(t, wallet.Product)
+		entries, err := store.Audit().GetAuditLogList(&dao.AuditFilter{Action: data.AuditActionWalletTerms}, nil, 10)
+		require.Nil(t, err)
+		assert.Len(t, entries, 1, "only the first change")
+	})
+
+	t.Run("case3: set terms fail-[no admin, or no wallet]", func(t *testing.T) {
+		store := dao.NewMemoryStore()
+		seed(t, store)
+		rsp, err := newMemoryWalletService(store, "terms").SetWalletTerms(&data.SetWalletTermsReq{UserID: 101, CreditLimit: 500.00, Reason: "ticket 42"})
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodePermissionDenied, rsp.Code)
+		rsp, err = newAdminWalletService(store, "alice").SetWalletTerms(&data.SetWalletTermsReq{UserID: 102, CreditLimit: 500.00, Reason: "ticket 42"})
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodeUserWalletNotExist, rsp.Code)
+		wallet, err := store.Wallets().GetWalletByUserID(101, data.DefaultPocket)
+		require.Nil(t, err)
+		assert.Equal(t, 0.00, wallet.CreditLimit)
+	})
+}
@@ -0,0 +1,394 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"simplewallet/data"
+	"simplewallet/model"
+	"simplewallet/service/dao"
+	"simplewallet/util"
+	"simplewallet/util/errcode"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// DefaultWebhookBatch is how many due deliveries of a shard one dispatcher run posts at most
+	DefaultWebhookBatch    int32 = 100
+	webhookDeliveryMaxList int32 = 100
+)
+
+// headers of a webhook post. The signature is "sha256=" and the hex hmac-sha256 of "<timestamp>.<body>"
+// keyed with the secret of the subscription, receivers reject old timestamps to stop replays.
+const (
+	WebhookHeaderEventID    = "X-Event-ID"
+	WebhookHeaderEventType  = "X-Event-Type"
+	WebhookHeaderDeliveryID = "X-Webhook-Delivery"
+	WebhookHeaderTimestamp  = "X-Webhook-Timestamp"
+	WebhookHeaderSignature  = "X-Webhook-Signature"
+)
+
+// SignWebhook returns the signature header of a post of the body at the unix second timestamp
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookRetryPolicy spaces the posts of a failing delivery: the n-th failure waits BaseDelay * 2^(n-1),
+// at most MaxDelay, and the delivery is dead after MaxAttempts posts.
+type WebhookRetryPolicy struct {
+	MaxAttempts int32
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+var DefaultWebhookRetryPolicy = WebhookRetryPolicy{MaxAttempts: 8, BaseDelay: 30 * time.Second, MaxDelay: time.Hour}
+
+// backoff returns the wait after the given failed attempt, the first one is 1
+func (p WebhookRetryPolicy) backoff(attempt int32) time.Duration {
+	delay := p.BaseDelay
+	for i := int32(1); i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxDelay)
+}
+
+// subscribedTo tells whether the subscription wants events of the type, an empty list wants all
+func subscribedTo(sub *model.WebhookSubscription, eventType string) bool {
+	return sub.EventTypes == "" || slices.Contains(strings.Split(sub.EventTypes, ","), eventType)
+}
+
+// WebhookPublisher is the outbox publisher fanning the wallet events out to the webhook subscriptions of the
+// users they touch: the owner of the wallet, and the recipient of a transfer. It records a pending delivery
+// per subscription on the shard of its user, the WebhookDispatcher posts them. An event published again
+// records no second delivery.
+type WebhookPublisher struct {
+	logID    string
+	storeFor func(userID int64) dao.Store
+}
+
+// NewWebhookPublisher returns the publisher on the shards of util/db
+func NewWebhookPublisher(ctx context.Context, logID string, dbCli *sql.DB) *WebhookPublisher {
+	store := newSqlStore(ctx, logID, dbCli)
+	if shards := newShardedStore(ctx, logID, store); shards != nil {
+		return &WebhookPublisher{logID: logID, storeFor: shards.ForUser}
+	}
+	return NewWebhookPublisherWithStore(logID, store)
+}
+
+func NewWebhookPublisherWithStore(logID string, store dao.Store) *WebhookPublisher {
+	return &WebhookPublisher{logID: logID, storeFor: func(int64) dao.Store { return store }}
+}
+
+func (p *WebhookPublisher) Publish(ctx context.Context, event *model.OutboxEvent) error {
+	walletEvent := &data.WalletEvent{}
+	if err := json.Unmarshal([]byte(event.Payload), walletEvent); err != nil {
+		return fmt.Errorf("decode event %s: %w", event.EventID, err)
+	}
+	userIDs := []int64{walletEvent.UserID}
+	if walletEvent.ToUserID > 0 && walletEvent.ToUserID != walletEvent.UserID {
+		userIDs = append(userIDs, walletEvent.ToUserID)
+	}
+	for _, userID := range userIDs {
+		webhookDao := p.storeFor(userID).Webhooks()
+		subList, err := webhookDao.GetSubscriptionListByUserID(userID)
+		if err != nil {
+			return err
+		}
+		for _, sub := range subList {
+			if !subscribedTo(sub, event.EventType) {
+				continue
+			}
+			_, err = webhookDao.InsertDelivery(&model.WebhookDelivery{SubscriptionID: sub.ID, UserID: userID, EventID: event.EventID,
+				EventType: event.EventType, Payload: event.Payload, NextAttemptAt: time.Now().Unix()})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// errWebhookTargetDenied fails the posts to the host itself and to private networks, which the users
+// subscribing urls must not reach
+var errWebhookTargetDenied = errors.New("webhook target is a loopback, private or link-local address")
+
+// publicWebhookIP tells whether a webhook may be posted to the ip
+func publicWebhookIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified()
+}
+
+// denyPrivateTargets is the dialer control of the webhook posts: it checks the address dialed, once the
+// host of the url was resolved, so a name resolving to a private address is refused too
+func denyPrivateTargets(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicWebhookIP(ip) {
+		return errWebhookTargetDenied
+	}
+	return nil
+}
+
+// WebhookDispatcher posts the due webhook deliveries of every shard, signed with the secret of their
+// subscription. A 2xx response delivers it, anything else is retried with backoff until the delivery is dead.
+// Posts to loopback, private and link-local addresses fail unless WithPrivateTargets allows them.
+type WebhookDispatcher struct {
+	logID  string
+	ctx    context.Context
+	stores []dao.Store // the shards, or the one store
+	dialer *net.Dialer
+	client *http.Client
+	policy WebhookRetryPolicy
+	batch  int32
+	locker util.DistributedLock
+}
+
+// NewWebhookDispatcher returns the dispatcher on the shards of util/db, a post taking longer than timeout fails
+func NewWebhookDispatcher(ctx context.Context, logID string, dbCli *sql.DB, timeout time.Duration, policy WebhookRetryPolicy, batch int32, locker util.DistributedLock) *WebhookDispatcher {
+	return NewWebhookDispatcherWithStores(ctx, logID, shardStores(ctx, logID, dbCli), timeout, policy, batch, locker)
+}
+
+func NewWebhookDispatcherWithStores(ctx context.Context, logID string, stores []dao.Store, timeout time.Duration, policy WebhookRetryPolicy, batch int32, locker util.DistributedLock) *WebhookDispatcher {
+	if batch <= 0 {
+		batch = DefaultWebhookBatch
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: denyPrivateTargets}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// no proxy, the address dialed is the one of the receiver
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	client := &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		// a redirect is not followed, it fails like any other non 2xx response
+		CheckRedirect: func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	return &WebhookDispatcher{
+		ctx:    ctx,
+		logID:  logID,
+		stores: stores,
+		dialer: dialer,
+		client: client,
+		policy: policy,
+		batch:  batch,
+		locker: locker,
+	}
+}
+
+// WithPrivateTargets allows the posts to loopback, private and link-local addresses, for receivers on the
+// same host or network in development
+func (w *WebhookDispatcher) WithPrivateTargets(allow bool) *WebhookDispatcher {
+	w.dialer.Control = denyPrivateTargets
+	if allow {
+		w.dialer.Control = nil
+	}
+	return w
+}
+
+// Run posts one batch of the deliveries due at now of every shard, returns how many were delivered.
+// The lock keeps a second dispatcher from posting the same deliveries.
+func (w *WebhookDispatcher) Run(now time.Time) (delivered int, err error) {
+	if err = w.locker.Lock(); err != nil {
+		return 0, err
+	}
+	defer func() {
+		if errt := w.locker.UnLock(); errt != nil && err == nil {
+			err = errt
+		}
+	}()
+
+	var errs []error
+	for shard, store := range w.stores {
+		n, err := w.runStore(store, now)
+		delivered += n
+		if err != nil {
+			log.Printf("%s|fail to dispatch webhook deliveries of shard %d:%s\n", w.logID, shard, err.Error())
+			errs = append(errs, err)
+		}
+	}
+	return delivered, errors.Join(errs...)
+}
+
+func (w *WebhookDispatcher) runStore(store dao.Store, now time.Time) (int, error) {
+	webhookDao := store.Webhooks()
+	deliveryList, err := webhookDao.GetDueDeliveryList(now.Unix(), w.batch)
+	if err != nil {
+		return 0, err
+	}
+	subs := make(map[int64]*model.WebhookSubscription)
+	delivered := 0
+	for _, delivery := range deliveryList {
+		sub, ok := subs[delivery.SubscriptionID]
+		if !ok {
+			if sub, err = webhookDao.GetSubscriptionByID(delivery.SubscriptionID); err != nil {
+				return delivered, err
+			}
+			subs[delivery.SubscriptionID] = sub
+		}
+		status, nextAttemptAt, lastError := data.WebhookDeliveryDelivered, now.Unix(), ""
+		statusCode, errPost := w.post(sub, delivery, now)
+		if errPost != nil {
+			log.Printf("%s|[%d] fail to post webhook delivery:%s\n", w.logID, delivery.ID, errPost.Error())
+			status, lastError = data.WebhookDeliveryPending, errPost.Error()
+			if attempt := delivery.Attempts + 1; attempt >= w.policy.MaxAttempts {
+				status = data.WebhookDeliveryDead
+			} else {
+				nextAttemptAt = now.Add(w.policy.backoff(attempt)).Unix()
+			}
+		}
+		if _, err = webhookDao.RecordDeliveryAttempt(delivery.ID, status, statusCode, lastError, nextAttemptAt); err != nil {
+			return delivered, err
+		}
+		if status == data.WebhookDeliveryDelivered {
+			delivered++
+		}
+	}
+	return delivered, nil
+}
+
+// post sends the delivery to the url of the subscription, returns the http status it got, 0 for none
+func (w *WebhookDispatcher) post(sub *model.WebhookSubscription, delivery *model.WebhookDelivery, now time.Time) (int32, error) {
+	if sub == nil {
+		return 0, errors.New("webhook subscription not exist")
+	}
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := now.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookHeaderEventID, delivery.EventID)
+	req.Header.Set(WebhookHeaderEventType, delivery.EventType)
+	req.Header.Set(WebhookHeaderDeliveryID, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(WebhookHeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookHeaderSignature, SignWebhook(sub.Secret, timestamp, body))
+	rsp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer rsp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(rsp.Body, 64<<10))
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		return int32(rsp.StatusCode), fmt.Errorf("http status %d", rsp.StatusCode)
+	}
+	return int32(rsp.StatusCode), nil
+}
+
+// CreateWebhook subscribes a url to the wallet events of the user, signed with the given secret or a random one
+func (s *WalletService) CreateWebhook(req *data.CreateWebhookReq) (rsp *data.CreateWebhookRsp, err error) {
+	rsp = &data.CreateWebhookRsp{Code: errcode.ErrCodeInternalErr, Message: errcode.ErrMsgMap[errcode.ErrCodeInternalErr], LogID: s.logID}
+	defer func() { mapDbError(err, &rsp.Code, &rsp.Message) }()
+
+	secret := req.Secret
+	if secret == "" {
+		key := make([]byte, 32)
+		if _, err = rand.Read(key); err != nil {
+			return rsp, err
+		}
+		secret = hex.EncodeToString(key)
+	}
+	sub := &model.WebhookSubscription{UserID: req.UserID, URL: req.URL, EventTypes: strings.Join(req.EventTypes, ","), Secret: secret}
+	subID, err := s.storeFor(req.UserID).Webhooks().CreateSubscription(sub)
+	if err != nil {
+		rsp.Code = errcode.ErrCodeDbError
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return rsp, err
+	}
+
+	rsp.Code = errcode.ErrCodeSuccess
+	rsp.Message = "Success"
+	rsp.Data = &data.CreateWebhookRspData{SubscriptionID: subID, Secret: secret}
+	return rsp, nil
+}
+
+// GetWebhookDeliveryList lists the webhook deliveries of the user, newest first
+func (s *WalletService) GetWebhookDeliveryList(req *data.GetWebhookDeliveryListReq) (rsp *data.GetWebhookDeliveryListRsp, err error) {
+	rsp = &data.GetWebhookDeliveryListRsp{Code: errcode.ErrCodeInternalErr, Message: errcode.ErrMsgMap[errcode.ErrCodeInternalErr], LogID: s.logID}
+	defer func() { mapDbError(err, &rsp.Code, &rsp.Message) }()
+
+	status := int32(-1)
+	for code, name := range data.WebhookDeliveryStatusNames {
+		if name == req.Status {
+			status = code
+		}
+	}
+	limit := req.Limit
+	if limit <= 0 || limit > webhookDeliveryMaxList {
+		limit = webhookDeliveryMaxList
+	}
+	deliveryList, err := s.storeFor(req.UserID).Webhooks().GetDeliveryList(req.UserID, status, req.BeforeID, limit)
+	if err != nil {
+		rsp.Code = errcode.ErrCodeQueryDBFail
+		rsp.Message = errcode.ErrMsgMap[rsp.Code] + err.Error()
+		return rsp, err
+	}
+	rspData := &data.GetWebhookDeliveryListRspData{Items: make([]*data.GetWebhookDeliveryListRspDataItem, 0, len(deliveryList))}
+	for _, delivery := range deliveryList {
+		rspData.Items = append(rspData.Items, &data.GetWebhookDeliveryListRspDataItem{
+			DeliveryID:     delivery.ID,
+			SubscriptionID: delivery.SubscriptionID,
+			EventID:        delivery.EventID,
+			EventType:      delivery.EventType,
+			Payload:        delivery.Payload,
+			Status:         data.WebhookDeliveryStatusNames[delivery.Status],
+			Attempts:       delivery.Attempts,
+			NextAttemptAt:  time.Unix(delivery.NextAttemptAt, 0).Format("2006-01-02 15:04:05"),
+			LastStatusCode: delivery.LastStatusCode,
+			LastError:      delivery.LastError,
+			CreatedAt:      time.Unix(delivery.CreatedAt, 0).Format("2006-01-02 15:04:05"),
+		})
+	}
+	if len(deliveryList) == int(limit) {
+		rspData.BeforeID = deliveryList[len(deliveryList)-1].ID
+	}
+
+	rsp.Code = errcode.ErrCodeSuccess
+	rsp.Message = "Success"
+	rsp.Data = rspData
+	return rsp, nil
+}
+
+// RedeliverWebhook posts a delivery of the user again on the next dispatcher run, with a new round of retries,
+// whether it was delivered, dead or still pending
+func (s *WalletService) RedeliverWebhook(req *data.RedeliverWebhookReq) (rsp *data.CommRsp, err error) {
+	rsp = &data.CommRsp{Code: errcode.ErrCodeInternalErr, Message: errcode.ErrMsgMap[errcode.ErrCodeInternalErr], LogID: s.logID}
+	defer func() { mapDbError(err, &rsp.Code, &rsp.Message) }()
+
+	updated, err := s.storeFor(req.UserID).Webhooks().RedeliverDelivery(req.DeliveryID, req.UserID, time.Now().Unix())
+	if err != nil {
+		rsp.Code = errcode.ErrCodeDbError
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return rsp, err
+	}
+	if !updated {
+		err = errors.New("webhook delivery not exist")
+		rsp.Code = errcode.ErrCodeWebhookNotExist
+		rsp.Message = errcode.ErrMsgMap[rsp.Code]
+		return rsp, err
+	}
+
+	rsp.Code = errcode.ErrCodeSuccess
+	rsp.Message = "Success"
+	return rsp, nil
+}
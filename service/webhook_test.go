@@ -0,0 +1,321 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"simplewallet/data"
+	"simplewallet/model"
+	"simplewallet/service"
+	"simplewallet/service/dao"
+	"simplewallet/util"
+	"simplewallet/util/db"
+	"simplewallet/util/errcode"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+// webhookReceiver records the posts it gets and answers them with its status
+type webhookReceiver struct {
+	mu     sync.Mutex
+	status int
+	posts  []webhookPost
+}
+
+type webhookPost struct {
+	header http.Header
+	body   []byte
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.posts = append(r.posts, webhookPost{header: req.Header.Clone(), body: body})
+	w.WriteHeader(r.status)
+}
+
+func (r *webhookReceiver) setStatus(status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = status
+}
+
+func (r *webhookReceiver) received() []webhookPost {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]webhookPost(nil), r.posts...)
+}
+
+var testWebhookPolicy = service.WebhookRetryPolicy{MaxAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Hour}
+
+func newWebhookDispatcher(store dao.Store) *service.WebhookDispatcher {
+	logID := util.Uniqid()
+	ctx := context.Background()
+	locker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, "webhook:"+logID, 5)
+	// the receivers of the tests listen on 127.0.0.1
+	return service.NewWebhookDispatcherWithStores(ctx, logID, []dao.Store{store}, time.Second, testWebhookPolicy, 0, locker).WithPrivateTargets(true)
+}
+
+// relayToWebhooks fans the pending outbox events of the store out to its webhook subscriptions
+func relayToWebhooks(t *testing.T, store dao.Store) {
+	_, err := newOutboxRelay(store, service.NewWebhookPublisherWithStore(util.Uniqid(), store)).Run()
+	require.Nil(t, err)
+}
+
+func TestWebhook(t *testing.T) {
+	ConnectDBRedis()
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	defer DisconnectDBRedis()
+
+	deposit := func(t *testing.T, store dao.Store, orderID string, userID int64, amount float64) {
+		rsp, err := newMemoryWalletService(store, "deposit").Deposit(&data.DepositReq{OrderID: orderID, UserID: userID, Amount: amount})
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+	}
+	createWebhook := func(t *testing.T, store dao.Store, req *data.CreateWebhookReq) *data.CreateWebhookRspData {
+		rsp, err := newMemoryWalletService(store, "").CreateWebhook(req)
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		return rsp.Data
+	}
+	deliveriesOf := func(t *testing.T, store dao.Store, req *data.GetWebhookDeliveryListReq) []*data.GetWebhookDeliveryListRspDataItem {
+		rsp, err := newMemoryWalletService(store, "").GetWebhookDeliveryList(req)
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		return rsp.Data.Items
+	}
+
+	t.Run("case1: webhook success-[signed posts to the owner and the recipient]", func(t *testing.T) {
+		receiver := &webhookReceiver{status: http.StatusOK}
+		srv := httptest.NewServer(receiver)
+		defer srv.Close()
+		store := dao.NewMemoryStore()
+		merchant := createWebhook(t, store, &data.CreateWebhookReq{UserID: 102, URL: srv.URL + "/merchant", EventTypes: []string{data.EventTypeTransfer}, Secret: "merchant-secret-0123"})
+		sender := createWebhook(t, store, &data.CreateWebhookReq{UserID: 101, URL: srv.URL + "/sender"})
+		assert.Equal(t, "merchant-secret-0123", merchant.Secret)
+		assert.Len(t, sender.Secret, 64, "random secret")
+
+		deposit(t, store, "1001", 101, 100.00)
+		rsp, err := newMemoryWalletService(store, "transfer").Transfer(&data.TransferReq{OrderID: "1002", FromUserID: 101, ToUserID: 102, Amount: 30.00})
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		relayToWebhooks(t, store)
+
+		delivered, err := newWebhookDispatcher(store).Run(time.Now())
+		require.Nil(t, err)
+		assert.Equal(t, 3, delivered, "deposit and transfer to the sender, transfer to the merchant")
+		posts := receiver.received()
+		require.Len(t, posts, 3)
+		secrets := map[string]string{"merchant-secret-0123": "", sender.Secret: ""}
+		for _, post := range posts {
+			timestamp, err := strconv.ParseInt(post.header.Get(service.WebhookHeaderTimestamp), 10, 64)
+			require.Nil(t, err)
+			assert.InDelta(t, time.Now().Unix(), timestamp, 5)
+			signature := post.header.Get(service.WebhookHeaderSignature)
+			matched := false
+			for secret := range secrets {
+				matched = matched || signature == service.SignWebhook(secret, timestamp, post.body)
+			}
+			assert.True(t, matched, "signed with the secret of the subscription")
+			assert.NotEqual(t, service.SignWebhook("another-secret-0123", timestamp, post.body), signature)
+
+			event := &data.WalletEvent{}
+			require.Nil(t, json.Unmarshal(post.body, event))
+			assert.Equal(t, event.EventID, post.header.Get(service.WebhookHeaderEventID))
+			assert.Equal(t, event.EventType, post.header.Get(service.WebhookHeaderEventType))
+			assert.NotEmpty(t, post.header.Get(service.WebhookHeaderDeliveryID))
+		}
+
+		items := deliveriesOf(t, store, &data.GetWebhookDeliveryListReq{UserID: 102, Limit: 10})
+		require.Len(t, items, 1)
+		assert.Equal(t, merchant.SubscriptionID, items[0].SubscriptionID)
+		assert.Equal(t, data.EventTypeTransfer, items[0].EventType)
+		assert.Equal(t, "delivered", items[0].Status)
+		assert.Equal(t, int32(1), items[0].Attempts)
+		assert.Equal(t, int32(http.StatusOK), items[0].LastStatusCode)
+		event := &data.WalletEvent{}
+		require.Nil(t, json.Unmarshal([]byte(items[0].Payload), event))
+		assert.Equal(t, int64(102), event.ToUserID)
+		assert.Equal(t, 30.00, event.Amount)
+
+		// an event published again is not delivered again
+		delivered, err = newWebhookDispatcher(store).Run(time.Now())
+		require.Nil(t, err)
+		assert.Equal(t, 0, delivered)
+		publisher := service.NewWebhookPublisherWithStore(util.Uniqid(), store)
+		require.Nil(t, publisher.Publish(context.Background(), &model.OutboxEvent{EventID: items[0].EventID, EventType: items[0].EventType, Payload: items[0].Payload}))
+		assert.Len(t, deliveriesOf(t, store, &data.GetWebhookDeliveryListReq{UserID: 102, Limit: 10}), 1)
+		assert.Len(t, deliveriesOf(t, store, &data.GetWebhookDeliveryListReq{UserID: 101, Limit: 10}), 2)
+	})
+
+	t.Run("case2: webhook fail-[retried with backoff, dead, redelivered]", func(t *testing.T) {
+		receiver := &webhookReceiver{status: http.StatusInternalServerError}
+		srv := httptest.NewServer(receiver)
+		defer srv.Close()
+		store := dao.NewMemoryStore()
+		createWebhook(t, store, &data.CreateWebhookReq{UserID: 101, URL: srv.URL})
+		deposit(t, store, "1001", 101, 100.00)
+		relayToWebhooks(t, store)
+
+		now := time.Now()
+		delivered, err := newWebhookDispatcher(store).Run(now)
+		require.Nil(t, err)
+		assert.Equal(t, 0, delivered)
+		items := deliveriesOf(t, store, &data.GetWebhookDeliveryListReq{UserID: 101, Limit: 10})
+		require.Len(t, items, 1)
+		assert.Equal(t, "pending", items[0].Status)
+		assert.Equal(t, int32(1), items[0].Attempts)
+		assert.Equal(t, int32(http.StatusInternalServerError), items[0].LastStatusCode)
+		assert.Equal(t, "http status 500", items[0].LastError)
+		assert.Equal(t, now.Add(time.Minute).Format("2006-01-02 15:04:05"), items[0].NextAttemptAt)
+
+		// not due before the backoff, then 1 and 2 minutes apart
+		_, err = newWebhookDispatcher(store).Run(now.Add(59 * time.Second))
+		require.Nil(t, err)
+		assert.Len(t, receiver.received(), 1)
+		_, err = newWebhookDispatcher(store).Run(now.Add(time.Minute))
+		require.Nil(t, err)
+		assert.Len(t, receiver.received(), 2)
+		_, err = newWebhookDispatcher(store).Run(now.Add(2 * time.Minute))
+		require.Nil(t, err)
+		assert.Len(t, receiver.received(), 2)
+		_, err = newWebhookDispatcher(store).Run(now.Add(3 * time.Minute))
+		require.Nil(t, err)
+		assert.Len(t, receiver.received(), 3)
+
+		items = deliveriesOf(t, store, &data.GetWebhookDeliveryListReq{UserID: 101, Status: "dead", Limit: 10})
+		require.Len(t, items, 1)
+		assert.Equal(t, int32(3), items[0].Attempts)
+		_, err = newWebhookDispatcher(store).Run(now.Add(24 * time.Hour))
+		require.Nil(t, err)
+		assert.Len(t, receiver.received(), 3, "a dead delivery is not posted any more")
+
+		receiver.setStatus(http.StatusNoContent)
+		rsp, err := newMemoryWalletService(store, "").RedeliverWebhook(&data.RedeliverWebhookReq{UserID: 101, DeliveryID: items[0].DeliveryID})
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		delivered, err = newWebhookDispatcher(store).Run(time.Now())
+		require.Nil(t, err)
+		assert.Equal(t, 1, delivered)
+		items = deliveriesOf(t, store, &data.GetWebhookDeliveryListReq{UserID: 101, Limit: 10})
+		require.Len(t, items, 1)
+		assert.Equal(t, "delivered", items[0].Status)
+		assert.Equal(t, int32(1), items[0].Attempts)
+		assert.Equal(t, int32(http.StatusNoContent), items[0].LastStatusCode)
+	})
+
+	t.Run("case3: webhook fail-[unreachable and redirecting receivers]", func(t *testing.T) {
+		redirect := httptest.NewServer(http.RedirectHandler("http://127.0.0.1:1/", http.StatusFound))
+		defer redirect.Close()
+		store := dao.NewMemoryStore()
+		createWebhook(t, store, &data.CreateWebhookReq{UserID: 101, URL: "http://127.0.0.1:1/hooks"})
+		createWebhook(t, store, &data.CreateWebhookReq{UserID: 101, URL: redirect.URL})
+		deposit(t, store, "1001", 101, 100.00)
+		relayToWebhooks(t, store)
+
+		delivered, err := newWebhookDispatcher(store).Run(time.Now())
+		require.Nil(t, err)
+		assert.Equal(t, 0, delivered)
+		items := deliveriesOf(t, store, &data.GetWebhookDeliveryListReq{UserID: 101, Limit: 10})
+		require.Len(t, items, 2)
+		assert.Equal(t, int32(http.StatusFound), items[0].LastStatusCode, "not followed")
+		assert.Equal(t, int32(0), items[1].LastStatusCode)
+		assert.NotEmpty(t, items[1].LastError)
+	})
+
+	t.Run("case4: webhook success-[event types filtered, deliveries paged]", func(t *testing.T) {
+		receiver := &webhookReceiver{status: http.StatusOK}
+		srv := httptest.NewServer(receiver)
+		defer srv.Close()
+		store := dao.NewMemoryStore()
+		createWebhook(t, store, &data.CreateWebhookReq{UserID: 101, URL: srv.URL, EventTypes: []string{data.EventTypeDeposit}})
+		for i := 0; i < 3; i++ {
+			deposit(t, store, "100"+strconv.Itoa(i), 101, 10.00)
+		}
+		rsp, err := newMemoryWalletService(store, "withdraw").Withdraw(&data.WithdrawReq{OrderID: "2001", UserID: 101, Amount: 5.00})
+		require.Nil(t, err)
+		require.Equal(t, errcode.ErrCodeSuccess, rsp.Code)
+		relayToWebhooks(t, store)
+
+		listRsp, err := newMemoryWalletService(store, "").GetWebhookDeliveryList(&data.GetWebhookDeliveryListReq{UserID: 101, Limit: 2})
+		require.Nil(t, err)
+		require.Len(t, listRsp.Data.Items, 2)
+		assert.Greater(t, listRsp.Data.Items[0].DeliveryID, listRsp.Data.Items[1].DeliveryID, "newest first")
+		require.NotZero(t, listRsp.Data.BeforeID)
+		listRsp, err = newMemoryWalletService(store, "").GetWebhookDeliveryList(&data.GetWebhookDeliveryListReq{UserID: 101, BeforeID: listRsp.Data.BeforeID, Limit: 2})
+		require.Nil(t, err)
+		require.Len(t, listRsp.Data.Items, 1, "no delivery of the withdraw")
+		assert.Zero(t, listRsp.Data.BeforeID)
+		for _, item := range deliveriesOf(t, store, &data.GetWebhookDeliveryListReq{UserID: 101, Limit: 10}) {
+			assert.Equal(t, data.EventTypeDeposit, item.EventType)
+		}
+		assert.Empty(t, deliveriesOf(t, store, &data.GetWebhookDeliveryListReq{UserID: 101, Status: "dead", Limit: 10}))
+	})
+
+	t.Run("case5: redeliver webhook fail-[delivery of another user]", func(t *testing.T) {
+		store := dao.NewMemoryStore()
+		createWebhook(t, store, &data.CreateWebhookReq{UserID: 101, URL: "http://127.0.0.1:1/hooks"})
+		deposit(t, store, "1001", 101, 100.00)
+		relayToWebhooks(t, store)
+		items := deliveriesOf(t, store, &data.GetWebhookDeliveryListReq{UserID: 101, Limit: 10})
+		require.Len(t, items, 1)
+
+		rsp, err := newMemoryWalletService(store, "").RedeliverWebhook(&data.RedeliverWebhookReq{UserID: 102, DeliveryID: items[0].DeliveryID})
+		assert.NotNil(t, err)
+		assert.Equal(t, errcode.ErrCodeWebhookNotExist, rsp.Code)
+	})
+
+	t.Run("case6: webhook fail-[receiver on a loopback address, never posted]", func(t *testing.T) {
+		receiver := &webhookReceiver{status: http.StatusOK}
+		srv := httptest.NewServer(receiver)
+		defer srv.Close()
+		store := dao.NewMemoryStore()
+		createWebhook(t, store, &data.CreateWebhookReq{UserID: 101, URL: srv.URL})
+		createWebhook(t, store, &data.CreateWebhookReq{UserID: 101, URL: strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)})
+		deposit(t, store, "1001", 101, 100.00)
+		relayToWebhooks(t, store)
+
+		logID := util.Uniqid()
+		ctx := context.Background()
+		dispatcher := service.NewWebhookDispatcherWithStores(ctx, logID, []dao.Store{store}, time.Second, testWebhookPolicy, 0,
+			util.NewDistributedLock(ctx, db.GetRedisClient(), logID, "webhook:"+logID, 5))
+		delivered, err := dispatcher.Run(time.Now())
+		require.Nil(t, err)
+		assert.Equal(t, 0, delivered)
+		assert.Empty(t, receiver.received())
+		items := deliveriesOf(t, store, &data.GetWebhookDeliveryListReq{UserID: 101, Limit: 10})
+		require.Len(t, items, 2)
+		for _, item := range items {
+			assert.Equal(t, "pending", item.Status)
+			assert.Contains(t, item.LastError, "loopback, private or link-local")
+		}
+	})
+
+	t.Run("case7: webhook success-[fanned out next to the outbox publisher, once]", func(t *testing.T) {
+		store := dao.NewMemoryStore()
+		createWebhook(t, store, &data.CreateWebhookReq{UserID: 101, URL: "http://127.0.0.1:1/hooks"})
+		deposit(t, store, "1001", 101, 100.00)
+		sink := &failingPublisher{fail: true}
+		publisher := service.NewMultiPublisher(service.NewWebhookPublisherWithStore(util.Uniqid(), store), sink)
+
+		_, err := newOutboxRelay(store, publisher).Run()
+		assert.NotNil(t, err)
+		require.Len(t, deliveriesOf(t, store, &data.GetWebhookDeliveryListReq{UserID: 101, Limit: 10}), 1)
+		sink.fail = false
+		sent, err := newOutboxRelay(store, publisher).Run()
+		require.Nil(t, err)
+		assert.Equal(t, 1, sent)
+		assert.Len(t, sink.published, 1, "the outbox publisher still gets the event")
+		assert.Len(t, deliveriesOf(t, store, &data.GetWebhookDeliveryListReq{UserID: 101, Limit: 10}), 1, "published again, delivered once")
+	})
+}
@@ -0,0 +1,142 @@
+package db
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	DefaultConnectTimeoutMs      = 5000
+	DefaultConnectRetries        = 5
+	DefaultConnectRetryBackoffMs = 500
+)
+
+var dsnQuoter = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+// quoteDSNValue quotes a value of a key=value postgres DSN when it is empty or has spaces, quotes or backslashes
+func quoteDSNValue(v string) string {
+	if v != "" && !strings.ContainsAny(v, ` '\`) {
+		return v
+	}
+	return "'" + dsnQuoter.Replace(v) + "'"
+}
+
+// PostgresDSN returns the key=value DSN of the postgres db at host and port, with the user, password
+// and ssl settings of the config. sslmode is disable when not set.
+func (conf *DbConf) PostgresDSN(host string, port int, dbName string) string {
+	sslMode := conf.SslMode
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+	params := [][2]string{{"host", host}, {"port", strconv.Itoa(port)}, {"user", conf.User}, {"password", conf.Password}, {"dbname", dbName}, {"sslmode", sslMode}}
+	for _, param := range [][2]string{{"sslrootcert", conf.SslRootCert}, {"sslcert", conf.SslCert}, {"sslkey", conf.SslKey}} {
+		if param[1] != "" {
+			params = append(params, param)
+		}
+	}
+	pairs := make([]string, 0, len(params))
+	for _, param := range params {
+		pairs = append(pairs, param[0]+"="+quoteDSNValue(param[1]))
+	}
+	return strings.Join(pairs, " ")
+}
+
+// normalizeDSN turns a postgres:// url into a key=value DSN, other DSNs are returned as they are
+func normalizeDSN(dsn string) (string, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return pq.ParseURL(dsn)
+	}
+	return dsn, nil
+}
+
+// configurePool applies the pool settings of the config to the db client, 0 keeps the database/sql defaults
+func configurePool(cli *sql.DB, conf *DbConf) {
+	if conf.MaxOpenConns > 0 {
+		cli.SetMaxOpenConns(conf.MaxOpenConns)
+	}
+	if conf.MaxIdleConns > 0 {
+		cli.SetMaxIdleConns(conf.MaxIdleConns)
+	}
+	if conf.ConnMaxLifetimeMs > 0 {
+		cli.SetConnMaxLifetime(time.Duration(conf.ConnMaxLifetimeMs) * time.Millisecond)
+	}
+	if conf.ConnMaxIdleTimeMs > 0 {
+		cli.SetConnMaxIdleTime(time.Duration(conf.ConnMaxIdleTimeMs) * time.Millisecond)
+	}
+}
+
+// connectRetry is how a client is pinged on start
+type connectRetry struct {
+	timeout time.Duration // one ping
+	retries int
+	backoff time.Duration // before the first retry, doubled on every retry
+}
+
+// newConnectRetry returns the retry of the config values, the defaults apply when 0 and a negative count never retries
+func newConnectRetry(timeoutMs int, retries int, backoffMs int) connectRetry {
+	if retries == 0 {
+		retries = DefaultConnectRetries
+	} else if retries < 0 {
+		retries = 0
+	}
+	return connectRetry{
+		timeout: timeoutOrDefault(timeoutMs, DefaultConnectTimeoutMs),
+		retries: retries,
+		backoff: timeoutOrDefault(backoffMs, DefaultConnectRetryBackoffMs),
+	}
+}
+
+// ping runs ping until it succeeds, so a wrong password or an unreachable server fails the start
+// instead of the first request. It returns the last error once the retries are used up.
+func (r connectRetry) ping(name string, ping func(ctx context.Context) error) error {
+	backoff := r.backoff
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+		err := ping(ctx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if attempt >= r.retries {
+			return fmt.Errorf("fail to connect %s: %w", name, err)
+		}
+		log.Printf("fail to connect %s, retry in %s:%s\n", name, backoff, err.Error())
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// tlsConfig returns the tls config verifying the server with the CA file, or the system roots without one,
+// and presenting the client cert when its files are given
+func tlsConfig(serverName string, caFile string, certFile string, keyFile string) (*tls.Config, error) {
+	conf := &tls.Config{ServerName: serverName, MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificate in " + caFile)
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
@@ -5,32 +5,256 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"time"
 )
 
 type DbConf struct {
-	Host     string `yaml:"host" json:"host"`
-	Port     int    `yaml:"port" json:"port"`
-	User     string `yaml:"user" json:"user"`
-	Password string `yaml:"password" json:"password"`
-	DbName   string `yaml:"dbname" json:"dbname"`
+	Driver      string `yaml:"driver" json:"driver"` // postgres(default) or sqlite
+	Path        string `yaml:"path" json:"path"`     // sqlite database file
+	Host        string `yaml:"host" json:"host"`
+	Port        int    `yaml:"port" json:"port"`
+	User        string `yaml:"user" json:"user"`
+	Password    string `yaml:"password" json:"password"`
+	DbName      string `yaml:"dbname" json:"dbname"`
+	AutoMigrate bool   `yaml:"auto_migrate" json:"auto_migrate"` // apply pending migrations on start
+	// full postgres DSN, key=value or a postgres:// url, used instead of host, port, user, password, dbname and the ssl settings
+	DSN string `yaml:"dsn" json:"dsn"`
+	// tls of postgres: disable (default), require, verify-ca or verify-full, with the CA and the client cert files
+	SslMode     string `yaml:"sslmode" json:"sslmode"`
+	SslRootCert string `yaml:"sslrootcert" json:"sslrootcert"`
+	SslCert     string `yaml:"sslcert" json:"sslcert"`
+	SslKey      string `yaml:"sslkey" json:"sslkey"`
+	// pool of every db client, the database/sql defaults apply when 0
+	MaxOpenConns      int `yaml:"max_open_conns" json:"max_open_conns"`
+	MaxIdleConns      int `yaml:"max_idle_conns" json:"max_idle_conns"`
+	ConnMaxLifetimeMs int `yaml:"conn_max_lifetime_ms" json:"conn_max_lifetime_ms"`
+	ConnMaxIdleTimeMs int `yaml:"conn_max_idle_time_ms" json:"conn_max_idle_time_ms"`
+	// ping on start, retried after a backoff doubled on every retry, the defaults apply when 0
+	ConnectTimeoutMs      int `yaml:"connect_timeout_ms" json:"connect_timeout_ms"` // one ping
+	ConnectRetries        int `yaml:"connect_retries" json:"connect_retries"`       // negative never retries
+	ConnectRetryBackoffMs int `yaml:"connect_retry_backoff_ms" json:"connect_retry_backoff_ms"`
+	// timeouts of the db operations in milliseconds, the defaults apply when 0
+	QueryTimeoutMs int `yaml:"query_timeout_ms" json:"query_timeout_ms"` // one read
+	ExecTimeoutMs  int `yaml:"exec_timeout_ms" json:"exec_timeout_ms"`   // one write
+	TxTimeoutMs    int `yaml:"tx_timeout_ms" json:"tx_timeout_ms"`       // a transaction, from begin to commit
+	// isolation level of the wallet transactions: read_committed, repeatable_read or serializable, the db default when empty
+	Isolation string `yaml:"isolation" json:"isolation"`
+	// retries of a transaction aborted by a serialization failure or a deadlock, the defaults apply when 0
+	TxMaxRetries        int `yaml:"tx_max_retries" json:"tx_max_retries"`                   // negative never retries
+	TxRetryBackoffMs    int `yaml:"tx_retry_backoff_ms" json:"tx_retry_backoff_ms"`         // before the first retry, doubled on every retry
+	TxRetryMaxBackoffMs int `yaml:"tx_retry_max_backoff_ms" json:"tx_retry_max_backoff_ms"` // cap of the backoff
+	// replicas of the primary serving the balance and history reads, with its user, password and dbname
+	Replicas         []DbReplicaConf `yaml:"replicas" json:"replicas"`
+	MaxStalenessMs   int             `yaml:"max_staleness_ms" json:"max_staleness_ms"`       // replicas lagging more serve no reads, the default applies when 0
+	ReadYourWritesMs int             `yaml:"read_your_writes_ms" json:"read_your_writes_ms"` // a user reads from the primary this long after a write, 0 never
+	// shards 1..n of the wallets and transactions, the primary is shard 0. Same user and password as the primary
+	Shards []DbShardConf `yaml:"shards" json:"shards"`
+	// transactions older than this many days are moved to transactions_archive by the archive job, 0 never
+	ArchiveRetentionDays int `yaml:"archive_retention_days" json:"archive_retention_days"`
+}
+
+type DbReplicaConf struct {
+	Host string `yaml:"host" json:"host"`
+	Port int    `yaml:"port" json:"port"`
+	DSN  string `yaml:"dsn" json:"dsn"` // instead of host and port
+}
+
+type DbShardConf struct {
+	Host   string `yaml:"host" json:"host"`
+	Port   int    `yaml:"port" json:"port"`
+	DbName string `yaml:"dbname" json:"dbname"` // the dbname of the primary when empty
+	DSN    string `yaml:"dsn" json:"dsn"`       // instead of host, port and dbname
+}
+
+const (
+	DefaultQueryTimeoutMs = 3000
+	DefaultExecTimeoutMs  = 3000
+	DefaultTxTimeoutMs    = 10000
+
+	DefaultTxMaxRetries        = 3
+	DefaultTxRetryBackoffMs    = 10
+	DefaultTxRetryMaxBackoffMs = 200
+
+	DefaultMaxStalenessMs = 1000
+)
+
+var isolationLevels = map[string]sql.IsolationLevel{
+	"":                sql.LevelDefault,
+	"read_committed":  sql.LevelReadCommitted,
+	"repeatable_read": sql.LevelRepeatableRead,
+	"serializable":    sql.LevelSerializable,
 }
 
 var dbCli *sql.DB
+var replicaPool *ReplicaPool
+var shardClis []*sql.DB
+var dbDriver string
+var queryTimeout, execTimeout, txTimeout time.Duration
+var isolation sql.IsolationLevel
+var txMaxRetries int
+var txRetryBackoff, txRetryMaxBackoff time.Duration
+var archiveRetention time.Duration
 
 func InitDb(conf *DbConf) error {
 	if conf == nil {
 		return errors.New("db config is nil")
 	}
+	queryTimeout = timeoutOrDefault(conf.QueryTimeoutMs, DefaultQueryTimeoutMs)
+	execTimeout = timeoutOrDefault(conf.ExecTimeoutMs, DefaultExecTimeoutMs)
+	txTimeout = timeoutOrDefault(conf.TxTimeoutMs, DefaultTxTimeoutMs)
+	level, ok := isolationLevels[conf.Isolation]
+	if !ok {
+		return fmt.Errorf("unknown db isolation level %q", conf.Isolation)
+	}
+	isolation = level
+	txMaxRetries = conf.TxMaxRetries
+	if txMaxRetries == 0 {
+		txMaxRetries = DefaultTxMaxRetries
+	} else if txMaxRetries < 0 {
+		txMaxRetries = 0
+	}
+	txRetryBackoff = timeoutOrDefault(conf.TxRetryBackoffMs, DefaultTxRetryBackoffMs)
+	txRetryMaxBackoff = timeoutOrDefault(conf.TxRetryMaxBackoffMs, DefaultTxRetryMaxBackoffMs)
+	if conf.ArchiveRetentionDays < 0 {
+		return errors.New("db archive_retention_days can not be negative")
+	}
+	archiveRetention = time.Duration(conf.ArchiveRetentionDays) * 24 * time.Hour
+	retry := newConnectRetry(conf.ConnectTimeoutMs, conf.ConnectRetries, conf.ConnectRetryBackoffMs)
 	var err error
-	connectStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable", conf.Host, conf.Port, conf.User, conf.Password, conf.DbName)
-	dbCli, err = sql.Open("postgres", connectStr)
+	replicaPool = nil
+	if conf.Driver == DriverSqlite {
+		if len(conf.Replicas) > 0 || len(conf.Shards) > 0 {
+			return errors.New("db replicas and shards need the postgres driver")
+		}
+		dbCli, err = OpenSqlite(conf.Path)
+		if err != nil {
+			return err
+		}
+		configurePool(dbCli, conf)
+		dbDriver = DriverSqlite
+		shardClis = []*sql.DB{dbCli}
+		if err = retry.ping("sqlite "+conf.Path, dbCli.PingContext); err != nil {
+			closeClients()
+			return err
+		}
+		return nil
+	}
+	dbCli, err = openPostgres(conf, conf.DSN, conf.Host, conf.Port, conf.DbName)
 	if err != nil {
-		log.Println("fail to connect DB:" + err.Error())
 		return err
 	}
+	shardClis = []*sql.DB{dbCli}
+	if err = retry.ping("db", dbCli.PingContext); err != nil {
+		closeClients()
+		return err
+	}
+	dbDriver = DriverPostgres
+	if len(conf.Replicas) > 0 {
+		replicas := make([]*sql.DB, 0, len(conf.Replicas))
+		for _, replicaConf := range conf.Replicas {
+			replica, err := openPostgres(conf, replicaConf.DSN, replicaConf.Host, replicaConf.Port, conf.DbName)
+			if err != nil {
+				NewReplicaPool(replicas, 0, 0).Close()
+				closeClients()
+				return err
+			}
+			// a replica down on start serves no reads until it is back, the primary serves them meanwhile
+			if err = (connectRetry{timeout: retry.timeout}).ping("db replica", replica.PingContext); err != nil {
+				log.Println(err.Error())
+			}
+			replicas = append(replicas, replica)
+		}
+		replicaPool = NewReplicaPool(replicas, timeoutOrDefault(conf.MaxStalenessMs, DefaultMaxStalenessMs), time.Duration(conf.ReadYourWritesMs)*time.Millisecond)
+	}
+	for _, shardConf := range conf.Shards {
+		dbName := shardConf.DbName
+		if dbName == "" {
+			dbName = conf.DbName
+		}
+		shard, err := openPostgres(conf, shardConf.DSN, shardConf.Host, shardConf.Port, dbName)
+		if err != nil {
+			closeClients()
+			return err
+		}
+		shardClis = append(shardClis, shard)
+		if err = retry.ping(fmt.Sprintf("db shard %d", len(shardClis)-1), shard.PingContext); err != nil {
+			closeClients()
+			return err
+		}
+	}
 	return nil
 }
 
+// closeClients closes the db clients opened by a failed InitDb
+func closeClients() {
+	for _, cli := range shardClis {
+		cli.Close()
+	}
+	replicaPool.Close()
+	dbCli, replicaPool, shardClis = nil, nil, nil
+}
+
+// openPostgres opens the postgres db of the DSN, or at host and port with the settings of the config without one
+func openPostgres(conf *DbConf, dsn string, host string, port int, dbName string) (*sql.DB, error) {
+	if dsn == "" {
+		dsn = conf.PostgresDSN(host, port, dbName)
+	}
+	dsn, err := normalizeDSN(dsn)
+	if err != nil {
+		log.Println("fail to parse DB url:" + err.Error())
+		return nil, err
+	}
+	cli, err := sql.Open(DriverPostgres, dsn)
+	if err != nil {
+		log.Println("fail to connect DB:" + err.Error())
+		return nil, err
+	}
+	configurePool(cli, conf)
+	return cli, nil
+}
+
 func GetDbClient() *sql.DB {
 	return dbCli
 }
+
+// GetReplicaPool returns the replicas of the db client, nil without replicas
+func GetReplicaPool() *ReplicaPool {
+	return replicaPool
+}
+
+// GetShardClients returns the db clients of the shards, the primary first. Only the primary without shards
+func GetShardClients() []*sql.DB {
+	return shardClis
+}
+
+// GetDbDriver returns the driver of the db client, postgres or sqlite
+func GetDbDriver() string {
+	return dbDriver
+}
+
+// GetDbTimeouts returns the timeouts of one read, one write and one transaction, 0 before InitDb
+func GetDbTimeouts() (query time.Duration, exec time.Duration, tx time.Duration) {
+	return queryTimeout, execTimeout, txTimeout
+}
+
+// GetDbIsolation returns the isolation level of the wallet transactions, sql.LevelDefault before InitDb
+func GetDbIsolation() sql.IsolationLevel {
+	return isolation
+}
+
+// GetDbTxRetry returns how often and after which backoff an aborted transaction is retried, no retry before InitDb
+func GetDbTxRetry() (maxRetries int, backoff time.Duration, maxBackoff time.Duration) {
+	return txMaxRetries, txRetryBackoff, txRetryMaxBackoff
+}
+
+// GetArchiveRetention returns how old the transactions moved to the archive are, 0 when they never are
+func GetArchiveRetention() time.Duration {
+	return archiveRetention
+}
+
+func timeoutOrDefault(ms int, defaultMs int) time.Duration {
+	if ms <= 0 {
+		ms = defaultMs
+	}
+	return time.Duration(ms) * time.Millisecond
+}
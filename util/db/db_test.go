@@ -1,8 +1,10 @@
 package db_test
 
 import (
+	"path/filepath"
 	"simplewallet/util/db"
 	"testing"
+	"time"
 
 	_ "github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
@@ -18,4 +20,36 @@ func TestInitDb(t *testing.T) {
 		assert.NotNil(t, cli)
 		cli.Close()
 	})
+
+	t.Run("case2: postgres dsn success-[ssl settings, values quoted]", func(t *testing.T) {
+		conf := &db.DbConf{User: "wallet", Password: "it's a secret"}
+		assert.Equal(t, "host=127.0.0.1 port=5432 user=wallet password='it\\'s a secret' dbname=wallet sslmode=disable",
+			conf.PostgresDSN("127.0.0.1", 5432, "wallet"))
+		conf = &db.DbConf{User: "wallet", SslMode: "verify-full", SslRootCert: "/etc/ssl/ca.pem", SslCert: "/etc/ssl/client.pem", SslKey: "/etc/ssl/client.key"}
+		assert.Equal(t, "host=10.0.0.2 port=5433 user=wallet password='' dbname=wallet_1 sslmode=verify-full sslrootcert=/etc/ssl/ca.pem sslcert=/etc/ssl/client.pem sslkey=/etc/ssl/client.key",
+			conf.PostgresDSN("10.0.0.2", 5433, "wallet_1"))
+	})
+
+	t.Run("case3: init db success-[sqlite pinged, pool settings]", func(t *testing.T) {
+		err := db.InitDb(&db.DbConf{Driver: db.DriverSqlite, Path: filepath.Join(t.TempDir(), "wallet.db"), MaxOpenConns: 4, MaxIdleConns: 2, ConnMaxLifetimeMs: 60000})
+		assert.Nil(t, err)
+		cli := db.GetDbClient()
+		assert.Equal(t, 4, cli.Stats().MaxOpenConnections)
+		cli.Close()
+	})
+
+	t.Run("case4: init db fail-[unreachable postgres after the retries]", func(t *testing.T) {
+		start := time.Now()
+		err := db.InitDb(&db.DbConf{Host: "127.0.0.1", Port: 1, User: "wallet", DbName: "wallet", ConnectTimeoutMs: 500, ConnectRetries: 2, ConnectRetryBackoffMs: 10})
+		assert.ErrorContains(t, err, "fail to connect db")
+		assert.GreaterOrEqual(t, time.Since(start), 30*time.Millisecond, "backoff 10ms then 20ms")
+		assert.Nil(t, db.GetDbClient())
+	})
+
+	t.Run("case5: init db fail-[bad postgres url]", func(t *testing.T) {
+		err := db.InitDb(&db.DbConf{DSN: "postgres://wallet@127.0.0.1:1/wallet?sslmode=disable", ConnectTimeoutMs: 500, ConnectRetries: -1})
+		assert.ErrorContains(t, err, "fail to connect db")
+		err = db.InitDb(&db.DbConf{DSN: "postgres://%zz", ConnectRetries: -1})
+		assert.NotNil(t, err)
+	})
 }
@@ -0,0 +1,267 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrations/<driver>/<version>_<name>.up.sql and .down.sql, versions are applied in order
+//
+//go:embed migrations
+var migrationFS embed.FS
+
+// migrateLockKey is the postgres advisory lock held while migrating, so replicas starting
+// together apply every migration once.
+const migrateLockKey int64 = 7_270_135_033
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	AppliedAt int64 // unix second, 0 while pending
+}
+
+// Migrator applies the migrations embedded for the driver and records them in schema_migrations.
+type Migrator struct {
+	dbCli      *sql.DB
+	driver     string
+	migrations []*Migration
+}
+
+func NewMigrator(dbCli *sql.DB, driver string) (*Migrator, error) {
+	if driver == "" {
+		driver = DriverPostgres
+	}
+	migrations, err := loadMigrations(driver)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{dbCli: dbCli, driver: driver, migrations: migrations}, nil
+}
+
+func loadMigrations(driver string) ([]*Migration, error) {
+	dir := path.Join("migrations", driver)
+	entries, err := fs.ReadDir(migrationFS, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for db driver %s", driver)
+	}
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		fileName := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(fileName, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(fileName, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+		base := strings.TrimSuffix(fileName, "."+direction+".sql")
+		versionStr, name, ok := strings.Cut(base, "_")
+		version, err := strconv.ParseInt(versionStr, 10, 64)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("bad migration file name %s", fileName)
+		}
+		content, err := fs.ReadFile(migrationFS, path.Join(dir, fileName))
+		if err != nil {
+			return nil, err
+		}
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if m.Name != name {
+			return nil, fmt.Errorf("migration %d has two names %s and %s", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both up and down files", m.Version, m.Name)
+		}
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Up applies every pending migration, returns the applied ones.
+func (m *Migrator) Up(ctx context.Context) ([]*Migration, error) {
+	var applied []*Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		done, err := m.appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if _, ok := done[migration.Version]; ok {
+				continue
+			}
+			ok, err := m.apply(ctx, conn, migration, true)
+			if err != nil {
+				return err
+			}
+			if ok {
+				applied = append(applied, migration)
+			}
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts the last n applied migrations, newest first, returns the reverted ones.
+func (m *Migrator) Down(ctx context.Context, n int) ([]*Migration, error) {
+	var reverted []*Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		done, err := m.appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && len(reverted) < n; i-- {
+			migration := m.migrations[i]
+			if _, ok := done[migration.Version]; !ok {
+				continue
+			}
+			ok, err := m.apply(ctx, conn, migration, false)
+			if err != nil {
+				return err
+			}
+			if ok {
+				reverted = append(reverted, migration)
+			}
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Status returns every migration of the binary, with when it was applied.
+func (m *Migrator) Status(ctx context.Context) ([]*MigrationStatus, error) {
+	conn, err := m.dbCli.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	err = createMigrationTable(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	done, err := m.appliedVersions(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		list = append(list, &MigrationStatus{Version: migration.Version, Name: migration.Name, AppliedAt: done[migration.Version]})
+	}
+	return list, nil
+}
+
+// withLock runs fn on one connection holding the migrate lock. sqlite has no advisory lock,
+// its migrations are kept apart by the immediate transactions and the version check in apply.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.dbCli.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if m.driver == DriverPostgres {
+		_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrateLockKey)
+		if err != nil {
+			log.Println("fail to take migrate lock:" + err.Error())
+			return err
+		}
+		defer func() {
+			_, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrateLockKey)
+			if err != nil {
+				log.Println("fail to release migrate lock:" + err.Error())
+			}
+		}()
+	}
+	err = createMigrationTable(ctx, conn)
+	if err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func createMigrationTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS schema_migrations (version BIGINT PRIMARY KEY, name VARCHAR(128) NOT NULL DEFAULT '', applied_at INTEGER NOT NULL DEFAULT 0)")
+	if err != nil {
+		log.Println("fail to create schema_migrations:" + err.Error())
+	}
+	return err
+}
+
+// appliedVersions returns version -> applied_at of the applied migrations
+func (m *Migrator) appliedVersions(ctx context.Context, conn *sql.Conn) (map[int64]int64, error) {
+	done := make(map[int64]int64)
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version, appliedAt int64
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		done[version] = appliedAt
+	}
+	return done, rows.Err()
+}
+
+// apply runs one migration up or down and its schema_migrations row in one transaction,
+// a failed migration leaves nothing behind. It is skipped, returning false, if another process got there first.
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, migration *Migration, up bool) (bool, error) {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	var count int
+	err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM schema_migrations WHERE version = $1", migration.Version).Scan(&count)
+	if err != nil {
+		_ = tx.Rollback()
+		return false, err
+	}
+	applied := count > 0
+	if applied == up {
+		return false, tx.Rollback()
+	}
+	script, record, args := migration.Down, "DELETE FROM schema_migrations WHERE version = $1", []any{migration.Version}
+	if up {
+		script, record, args = migration.Up, "INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)", []any{migration.Version, migration.Name, time.Now().Unix()}
+	}
+	_, err = tx.ExecContext(ctx, script)
+	if err == nil {
+		_, err = tx.ExecContext(ctx, record, args...)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return false, fmt.Errorf("migration %d_%s failed: %w", migration.Version, migration.Name, err)
+	}
+	return true, tx.Commit()
+}
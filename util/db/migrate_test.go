@@ -0,0 +1,209 @@
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"regexp"
+	"simplewallet/util/db"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+func TestMigrator(t *testing.T) {
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	ctx := context.Background()
+
+	t.Run("case1: up, down and status success-[sqlite]", func(t *testing.T) {
+		dbCli, err := db.OpenSqlite(filepath.Join(t.TempDir(), "wallet.db"))
+		assert.Nil(t, err)
+		defer dbCli.Close()
+		migrator, err := db.NewMigrator(dbCli, db.DriverSqlite)
+		assert.Nil(t, err)
+
+		list, err := migrator.Status(ctx)
+		assert.Nil(t, err)
+		assert.NotEmpty(t, list)
+		for _, m := range list {
+			assert.Zero(t, m.AppliedAt)
+		}
+		applied, err := migrator.Up(ctx)
+		assert.Nil(t, err)
+		assert.Len(t, applied, len(list))
+		_, err = dbCli.Exec("INSERT INTO wallets (user_id, balance) VALUES (101, 1.5)")
+		assert.Nil(t, err)
+		applied, err = migrator.Up(ctx)
+		assert.Nil(t, err)
+		assert.Empty(t, applied)
+		list, err = migrator.Status(ctx)
+		assert.Nil(t, err)
+		for _, m := range list {
+			assert.NotZero(t, m.AppliedAt)
+		}
+
+		reverted, err := migrator.Down(ctx, len(list))
+		assert.Nil(t, err)
+		assert.Len(t, reverted, len(list))
+		assert.Equal(t, list[0].Version, reverted[len(reverted)-1].Version)
+		_, err = dbCli.Exec("SELECT COUNT(*) FROM wallets")
+		assert.NotNil(t, err)
+		reverted, err = migrator.Down(ctx, 1)
+		assert.Nil(t, err)
+		assert.Empty(t, reverted)
+	})
+
+	t.Run("case2: up success-[transactions without wallet_id backfilled with the main pocket of their user]", func(t *testing.T) {
+		dbCli, err := db.OpenSqlite(filepath.Join(t.TempDir(), "wallet.db"))
+		require.Nil(t, err)
+		defer dbCli.Close()
+		migrator, err := db.NewMigrator(dbCli, db.DriverSqlite)
+		require.Nil(t, err)
+		_, err = migrator.Up(ctx)
+		require.Nil(t, err)
+		// back to before the backfill of 0011, 0012 is applied over it
+		_, err = migrator.Down(ctx, 2)
+		require.Nil(t, err)
+		for _, stmt := range []string{
+			"INSERT INTO wallets (id, user_id, name, balance) VALUES (1, 101, 'main', 10), (2, 101, 'savings', 0), (3, 102, 'main', 5)",
+			"INSERT INTO transactions (order_id, user_id, wallet_id, tx_type, amount) VALUES ('1001', 101, 0, 1, 10), ('1002', 101, 2, 6, 1), ('1003', 103, 0, 1, 1)",
+			"INSERT INTO transactions_archive (id, order_id, user_id, wallet_id, tx_type, amount) VALUES (100, '1000', 102, 0, 1, 5)",
+		} {
+			_, err = dbCli.Exec(stmt)
+			require.Nil(t, err)
+		}
+		applied, err := migrator.Up(ctx)
+		require.Nil(t, err)
+		require.Len(t, applied, 2)
+
+		for orderID, walletID := range map[string]int64{"1001": 1, "1002": 2, "1003": 0} {
+			var got int64
+			require.Nil(t, dbCli.QueryRow("SELECT wallet_id FROM transactions WHERE order_id = ?", orderID).Scan(&got))
+			assert.Equal(t, walletID, got, orderID)
+		}
+		var got int64
+		require.Nil(t, dbCli.QueryRow("SELECT wallet_id FROM transactions_archive WHERE order_id = '1000'").Scan(&got))
+		assert.Equal(t, int64(3), got)
+	})
+
+	t.Run("case3: up success-[replicas migrating together apply each migration once]", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "wallet.db")
+		var wg sync.WaitGroup
+		total := make([]int, 3)
+		for i := range total {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				dbCli, err := db.OpenSqlite(path)
+				assert.Nil(t, err)
+				defer dbCli.Close()
+				migrator, err := db.NewMigrator(dbCli, db.DriverSqlite)
+				assert.Nil(t, err)
+				applied, err := migrator.Up(ctx)
+				assert.Nil(t, err)
+				total[i] = len(applied)
+			}(i)
+		}
+		wg.Wait()
+
+		dbCli, err := db.OpenSqlite(path)
+		assert.Nil(t, err)
+		defer dbCli.Close()
+		var count int
+		err = dbCli.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&count)
+		assert.Nil(t, err)
+		assert.Equal(t, count, total[0]+total[1]+total[2])
+	})
+
+	t.Run("case4: up success-[postgres takes the advisory lock, nothing pending]", func(t *testing.T) {
+		dbCli, mock, err := sqlmock.New()
+		assert.Nil(t, err)
+		defer dbCli.Close()
+		migrator, err := db.NewMigrator(dbCli, db.DriverPostgres)
+		assert.Nil(t, err)
+
+		mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_lock($1)")).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE IF NOT EXISTS schema_migrations")).WillReturnResult(sqlmock.NewResult(0, 0))
+		rows := sqlmock.NewRows([]string{"version", "applied_at"})
+		for version := 1; version < 1000; version++ { // every version applied
+			rows.AddRow(version, 1700000000)
+		}
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT version, applied_at FROM schema_migrations")).WillReturnRows(rows)
+		mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_unlock($1)")).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		applied, err := migrator.Up(ctx)
+		assert.Nil(t, err)
+		assert.Empty(t, applied)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("case5: up success-[postgres database created from the old schema.sql adopted]", func(t *testing.T) {
+		dsn := os.Getenv("WALLET_TEST_PG_DSN")
+		if dsn == "" {
+			t.Skip("WALLET_TEST_PG_DSN not set")
+		}
+		// a schema of its own, the conformance suite truncates the tables of the default one
+		adminCli, err := sql.Open("postgres", dsn)
+		require.Nil(t, err)
+		defer adminCli.Close()
+		_, err = adminCli.Exec("DROP SCHEMA IF EXISTS wallet_baseline CASCADE; CREATE SCHEMA wallet_baseline")
+		require.Nil(t, err)
+		defer adminCli.Exec("DROP SCHEMA IF EXISTS wallet_baseline CASCADE")
+		sep := " "
+		if strings.Contains(dsn, "://") {
+			sep = "?"
+			if strings.Contains(dsn, "?") {
+				sep = "&"
+			}
+		}
+		dbCli, err := sql.Open("postgres", dsn+sep+"search_path=wallet_baseline")
+		require.Nil(t, err)
+		defer dbCli.Close()
+
+		baseline, err := os.ReadFile(filepath.Join("testdata", "baseline_schema.sql"))
+		require.Nil(t, err)
+		_, err = dbCli.Exec(string(baseline))
+		require.Nil(t, err)
+		_, err = dbCli.Exec("INSERT INTO wallets (user_id, balance, created_at, updated_at) VALUES (101, 7.5, 1700000000, 1700000000), (102, 2.5, 1700000000, 1700000000)")
+		require.Nil(t, err)
+		_, err = dbCli.Exec("INSERT INTO transactions (order_id, user_id, tx_type, amount, related_user_id, created_at, updated_at) VALUES " +
+			"('1001', 101, 1, 10, 0, 1700000000, 1700000000), ('1002', 101, 4, 2.5, 102, 1700000000, 1700000000), ('1002', 102, 3, 2.5, 101, 1700000000, 1700000000)")
+		require.Nil(t, err)
+
+		migrator, err := db.NewMigrator(dbCli, db.DriverPostgres)
+		require.Nil(t, err)
+		_, err = migrator.Up(ctx)
+		require.Nil(t, err)
+
+		var name string
+		var balance float64
+		require.Nil(t, dbCli.QueryRow("SELECT name, balance FROM wallets WHERE org_id = 0 AND user_id = 101").Scan(&name, &balance))
+		assert.Equal(t, "main", name)
+		assert.Equal(t, 7.5, balance)
+		rows, err := dbCli.Query("SELECT t.user_id, t.actor_user_id, w.user_id FROM transactions t LEFT JOIN wallets w ON w.id = t.wallet_id ORDER BY t.id")
+		require.Nil(t, err)
+		defer rows.Close()
+		actors := make([][3]int64, 0)
+		for rows.Next() {
+			var userID, actorUserID int64
+			var walletUserID sql.NullInt64
+			require.Nil(t, rows.Scan(&userID, &actorUserID, &walletUserID))
+			actors = append(actors, [3]int64{userID, actorUserID, walletUserID.Int64})
+		}
+		require.Nil(t, rows.Err())
+		// every transaction on the wallet of its user, the transfer in made by its sender
+		assert.Equal(t, [][3]int64{{101, 101, 101}, {101, 101, 101}, {102, 101, 102}}, actors)
+	})
+
+	t.Run("case6: new migrator fail-[no migrations for the driver]", func(t *testing.T) {
+		_, err := db.NewMigrator(nil, "mysql")
+		assert.NotNil(t, err)
+	})
+}
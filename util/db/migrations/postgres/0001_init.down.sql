@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS approval_votes;
+DROP TABLE IF EXISTS transfer_approvals;
+DROP TABLE IF EXISTS org_members;
+DROP TABLE IF EXISTS organizations;
+DROP TABLE IF EXISTS interest_accruals;
+DROP TABLE IF EXISTS transactions;
+DROP TABLE IF EXISTS wallets;
@@ -0,0 +1,169 @@
+-- baseline schema. Every statement is idempotent, so a database created by hand from the old schema.sql adopts it:
+-- its wallets and transactions tables are kept, the columns and constraints they lack are added, then backfilled.
+-- Wallets table
+CREATE TABLE IF NOT EXISTS wallets (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL DEFAULT 0,
+    org_id INTEGER NOT NULL DEFAULT 0,
+    name VARCHAR(32) NOT NULL DEFAULT 'main',
+    balance DECIMAL(15, 8) NOT NULL DEFAULT 0.00000000,
+    credit_limit DECIMAL(15, 8) NOT NULL DEFAULT 0.00000000,
+    held DECIMAL(15, 8) NOT NULL DEFAULT 0.00000000,
+    product VARCHAR(32) NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL DEFAULT 0,
+    CONSTRAINT chk_wallets_credit_limit CHECK (credit_limit >= 0),
+    CONSTRAINT chk_wallets_balance CHECK (balance >= -credit_limit),
+    CONSTRAINT chk_wallets_held CHECK (held >= 0 AND balance - held >= -credit_limit),
+    CONSTRAINT uk_wallets_owner_name UNIQUE (org_id, user_id, name)
+);
+-- the old schema.sql had user_id, balance, created_at and updated_at only
+ALTER TABLE wallets ADD COLUMN IF NOT EXISTS org_id INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE wallets ADD COLUMN IF NOT EXISTS name VARCHAR(32) NOT NULL DEFAULT 'main';
+ALTER TABLE wallets ADD COLUMN IF NOT EXISTS credit_limit DECIMAL(15, 8) NOT NULL DEFAULT 0.00000000;
+ALTER TABLE wallets ADD COLUMN IF NOT EXISTS held DECIMAL(15, 8) NOT NULL DEFAULT 0.00000000;
+ALTER TABLE wallets ADD COLUMN IF NOT EXISTS product VARCHAR(32) NOT NULL DEFAULT '';
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conrelid = 'wallets'::regclass AND conname = 'chk_wallets_credit_limit') THEN
+        ALTER TABLE wallets ADD CONSTRAINT chk_wallets_credit_limit CHECK (credit_limit >= 0);
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conrelid = 'wallets'::regclass AND conname = 'chk_wallets_balance') THEN
+        ALTER TABLE wallets ADD CONSTRAINT chk_wallets_balance CHECK (balance >= -credit_limit);
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conrelid = 'wallets'::regclass AND conname = 'chk_wallets_held') THEN
+        ALTER TABLE wallets ADD CONSTRAINT chk_wallets_held CHECK (held >= 0 AND balance - held >= -credit_limit);
+    END IF;
+    -- fails on a user with two wallet rows, they have to be merged by hand first
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conrelid = 'wallets'::regclass AND conname = 'uk_wallets_owner_name') THEN
+        ALTER TABLE wallets ADD CONSTRAINT uk_wallets_owner_name UNIQUE (org_id, user_id, name);
+    END IF;
+END $$;
+COMMENT ON TABLE wallets IS 'user wallets table';
+COMMENT ON COLUMN wallets.user_id IS 'user id';
+COMMENT ON COLUMN wallets.org_id IS 'organization id of shared wallets, user_id is 0 for them';
+COMMENT ON COLUMN wallets.name IS 'pocket name, every user has a main pocket';
+COMMENT ON COLUMN wallets.balance IS 'user wallet balance amount, may be negative down to -credit_limit';
+COMMENT ON COLUMN wallets.credit_limit IS 'overdraft credit limit of the wallet';
+COMMENT ON COLUMN wallets.held IS 'amount held by transfers pending approval, not available for debits';
+COMMENT ON COLUMN wallets.product IS 'wallet product, decides the interest rate';
+CREATE INDEX IF NOT EXISTS idx_wallets_user_id ON wallets(user_id);
+CREATE INDEX IF NOT EXISTS idx_wallets_org_id ON wallets(org_id);
+
+-- transactions table
+CREATE TABLE IF NOT EXISTS transactions (
+    id SERIAL PRIMARY KEY,
+    order_id VARCHAR(64) NOT NULL DEFAULT '',
+    user_id INTEGER NOT NULL DEFAULT 0,
+    wallet_id INTEGER NOT NULL DEFAULT 0,
+    tx_type INTEGER NOT NULL DEFAULT 0,
+    amount DECIMAL(15, 8) NOT NULL DEFAULT 0.00000000,
+    related_user_id INTEGER NOT NULL DEFAULT 0,
+    actor_user_id INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL DEFAULT 0
+);
+-- the old schema.sql had no wallet_id nor actor_user_id: every transaction was on the wallet of its user,
+-- made by its user, or by the sender for a transfer in
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS wallet_id INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS actor_user_id INTEGER NOT NULL DEFAULT 0;
+UPDATE transactions t SET wallet_id = w.id FROM wallets w
+    WHERE t.wallet_id = 0 AND w.user_id = t.user_id AND w.org_id = 0 AND w.name = 'main';
+UPDATE transactions SET actor_user_id = CASE WHEN tx_type = 3 THEN related_user_id ELSE user_id END WHERE actor_user_id = 0;
+COMMENT ON TABLE transactions IS 'user transactions log table';
+COMMENT ON COLUMN transactions.order_id IS 'order id';
+COMMENT ON COLUMN transactions.user_id IS 'user id';
+COMMENT ON COLUMN transactions.wallet_id IS 'wallet(pocket) id';
+COMMENT ON COLUMN transactions.tx_type IS '0: undefine 1: deposit, 2: withdrawal, 3: transfer in, 4: transfer out, 5: interest, 6: pocket move in, 7: pocket move out';
+COMMENT ON COLUMN transactions.amount IS 'transaction amount';
+COMMENT ON COLUMN transactions.related_user_id IS 'related user id';
+COMMENT ON COLUMN transactions.actor_user_id IS 'user who made the transaction, differs from user_id on shared wallets';
+CREATE INDEX IF NOT EXISTS idx_transactions_order_id ON transactions(order_id);
+CREATE INDEX IF NOT EXISTS idx_transactions_user_id ON transactions(user_id);
+CREATE INDEX IF NOT EXISTS idx_transactions_wallet_id ON transactions(wallet_id);
+CREATE INDEX IF NOT EXISTS idx_transactions_actor_user_id ON transactions(actor_user_id, wallet_id);
+CREATE INDEX IF NOT EXISTS idx_transactions_related_user_id ON transactions(related_user_id);
+
+-- interest accruals table
+CREATE TABLE IF NOT EXISTS interest_accruals (
+    id SERIAL PRIMARY KEY,
+    wallet_id INTEGER NOT NULL DEFAULT 0,
+    user_id INTEGER NOT NULL DEFAULT 0,
+    accrual_date INTEGER NOT NULL DEFAULT 0,
+    balance DECIMAL(15, 8) NOT NULL DEFAULT 0.00000000,
+    rate DECIMAL(10, 8) NOT NULL DEFAULT 0.00000000,
+    amount DECIMAL(15, 8) NOT NULL DEFAULT 0.00000000,
+    payout_order_id VARCHAR(64) NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL DEFAULT 0,
+    CONSTRAINT uk_interest_accruals_wallet_date UNIQUE (wallet_id, accrual_date)
+);
+COMMENT ON TABLE interest_accruals IS 'daily interest accrual of wallets';
+COMMENT ON COLUMN interest_accruals.accrual_date IS 'accrual day, yyyymmdd';
+COMMENT ON COLUMN interest_accruals.balance IS 'end-of-day balance computed from transactions';
+COMMENT ON COLUMN interest_accruals.rate IS 'annual interest rate';
+COMMENT ON COLUMN interest_accruals.amount IS 'interest accrued for the day';
+COMMENT ON COLUMN interest_accruals.payout_order_id IS 'order id of the interest payout, empty until paid';
+CREATE INDEX IF NOT EXISTS idx_interest_accruals_user_id ON interest_accruals(user_id, accrual_date);
+CREATE INDEX IF NOT EXISTS idx_interest_accruals_wallet_id ON interest_accruals(wallet_id, accrual_date);
+
+-- organizations table
+CREATE TABLE IF NOT EXISTS organizations (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(64) NOT NULL DEFAULT '',
+    required_approvals INTEGER NOT NULL DEFAULT 1,
+    approval_expire_second INTEGER NOT NULL DEFAULT 86400,
+    created_at INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL DEFAULT 0
+);
+COMMENT ON TABLE organizations IS 'organizations owning shared wallets';
+COMMENT ON COLUMN organizations.required_approvals IS 'approvals of other members needed by a transfer above the spending limit';
+COMMENT ON COLUMN organizations.approval_expire_second IS 'seconds a transfer waits for approvals before its hold is released';
+
+-- organization members table
+CREATE TABLE IF NOT EXISTS org_members (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL DEFAULT 0,
+    user_id INTEGER NOT NULL DEFAULT 0,
+    role VARCHAR(16) NOT NULL DEFAULT 'viewer',
+    spending_limit DECIMAL(15, 8) NOT NULL DEFAULT 0.00000000,
+    created_at INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL DEFAULT 0,
+    CONSTRAINT uk_org_members_org_user UNIQUE (org_id, user_id)
+);
+COMMENT ON TABLE org_members IS 'members of organizations';
+COMMENT ON COLUMN org_members.role IS 'owner, spender or viewer';
+COMMENT ON COLUMN org_members.spending_limit IS 'daily amount a spender may debit from the shared wallets';
+CREATE INDEX IF NOT EXISTS idx_org_members_user_id ON org_members(user_id);
+
+-- transfer approvals table
+CREATE TABLE IF NOT EXISTS transfer_approvals (
+    id SERIAL PRIMARY KEY,
+    order_id VARCHAR(64) NOT NULL DEFAULT '',
+    org_id INTEGER NOT NULL DEFAULT 0,
+    wallet_id INTEGER NOT NULL DEFAULT 0,
+    requester_user_id INTEGER NOT NULL DEFAULT 0,
+    to_user_id INTEGER NOT NULL DEFAULT 0,
+    amount DECIMAL(15, 8) NOT NULL DEFAULT 0.00000000,
+    required_approvals INTEGER NOT NULL DEFAULT 1,
+    status SMALLINT NOT NULL DEFAULT 0,
+    expire_at INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL DEFAULT 0,
+    CONSTRAINT uk_transfer_approvals_order_id UNIQUE (order_id)
+);
+COMMENT ON TABLE transfer_approvals IS 'shared wallet transfers above the spending limit, waiting for approvals';
+COMMENT ON COLUMN transfer_approvals.order_id IS 'order id of the transfer, the transactions use it once executed';
+COMMENT ON COLUMN transfer_approvals.status IS '0: pending, 1: executed, 2: expired';
+CREATE INDEX IF NOT EXISTS idx_transfer_approvals_org_status ON transfer_approvals(org_id, status);
+CREATE INDEX IF NOT EXISTS idx_transfer_approvals_status_expire ON transfer_approvals(status, expire_at);
+
+-- approval votes table, the audit trail of approvals
+CREATE TABLE IF NOT EXISTS approval_votes (
+    id SERIAL PRIMARY KEY,
+    approval_id INTEGER NOT NULL DEFAULT 0,
+    approver_user_id INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL DEFAULT 0,
+    CONSTRAINT uk_approval_votes_approver UNIQUE (approval_id, approver_user_id)
+);
+COMMENT ON TABLE approval_votes IS 'who approved a pending transfer and when';
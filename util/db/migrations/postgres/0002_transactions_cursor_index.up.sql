@@ -0,0 +1,3 @@
+-- keyset pagination of the transaction history by (created_at, id), per user and per pocket
+CREATE INDEX IF NOT EXISTS idx_transactions_user_created ON transactions(user_id, created_at, id);
+CREATE INDEX IF NOT EXISTS idx_transactions_wallet_created ON transactions(wallet_id, created_at, id);
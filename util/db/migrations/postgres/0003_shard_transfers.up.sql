@@ -0,0 +1,17 @@
+-- transfers between users on different shards, kept on the shard of the sender
+CREATE TABLE IF NOT EXISTS shard_transfers (
+    id SERIAL PRIMARY KEY,
+    order_id VARCHAR(64) NOT NULL DEFAULT '',
+    from_user_id INTEGER NOT NULL DEFAULT 0,
+    from_wallet_id INTEGER NOT NULL DEFAULT 0,
+    to_user_id INTEGER NOT NULL DEFAULT 0,
+    amount DECIMAL(15, 8) NOT NULL DEFAULT 0.00000000,
+    status SMALLINT NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL DEFAULT 0,
+    CONSTRAINT uk_shard_transfers_order_id UNIQUE (order_id)
+);
+COMMENT ON TABLE shard_transfers IS 'transfers to a user on another shard, the sender is debited with the row in one transaction';
+COMMENT ON COLUMN shard_transfers.from_user_id IS 'user who made the transfer, the related user of the credit';
+COMMENT ON COLUMN shard_transfers.status IS '0: debited, the credit on the shard of to_user_id is pending, 1: credited';
+CREATE INDEX IF NOT EXISTS idx_shard_transfers_status ON shard_transfers(status, created_at);
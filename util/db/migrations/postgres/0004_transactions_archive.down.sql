@@ -0,0 +1,37 @@
+-- back to one unpartitioned transactions table, the archived transactions with it
+DROP TABLE IF EXISTS archive_watermarks;
+ALTER SEQUENCE transactions_id_seq OWNED BY NONE;
+CREATE TABLE transactions_unpartitioned (
+    id INTEGER NOT NULL DEFAULT nextval('transactions_id_seq') PRIMARY KEY,
+    order_id VARCHAR(64) NOT NULL DEFAULT '',
+    user_id INTEGER NOT NULL DEFAULT 0,
+    wallet_id INTEGER NOT NULL DEFAULT 0,
+    tx_type INTEGER NOT NULL DEFAULT 0,
+    amount DECIMAL(15, 8) NOT NULL DEFAULT 0.00000000,
+    related_user_id INTEGER NOT NULL DEFAULT 0,
+    actor_user_id INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL DEFAULT 0
+);
+INSERT INTO transactions_unpartitioned SELECT id, order_id, user_id, wallet_id, tx_type, amount, related_user_id, actor_user_id, created_at, updated_at FROM transactions_archive;
+INSERT INTO transactions_unpartitioned SELECT id, order_id, user_id, wallet_id, tx_type, amount, related_user_id, actor_user_id, created_at, updated_at FROM transactions;
+DROP TABLE transactions_archive;
+DROP TABLE transactions;
+ALTER TABLE transactions_unpartitioned RENAME TO transactions;
+ALTER INDEX transactions_unpartitioned_pkey RENAME TO transactions_pkey;
+ALTER SEQUENCE transactions_id_seq OWNED BY transactions.id;
+COMMENT ON TABLE transactions IS 'user transactions log table';
+COMMENT ON COLUMN transactions.order_id IS 'order id';
+COMMENT ON COLUMN transactions.user_id IS 'user id';
+COMMENT ON COLUMN transactions.wallet_id IS 'wallet(pocket) id';
+COMMENT ON COLUMN transactions.tx_type IS '0: undefine 1: deposit, 2: withdrawal, 3: transfer in, 4: transfer out, 5: interest, 6: pocket move in, 7: pocket move out';
+COMMENT ON COLUMN transactions.amount IS 'transaction amount';
+COMMENT ON COLUMN transactions.related_user_id IS 'related user id';
+COMMENT ON COLUMN transactions.actor_user_id IS 'user who made the transaction, differs from user_id on shared wallets';
+CREATE INDEX idx_transactions_order_id ON transactions(order_id);
+CREATE INDEX idx_transactions_user_id ON transactions(user_id);
+CREATE INDEX idx_transactions_wallet_id ON transactions(wallet_id);
+CREATE INDEX idx_transactions_actor_user_id ON transactions(actor_user_id, wallet_id);
+CREATE INDEX idx_transactions_related_user_id ON transactions(related_user_id);
+CREATE INDEX idx_transactions_user_created ON transactions(user_id, created_at, id);
+CREATE INDEX idx_transactions_wallet_created ON transactions(wallet_id, created_at, id);
@@ -0,0 +1,84 @@
+-- time partitioning of transactions by created_at, one partition per utc month named transactions_pYYYYMM.
+-- The archive job creates the partitions ahead of time, a row outside of them lands in transactions_default.
+-- A partitioned table keys by its partition column too, so the primary key becomes (id, created_at).
+DROP INDEX IF EXISTS idx_transactions_order_id;
+DROP INDEX IF EXISTS idx_transactions_user_id;
+DROP INDEX IF EXISTS idx_transactions_wallet_id;
+DROP INDEX IF EXISTS idx_transactions_actor_user_id;
+DROP INDEX IF EXISTS idx_transactions_related_user_id;
+DROP INDEX IF EXISTS idx_transactions_user_created;
+DROP INDEX IF EXISTS idx_transactions_wallet_created;
+ALTER TABLE transactions RENAME TO transactions_unpartitioned;
+ALTER TABLE transactions_unpartitioned RENAME CONSTRAINT transactions_pkey TO transactions_unpartitioned_pkey;
+ALTER SEQUENCE transactions_id_seq OWNED BY NONE;
+CREATE TABLE transactions (
+    id INTEGER NOT NULL DEFAULT nextval('transactions_id_seq'),
+    order_id VARCHAR(64) NOT NULL DEFAULT '',
+    user_id INTEGER NOT NULL DEFAULT 0,
+    wallet_id INTEGER NOT NULL DEFAULT 0,
+    tx_type INTEGER NOT NULL DEFAULT 0,
+    amount DECIMAL(15, 8) NOT NULL DEFAULT 0.00000000,
+    related_user_id INTEGER NOT NULL DEFAULT 0,
+    actor_user_id INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (id, created_at)
+) PARTITION BY RANGE (created_at);
+ALTER SEQUENCE transactions_id_seq OWNED BY transactions.id;
+COMMENT ON TABLE transactions IS 'user transactions log table, partitioned by month of created_at';
+COMMENT ON COLUMN transactions.order_id IS 'order id';
+COMMENT ON COLUMN transactions.user_id IS 'user id';
+COMMENT ON COLUMN transactions.wallet_id IS 'wallet(pocket) id';
+COMMENT ON COLUMN transactions.tx_type IS '0: undefine 1: deposit, 2: withdrawal, 3: transfer in, 4: transfer out, 5: interest, 6: pocket move in, 7: pocket move out';
+COMMENT ON COLUMN transactions.amount IS 'transaction amount';
+COMMENT ON COLUMN transactions.related_user_id IS 'related user id';
+COMMENT ON COLUMN transactions.actor_user_id IS 'user who made the transaction, differs from user_id on shared wallets';
+CREATE TABLE transactions_default PARTITION OF transactions DEFAULT;
+
+-- a partition per month from the oldest transaction to the next month
+DO $$
+DECLARE
+    m TIMESTAMP;
+    last TIMESTAMP := date_trunc('month', now() AT TIME ZONE 'UTC') + INTERVAL '2 month';
+BEGIN
+    SELECT date_trunc('month', to_timestamp(MIN(created_at)) AT TIME ZONE 'UTC') INTO m FROM transactions_unpartitioned WHERE created_at > 0;
+    m := COALESCE(m, last - INTERVAL '2 month');
+    WHILE m < last LOOP
+        EXECUTE format('CREATE TABLE %I PARTITION OF transactions FOR VALUES FROM (%s) TO (%s)',
+            'transactions_p' || to_char(m, 'YYYYMM'), EXTRACT(EPOCH FROM m)::BIGINT, EXTRACT(EPOCH FROM m + INTERVAL '1 month')::BIGINT);
+        m := m + INTERVAL '1 month';
+    END LOOP;
+END $$;
+INSERT INTO transactions SELECT id, order_id, user_id, wallet_id, tx_type, amount, related_user_id, actor_user_id, created_at, updated_at FROM transactions_unpartitioned;
+DROP TABLE transactions_unpartitioned;
+CREATE INDEX idx_transactions_order_id ON transactions(order_id);
+CREATE INDEX idx_transactions_actor_user_id ON transactions(actor_user_id, wallet_id);
+CREATE INDEX idx_transactions_related_user_id ON transactions(related_user_id);
+CREATE INDEX idx_transactions_user_created ON transactions(user_id, created_at, id);
+CREATE INDEX idx_transactions_wallet_created ON transactions(wallet_id, created_at, id);
+
+-- transactions moved out of the partitions older than the retention, read by the history when its range reaches them
+CREATE TABLE transactions_archive (
+    id INTEGER PRIMARY KEY,
+    order_id VARCHAR(64) NOT NULL DEFAULT '',
+    user_id INTEGER NOT NULL DEFAULT 0,
+    wallet_id INTEGER NOT NULL DEFAULT 0,
+    tx_type INTEGER NOT NULL DEFAULT 0,
+    amount DECIMAL(15, 8) NOT NULL DEFAULT 0.00000000,
+    related_user_id INTEGER NOT NULL DEFAULT 0,
+    actor_user_id INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL DEFAULT 0
+);
+COMMENT ON TABLE transactions_archive IS 'transactions older than the retention, same columns as transactions';
+CREATE INDEX idx_transactions_archive_order_id ON transactions_archive(order_id);
+CREATE INDEX idx_transactions_archive_user_created ON transactions_archive(user_id, created_at, id);
+CREATE INDEX idx_transactions_archive_wallet_created ON transactions_archive(wallet_id, created_at, id);
+
+-- how far a table was archived
+CREATE TABLE archive_watermarks (
+    table_name VARCHAR(64) PRIMARY KEY,
+    archived_before INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL DEFAULT 0
+);
+COMMENT ON COLUMN archive_watermarks.archived_before IS 'rows created before are in the archive table';
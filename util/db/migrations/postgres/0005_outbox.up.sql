@@ -0,0 +1,20 @@
+-- events of committed balance changes, written in the transaction of the change and published by the relay
+CREATE TABLE IF NOT EXISTS outbox (
+    id BIGSERIAL PRIMARY KEY,
+    event_id VARCHAR(64) NOT NULL DEFAULT '',
+    event_type VARCHAR(32) NOT NULL DEFAULT '',
+    order_id VARCHAR(64) NOT NULL DEFAULT '',
+    user_id INTEGER NOT NULL DEFAULT 0,
+    payload TEXT NOT NULL DEFAULT '',
+    status SMALLINT NOT NULL DEFAULT 0,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error VARCHAR(255) NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL DEFAULT 0,
+    CONSTRAINT uk_outbox_event_id UNIQUE (event_id)
+);
+COMMENT ON TABLE outbox IS 'events of committed deposits, withdrawals and transfers, published at least once in id order';
+COMMENT ON COLUMN outbox.payload IS 'the json event as published';
+COMMENT ON COLUMN outbox.status IS '0: pending, 1: sent';
+COMMENT ON COLUMN outbox.attempts IS 'failed publishes, the last error in last_error';
+CREATE INDEX IF NOT EXISTS idx_outbox_status ON outbox(status, id);
@@ -0,0 +1,37 @@
+-- webhook subscriptions of the users, and the deliveries of wallet events to them
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL DEFAULT 0,
+    url VARCHAR(2048) NOT NULL DEFAULT '',
+    event_types VARCHAR(255) NOT NULL DEFAULT '',
+    secret VARCHAR(128) NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL DEFAULT 0
+);
+COMMENT ON TABLE webhook_subscriptions IS 'urls the wallet events of a user are posted to';
+COMMENT ON COLUMN webhook_subscriptions.event_types IS 'comma separated event types, empty for all';
+COMMENT ON COLUMN webhook_subscriptions.secret IS 'hmac-sha256 key of the signatures';
+CREATE INDEX IF NOT EXISTS idx_webhook_subscriptions_user_id ON webhook_subscriptions(user_id);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    subscription_id BIGINT NOT NULL DEFAULT 0,
+    user_id INTEGER NOT NULL DEFAULT 0,
+    event_id VARCHAR(64) NOT NULL DEFAULT '',
+    event_type VARCHAR(32) NOT NULL DEFAULT '',
+    payload TEXT NOT NULL DEFAULT '',
+    status SMALLINT NOT NULL DEFAULT 0,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at INTEGER NOT NULL DEFAULT 0,
+    last_status_code INTEGER NOT NULL DEFAULT 0,
+    last_error VARCHAR(255) NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL DEFAULT 0,
+    CONSTRAINT uk_webhook_deliveries_event UNIQUE (subscription_id, event_id)
+);
+COMMENT ON TABLE webhook_deliveries IS 'one wallet event posted to one subscription, retried with backoff';
+COMMENT ON COLUMN webhook_deliveries.status IS '0: pending, 1: delivered, 2: dead';
+COMMENT ON COLUMN webhook_deliveries.next_attempt_at IS 'unix second of the next post of a pending delivery';
+COMMENT ON COLUMN webhook_deliveries.last_status_code IS 'http status of the last post, 0 when it got none';
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_user_id ON webhook_deliveries(user_id, id);
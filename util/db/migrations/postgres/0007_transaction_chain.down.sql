@@ -0,0 +1,7 @@
+ALTER TABLE wallets DROP COLUMN IF EXISTS tx_hash;
+DROP INDEX IF EXISTS idx_transactions_archive_wallet_chain;
+ALTER TABLE transactions_archive DROP COLUMN IF EXISTS hash;
+ALTER TABLE transactions_archive DROP COLUMN IF EXISTS prev_hash;
+DROP INDEX IF EXISTS idx_transactions_wallet_chain;
+ALTER TABLE transactions DROP COLUMN IF EXISTS hash;
+ALTER TABLE transactions DROP COLUMN IF EXISTS prev_hash;
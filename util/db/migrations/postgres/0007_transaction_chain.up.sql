@@ -0,0 +1,12 @@
+-- every transaction is chained to the one before it on its wallet: hash is the sha256 of prev_hash and its contents,
+-- the wallet keeps the hash of its last one. Transactions recorded before have no hash and stay out of the chains.
+ALTER TABLE transactions ADD COLUMN prev_hash VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE transactions ADD COLUMN hash VARCHAR(64) NOT NULL DEFAULT '';
+COMMENT ON COLUMN transactions.prev_hash IS 'hash of the transaction before on the wallet, empty on the first one';
+COMMENT ON COLUMN transactions.hash IS 'sha256 of prev_hash and the contents, hex';
+CREATE INDEX idx_transactions_wallet_chain ON transactions(wallet_id, id);
+ALTER TABLE transactions_archive ADD COLUMN prev_hash VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE transactions_archive ADD COLUMN hash VARCHAR(64) NOT NULL DEFAULT '';
+CREATE INDEX idx_transactions_archive_wallet_chain ON transactions_archive(wallet_id, id);
+ALTER TABLE wallets ADD COLUMN tx_hash VARCHAR(64) NOT NULL DEFAULT '';
+COMMENT ON COLUMN wallets.tx_hash IS 'hash of the last transaction of the wallet, the head of its chain';
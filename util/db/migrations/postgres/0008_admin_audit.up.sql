@@ -0,0 +1,32 @@
+-- what the admins and the operators did, each record written in the db transaction of its action
+CREATE TABLE IF NOT EXISTS admin_audit_logs (
+    id BIGSERIAL PRIMARY KEY,
+    actor VARCHAR(64) NOT NULL DEFAULT '',
+    action VARCHAR(64) NOT NULL DEFAULT '',
+    target_type VARCHAR(32) NOT NULL DEFAULT '',
+    target_id BIGINT NOT NULL DEFAULT 0,
+    before_state TEXT NOT NULL DEFAULT '',
+    after_state TEXT NOT NULL DEFAULT '',
+    reason VARCHAR(255) NOT NULL DEFAULT '',
+    log_id VARCHAR(64) NOT NULL DEFAULT '',
+    source_ip VARCHAR(64) NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL DEFAULT 0
+);
+COMMENT ON TABLE admin_audit_logs IS 'append-only trail of the non-customer actions';
+COMMENT ON COLUMN admin_audit_logs.actor IS 'name of the admin, or of the operator command';
+COMMENT ON COLUMN admin_audit_logs.target_id IS 'id of the target of target_type, e.g. wallets.id';
+COMMENT ON COLUMN admin_audit_logs.before_state IS 'json of the target before the action, empty when it did not exist';
+COMMENT ON COLUMN admin_audit_logs.after_state IS 'json of the target after the action';
+COMMENT ON COLUMN admin_audit_logs.source_ip IS 'client ip of the admin request, empty for a command';
+CREATE INDEX IF NOT EXISTS idx_admin_audit_logs_created_at ON admin_audit_logs(created_at, id);
+CREATE INDEX IF NOT EXISTS idx_admin_audit_logs_target ON admin_audit_logs(target_type, target_id);
+
+CREATE OR REPLACE FUNCTION admin_audit_logs_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'admin_audit_logs is append-only';
+END;
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER trg_admin_audit_logs_append_only BEFORE UPDATE OR DELETE ON admin_audit_logs
+    FOR EACH ROW EXECUTE FUNCTION admin_audit_logs_append_only();
+CREATE TRIGGER trg_admin_audit_logs_no_truncate BEFORE TRUNCATE ON admin_audit_logs
+    FOR EACH STATEMENT EXECUTE FUNCTION admin_audit_logs_append_only();
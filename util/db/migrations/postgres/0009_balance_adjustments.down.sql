@@ -0,0 +1,2 @@
+COMMENT ON COLUMN transactions.tx_type IS '0: undefine 1: deposit, 2: withdrawal, 3: transfer in, 4: transfer out, 5: interest, 6: pocket move in, 7: pocket move out';
+DROP TABLE IF EXISTS balance_adjustments;
@@ -0,0 +1,29 @@
+-- manual credits and debits of the admins, posted once another admin approved them
+CREATE TABLE IF NOT EXISTS balance_adjustments (
+    id SERIAL PRIMARY KEY,
+    order_id VARCHAR(64) NOT NULL DEFAULT '',
+    user_id INTEGER NOT NULL DEFAULT 0,
+    org_id INTEGER NOT NULL DEFAULT 0,
+    wallet_id INTEGER NOT NULL DEFAULT 0,
+    tx_type INTEGER NOT NULL DEFAULT 0,
+    amount DECIMAL(15, 8) NOT NULL DEFAULT 0.00000000,
+    reason_code VARCHAR(32) NOT NULL DEFAULT '',
+    reason VARCHAR(255) NOT NULL DEFAULT '',
+    status SMALLINT NOT NULL DEFAULT 0,
+    created_by VARCHAR(64) NOT NULL DEFAULT '',
+    reviewed_by VARCHAR(64) NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL DEFAULT 0,
+    CONSTRAINT uk_balance_adjustments_order_id UNIQUE (order_id),
+    CONSTRAINT ck_balance_adjustments_reviewer CHECK (reviewed_by = '' OR reviewed_by <> created_by)
+);
+COMMENT ON TABLE balance_adjustments IS 'manual credits and debits of the admins, posted once approved by another admin';
+COMMENT ON COLUMN balance_adjustments.order_id IS 'order id of the adjustment, the transaction uses it once posted';
+COMMENT ON COLUMN balance_adjustments.user_id IS 'owner of the wallet, 0 for the shared wallet of org_id';
+COMMENT ON COLUMN balance_adjustments.tx_type IS '8: adjustment credit, 9: adjustment debit';
+COMMENT ON COLUMN balance_adjustments.status IS '0: pending, 1: posted, 2: rejected';
+COMMENT ON COLUMN balance_adjustments.created_by IS 'admin who created the adjustment';
+COMMENT ON COLUMN balance_adjustments.reviewed_by IS 'admin who approved or rejected it, never the creator';
+CREATE INDEX IF NOT EXISTS idx_balance_adjustments_status ON balance_adjustments(status, id);
+
+COMMENT ON COLUMN transactions.tx_type IS '0: undefine 1: deposit, 2: withdrawal, 3: transfer in, 4: transfer out, 5: interest, 6: pocket move in, 7: pocket move out, 8: adjustment credit, 9: adjustment debit';
@@ -0,0 +1,3 @@
+COMMENT ON COLUMN transactions.tx_type IS '0: undefine 1: deposit, 2: withdrawal, 3: transfer in, 4: transfer out, 5: interest, 6: pocket move in, 7: pocket move out, 8: adjustment credit, 9: adjustment debit';
+COMMENT ON COLUMN shard_transfers.status IS '0: debited, the credit on the shard of to_user_id is pending, 1: credited';
+ALTER TABLE shard_transfers DROP COLUMN IF EXISTS attempts;
@@ -0,0 +1,5 @@
+-- a transfer between shards whose credit failed for good is refunded to the sender on their shard
+ALTER TABLE shard_transfers ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+COMMENT ON COLUMN shard_transfers.attempts IS 'failed credits, the transfer is refunded after the last one';
+COMMENT ON COLUMN shard_transfers.status IS '0: debited, the credit on the shard of to_user_id is pending, 1: credited, 2: refunded to from_user_id';
+COMMENT ON COLUMN transactions.tx_type IS '0: undefine 1: deposit, 2: withdrawal, 3: transfer in, 4: transfer out, 5: interest, 6: pocket move in, 7: pocket move out, 8: adjustment credit, 9: adjustment debit, 10: transfer refund';
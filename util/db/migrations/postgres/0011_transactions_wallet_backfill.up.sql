@@ -0,0 +1,5 @@
+-- transactions recorded before they had a wallet_id are of the main pocket of their user, which was their only wallet
+UPDATE transactions t SET wallet_id = w.id FROM wallets w
+    WHERE t.wallet_id = 0 AND w.user_id = t.user_id AND w.org_id = 0 AND w.name = 'main';
+UPDATE transactions_archive t SET wallet_id = w.id FROM wallets w
+    WHERE t.wallet_id = 0 AND w.user_id = t.user_id AND w.org_id = 0 AND w.name = 'main';
@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS trg_transactions_order_id_pending ON transactions;
+DROP FUNCTION IF EXISTS transactions_order_id_pending();
@@ -0,0 +1,13 @@
+-- the order_id of a transfer waiting for approvals is reserved: its transactions are recorded under it once
+-- approved, only the execution, in the db transaction of the last vote, may use it before
+CREATE OR REPLACE FUNCTION transactions_order_id_pending() RETURNS trigger AS $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM transfer_approvals a WHERE a.order_id = NEW.order_id AND a.status = 0
+        AND (SELECT COUNT(*) FROM approval_votes v WHERE v.approval_id = a.id) < a.required_approvals) THEN
+        RAISE EXCEPTION 'order_id is taken by a transfer waiting for approvals' USING ERRCODE = 'unique_violation';
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER trg_transactions_order_id_pending BEFORE INSERT ON transactions
+    FOR EACH ROW EXECUTE FUNCTION transactions_order_id_pending();
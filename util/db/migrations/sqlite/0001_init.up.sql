@@ -0,0 +1,108 @@
+-- baseline schema, the same tables as the postgres migrations.
+
+-- Wallets table
+CREATE TABLE IF NOT EXISTS wallets (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL DEFAULT 0,
+    org_id INTEGER NOT NULL DEFAULT 0,
+    name VARCHAR(32) NOT NULL DEFAULT 'main', -- pocket name, every user has a main pocket
+    balance DECIMAL(15, 8) NOT NULL DEFAULT 0.00000000, -- may be negative down to -credit_limit
+    credit_limit DECIMAL(15, 8) NOT NULL DEFAULT 0.00000000,
+    held DECIMAL(15, 8) NOT NULL DEFAULT 0.00000000, -- held by transfers pending approval
+    product VARCHAR(32) NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL DEFAULT 0,
+    CONSTRAINT chk_wallets_credit_limit CHECK (credit_limit >= 0),
+    CONSTRAINT chk_wallets_balance CHECK (balance >= -credit_limit),
+    CONSTRAINT chk_wallets_held CHECK (held >= 0 AND balance - held >= -credit_limit),
+    CONSTRAINT uk_wallets_owner_name UNIQUE (org_id, user_id, name)
+);
+CREATE INDEX IF NOT EXISTS idx_wallets_user_id ON wallets(user_id);
+CREATE INDEX IF NOT EXISTS idx_wallets_org_id ON wallets(org_id);
+
+-- transactions table
+CREATE TABLE IF NOT EXISTS transactions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    order_id VARCHAR(64) NOT NULL DEFAULT '',
+    user_id INTEGER NOT NULL DEFAULT 0,
+    wallet_id INTEGER NOT NULL DEFAULT 0,
+    tx_type INTEGER NOT NULL DEFAULT 0, -- see the postgres migrations
+    amount DECIMAL(15, 8) NOT NULL DEFAULT 0.00000000,
+    related_user_id INTEGER NOT NULL DEFAULT 0,
+    actor_user_id INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS idx_transactions_order_id ON transactions(order_id);
+CREATE INDEX IF NOT EXISTS idx_transactions_user_id ON transactions(user_id);
+CREATE INDEX IF NOT EXISTS idx_transactions_wallet_id ON transactions(wallet_id);
+CREATE INDEX IF NOT EXISTS idx_transactions_actor_user_id ON transactions(actor_user_id, wallet_id);
+CREATE INDEX IF NOT EXISTS idx_transactions_related_user_id ON transactions(related_user_id);
+
+-- interest accruals table
+CREATE TABLE IF NOT EXISTS interest_accruals (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    wallet_id INTEGER NOT NULL DEFAULT 0,
+    user_id INTEGER NOT NULL DEFAULT 0,
+    accrual_date INTEGER NOT NULL DEFAULT 0, -- yyyymmdd
+    balance DECIMAL(15, 8) NOT NULL DEFAULT 0.00000000,
+    rate DECIMAL(10, 8) NOT NULL DEFAULT 0.00000000,
+    amount DECIMAL(15, 8) NOT NULL DEFAULT 0.00000000,
+    payout_order_id VARCHAR(64) NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL DEFAULT 0,
+    CONSTRAINT uk_interest_accruals_wallet_date UNIQUE (wallet_id, accrual_date)
+);
+CREATE INDEX IF NOT EXISTS idx_interest_accruals_user_id ON interest_accruals(user_id, accrual_date);
+CREATE INDEX IF NOT EXISTS idx_interest_accruals_wallet_id ON interest_accruals(wallet_id, accrual_date);
+
+-- organizations table
+CREATE TABLE IF NOT EXISTS organizations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(64) NOT NULL DEFAULT '',
+    required_approvals INTEGER NOT NULL DEFAULT 1,
+    approval_expire_second INTEGER NOT NULL DEFAULT 86400,
+    created_at INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL DEFAULT 0
+);
+
+-- organization members table
+CREATE TABLE IF NOT EXISTS org_members (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    org_id INTEGER NOT NULL DEFAULT 0,
+    user_id INTEGER NOT NULL DEFAULT 0,
+    role VARCHAR(16) NOT NULL DEFAULT 'viewer',
+    spending_limit DECIMAL(15, 8) NOT NULL DEFAULT 0.00000000,
+    created_at INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL DEFAULT 0,
+    CONSTRAINT uk_org_members_org_user UNIQUE (org_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_org_members_user_id ON org_members(user_id);
+
+-- transfer approvals table
+CREATE TABLE IF NOT EXISTS transfer_approvals (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    order_id VARCHAR(64) NOT NULL DEFAULT '',
+    org_id INTEGER NOT NULL DEFAULT 0,
+    wallet_id INTEGER NOT NULL DEFAULT 0,
+    requester_user_id INTEGER NOT NULL DEFAULT 0,
+    to_user_id INTEGER NOT NULL DEFAULT 0,
+    amount DECIMAL(15, 8) NOT NULL DEFAULT 0.00000000,
+    required_approvals INTEGER NOT NULL DEFAULT 1,
+    status SMALLINT NOT NULL DEFAULT 0, -- 0: pending, 1: executed, 2: expired
+    expire_at INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL DEFAULT 0,
+    CONSTRAINT uk_transfer_approvals_order_id UNIQUE (order_id)
+);
+CREATE INDEX IF NOT EXISTS idx_transfer_approvals_org_status ON transfer_approvals(org_id, status);
+CREATE INDEX IF NOT EXISTS idx_transfer_approvals_status_expire ON transfer_approvals(status, expire_at);
+
+-- approval votes table, the audit trail of approvals
+CREATE TABLE IF NOT EXISTS approval_votes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    approval_id INTEGER NOT NULL DEFAULT 0,
+    approver_user_id INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL DEFAULT 0,
+    CONSTRAINT uk_approval_votes_approver UNIQUE (approval_id, approver_user_id)
+);
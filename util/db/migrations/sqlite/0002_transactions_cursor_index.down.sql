@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_transactions_wallet_created;
+DROP INDEX IF EXISTS idx_transactions_user_created;
@@ -0,0 +1,14 @@
+-- transfers between users on different shards, kept on the shard of the sender
+CREATE TABLE IF NOT EXISTS shard_transfers (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    order_id VARCHAR(64) NOT NULL DEFAULT '',
+    from_user_id INTEGER NOT NULL DEFAULT 0,
+    from_wallet_id INTEGER NOT NULL DEFAULT 0,
+    to_user_id INTEGER NOT NULL DEFAULT 0,
+    amount DECIMAL(15, 8) NOT NULL DEFAULT 0.00000000,
+    status SMALLINT NOT NULL DEFAULT 0, -- 0: debited, the credit is pending, 1: credited
+    created_at INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL DEFAULT 0,
+    CONSTRAINT uk_shard_transfers_order_id UNIQUE (order_id)
+);
+CREATE INDEX IF NOT EXISTS idx_shard_transfers_status ON shard_transfers(status, created_at);
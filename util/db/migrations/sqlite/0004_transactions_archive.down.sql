@@ -0,0 +1,4 @@
+-- the archived transactions go back to transactions
+INSERT INTO transactions SELECT id, order_id, user_id, wallet_id, tx_type, amount, related_user_id, actor_user_id, created_at, updated_at FROM transactions_archive;
+DROP TABLE IF EXISTS transactions_archive;
+DROP TABLE IF EXISTS archive_watermarks;
@@ -0,0 +1,24 @@
+-- transactions moved out of transactions once older than the retention, same columns.
+-- sqlite has no partitions, the archive job moves the rows.
+CREATE TABLE IF NOT EXISTS transactions_archive (
+    id INTEGER PRIMARY KEY,
+    order_id VARCHAR(64) NOT NULL DEFAULT '',
+    user_id INTEGER NOT NULL DEFAULT 0,
+    wallet_id INTEGER NOT NULL DEFAULT 0,
+    tx_type INTEGER NOT NULL DEFAULT 0,
+    amount DECIMAL(15, 8) NOT NULL DEFAULT 0.00000000,
+    related_user_id INTEGER NOT NULL DEFAULT 0,
+    actor_user_id INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS idx_transactions_archive_order_id ON transactions_archive(order_id);
+CREATE INDEX IF NOT EXISTS idx_transactions_archive_user_created ON transactions_archive(user_id, created_at, id);
+CREATE INDEX IF NOT EXISTS idx_transactions_archive_wallet_created ON transactions_archive(wallet_id, created_at, id);
+
+-- how far a table was archived, rows created before archived_before are in the archive table
+CREATE TABLE IF NOT EXISTS archive_watermarks (
+    table_name VARCHAR(64) PRIMARY KEY,
+    archived_before INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL DEFAULT 0
+);
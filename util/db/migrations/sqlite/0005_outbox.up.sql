@@ -0,0 +1,16 @@
+-- events of committed balance changes, written in the transaction of the change and published by the relay
+CREATE TABLE IF NOT EXISTS outbox (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    event_id VARCHAR(64) NOT NULL DEFAULT '',
+    event_type VARCHAR(32) NOT NULL DEFAULT '',
+    order_id VARCHAR(64) NOT NULL DEFAULT '',
+    user_id INTEGER NOT NULL DEFAULT 0,
+    payload TEXT NOT NULL DEFAULT '', -- the json event as published
+    status SMALLINT NOT NULL DEFAULT 0, -- 0: pending, 1: sent
+    attempts INTEGER NOT NULL DEFAULT 0, -- failed publishes, the last error in last_error
+    last_error VARCHAR(255) NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL DEFAULT 0,
+    CONSTRAINT uk_outbox_event_id UNIQUE (event_id)
+);
+CREATE INDEX IF NOT EXISTS idx_outbox_status ON outbox(status, id);
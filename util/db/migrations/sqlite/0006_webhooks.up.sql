@@ -0,0 +1,30 @@
+-- webhook subscriptions of the users, and the deliveries of wallet events to them
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL DEFAULT 0,
+    url VARCHAR(2048) NOT NULL DEFAULT '',
+    event_types VARCHAR(255) NOT NULL DEFAULT '', -- comma separated event types, empty for all
+    secret VARCHAR(128) NOT NULL DEFAULT '', -- hmac-sha256 key of the signatures
+    created_at INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_subscriptions_user_id ON webhook_subscriptions(user_id);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    subscription_id INTEGER NOT NULL DEFAULT 0,
+    user_id INTEGER NOT NULL DEFAULT 0,
+    event_id VARCHAR(64) NOT NULL DEFAULT '',
+    event_type VARCHAR(32) NOT NULL DEFAULT '',
+    payload TEXT NOT NULL DEFAULT '',
+    status SMALLINT NOT NULL DEFAULT 0, -- 0: pending, 1: delivered, 2: dead
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at INTEGER NOT NULL DEFAULT 0, -- unix second of the next post of a pending delivery
+    last_status_code INTEGER NOT NULL DEFAULT 0, -- http status of the last post, 0 when it got none
+    last_error VARCHAR(255) NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL DEFAULT 0,
+    CONSTRAINT uk_webhook_deliveries_event UNIQUE (subscription_id, event_id)
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_user_id ON webhook_deliveries(user_id, id);
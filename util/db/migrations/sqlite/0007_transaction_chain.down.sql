@@ -0,0 +1,7 @@
+ALTER TABLE wallets DROP COLUMN tx_hash;
+DROP INDEX IF EXISTS idx_transactions_archive_wallet_chain;
+ALTER TABLE transactions_archive DROP COLUMN hash;
+ALTER TABLE transactions_archive DROP COLUMN prev_hash;
+DROP INDEX IF EXISTS idx_transactions_wallet_chain;
+ALTER TABLE transactions DROP COLUMN hash;
+ALTER TABLE transactions DROP COLUMN prev_hash;
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS trg_admin_audit_logs_no_delete;
+DROP TRIGGER IF EXISTS trg_admin_audit_logs_no_update;
+DROP TABLE IF EXISTS admin_audit_logs;
@@ -0,0 +1,26 @@
+-- what the admins and the operators did, each record written in the db transaction of its action
+CREATE TABLE IF NOT EXISTS admin_audit_logs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    actor VARCHAR(64) NOT NULL DEFAULT '', -- name of the admin, or of the operator command
+    action VARCHAR(64) NOT NULL DEFAULT '',
+    target_type VARCHAR(32) NOT NULL DEFAULT '',
+    target_id INTEGER NOT NULL DEFAULT 0, -- id of the target of target_type, e.g. wallets.id
+    before_state TEXT NOT NULL DEFAULT '', -- json of the target before the action, empty when it did not exist
+    after_state TEXT NOT NULL DEFAULT '', -- json of the target after the action
+    reason VARCHAR(255) NOT NULL DEFAULT '',
+    log_id VARCHAR(64) NOT NULL DEFAULT '',
+    source_ip VARCHAR(64) NOT NULL DEFAULT '', -- client ip of the admin request, empty for a command
+    created_at INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS idx_admin_audit_logs_created_at ON admin_audit_logs(created_at, id);
+CREATE INDEX IF NOT EXISTS idx_admin_audit_logs_target ON admin_audit_logs(target_type, target_id);
+
+-- append-only
+CREATE TRIGGER IF NOT EXISTS trg_admin_audit_logs_no_update BEFORE UPDATE ON admin_audit_logs
+BEGIN
+    SELECT RAISE(ABORT, 'admin_audit_logs is append-only');
+END;
+CREATE TRIGGER IF NOT EXISTS trg_admin_audit_logs_no_delete BEFORE DELETE ON admin_audit_logs
+BEGIN
+    SELECT RAISE(ABORT, 'admin_audit_logs is append-only');
+END;
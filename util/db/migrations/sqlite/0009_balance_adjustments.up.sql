@@ -0,0 +1,20 @@
+-- manual credits and debits of the admins, posted once another admin approved them
+CREATE TABLE IF NOT EXISTS balance_adjustments (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    order_id VARCHAR(64) NOT NULL DEFAULT '', -- the transaction uses it once posted
+    user_id INTEGER NOT NULL DEFAULT 0, -- owner of the wallet, 0 for the shared wallet of org_id
+    org_id INTEGER NOT NULL DEFAULT 0,
+    wallet_id INTEGER NOT NULL DEFAULT 0,
+    tx_type INTEGER NOT NULL DEFAULT 0, -- 8: adjustment credit, 9: adjustment debit
+    amount DECIMAL(15, 8) NOT NULL DEFAULT 0.00000000,
+    reason_code VARCHAR(32) NOT NULL DEFAULT '',
+    reason VARCHAR(255) NOT NULL DEFAULT '',
+    status SMALLINT NOT NULL DEFAULT 0, -- 0: pending, 1: posted, 2: rejected
+    created_by VARCHAR(64) NOT NULL DEFAULT '', -- admin who created the adjustment
+    reviewed_by VARCHAR(64) NOT NULL DEFAULT '', -- admin who approved or rejected it, never the creator
+    created_at INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL DEFAULT 0,
+    CONSTRAINT uk_balance_adjustments_order_id UNIQUE (order_id),
+    CONSTRAINT ck_balance_adjustments_reviewer CHECK (reviewed_by = '' OR reviewed_by <> created_by)
+);
+CREATE INDEX IF NOT EXISTS idx_balance_adjustments_status ON balance_adjustments(status, id);
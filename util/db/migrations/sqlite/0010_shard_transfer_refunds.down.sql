@@ -0,0 +1 @@
+ALTER TABLE shard_transfers DROP COLUMN attempts;
@@ -0,0 +1,2 @@
+-- a transfer between shards whose credit failed for good is refunded to the sender on their shard, status 2
+ALTER TABLE shard_transfers ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0; -- failed credits, the transfer is refunded after the last one
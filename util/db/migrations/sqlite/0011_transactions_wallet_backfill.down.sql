@@ -0,0 +1 @@
+-- the backfilled wallet_ids are right, they are kept
@@ -0,0 +1,5 @@
+-- transactions recorded before they had a wallet_id are of the main pocket of their user, which was their only wallet
+UPDATE transactions SET wallet_id = (SELECT w.id FROM wallets w WHERE w.user_id = transactions.user_id AND w.org_id = 0 AND w.name = 'main')
+    WHERE wallet_id = 0 AND EXISTS (SELECT 1 FROM wallets w WHERE w.user_id = transactions.user_id AND w.org_id = 0 AND w.name = 'main');
+UPDATE transactions_archive SET wallet_id = (SELECT w.id FROM wallets w WHERE w.user_id = transactions_archive.user_id AND w.org_id = 0 AND w.name = 'main')
+    WHERE wallet_id = 0 AND EXISTS (SELECT 1 FROM wallets w WHERE w.user_id = transactions_archive.user_id AND w.org_id = 0 AND w.name = 'main');
@@ -0,0 +1 @@
+DROP TRIGGER IF EXISTS trg_transactions_order_id_pending;
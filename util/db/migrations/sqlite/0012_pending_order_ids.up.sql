@@ -0,0 +1,8 @@
+-- the order_id of a transfer waiting for approvals is reserved: its transactions are recorded under it once
+-- approved, only the execution, in the db transaction of the last vote, may use it before
+CREATE TRIGGER IF NOT EXISTS trg_transactions_order_id_pending BEFORE INSERT ON transactions
+WHEN EXISTS (SELECT 1 FROM transfer_approvals a WHERE a.order_id = NEW.order_id AND a.status = 0
+    AND (SELECT COUNT(*) FROM approval_votes v WHERE v.approval_id = a.id) < a.required_approvals)
+BEGIN
+    SELECT RAISE(ABORT, 'order_id is taken by a transfer waiting for approvals');
+END;
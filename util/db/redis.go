@@ -1,31 +1,110 @@
 package db
 
 import (
+	"context"
 	"errors"
+	"net"
+	"strings"
+	"time"
 
-	"github.com/go-redis/redis"
+	"github.com/go-redis/redis/v8"
 )
 
 type RedisConf struct {
-	Uri      string `yaml:"uri" json:"uri"`
+	Uri      string `yaml:"uri" json:"uri"` // host:port, or a redis:// or rediss:// url with the password and db
 	Password string `yaml:"password" json:"password"`
 	Db       int    `yaml:"db" json:"db"`
+	// tls to redis, rediss:// urls always use it. The CA file verifies a server cert not signed by
+	// a public CA, the client cert and key are presented when given
+	TLS         bool   `yaml:"tls" json:"tls"`
+	TLSCAFile   string `yaml:"tls_ca_file" json:"tls_ca_file"`
+	TLSCertFile string `yaml:"tls_cert_file" json:"tls_cert_file"`
+	TLSKeyFile  string `yaml:"tls_key_file" json:"tls_key_file"`
+	// pool and timeouts, the ones of the url or the go-redis defaults apply when 0
+	PoolSize       int `yaml:"pool_size" json:"pool_size"`
+	MinIdleConns   int `yaml:"min_idle_conns" json:"min_idle_conns"`
+	DialTimeoutMs  int `yaml:"dial_timeout_ms" json:"dial_timeout_ms"`
+	ReadTimeoutMs  int `yaml:"read_timeout_ms" json:"read_timeout_ms"`
+	WriteTimeoutMs int `yaml:"write_timeout_ms" json:"write_timeout_ms"`
+	// ping on start like the db, the defaults apply when 0
+	ConnectRetries        int `yaml:"connect_retries" json:"connect_retries"` // negative never retries
+	ConnectRetryBackoffMs int `yaml:"connect_retry_backoff_ms" json:"connect_retry_backoff_ms"`
+	// balances are cached for this long, 0 never caches them
+	BalanceCacheTTLMs int `yaml:"balance_cache_ttl_ms" json:"balance_cache_ttl_ms"`
 }
 
 var redisClient *redis.Client
+var balanceCacheTTL time.Duration
+
+// RedisOptions returns the go-redis options of the config
+func (conf *RedisConf) RedisOptions() (*redis.Options, error) {
+	opt := &redis.Options{Addr: conf.Uri, Password: conf.Password, DB: conf.Db}
+	if strings.HasPrefix(conf.Uri, "redis://") || strings.HasPrefix(conf.Uri, "rediss://") {
+		var err error
+		if opt, err = redis.ParseURL(conf.Uri); err != nil {
+			return nil, err
+		}
+		if conf.Password != "" {
+			opt.Password = conf.Password
+		}
+	}
+	if conf.TLS || opt.TLSConfig != nil {
+		serverName, _, err := net.SplitHostPort(opt.Addr)
+		if err != nil {
+			return nil, err
+		}
+		if opt.TLSConfig, err = tlsConfig(serverName, conf.TLSCAFile, conf.TLSCertFile, conf.TLSKeyFile); err != nil {
+			return nil, err
+		}
+	}
+	if conf.PoolSize > 0 {
+		opt.PoolSize = conf.PoolSize
+	}
+	if conf.MinIdleConns > 0 {
+		opt.MinIdleConns = conf.MinIdleConns
+	}
+	if conf.DialTimeoutMs > 0 {
+		opt.DialTimeout = time.Duration(conf.DialTimeoutMs) * time.Millisecond
+	}
+	if conf.ReadTimeoutMs > 0 {
+		opt.ReadTimeout = time.Duration(conf.ReadTimeoutMs) * time.Millisecond
+	}
+	if conf.WriteTimeoutMs > 0 {
+		opt.WriteTimeout = time.Duration(conf.WriteTimeoutMs) * time.Millisecond
+	}
+	return opt, nil
+}
 
 func InitRedis(conf *RedisConf) error {
 	if conf == nil {
 		return errors.New("redis config is nil")
 	}
-	redisClient = redis.NewClient(&redis.Options{
-		Addr:     conf.Uri,
-		Password: conf.Password,
-		DB:       conf.Db,
+	if conf.BalanceCacheTTLMs < 0 {
+		return errors.New("redis balance_cache_ttl_ms can not be negative")
+	}
+	balanceCacheTTL = time.Duration(conf.BalanceCacheTTLMs) * time.Millisecond
+	opt, err := conf.RedisOptions()
+	if err != nil {
+		return err
+	}
+	redisClient = redis.NewClient(opt)
+	retry := newConnectRetry(0, conf.ConnectRetries, conf.ConnectRetryBackoffMs)
+	err = retry.ping("redis", func(ctx context.Context) error {
+		return redisClient.Ping(ctx).Err()
 	})
-	return nil
+	if err != nil {
+		redisClient.Close()
+		redisClient = nil
+	}
+	return err
 }
 
+// GetRedisClient returns the client every redis user shares, the locks and the caches take it from here
 func GetRedisClient() *redis.Client {
 	return redisClient
 }
+
+// GetBalanceCacheTTL returns how long balances are cached, 0 when they are not
+func GetBalanceCacheTTL() time.Duration {
+	return balanceCacheTTL
+}
@@ -1,20 +1,33 @@
 package db
 
 import (
-	"github.com/go-redis/redis/v8"
+	"github.com/alicebob/miniredis/v2"
 )
 
-var redisClientMock *redis.Client
+var redisMock *miniredis.Miniredis
 
+// InitRedisMock starts an in-process redis and connects the redis client to it
 func InitRedisMock() error {
-	redisClientMock = redis.NewClient(&redis.Options{
-		Addr:     "localhost:6379",
-		Password: "123456",
-		DB:       0,
-	})
-	return nil
+	var err error
+	redisMock, err = miniredis.Run()
+	if err != nil {
+		return err
+	}
+	err = InitRedis(&RedisConf{Uri: redisMock.Addr(), ConnectRetries: -1})
+	if err != nil {
+		redisMock.Close()
+	}
+	return err
 }
 
-func GetRedisClientMock() *redis.Client {
-	return redisClientMock
+// GetRedisMock returns the in-process redis, e.g. to fast forward its keys to expiry
+func GetRedisMock() *miniredis.Miniredis {
+	return redisMock
+}
+
+// CloseRedisMock closes the redis client and stops the in-process redis
+func CloseRedisMock() error {
+	err := redisClient.Close()
+	redisMock.Close()
+	return err
 }
@@ -4,6 +4,7 @@ import (
 	"simplewallet/util/db"
 	"testing"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/goleak"
 )
@@ -13,8 +14,52 @@ func TestInitRedis(t *testing.T) {
 	t.Run("init redis sussess", func(t *testing.T) {
 		err := db.InitRedisMock()
 		assert.Nil(t, err)
-		redisCli := db.GetRedisClientMock()
+		redisCli := db.GetRedisClient()
 		assert.NotNil(t, redisCli)
-		redisCli.Close()
+		db.CloseRedisMock()
+	})
+
+	t.Run("case2: redis options success-[url, tls, pool and timeouts]", func(t *testing.T) {
+		opt, err := (&db.RedisConf{Uri: "127.0.0.1:6379", Password: "123456", Db: 1, PoolSize: 20, MinIdleConns: 2, DialTimeoutMs: 500, ReadTimeoutMs: 200}).RedisOptions()
+		assert.Nil(t, err)
+		assert.Equal(t, "127.0.0.1:6379", opt.Addr)
+		assert.Equal(t, 1, opt.DB)
+		assert.Equal(t, 20, opt.PoolSize)
+		assert.Equal(t, 2, opt.MinIdleConns)
+		assert.Equal(t, int64(500), opt.DialTimeout.Milliseconds())
+		assert.Nil(t, opt.TLSConfig)
+
+		opt, err = (&db.RedisConf{Uri: "rediss://:secret@cache.internal:6380/2"}).RedisOptions()
+		assert.Nil(t, err)
+		assert.Equal(t, "cache.internal:6380", opt.Addr)
+		assert.Equal(t, "secret", opt.Password)
+		assert.Equal(t, 2, opt.DB)
+		assert.NotNil(t, opt.TLSConfig)
+		assert.Equal(t, "cache.internal", opt.TLSConfig.ServerName)
+
+		_, err = (&db.RedisConf{Uri: "127.0.0.1:6379", TLS: true, TLSCAFile: "/nonexistent/ca.pem"}).RedisOptions()
+		assert.NotNil(t, err)
+	})
+
+	t.Run("case3: init redis success-[pinged on start]", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		mr.RequireAuth("123456")
+		err := db.InitRedis(&db.RedisConf{Uri: mr.Addr(), Password: "123456"})
+		assert.Nil(t, err)
+		db.GetRedisClient().Close()
+	})
+
+	t.Run("case4: init redis fail-[wrong password]", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		mr.RequireAuth("123456")
+		err := db.InitRedis(&db.RedisConf{Uri: mr.Addr(), Password: "654321", ConnectRetries: -1})
+		assert.ErrorContains(t, err, "fail to connect redis")
+		assert.Nil(t, db.GetRedisClient())
+	})
+
+	t.Run("case5: init redis fail-[unreachable after the retries]", func(t *testing.T) {
+		err := db.InitRedis(&db.RedisConf{Uri: "127.0.0.1:1", DialTimeoutMs: 200, ConnectRetries: 1, ConnectRetryBackoffMs: 10})
+		assert.ErrorContains(t, err, "fail to connect redis")
+		assert.Nil(t, db.GetRedisClient())
 	})
 }
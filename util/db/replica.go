@@ -0,0 +1,189 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// replicaLagSql is how far the replay of a postgres standby is behind, 0 once it replayed all it received,
+// so an idle primary doesn't make its replicas look stale
+const replicaLagSql = "SELECT CASE WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0 " +
+	"ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0) END"
+
+// ReplicaPool routes reads to the replicas of the primary. A replica serves reads while its lag is
+// within the max staleness, a user who wrote recently reads from the primary to see their own writes.
+type ReplicaPool struct {
+	replicas       []*replica
+	maxStaleness   time.Duration
+	readYourWrites time.Duration
+	checkInterval  time.Duration // how long a measured lag is trusted
+	next           atomic.Uint32
+
+	// the read-your-writes windows, in redis when set so that every instance sees them,
+	// else in the map of this process
+	redisCli redis.Cmdable
+	mu       sync.Mutex
+	writes   map[int64]time.Time // user id -> end of the read-your-writes window
+	pruneAt  int
+}
+
+type replica struct {
+	db        *sql.DB
+	mu        sync.Mutex
+	lag       time.Duration
+	err       error
+	checkedAt time.Time // zero until the first check ended
+	checking  bool      // a check is running, the others read the last measured lag
+}
+
+// NewReplicaPool returns the pool on the replica db clients. A max staleness of 0 never checks the lag,
+// a read-your-writes window of 0 never pins a user to the primary.
+func NewReplicaPool(dbs []*sql.DB, maxStaleness time.Duration, readYourWrites time.Duration) *ReplicaPool {
+	p := &ReplicaPool{maxStaleness: maxStaleness, readYourWrites: readYourWrites, checkInterval: maxStaleness / 2, writes: make(map[int64]time.Time), pruneAt: 1024}
+	if p.checkInterval <= 0 || p.checkInterval > time.Second {
+		p.checkInterval = time.Second
+	}
+	for _, db := range dbs {
+		p.replicas = append(p.replicas, &replica{db: db})
+	}
+	return p
+}
+
+// WithRedis keeps the read-your-writes windows in redis: a user who wrote through one instance reads
+// from the primary on every instance. Without it a window is only seen by the instance of the write.
+func (p *ReplicaPool) WithRedis(cli redis.Cmdable) *ReplicaPool {
+	if p != nil {
+		p.redisCli = cli
+	}
+	return p
+}
+
+func readYourWritesKey(userID int64) string {
+	return "replica:ryw:{" + strconv.FormatInt(userID, 10) + "}"
+}
+
+// MarkWrite starts the read-your-writes window of the users, their reads go to the primary until it ends.
+func (p *ReplicaPool) MarkWrite(ctx context.Context, userIDs ...int64) {
+	if p == nil || p.readYourWrites <= 0 {
+		return
+	}
+	if p.redisCli != nil {
+		pipe := p.redisCli.Pipeline()
+		for _, userID := range userIDs {
+			pipe.Set(ctx, readYourWritesKey(userID), 1, p.readYourWrites)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			log.Println("fail to mark the writes of the users:" + err.Error())
+		}
+		return
+	}
+	until := time.Now().Add(p.readYourWrites)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, userID := range userIDs {
+		p.writes[userID] = until
+	}
+	if len(p.writes) >= p.pruneAt {
+		now := time.Now()
+		for userID, end := range p.writes {
+			if now.After(end) {
+				delete(p.writes, userID)
+			}
+		}
+		p.pruneAt = 2*len(p.writes) + 1024
+	}
+}
+
+// Pick returns a replica for the reads of the user, nil when they must go to the primary:
+// the user is in their read-your-writes window, or no replica is within the max staleness.
+func (p *ReplicaPool) Pick(ctx context.Context, userID int64) *sql.DB {
+	if p == nil || len(p.replicas) == 0 || p.pinned(ctx, userID) {
+		return nil
+	}
+	start := int(p.next.Add(1))
+	for i := range p.replicas {
+		r := p.replicas[(start+i)%len(p.replicas)]
+		if p.fresh(r) {
+			return r.db
+		}
+	}
+	return nil
+}
+
+// pinned reports whether the user is in their read-your-writes window, a failing redis pins every user
+func (p *ReplicaPool) pinned(ctx context.Context, userID int64) bool {
+	if p.readYourWrites <= 0 {
+		return false
+	}
+	if p.redisCli != nil {
+		n, err := p.redisCli.Exists(ctx, readYourWritesKey(userID)).Result()
+		if err != nil {
+			log.Println("fail to check the writes of the user:" + err.Error())
+			return true
+		}
+		return n > 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	until, ok := p.writes[userID]
+	return ok && time.Now().Before(until)
+}
+
+// fresh measures the lag of the replica at most once per check interval. The check is not bound to the
+// request that triggers it: a cancelled request would leave the replica failed for the whole interval.
+// The query runs outside the lock, the reads meanwhile go by the last measured lag, or to the primary
+// when the replica was never measured.
+func (p *ReplicaPool) fresh(r *replica) bool {
+	if p.maxStaleness <= 0 {
+		return true
+	}
+	r.mu.Lock()
+	due := !r.checking && time.Since(r.checkedAt) >= p.checkInterval
+	if due {
+		r.checking = true
+	}
+	lag, err, checked := r.lag, r.err, !r.checkedAt.IsZero()
+	r.mu.Unlock()
+
+	if due {
+		lag, err = replicaLag(context.Background(), r.db, p.checkInterval)
+		if err != nil {
+			log.Println("fail to check replica lag:" + err.Error())
+		}
+		r.mu.Lock()
+		r.lag, r.err, r.checkedAt, r.checking = lag, err, time.Now(), false
+		r.mu.Unlock()
+		checked = true
+	}
+	return checked && err == nil && lag <= p.maxStaleness
+}
+
+func replicaLag(ctx context.Context, db *sql.DB, timeout time.Duration) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var seconds float64
+	if err := db.QueryRowContext(ctx, replicaLagSql).Scan(&seconds); err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// Close closes the replica db clients
+func (p *ReplicaPool) Close() error {
+	if p == nil {
+		return nil
+	}
+	var errs []error
+	for _, r := range p.replicas {
+		errs = append(errs, r.db.Close())
+	}
+	return errors.Join(errs...)
+}
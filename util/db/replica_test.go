@@ -0,0 +1,142 @@
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"simplewallet/util/db"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
+)
+
+func TestReplicaPool(t *testing.T) {
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+	lagSql := regexp.QuoteMeta("SELECT CASE WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0")
+	newReplica := func(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+		replica, mock, err := sqlmock.New()
+		assert.Nil(t, err)
+		return replica, mock
+	}
+
+	t.Run("case1: pick success-[replicas within max staleness, round robin]", func(t *testing.T) {
+		replica1, mock1 := newReplica(t)
+		replica2, mock2 := newReplica(t)
+		pool := db.NewReplicaPool([]*sql.DB{replica1, replica2}, time.Second, 0)
+		defer pool.Close()
+		// the lag is measured once per check interval
+		mock1.ExpectQuery(lagSql).WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(0.2))
+		mock2.ExpectQuery(lagSql).WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(0))
+
+		picked := map[*sql.DB]int{}
+		for i := 0; i < 4; i++ {
+			picked[pool.Pick(context.Background(), 101)]++
+		}
+		assert.Equal(t, map[*sql.DB]int{replica1: 2, replica2: 2}, picked)
+		assert.Nil(t, mock1.ExpectationsWereMet())
+		assert.Nil(t, mock2.ExpectationsWereMet())
+	})
+
+	t.Run("case2: pick success-[stale or failing replicas skipped, primary when none]", func(t *testing.T) {
+		replica1, mock1 := newReplica(t)
+		replica2, mock2 := newReplica(t)
+		pool := db.NewReplicaPool([]*sql.DB{replica1, replica2}, time.Second, 0)
+		defer pool.Close()
+		mock1.ExpectQuery(lagSql).WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(5))
+		mock2.ExpectQuery(lagSql).WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(0.5))
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, replica2, pool.Pick(context.Background(), 101))
+		}
+
+		replica3, mock3 := newReplica(t)
+		pool3 := db.NewReplicaPool([]*sql.DB{replica3}, time.Second, 0)
+		defer pool3.Close()
+		mock3.ExpectQuery(lagSql).WillReturnError(errors.New("connection refused"))
+		assert.Nil(t, pool3.Pick(context.Background(), 101))
+		assert.Nil(t, pool3.Pick(context.Background(), 101))
+		assert.Nil(t, mock1.ExpectationsWereMet())
+		assert.Nil(t, mock2.ExpectationsWereMet())
+		assert.Nil(t, mock3.ExpectationsWereMet())
+	})
+
+	t.Run("case3: pick success-[primary within the read-your-writes window]", func(t *testing.T) {
+		replica, mock := newReplica(t)
+		pool := db.NewReplicaPool([]*sql.DB{replica}, 0, 50*time.Millisecond)
+		defer pool.Close()
+
+		pool.MarkWrite(context.Background(), 101, 102)
+		assert.Nil(t, pool.Pick(context.Background(), 101))
+		assert.Nil(t, pool.Pick(context.Background(), 102))
+		assert.Equal(t, replica, pool.Pick(context.Background(), 103))
+		time.Sleep(60 * time.Millisecond)
+		assert.Equal(t, replica, pool.Pick(context.Background(), 101))
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("case4: pick success-[no pool reads from the primary]", func(t *testing.T) {
+		var pool *db.ReplicaPool
+		pool.MarkWrite(context.Background(), 101)
+		assert.Nil(t, pool.Pick(context.Background(), 101))
+		assert.Nil(t, pool.Close())
+	})
+
+	t.Run("case5: pick success-[a write through another instance pins the user, in redis]", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		redisCli := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		defer redisCli.Close()
+		replicaA, mockA := newReplica(t)
+		replicaB, mockB := newReplica(t)
+		poolA := db.NewReplicaPool([]*sql.DB{replicaA}, 0, time.Second).WithRedis(redisCli)
+		defer poolA.Close()
+		poolB := db.NewReplicaPool([]*sql.DB{replicaB}, 0, time.Second).WithRedis(redisCli)
+		defer poolB.Close()
+
+		poolA.MarkWrite(context.Background(), 101)
+		assert.Nil(t, poolB.Pick(context.Background(), 101))
+		assert.Equal(t, replicaB, poolB.Pick(context.Background(), 102))
+		mr.FastForward(time.Second)
+		assert.Equal(t, replicaB, poolB.Pick(context.Background(), 101))
+
+		// redis down, the primary is the safe side
+		mr.Close()
+		assert.Nil(t, poolB.Pick(context.Background(), 102))
+		assert.Nil(t, mockA.ExpectationsWereMet())
+		assert.Nil(t, mockB.ExpectationsWereMet())
+	})
+
+	t.Run("case6: pick success-[the lag checked even when the request that triggers it is cancelled]", func(t *testing.T) {
+		replica, mock := newReplica(t)
+		pool := db.NewReplicaPool([]*sql.DB{replica}, time.Second, 0)
+		defer pool.Close()
+		mock.ExpectQuery(lagSql).WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(0))
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		assert.Equal(t, replica, pool.Pick(ctx, 101))
+		assert.Equal(t, replica, pool.Pick(context.Background(), 101))
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("case7: pick success-[a running lag check doesn't block the other reads]", func(t *testing.T) {
+		replica, mock := newReplica(t)
+		pool := db.NewReplicaPool([]*sql.DB{replica}, time.Second, 0)
+		defer pool.Close()
+		mock.ExpectQuery(lagSql).WillDelayFor(300 * time.Millisecond).WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(0))
+
+		done := make(chan *sql.DB)
+		go func() { done <- pool.Pick(context.Background(), 101) }()
+		time.Sleep(50 * time.Millisecond)
+		// never measured, the read goes to the primary without waiting for the check
+		start := time.Now()
+		assert.Nil(t, pool.Pick(context.Background(), 102))
+		assert.Less(t, time.Since(start), 100*time.Millisecond)
+		assert.Equal(t, replica, <-done)
+		assert.Equal(t, replica, pool.Pick(context.Background(), 102))
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,16 @@
+package db
+
+// ShardBuckets is the number of buckets of user ids, user_id modulo ShardBuckets. The buckets are split in
+// n ranges of equal size, one per shard. A bucket is never split, but the ranges depend on n: adding a shard
+// moves most buckets to another shard, whose rows have to be copied over with the writes stopped, see README.
+const ShardBuckets = 4096
+
+// ShardOf returns the index of the shard of the user among n shards. User 0, the owner of the shared
+// wallets of the organizations, is on shard 0 with the organizations. n must not change once users are written.
+func ShardOf(userID int64, n int) int {
+	if n <= 1 || userID <= 0 {
+		return 0
+	}
+	bucket := userID % ShardBuckets
+	return int(bucket * int64(n) / ShardBuckets)
+}
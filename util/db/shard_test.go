@@ -0,0 +1,31 @@
+package db_test
+
+import (
+	"simplewallet/util/db"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
+)
+
+func TestShardOf(t *testing.T) {
+	defer goleak.VerifyNone(t) // check for goroutine leaks
+
+	t.Run("case1: shard of success-[one shard or no user on shard 0]", func(t *testing.T) {
+		assert.Equal(t, 0, db.ShardOf(101, 0))
+		assert.Equal(t, 0, db.ShardOf(101, 1))
+		assert.Equal(t, 0, db.ShardOf(0, 4))
+		assert.Equal(t, 0, db.ShardOf(-1, 4))
+	})
+
+	t.Run("case2: shard of success-[users spread evenly over the shards]", func(t *testing.T) {
+		count := make([]int, 4)
+		for userID := int64(1); userID <= db.ShardBuckets*10; userID++ {
+			shard := db.ShardOf(userID, 4)
+			assert.True(t, shard >= 0 && shard < 4)
+			count[shard]++
+		}
+		assert.Equal(t, []int{db.ShardBuckets * 10 / 4, db.ShardBuckets * 10 / 4, db.ShardBuckets * 10 / 4, db.ShardBuckets * 10 / 4}, count)
+		assert.Equal(t, db.ShardOf(7, 3), db.ShardOf(7+db.ShardBuckets, 3))
+	})
+}
@@ -0,0 +1,27 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	_ "modernc.org/sqlite"
+)
+
+const (
+	DriverPostgres = "postgres"
+	DriverSqlite   = "sqlite"
+)
+
+// OpenSqlite opens the sqlite database file at path, the migrations create its tables.
+// Transactions begin immediate so concurrent writers wait on busy_timeout instead of failing
+// on lock upgrade, WAL lets reads go on while a transaction writes.
+func OpenSqlite(path string) (*sql.DB, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate", path)
+	cli, err := sql.Open(DriverSqlite, dsn)
+	if err != nil {
+		log.Println("fail to open sqlite:" + err.Error())
+		return nil, err
+	}
+	return cli, nil
+}
@@ -1,4 +1,4 @@
-CREATE DATABASE  wallet;
+-- the schema.sql databases were created from before the migrations, kept to test that 0001_init adopts them
 -- Wallets table
 CREATE TABLE wallets (
     id SERIAL PRIMARY KEY,
@@ -31,4 +31,4 @@ COMMENT ON COLUMN transactions.amount IS 'transaction amount';
 COMMENT ON COLUMN transactions.related_user_id IS 'related user id';
 CREATE INDEX idx_transactions_order_id ON transactions(order_id);
 CREATE INDEX idx_transactions_user_id ON transactions(user_id);
-CREATE INDEX idx_transactions_related_user_id ON transactions(related_user_id);
\ No newline at end of file
+CREATE INDEX idx_transactions_related_user_id ON transactions(related_user_id);
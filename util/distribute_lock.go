@@ -1,9 +1,14 @@
 package util
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"log"
-	"simplewallet/util/db"
+	"time"
+
+	"github.com/go-redis/redis/v8"
 )
 
 type DistributedLock interface {
@@ -16,36 +21,51 @@ type RedisDistributedLock struct {
 	Key      string
 	Expire   int
 	isLocked bool
+	ctx      context.Context
+	cli      redis.Cmdable
+	token    string // the value of the key while locked, so only the holder deletes it
 }
 
-func NewDistributedLock(logId string, key string, expire int) DistributedLock {
-	return &RedisDistributedLock{LogId: logId, Key: key, Expire: expire, isLocked: false}
+// unlockScript deletes the key only while it still holds the token, a lock that expired and was
+// taken by someone else stays theirs
+var unlockScript = redis.NewScript(`if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`)
+
+// NewDistributedLock returns a lock on the key of the redis client, held for expire seconds at most
+func NewDistributedLock(ctx context.Context, cli redis.Cmdable, logId string, key string, expire int) DistributedLock {
+	return &RedisDistributedLock{LogId: logId, Key: key, Expire: expire, isLocked: false, ctx: ctx, cli: cli}
 }
 
 func (r *RedisDistributedLock) Lock() error {
-	cli := db.GetRedisClient()
-	resp, err := cli.Do("SET", r.Key, "", "NX", "EX", r.Expire).Result()
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return err
+	}
+	ok, err := r.cli.SetNX(r.ctx, r.Key, hex.EncodeToString(token), time.Duration(r.Expire)*time.Second).Result()
 	if err != nil {
 		return err
 	}
-	log.Printf("%s|get lock success:%s\n", r.LogId, resp)
-	if resp != "OK" {
+	if !ok {
 		return errors.New("lock key fail")
 	}
+	r.token = hex.EncodeToString(token)
 	r.isLocked = true
+	log.Printf("%s|get lock success\n", r.LogId)
 	return nil
 }
 
 func (r *RedisDistributedLock) UnLock() error {
-	cli := db.GetRedisClient()
 	if !r.isLocked {
 		return nil
 	}
-	resp, err := cli.Do("DEL", r.Key).Result()
+	resp, err := unlockScript.Run(r.ctx, r.cli, []string{r.Key}, r.token).Int64()
 	if err != nil {
 		return err
 	}
 	r.isLocked = false
-	log.Printf("%s|unlock key success:%s\n", r.LogId, resp)
+	if resp == 0 {
+		log.Printf("%s|lock expired before unlock:%s\n", r.LogId, r.Key)
+		return nil
+	}
+	log.Printf("%s|unlock key success:%d\n", r.LogId, resp)
 	return nil
 }
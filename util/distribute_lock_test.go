@@ -6,6 +6,7 @@ import (
 	"simplewallet/util"
 	"simplewallet/util/db"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/goleak"
@@ -19,7 +20,7 @@ func ConnectRedis() {
 	}
 }
 func DisconnectRedis() {
-	if err := db.GetRedisClientMock().Close(); err != nil {
+	if err := db.CloseRedisMock(); err != nil {
 		log.Println("fail to disconnect redis", err)
 	}
 }
@@ -32,13 +33,14 @@ func TestLock(t *testing.T) {
 		logID := util.Uniqid()
 		ctx := context.Background()
 		key := "testLock:" + logID
-		locker := util.NewDistributedLockMock(logID, key, 10, ctx)
+		locker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, key, 10)
 		err := locker.Lock()
 		assert.Nil(t, err)
 
-		rsp, err := db.GetRedisClientMock().Do(ctx, "EXISTS", key).Result()
+		rsp, err := db.GetRedisClient().Do(ctx, "EXISTS", key).Result()
 		assert.Nil(t, err)
 		assert.Equal(t, rsp, int64(1))
+		assert.Equal(t, 10*time.Second, db.GetRedisMock().TTL(key))
 
 		locker.UnLock()
 	})
@@ -47,11 +49,46 @@ func TestLock(t *testing.T) {
 		logID := util.Uniqid()
 		ctx := context.Background()
 		key := "testLock:" + logID
-		locker := util.NewDistributedLockMock(logID, key, -1, ctx)
+		locker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, key, -1)
 		err := locker.Lock()
 		assert.NotNil(t, err)
 	})
 
+	t.Run("testLock fail-[locked by another]", func(t *testing.T) {
+		logID := util.Uniqid()
+		ctx := context.Background()
+		key := "testLock:" + logID
+		locker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, key, 10)
+		err := locker.Lock()
+		assert.Nil(t, err)
+		defer locker.UnLock()
+
+		err = util.NewDistributedLock(ctx, db.GetRedisClient(), logID, key, 10).Lock()
+		assert.EqualError(t, err, "lock key fail")
+	})
+
+	t.Run("testLock success-[previous lock expired]", func(t *testing.T) {
+		logID := util.Uniqid()
+		ctx := context.Background()
+		key := "testLock:" + logID
+		err := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, key, 10).Lock()
+		assert.Nil(t, err)
+
+		db.GetRedisMock().FastForward(11 * time.Second)
+		locker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, key, 10)
+		err = locker.Lock()
+		assert.Nil(t, err)
+		locker.UnLock()
+	})
+
+	t.Run("testLock fail-[redis down]", func(t *testing.T) {
+		logID := util.Uniqid()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		locker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, "testLock:"+logID, 10)
+		err := locker.Lock()
+		assert.ErrorIs(t, err, context.Canceled)
+	})
 }
 
 func TestUnlock(t *testing.T) {
@@ -63,16 +100,16 @@ func TestUnlock(t *testing.T) {
 		logID := util.Uniqid()
 		ctx := context.Background()
 		key := "testUnLock:" + logID
-		locker := util.NewDistributedLockMock(logID, key, 10, ctx)
+		locker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, key, 10)
 		err := locker.Lock()
 		assert.Nil(t, err)
 
-		rsp, err := db.GetRedisClientMock().Do(ctx, "EXISTS", key).Result()
+		rsp, err := db.GetRedisClient().Do(ctx, "EXISTS", key).Result()
 		assert.Nil(t, err)
 		assert.Equal(t, rsp, int64(1))
 
 		locker.UnLock()
-		rsp, err = db.GetRedisClientMock().Do(ctx, "EXISTS", key).Result()
+		rsp, err = db.GetRedisClient().Do(ctx, "EXISTS", key).Result()
 		assert.Nil(t, err)
 		assert.Equal(t, rsp, int64(0))
 	})
@@ -81,7 +118,7 @@ func TestUnlock(t *testing.T) {
 		logID := util.Uniqid()
 		ctx := context.Background()
 		key := "testUnLock:" + logID
-		locker := util.NewDistributedLockMock(logID, key, 10, ctx)
+		locker := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, key, 10)
 		err := locker.Lock()
 		assert.Nil(t, err)
 		err = locker.UnLock()
@@ -90,4 +127,23 @@ func TestUnlock(t *testing.T) {
 		assert.Nil(t, err)
 	})
 
+	t.Run("testUnLock success-[expired lock of another holder kept]", func(t *testing.T) {
+		logID := util.Uniqid()
+		ctx := context.Background()
+		key := "testUnLock:" + logID
+		expired := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, key, 10)
+		err := expired.Lock()
+		assert.Nil(t, err)
+		db.GetRedisMock().FastForward(11 * time.Second)
+		holder := util.NewDistributedLock(ctx, db.GetRedisClient(), logID, key, 10)
+		err = holder.Lock()
+		assert.Nil(t, err)
+
+		err = expired.UnLock()
+		assert.Nil(t, err)
+		assert.True(t, db.GetRedisMock().Exists(key), "the new holder keeps the lock")
+		err = holder.UnLock()
+		assert.Nil(t, err)
+		assert.False(t, db.GetRedisMock().Exists(key))
+	})
 }
@@ -13,6 +13,17 @@ const (
 	ErrCodeQueryDBFail         int32 = 1008
 	ErrCodeInternalErr         int32 = 1009
 	ErrCodeTransactionNotExist int32 = 1010
+	ErrCodePermissionDenied    int32 = 1011
+	ErrCodeSpendingLimit       int32 = 1012
+	ErrCodeApprovalPending     int32 = 1013
+	ErrCodeApprovalNotExist    int32 = 1014
+	ErrCodeApprovalExpired     int32 = 1015
+	ErrCodeApprovalRepeat      int32 = 1016
+	ErrCodeDbTimeout           int32 = 1017
+	ErrCodeTransferPending     int32 = 1018
+	ErrCodeWebhookNotExist     int32 = 1019
+	ErrCodeAdjustmentNotExist  int32 = 1020
+	ErrCodeTransferRefunded    int32 = 1021
 )
 
 var (
@@ -29,5 +40,16 @@ var (
 		ErrCodeQueryDBFail:         "failed to query db",
 		ErrCodeInternalErr:         "internal error",
 		ErrCodeTransactionNotExist: "transaction not exist",
+		ErrCodePermissionDenied:    "permission denied",
+		ErrCodeSpendingLimit:       "spending limit exceeded",
+		ErrCodeApprovalPending:     "transfer pending approval",
+		ErrCodeApprovalNotExist:    "pending approval not exist",
+		ErrCodeApprovalExpired:     "approval expired",
+		ErrCodeApprovalRepeat:      "already approved",
+		ErrCodeDbTimeout:           "db timeout",
+		ErrCodeTransferPending:     "transfer debited, credit pending",
+		ErrCodeWebhookNotExist:     "webhook delivery not exist",
+		ErrCodeAdjustmentNotExist:  "pending adjustment not exist",
+		ErrCodeTransferRefunded:    "transfer refunded, the recipient could not be credited",
 	}
 )